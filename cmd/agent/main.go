@@ -12,12 +12,13 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	"gopkg.in/yaml.v3"
 
-	"cymbytes.com/cymconductor/internal/agent/client"
+	"cymbytes.com/cymconductor/internal/agent/actions"
 	"cymbytes.com/cymconductor/internal/agent/executor"
+	"cymbytes.com/cymconductor/pkg/client"
+	"cymbytes.com/cymconductor/pkg/protocol"
 )
 
 // Version information (set at build time)
@@ -41,14 +42,19 @@ type OrchestratorConfig struct {
 	URL            string        `yaml:"url"`
 	ConnectTimeout time.Duration `yaml:"connect_timeout"`
 	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// Transport is auto, websocket, long_poll or poll
+	Transport string `yaml:"transport"`
 }
 
 // AgentConfig holds agent identity settings.
 type AgentConfig struct {
 	ID        string            `yaml:"id"`
 	LabHostID string            `yaml:"lab_host_id"`
+	LabID     string            `yaml:"lab_id"`
 	Hostname  string            `yaml:"hostname"`
 	Labels    map[string]string `yaml:"labels"`
+	// DataDir holds the agent state file with the generated agent ID
+	DataDir string `yaml:"data_dir"`
 }
 
 // HeartbeatConfig holds heartbeat settings.
@@ -62,6 +68,7 @@ type ActionsConfig struct {
 	Browsing        BrowsingConfig        `yaml:"browsing"`
 	FileActivity    FileActivityConfig    `yaml:"file_activity"`
 	ProcessActivity ProcessActivityConfig `yaml:"process_activity"`
+	Watermark       WatermarkConfig       `yaml:"watermark"`
 }
 
 // BrowsingConfig holds browser automation settings.
@@ -80,6 +87,12 @@ type ProcessActivityConfig struct {
 	AllowedProcesses []string `yaml:"allowed_processes"`
 }
 
+// WatermarkConfig holds artifact watermarking settings. When enabled, the
+// job and scenario IDs are embedded in generated emails, files and processes.
+type WatermarkConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
 // LoggingConfig holds logging settings.
 type LoggingConfig struct {
 	Level  string `yaml:"level"`
@@ -96,10 +109,12 @@ func DefaultConfig() Config {
 			URL:            "http://10.0.0.254:8081",
 			ConnectTimeout: 10 * time.Second,
 			RequestTimeout: 30 * time.Second,
+			Transport:      "auto",
 		},
 		Agent: AgentConfig{
 			ID:        "",
 			LabHostID: "",
+			LabID:     "",
 			Hostname:  "",
 			Labels: map[string]string{
 				"role": "workstation",
@@ -139,6 +154,7 @@ func DefaultConfig() Config {
 			},
 		}
 		cfg.Logging.Path = `C:\ProgramData\CymBytes\logs\agent.log`
+		cfg.Agent.DataDir = `C:\ProgramData\CymBytes\agent`
 	} else {
 		cfg.Actions = ActionsConfig{
 			Browsing: BrowsingConfig{
@@ -160,8 +176,8 @@ func DefaultConfig() Config {
 			},
 		}
 		cfg.Logging.Path = "/var/log/cymbytes/agent.log"
+		cfg.Agent.DataDir = "/var/lib/cymbytes/agent"
 	}
-
 	return cfg
 }
 
@@ -197,6 +213,10 @@ func main() {
 
 	// Initialize logger
 	logger := initLogger(cfg.Logging)
+
+	// Reuse the agent ID saved by a previous run
+	resolveIdentity(&cfg, logger)
+
 	logger.Info().
 		Str("version", Version).
 		Str("agent_id", cfg.Agent.ID).
@@ -210,9 +230,10 @@ func main() {
 	// Initialize orchestrator client
 	apiClient := client.New(client.Config{
 		BaseURL:        cfg.Orchestrator.URL,
+		Timeout:        cfg.Orchestrator.RequestTimeout,
 		ConnectTimeout: cfg.Orchestrator.ConnectTimeout,
-		RequestTimeout: cfg.Orchestrator.RequestTimeout,
-	}, logger)
+		UserAgent:      "cymbytes-agent/" + Version,
+	})
 
 	// Initialize executor
 	exec := executor.New(executor.Config{
@@ -260,6 +281,10 @@ type Agent struct {
 	client   *client.Client
 	executor *executor.Executor
 	logger   zerolog.Logger
+
+	// Negotiated at registration
+	transports  []string
+	longPollMax time.Duration
 }
 
 // Run starts the agent main loop.
@@ -269,32 +294,52 @@ func (a *Agent) Run(ctx context.Context) {
 		a.logger.Fatal().Err(err).Msg("Failed to register with orchestrator")
 	}
 
-	// Start heartbeat/job polling loop
-	ticker := time.NewTicker(a.config.Heartbeat.Interval)
-	defer ticker.Stop()
+	for ctx.Err() == nil {
+		transport := a.selectTransport()
+		a.logger.Info().Str("transport", transport).Msg("Receiving jobs")
+
+		switch transport {
+		case protocol.TransportWebSocket:
+			err := a.runChannel(ctx)
+			if err == nil || ctx.Err() != nil {
+				continue
+			}
+
+			// Fall back to HTTP for a while, then try the channel again
+			a.logger.Warn().Err(err).Dur("retry_in", channelRetryInterval).Msg("Agent channel unavailable, falling back to HTTP")
+			fallbackCtx, cancel := context.WithTimeout(ctx, channelRetryInterval)
+			a.runHTTP(fallbackCtx, a.offers(protocol.TransportLongPoll))
+			cancel()
 
-	for {
-		select {
-		case <-ctx.Done():
-			a.logger.Info().Msg("Agent loop stopping")
-			return
-		case <-ticker.C:
-			a.poll(ctx)
+		case protocol.TransportLongPoll:
+			a.runHTTP(ctx, true)
+
+		default:
+			a.runHTTP(ctx, false)
 		}
 	}
+
+	a.logger.Info().Msg("Agent loop stopping")
 }
 
 // register registers the agent with the orchestrator.
 func (a *Agent) register(ctx context.Context) error {
 	a.logger.Info().Msg("Registering with orchestrator")
 
-	resp, err := a.client.Register(ctx, client.RegisterRequest{
-		AgentID:   a.config.Agent.ID,
-		LabHostID: a.config.Agent.LabHostID,
-		Hostname:  a.config.Agent.Hostname,
-		IPAddress: getLocalIP(),
-		Labels:    a.config.Agent.Labels,
-		Version:   Version,
+	caps := a.executor.Capabilities()
+	for action, reason := range caps.Unavailable {
+		a.logger.Warn().Str("action", action).Str("reason", reason).Msg("Action unavailable on this agent")
+	}
+
+	resp, err := a.client.Register(ctx, protocol.RegisterAgentRequest{
+		AgentID:      a.config.Agent.ID,
+		LabHostID:    a.config.Agent.LabHostID,
+		LabID:        a.config.Agent.LabID,
+		Hostname:     a.config.Agent.Hostname,
+		IPAddress:    getLocalIP(),
+		Labels:       a.config.Agent.Labels,
+		Version:      Version,
+		Capabilities: caps,
 	})
 	if err != nil {
 		return fmt.Errorf("registration failed: %w", err)
@@ -310,13 +355,17 @@ func (a *Agent) register(ctx context.Context) error {
 		a.config.Heartbeat.Interval = time.Duration(resp.HeartbeatIntervalMs) * time.Millisecond
 	}
 
+	// Orchestrators that predate transport negotiation only support polling
+	a.transports = resp.Transports
+	a.longPollMax = time.Duration(resp.LongPollMaxMs) * time.Millisecond
+
 	return nil
 }
 
 // poll sends heartbeat and fetches/executes jobs.
 func (a *Agent) poll(ctx context.Context) {
 	// Send heartbeat
-	_, err := a.client.Heartbeat(ctx, a.config.Agent.ID, client.HeartbeatRequest{
+	_, err := a.client.Heartbeat(ctx, a.config.Agent.ID, protocol.HeartbeatRequest{
 		Status: "online",
 	})
 	if err != nil {
@@ -339,20 +388,40 @@ func (a *Agent) poll(ctx context.Context) {
 
 	// Execute each job
 	for _, job := range jobs {
-		a.executeJob(ctx, job)
+		a.executeJob(ctx, job, a.reportResult)
 	}
 }
 
+// resultReporter delivers a job result to the orchestrator.
+type resultReporter func(ctx context.Context, jobID string, req protocol.JobResultRequest) error
+
+// reportResult delivers a job result over REST.
+func (a *Agent) reportResult(ctx context.Context, jobID string, req protocol.JobResultRequest) error {
+	_, err := a.client.ReportResult(ctx, a.config.Agent.ID, jobID, req)
+	return err
+}
+
 // executeJob executes a single job and reports the result.
-func (a *Agent) executeJob(ctx context.Context, job client.JobAssignment) {
+func (a *Agent) executeJob(ctx context.Context, job protocol.JobAssignment, report resultReporter) {
 	startTime := time.Now()
 	a.logger.Info().
 		Str("job_id", job.JobID).
 		Str("action", job.ActionType).
 		Msg("Executing job")
 
-	// Execute the action
-	result, err := a.executor.Execute(ctx, job.ActionType, job.Parameters)
+	// Execute the action, as the assigned user if the job asks for one
+	var runAs *executor.RunAsConfig
+	if job.RunAs != nil {
+		runAs = &executor.RunAsConfig{User: job.RunAs.User, LogonType: job.RunAs.LogonType}
+	}
+	if a.config.Actions.Watermark.Enabled {
+		ctx = actions.WithWatermark(ctx, actions.Watermark{
+			JobID:      job.JobID,
+			ScenarioID: job.ScenarioID,
+			StepID:     job.StepID,
+		})
+	}
+	result, err := a.executor.ExecuteAs(ctx, job.ActionType, job.Parameters, runAs)
 
 	completedAt := time.Now()
 
@@ -363,11 +432,11 @@ func (a *Agent) executeJob(ctx context.Context, job client.JobAssignment) {
 			Str("job_id", job.JobID).
 			Msg("Job execution failed")
 
-		_, reportErr := a.client.ReportResult(ctx, a.config.Agent.ID, job.JobID, client.JobResultRequest{
+		reportErr := report(ctx, job.JobID, protocol.JobResultRequest{
 			Status:      "failed",
 			StartedAt:   startTime,
 			CompletedAt: completedAt,
-			Error: &client.JobError{
+			Error: &protocol.JobError{
 				Code:      "EXECUTION_ERROR",
 				Message:   err.Error(),
 				Retryable: true,
@@ -384,7 +453,7 @@ func (a *Agent) executeJob(ctx context.Context, job client.JobAssignment) {
 		Dur("duration", completedAt.Sub(startTime)).
 		Msg("Job completed successfully")
 
-	_, reportErr := a.client.ReportResult(ctx, a.config.Agent.ID, job.JobID, client.JobResultRequest{
+	reportErr := report(ctx, job.JobID, protocol.JobResultRequest{
 		Status:      "completed",
 		StartedAt:   startTime,
 		CompletedAt: completedAt,
@@ -415,20 +484,24 @@ func applyEnvOverrides(cfg *Config) {
 	if v := os.Getenv("AGENT_ID"); v != "" {
 		cfg.Agent.ID = v
 	}
+	if v := os.Getenv("AGENT_DATA_DIR"); v != "" {
+		cfg.Agent.DataDir = v
+	}
 	if v := os.Getenv("LAB_HOST_ID"); v != "" {
 		cfg.Agent.LabHostID = v
 	}
+	if v := os.Getenv("LAB_ID"); v != "" {
+		cfg.Agent.LabID = v
+	}
+	if v := os.Getenv("ORCHESTRATOR_TRANSPORT"); v != "" {
+		cfg.Orchestrator.Transport = v
+	}
 	if v := os.Getenv("LOG_LEVEL"); v != "" {
 		cfg.Logging.Level = v
 	}
 }
 
 func autoDetect(cfg *Config) {
-	// Generate agent ID if not set
-	if cfg.Agent.ID == "" {
-		cfg.Agent.ID = uuid.New().String()
-	}
-
 	// Get hostname if not set
 	if cfg.Agent.Hostname == "" {
 		hostname, _ := os.Hostname()
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// stateFileName is the agent state file in the data directory.
+const stateFileName = "agent-state.json"
+
+// agentState is the identity the agent keeps across restarts.
+type agentState struct {
+	AgentID string `json:"agent_id"`
+
+	// Host the state was written on. A state file whose fingerprint does not
+	// match the running host was cloned with a VM template.
+	MachineID string `json:"machine_id,omitempty"`
+	Hostname  string `json:"hostname"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// resolveIdentity sets the agent ID. A configured ID is used as is; otherwise
+// the ID saved in the data directory is reused, unless the state file belongs
+// to another machine, in which case a new ID replaces it. If the state cannot
+// be saved the agent still starts, with an ID that lasts until it restarts.
+func resolveIdentity(cfg *Config, logger zerolog.Logger) {
+	if cfg.Agent.ID != "" {
+		return
+	}
+
+	path := filepath.Join(cfg.Agent.DataDir, stateFileName)
+	machineID := readMachineID()
+
+	state, err := loadState(path)
+	switch {
+	case err != nil:
+		logger.Warn().Err(err).Str("path", path).Msg("Ignoring unreadable agent state")
+	case state != nil:
+		if reason := state.mismatch(machineID, cfg.Agent.Hostname); reason != "" {
+			logger.Warn().
+				Str("old_agent_id", state.AgentID).
+				Str("reason", reason).
+				Msg("Agent state was cloned from another machine, generating a new agent ID")
+		} else {
+			cfg.Agent.ID = state.AgentID
+			logger.Info().Str("path", path).Msg("Loaded agent identity")
+			return
+		}
+	}
+
+	cfg.Agent.ID = uuid.New().String()
+	state = &agentState{
+		AgentID:   cfg.Agent.ID,
+		MachineID: machineID,
+		Hostname:  cfg.Agent.Hostname,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := saveState(path, state); err != nil {
+		logger.Warn().Err(err).Str("path", path).
+			Msg("Failed to save agent identity, a new agent ID will be generated on restart")
+		return
+	}
+	logger.Info().Str("path", path).Msg("Saved new agent identity")
+}
+
+// mismatch returns why the state does not belong to this machine, or "".
+func (s *agentState) mismatch(machineID, hostname string) string {
+	if s.MachineID != "" && machineID != "" && s.MachineID != machineID {
+		return "machine ID changed"
+	}
+	if !strings.EqualFold(s.Hostname, hostname) {
+		return fmt.Sprintf("hostname changed from %s to %s", s.Hostname, hostname)
+	}
+	return ""
+}
+
+// loadState reads the state file. It returns nil if there is none.
+func loadState(path string) (*agentState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var state agentState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if _, err := uuid.Parse(state.AgentID); err != nil {
+		return nil, fmt.Errorf("invalid agent ID in state file: %q", state.AgentID)
+	}
+	return &state, nil
+}
+
+// saveState writes the state file, replacing it atomically.
+func saveState(path string, state *agentState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// readMachineID returns the operating system's machine identifier, which
+// template preparation (sysprep, systemd-firstboot) regenerates on each
+// clone. It returns "" if the host has none.
+func readMachineID() string {
+	switch runtime.GOOS {
+	case "windows":
+		out, err := exec.Command("reg", "query", `HKLM\SOFTWARE\Microsoft\Cryptography`, "/v", "MachineGuid").Output()
+		if err != nil {
+			return ""
+		}
+		// Output line: "    MachineGuid    REG_SZ    <guid>"
+		for _, line := range strings.Split(string(out), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 3 && fields[0] == "MachineGuid" {
+				return strings.ToLower(fields[2])
+			}
+		}
+	default:
+		for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+			if data, err := os.ReadFile(path); err == nil {
+				if id := strings.TrimSpace(string(data)); id != "" {
+					return id
+				}
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+func TestAgentState_Mismatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		state     agentState
+		machineID string
+		hostname  string
+		want      string
+	}{
+		{
+			name:      "same machine",
+			state:     agentState{MachineID: "m-1", Hostname: "WS-01"},
+			machineID: "m-1",
+			hostname:  "WS-01",
+		},
+		{
+			name:      "hostname case differs",
+			state:     agentState{MachineID: "m-1", Hostname: "WS-01"},
+			machineID: "m-1",
+			hostname:  "ws-01",
+		},
+		{
+			name:      "cloned machine ID",
+			state:     agentState{MachineID: "m-1", Hostname: "WS-01"},
+			machineID: "m-2",
+			hostname:  "WS-01",
+			want:      "machine ID changed",
+		},
+		{
+			name:      "renamed host",
+			state:     agentState{MachineID: "m-1", Hostname: "WS-01"},
+			machineID: "m-1",
+			hostname:  "WS-02",
+			want:      "hostname changed from WS-01 to WS-02",
+		},
+		{
+			name:      "no machine ID saved",
+			state:     agentState{Hostname: "WS-01"},
+			machineID: "m-1",
+			hostname:  "WS-01",
+		},
+		{
+			name:     "host has no machine ID",
+			state:    agentState{MachineID: "m-1", Hostname: "WS-01"},
+			hostname: "WS-01",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.mismatch(tt.machineID, tt.hostname); got != tt.want {
+				t.Errorf("mismatch() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadState(t *testing.T) {
+	agentID := uuid.New().String()
+
+	tests := []struct {
+		name    string
+		content string // empty means no state file
+		wantID  string
+		wantNil bool
+		wantErr string
+	}{
+		{
+			name:    "no state file",
+			wantNil: true,
+		},
+		{
+			name:    "valid state",
+			content: `{"agent_id":"` + agentID + `","machine_id":"m-1","hostname":"WS-01"}`,
+			wantID:  agentID,
+		},
+		{
+			name:    "corrupt JSON",
+			content: `{"agent_id":`,
+			wantErr: "failed to parse state file",
+		},
+		{
+			name:    "invalid agent ID",
+			content: `{"agent_id":"not-a-uuid"}`,
+			wantErr: "invalid agent ID in state file",
+		},
+		{
+			name:    "missing agent ID",
+			content: `{}`,
+			wantErr: "invalid agent ID in state file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), stateFileName)
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			state, err := loadState(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadState() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadState() error = %v", err)
+			}
+			if tt.wantNil {
+				if state != nil {
+					t.Errorf("Expected no state, got %+v", state)
+				}
+				return
+			}
+			if state == nil || state.AgentID != tt.wantID {
+				t.Errorf("loadState() = %+v, want agent ID %s", state, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestSaveState_RoundTrip(t *testing.T) {
+	// The data directory does not exist yet on first run
+	path := filepath.Join(t.TempDir(), "agent", stateFileName)
+	state := &agentState{
+		AgentID:   uuid.New().String(),
+		MachineID: "m-1",
+		Hostname:  "WS-01",
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	if err := saveState(path, state); err != nil {
+		t.Fatalf("saveState() error = %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Expected the temporary file to be renamed")
+	}
+
+	loaded, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loadState() error = %v", err)
+	}
+	if *loaded != *state {
+		t.Errorf("loadState() = %+v, want %+v", loaded, state)
+	}
+
+	// Saving again replaces the state
+	state.AgentID = uuid.New().String()
+	if err := saveState(path, state); err != nil {
+		t.Fatalf("saveState() error = %v", err)
+	}
+	if loaded, _ := loadState(path); loaded.AgentID != state.AgentID {
+		t.Errorf("Expected the state to be replaced, got %s", loaded.AgentID)
+	}
+}
+
+func TestResolveIdentity(t *testing.T) {
+	const hostname = "WS-01"
+	savedID := uuid.New().String()
+	machineID := readMachineID()
+
+	tests := []struct {
+		name       string
+		configured string
+		state      *agentState // saved before resolving
+		content    string      // raw state file content, if state is nil
+		wantSaved  bool        // the saved ID is reused
+		wantNew    bool        // a new ID is generated and saved
+		needsMID   bool
+	}{
+		{
+			name:    "first run",
+			wantNew: true,
+		},
+		{
+			name:      "reuse on restart",
+			state:     &agentState{AgentID: savedID, MachineID: machineID, Hostname: hostname},
+			wantSaved: true,
+		},
+		{
+			name:     "cloned machine ID",
+			state:    &agentState{AgentID: savedID, MachineID: "cloned-" + machineID, Hostname: hostname},
+			wantNew:  true,
+			needsMID: true,
+		},
+		{
+			name:    "cloned hostname",
+			state:   &agentState{AgentID: savedID, MachineID: machineID, Hostname: "TEMPLATE"},
+			wantNew: true,
+		},
+		{
+			name:    "corrupt state file",
+			content: "{not json",
+			wantNew: true,
+		},
+		{
+			name:       "configured ID",
+			configured: "agent-configured",
+			state:      &agentState{AgentID: savedID, MachineID: machineID, Hostname: hostname},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.needsMID && machineID == "" {
+				t.Skip("host has no machine ID")
+			}
+
+			cfg := &Config{}
+			cfg.Agent.ID = tt.configured
+			cfg.Agent.DataDir = t.TempDir()
+			cfg.Agent.Hostname = hostname
+			path := filepath.Join(cfg.Agent.DataDir, stateFileName)
+
+			switch {
+			case tt.state != nil:
+				if err := saveState(path, tt.state); err != nil {
+					t.Fatal(err)
+				}
+			case tt.content != "":
+				if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resolveIdentity(cfg, zerolog.Nop())
+
+			switch {
+			case tt.configured != "":
+				if cfg.Agent.ID != tt.configured {
+					t.Errorf("Expected the configured ID to be kept, got %s", cfg.Agent.ID)
+				}
+				if saved, _ := loadState(path); saved.AgentID != savedID {
+					t.Error("Expected the state file to be left alone")
+				}
+			case tt.wantSaved:
+				if cfg.Agent.ID != savedID {
+					t.Errorf("Expected the saved ID %s to be reused, got %s", savedID, cfg.Agent.ID)
+				}
+			case tt.wantNew:
+				if cfg.Agent.ID == savedID {
+					t.Fatal("Expected a new agent ID")
+				}
+				if _, err := uuid.Parse(cfg.Agent.ID); err != nil {
+					t.Fatalf("Expected a UUID agent ID, got %q", cfg.Agent.ID)
+				}
+				saved, err := loadState(path)
+				if err != nil || saved == nil {
+					t.Fatalf("Expected the new identity to be saved, got %v", err)
+				}
+				if saved.AgentID != cfg.Agent.ID || saved.MachineID != machineID || saved.Hostname != hostname {
+					t.Errorf("Unexpected saved state: %+v", saved)
+				}
+
+				// The new identity is reused on the next start
+				restarted := &Config{}
+				restarted.Agent.DataDir = cfg.Agent.DataDir
+				restarted.Agent.Hostname = hostname
+				resolveIdentity(restarted, zerolog.Nop())
+				if restarted.Agent.ID != cfg.Agent.ID {
+					t.Errorf("Expected %s after restart, got %s", cfg.Agent.ID, restarted.Agent.ID)
+				}
+			}
+		})
+	}
+}
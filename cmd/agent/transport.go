@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// channelRetryInterval is how long the agent stays on HTTP after the
+// WebSocket channel fails before trying it again.
+const channelRetryInterval = time.Minute
+
+// selectTransport picks the job delivery transport: the configured one if the
+// orchestrator offers it, otherwise the best one offered.
+func (a *Agent) selectTransport() string {
+	configured := strings.ToLower(a.config.Orchestrator.Transport)
+
+	if configured != "" && configured != "auto" {
+		if a.offers(configured) {
+			return configured
+		}
+		a.logger.Warn().
+			Str("transport", configured).
+			Strs("offered", a.transports).
+			Msg("Configured transport not offered by orchestrator, negotiating")
+	}
+
+	for _, t := range []string{protocol.TransportWebSocket, protocol.TransportLongPoll} {
+		if a.offers(t) {
+			return t
+		}
+	}
+	return protocol.TransportPoll
+}
+
+// offers reports whether the orchestrator advertised the transport.
+func (a *Agent) offers(transport string) bool {
+	if transport == protocol.TransportPoll {
+		return true
+	}
+	for _, t := range a.transports {
+		if t == transport {
+			return true
+		}
+	}
+	return false
+}
+
+// runHTTP receives jobs over REST until ctx is done. With longPoll, jobs/next
+// blocks until a job is due and heartbeats are sent on their own ticker;
+// otherwise each tick sends a heartbeat and polls once.
+func (a *Agent) runHTTP(ctx context.Context, longPoll bool) {
+	ticker := time.NewTicker(a.config.Heartbeat.Interval)
+	defer ticker.Stop()
+
+	if !longPoll {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.poll(ctx)
+			}
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.heartbeat(ctx)
+			}
+		}
+	}()
+
+	a.heartbeat(ctx)
+
+	for ctx.Err() == nil {
+		jobs, err := a.client.WaitJobs(ctx, a.config.Agent.ID, a.config.Heartbeat.MaxJobsPerPoll, a.longPollWait())
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			a.logger.Error().Err(err).Msg("Failed to get jobs")
+
+			// Back off so a failing orchestrator is not hammered
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(a.config.Heartbeat.Interval):
+			}
+			continue
+		}
+
+		for _, job := range jobs {
+			a.executeJob(ctx, job, a.reportResult)
+		}
+	}
+}
+
+// longPollWait returns the long-poll wait, kept below the request timeout.
+func (a *Agent) longPollWait() time.Duration {
+	wait := a.longPollMax
+	if limit := a.config.Orchestrator.RequestTimeout - 5*time.Second; wait <= 0 || wait > limit {
+		wait = limit
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+// heartbeat sends a single REST heartbeat.
+func (a *Agent) heartbeat(ctx context.Context) {
+	_, err := a.client.Heartbeat(ctx, a.config.Agent.ID, protocol.HeartbeatRequest{
+		Status: "online",
+	})
+	if err != nil && ctx.Err() == nil {
+		a.logger.Error().Err(err).Msg("Heartbeat failed")
+	}
+}
+
+// runChannel receives jobs over the WebSocket channel until ctx is done (nil)
+// or the channel fails (error). Jobs run one batch at a time; the agent polls
+// for the next batch once it is idle.
+func (a *Agent) runChannel(ctx context.Context) error {
+	ch, err := a.client.OpenChannel(ctx, a.config.Agent.ID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Closing the connection unblocks Receive on shutdown
+	go func() {
+		<-ctx.Done()
+		ch.Close()
+	}()
+
+	a.logger.Info().Msg("Agent channel connected")
+
+	var busy atomic.Bool
+	batches := make(chan []protocol.JobAssignment, 1)
+
+	// Results go over the channel, falling back to REST if it fails
+	report := func(ctx context.Context, jobID string, req protocol.JobResultRequest) error {
+		if err := ch.Send(protocol.MessageJobResult, protocol.JobResultMessage{JobID: jobID, Result: req}); err != nil {
+			a.logger.Warn().Err(err).Str("job_id", jobID).Msg("Channel send failed, reporting result over HTTP")
+			return a.reportResult(ctx, jobID, req)
+		}
+		return nil
+	}
+
+	poll := func() {
+		if busy.Load() {
+			return
+		}
+		if err := ch.Send(protocol.MessagePoll, protocol.PollMessage{Max: a.config.Heartbeat.MaxJobsPerPoll}); err != nil {
+			a.logger.Debug().Err(err).Msg("Failed to send poll")
+		}
+	}
+
+	heartbeat := func() {
+		if err := ch.Send(protocol.MessageHeartbeat, protocol.HeartbeatRequest{Status: "online"}); err != nil {
+			a.logger.Debug().Err(err).Msg("Failed to send heartbeat")
+		}
+	}
+
+	// Job worker
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case jobs := <-batches:
+				for _, job := range jobs {
+					a.executeJob(ctx, job, report)
+				}
+				busy.Store(false)
+				poll()
+			}
+		}
+	}()
+
+	// Heartbeats also re-send the poll; the orchestrator ignores duplicates,
+	// so a poll lost to an error is recovered on the next tick.
+	go func() {
+		ticker := time.NewTicker(a.config.Heartbeat.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				heartbeat()
+				poll()
+			}
+		}
+	}()
+
+	heartbeat()
+	poll()
+
+	for {
+		msg, err := ch.Receive(3 * a.config.Heartbeat.Interval)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("channel receive failed: %w", err)
+		}
+
+		switch msg.Type {
+		case protocol.MessageJobs:
+			var resp protocol.GetJobsResponse
+			if err := json.Unmarshal(msg.Payload, &resp); err != nil {
+				a.logger.Error().Err(err).Msg("Failed to decode jobs")
+				continue
+			}
+			if len(resp.Jobs) == 0 {
+				continue
+			}
+			a.logger.Debug().Int("count", len(resp.Jobs)).Msg("Received jobs")
+			busy.Store(true)
+			batches <- resp.Jobs
+
+		case protocol.MessageCommand:
+			var cmd protocol.AgentCommand
+			if err := json.Unmarshal(msg.Payload, &cmd); err != nil {
+				a.logger.Error().Err(err).Msg("Failed to decode command")
+				continue
+			}
+			ack := protocol.CommandAckMessage{CommandID: cmd.ID, Type: cmd.Type, Success: true}
+			if err := a.handleCommand(ctx, cmd); err != nil {
+				ack.Success = false
+				ack.Error = err.Error()
+			}
+			if cmd.ID != "" {
+				if err := ch.Send(protocol.MessageCommandAck, ack); err != nil {
+					a.logger.Debug().Err(err).Msg("Failed to acknowledge command")
+				}
+			}
+
+		case protocol.MessageError:
+			var errResp protocol.ErrorResponse
+			_ = json.Unmarshal(msg.Payload, &errResp)
+			a.logger.Warn().Str("error", errResp.Error).Str("message", errResp.Message).Msg("Orchestrator reported an error")
+
+		case protocol.MessageHeartbeatAck, protocol.MessageJobResultAck:
+			// Nothing to do
+
+		default:
+			a.logger.Debug().Str("type", msg.Type).Msg("Ignoring unknown channel message")
+		}
+	}
+}
+
+// handleCommand executes a command pushed by the orchestrator.
+func (a *Agent) handleCommand(ctx context.Context, cmd protocol.AgentCommand) error {
+	switch cmd.Type {
+	case protocol.CommandReregister:
+		a.logger.Info().Msg("Orchestrator requested re-registration")
+		if err := a.register(ctx); err != nil {
+			a.logger.Error().Err(err).Msg("Re-registration failed")
+			return err
+		}
+		return nil
+	default:
+		a.logger.Warn().Str("command", cmd.Type).Msg("Ignoring unknown command")
+		return fmt.Errorf("unknown command: %s", cmd.Type)
+	}
+}
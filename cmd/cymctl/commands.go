@@ -0,0 +1,792 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"cymbytes.com/cymconductor/pkg/client"
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// ============================================================
+// agents
+// ============================================================
+
+var agentCommands = map[string]command{
+	"list":     {"[-l <selector>] [-status <status>]", runAgentsList},
+	"describe": {"<agent-id>", runAgentsDescribe},
+	"delete":   {"<agent-id>", runAgentsDelete},
+}
+
+func runAgentsList(e *env, fs *flag.FlagSet, args []string) error {
+	selector := fs.String("l", "", "Label selector, e.g. role=workstation,os!=linux,!gpu")
+	status := fs.String("status", "", "Only agents with this status (online, offline)")
+	fs.Parse(args)
+
+	sel, err := parseSelector(*selector)
+	if err != nil {
+		return err
+	}
+
+	agents, err := e.client.ListAgents(e.ctx)
+	if err != nil {
+		return err
+	}
+
+	matched := make([]protocol.AgentInfo, 0, len(agents))
+	rows := make([][]string, 0, len(agents))
+	for _, a := range agents {
+		if (*status != "" && a.Status != *status) || !sel.matches(a.Labels) {
+			continue
+		}
+		matched = append(matched, a)
+		rows = append(rows, []string{
+			a.AgentID, a.Hostname, a.LabID, a.Status, orDash(a.IPAddress),
+			orDash(a.Version), formatLabels(a.Labels), formatAge(a.LastHeartbeatAt),
+		})
+	}
+
+	return e.out.print(matched, []string{"ID", "HOSTNAME", "LAB", "STATUS", "IP", "VERSION", "LABELS", "LAST SEEN"}, rows)
+}
+
+func runAgentsDescribe(e *env, fs *flag.FlagSet, args []string) error {
+	agentID, err := oneArg(fs, args, "agent ID")
+	if err != nil {
+		return err
+	}
+
+	agent, err := e.client.GetAgent(e.ctx, agentID)
+	if err != nil {
+		return err
+	}
+	recent, err := e.client.ListJobs(e.ctx, client.JobQuery{AgentID: agentID, Sort: "updated_at", Order: "desc", Limit: 10})
+	if err != nil {
+		return err
+	}
+
+	if e.out.json() {
+		return e.out.writeJSON(struct {
+			*protocol.AgentInfo
+			RecentJobs []protocol.JobResponse `json:"recent_jobs"`
+		}{agent, recent.Jobs})
+	}
+
+	platform, actions, unavailable := "-", "-", "-"
+	if caps := agent.Capabilities; caps != nil {
+		platform = orDash(strings.Trim(caps.OS+"/"+caps.Arch, "/"))
+		if len(caps.Actions) > 0 {
+			actions = strings.Join(caps.Actions, ", ")
+		}
+		if len(caps.Unavailable) > 0 {
+			unavailable = formatLabels(caps.Unavailable)
+		}
+	}
+
+	if err := e.out.details(agent, [][2]string{
+		{"ID", agent.AgentID},
+		{"Lab", agent.LabID},
+		{"Lab Host", agent.LabHostID},
+		{"Hostname", agent.Hostname},
+		{"IP Address", orDash(agent.IPAddress)},
+		{"Status", agent.Status},
+		{"Version", orDash(agent.Version)},
+		{"Labels", formatLabels(agent.Labels)},
+		{"Platform", platform},
+		{"Actions", actions},
+		{"Unavailable", unavailable},
+		{"Last Heartbeat", formatTime(agent.LastHeartbeatAt) + " (" + formatAge(agent.LastHeartbeatAt) + " ago)"},
+		{"Registered", formatTime(agent.RegisteredAt)},
+	}); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(e.out.w, "\nRecent jobs:\n")
+	return printJobs(e, recent.Jobs)
+}
+
+func runAgentsDelete(e *env, fs *flag.FlagSet, args []string) error {
+	agentID, err := oneArg(fs, args, "agent ID")
+	if err != nil {
+		return err
+	}
+	if err := e.client.DeleteAgent(e.ctx, agentID); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Deleted agent %s\n", agentID)
+	return nil
+}
+
+// labelSelector is a parsed -l selector. Each term is "key=value",
+// "key!=value", "key" (label present) or "!key" (label absent).
+type labelSelector []selectorTerm
+
+type selectorTerm struct {
+	key, value string
+	op         string // "=", "!=", "exists", "!exists"
+}
+
+func parseSelector(s string) (labelSelector, error) {
+	var sel labelSelector
+	for _, term := range splitList(s) {
+		switch {
+		case strings.Contains(term, "!="):
+			k, v, _ := strings.Cut(term, "!=")
+			sel = append(sel, selectorTerm{key: k, value: v, op: "!="})
+		case strings.Contains(term, "="):
+			k, v, _ := strings.Cut(term, "=")
+			sel = append(sel, selectorTerm{key: strings.TrimSuffix(k, "="), value: v, op: "="})
+		case strings.HasPrefix(term, "!"):
+			sel = append(sel, selectorTerm{key: term[1:], op: "!exists"})
+		default:
+			sel = append(sel, selectorTerm{key: term, op: "exists"})
+		}
+		if sel[len(sel)-1].key == "" {
+			return nil, fmt.Errorf("invalid label selector term %q", term)
+		}
+	}
+	return sel, nil
+}
+
+func (s labelSelector) matches(labels map[string]string) bool {
+	for _, t := range s {
+		v, ok := labels[t.key]
+		switch t.op {
+		case "=":
+			if !ok || v != t.value {
+				return false
+			}
+		case "!=":
+			if ok && v == t.value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ============================================================
+// scenarios
+// ============================================================
+
+var scenarioCommands = map[string]command{
+	"submit":      {"-f <file> [-name <name>] [-description <text>] [-watch]", runScenariosSubmit},
+	"list":        {"[-status <status>] [-limit <n>]", runScenariosList},
+	"describe":    {"<scenario-id>", runScenariosDescribe},
+	"scorecard":   {"<scenario-id>", runScenariosScorecard},
+	"timeline":    {"<scenario-id> [-format jsonl|csv|ecs] [-bulk]", runScenariosTimeline},
+	"report":      {"<scenario-id> [-format md|html|json]", runScenariosReport},
+	"watch":       {"<scenario-id> [-interval <duration>]", runScenariosWatch},
+	"delete":      {"<scenario-id>", runScenariosDelete},
+	"scoring-run": {"<scenario-id> [-run <run-id>]", runScenariosScoringRun},
+}
+
+// scenarioDone reports whether a scenario has reached a final status.
+func scenarioDone(status string) bool {
+	return status == "completed" || status == "failed" || status == "cancelled"
+}
+
+func runScenariosSubmit(e *env, fs *flag.FlagSet, args []string) error {
+	file := fs.String("f", "", "Scenario DSL or full request (JSON or YAML; - for stdin)")
+	name := fs.String("name", "", "Scenario name (default: from the file)")
+	description := fs.String("description", "", "Scenario description")
+	watch := fs.Bool("watch", false, "Watch progress after submitting")
+	interval := fs.Duration("interval", 2*time.Second, "Status poll interval for -watch")
+	fs.Parse(args)
+	if *file == "" {
+		fs.Usage()
+		return fmt.Errorf("-f is required")
+	}
+
+	req, err := loadScenarioRequest(*file)
+	if err != nil {
+		return err
+	}
+	if *name != "" {
+		req.Name = *name
+	}
+	if *description != "" {
+		req.Description = *description
+	}
+
+	resp, err := e.client.CreateScenario(e.ctx, *req)
+	if err != nil {
+		return err
+	}
+
+	if !*watch {
+		return e.out.details(resp, [][2]string{
+			{"ID", resp.ScenarioID},
+			{"Name", resp.Name},
+			{"Status", resp.Status},
+			{"Steps", strconv.Itoa(resp.StepCount)},
+			{"Jobs", strconv.Itoa(resp.JobCount)},
+		})
+	}
+	fmt.Fprintf(os.Stderr, "Submitted scenario %s (%s)\n", resp.ScenarioID, resp.Name)
+	return watchScenario(e, resp.ScenarioID, *interval)
+}
+
+// loadScenarioRequest reads a submission file. It accepts a complete
+// CreateScenarioRequest or a DSL scenario (identified by "steps"), as JSON or
+// YAML. Intents are rejected: the API does not plan scenarios from them.
+func loadScenarioRequest(path string) (*protocol.CreateScenarioRequest, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = readAllStdin()
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// YAML is a superset of JSON, so decode once and re-encode as JSON
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert %s to JSON: %w", path, err)
+	}
+
+	docName, _ := doc["name"].(string)
+	docDescription, _ := doc["description"].(string)
+	if docName == "" && path != "-" {
+		docName = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	req := &protocol.CreateScenarioRequest{Name: docName, Description: docDescription}
+	switch {
+	case doc["intent"] != nil || doc["lab_type"] != nil:
+		return nil, fmt.Errorf("%s is an intent; the API only accepts DSL scenarios", path)
+	case doc["scenario"] != nil:
+		if err := json.Unmarshal(body, req); err != nil {
+			return nil, fmt.Errorf("invalid scenario request: %w", err)
+		}
+		if req.Name == "" {
+			req.Name = docName
+		}
+	case doc["steps"] != nil:
+		req.Scenario = &protocol.ScenarioInput{Definition: string(body)}
+	default:
+		return nil, fmt.Errorf("%s is not a scenario (no \"steps\")", path)
+	}
+	return req, nil
+}
+
+func runScenariosList(e *env, fs *flag.FlagSet, args []string) error {
+	status := fs.String("status", "", "Only scenarios with this status")
+	limit := fs.Int("limit", 0, "Maximum number of scenarios (server default 50, max 100)")
+	fs.Parse(args)
+
+	scenarios, err := e.client.ListScenarios(e.ctx, *status, *limit)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(scenarios))
+	for _, s := range scenarios {
+		rows = append(rows, []string{s.ID, s.Name, s.LabID, s.Status, orDash(s.Source), formatTime(s.CreatedAt)})
+	}
+	return e.out.print(scenarios, []string{"ID", "NAME", "LAB", "STATUS", "SOURCE", "CREATED"}, rows)
+}
+
+func runScenariosDescribe(e *env, fs *flag.FlagSet, args []string) error {
+	scenarioID, err := oneArg(fs, args, "scenario ID")
+	if err != nil {
+		return err
+	}
+
+	scenario, err := e.client.GetScenario(e.ctx, scenarioID)
+	if err != nil {
+		return err
+	}
+	status, err := e.client.GetScenarioStatus(e.ctx, scenarioID)
+	if err != nil {
+		return err
+	}
+
+	if e.out.json() {
+		return e.out.writeJSON(struct {
+			Scenario *client.Scenario                 `json:"scenario"`
+			Status   *protocol.ScenarioStatusResponse `json:"status"`
+		}{scenario, status})
+	}
+
+	fields := [][2]string{
+		{"ID", scenario.ID},
+		{"Name", scenario.Name},
+		{"Description", orDash(strPtr(scenario.Description))},
+		{"Lab", scenario.LabID},
+		{"Source", orDash(scenario.Source)},
+		{"Status", scenario.Status},
+		{"Progress", formatProgress(status.Progress)},
+		{"Scoring Run", orDash(strPtr(scenario.ScoringRunID))},
+		{"Created", formatTime(scenario.CreatedAt)},
+		{"Updated", formatTime(scenario.UpdatedAt)},
+		{"Completed", formatTimePtr(scenario.CompletedAt)},
+	}
+	if msg := strPtr(scenario.ErrorMessage); msg != "" {
+		fields = append(fields, [2]string{"Error", msg})
+	}
+	return e.out.details(scenario, fields)
+}
+
+func runScenariosScorecard(e *env, fs *flag.FlagSet, args []string) error {
+	scenarioID, err := oneArg(fs, args, "scenario ID")
+	if err != nil {
+		return err
+	}
+
+	card, err := e.client.GetScenarioScorecard(e.ctx, scenarioID)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(card.Objectives))
+	for _, o := range card.Objectives {
+		passed := 0
+		for _, check := range o.Checks {
+			if check.Passed {
+				passed++
+			}
+		}
+		rows = append(rows, []string{
+			o.ID,
+			o.Name,
+			o.Status,
+			fmt.Sprintf("%d/%d", o.Score, o.Weight),
+			fmt.Sprintf("%d/%d (%s)", passed, len(o.Checks), o.Require),
+			formatTimePtr(o.Deadline),
+		})
+	}
+	if err := e.out.print(card, []string{"ID", "NAME", "STATUS", "SCORE", "CHECKS PASSED", "DEADLINE"}, rows); err != nil {
+		return err
+	}
+	if !e.out.json() {
+		fmt.Fprintf(e.out.w, "\nScore: %d/%d (%.0f%%), %d passed, %d failed, %d pending\n",
+			card.Score, card.MaxScore, card.Percent, card.Passed, card.Failed, card.Pending)
+	}
+	return nil
+}
+
+func runScenariosTimeline(e *env, fs *flag.FlagSet, args []string) error {
+	format := fs.String("format", "", "Export format: jsonl, csv or ecs (default: a table, or JSON with -o json)")
+	bulk := fs.Bool("bulk", false, "With -format ecs, write an Elasticsearch _bulk body")
+	scenarioID, err := oneArg(fs, args, "scenario ID")
+	if err != nil {
+		return err
+	}
+
+	if *format != "" {
+		body, err := e.client.ExportScenarioTimeline(e.ctx, scenarioID, *format, *bulk)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		_, err = io.Copy(e.out.w, body)
+		return err
+	}
+
+	tl, err := e.client.GetScenarioTimeline(e.ctx, scenarioID)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(tl.Entries))
+	for _, entry := range tl.Entries {
+		params := make([]string, 0, len(entry.Parameters))
+		for key, v := range entry.Parameters {
+			params = append(params, fmt.Sprintf("%s=%v", key, v))
+		}
+		sort.Strings(params)
+		rows = append(rows, []string{
+			formatTimePtr(entry.StartedAt),
+			orDash(entry.Hostname),
+			orDash(entry.RunAs),
+			entry.ActionType,
+			entry.Status,
+			fmt.Sprintf("%dms", entry.DurationMs),
+			orDash(strings.Join(params, " ")),
+		})
+	}
+	return e.out.print(tl, []string{"STARTED", "HOST", "RUN AS", "ACTION", "STATUS", "DURATION", "PARAMETERS"}, rows)
+}
+
+func runScenariosReport(e *env, fs *flag.FlagSet, args []string) error {
+	format := fs.String("format", "md", "Report format: md, html or json")
+	scenarioID, err := oneArg(fs, args, "scenario ID")
+	if err != nil {
+		return err
+	}
+
+	body, err := e.client.ExportScenarioReport(e.ctx, scenarioID, *format)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(e.out.w, body)
+	return err
+}
+
+func runScenariosWatch(e *env, fs *flag.FlagSet, args []string) error {
+	interval := fs.Duration("interval", 2*time.Second, "Status poll interval")
+	scenarioID, err := oneArg(fs, args, "scenario ID")
+	if err != nil {
+		return err
+	}
+	return watchScenario(e, scenarioID, *interval)
+}
+
+// watchScenario prints job events for the scenario as they happen and a
+// progress line whenever the progress changes, until the scenario finishes.
+// With -o json each status poll is printed as one JSON line.
+func watchScenario(e *env, scenarioID string, interval time.Duration) error {
+	ctx, cancel := context.WithCancel(e.ctx)
+	defer cancel()
+
+	events := make(chan client.Event, 16)
+	go func() {
+		// The event stream is best effort; polling alone still finishes the watch
+		_ = e.client.StreamEvents(ctx, client.EventQuery{ScenarioID: scenarioID}, func(ev client.Event) error {
+			select {
+			case events <- ev:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := ""
+	for {
+		status, err := e.client.GetScenarioStatus(e.ctx, scenarioID)
+		if err != nil {
+			return err
+		}
+
+		line := status.Status + " " + formatProgress(status.Progress)
+		if line != last {
+			last = line
+			if e.out.json() {
+				if err := writeJSONLine(e, status); err != nil {
+					return err
+				}
+			} else {
+				fmt.Fprintf(e.out.w, "%s  %-9s %s\n", time.Now().Format("15:04:05"), status.Status, formatProgress(status.Progress))
+			}
+		}
+		if scenarioDone(status.Status) {
+			if status.ErrorMessage != "" {
+				return fmt.Errorf("scenario %s: %s", status.Status, status.ErrorMessage)
+			}
+			return nil
+		}
+
+		select {
+		case <-e.ctx.Done():
+			return e.ctx.Err()
+		case ev := <-events:
+			if !e.out.json() && ev.Type != client.EventResync {
+				fmt.Fprintf(e.out.w, "%s  %s\n", ev.Time.Local().Format("15:04:05"), describeEvent(ev))
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+func formatProgress(p *protocol.ScenarioProgress) string {
+	if p == nil || p.TotalJobs == 0 {
+		return "no jobs"
+	}
+	return fmt.Sprintf("%.0f%% (%d/%d done, %d failed, %d cancelled, %d running, %d pending)",
+		p.PercentComplete, p.CompletedJobs+p.FailedJobs+p.CancelledJobs, p.TotalJobs, p.FailedJobs, p.CancelledJobs, p.RunningJobs, p.PendingJobs)
+}
+
+func runScenariosDelete(e *env, fs *flag.FlagSet, args []string) error {
+	scenarioID, err := oneArg(fs, args, "scenario ID")
+	if err != nil {
+		return err
+	}
+	if err := e.client.DeleteScenario(e.ctx, scenarioID); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Deleted scenario %s\n", scenarioID)
+	return nil
+}
+
+func runScenariosScoringRun(e *env, fs *flag.FlagSet, args []string) error {
+	runID := fs.String("run", "", "Existing scoring run ID (default: create one on the scoring engine)")
+	scenarioID, err := oneArg(fs, args, "scenario ID")
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.SetScenarioScoringRun(e.ctx, scenarioID, *runID)
+	if err != nil {
+		return err
+	}
+	return e.out.details(resp, [][2]string{
+		{"Scenario", resp.ScenarioID},
+		{"Scoring Run", resp.ScoringRunID},
+		{"Created", strconv.FormatBool(resp.Created)},
+	})
+}
+
+// ============================================================
+// jobs
+// ============================================================
+
+var jobCommands = map[string]command{
+	"list":     {"[-scenario <id>] [-agent <id>] [-status <s,...>] [-action <a,...>] [-run-as <user>] [-since <dur|time>] [-limit <n>] [-all]", runJobsList},
+	"describe": {"<job-id>", runJobsDescribe},
+	"retry":    {"<job-id>", runJobsRetry},
+	"cancel":   {"<job-id>", runJobsCancel},
+	"stats":    {"", runJobsStats},
+}
+
+func runJobsList(e *env, fs *flag.FlagSet, args []string) error {
+	var q client.JobQuery
+	fs.StringVar(&q.ScenarioID, "scenario", "", "Only jobs of this scenario")
+	fs.StringVar(&q.StepID, "step", "", "Only jobs of this scenario step")
+	fs.StringVar(&q.AgentID, "agent", "", "Only jobs for this agent")
+	fs.StringVar(&q.RunAs, "run-as", "", "Only jobs run as this user")
+	status := fs.String("status", "", "Comma-separated statuses")
+	action := fs.String("action", "", "Comma-separated action types")
+	since := fs.String("since", "", "Only jobs after this time (RFC 3339, or a duration such as 1h)")
+	fs.StringVar(&q.Sort, "sort", "", "scheduled_at, created_at or updated_at")
+	fs.StringVar(&q.Order, "order", "", "asc or desc")
+	fs.IntVar(&q.Limit, "limit", 50, "Jobs per page (max 500)")
+	all := fs.Bool("all", false, "Follow pagination and list every matching job")
+	fs.Parse(args)
+
+	q.Status = splitList(*status)
+	q.ActionType = splitList(*action)
+	if *since != "" {
+		t, err := parseSince(*since)
+		if err != nil {
+			return err
+		}
+		q.Since = t
+	}
+
+	var jobs []protocol.JobResponse
+	for {
+		page, err := e.client.ListJobs(e.ctx, q)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, page.Jobs...)
+		if !*all || page.NextCursor == "" {
+			if !*all && page.NextCursor != "" && !e.out.json() {
+				fmt.Fprintf(os.Stderr, "More jobs available; use -all to list them\n")
+			}
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+
+	if e.out.json() {
+		return e.out.writeJSON(jobs)
+	}
+	return printJobs(e, jobs)
+}
+
+// parseSince accepts an RFC 3339 time or a duration before now.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("-since must be an RFC 3339 time or a duration")
+	}
+	return t, nil
+}
+
+// printJobs writes jobs as a table.
+func printJobs(e *env, jobs []protocol.JobResponse) error {
+	rows := make([][]string, 0, len(jobs))
+	for _, j := range jobs {
+		rows = append(rows, []string{
+			j.ID, j.AgentID, j.ActionType, j.Status, orDash(j.RunAsUser),
+			strconv.Itoa(j.RetryCount) + "/" + strconv.Itoa(j.MaxRetries),
+			formatTime(j.ScheduledAt), orDash(j.ErrorMessage),
+		})
+	}
+	return e.out.print(jobs, []string{"ID", "AGENT", "ACTION", "STATUS", "RUN AS", "RETRIES", "SCHEDULED", "ERROR"}, rows)
+}
+
+func runJobsDescribe(e *env, fs *flag.FlagSet, args []string) error {
+	jobID, err := oneArg(fs, args, "job ID")
+	if err != nil {
+		return err
+	}
+	job, err := e.client.GetJob(e.ctx, jobID)
+	if err != nil {
+		return err
+	}
+	return printJob(e, job)
+}
+
+func runJobsRetry(e *env, fs *flag.FlagSet, args []string) error {
+	jobID, err := oneArg(fs, args, "job ID")
+	if err != nil {
+		return err
+	}
+	job, err := e.client.RetryJob(e.ctx, jobID)
+	if err != nil {
+		return err
+	}
+	return printJob(e, job)
+}
+
+func runJobsCancel(e *env, fs *flag.FlagSet, args []string) error {
+	jobID, err := oneArg(fs, args, "job ID")
+	if err != nil {
+		return err
+	}
+	job, err := e.client.CancelJob(e.ctx, jobID)
+	if err != nil {
+		return err
+	}
+	return printJob(e, job)
+}
+
+// printJob writes a single job's details, including parameters and result.
+func printJob(e *env, j *protocol.JobResponse) error {
+	fields := [][2]string{
+		{"ID", j.ID},
+		{"Lab", j.LabID},
+		{"Scenario", orDash(j.ScenarioID)},
+		{"Step", orDash(j.ScenarioStepID)},
+		{"Agent", j.AgentID},
+		{"Action", j.ActionType},
+		{"Run As", orDash(j.RunAsUser)},
+		{"Status", j.Status},
+		{"Priority", strconv.Itoa(j.Priority)},
+		{"Retries", strconv.Itoa(j.RetryCount) + "/" + strconv.Itoa(j.MaxRetries)},
+		{"Scheduled", formatTime(j.ScheduledAt)},
+		{"Assigned", formatTimePtr(j.AssignedAt)},
+		{"Started", formatTimePtr(j.StartedAt)},
+		{"Completed", formatTimePtr(j.CompletedAt)},
+	}
+	if j.ErrorMessage != "" {
+		fields = append(fields, [2]string{"Error", j.ErrorMessage})
+	}
+	if len(j.Parameters) > 0 {
+		fields = append(fields, [2]string{"Parameters", compactJSON(j.Parameters)})
+	}
+	if len(j.Result) > 0 {
+		fields = append(fields, [2]string{"Result", compactJSON(j.Result)})
+	}
+	return e.out.details(j, fields)
+}
+
+func runJobsStats(e *env, fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	stats, err := e.client.JobStats(e.ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(stats))
+	for _, status := range []string{"pending", "assigned", "running", "completed", "failed", "cancelled"} {
+		rows = append(rows, []string{status, strconv.Itoa(stats[status])})
+	}
+	return e.out.print(stats, []string{"STATUS", "COUNT"}, rows)
+}
+
+func compactJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(data)
+}
+
+// ============================================================
+// events
+// ============================================================
+
+var eventCommands = map[string]command{
+	"tail": {"[-type <t,...>] [-agent <id>] [-scenario <id>] [-since-id <id>]", runEventsTail},
+}
+
+func runEventsTail(e *env, fs *flag.FlagSet, args []string) error {
+	types := fs.String("type", "", "Comma-separated event types or categories (e.g. job.failed,agent)")
+	agentID := fs.String("agent", "", "Only events for this agent")
+	scenarioID := fs.String("scenario", "", "Only events for this scenario")
+	sinceID := fs.Uint64("since-id", 0, "Replay buffered events after this event ID")
+	fs.Parse(args)
+
+	query := client.EventQuery{
+		Types:       splitList(*types),
+		AgentID:     *agentID,
+		ScenarioID:  *scenarioID,
+		LastEventID: *sinceID,
+	}
+	return e.client.StreamEvents(e.ctx, query, func(ev client.Event) error {
+		if e.out.json() {
+			return writeJSONLine(e, ev)
+		}
+		if ev.Type == client.EventResync {
+			fmt.Fprintf(e.out.w, "-- events were missed; the stream resumed at %d --\n", ev.ID)
+			return nil
+		}
+		_, err := fmt.Fprintf(e.out.w, "%s  %-6d %s\n", ev.Time.Local().Format("2006-01-02 15:04:05"), ev.ID, describeEvent(ev))
+		return err
+	})
+}
+
+// describeEvent formats an event as a single line.
+func describeEvent(ev client.Event) string {
+	parts := []string{fmt.Sprintf("%-20s", ev.Type)}
+	if ev.AgentID != "" {
+		parts = append(parts, "agent="+ev.AgentID)
+	}
+	if ev.ScenarioID != "" {
+		parts = append(parts, "scenario="+ev.ScenarioID)
+	}
+	if ev.JobID != "" {
+		parts = append(parts, "job="+ev.JobID)
+	}
+	if len(ev.Data) > 0 {
+		parts = append(parts, compactJSON(ev.Data))
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeJSONLine writes v as a single line of JSON (for streaming output).
+func writeJSONLine(e *env, v interface{}) error {
+	return json.NewEncoder(e.out.w).Encode(v)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadScenarioRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		wantName string
+		wantErr  string
+	}{
+		{
+			name:     "DSL scenario",
+			file:     "browsing.yaml",
+			content:  "id: scn-1\nsteps:\n  - action: simulate_browsing\n",
+			wantName: "browsing",
+		},
+		{
+			name:     "full request",
+			file:     "request.json",
+			content:  `{"name": "Named", "scenario": {"definition": "{\"steps\": []}"}}`,
+			wantName: "Named",
+		},
+		{
+			name:    "intent",
+			file:    "intent.yaml",
+			content: "lab_type: windows\ndescription: A day at the office\n",
+			wantErr: "is an intent",
+		},
+		{
+			name:    "request with an intent",
+			file:    "intent.json",
+			content: `{"name": "Planned", "intent": {"lab_type": "windows"}}`,
+			wantErr: "is an intent",
+		},
+		{
+			name:    "neither",
+			file:    "other.yaml",
+			content: "name: nothing\n",
+			wantErr: "is not a scenario",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := loadScenarioRequest(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadScenarioRequest() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadScenarioRequest() error = %v", err)
+			}
+			if req.Name != tt.wantName || req.Scenario == nil || req.Intent != nil {
+				t.Errorf("Unexpected request: %+v", req)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultServer is used when neither a flag, the environment nor a context
+// names an orchestrator.
+const DefaultServer = "http://localhost:8081"
+
+// Context is a named orchestrator connection.
+type Context struct {
+	Server string `yaml:"server"`
+	APIKey string `yaml:"api_key,omitempty"`
+	Lab    string `yaml:"lab,omitempty"`
+}
+
+// CLIConfig is the cymctl config file.
+type CLIConfig struct {
+	CurrentContext string             `yaml:"current_context,omitempty"`
+	Contexts       map[string]Context `yaml:"contexts,omitempty"`
+
+	path string
+}
+
+// defaultConfigPath returns $CYMCTL_CONFIG or ~/.config/cymctl/config.yaml.
+func defaultConfigPath() (string, error) {
+	if path := os.Getenv("CYMCTL_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate config directory: %w", err)
+	}
+	return filepath.Join(dir, "cymctl", "config.yaml"), nil
+}
+
+// LoadCLIConfig reads the config file. A missing file is an empty config.
+func LoadCLIConfig(path string) (*CLIConfig, error) {
+	if path == "" {
+		var err error
+		if path, err = defaultConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg := &CLIConfig{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Save writes the config file. It holds API keys, so it is private to the
+// user.
+func (c *CLIConfig) Save() error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// Resolve picks the context to use (name, then $CYMCTL_CONTEXT, then the
+// current context) and applies $CYMCTL_SERVER, $CYMCTL_API_KEY and
+// $CYMCTL_LAB, then the non-empty fields of overrides, on top of it.
+func (c *CLIConfig) Resolve(name string, overrides Context) (Context, error) {
+	if name == "" {
+		name = os.Getenv("CYMCTL_CONTEXT")
+	}
+	explicit := name != ""
+	if name == "" {
+		name = c.CurrentContext
+	}
+
+	var ctx Context
+	if name != "" {
+		found, ok := c.Contexts[name]
+		if !ok && explicit {
+			return ctx, fmt.Errorf("context %q not found in %s", name, c.path)
+		}
+		ctx = found
+	}
+
+	ctx = ctx.merge(Context{
+		Server: os.Getenv("CYMCTL_SERVER"),
+		APIKey: os.Getenv("CYMCTL_API_KEY"),
+		Lab:    os.Getenv("CYMCTL_LAB"),
+	}).merge(overrides)
+	if ctx.Server == "" {
+		ctx.Server = DefaultServer
+	}
+	return ctx, nil
+}
+
+// merge returns c with the non-empty fields of o applied.
+func (c Context) merge(o Context) Context {
+	if o.Server != "" {
+		c.Server = o.Server
+	}
+	if o.APIKey != "" {
+		c.APIKey = o.APIKey
+	}
+	if o.Lab != "" {
+		c.Lab = o.Lab
+	}
+	return c
+}
+
+// ============================================================
+// config commands
+// ============================================================
+
+var configCommands = map[string]command{
+	"get-contexts":    {"", runGetContexts},
+	"current-context": {"", runCurrentContext},
+	"use-context":     {"<name>", runUseContext},
+	"set-context":     {"<name> [-server <url>] [-api-key <key>] [-lab <id>] [-use]", runSetContext},
+	"delete-context":  {"<name>", runDeleteContext},
+}
+
+func runGetContexts(e *env, fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+
+	names := make([]string, 0, len(e.cfg.Contexts))
+	for name := range e.cfg.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type contextView struct {
+		Name    string `json:"name"`
+		Current bool   `json:"current"`
+		Server  string `json:"server"`
+		Lab     string `json:"lab,omitempty"`
+		HasKey  bool   `json:"has_api_key"`
+	}
+	views := make([]contextView, 0, len(names))
+	rows := make([][]string, 0, len(names))
+	for _, name := range names {
+		ctx := e.cfg.Contexts[name]
+		v := contextView{
+			Name:    name,
+			Current: name == e.cfg.CurrentContext,
+			Server:  ctx.Server,
+			Lab:     ctx.Lab,
+			HasKey:  ctx.APIKey != "",
+		}
+		views = append(views, v)
+
+		current := ""
+		if v.Current {
+			current = "*"
+		}
+		rows = append(rows, []string{current, name, ctx.Server, orDash(ctx.Lab), yesNo(v.HasKey)})
+	}
+
+	return e.out.print(views, []string{"CURRENT", "NAME", "SERVER", "LAB", "API KEY"}, rows)
+}
+
+func runCurrentContext(e *env, fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	if e.cfg.CurrentContext == "" {
+		return fmt.Errorf("no current context is set")
+	}
+	fmt.Fprintln(e.out.w, e.cfg.CurrentContext)
+	return nil
+}
+
+func runUseContext(e *env, fs *flag.FlagSet, args []string) error {
+	name, err := oneArg(fs, args, "context name")
+	if err != nil {
+		return err
+	}
+	if _, ok := e.cfg.Contexts[name]; !ok {
+		return fmt.Errorf("context %q not found", name)
+	}
+	e.cfg.CurrentContext = name
+	if err := e.cfg.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Switched to context %q\n", name)
+	return nil
+}
+
+func runSetContext(e *env, fs *flag.FlagSet, args []string) error {
+	server := fs.String("server", "", "Orchestrator URL")
+	apiKey := fs.String("api-key", "", "API key")
+	lab := fs.String("lab", "", "Lab to scope requests to")
+	use := fs.Bool("use", false, "Make this the current context")
+	name, err := oneArg(fs, args, "context name")
+	if err != nil {
+		return err
+	}
+
+	if e.cfg.Contexts == nil {
+		e.cfg.Contexts = make(map[string]Context)
+	}
+	ctx := e.cfg.Contexts[name].merge(Context{Server: *server, APIKey: *apiKey, Lab: *lab})
+	if ctx.Server == "" {
+		return fmt.Errorf("-server is required for a new context")
+	}
+	e.cfg.Contexts[name] = ctx
+	if *use || e.cfg.CurrentContext == "" {
+		e.cfg.CurrentContext = name
+	}
+	if err := e.cfg.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Context %q saved to %s\n", name, e.cfg.path)
+	return nil
+}
+
+func runDeleteContext(e *env, fs *flag.FlagSet, args []string) error {
+	name, err := oneArg(fs, args, "context name")
+	if err != nil {
+		return err
+	}
+	if _, ok := e.cfg.Contexts[name]; !ok {
+		return fmt.Errorf("context %q not found", name)
+	}
+	delete(e.cfg.Contexts, name)
+	if e.cfg.CurrentContext == name {
+		e.cfg.CurrentContext = ""
+	}
+	if err := e.cfg.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Deleted context %q\n", name)
+	return nil
+}
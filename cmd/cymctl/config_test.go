@@ -0,0 +1,172 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadCLIConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("missing file is empty", func(t *testing.T) {
+		cfg, err := LoadCLIConfig(filepath.Join(dir, "missing.yaml"))
+		if err != nil {
+			t.Fatalf("LoadCLIConfig() error = %v", err)
+		}
+		if cfg.CurrentContext != "" || len(cfg.Contexts) != 0 {
+			t.Errorf("Expected an empty config, got %+v", cfg)
+		}
+	})
+
+	t.Run("parses contexts", func(t *testing.T) {
+		path := filepath.Join(dir, "config.yaml")
+		data := `current_context: lab
+contexts:
+  lab:
+    server: https://orchestrator.lab:8081
+    api_key: secret
+    lab: lab-1
+`
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadCLIConfig(path)
+		if err != nil {
+			t.Fatalf("LoadCLIConfig() error = %v", err)
+		}
+		want := Context{Server: "https://orchestrator.lab:8081", APIKey: "secret", Lab: "lab-1"}
+		if cfg.CurrentContext != "lab" || cfg.Contexts["lab"] != want {
+			t.Errorf("Unexpected config: %+v", cfg)
+		}
+	})
+
+	t.Run("invalid yaml", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.yaml")
+		if err := os.WriteFile(path, []byte("contexts: ["), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadCLIConfig(path); err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("Expected a parse error naming the file, got %v", err)
+		}
+	})
+
+	t.Run("default path from environment", func(t *testing.T) {
+		path := filepath.Join(dir, "env.yaml")
+		t.Setenv("CYMCTL_CONFIG", path)
+		cfg, err := LoadCLIConfig("")
+		if err != nil {
+			t.Fatalf("LoadCLIConfig() error = %v", err)
+		}
+		if cfg.path != path {
+			t.Errorf("Expected path %s, got %s", path, cfg.path)
+		}
+	})
+}
+
+func TestCLIConfig_SaveRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cymctl", "config.yaml")
+	cfg := &CLIConfig{
+		CurrentContext: "prod",
+		Contexts:       map[string]Context{"prod": {Server: "https://prod:8081", APIKey: "key"}},
+		path:           path,
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected mode 0600, got %o", perm)
+	}
+
+	loaded, err := LoadCLIConfig(path)
+	if err != nil {
+		t.Fatalf("LoadCLIConfig() error = %v", err)
+	}
+	if loaded.CurrentContext != "prod" || loaded.Contexts["prod"] != cfg.Contexts["prod"] {
+		t.Errorf("Round trip mismatch: %+v", loaded)
+	}
+}
+
+func TestCLIConfig_Resolve(t *testing.T) {
+	cfg := &CLIConfig{
+		CurrentContext: "dev",
+		Contexts: map[string]Context{
+			"dev":  {Server: "http://dev:8081", APIKey: "dev-key", Lab: "dev-lab"},
+			"prod": {Server: "https://prod:8081", APIKey: "prod-key"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		context   string
+		env       map[string]string
+		overrides Context
+		want      Context
+		wantErr   bool
+	}{
+		{
+			name: "current context",
+			want: Context{Server: "http://dev:8081", APIKey: "dev-key", Lab: "dev-lab"},
+		},
+		{
+			name:    "named context",
+			context: "prod",
+			want:    Context{Server: "https://prod:8081", APIKey: "prod-key"},
+		},
+		{
+			name: "context from environment",
+			env:  map[string]string{"CYMCTL_CONTEXT": "prod"},
+			want: Context{Server: "https://prod:8081", APIKey: "prod-key"},
+		},
+		{
+			name:    "unknown named context",
+			context: "staging",
+			wantErr: true,
+		},
+		{
+			name: "environment overrides context",
+			env:  map[string]string{"CYMCTL_SERVER": "http://env:8081", "CYMCTL_LAB": "env-lab"},
+			want: Context{Server: "http://env:8081", APIKey: "dev-key", Lab: "env-lab"},
+		},
+		{
+			name:      "flags override environment",
+			env:       map[string]string{"CYMCTL_API_KEY": "env-key"},
+			overrides: Context{APIKey: "flag-key"},
+			want:      Context{Server: "http://dev:8081", APIKey: "flag-key", Lab: "dev-lab"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"CYMCTL_CONTEXT", "CYMCTL_SERVER", "CYMCTL_API_KEY", "CYMCTL_LAB"} {
+				t.Setenv(key, tt.env[key])
+			}
+			got, err := cfg.Resolve(tt.context, tt.overrides)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Resolve() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCLIConfig_ResolveDefaultServer(t *testing.T) {
+	for _, key := range []string{"CYMCTL_CONTEXT", "CYMCTL_SERVER", "CYMCTL_API_KEY", "CYMCTL_LAB"} {
+		t.Setenv(key, "")
+	}
+	got, err := (&CLIConfig{}).Resolve("", Context{})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got.Server != DefaultServer {
+		t.Errorf("Expected server %s, got %s", DefaultServer, got.Server)
+	}
+}
@@ -0,0 +1,199 @@
+// Command cymctl is the operator CLI for the CymConductor orchestrator.
+//
+// Usage:
+//
+//	cymctl [global flags] <resource> <command> [flags] [args]
+//
+// Resources are agents, scenarios, jobs, users, events and config. Global
+// flags select the orchestrator (directly or through a named context in the
+// cymctl config file) and the output format.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"cymbytes.com/cymconductor/pkg/client"
+)
+
+// Version information (set at build time)
+var (
+	Version   = "dev"
+	BuildTime = "unknown"
+	GitCommit = "unknown"
+)
+
+// env carries what every command needs: the resolved context, the API client
+// and the output printer.
+type env struct {
+	ctx     context.Context
+	cfg     *CLIConfig
+	context Context
+	client  *client.Client
+	out     *printer
+}
+
+// command is a single "<resource> <command>" handler. run receives a flag
+// set named after the command with its usage line already set.
+type command struct {
+	usage string
+	run   func(e *env, fs *flag.FlagSet, args []string) error
+}
+
+// resources maps resource names to their commands.
+var resources = map[string]map[string]command{
+	"agents":    agentCommands,
+	"scenarios": scenarioCommands,
+	"jobs":      jobCommands,
+	"users":     userCommands,
+	"events":    eventCommands,
+	"config":    configCommands,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("cymctl", flag.ExitOnError)
+	fs.Usage = func() { usage(fs) }
+	configPath := fs.String("config", "", "Path to the cymctl config file (default $CYMCTL_CONFIG or ~/.config/cymctl/config.yaml)")
+	contextName := fs.String("context", "", "Context to use (default $CYMCTL_CONTEXT or the current context)")
+	server := fs.String("server", "", "Orchestrator URL, overriding the context")
+	apiKey := fs.String("api-key", "", "API key, overriding the context")
+	lab := fs.String("lab", "", "Lab to scope requests to, overriding the context")
+	output := fs.String("o", outputTable, "Output format: table or json")
+	showVersion := fs.Bool("version", false, "Show version information")
+	fs.Parse(args)
+
+	if *showVersion {
+		fmt.Printf("cymctl %s (commit %s, built %s)\n", Version, GitCommit, BuildTime)
+		return 0
+	}
+	if *output != outputTable && *output != outputJSON {
+		fmt.Fprintf(os.Stderr, "cymctl: unknown output format %q (use table or json)\n", *output)
+		return 2
+	}
+
+	rest := fs.Args()
+	if len(rest) < 2 {
+		usage(fs)
+		return 2
+	}
+	cmds, ok := resources[rest[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "cymctl: unknown resource %q\n", rest[0])
+		usage(fs)
+		return 2
+	}
+	cmd, ok := cmds[rest[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "cymctl: unknown command %q for %s\n", rest[1], rest[0])
+		usage(fs)
+		return 2
+	}
+
+	cfg, err := LoadCLIConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cymctl: %v\n", err)
+		return 1
+	}
+	current, err := cfg.Resolve(*contextName, Context{Server: *server, APIKey: *apiKey, Lab: *lab})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cymctl: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	e := &env{
+		ctx:     ctx,
+		cfg:     cfg,
+		context: current,
+		client: client.New(client.Config{
+			BaseURL: current.Server,
+			APIKey:  current.APIKey,
+			LabID:   current.Lab,
+		}),
+		out: newPrinter(os.Stdout, *output),
+	}
+
+	if err := cmd.run(e, commandFlags(rest[0], rest[1], cmd.usage), rest[2:]); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return 130
+		}
+		fmt.Fprintf(os.Stderr, "cymctl %s %s: %v\n", rest[0], rest[1], err)
+		return 1
+	}
+	return 0
+}
+
+// usage prints the global flags and every resource's commands.
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, "Usage: cymctl [global flags] <resource> <command> [flags] [args]\n\nGlobal flags:\n")
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\nCommands:\n")
+
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmds := make([]string, 0, len(resources[name]))
+		for cmd := range resources[name] {
+			cmds = append(cmds, cmd)
+		}
+		sort.Strings(cmds)
+		for _, cmd := range cmds {
+			fmt.Fprintf(w, "  %s %s %s\n", name, cmd, resources[name][cmd].usage)
+		}
+	}
+}
+
+// commandFlags returns a flag set for "<resource> <command>".
+func commandFlags(resource, name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(resource+" "+name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cymctl %s %s %s\n", resource, name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// oneArg parses flags and returns the single positional argument. Flags may
+// come before or after it.
+func oneArg(fs *flag.FlagSet, args []string, what string) (string, error) {
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return "", fmt.Errorf("expected one %s", what)
+	}
+	arg := fs.Arg(0)
+	fs.Parse(fs.Args()[1:])
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return "", fmt.Errorf("expected one %s", what)
+	}
+	return arg, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrinter_Print(t *testing.T) {
+	v := []map[string]string{{"id": "a1"}}
+	headers := []string{"ID", "STATUS"}
+	rows := [][]string{{"a1", "online"}, {"agent-two", "offline"}}
+
+	var table bytes.Buffer
+	if err := newPrinter(&table, outputTable).print(v, headers, rows); err != nil {
+		t.Fatalf("print() error = %v", err)
+	}
+	want := "ID         STATUS\na1         online\nagent-two  offline\n"
+	if table.String() != want {
+		t.Errorf("table output = %q, want %q", table.String(), want)
+	}
+
+	var out bytes.Buffer
+	if err := newPrinter(&out, outputJSON).print(v, headers, rows); err != nil {
+		t.Fatalf("print() error = %v", err)
+	}
+	var decoded []map[string]string
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("JSON output is invalid: %v", err)
+	}
+	if len(decoded) != 1 || decoded[0]["id"] != "a1" {
+		t.Errorf("Unexpected JSON output: %s", out.String())
+	}
+	if !strings.Contains(out.String(), "\n  ") {
+		t.Errorf("Expected indented JSON, got %s", out.String())
+	}
+}
+
+func TestPrinter_Details(t *testing.T) {
+	fields := [][2]string{{"ID", "s1"}, {"Status", "active"}}
+
+	var table bytes.Buffer
+	if err := newPrinter(&table, outputTable).details(nil, fields); err != nil {
+		t.Fatalf("details() error = %v", err)
+	}
+	want := "ID:     s1\nStatus: active\n"
+	if table.String() != want {
+		t.Errorf("details output = %q, want %q", table.String(), want)
+	}
+
+	var out bytes.Buffer
+	if err := newPrinter(&out, outputJSON).details(map[string]string{"id": "s1"}, fields); err != nil {
+		t.Fatalf("details() error = %v", err)
+	}
+	if strings.TrimSpace(out.String()) != "{\n  \"id\": \"s1\"\n}" {
+		t.Errorf("Unexpected JSON output: %s", out.String())
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		ago  time.Duration
+		want string
+	}{
+		{30 * time.Second, "30s"},
+		{5 * time.Minute, "5m"},
+		{3 * time.Hour, "3h"},
+		{50 * time.Hour, "2d"},
+	}
+	for _, tt := range tests {
+		if got := formatAge(time.Now().Add(-tt.ago)); got != tt.want {
+			t.Errorf("formatAge(-%s) = %s, want %s", tt.ago, got, tt.want)
+		}
+	}
+	if got := formatAge(time.Time{}); got != "-" {
+		t.Errorf("formatAge(zero) = %s, want -", got)
+	}
+}
+
+func TestFormatHelpers(t *testing.T) {
+	if got := formatLabels(map[string]string{"role": "ws", "os": "windows"}); got != "os=windows,role=ws" {
+		t.Errorf("formatLabels() = %s", got)
+	}
+	if got := formatLabels(nil); got != "-" {
+		t.Errorf("formatLabels(nil) = %s", got)
+	}
+	if got := formatTime(time.Time{}); got != "-" {
+		t.Errorf("formatTime(zero) = %s", got)
+	}
+	if got := formatTimePtr(nil); got != "-" {
+		t.Errorf("formatTimePtr(nil) = %s", got)
+	}
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if got := formatTimePtr(&ts); got != ts.Local().Format("2006-01-02 15:04:05") {
+		t.Errorf("formatTimePtr() = %s", got)
+	}
+	if orDash("") != "-" || orDash("x") != "x" {
+		t.Error("orDash() returned an unexpected value")
+	}
+	if yesNo(true) != "yes" || yesNo(false) != "no" {
+		t.Error("yesNo() returned an unexpected value")
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// ============================================================
+// users
+// ============================================================
+
+var userCommands = map[string]command{
+	"list":     {"[-department <name>]", runUsersList},
+	"describe": {"<user-id>", runUsersDescribe},
+	"create":   {"-username <DOMAIN\\user> [-display-name <name>] [-department <name>] [-title <title>] [-hosts <h,...>]", runUsersCreate},
+	"delete":   {"<user-id>", runUsersDelete},
+	"import":   {"-f <file.csv|file.json> [-dry-run]", runUsersImport},
+}
+
+func runUsersList(e *env, fs *flag.FlagSet, args []string) error {
+	department := fs.String("department", "", "Only users in this department")
+	fs.Parse(args)
+
+	users, err := e.client.ListUsers(e.ctx, *department)
+	if err != nil {
+		return err
+	}
+	return printUsers(e, users)
+}
+
+func printUsers(e *env, users []protocol.ImpersonationUserResponse) error {
+	rows := make([][]string, 0, len(users))
+	for _, u := range users {
+		rows = append(rows, []string{
+			u.ID, u.Username, orDash(u.DisplayName), orDash(u.Department), orDash(u.Title),
+			u.LabID, orDash(strings.Join(u.AllowedHosts, ",")),
+		})
+	}
+	return e.out.print(users, []string{"ID", "USERNAME", "DISPLAY NAME", "DEPARTMENT", "TITLE", "LAB", "HOSTS"}, rows)
+}
+
+func runUsersDescribe(e *env, fs *flag.FlagSet, args []string) error {
+	userID, err := oneArg(fs, args, "user ID")
+	if err != nil {
+		return err
+	}
+	user, err := e.client.GetUser(e.ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	fields := [][2]string{
+		{"ID", user.ID},
+		{"Lab", user.LabID},
+		{"Username", user.Username},
+		{"Domain", user.Domain},
+		{"SAM Account", user.SAMAccountName},
+		{"Display Name", orDash(user.DisplayName)},
+		{"Department", orDash(user.Department)},
+		{"Title", orDash(user.Title)},
+		{"Allowed Hosts", orDash(strings.Join(user.AllowedHosts, ","))},
+	}
+	if p := user.Persona; p != nil {
+		fields = append(fields,
+			[2]string{"Typical Apps", orDash(strings.Join(p.TypicalApps, ","))},
+			[2]string{"Typical Sites", orDash(strings.Join(p.TypicalSites, ","))},
+			[2]string{"File Types", orDash(strings.Join(p.FileTypes, ","))},
+		)
+		if p.WorkHours != nil {
+			fields = append(fields, [2]string{"Work Hours", fmt.Sprintf("%02d:00-%02d:00", p.WorkHours.Start, p.WorkHours.End)})
+		}
+	}
+	fields = append(fields, [2]string{"Created", formatTime(user.CreatedAt)})
+	return e.out.details(user, fields)
+}
+
+func runUsersCreate(e *env, fs *flag.FlagSet, args []string) error {
+	var req protocol.CreateImpersonationUserRequest
+	fs.StringVar(&req.Username, "username", "", "Full username (DOMAIN\\user)")
+	fs.StringVar(&req.DisplayName, "display-name", "", "Display name")
+	fs.StringVar(&req.Department, "department", "", "Department")
+	fs.StringVar(&req.Title, "title", "", "Job title")
+	hosts := fs.String("hosts", "", "Comma-separated lab host IDs the user may be impersonated on")
+	fs.Parse(args)
+
+	if req.Username == "" {
+		fs.Usage()
+		return fmt.Errorf("-username is required")
+	}
+	fillAccount(&req)
+	req.AllowedHosts = splitList(*hosts)
+
+	user, err := e.client.CreateUser(e.ctx, req)
+	if err != nil {
+		return err
+	}
+	return printUsers(e, []protocol.ImpersonationUserResponse{*user})
+}
+
+func runUsersDelete(e *env, fs *flag.FlagSet, args []string) error {
+	userID, err := oneArg(fs, args, "user ID")
+	if err != nil {
+		return err
+	}
+	if err := e.client.DeleteUser(e.ctx, userID); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Deleted user %s\n", userID)
+	return nil
+}
+
+func runUsersImport(e *env, fs *flag.FlagSet, args []string) error {
+	file := fs.String("f", "", "CSV file with a header row, or a JSON bulk request like configs/seed-users.json (- for CSV on stdin)")
+	dryRun := fs.Bool("dry-run", false, "Parse the file and print the request without sending it")
+	fs.Parse(args)
+	if *file == "" {
+		fs.Usage()
+		return fmt.Errorf("-f is required")
+	}
+
+	req, err := loadUsers(*file)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return e.out.writeJSON(req)
+	}
+
+	resp, err := e.client.BulkCreateUsers(e.ctx, *req)
+	if err != nil {
+		return err
+	}
+
+	if e.out.json() {
+		return e.out.writeJSON(resp)
+	}
+	if len(resp.Created) > 0 {
+		if err := printUsers(e, resp.Created); err != nil {
+			return err
+		}
+	}
+	for _, failure := range resp.Errors {
+		fmt.Fprintf(os.Stderr, "user %d (%s): %s\n", failure.Index+1, failure.Username, failure.Error)
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d of %d users (%d failed)\n", resp.Success, resp.Total, resp.Failed)
+	if resp.Failed > 0 {
+		return fmt.Errorf("%d users were not imported", resp.Failed)
+	}
+	return nil
+}
+
+// loadUsers reads a bulk user file: JSON in the /api/users/bulk format, or
+// CSV otherwise.
+func loadUsers(path string) (*protocol.BulkCreateImpersonationUsersRequest, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = readAllStdin()
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var req protocol.BulkCreateImpersonationUsersRequest
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	} else {
+		users, err := parseUsersCSV(strings.NewReader(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		req.Users = users
+	}
+
+	if len(req.Users) == 0 {
+		return nil, fmt.Errorf("%s contains no users", path)
+	}
+	for i := range req.Users {
+		fillAccount(&req.Users[i])
+	}
+	return &req, nil
+}
+
+// userCSVColumns are the recognised CSV columns. Only username is required;
+// list columns are separated by ";" and work_hours is "start-end" (e.g. 8-17).
+var userCSVColumns = []string{
+	"username", "domain", "sam_account_name", "display_name", "department", "title",
+	"lab_id", "allowed_hosts", "typical_apps", "typical_sites", "file_types", "work_hours",
+}
+
+// parseUsersCSV parses users from CSV with a header row.
+func parseUsersCSV(r io.Reader) ([]protocol.CreateImpersonationUserRequest, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, c := range userCSVColumns {
+			known = known || c == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column %q (expected %s)", name, strings.Join(userCSVColumns, ", "))
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["username"]; !ok {
+		return nil, fmt.Errorf("missing username column")
+	}
+
+	var users []protocol.CreateImpersonationUserRequest
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		list := func(name string) []string {
+			var items []string
+			for _, item := range strings.Split(get(name), ";") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			return items
+		}
+
+		user := protocol.CreateImpersonationUserRequest{
+			LabID:          get("lab_id"),
+			Username:       get("username"),
+			Domain:         get("domain"),
+			SAMAccountName: get("sam_account_name"),
+			DisplayName:    get("display_name"),
+			Department:     get("department"),
+			Title:          get("title"),
+			AllowedHosts:   list("allowed_hosts"),
+		}
+		if user.Username == "" {
+			return nil, fmt.Errorf("line %d: username is required", line)
+		}
+
+		persona := &protocol.UserPersonaInput{
+			TypicalApps:  list("typical_apps"),
+			TypicalSites: list("typical_sites"),
+			FileTypes:    list("file_types"),
+		}
+		if hours := get("work_hours"); hours != "" {
+			start, end, ok := strings.Cut(hours, "-")
+			s, err1 := strconv.Atoi(strings.TrimSpace(start))
+			e, err2 := strconv.Atoi(strings.TrimSpace(end))
+			if !ok || err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("line %d: work_hours must be start-end hours, e.g. 8-17", line)
+			}
+			persona.WorkHours = &protocol.WorkHoursInput{Start: s, End: e}
+		}
+		if len(persona.TypicalApps)+len(persona.TypicalSites)+len(persona.FileTypes) > 0 || persona.WorkHours != nil {
+			user.Persona = persona
+		}
+
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// fillAccount derives the domain and SAM account name from a DOMAIN\user
+// username when they are not given.
+func fillAccount(u *protocol.CreateImpersonationUserRequest) {
+	domain, sam, ok := strings.Cut(u.Username, `\`)
+	if !ok {
+		return
+	}
+	if u.Domain == "" {
+		u.Domain = domain
+	}
+	if u.SAMAccountName == "" {
+		u.SAMAccountName = sam
+	}
+}
+
+func readAllStdin() ([]byte, error) {
+	return io.ReadAll(os.Stdin)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/backup"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/internal/orchestrator/validator"
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// commands are the maintenance subcommands, run instead of the server as
+// "orchestrator <command> [flags]".
+var commands = map[string]func(args []string) error{
+	"backup":  runBackup,
+	"restore": runRestore,
+	"export":  runExport,
+	"import":  runImport,
+}
+
+// runCommand runs a maintenance subcommand and returns the exit code.
+func runCommand(name string, args []string) int {
+	if err := commands[name](args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// commandFlags returns a flag set with the -config flag every command accepts.
+func commandFlags(name, usage string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: orchestrator %s %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "", "Path to configuration file")
+	return fs, configPath
+}
+
+// commandConfig loads the configuration the server would use. Commands
+// operate on the SQLite database file, so the memory driver is rejected.
+func commandConfig(configPath string) (Config, error) {
+	cfg, err := readConfig(configPath)
+	if err != nil {
+		return cfg, err
+	}
+	if cfg.Database.Driver == DatabaseDriverMemory {
+		return cfg, fmt.Errorf("the %s database driver keeps no state to maintain; use %s", DatabaseDriverMemory, DatabaseDriverSQLite)
+	}
+	return cfg, nil
+}
+
+// openDatabase opens (and migrates) the configured database, logging
+// warnings and errors to stderr.
+func openDatabase(ctx context.Context, cfg Config) (*storage.DB, error) {
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: os.Stderr}).Level(zerolog.WarnLevel).With().Timestamp().Logger()
+	return storage.New(ctx, storage.Config{
+		Path:         cfg.Database.Path,
+		MaxOpenConns: cfg.Database.MaxOpenConns,
+		MaxIdleConns: cfg.Database.MaxIdleConns,
+		EnableWAL:    cfg.Database.EnableWAL,
+	}, logger)
+}
+
+// runBackup takes an online backup of the database. It is safe to run while
+// the orchestrator is serving.
+func runBackup(args []string) error {
+	fs, configPath := commandFlags("backup", "-out <file> [-config <file>]")
+	out := fs.String("out", "", "Backup file to create (must not exist)")
+	fs.Parse(args)
+	if *out == "" {
+		fs.Usage()
+		return fmt.Errorf("-out is required")
+	}
+
+	cfg, err := commandConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	db, err := openDatabase(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.Backup(ctx, *out); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Backed up %s to %s\n", cfg.Database.Path, *out)
+	return nil
+}
+
+// runRestore replaces the database with a backup. The orchestrator must be
+// stopped; use the -restore flag to restore as part of starting it.
+func runRestore(args []string) error {
+	fs, configPath := commandFlags("restore", "-in <file> [-config <file>]")
+	in := fs.String("in", "", "Backup file to restore")
+	fs.Parse(args)
+	if *in == "" {
+		fs.Usage()
+		return fmt.Errorf("-in is required")
+	}
+
+	cfg, err := commandConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if err := storage.Restore(ctx, *in, cfg.Database.Path); err != nil {
+		return err
+	}
+
+	// Bring the restored database up to the current schema
+	db, err := openDatabase(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	fmt.Fprintf(os.Stderr, "Restored %s from %s\n", cfg.Database.Path, *in)
+	return nil
+}
+
+// runExport writes a portable state export as JSON.
+func runExport(args []string) error {
+	fs, configPath := commandFlags("export", "[-out <file>] [-config <file>]")
+	out := fs.String("out", "-", "Export file to write (- for stdout)")
+	fs.Parse(args)
+
+	cfg, err := commandConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	db, err := openDatabase(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	doc, err := backup.Export(ctx, db, Version)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d labs, %d users, %d scenarios\n", len(doc.Labs), len(doc.Users), len(doc.Scenarios))
+	return nil
+}
+
+// runImport loads a state export into the database.
+func runImport(args []string) error {
+	fs, configPath := commandFlags("import", "-in <file> [-overwrite] [-config <file>]")
+	in := fs.String("in", "", "Export file to import (- for stdin)")
+	overwrite := fs.Bool("overwrite", false, "Update existing labs and users instead of skipping them")
+	fs.Parse(args)
+	if *in == "" {
+		fs.Usage()
+		return fmt.Errorf("-in is required")
+	}
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var doc protocol.StateExport
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return fmt.Errorf("failed to parse export: %w", err)
+	}
+
+	cfg, err := commandConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	db, err := openDatabase(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	val := validator.New()
+	val.SetPolicy(cfg.validatorPolicy())
+
+	result, err := backup.Import(ctx, db, &doc, backup.ImportOptions{
+		Overwrite: *overwrite,
+		Validator: val,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range result.Errors {
+		fmt.Fprintf(os.Stderr, "skipped: %s\n", msg)
+	}
+	fmt.Fprintf(os.Stderr, "Labs: %+v\nUsers: %+v\nScenarios: %+v\n", result.Labs, result.Users, result.Scenarios)
+	return nil
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/export"
+	"cymbytes.com/cymconductor/internal/orchestrator/outbox"
+	"cymbytes.com/cymconductor/internal/orchestrator/registry"
+	"cymbytes.com/cymconductor/internal/orchestrator/retention"
+	"cymbytes.com/cymconductor/internal/orchestrator/scheduler"
+	"cymbytes.com/cymconductor/internal/orchestrator/scoring"
+	"cymbytes.com/cymconductor/internal/orchestrator/validator"
+	"cymbytes.com/cymconductor/internal/orchestrator/webhooks"
+	"cymbytes.com/cymconductor/pkg/dsl"
+)
+
+// redacted replaces secrets in printed configuration.
+const redacted = "[REDACTED]"
+
+// readConfig builds the effective configuration: defaults, then the config
+// file (if any), then environment overrides.
+func readConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		if err := loadConfig(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+	applyEnvOverrides(&cfg)
+	return cfg, nil
+}
+
+// Validate checks the configuration for values the orchestrator cannot run
+// with. Every problem is reported, not just the first.
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	// Server
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		fail("server.port must be between 1 and 65535 (got %d)", c.Server.Port)
+	}
+	if c.Server.ReadTimeout <= 0 {
+		fail("server.read_timeout must be positive")
+	}
+	if c.Server.WriteTimeout <= 0 {
+		fail("server.write_timeout must be positive")
+	}
+	for name, dir := range map[string]string{
+		"server.downloads_dir": c.Server.DownloadsDir,
+		"server.web_dir":       c.Server.WebDir,
+	} {
+		if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+			fail("%s %q is not a directory", name, dir)
+		}
+	}
+
+	// Auth
+	for _, origin := range c.Auth.AllowedOrigins {
+		if strings.TrimSpace(origin) == "" {
+			fail("auth.allowed_origins must not contain empty entries")
+			break
+		}
+	}
+
+	// Database
+	switch c.Database.Driver {
+	case DatabaseDriverSQLite, "":
+		if c.Database.Path == "" {
+			fail("database.path is required for the %s driver", DatabaseDriverSQLite)
+		} else if info, err := os.Stat(c.Database.Path); err == nil && info.IsDir() {
+			fail("database.path %q is a directory", c.Database.Path)
+		}
+		if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+			fail("database.max_open_conns and database.max_idle_conns must not be negative")
+		}
+	case DatabaseDriverMemory:
+	default:
+		fail("database.driver must be %s or %s (got %q)", DatabaseDriverSQLite, DatabaseDriverMemory, c.Database.Driver)
+	}
+
+	// Registry and scheduler
+	if c.Registry.HeartbeatTimeout <= 0 {
+		fail("registry.heartbeat_timeout must be positive")
+	}
+	if c.Registry.CleanupInterval <= 0 {
+		fail("registry.cleanup_interval must be positive")
+	}
+	if c.Scheduler.PollInterval <= 0 {
+		fail("scheduler.poll_interval must be positive")
+	}
+	if c.Scheduler.MaxJobsPerAgent < 1 {
+		fail("scheduler.max_jobs_per_agent must be at least 1")
+	}
+	if c.Scheduler.LongPollMax <= 0 {
+		fail("scheduler.long_poll_max must be positive")
+	} else if c.Scheduler.LongPollMax >= c.Server.ReadTimeout || c.Scheduler.LongPollMax >= c.Server.WriteTimeout {
+		fail("scheduler.long_poll_max (%s) must be below server.read_timeout and server.write_timeout", c.Scheduler.LongPollMax)
+	}
+
+	// Events
+	if c.Events.BufferSize < 0 {
+		fail("events.buffer_size must not be negative")
+	}
+
+	// Retention
+	if c.Retention.Interval < 0 || c.Retention.CheckpointInterval < 0 || c.Retention.VacuumInterval < 0 {
+		fail("retention intervals must not be negative")
+	}
+	if err := c.retentionConfig().Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if dir := c.Retention.ArchiveDir; dir != "" {
+		if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+			fail("retention.archive_dir %q is not a directory", dir)
+		}
+	}
+
+	// Integrations
+	errs = append(errs, validateForwarder("scoring", "engine_url", c.Scoring.Enabled, c.Scoring.EngineURL,
+		c.Scoring.RetryCount, c.Scoring.RetryDelay, c.Scoring.Timeout)...)
+	if _, err := c.scoringMapping(); err != nil {
+		fail("scoring.event_mapping: %v", err)
+	}
+	errs = append(errs, validateForwarder("messenger", "webhook_url", c.Messenger.Enabled, c.Messenger.WebhookURL,
+		c.Messenger.RetryCount, c.Messenger.RetryDelay, c.Messenger.Timeout)...)
+
+	// Outbox
+	if c.Outbox.PollInterval <= 0 {
+		fail("outbox.poll_interval must be positive")
+	}
+	if c.Outbox.BatchSize < 1 {
+		fail("outbox.batch_size must be at least 1")
+	}
+	if c.Outbox.MaxAttempts < 1 {
+		fail("outbox.max_attempts must be at least 1")
+	}
+	if c.Outbox.InitialBackoff <= 0 {
+		fail("outbox.initial_backoff must be positive")
+	} else if c.Outbox.MaxBackoff < c.Outbox.InitialBackoff {
+		fail("outbox.max_backoff (%s) must not be below outbox.initial_backoff (%s)", c.Outbox.MaxBackoff, c.Outbox.InitialBackoff)
+	}
+
+	// Export
+	if err := c.exportConfig().Validate(); err != nil {
+		fail("export: %v", err)
+	}
+
+	// Logging
+	if _, err := zerolog.ParseLevel(c.Logging.Level); err != nil || c.Logging.Level == "" {
+		fail("logging.level %q is not a valid level", c.Logging.Level)
+	}
+	if c.Logging.Format != "json" && c.Logging.Format != "console" {
+		fail("logging.format must be json or console (got %q)", c.Logging.Format)
+	}
+
+	// Validator policy
+	for _, action := range c.Validator.DeniedActions {
+		if !dsl.IsValidAction(dsl.ActionType(action)) {
+			fail("validator.denied_actions: unknown action type %q", action)
+		}
+	}
+	if c.Validator.MaxSteps < 0 {
+		fail("validator.max_steps must not be negative")
+	}
+	for _, path := range c.Validator.BlockedPaths {
+		if strings.TrimSpace(path) == "" {
+			fail("validator.blocked_paths must not contain empty entries")
+			break
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateForwarder checks the settings shared by the scoring and messenger
+// integrations. The URL is only required while the integration is enabled.
+func validateForwarder(section, urlKey string, enabled bool, rawURL string, retryCount int, retryDelay, timeout time.Duration) []error {
+	var errs []error
+	if enabled {
+		if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s.%s must be an http(s) URL (got %q)", section, urlKey, redactURL(rawURL)))
+		}
+	}
+	if retryCount < 0 {
+		errs = append(errs, fmt.Errorf("%s.retry_count must not be negative", section))
+	}
+	if retryDelay < 0 {
+		errs = append(errs, fmt.Errorf("%s.retry_delay must not be negative", section))
+	}
+	if timeout <= 0 {
+		errs = append(errs, fmt.Errorf("%s.timeout must be positive", section))
+	}
+	return errs
+}
+
+// Redacted returns a copy of the configuration that is safe to print or log.
+func (c Config) Redacted() Config {
+	if c.Auth.BootstrapKey != "" {
+		c.Auth.BootstrapKey = redacted
+	}
+	c.Scoring.EngineURL = redactURL(c.Scoring.EngineURL)
+	c.Messenger.WebhookURL = redactURL(c.Messenger.WebhookURL)
+
+	// Copy the sinks so the running configuration keeps its header values
+	c.Export.Sinks = append([]ExportSinkConfig(nil), c.Export.Sinks...)
+	for i, sink := range c.Export.Sinks {
+		c.Export.Sinks[i].URL = redactURL(sink.URL)
+		if len(sink.Headers) > 0 {
+			headers := make(map[string]string, len(sink.Headers))
+			for name := range sink.Headers {
+				headers[name] = redacted
+			}
+			c.Export.Sinks[i].Headers = headers
+		}
+	}
+	return c
+}
+
+// redactURL hides the password in a URL's user info and any query values,
+// which is where credentials end up in webhook URLs.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.User == nil && u.RawQuery == "") {
+		return raw
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		keys := make([]string, 0, len(q))
+		for key := range q {
+			keys = append(keys, key+"="+redacted)
+		}
+		sort.Strings(keys)
+		u.RawQuery = strings.Join(keys, "&")
+	}
+	return u.Redacted()
+}
+
+// checkConfig implements -check-config: it validates the effective
+// configuration, prints it with secrets redacted, and returns the exit code.
+func checkConfig(path string) int {
+	cfg, err := readConfig(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+
+	out, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print config: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(out)
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n")
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "  - %s\n", line)
+		}
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Configuration is valid\n")
+	return 0
+}
+
+// ============================================================
+// Component configuration
+// ============================================================
+
+func (c Config) registryConfig() registry.Config {
+	return registry.Config{
+		HeartbeatTimeout: c.Registry.HeartbeatTimeout,
+		CleanupInterval:  c.Registry.CleanupInterval,
+	}
+}
+
+func (c Config) schedulerConfig() scheduler.Config {
+	return scheduler.Config{
+		PollInterval:    c.Scheduler.PollInterval,
+		MaxJobsPerAgent: c.Scheduler.MaxJobsPerAgent,
+		LongPollMax:     c.Scheduler.LongPollMax,
+	}
+}
+
+func (c Config) retentionConfig() retention.Config {
+	return retention.Config{
+		Interval:           c.Retention.Interval,
+		BatchSize:          c.Retention.BatchSize,
+		ArchiveDir:         c.Retention.ArchiveDir,
+		Jobs:               c.Retention.Jobs,
+		Scenarios:          c.Retention.Scenarios,
+		WebhookDeliveries:  c.Retention.WebhookDeliveries,
+		Outbox:             c.Retention.Outbox,
+		AuditLog:           c.Retention.AuditLog,
+		CheckpointInterval: c.Retention.CheckpointInterval,
+		VacuumInterval:     c.Retention.VacuumInterval,
+	}
+}
+
+func (c Config) scoringConfig() scoring.Config {
+	// An invalid mapping is rejected by Validate; fall back to the defaults
+	mapping, _ := c.scoringMapping()
+	return scoring.Config{
+		Enabled:    c.Scoring.Enabled,
+		EngineURL:  c.Scoring.EngineURL,
+		RetryCount: c.Scoring.RetryCount,
+		RetryDelay: c.Scoring.RetryDelay,
+		Timeout:    c.Scoring.Timeout,
+		Mapping:    mapping,
+	}
+}
+
+// scoringMapping compiles the configured event mapping, or returns nil to use
+// the built-in rules.
+func (c Config) scoringMapping() (*scoring.Mapping, error) {
+	if len(c.Scoring.EventMapping) == 0 {
+		return nil, nil
+	}
+	rules := make([]scoring.Rule, len(c.Scoring.EventMapping))
+	for i, r := range c.Scoring.EventMapping {
+		rules[i] = scoring.Rule{
+			ActionTypes: r.ActionTypes,
+			Status:      r.Status,
+			When:        r.When,
+			EventType:   r.EventType,
+			Payload:     r.Payload,
+		}
+	}
+	return scoring.NewMapping(rules)
+}
+
+func (c Config) messengerConfig() webhooks.Config {
+	return webhooks.Config{
+		Enabled:      c.Messenger.Enabled,
+		MessengerURL: c.Messenger.WebhookURL,
+		RetryCount:   c.Messenger.RetryCount,
+		RetryDelay:   c.Messenger.RetryDelay,
+		Timeout:      c.Messenger.Timeout,
+	}
+}
+
+func (c Config) outboxConfig() outbox.Config {
+	return outbox.Config{
+		PollInterval:   c.Outbox.PollInterval,
+		BatchSize:      c.Outbox.BatchSize,
+		MaxAttempts:    c.Outbox.MaxAttempts,
+		InitialBackoff: c.Outbox.InitialBackoff,
+		MaxBackoff:     c.Outbox.MaxBackoff,
+	}
+}
+
+func (c Config) exportConfig() export.Config {
+	cfg := export.Config{
+		QueueSize: c.Export.QueueSize,
+	}
+	for _, s := range c.Export.Sinks {
+		cfg.Sinks = append(cfg.Sinks, export.SinkConfig{
+			Name:       s.Name,
+			Type:       s.Type,
+			EventTypes: s.EventTypes,
+			RetryCount: s.RetryCount,
+			RetryDelay: s.RetryDelay,
+			Timeout:    s.Timeout,
+			CloudEvents: export.CloudEventsConfig{
+				URL:        s.URL,
+				Mode:       s.Mode,
+				Source:     s.Source,
+				TypePrefix: s.TypePrefix,
+				Headers:    s.Headers,
+			},
+			Syslog: export.SyslogConfig{
+				Network:            s.Network,
+				Address:            s.Address,
+				Format:             s.Format,
+				Facility:           s.Facility,
+				AppName:            s.AppName,
+				Hostname:           s.Hostname,
+				CAFile:             s.CAFile,
+				InsecureSkipVerify: s.InsecureSkipVerify,
+				ProductVersion:     Version,
+			},
+		})
+	}
+	return cfg
+}
+
+func (c Config) validatorPolicy() validator.Policy {
+	policy := validator.Policy{
+		MaxSteps:     c.Validator.MaxSteps,
+		BlockedPaths: c.Validator.BlockedPaths,
+	}
+	for _, action := range c.Validator.DeniedActions {
+		policy.DeniedActions = append(policy.DeniedActions, dsl.ActionType(action))
+	}
+	return policy
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,10 +16,17 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"cymbytes.com/cymconductor/internal/orchestrator/api"
+	"cymbytes.com/cymconductor/internal/orchestrator/auth"
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+	"cymbytes.com/cymconductor/internal/orchestrator/export"
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+	"cymbytes.com/cymconductor/internal/orchestrator/outbox"
 	"cymbytes.com/cymconductor/internal/orchestrator/registry"
+	"cymbytes.com/cymconductor/internal/orchestrator/retention"
 	"cymbytes.com/cymconductor/internal/orchestrator/scheduler"
 	"cymbytes.com/cymconductor/internal/orchestrator/scoring"
 	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/internal/orchestrator/validator"
 	"cymbytes.com/cymconductor/internal/orchestrator/webhooks"
 )
 
@@ -32,14 +40,20 @@ var (
 // Config holds the complete orchestrator configuration.
 type Config struct {
 	Server    ServerConfig    `yaml:"server"`
+	Auth      AuthConfig      `yaml:"auth"`
 	Database  DatabaseConfig  `yaml:"database"`
 	Registry  RegistryConfig  `yaml:"registry"`
 	Scheduler SchedulerConfig `yaml:"scheduler"`
+	Events    EventsConfig    `yaml:"events"`
+	Retention RetentionConfig `yaml:"retention"`
 	Scoring   ScoringConfig   `yaml:"scoring"`
 	Messenger MessengerConfig `yaml:"messenger"`
+	Outbox    OutboxConfig    `yaml:"outbox"`
+	Export    ExportConfig    `yaml:"export"`
 	Azure     AzureConfig     `yaml:"azure"`
 	Logging   LoggingConfig   `yaml:"logging"`
 	Intents   IntentsConfig   `yaml:"intents"`
+	Validator ValidatorConfig `yaml:"validator"`
 }
 
 // MessengerConfig holds messenger webhook integration settings.
@@ -61,8 +75,23 @@ type ServerConfig struct {
 	WebDir       string        `yaml:"web_dir"`
 }
 
-// DatabaseConfig holds SQLite settings.
+// AuthConfig holds API authentication settings.
+type AuthConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	BootstrapKey   string   `yaml:"bootstrap_key"`
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// Database drivers.
+const (
+	DatabaseDriverSQLite = "sqlite"
+	DatabaseDriverMemory = "memory"
+)
+
+// DatabaseConfig holds storage settings. Path and the connection settings
+// apply to the SQLite driver only.
 type DatabaseConfig struct {
+	Driver       string `yaml:"driver"`
 	Path         string `yaml:"path"`
 	MaxOpenConns int    `yaml:"max_open_conns"`
 	MaxIdleConns int    `yaml:"max_idle_conns"`
@@ -79,6 +108,27 @@ type RegistryConfig struct {
 type SchedulerConfig struct {
 	PollInterval    time.Duration `yaml:"poll_interval"`
 	MaxJobsPerAgent int           `yaml:"max_jobs_per_agent"`
+	LongPollMax     time.Duration `yaml:"long_poll_max"`
+}
+
+// EventsConfig holds event bus settings.
+type EventsConfig struct {
+	BufferSize int `yaml:"buffer_size"`
+}
+
+// RetentionConfig holds data retention policies and database maintenance
+// schedules. Policies map a finished status to how long rows are kept.
+type RetentionConfig struct {
+	Interval           time.Duration            `yaml:"interval"`
+	BatchSize          int                      `yaml:"batch_size"`
+	ArchiveDir         string                   `yaml:"archive_dir"`
+	Jobs               map[string]time.Duration `yaml:"jobs"`
+	Scenarios          map[string]time.Duration `yaml:"scenarios"`
+	WebhookDeliveries  map[string]time.Duration `yaml:"webhook_deliveries"`
+	Outbox             map[string]time.Duration `yaml:"outbox"`
+	AuditLog           time.Duration            `yaml:"audit_log"`
+	CheckpointInterval time.Duration            `yaml:"checkpoint_interval"`
+	VacuumInterval     time.Duration            `yaml:"vacuum_interval"`
 }
 
 // ScoringConfig holds scoring engine integration settings.
@@ -88,6 +138,62 @@ type ScoringConfig struct {
 	RetryCount int           `yaml:"retry_count"`
 	RetryDelay time.Duration `yaml:"retry_delay"`
 	Timeout    time.Duration `yaml:"timeout"`
+
+	// EventMapping replaces the built-in rules that decide which job results
+	// are forwarded and as which events
+	EventMapping []ScoringRuleConfig `yaml:"event_mapping"`
+}
+
+// ScoringRuleConfig maps matching job results to a scoring event.
+type ScoringRuleConfig struct {
+	ActionTypes []string          `yaml:"action_types"`
+	Status      string            `yaml:"status"`
+	When        []string          `yaml:"when"`
+	EventType   string            `yaml:"event_type"`
+	Payload     map[string]string `yaml:"payload"`
+}
+
+// OutboxConfig holds delivery settings for the scoring and messenger events
+// queued in the outbox.
+type OutboxConfig struct {
+	PollInterval   time.Duration `yaml:"poll_interval"`
+	BatchSize      int           `yaml:"batch_size"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+// ExportConfig holds the sinks orchestrator events are exported to.
+type ExportConfig struct {
+	QueueSize int                `yaml:"queue_size"`
+	Sinks     []ExportSinkConfig `yaml:"sinks"`
+}
+
+// ExportSinkConfig configures one export sink. The url, mode, source,
+// type_prefix and headers settings apply to cloudevents sinks; the rest of
+// the settings below them apply to syslog sinks.
+type ExportSinkConfig struct {
+	Name       string        `yaml:"name"`
+	Type       string        `yaml:"type"`
+	EventTypes []string      `yaml:"event_types"`
+	RetryCount int           `yaml:"retry_count"`
+	RetryDelay time.Duration `yaml:"retry_delay"`
+	Timeout    time.Duration `yaml:"timeout"`
+
+	URL        string            `yaml:"url,omitempty"`
+	Mode       string            `yaml:"mode,omitempty"`
+	Source     string            `yaml:"source,omitempty"`
+	TypePrefix string            `yaml:"type_prefix,omitempty"`
+	Headers    map[string]string `yaml:"headers,omitempty"`
+
+	Network            string `yaml:"network,omitempty"`
+	Address            string `yaml:"address,omitempty"`
+	Format             string `yaml:"format,omitempty"`
+	Facility           string `yaml:"facility,omitempty"`
+	AppName            string `yaml:"app_name,omitempty"`
+	Hostname           string `yaml:"hostname,omitempty"`
+	CAFile             string `yaml:"ca_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
 }
 
 // AzureConfig holds Azure Key Vault settings.
@@ -108,6 +214,14 @@ type IntentsConfig struct {
 	PollInterval   time.Duration `yaml:"poll_interval"`
 }
 
+// ValidatorConfig holds the scenario validation policy applied on top of the
+// built-in safety checks.
+type ValidatorConfig struct {
+	DeniedActions []string `yaml:"denied_actions"`
+	MaxSteps      int      `yaml:"max_steps"`
+	BlockedPaths  []string `yaml:"blocked_paths"`
+}
+
 // DefaultConfig returns a configuration with sensible defaults.
 func DefaultConfig() Config {
 	return Config{
@@ -120,6 +234,7 @@ func DefaultConfig() Config {
 			WebDir:       "./web",
 		},
 		Database: DatabaseConfig{
+			Driver:       DatabaseDriverSQLite,
 			Path:         "/data/orchestrator.db",
 			MaxOpenConns: 10,
 			MaxIdleConns: 5,
@@ -132,6 +247,15 @@ func DefaultConfig() Config {
 		Scheduler: SchedulerConfig{
 			PollInterval:    time.Second,
 			MaxJobsPerAgent: 5,
+			LongPollMax:     25 * time.Second,
+		},
+		Events: EventsConfig{
+			BufferSize: 1000,
+		},
+		Retention: RetentionConfig{
+			Interval:           time.Hour,
+			BatchSize:          500,
+			CheckpointInterval: 15 * time.Minute,
 		},
 		Scoring: ScoringConfig{
 			Enabled:    false,
@@ -147,6 +271,16 @@ func DefaultConfig() Config {
 			RetryDelay: time.Second,
 			Timeout:    10 * time.Second,
 		},
+		Outbox: OutboxConfig{
+			PollInterval:   2 * time.Second,
+			BatchSize:      50,
+			MaxAttempts:    12,
+			InitialBackoff: 5 * time.Second,
+			MaxBackoff:     15 * time.Minute,
+		},
+		Export: ExportConfig{
+			QueueSize: 1000,
+		},
 		Azure: AzureConfig{
 			KeyVaultURL:      "",
 			APIKeySecretName: "anthropic-api-key",
@@ -163,9 +297,19 @@ func DefaultConfig() Config {
 }
 
 func main() {
+	// Maintenance subcommands (backup, restore, export, import)
+	if len(os.Args) > 1 {
+		if _, ok := commands[os.Args[1]]; ok {
+			os.Exit(runCommand(os.Args[1], os.Args[2:]))
+		}
+	}
+
 	// Parse command line flags
 	configPath := flag.String("config", "", "Path to configuration file")
 	showVersion := flag.Bool("version", false, "Show version information")
+	restorePath := flag.String("restore", "", "Restore the database from this backup before starting")
+	demo := flag.Bool("demo", false, "Run with an in-memory store that is discarded on exit")
+	checkOnly := flag.Bool("check-config", false, "Validate the configuration, print it with secrets redacted, and exit")
 	flag.Parse()
 
 	if *showVersion {
@@ -176,17 +320,23 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Load configuration
-	cfg := DefaultConfig()
-	if *configPath != "" {
-		if err := loadConfig(*configPath, &cfg); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
-			os.Exit(1)
-		}
+	if *checkOnly {
+		os.Exit(checkConfig(*configPath))
 	}
 
-	// Apply environment variable overrides
-	applyEnvOverrides(&cfg)
+	// Load configuration (file, then environment variable overrides)
+	cfg, err := readConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+	if *demo {
+		cfg.Database.Driver = DatabaseDriverMemory
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
 
 	// Initialize logger
 	logger := initLogger(cfg.Logging)
@@ -199,64 +349,144 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Initialize database
-	db, err := storage.New(ctx, storage.Config{
-		Path:         cfg.Database.Path,
-		MaxOpenConns: cfg.Database.MaxOpenConns,
-		MaxIdleConns: cfg.Database.MaxIdleConns,
-		EnableWAL:    cfg.Database.EnableWAL,
-	}, logger)
-	if err != nil {
-		logger.Fatal().Err(err).Msg("Failed to initialize database")
+	// Initialize storage. sqliteDB is nil unless the SQLite driver is used.
+	var db storage.Store
+	var sqliteDB *storage.DB
+	switch cfg.Database.Driver {
+	case DatabaseDriverSQLite, "":
+		// Restore the database from a backup (if requested)
+		if *restorePath != "" {
+			if err := storage.Restore(ctx, *restorePath, cfg.Database.Path); err != nil {
+				logger.Fatal().Err(err).Str("backup", *restorePath).Msg("Failed to restore database")
+			}
+			logger.Info().Str("backup", *restorePath).Str("path", cfg.Database.Path).Msg("Database restored from backup")
+		}
+
+		sqliteDB, err = storage.New(ctx, storage.Config{
+			Path:         cfg.Database.Path,
+			MaxOpenConns: cfg.Database.MaxOpenConns,
+			MaxIdleConns: cfg.Database.MaxIdleConns,
+			EnableWAL:    cfg.Database.EnableWAL,
+		}, logger)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to initialize database")
+		}
+		db = sqliteDB
+	case DatabaseDriverMemory:
+		if *restorePath != "" {
+			logger.Fatal().Msg("-restore requires the sqlite database driver")
+		}
+		logger.Warn().Msg("Demo mode: using an in-memory store; all state is lost on exit")
+		db = storage.NewMemory(logger)
+	default:
+		logger.Fatal().Str("driver", cfg.Database.Driver).Msg("Unknown database driver (expected sqlite or memory)")
 	}
 	defer db.Close()
 
-	// Initialize registry
-	reg := registry.New(db, registry.Config{
-		HeartbeatTimeout: cfg.Registry.HeartbeatTimeout,
-		CleanupInterval:  cfg.Registry.CleanupInterval,
+	// Seed the bootstrap admin key (if configured)
+	if err := auth.EnsureBootstrapKey(ctx, db, cfg.Auth.BootstrapKey); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create bootstrap API key")
+	}
+	if cfg.Auth.Enabled {
+		logger.Info().Msg("API key authentication enabled")
+	} else {
+		logger.Warn().Msg("API key authentication disabled; all API requests are treated as admin")
+	}
+
+	// Initialize metrics
+	m := metrics.New()
+	if sqliteDB != nil {
+		m.RegisterDB(sqliteDB.GetDB(), "orchestrator")
+	}
+
+	// Initialize event bus
+	bus := events.New(events.Config{
+		BufferSize: cfg.Events.BufferSize,
 	}, logger)
+
+	// Initialize registry
+	reg := registry.New(db, cfg.registryConfig(), logger)
+	reg.SetEventBus(bus)
+	reg.SetMetrics(m)
 	reg.Start(ctx)
 	defer reg.Stop()
 
 	// Initialize scheduler
-	sched := scheduler.New(db, scheduler.Config{
-		PollInterval:    cfg.Scheduler.PollInterval,
-		MaxJobsPerAgent: cfg.Scheduler.MaxJobsPerAgent,
-	}, logger)
+	sched := scheduler.New(db, cfg.schedulerConfig(), logger)
+	sched.SetEventBus(bus)
+	sched.SetMetrics(m)
 	sched.Start(ctx)
 	defer sched.Stop()
 
-	// Initialize scoring forwarder (if enabled)
+	// Initialize retention janitor. The in-memory store is discarded on exit,
+	// so it needs no retention.
+	if sqliteDB != nil {
+		janitor := retention.New(sqliteDB, cfg.retentionConfig(), logger)
+		janitor.SetMetrics(m)
+		janitor.Start(ctx)
+		defer janitor.Stop()
+	}
+
+	// Initialize the scoring and messenger forwarders. Both always exist so a
+	// configuration reload can enable them; disabled forwarders send nothing.
+	scoringForwarder := scoring.NewEventForwarder(cfg.scoringConfig(), logger)
+	scoringForwarder.SetMetrics(m)
+	sched.SetScoringForwarder(scoringForwarder)
 	if cfg.Scoring.Enabled {
-		scoringForwarder := scoring.NewEventForwarder(scoring.Config{
-			Enabled:    cfg.Scoring.Enabled,
-			EngineURL:  cfg.Scoring.EngineURL,
-			RetryCount: cfg.Scoring.RetryCount,
-			RetryDelay: cfg.Scoring.RetryDelay,
-			Timeout:    cfg.Scoring.Timeout,
-		}, logger)
-		sched.SetScoringForwarder(scoringForwarder)
 		logger.Info().
-			Str("engine_url", cfg.Scoring.EngineURL).
+			Str("engine_url", redactURL(cfg.Scoring.EngineURL)).
 			Msg("Scoring engine integration enabled")
 	}
 
-	// Initialize messenger webhook forwarder (if enabled)
+	messengerForwarder := webhooks.NewForwarder(cfg.messengerConfig(), logger)
+	messengerForwarder.SetTargetResolver(func(ctx context.Context, labID string) string {
+		lab, err := db.GetLab(ctx, labID)
+		if err != nil || lab == nil {
+			return ""
+		}
+		return lab.WebhookURL
+	})
+	messengerForwarder.SetMetrics(m)
+	sched.SetMessengerForwarder(messengerForwarder)
 	if cfg.Messenger.Enabled {
-		messengerForwarder := webhooks.NewForwarder(webhooks.Config{
-			Enabled:      cfg.Messenger.Enabled,
-			MessengerURL: cfg.Messenger.WebhookURL,
-			RetryCount:   cfg.Messenger.RetryCount,
-			RetryDelay:   cfg.Messenger.RetryDelay,
-			Timeout:      cfg.Messenger.Timeout,
-		}, logger)
-		sched.SetMessengerForwarder(messengerForwarder)
 		logger.Info().
-			Str("webhook_url", cfg.Messenger.WebhookURL).
+			Str("webhook_url", redactURL(cfg.Messenger.WebhookURL)).
 			Msg("Messenger webhook integration enabled")
 	}
 
+	// Initialize the outbox worker that delivers scoring and messenger events
+	outboxWorker := outbox.New(db, cfg.outboxConfig(), logger)
+	outboxWorker.Register(storage.OutboxTargetScoring, scoringForwarder)
+	outboxWorker.Register(storage.OutboxTargetMessenger, messengerForwarder)
+	outboxWorker.SetMetrics(m)
+	sched.SetOutbox(outboxWorker)
+	outboxWorker.Start(ctx)
+	defer outboxWorker.Stop()
+
+	// Initialize webhook subscription delivery
+	dispatcher := webhooks.NewDispatcher(db, bus, logger)
+	dispatcher.SetMetrics(m)
+	if target := os.Getenv("TICKETS_WEBHOOK_URL"); target != "" {
+		seedWebhook(ctx, db, "tickets", target, os.Getenv("TICKETS_WEBHOOK_SECRET"), logger)
+	}
+	if err := dispatcher.Start(ctx); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to start webhook dispatcher")
+	}
+	defer dispatcher.Stop()
+
+	// Initialize event export to CloudEvents and syslog sinks
+	exporter, err := export.New(cfg.exportConfig(), bus, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create event exporter")
+	}
+	exporter.SetMetrics(m)
+	exporter.Start(ctx)
+	defer exporter.Stop()
+
+	// Initialize the scenario validator (imports are re-validated with it)
+	val := validator.New()
+	val.SetPolicy(cfg.validatorPolicy())
+
 	// Initialize API server
 	server := api.New(api.Config{
 		Host:         cfg.Server.Host,
@@ -265,10 +495,19 @@ func main() {
 		WriteTimeout: cfg.Server.WriteTimeout,
 		DownloadsDir: cfg.Server.DownloadsDir,
 		WebDir:       cfg.Server.WebDir,
+		Auth: auth.Config{
+			Enabled: cfg.Auth.Enabled,
+		},
+		AllowedOrigins: cfg.Auth.AllowedOrigins,
 	}, api.Dependencies{
 		DB:        db,
 		Registry:  reg,
 		Scheduler: sched,
+		Events:    bus,
+		Validator: val,
+		Webhooks:  dispatcher,
+		Outbox:    outboxWorker,
+		Metrics:   m,
 		Version:   Version,
 		StartTime: time.Now(),
 	}, logger)
@@ -285,10 +524,27 @@ func main() {
 		Int("port", cfg.Server.Port).
 		Msg("Orchestrator is ready")
 
-	// Wait for shutdown signal
+	// Reload the safe configuration sections on SIGHUP until a shutdown signal
+	config := &reloader{
+		path:      *configPath,
+		demo:      *demo,
+		running:   cfg,
+		registry:  reg,
+		scheduler: sched,
+		scoring:   scoringForwarder,
+		messenger: messengerForwarder,
+		validator: val,
+		db:        db,
+		logger:    logger,
+	}
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	sig := <-sigCh
+	for sig == syscall.SIGHUP {
+		logger.Info().Str("config", *configPath).Msg("Received SIGHUP; reloading configuration")
+		config.reload(ctx)
+		sig = <-sigCh
+	}
 
 	logger.Info().Str("signal", sig.String()).Msg("Received shutdown signal")
 
@@ -303,6 +559,48 @@ func main() {
 	logger.Info().Msg("Orchestrator stopped")
 }
 
+// seedWebhook creates a webhook subscription for a service configured through
+// the environment (e.g. TICKETS_WEBHOOK_URL), unless one with that name
+// already exists. Without a secret one is generated; rotate it through the
+// API to obtain it.
+func seedWebhook(ctx context.Context, db storage.Store, name, target, secret string, logger zerolog.Logger) {
+	subs, err := db.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		logger.Error().Err(err).Str("name", name).Msg("Failed to check webhook subscriptions")
+		return
+	}
+	for _, sub := range subs {
+		if sub.Name == name {
+			return
+		}
+	}
+
+	if secret == "" {
+		if secret, err = webhooks.GenerateSecret(); err != nil {
+			logger.Error().Err(err).Str("name", name).Msg("Failed to generate webhook secret")
+			return
+		}
+	}
+
+	sub := &storage.WebhookSubscription{
+		Name:         name,
+		URL:          target,
+		Secret:       secret,
+		Enabled:      true,
+		MaxRetries:   webhooks.DefaultMaxRetries,
+		RetryBackoff: webhooks.DefaultRetryBackoff,
+		Timeout:      webhooks.DefaultTimeout,
+	}
+	if err := db.CreateWebhookSubscription(ctx, sub); err != nil {
+		logger.Error().Err(err).Str("name", name).Msg("Failed to create webhook subscription")
+		return
+	}
+	logger.Info().
+		Str("name", name).
+		Str("url", redactURL(target)).
+		Msg("Created webhook subscription from the environment")
+}
+
 func loadConfig(path string, cfg *Config) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -317,7 +615,10 @@ func loadConfig(path string, cfg *Config) error {
 }
 
 func applyEnvOverrides(cfg *Config) {
-	// Database path
+	// Database
+	if v := os.Getenv("DATABASE_DRIVER"); v != "" {
+		cfg.Database.Driver = v
+	}
 	if v := os.Getenv("DATABASE_PATH"); v != "" {
 		cfg.Database.Path = v
 	}
@@ -356,10 +657,26 @@ func applyEnvOverrides(cfg *Config) {
 		cfg.Messenger.WebhookURL = v
 	}
 
+	// Retention archives
+	if v := os.Getenv("RETENTION_ARCHIVE_DIR"); v != "" {
+		cfg.Retention.ArchiveDir = v
+	}
+
 	// Web directory
 	if v := os.Getenv("WEB_DIR"); v != "" {
 		cfg.Server.WebDir = v
 	}
+
+	// Authentication
+	if v := os.Getenv("AUTH_ENABLED"); v == "true" || v == "1" {
+		cfg.Auth.Enabled = true
+	}
+	if v := os.Getenv("AUTH_BOOTSTRAP_KEY"); v != "" {
+		cfg.Auth.BootstrapKey = v
+	}
+	if v := os.Getenv("CORS_ALLOWED_ORIGINS"); v != "" {
+		cfg.Auth.AllowedOrigins = strings.Split(v, ",")
+	}
 }
 
 func initLogger(cfg LoggingConfig) zerolog.Logger {
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/registry"
+	"cymbytes.com/cymconductor/internal/orchestrator/scheduler"
+	"cymbytes.com/cymconductor/internal/orchestrator/scoring"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/internal/orchestrator/validator"
+	"cymbytes.com/cymconductor/internal/orchestrator/webhooks"
+)
+
+// reloader re-reads the configuration on SIGHUP and applies the sections that
+// are safe to change while serving: the log level, scheduler and registry
+// timing, the scoring and messenger integrations, and the validator policy.
+// Changes to any other section are reported as needing a restart.
+type reloader struct {
+	path string
+	demo bool
+
+	// running is the configuration currently in effect
+	running Config
+
+	registry  *registry.Registry
+	scheduler *scheduler.Scheduler
+	scoring   *scoring.EventForwarder
+	messenger *webhooks.Forwarder
+	validator *validator.Validator
+	db        storage.Store
+	logger    zerolog.Logger
+}
+
+// reload applies the configuration file and environment as they are now.
+// Every attempt, successful or not, is written to the audit log.
+func (r *reloader) reload(ctx context.Context) {
+	next, err := readConfig(r.path)
+	if err == nil {
+		if r.demo {
+			next.Database.Driver = DatabaseDriverMemory
+		}
+		err = next.Validate()
+	}
+	if err != nil {
+		r.logger.Error().Err(err).Str("config", r.path).Msg("Configuration reload rejected; keeping the running configuration")
+		r.db.Audit(ctx, storage.AuditEntityConfig, "orchestrator", storage.AuditActionReloadFailed, nil, nil, map[string]interface{}{
+			"path":  r.path,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Take the hot sections from the new configuration; everything else keeps
+	// running with the values it started with.
+	applied := r.running
+	applied.Logging.Level = next.Logging.Level
+	applied.Registry = next.Registry
+	applied.Scheduler = next.Scheduler
+	applied.Scoring = next.Scoring
+	applied.Messenger = next.Messenger
+	applied.Validator = next.Validator
+
+	changed := diffSections(r.running, applied)
+	restart := diffSections(applied, next)
+
+	if level, err := zerolog.ParseLevel(applied.Logging.Level); err == nil {
+		zerolog.SetGlobalLevel(level)
+	}
+	r.registry.UpdateConfig(applied.registryConfig())
+	r.scheduler.UpdateConfig(applied.schedulerConfig())
+	r.scoring.UpdateConfig(applied.scoringConfig())
+	r.messenger.UpdateConfig(applied.messengerConfig())
+	r.validator.SetPolicy(applied.validatorPolicy())
+
+	oldValue := sectionValues(r.running, changed)
+	newValue := sectionValues(applied, changed)
+	r.running = applied
+
+	if len(restart) > 0 {
+		r.logger.Warn().Strs("sections", restart).Msg("Configuration changes that require a restart were not applied")
+	}
+	r.logger.Info().Strs("changed", changed).Msg("Configuration reloaded")
+
+	r.db.Audit(ctx, storage.AuditEntityConfig, "orchestrator", storage.AuditActionReloaded, oldValue, newValue, map[string]interface{}{
+		"path":             r.path,
+		"changed":          changed,
+		"restart_required": restart,
+	})
+}
+
+// configSections returns each top-level section of cfg (by its YAML key) with
+// secrets redacted when redact is set.
+func configSections(cfg Config, redact bool) map[string]interface{} {
+	if redact {
+		cfg = cfg.Redacted()
+	}
+	sections := map[string]interface{}{}
+	data, err := yaml.Marshal(cfg)
+	if err == nil {
+		err = yaml.Unmarshal(data, &sections)
+	}
+	if err != nil {
+		return nil
+	}
+	return sections
+}
+
+// diffSections lists the top-level sections that differ between a and b.
+func diffSections(a, b Config) []string {
+	before, after := configSections(a, false), configSections(b, false)
+	var changed []string
+	for name, value := range after {
+		if !reflect.DeepEqual(before[name], value) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// sectionValues returns the named sections of cfg for an audit entry, with
+// secrets redacted. It returns nil if no sections are named.
+func sectionValues(cfg Config, names []string) map[string]interface{} {
+	if len(names) == 0 {
+		return nil
+	}
+	sections := configSections(cfg, true)
+	values := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		values[name] = sections[name]
+	}
+	return values
+}
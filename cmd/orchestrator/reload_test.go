@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/registry"
+	"cymbytes.com/cymconductor/internal/orchestrator/scheduler"
+	"cymbytes.com/cymconductor/internal/orchestrator/scoring"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/internal/orchestrator/validator"
+	"cymbytes.com/cymconductor/internal/orchestrator/webhooks"
+)
+
+func writeConfig(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func lastAudit(t *testing.T, db storage.Store) *storage.AuditEntry {
+	t.Helper()
+	entries, err := db.ListAuditEntries(context.Background(), storage.AuditFilter{EntityType: storage.AuditEntityConfig, Limit: 1})
+	if err != nil {
+		t.Fatalf("ListAuditEntries() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected a config audit entry, got %d", len(entries))
+	}
+	return entries[0]
+}
+
+func TestReloader_AppliesHotSections(t *testing.T) {
+	level := zerolog.GlobalLevel()
+	defer zerolog.SetGlobalLevel(level)
+
+	path := filepath.Join(t.TempDir(), "orchestrator.yaml")
+	writeConfig(t, path, "database:\n  driver: memory\n")
+
+	cfg, err := readConfig(path)
+	if err != nil {
+		t.Fatalf("readConfig() error = %v", err)
+	}
+	logger := zerolog.Nop()
+	db := storage.NewMemory(logger)
+	val := validator.New()
+	val.SetPolicy(cfg.validatorPolicy())
+	r := &reloader{
+		path:      path,
+		running:   cfg,
+		registry:  registry.New(db, cfg.registryConfig(), logger),
+		scheduler: scheduler.New(db, cfg.schedulerConfig(), logger),
+		scoring:   scoring.NewEventForwarder(cfg.scoringConfig(), logger),
+		messenger: webhooks.NewForwarder(cfg.messengerConfig(), logger),
+		validator: val,
+		db:        db,
+		logger:    logger,
+	}
+
+	// A hot change to two sections and a restart-only change to the server
+	writeConfig(t, path, `database:
+  driver: memory
+server:
+  port: 9090
+scheduler:
+  long_poll_max: 10s
+validator:
+  max_steps: 5
+`)
+	r.reload(context.Background())
+
+	if got := r.scheduler.LongPollMax(); got != 10*time.Second {
+		t.Errorf("Expected the scheduler to use the new long poll, got %s", got)
+	}
+	if got := r.validator.Policy().MaxSteps; got != 5 {
+		t.Errorf("Expected the validator to use the new policy, got max_steps %d", got)
+	}
+	if r.running.Server.Port != cfg.Server.Port {
+		t.Errorf("Expected the server port to wait for a restart, got %d", r.running.Server.Port)
+	}
+
+	entry := lastAudit(t, db)
+	if entry.Action != storage.AuditActionReloaded {
+		t.Fatalf("Expected action %q, got %q", storage.AuditActionReloaded, entry.Action)
+	}
+	if got := fmt.Sprint(entry.Metadata["changed"]); got != "[scheduler validator]" {
+		t.Errorf("Expected changed sections [scheduler validator], got %s", got)
+	}
+	if got := fmt.Sprint(entry.Metadata["restart_required"]); got != "[server]" {
+		t.Errorf("Expected restart_required [server], got %s", got)
+	}
+
+	// An invalid file is rejected and the running configuration stays
+	writeConfig(t, path, "database:\n  driver: memory\nscheduler:\n  long_poll_max: 5m\n")
+	r.reload(context.Background())
+
+	if got := r.scheduler.LongPollMax(); got != 10*time.Second {
+		t.Errorf("Expected the rejected reload to keep the long poll, got %s", got)
+	}
+	if r.running.Scheduler.LongPollMax != 10*time.Second {
+		t.Errorf("Expected the running configuration to be kept, got %s", r.running.Scheduler.LongPollMax)
+	}
+	if entry := lastAudit(t, db); entry.Action != storage.AuditActionReloadFailed {
+		t.Errorf("Expected action %q, got %q", storage.AuditActionReloadFailed, entry.Action)
+	}
+}
+
+func TestDiffSections(t *testing.T) {
+	a := DefaultConfig()
+	b := DefaultConfig()
+	if changed := diffSections(a, b); len(changed) != 0 {
+		t.Errorf("Expected no changes, got %v", changed)
+	}
+
+	b.Logging.Level = "debug"
+	b.Outbox.BatchSize = 7
+	if got := fmt.Sprint(diffSections(a, b)); got != "[logging outbox]" {
+		t.Errorf("Expected [logging outbox], got %s", got)
+	}
+}
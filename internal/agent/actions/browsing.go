@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"os"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -24,6 +25,19 @@ func NewBrowsingHandler(cfg BrowsingConfig, logger zerolog.Logger) *BrowsingHand
 	}
 }
 
+// Check reports a configured browser that is missing, e.g. on a headless
+// host built from a desktop image. Without a browser path the activity is
+// only simulated, which works everywhere.
+func (h *BrowsingHandler) Check() error {
+	if h.config.BrowserPath == "" {
+		return nil
+	}
+	if _, err := os.Stat(h.config.BrowserPath); err != nil {
+		return fmt.Errorf("browser not found at %s", h.config.BrowserPath)
+	}
+	return nil
+}
+
 // Execute simulates web browsing activity.
 func (h *BrowsingHandler) Execute(ctx context.Context, params map[string]interface{}) (*Result, error) {
 	startTime := time.Now()
@@ -28,6 +28,14 @@ func NewCapturePowerShellHandler(logger zerolog.Logger) *CapturePowerShellHandle
 	}
 }
 
+// Check reports that PowerShell history is only read on Windows hosts.
+func (h *CapturePowerShellHandler) Check() error {
+	if runtime.GOOS != "windows" {
+		return fmt.Errorf("only supported on Windows")
+	}
+	return nil
+}
+
 // CapturedCommand represents a command found in history.
 type CapturedCommand struct {
 	Command   string `json:"command"`
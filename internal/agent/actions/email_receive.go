@@ -115,6 +115,16 @@ func NewEmailReceiveHandler(cfg EmailReceiveConfig, logger zerolog.Logger) *Emai
 	return h
 }
 
+// Backends returns "imap", plus "outlook" when Outlook is enabled on a
+// Windows host.
+func (h *EmailReceiveHandler) Backends() []string {
+	backends := []string{h.imapBackend.Name()}
+	if h.outlookBackend != nil {
+		backends = append(backends, h.outlookBackend.Name())
+	}
+	return backends
+}
+
 // Execute handles the email_receive action.
 func (h *EmailReceiveHandler) Execute(ctx context.Context, params map[string]interface{}) (*Result, error) {
 	startTime := time.Now()
@@ -576,6 +586,9 @@ func (h *EmailReceiveHandler) executeFile(ctx context.Context, filePath string)
 			cmd = exec.CommandContext(ctx, "xdg-open", filePath)
 		}
 	}
+	if wm, ok := watermarkFrom(ctx); ok {
+		cmd.Env = wm.Env()
+	}
 
 	if err := cmd.Start(); err != nil {
 		return 0, fmt.Errorf("failed to start: %w", err)
@@ -97,7 +97,7 @@ func (h *EmailTrafficHandler) Execute(ctx context.Context, params map[string]int
 				subject := fmt.Sprintf("%s - %d", subjectTemplate, time.Now().UnixNano())
 				body := fmt.Sprintf("%s\n\nSent at: %s", bodyTemplate, time.Now().Format(time.RFC3339))
 
-				err := h.sendEmail(server, port, username, password, recipient, subject, body)
+				err := h.sendEmail(ctx, server, port, username, password, recipient, subject, body)
 				if err != nil {
 					h.logger.Warn().Err(err).Str("recipient", recipient).Msg("Failed to send email")
 					emailsFailed++
@@ -138,15 +138,14 @@ func (h *EmailTrafficHandler) Execute(ctx context.Context, params map[string]int
 	}, nil
 }
 
-func (h *EmailTrafficHandler) sendEmail(server string, port int, username, password, recipient, subject, body string) error {
+func (h *EmailTrafficHandler) sendEmail(ctx context.Context, server string, port int, username, password, recipient, subject, body string) error {
 	addr := fmt.Sprintf("%s:%d", server, port)
 	from := username
 	if from == "" {
 		from = "agent@cymbytes.local"
 	}
 
-	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
-		from, recipient, subject, body)
+	msg := buildMessage(ctx, from, recipient, subject, body)
 
 	var auth smtp.Auth
 	if username != "" && password != "" {
@@ -160,3 +159,16 @@ func (h *EmailTrafficHandler) sendEmail(server string, port int, username, passw
 
 	return nil
 }
+
+// buildMessage formats an email, adding the watermark headers when the job is
+// watermarked.
+func buildMessage(ctx context.Context, from, recipient, subject, body string) string {
+	headers := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n", from, recipient, subject)
+	if wm, ok := watermarkFrom(ctx); ok {
+		headers += fmt.Sprintf("%s: %s\r\n", EmailJobHeader, wm.JobID)
+		if wm.ScenarioID != "" {
+			headers += fmt.Sprintf("%s: %s\r\n", EmailScenarioHeader, wm.ScenarioID)
+		}
+	}
+	return headers + "\r\n" + body
+}
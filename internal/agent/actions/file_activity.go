@@ -101,7 +101,7 @@ fileLoop:
 		switch op {
 		case "create":
 			size := (fileSizeMin + mathrand.Intn(fileSizeMax-fileSizeMin+1)) * 1024
-			if err := h.createFile(filePath, size); err != nil {
+			if err := h.createFile(ctx, filePath, size); err != nil {
 				h.logger.Warn().Err(err).Str("file", filePath).Msg("Failed to create file")
 			} else {
 				filesCreated++
@@ -112,7 +112,7 @@ fileLoop:
 			// Modify an existing file or create if none exist
 			if len(createdFiles) > 0 {
 				targetFile := createdFiles[mathrand.Intn(len(createdFiles))]
-				if err := h.modifyFile(targetFile); err != nil {
+				if err := h.modifyFile(ctx, targetFile); err != nil {
 					h.logger.Warn().Err(err).Str("file", targetFile).Msg("Failed to modify file")
 				} else {
 					filesModified++
@@ -120,7 +120,7 @@ fileLoop:
 			} else {
 				// Create a file instead
 				size := (fileSizeMin + mathrand.Intn(fileSizeMax-fileSizeMin+1)) * 1024
-				if err := h.createFile(filePath, size); err == nil {
+				if err := h.createFile(ctx, filePath, size); err == nil {
 					filesCreated++
 					createdFiles = append(createdFiles, filePath)
 				}
@@ -219,17 +219,20 @@ func (h *FileActivityHandler) isAllowedDirectory(dir string) bool {
 	return false
 }
 
-func (h *FileActivityHandler) createFile(path string, size int) error {
+func (h *FileActivityHandler) createFile(ctx context.Context, path string, size int) error {
 	// Generate random content
 	content := make([]byte, size)
 	if _, err := rand.Read(content); err != nil {
 		return fmt.Errorf("failed to generate random content: %w", err)
 	}
+	if wm, ok := watermarkFrom(ctx); ok {
+		content = append(content, wm.FileTrailer()...)
+	}
 
 	return os.WriteFile(path, content, 0644)
 }
 
-func (h *FileActivityHandler) modifyFile(path string) error {
+func (h *FileActivityHandler) modifyFile(ctx context.Context, path string) error {
 	// Read existing content
 	content, err := os.ReadFile(path)
 	if err != nil {
@@ -243,6 +246,11 @@ func (h *FileActivityHandler) modifyFile(path string) error {
 	}
 	content = append(content, extra...)
 
+	// Each modification is watermarked with the job that made it
+	if wm, ok := watermarkFrom(ctx); ok {
+		content = append(content, wm.FileTrailer()...)
+	}
+
 	return os.WriteFile(path, content, 0644)
 }
 
@@ -27,6 +27,14 @@ func NewObserveUserHandler(logger zerolog.Logger) *ObserveUserHandler {
 	}
 }
 
+// Check reports that AD user queries need a Windows host.
+func (h *ObserveUserHandler) Check() error {
+	if runtime.GOOS != "windows" {
+		return fmt.Errorf("only supported on Windows")
+	}
+	return nil
+}
+
 // Execute checks the state of an AD user account.
 // Parameters:
 //   - username: SAM account name of the user (e.g., "jsmith")
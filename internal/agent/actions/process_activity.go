@@ -69,7 +69,7 @@ spawnLoop:
 		}
 
 		proc := allowedProcesses[rand.Intn(len(allowedProcesses))]
-		cmd, err := h.spawnProcess(proc)
+		cmd, err := h.spawnProcess(ctx, proc)
 		if err != nil {
 			h.logger.Warn().Err(err).Str("process", proc).Msg("Failed to spawn process")
 			continue
@@ -130,7 +130,7 @@ func (h *ProcessActivityHandler) isAllowedProcess(proc string) bool {
 	return false
 }
 
-func (h *ProcessActivityHandler) spawnProcess(proc string) (*exec.Cmd, error) {
+func (h *ProcessActivityHandler) spawnProcess(ctx context.Context, proc string) (*exec.Cmd, error) {
 	var cmd *exec.Cmd
 
 	switch runtime.GOOS {
@@ -141,6 +141,9 @@ func (h *ProcessActivityHandler) spawnProcess(proc string) (*exec.Cmd, error) {
 		// On Linux/Mac, launch directly
 		cmd = exec.Command(proc)
 	}
+	if wm, ok := watermarkFrom(ctx); ok {
+		cmd.Env = wm.Env()
+	}
 
 	if err := cmd.Start(); err != nil {
 		return nil, fmt.Errorf("failed to start process: %w", err)
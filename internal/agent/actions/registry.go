@@ -4,6 +4,7 @@ package actions
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	"github.com/rs/zerolog"
 )
@@ -21,6 +22,31 @@ type Handler interface {
 	Execute(ctx context.Context, params map[string]interface{}) (*Result, error)
 }
 
+// Checker is implemented by handlers that cannot run on every host or
+// configuration.
+type Checker interface {
+	// Check returns why the handler cannot run on this agent, or nil.
+	Check() error
+}
+
+// BackendLister is implemented by handlers with more than one backend.
+type BackendLister interface {
+	// Backends returns the backends available on this agent.
+	Backends() []string
+}
+
+// Capabilities describes which registered actions can run on this agent.
+type Capabilities struct {
+	// Actions that can run, sorted
+	Actions []string
+
+	// Unavailable maps actions that cannot run to the reason
+	Unavailable map[string]string
+
+	// Backends lists the available backends of multi-backend actions
+	Backends map[string][]string
+}
+
 // Registry holds all available action handlers.
 type Registry struct {
 	handlers map[string]Handler
@@ -128,3 +154,28 @@ func (r *Registry) ListActions() []string {
 	}
 	return actions
 }
+
+// Capabilities checks every handler and reports what this agent can run.
+func (r *Registry) Capabilities() Capabilities {
+	caps := Capabilities{
+		Actions:     make([]string, 0, len(r.handlers)),
+		Unavailable: make(map[string]string),
+		Backends:    make(map[string][]string),
+	}
+
+	for action, handler := range r.handlers {
+		if checker, ok := handler.(Checker); ok {
+			if err := checker.Check(); err != nil {
+				caps.Unavailable[action] = err.Error()
+				continue
+			}
+		}
+		caps.Actions = append(caps.Actions, action)
+		if lister, ok := handler.(BackendLister); ok {
+			caps.Backends[action] = lister.Backends()
+		}
+	}
+	sort.Strings(caps.Actions)
+
+	return caps
+}
@@ -0,0 +1,77 @@
+// Package actions provides predefined action implementations for the agent.
+package actions
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// Watermark headers and environment variables. Analysts match SIEM events to
+// orchestrator jobs by these values.
+const (
+	// Email headers carrying the job and scenario IDs
+	EmailJobHeader      = "X-CymConductor-Job"
+	EmailScenarioHeader = "X-CymConductor-Scenario"
+
+	// Environment variables set on spawned processes
+	EnvJobID      = "CYMCONDUCTOR_JOB_ID"
+	EnvScenarioID = "CYMCONDUCTOR_SCENARIO_ID"
+	EnvStepID     = "CYMCONDUCTOR_STEP_ID"
+
+	// fileTrailerPrefix starts the line appended to generated files
+	fileTrailerPrefix = "CYMCONDUCTOR-WATERMARK"
+)
+
+// Watermark identifies the job an action runs for. When the agent runs with
+// watermarking enabled, handlers embed it in the artifacts they create.
+type Watermark struct {
+	JobID      string
+	ScenarioID string
+	StepID     string
+}
+
+type watermarkKey struct{}
+
+// WithWatermark returns a context that makes handlers watermark their
+// artifacts with wm.
+func WithWatermark(ctx context.Context, wm Watermark) context.Context {
+	return context.WithValue(ctx, watermarkKey{}, wm)
+}
+
+// watermarkFrom returns the watermark of ctx, if any.
+func watermarkFrom(ctx context.Context) (Watermark, bool) {
+	wm, ok := ctx.Value(watermarkKey{}).(Watermark)
+	return wm, ok && wm.JobID != ""
+}
+
+// String returns the watermark as "job=<id> scenario=<id> step=<id>",
+// leaving out empty IDs.
+func (w Watermark) String() string {
+	parts := []string{"job=" + w.JobID}
+	if w.ScenarioID != "" {
+		parts = append(parts, "scenario="+w.ScenarioID)
+	}
+	if w.StepID != "" {
+		parts = append(parts, "step="+w.StepID)
+	}
+	return strings.Join(parts, " ")
+}
+
+// FileTrailer returns the line appended to generated files.
+func (w Watermark) FileTrailer() []byte {
+	return []byte("\n" + fileTrailerPrefix + " " + w.String() + "\n")
+}
+
+// Env returns the agent's environment with the watermark variables added, for
+// processes the agent spawns.
+func (w Watermark) Env() []string {
+	env := append(os.Environ(), EnvJobID+"="+w.JobID)
+	if w.ScenarioID != "" {
+		env = append(env, EnvScenarioID+"="+w.ScenarioID)
+	}
+	if w.StepID != "" {
+		env = append(env, EnvStepID+"="+w.StepID)
+	}
+	return env
+}
@@ -0,0 +1,192 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestWatermark_FileTrailer(t *testing.T) {
+	tests := []struct {
+		name string
+		wm   Watermark
+		want string
+	}{
+		{
+			name: "all IDs",
+			wm:   Watermark{JobID: "job-1", ScenarioID: "scn-1", StepID: "step-1"},
+			want: "\nCYMCONDUCTOR-WATERMARK job=job-1 scenario=scn-1 step=step-1\n",
+		},
+		{
+			name: "ad-hoc job",
+			wm:   Watermark{JobID: "job-2"},
+			want: "\nCYMCONDUCTOR-WATERMARK job=job-2\n",
+		},
+		{
+			name: "scenario without step",
+			wm:   Watermark{JobID: "job-3", ScenarioID: "scn-3"},
+			want: "\nCYMCONDUCTOR-WATERMARK job=job-3 scenario=scn-3\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(tt.wm.FileTrailer()); got != tt.want {
+				t.Errorf("FileTrailer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatermark_Env(t *testing.T) {
+	t.Setenv("CYMCONDUCTOR_TEST_INHERITED", "yes")
+
+	tests := []struct {
+		name    string
+		wm      Watermark
+		want    []string
+		missing []string
+	}{
+		{
+			name: "all IDs",
+			wm:   Watermark{JobID: "job-1", ScenarioID: "scn-1", StepID: "step-1"},
+			want: []string{EnvJobID + "=job-1", EnvScenarioID + "=scn-1", EnvStepID + "=step-1"},
+		},
+		{
+			name:    "ad-hoc job",
+			wm:      Watermark{JobID: "job-2"},
+			want:    []string{EnvJobID + "=job-2"},
+			missing: []string{EnvScenarioID, EnvStepID},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.wm.Env()
+			has := func(entry string) bool {
+				for _, e := range env {
+					if e == entry || strings.HasPrefix(e, entry+"=") {
+						return true
+					}
+				}
+				return false
+			}
+
+			// The agent's own environment is inherited
+			if !has("CYMCONDUCTOR_TEST_INHERITED=yes") {
+				t.Error("Expected the agent environment to be inherited")
+			}
+			for _, want := range tt.want {
+				if !has(want) {
+					t.Errorf("Expected %s in the environment", want)
+				}
+			}
+			for _, name := range tt.missing {
+				if has(name) {
+					t.Errorf("Expected no %s in the environment", name)
+				}
+			}
+		})
+	}
+}
+
+func TestWatermarkFrom(t *testing.T) {
+	if _, ok := watermarkFrom(context.Background()); ok {
+		t.Error("Expected no watermark without WithWatermark")
+	}
+	if _, ok := watermarkFrom(WithWatermark(context.Background(), Watermark{ScenarioID: "scn-1"})); ok {
+		t.Error("Expected a watermark without a job ID to be ignored")
+	}
+	wm, ok := watermarkFrom(WithWatermark(context.Background(), Watermark{JobID: "job-1"}))
+	if !ok || wm.JobID != "job-1" {
+		t.Errorf("Expected watermark job-1, got %+v (%v)", wm, ok)
+	}
+}
+
+func TestBuildMessage_WatermarkHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		want    []string
+		missing []string
+	}{
+		{
+			name:    "not watermarked",
+			ctx:     context.Background(),
+			missing: []string{EmailJobHeader, EmailScenarioHeader},
+		},
+		{
+			name: "scenario job",
+			ctx:  WithWatermark(context.Background(), Watermark{JobID: "job-1", ScenarioID: "scn-1"}),
+			want: []string{"X-CymConductor-Job: job-1\r\n", "X-CymConductor-Scenario: scn-1\r\n"},
+		},
+		{
+			name:    "ad-hoc job",
+			ctx:     WithWatermark(context.Background(), Watermark{JobID: "job-2"}),
+			want:    []string{"X-CymConductor-Job: job-2\r\n"},
+			missing: []string{EmailScenarioHeader},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := buildMessage(tt.ctx, "agent@lab.local", "user@lab.local", "Report", "Body text")
+
+			headers, body, ok := strings.Cut(msg, "\r\n\r\n")
+			if !ok || body != "Body text" {
+				t.Fatalf("Expected headers and body separated by a blank line, got %q", msg)
+			}
+			if !strings.HasPrefix(headers, "From: agent@lab.local\r\nTo: user@lab.local\r\nSubject: Report") {
+				t.Errorf("Unexpected headers: %q", headers)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(headers+"\r\n", want) {
+					t.Errorf("Expected header %q in %q", want, headers)
+				}
+			}
+			for _, name := range tt.missing {
+				if strings.Contains(headers, name) {
+					t.Errorf("Expected no %s header in %q", name, headers)
+				}
+			}
+		})
+	}
+}
+
+func TestFileActivityHandler_WatermarksFiles(t *testing.T) {
+	dir := t.TempDir()
+	h := NewFileActivityHandler(FileActivityConfig{AllowedDirectories: []string{dir}}, zerolog.Nop())
+	wm := Watermark{JobID: "job-1", ScenarioID: "scn-1"}
+	ctx := WithWatermark(context.Background(), wm)
+
+	path := filepath.Join(dir, "report.txt")
+	if err := h.createFile(ctx, path, 64); err != nil {
+		t.Fatalf("createFile() error = %v", err)
+	}
+	if err := h.modifyFile(ctx, path); err != nil {
+		t.Fatalf("modifyFile() error = %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(content, wm.FileTrailer()); n != 2 {
+		t.Errorf("Expected a trailer for the create and the modify, got %d", n)
+	}
+	if !bytes.HasSuffix(content, wm.FileTrailer()) {
+		t.Error("Expected the file to end with the watermark trailer")
+	}
+
+	plain := filepath.Join(dir, "plain.txt")
+	if err := h.createFile(context.Background(), plain, 64); err != nil {
+		t.Fatalf("createFile() error = %v", err)
+	}
+	if content, _ := os.ReadFile(plain); bytes.Contains(content, []byte("CYMCONDUCTOR-WATERMARK")) {
+		t.Error("Expected no trailer without a watermark")
+	}
+}
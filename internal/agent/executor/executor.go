@@ -4,11 +4,12 @@ package executor
 import (
 	"context"
 	"fmt"
+	"runtime"
 	"time"
 
 	"cymbytes.com/cymconductor/internal/agent/actions"
-	"cymbytes.com/cymconductor/internal/agent/client"
 	"cymbytes.com/cymconductor/internal/agent/impersonation"
+	"cymbytes.com/cymconductor/pkg/protocol"
 	"github.com/rs/zerolog"
 )
 
@@ -94,12 +95,12 @@ func New(cfg Config, logger zerolog.Logger) *Executor {
 
 // Execute runs a job action and returns the result.
 // For backward compatibility, this executes without impersonation.
-func (e *Executor) Execute(ctx context.Context, actionType string, params map[string]interface{}) (*client.JobResult, error) {
+func (e *Executor) Execute(ctx context.Context, actionType string, params map[string]interface{}) (*protocol.JobResult, error) {
 	return e.ExecuteAs(ctx, actionType, params, nil)
 }
 
 // ExecuteAs runs a job action as the specified user (if runAs is provided).
-func (e *Executor) ExecuteAs(ctx context.Context, actionType string, params map[string]interface{}, runAs *RunAsConfig) (*client.JobResult, error) {
+func (e *Executor) ExecuteAs(ctx context.Context, actionType string, params map[string]interface{}, runAs *RunAsConfig) (*protocol.JobResult, error) {
 	startTime := time.Now()
 
 	logEvent := e.logger.Info().
@@ -134,7 +135,7 @@ func (e *Executor) ExecuteAs(ctx context.Context, actionType string, params map[
 		result.DurationMs = time.Since(startTime).Milliseconds()
 	}
 
-	return &client.JobResult{
+	return &protocol.JobResult{
 		Data:       result.Data,
 		Summary:    result.Summary,
 		DurationMs: result.DurationMs,
@@ -172,3 +173,16 @@ func (e *Executor) executeWithImpersonation(ctx context.Context, handler actions
 func (e *Executor) GetImpersonationManager() *impersonation.Manager {
 	return e.impersonation
 }
+
+// Capabilities reports the actions and backends this agent can run, for
+// registration.
+func (e *Executor) Capabilities() *protocol.AgentCapabilities {
+	caps := e.registry.Capabilities()
+	return &protocol.AgentCapabilities{
+		Actions:     caps.Actions,
+		Unavailable: caps.Unavailable,
+		Backends:    caps.Backends,
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+	}
+}
@@ -2,44 +2,97 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
+	"golang.org/x/net/websocket"
 
+	"cymbytes.com/cymconductor/internal/orchestrator/auth"
+	"cymbytes.com/cymconductor/internal/orchestrator/backup"
+	"cymbytes.com/cymconductor/internal/orchestrator/compiler"
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+	"cymbytes.com/cymconductor/internal/orchestrator/outbox"
 	"cymbytes.com/cymconductor/internal/orchestrator/registry"
+	"cymbytes.com/cymconductor/internal/orchestrator/report"
 	"cymbytes.com/cymconductor/internal/orchestrator/scheduler"
+	"cymbytes.com/cymconductor/internal/orchestrator/scoring"
 	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/internal/orchestrator/timeline"
+	"cymbytes.com/cymconductor/internal/orchestrator/validator"
+	"cymbytes.com/cymconductor/internal/orchestrator/webhooks"
 	"cymbytes.com/cymconductor/pkg/protocol"
 )
 
 // Handlers contains all API handlers.
 type Handlers struct {
-	db        *storage.DB
+	db        storage.Store
 	registry  *registry.Registry
 	scheduler *scheduler.Scheduler
+	compiler  *compiler.Compiler
+	events    *events.Bus
+	validator *validator.Validator
+	webhooks  *webhooks.Dispatcher
+	outbox    *outbox.Worker
 	version   string
 	startTime time.Time
 	logger    zerolog.Logger
 }
 
 // New creates a new Handlers instance.
-func New(db *storage.DB, reg *registry.Registry, sched *scheduler.Scheduler, version string, startTime time.Time, logger zerolog.Logger) *Handlers {
+func New(db storage.Store, reg *registry.Registry, sched *scheduler.Scheduler, version string, startTime time.Time, logger zerolog.Logger) *Handlers {
 	return &Handlers{
 		db:        db,
 		registry:  reg,
 		scheduler: sched,
+		compiler:  compiler.New(reg, logger),
 		version:   version,
 		startTime: startTime,
 		logger:    logger.With().Str("component", "handlers").Logger(),
 	}
 }
 
+// SetEventBus sets the bus behind GET /api/events. Handlers also publish
+// scenario and command events to it.
+func (h *Handlers) SetEventBus(bus *events.Bus) {
+	h.events = bus
+}
+
+// SetValidator sets the validator that imported scenarios are re-validated
+// with. Without one, imported DSL is only checked to be JSON.
+func (h *Handlers) SetValidator(v *validator.Validator) {
+	h.validator = v
+}
+
+// SetWebhookDispatcher sets the dispatcher that is reloaded when webhook
+// subscriptions change and that sends test events.
+func (h *Handlers) SetWebhookDispatcher(d *webhooks.Dispatcher) {
+	h.webhooks = d
+}
+
+// SetOutbox sets the worker that is woken when outbox entries are replayed.
+func (h *Handlers) SetOutbox(worker *outbox.Worker) {
+	h.outbox = worker
+}
+
 // ============================================================
 // Agent Handlers
 // ============================================================
@@ -58,13 +111,25 @@ func (h *Handlers) RegisterAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := h.registry.RegisterAgent(r.Context(), &req)
+	ctx := storage.WithActor(r.Context(), storage.AgentActor(req.AgentID))
+	resp, err := h.registry.RegisterAgent(ctx, &req)
 	if err != nil {
+		if strings.HasPrefix(err.Error(), "lab not found: ") {
+			h.writeError(w, r, http.StatusBadRequest, "lab_not_found", "Lab does not exist")
+			return
+		}
+		if strings.Contains(err.Error(), "is not allowed in lab") {
+			h.writeError(w, r, http.StatusForbidden, "network_not_allowed", "Agent IP address is outside the lab's allowed networks")
+			return
+		}
 		h.logger.Error().Err(err).Str("agent_id", req.AgentID).Msg("Failed to register agent")
 		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to register agent")
 		return
 	}
 
+	resp.Transports = []string{protocol.TransportWebSocket, protocol.TransportLongPoll, protocol.TransportPoll}
+	resp.LongPollMaxMs = int(h.scheduler.LongPollMax().Milliseconds())
+
 	h.writeJSON(w, http.StatusCreated, resp)
 }
 
@@ -103,13 +168,14 @@ func (h *Handlers) GetAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if agent == nil {
+	if agent == nil || !inLabScope(r, agent.LabID) {
 		h.writeError(w, r, http.StatusNotFound, "agent_not_found", "Agent not found")
 		return
 	}
 
 	h.writeJSON(w, http.StatusOK, protocol.AgentInfo{
 		AgentID:         agent.ID,
+		LabID:           agent.LabID,
 		LabHostID:       agent.LabHostID,
 		Hostname:        agent.Hostname,
 		IPAddress:       agent.IPAddress,
@@ -118,12 +184,27 @@ func (h *Handlers) GetAgent(w http.ResponseWriter, r *http.Request) {
 		Version:         agent.Version,
 		LastHeartbeatAt: agent.LastHeartbeatAt,
 		RegisteredAt:    agent.RegisteredAt,
+		Capabilities:    capabilitiesInfo(agent.Capabilities),
 	})
 }
 
+// capabilitiesInfo converts stored agent capabilities for API responses.
+func capabilitiesInfo(caps *storage.AgentCapabilities) *protocol.AgentCapabilities {
+	if caps == nil {
+		return nil
+	}
+	return &protocol.AgentCapabilities{
+		Actions:     caps.Actions,
+		Unavailable: caps.Unavailable,
+		Backends:    caps.Backends,
+		OS:          caps.OS,
+		Arch:        caps.Arch,
+	}
+}
+
 // ListAgents handles GET /api/agents
 func (h *Handlers) ListAgents(w http.ResponseWriter, r *http.Request) {
-	agents, err := h.registry.ListAgents(r.Context())
+	agents, err := h.registry.ListAgents(r.Context(), labScope(r))
 	if err != nil {
 		h.logger.Error().Err(err).Msg("Failed to list agents")
 		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list agents")
@@ -134,6 +215,7 @@ func (h *Handlers) ListAgents(w http.ResponseWriter, r *http.Request) {
 	for _, agent := range agents {
 		agentInfos = append(agentInfos, protocol.AgentInfo{
 			AgentID:         agent.ID,
+			LabID:           agent.LabID,
 			LabHostID:       agent.LabHostID,
 			Hostname:        agent.Hostname,
 			IPAddress:       agent.IPAddress,
@@ -142,6 +224,7 @@ func (h *Handlers) ListAgents(w http.ResponseWriter, r *http.Request) {
 			Version:         agent.Version,
 			LastHeartbeatAt: agent.LastHeartbeatAt,
 			RegisteredAt:    agent.RegisteredAt,
+			Capabilities:    capabilitiesInfo(agent.Capabilities),
 		})
 	}
 
@@ -151,11 +234,31 @@ func (h *Handlers) ListAgents(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// DeleteAgent handles DELETE /api/agents/{agentID}
+func (h *Handlers) DeleteAgent(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "agentID")
+
+	if err := h.registry.DeleteAgent(r.Context(), agentID); err != nil {
+		if err.Error() == "agent not found: "+agentID {
+			h.writeError(w, r, http.StatusNotFound, "agent_not_found", "Agent not found")
+			return
+		}
+		h.logger.Error().Err(err).Str("agent_id", agentID).Msg("Failed to delete agent")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete agent")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // ============================================================
 // Job Handlers
 // ============================================================
 
 // GetNextJobs handles GET /api/agents/{agentID}/jobs/next
+//
+// With ?wait=<seconds> the request long-polls: it blocks until a job is due
+// or the wait (capped by the scheduler) expires, then answers as usual.
 func (h *Handlers) GetNextJobs(w http.ResponseWriter, r *http.Request) {
 	agentID := chi.URLParam(r, "agentID")
 
@@ -168,6 +271,14 @@ func (h *Handlers) GetNextJobs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Parse wait parameter (seconds, default: no long-poll)
+	var wait time.Duration
+	if waitStr := r.URL.Query().Get("wait"); waitStr != "" {
+		if s, err := strconv.Atoi(waitStr); err == nil && s > 0 {
+			wait = time.Duration(s) * time.Second
+		}
+	}
+
 	// Verify agent exists
 	exists, err := h.registry.AgentExists(r.Context(), agentID)
 	if err != nil {
@@ -181,7 +292,17 @@ func (h *Handlers) GetNextJobs(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get next jobs from scheduler
-	jobs, hasMore, err := h.scheduler.GetNextJobsForAgent(r.Context(), agentID, max)
+	var jobs []protocol.JobAssignment
+	var hasMore bool
+	if wait > 0 {
+		jobs, hasMore, err = h.scheduler.WaitForJobs(r.Context(), agentID, max, wait)
+	} else {
+		jobs, hasMore, err = h.scheduler.GetNextJobsForAgent(r.Context(), agentID, max)
+	}
+	if errors.Is(err, scheduler.ErrStopped) {
+		h.writeError(w, r, http.StatusServiceUnavailable, "shutting_down", "Orchestrator is shutting down")
+		return
+	}
 	if err != nil {
 		h.logger.Error().Err(err).Str("agent_id", agentID).Msg("Failed to get jobs")
 		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get jobs")
@@ -213,7 +334,7 @@ func (h *Handlers) SubmitJobResult(w http.ResponseWriter, r *http.Request) {
 	// Process the result through the scheduler
 	retryScheduled, retryAt, err := h.scheduler.ProcessJobResult(r.Context(), agentID, jobID, &req)
 	if err != nil {
-		if err.Error() == "job not found: "+jobID {
+		if errors.Is(err, storage.ErrJobNotFound) {
 			h.writeError(w, r, http.StatusNotFound, "job_not_found", "Job not found")
 			return
 		}
@@ -238,7 +359,7 @@ func (h *Handlers) SubmitJobResult(w http.ResponseWriter, r *http.Request) {
 
 // GetJobStats handles GET /api/jobs/stats
 func (h *Handlers) GetJobStats(w http.ResponseWriter, r *http.Request) {
-	counts, err := h.db.CountJobsByStatus(r.Context())
+	counts, err := h.db.CountJobsByStatus(r.Context(), labScope(r))
 	if err != nil {
 		h.logger.Error().Err(err).Msg("Failed to get job stats")
 		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get job stats")
@@ -248,559 +369,2830 @@ func (h *Handlers) GetJobStats(w http.ResponseWriter, r *http.Request) {
 	h.writeJSON(w, http.StatusOK, counts)
 }
 
-// ============================================================
-// Scenario Handlers
-// ============================================================
+// ListJobs handles GET /api/jobs
+//
+// Filters: scenario_id, step_id, agent_id, status and action_type (both
+// comma-separated), run_as, and since/until (RFC 3339, applied to the sort
+// column). Sorting: sort=scheduled_at|created_at|updated_at, order=asc|desc.
+// Pagination: limit (default 50, max 500) and the cursor returned as
+// next_cursor.
+func (h *Handlers) ListJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	filter := storage.JobFilter{
+		LabID:          labScope(r),
+		ScenarioID:     q.Get("scenario_id"),
+		ScenarioStepID: q.Get("step_id"),
+		AgentID:        q.Get("agent_id"),
+		Statuses:       splitList(q.Get("status")),
+		ActionTypes:    splitList(q.Get("action_type")),
+		RunAsUser:      q.Get("run_as"),
+		SortBy:         q.Get("sort"),
+		Limit:          50,
+	}
+
+	switch filter.SortBy {
+	case "", storage.JobSortScheduledAt, storage.JobSortCreatedAt, storage.JobSortUpdatedAt:
+	default:
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "sort must be one of scheduled_at, created_at, updated_at")
+		return
+	}
 
-// CreateScenario handles POST /api/scenarios
-func (h *Handlers) CreateScenario(w http.ResponseWriter, r *http.Request) {
-	var req protocol.CreateScenarioRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+	switch q.Get("order") {
+	case "", "desc":
+		filter.Descending = true
+	case "asc":
+	default:
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "order must be asc or desc")
 		return
 	}
 
-	if req.Name == "" {
-		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "Name is required")
+	for param, dest := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				h.writeError(w, r, http.StatusBadRequest, "invalid_request", param+" must be an RFC 3339 timestamp")
+				return
+			}
+			*dest = t
+		}
+	}
+
+	if limitStr := q.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 500 {
+			filter.Limit = l
+		}
+	}
+
+	if cursor := q.Get("cursor"); cursor != "" {
+		after, err := storage.ParseJobCursor(cursor)
+		if err != nil {
+			h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid cursor")
+			return
+		}
+		filter.After = after
+	}
+
+	jobs, next, err := h.scheduler.ListJobs(r.Context(), filter)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to list jobs")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list jobs")
 		return
 	}
 
-	// For now, return not implemented
-	// This will be connected to the planner/compiler pipeline later
-	h.writeError(w, r, http.StatusNotImplemented, "not_implemented", "Scenario creation via API coming soon")
+	resp := protocol.ListJobsResponse{
+		Jobs: make([]protocol.JobResponse, 0, len(jobs)),
+	}
+	for _, job := range jobs {
+		item := jobToResponse(job)
+		item.Parameters = nil
+		item.Result = nil
+		resp.Jobs = append(resp.Jobs, item)
+	}
+	if next != nil {
+		resp.NextCursor = next.Encode()
+	}
+
+	h.writeJSON(w, http.StatusOK, resp)
 }
 
-// GetScenario handles GET /api/scenarios/{scenarioID}
-func (h *Handlers) GetScenario(w http.ResponseWriter, r *http.Request) {
-	scenarioID := chi.URLParam(r, "scenarioID")
+// GetJob handles GET /api/jobs/{jobID}
+func (h *Handlers) GetJob(w http.ResponseWriter, r *http.Request) {
+	jobID := chi.URLParam(r, "jobID")
 
-	scenario, err := h.db.GetScenario(r.Context(), scenarioID)
+	job, err := h.db.GetJob(r.Context(), jobID)
 	if err != nil {
-		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to get scenario")
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get scenario")
+		h.logger.Error().Err(err).Str("job_id", jobID).Msg("Failed to get job")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get job")
 		return
 	}
-
-	if scenario == nil {
-		h.writeError(w, r, http.StatusNotFound, "scenario_not_found", "Scenario not found")
+	if job == nil || !inLabScope(r, job.LabID) {
+		h.writeError(w, r, http.StatusNotFound, "job_not_found", "Job not found")
 		return
 	}
 
-	h.writeJSON(w, http.StatusOK, scenario)
+	h.writeJSON(w, http.StatusOK, jobToResponse(job))
 }
 
-// GetScenarioStatus handles GET /api/scenarios/{scenarioID}/status
-func (h *Handlers) GetScenarioStatus(w http.ResponseWriter, r *http.Request) {
-	scenarioID := chi.URLParam(r, "scenarioID")
+// RetryJob handles POST /api/jobs/{jobID}/retry
+//
+// Failed and cancelled jobs are returned to pending and run again as soon as
+// their agent polls.
+func (h *Handlers) RetryJob(w http.ResponseWriter, r *http.Request) {
+	h.changeJobState(w, r, "retry", h.scheduler.RetryJob)
+}
 
-	scenario, err := h.db.GetScenario(r.Context(), scenarioID)
+// CancelJob handles POST /api/jobs/{jobID}/cancel
+//
+// Only pending and assigned jobs can be cancelled; a running job is left to
+// finish.
+func (h *Handlers) CancelJob(w http.ResponseWriter, r *http.Request) {
+	h.changeJobState(w, r, "cancel", h.scheduler.CancelJob)
+}
+
+// changeJobState applies a scheduler job transition and writes the updated job.
+func (h *Handlers) changeJobState(w http.ResponseWriter, r *http.Request, action string,
+	transition func(ctx context.Context, jobID string) (*storage.Job, error)) {
+	jobID := chi.URLParam(r, "jobID")
+
+	job, err := h.db.GetJob(r.Context(), jobID)
 	if err != nil {
-		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to get scenario")
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get scenario")
+		h.logger.Error().Err(err).Str("job_id", jobID).Msg("Failed to get job")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get job")
 		return
 	}
-
-	if scenario == nil {
-		h.writeError(w, r, http.StatusNotFound, "scenario_not_found", "Scenario not found")
+	if job == nil || !inLabScope(r, job.LabID) {
+		h.writeError(w, r, http.StatusNotFound, "job_not_found", "Job not found")
 		return
 	}
 
-	// Get job stats for progress
-	total, completed, failed, running, pending, err := h.db.GetScenarioJobStats(r.Context(), scenarioID)
+	job, err = transition(r.Context(), jobID)
 	if err != nil {
-		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to get job stats")
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get job stats")
+		if errors.Is(err, storage.ErrJobNotFound) {
+			h.writeError(w, r, http.StatusNotFound, "job_not_found", "Job not found")
+			return
+		}
+		if errors.Is(err, scheduler.ErrInvalidJobState) {
+			h.writeError(w, r, http.StatusConflict, "invalid_state", err.Error())
+			return
+		}
+		h.logger.Error().Err(err).Str("job_id", jobID).Str("action", action).Msg("Failed to update job")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to "+action+" job")
 		return
 	}
 
-	var percentComplete float64
-	if total > 0 {
-		percentComplete = float64(completed+failed) / float64(total) * 100
+	h.writeJSON(w, http.StatusOK, jobToResponse(job))
+}
+
+func jobToResponse(job *storage.Job) protocol.JobResponse {
+	resp := protocol.JobResponse{
+		ID:          job.ID,
+		LabID:       job.LabID,
+		AgentID:     job.AgentID,
+		ActionType:  job.ActionType,
+		Parameters:  job.Parameters,
+		Status:      job.Status,
+		Priority:    job.Priority,
+		Result:      job.Result,
+		RetryCount:  job.RetryCount,
+		MaxRetries:  job.MaxRetries,
+		ScheduledAt: job.ScheduledAt,
+		AssignedAt:  job.AssignedAt,
+		StartedAt:   job.StartedAt,
+		CompletedAt: job.CompletedAt,
+		CreatedAt:   job.CreatedAt,
+		UpdatedAt:   job.UpdatedAt,
+	}
+	if job.ScenarioID != nil {
+		resp.ScenarioID = *job.ScenarioID
+	}
+	if job.ScenarioStepID != nil {
+		resp.ScenarioStepID = *job.ScenarioStepID
+	}
+	if job.RunAsUser != nil {
+		resp.RunAsUser = *job.RunAsUser
+		if job.RunAsLogonType != nil {
+			resp.RunAsLogonType = *job.RunAsLogonType
+		}
+	}
+	if job.ErrorMessage != nil {
+		resp.ErrorMessage = *job.ErrorMessage
 	}
+	return resp
+}
 
-	var errMsg string
-	if scenario.ErrorMessage != nil {
-		errMsg = *scenario.ErrorMessage
+// splitList splits a comma-separated query parameter, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
 
-	resp := protocol.ScenarioStatusResponse{
-		ScenarioID:   scenario.ID,
-		Name:         scenario.Name,
-		Status:       scenario.Status,
-		ErrorMessage: errMsg,
-		CreatedAt:    scenario.CreatedAt,
-		UpdatedAt:    scenario.UpdatedAt,
-		CompletedAt:  scenario.CompletedAt,
-		Progress: &protocol.ScenarioProgress{
-			TotalJobs:       total,
-			CompletedJobs:   completed,
-			FailedJobs:      failed,
-			RunningJobs:     running,
-			PendingJobs:     pending,
-			PercentComplete: percentComplete,
-		},
+// ============================================================
+// Agent Channel Handlers
+// ============================================================
+
+// channelIdleTimeout closes an agent channel that has been silent this long.
+// Agents send heartbeats well within it.
+const channelIdleTimeout = 2 * time.Minute
+
+// agentChannel is an agent's open WebSocket connection.
+type agentChannel struct {
+	conn    *websocket.Conn
+	agentID string
+	labID   string
+	sendMu  sync.Mutex
+	polling chan struct{} // holds a token while a poll is outstanding
+}
+
+func (c *agentChannel) send(msgType string, payload interface{}) error {
+	msg, err := protocol.NewChannelMessage(msgType, payload)
+	if err != nil {
+		return err
 	}
 
-	h.writeJSON(w, http.StatusOK, resp)
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return websocket.JSON.Send(c.conn, msg)
 }
 
-// ListScenarios handles GET /api/scenarios
-func (h *Handlers) ListScenarios(w http.ResponseWriter, r *http.Request) {
-	status := r.URL.Query().Get("status")
-	limitStr := r.URL.Query().Get("limit")
+func (c *agentChannel) sendError(code, message string) {
+	_ = c.send(protocol.MessageError, protocol.ErrorResponse{Error: code, Message: message})
+}
 
-	limit := 50
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
-			limit = l
-		}
+// sendCommand pushes a command to the agent and publishes command.sent. The
+// agent's acknowledgment is published as command.acked.
+func (h *Handlers) sendCommand(ch *agentChannel, cmd protocol.AgentCommand) {
+	cmd.ID = uuid.New().String()
+	if err := ch.send(protocol.MessageCommand, cmd); err != nil {
+		h.logger.Warn().Err(err).Str("agent_id", ch.agentID).Str("command", cmd.Type).Msg("Failed to send command")
+		return
 	}
 
-	scenarios, err := h.db.ListScenarios(r.Context(), status, limit)
+	h.events.Publish(events.Event{
+		Type:    events.CommandSent,
+		LabID:   ch.labID,
+		AgentID: ch.agentID,
+		Data: map[string]interface{}{
+			"command_id": cmd.ID,
+			"command":    cmd.Type,
+		},
+	})
+}
+
+// AgentChannel handles GET /api/agents/{agentID}/ws
+//
+// It upgrades to a WebSocket carrying heartbeats, job assignments, results and
+// commands. Agents that cannot connect fall back to the REST endpoints.
+func (h *Handlers) AgentChannel(w http.ResponseWriter, r *http.Request) {
+	agentID := chi.URLParam(r, "agentID")
+
+	agent, err := h.registry.GetAgent(r.Context(), agentID)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("Failed to list scenarios")
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list scenarios")
+		h.logger.Error().Err(err).Str("agent_id", agentID).Msg("Failed to check agent")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to check agent")
+		return
+	}
+	if agent == nil {
+		h.writeError(w, r, http.StatusNotFound, "agent_not_found", "Agent must register before opening a channel")
 		return
 	}
 
-	h.writeJSON(w, http.StatusOK, scenarios)
+	server := websocket.Server{
+		Handler: func(conn *websocket.Conn) {
+			h.serveAgentChannel(conn, agentID, agent.LabID)
+		},
+	}
+	server.ServeHTTP(w, r)
 }
 
-// DeleteScenario handles DELETE /api/scenarios/{scenarioID}
-func (h *Handlers) DeleteScenario(w http.ResponseWriter, r *http.Request) {
-	scenarioID := chi.URLParam(r, "scenarioID")
+// serveAgentChannel reads messages from an agent until the connection closes.
+func (h *Handlers) serveAgentChannel(conn *websocket.Conn, agentID, labID string) {
+	ctx, cancel := context.WithCancel(conn.Request().Context())
+	defer cancel()
+	defer conn.Close()
 
-	// Cancel any pending jobs first
-	_, err := h.db.CancelJobsForScenario(r.Context(), scenarioID)
-	if err != nil {
-		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to cancel jobs")
+	// The HTTP server's write timeout still applies to the hijacked connection
+	_ = conn.SetWriteDeadline(time.Time{})
+
+	ch := &agentChannel{
+		conn:    conn,
+		agentID: agentID,
+		labID:   labID,
+		polling: make(chan struct{}, 1),
 	}
 
-	if err := h.db.DeleteScenario(r.Context(), scenarioID); err != nil {
-		if err.Error() == "scenario not found: "+scenarioID {
-			h.writeError(w, r, http.StatusNotFound, "scenario_not_found", "Scenario not found")
+	h.logger.Info().Str("agent_id", agentID).Msg("Agent channel opened")
+	defer h.logger.Info().Str("agent_id", agentID).Msg("Agent channel closed")
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(channelIdleTimeout))
+
+		var msg protocol.ChannelMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			if err != io.EOF {
+				h.logger.Debug().Err(err).Str("agent_id", agentID).Msg("Agent channel read failed")
+			}
 			return
 		}
-		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to delete scenario")
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete scenario")
-		return
-	}
 
-	w.WriteHeader(http.StatusNoContent)
+		h.handleChannelMessage(ctx, ch, &msg)
+	}
 }
 
-// ============================================================
-// Health Handlers
-// ============================================================
+// handleChannelMessage dispatches a single message received from an agent.
+func (h *Handlers) handleChannelMessage(ctx context.Context, ch *agentChannel, msg *protocol.ChannelMessage) {
+	switch msg.Type {
+	case protocol.MessageHeartbeat:
+		var req protocol.HeartbeatRequest
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			ch.sendError("invalid_request", "Failed to parse heartbeat")
+			return
+		}
 
-// HealthCheck handles GET /health
-func (h *Handlers) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	// Check database health
-	dbStatus, dbErr := h.db.Health(r.Context())
+		resp, err := h.registry.ProcessHeartbeat(ctx, ch.agentID, &req)
+		if err != nil {
+			if err.Error() == "agent not found: "+ch.agentID {
+				h.sendCommand(ch, protocol.AgentCommand{Type: protocol.CommandReregister})
+				return
+			}
+			h.logger.Error().Err(err).Str("agent_id", ch.agentID).Msg("Failed to process heartbeat")
+			ch.sendError("internal_error", "Failed to process heartbeat")
+			return
+		}
 
-	status := "healthy"
-	if dbErr != nil {
-		status = "unhealthy"
-	}
+		_ = ch.send(protocol.MessageHeartbeatAck, resp)
 
-	resp := protocol.HealthResponse{
-		Status:        status,
-		Version:       h.version,
-		UptimeSeconds: int64(time.Since(h.startTime).Seconds()),
-		Components: map[string]protocol.ComponentHealth{
-			"database": {
-				Status:    dbStatus,
-				LastCheck: time.Now(),
+	case protocol.MessagePoll:
+		var req protocol.PollMessage
+		if len(msg.Payload) > 0 {
+			if err := json.Unmarshal(msg.Payload, &req); err != nil {
+				ch.sendError("invalid_request", "Failed to parse poll")
+				return
+			}
+		}
+
+		max := 5
+		if req.Max > 0 && req.Max <= 10 {
+			max = req.Max
+		}
+
+		select {
+		case ch.polling <- struct{}{}:
+			go h.dispatchChannelJobs(ctx, ch, max)
+		default:
+			// A poll is already outstanding
+		}
+
+	case protocol.MessageJobResult:
+		var req protocol.JobResultMessage
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			ch.sendError("invalid_request", "Failed to parse job result")
+			return
+		}
+
+		retryScheduled, retryAt, err := h.scheduler.ProcessJobResult(ctx, ch.agentID, req.JobID, &req.Result)
+		if err != nil {
+			if errors.Is(err, storage.ErrJobNotFound) {
+				ch.sendError("job_not_found", "Job not found: "+req.JobID)
+				return
+			}
+			h.logger.Error().Err(err).
+				Str("agent_id", ch.agentID).
+				Str("job_id", req.JobID).
+				Msg("Failed to process job result")
+			ch.sendError("internal_error", "Failed to process job result")
+			return
+		}
+
+		_ = ch.send(protocol.MessageJobResultAck, protocol.JobResultAckMessage{
+			JobID: req.JobID,
+			JobResultResponse: protocol.JobResultResponse{
+				Acknowledged:   true,
+				RetryScheduled: retryScheduled,
+				RetryAt:        retryAt,
 			},
-		},
-	}
+		})
 
-	if dbErr != nil {
-		resp.Components["database"] = protocol.ComponentHealth{
-			Status:    "unhealthy",
-			LastCheck: time.Now(),
-			Details:   dbErr.Error(),
+	case protocol.MessageCommandAck:
+		var ack protocol.CommandAckMessage
+		if err := json.Unmarshal(msg.Payload, &ack); err != nil {
+			ch.sendError("invalid_request", "Failed to parse command acknowledgment")
+			return
 		}
-	}
 
-	statusCode := http.StatusOK
-	if status != "healthy" {
-		statusCode = http.StatusServiceUnavailable
-	}
+		data := map[string]interface{}{
+			"command_id": ack.CommandID,
+			"command":    ack.Type,
+			"success":    ack.Success,
+		}
+		if ack.Error != "" {
+			data["error"] = ack.Error
+		}
+		h.events.Publish(events.Event{
+			Type:    events.CommandAcked,
+			LabID:   ch.labID,
+			AgentID: ch.agentID,
+			Data:    data,
+		})
 
-	h.writeJSON(w, statusCode, resp)
+	default:
+		ch.sendError("unknown_message", "Unknown message type: "+msg.Type)
+	}
 }
 
-// ReadyCheck handles GET /ready
-func (h *Handlers) ReadyCheck(w http.ResponseWriter, r *http.Request) {
-	// Check if we can handle requests
-	if err := h.db.Ping(r.Context()); err != nil {
-		h.writeError(w, r, http.StatusServiceUnavailable, "not_ready", "Database not available")
+// dispatchChannelJobs waits until jobs are due for the agent and pushes them.
+// The agent sends another poll once it is ready for more.
+func (h *Handlers) dispatchChannelJobs(ctx context.Context, ch *agentChannel, max int) {
+	defer func() { <-ch.polling }()
+
+	for ctx.Err() == nil {
+		jobs, hasMore, err := h.scheduler.WaitForJobs(ctx, ch.agentID, max, h.scheduler.LongPollMax())
+		if errors.Is(err, scheduler.ErrStopped) {
+			return
+		}
+		if err != nil {
+			h.logger.Error().Err(err).Str("agent_id", ch.agentID).Msg("Failed to get jobs")
+			ch.sendError("internal_error", "Failed to get jobs")
+			return
+		}
+		if len(jobs) == 0 {
+			continue
+		}
+
+		if err := ch.send(protocol.MessageJobs, protocol.GetJobsResponse{Jobs: jobs, HasMore: hasMore}); err != nil {
+			h.logger.Warn().Err(err).Str("agent_id", ch.agentID).Int("count", len(jobs)).Msg("Failed to push jobs to agent")
+			h.releaseJobs(ch.agentID, jobs)
+		}
 		return
 	}
+}
 
-	h.writeJSON(w, http.StatusOK, map[string]bool{"ready": true})
+// releaseJobs returns jobs that were assigned but never reached the agent to
+// pending. The connection's context may already be cancelled, so a fresh one
+// is used.
+func (h *Handlers) releaseJobs(agentID string, jobs []protocol.JobAssignment) {
+	jobIDs := make([]string, len(jobs))
+	for i, job := range jobs {
+		jobIDs[i] = job.JobID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := h.scheduler.ReleaseJobs(ctx, agentID, jobIDs); err != nil {
+		h.logger.Error().Err(err).Str("agent_id", agentID).Strs("job_ids", jobIDs).Msg("Failed to release undelivered jobs")
+	}
 }
 
 // ============================================================
-// Helper methods
+// Scenario Handlers
+// ============================================================
+
+// CreateScenario handles POST /api/scenarios
+func (h *Handlers) CreateScenario(w http.ResponseWriter, r *http.Request) {
+	var req protocol.CreateScenarioRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+		return
+	}
+
+	if req.Name == "" {
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "Name is required")
+		return
+	}
+	if req.Scenario == nil {
+		// Intents need the AI planner, which is not wired to the API
+		h.writeError(w, r, http.StatusNotImplemented, "not_implemented",
+			"Scenario creation from an intent is not available via the API; submit a DSL scenario definition")
+		return
+	}
+
+	ctx := r.Context()
+	labID, ok := h.resolveLab(w, r, "")
+	if !ok {
+		return
+	}
+
+	val, err := h.scenarioValidator(ctx, labID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("lab_id", labID).Msg("Failed to prepare scenario validator")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to validate scenario")
+		return
+	}
+	definition, result := val.ValidateScenarioJSON([]byte(req.Scenario.Definition))
+	if !result.Valid {
+		h.writeJSON(w, http.StatusUnprocessableEntity, result)
+		return
+	}
+
+	existing, err := h.db.GetScenario(ctx, definition.ID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", definition.ID).Msg("Failed to get scenario")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create scenario")
+		return
+	}
+	if existing != nil {
+		h.writeError(w, r, http.StatusConflict, "scenario_exists", "A scenario with this ID already exists")
+		return
+	}
+
+	startAt := time.Now().UTC()
+	switch definition.Schedule.Type {
+	case "delayed":
+		if definition.Schedule.StartAt != nil {
+			startAt = *definition.Schedule.StartAt
+		}
+	case "cron":
+		h.writeError(w, r, http.StatusUnprocessableEntity, "unsupported_schedule", "Cron schedules are not supported")
+		return
+	}
+
+	compiled, err := h.compiler.Compile(ctx, definition, labID, startAt)
+	if err != nil {
+		h.writeError(w, r, http.StatusUnprocessableEntity, "compile_failed", err.Error())
+		return
+	}
+	if len(compiled.Errors) > 0 {
+		h.writeError(w, r, http.StatusUnprocessableEntity, "compile_failed", strings.Join(compiled.Errors, "; "))
+		return
+	}
+
+	scenario := &storage.Scenario{
+		ID:     definition.ID,
+		LabID:  labID,
+		Name:   req.Name,
+		Intent: "{}",
+		Source: storage.ScenarioSourceAPI,
+		Status: storage.ScenarioStatusActive,
+	}
+	if req.Description != "" {
+		scenario.Description = &req.Description
+	}
+	if req.ScoringRunID != "" {
+		scenario.ScoringRunID = &req.ScoringRunID
+	}
+	if err := h.createCompiledScenario(ctx, scenario, req.Scenario.Definition, compiled); err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenario.ID).Msg("Failed to create scenario")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create scenario")
+		return
+	}
+
+	var estimated *time.Time
+	for _, job := range compiled.Jobs {
+		if estimated == nil || job.ScheduledAt.After(*estimated) {
+			scheduled := job.ScheduledAt
+			estimated = &scheduled
+		}
+	}
+
+	h.writeJSON(w, http.StatusCreated, protocol.CreateScenarioResponse{
+		ScenarioID:            scenario.ID,
+		Name:                  scenario.Name,
+		Status:                scenario.Status,
+		StepCount:             len(compiled.Steps),
+		JobCount:              len(compiled.Jobs),
+		CreatedAt:             time.Now(),
+		EstimatedCompletionAt: estimated,
+	})
+}
+
+// scenarioValidator returns the validator for scenarios submitted to a lab:
+// restricted to the lab's networks and to steps its agents can run.
+func (h *Handlers) scenarioValidator(ctx context.Context, labID string) (*validator.Validator, error) {
+	val := h.validator
+	if val == nil {
+		val = validator.New()
+	}
+
+	lab, err := h.db.GetLab(ctx, labID)
+	if err != nil {
+		return nil, err
+	}
+	if lab != nil {
+		if val, err = val.WithAllowedNetworks(lab.AllowedNetworks); err != nil {
+			return nil, err
+		}
+	}
+
+	agents, err := h.registry.GetOnlineAgents(ctx, labID)
+	if err != nil {
+		return nil, err
+	}
+	return val.WithAgents(agents), nil
+}
+
+// createCompiledScenario stores a compiled scenario as active with its steps
+// and jobs in one transaction, which announces its start to its scoring run.
+func (h *Handlers) createCompiledScenario(ctx context.Context, scenario *storage.Scenario, definition string, compiled *compiler.CompileResult) error {
+	scenario.ValidatedDSL = &definition
+	return h.scheduler.CreateScenario(ctx, scenario, compiled.Steps, compiled.Jobs)
+}
+
+// publishScenario publishes a scenario lifecycle event.
+func (h *Handlers) publishScenario(eventType string, scenario *storage.Scenario, data map[string]interface{}) {
+	data["name"] = scenario.Name
+	h.events.Publish(events.Event{
+		Type:       eventType,
+		LabID:      scenario.LabID,
+		ScenarioID: scenario.ID,
+		Data:       data,
+	})
+}
+
+// GetScenario handles GET /api/scenarios/{scenarioID}
+func (h *Handlers) GetScenario(w http.ResponseWriter, r *http.Request) {
+	scenarioID := chi.URLParam(r, "scenarioID")
+
+	scenario, err := h.db.GetScenario(r.Context(), scenarioID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to get scenario")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get scenario")
+		return
+	}
+
+	if scenario == nil || !inLabScope(r, scenario.LabID) {
+		h.writeError(w, r, http.StatusNotFound, "scenario_not_found", "Scenario not found")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, scenario)
+}
+
+// GetScenarioStatus handles GET /api/scenarios/{scenarioID}/status
+func (h *Handlers) GetScenarioStatus(w http.ResponseWriter, r *http.Request) {
+	scenarioID := chi.URLParam(r, "scenarioID")
+
+	scenario, err := h.db.GetScenario(r.Context(), scenarioID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to get scenario")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get scenario")
+		return
+	}
+
+	if scenario == nil || !inLabScope(r, scenario.LabID) {
+		h.writeError(w, r, http.StatusNotFound, "scenario_not_found", "Scenario not found")
+		return
+	}
+
+	// Get job stats for progress
+	total, completed, failed, cancelled, running, pending, err := h.db.GetScenarioJobStats(r.Context(), scenarioID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to get job stats")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get job stats")
+		return
+	}
+
+	var percentComplete float64
+	if total > 0 {
+		percentComplete = float64(completed+failed+cancelled) / float64(total) * 100
+	}
+
+	var errMsg string
+	if scenario.ErrorMessage != nil {
+		errMsg = *scenario.ErrorMessage
+	}
+
+	resp := protocol.ScenarioStatusResponse{
+		ScenarioID:   scenario.ID,
+		Name:         scenario.Name,
+		Status:       scenario.Status,
+		ErrorMessage: errMsg,
+		CreatedAt:    scenario.CreatedAt,
+		UpdatedAt:    scenario.UpdatedAt,
+		CompletedAt:  scenario.CompletedAt,
+		Progress: &protocol.ScenarioProgress{
+			TotalJobs:       total,
+			CompletedJobs:   completed,
+			FailedJobs:      failed,
+			CancelledJobs:   cancelled,
+			RunningJobs:     running,
+			PendingJobs:     pending,
+			PercentComplete: percentComplete,
+		},
+	}
+
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// GetScenarioScorecard handles GET /api/scenarios/{scenarioID}/scorecard
+//
+// Grades the scenario's objectives against the latest results of their
+// steps. Scenarios without objectives get an empty scorecard.
+func (h *Handlers) GetScenarioScorecard(w http.ResponseWriter, r *http.Request) {
+	scenarioID := chi.URLParam(r, "scenarioID")
+
+	scenario, err := h.db.GetScenario(r.Context(), scenarioID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to get scenario")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get scenario")
+		return
+	}
+	if scenario == nil || !inLabScope(r, scenario.LabID) {
+		h.writeError(w, r, http.StatusNotFound, "scenario_not_found", "Scenario not found")
+		return
+	}
+
+	card, err := h.scheduler.Scorecard(r.Context(), scenario)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to evaluate objectives")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to evaluate objectives")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, scorecardResponse(scenario, card, time.Now()))
+}
+
+// scorecardResponse describes a scenario's objective grades at now.
+func scorecardResponse(scenario *storage.Scenario, card *scoring.Scorecard, now time.Time) protocol.ScorecardResponse {
+	resp := protocol.ScorecardResponse{
+		ScenarioID:  scenario.ID,
+		Name:        scenario.Name,
+		Status:      scenario.Status,
+		Score:       card.Score,
+		MaxScore:    card.MaxScore,
+		Percent:     card.Percent(),
+		Passed:      card.Passed,
+		Failed:      card.Failed,
+		Pending:     card.Pending,
+		Objectives:  make([]protocol.ObjectiveScore, 0, len(card.Objectives)),
+		EvaluatedAt: now.UTC(),
+	}
+	for _, result := range card.Objectives {
+		o := result.Objective
+		score := protocol.ObjectiveScore{
+			ID:          o.ID,
+			Name:        o.Name,
+			Description: o.Description,
+			Status:      result.Status,
+			Weight:      o.Weight,
+			Score:       result.Score,
+			Require:     o.Require,
+			Assert:      o.Assert,
+			Deadline:    result.Deadline,
+			PassedAt:    result.PassedAt,
+			Checks:      make([]protocol.ObjectiveCheck, 0, len(result.Checks)),
+		}
+		for _, check := range result.Checks {
+			score.Checks = append(score.Checks, protocol.ObjectiveCheck{
+				JobID:     check.JobID,
+				StepID:    check.StepID,
+				AgentID:   check.AgentID,
+				JobStatus: check.JobStatus,
+				Passed:    check.Passed,
+				CheckedAt: check.CheckedAt,
+			})
+		}
+		resp.Objectives = append(resp.Objectives, score)
+	}
+
+	return resp
+}
+
+// GetScenarioTimeline handles GET /api/scenarios/{scenarioID}/timeline
+//
+// Returns every job the scenario ran, in start order, as the ground truth of
+// the activity it generated. format selects json (the default), jsonl, csv or
+// ecs (Elastic Common Schema documents as JSON lines); with bulk=true the ECS
+// export is an Elasticsearch _bulk body.
+func (h *Handlers) GetScenarioTimeline(w http.ResponseWriter, r *http.Request) {
+	scenarioID := chi.URLParam(r, "scenarioID")
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "jsonl" && format != "csv" && format != "ecs" {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "format must be json, jsonl, csv or ecs")
+		return
+	}
+	bulk := r.URL.Query().Get("bulk") == "true"
+
+	scenario, err := h.db.GetScenario(r.Context(), scenarioID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to get scenario")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get scenario")
+		return
+	}
+	if scenario == nil || !inLabScope(r, scenario.LabID) {
+		h.writeError(w, r, http.StatusNotFound, "scenario_not_found", "Scenario not found")
+		return
+	}
+
+	entries, err := timeline.Build(r.Context(), h.db, scenario.ID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to build timeline")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to build timeline")
+		return
+	}
+
+	if format == "json" {
+		h.writeJSON(w, http.StatusOK, protocol.TimelineResponse{
+			ScenarioID: scenario.ID,
+			Name:       scenario.Name,
+			Entries:    entries,
+		})
+		return
+	}
+
+	ext := format
+	if format == "ecs" {
+		ext = "ndjson"
+	}
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="timeline-%s.%s"`, scenario.ID, ext))
+
+	// Errors writing the body can only be logged
+	switch format {
+	case "jsonl", "ecs":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for _, entry := range entries {
+			var doc interface{} = entry
+			if format == "ecs" {
+				if bulk {
+					action := map[string]interface{}{"create": map[string]interface{}{"_id": entry.JobID}}
+					if err := enc.Encode(action); err != nil {
+						h.logger.Error().Err(err).Msg("Failed to export timeline")
+						return
+					}
+				}
+				doc = timeline.ECSDocument(entry)
+			}
+			if err := enc.Encode(doc); err != nil {
+				h.logger.Error().Err(err).Msg("Failed to export timeline")
+				return
+			}
+		}
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		if err := cw.Write(timeline.CSVHeader); err != nil {
+			return
+		}
+		for _, entry := range entries {
+			if err := cw.Write(timeline.CSVRecord(entry)); err != nil {
+				h.logger.Error().Err(err).Msg("Failed to export timeline")
+				return
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			h.logger.Error().Err(err).Msg("Failed to export timeline")
+		}
+	}
+}
+
+// GetScenarioReport handles GET /api/scenarios/{scenarioID}/report
+//
+// Returns the after-action report of a scenario as a single file: json (the
+// default), md or html (self-contained, with no external assets).
+func (h *Handlers) GetScenarioReport(w http.ResponseWriter, r *http.Request) {
+	scenarioID := chi.URLParam(r, "scenarioID")
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = report.FormatJSON
+	}
+	contentType, ok := report.ContentTypes[format]
+	if !ok {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "format must be json, md or html")
+		return
+	}
+
+	scenario, err := h.db.GetScenario(r.Context(), scenarioID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to get scenario")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get scenario")
+		return
+	}
+	if scenario == nil || !inLabScope(r, scenario.LabID) {
+		h.writeError(w, r, http.StatusNotFound, "scenario_not_found", "Scenario not found")
+		return
+	}
+
+	now := time.Now()
+	card, err := h.scheduler.Scorecard(r.Context(), scenario)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to evaluate objectives")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to evaluate objectives")
+		return
+	}
+	rep, err := report.Build(r.Context(), h.db, scenario, scorecardResponse(scenario, card, now), now)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to build report")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to build report")
+		return
+	}
+
+	// Render before sending headers, so a template error is still a 500
+	var buf bytes.Buffer
+	if err := report.Render(&buf, rep, format); err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to render report")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to render report")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="report-%s.%s"`, scenario.ID, format))
+	w.WriteHeader(http.StatusOK)
+	if _, err := buf.WriteTo(w); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to write report")
+	}
+}
+
+// ListScenarios handles GET /api/scenarios
+func (h *Handlers) ListScenarios(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	limitStr := r.URL.Query().Get("limit")
+
+	limit := 50
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	scenarios, err := h.db.ListScenarios(r.Context(), labScope(r), status, limit)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to list scenarios")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list scenarios")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, scenarios)
+}
+
+// SetScenarioScoringRun handles PUT /api/scenarios/{scenarioID}/scoring-run
+//
+// Attaches the run given by run_id, or with create, one the orchestrator
+// opens on the scoring engine. Job results reported from then on are sent to
+// the run, along with the scenario's started and completed events.
+func (h *Handlers) SetScenarioScoringRun(w http.ResponseWriter, r *http.Request) {
+	scenarioID := chi.URLParam(r, "scenarioID")
+
+	var req protocol.SetScoringRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+		return
+	}
+	req.RunID = strings.TrimSpace(req.RunID)
+	if (req.RunID == "") == !req.Create {
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "Exactly one of run_id and create is required")
+		return
+	}
+
+	scenario, err := h.db.GetScenario(r.Context(), scenarioID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to get scenario")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get scenario")
+		return
+	}
+	if scenario == nil || !inLabScope(r, scenario.LabID) {
+		h.writeError(w, r, http.StatusNotFound, "scenario_not_found", "Scenario not found")
+		return
+	}
+	if scenario.Status == storage.ScenarioStatusCompleted || scenario.Status == storage.ScenarioStatusFailed {
+		h.writeError(w, r, http.StatusConflict, "scenario_finished", "Scenario has already finished")
+		return
+	}
+
+	runID := req.RunID
+	if req.Create {
+		runID, err = h.scheduler.CreateScoringRun(r.Context(), scenario)
+		if errors.Is(err, scheduler.ErrScoringDisabled) {
+			h.writeError(w, r, http.StatusServiceUnavailable, "scoring_unavailable", "Scoring engine integration is not enabled")
+			return
+		}
+		if err != nil {
+			h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to create scoring run")
+			h.writeError(w, r, http.StatusBadGateway, "scoring_run_failed", "Failed to create scoring run: "+err.Error())
+			return
+		}
+	}
+
+	if err := h.scheduler.AttachScoringRun(r.Context(), scenario, runID); err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to attach scoring run")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to attach scoring run")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, protocol.ScoringRunResponse{
+		ScenarioID:   scenarioID,
+		ScoringRunID: runID,
+		Created:      req.Create,
+	})
+}
+
+// DeleteScenario handles DELETE /api/scenarios/{scenarioID}
+func (h *Handlers) DeleteScenario(w http.ResponseWriter, r *http.Request) {
+	scenarioID := chi.URLParam(r, "scenarioID")
+
+	scenario, err := h.db.GetScenario(r.Context(), scenarioID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to get scenario")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get scenario")
+		return
+	}
+	if scenario == nil || !inLabScope(r, scenario.LabID) {
+		h.writeError(w, r, http.StatusNotFound, "scenario_not_found", "Scenario not found")
+		return
+	}
+
+	// Cancel any pending jobs first
+	_, err = h.scheduler.CancelScenarioJobs(r.Context(), scenarioID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to cancel jobs")
+	}
+
+	if err := h.db.DeleteScenario(r.Context(), scenarioID); err != nil {
+		if err.Error() == "scenario not found: "+scenarioID {
+			h.writeError(w, r, http.StatusNotFound, "scenario_not_found", "Scenario not found")
+			return
+		}
+		h.logger.Error().Err(err).Str("scenario_id", scenarioID).Msg("Failed to delete scenario")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete scenario")
+		return
+	}
+
+	h.publishScenario(events.ScenarioDeleted, scenario, map[string]interface{}{})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ============================================================
+// Event Stream Handlers
+// ============================================================
+
+// eventStreamKeepAlive is how often an idle event stream receives a comment
+// line so proxies do not close it.
+const eventStreamKeepAlive = 15 * time.Second
+
+// eventStreamRetryMs is the reconnect delay suggested to SSE clients.
+const eventStreamRetryMs = 3000
+
+// StreamEvents handles GET /api/events
+//
+// It streams events as Server-Sent Events. Query parameters filter by type
+// (comma-separated, e.g. "job.completed,agent"), agent_id and scenario_id.
+// Clients resume with the Last-Event-ID header (or last_event_id query
+// parameter); if events were missed a "resync" event is sent first and the
+// client should reload its state.
+func (h *Handlers) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		h.writeError(w, r, http.StatusServiceUnavailable, "events_unavailable", "Event stream is not enabled")
+		return
+	}
+
+	q := r.URL.Query()
+	filter := events.Filter{
+		LabID:      labScope(r),
+		AgentID:    q.Get("agent_id"),
+		ScenarioID: q.Get("scenario_id"),
+	}
+	filter.Types = splitList(q.Get("type"))
+
+	var lastEventID uint64
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = q.Get("last_event_id")
+	}
+	if lastID != "" {
+		id, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Last-Event-ID must be a number")
+			return
+		}
+		lastEventID = id
+	}
+
+	sub, backlog, complete := h.events.Subscribe(filter, lastEventID)
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// The stream outlives the server's write timeout
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventStreamRetryMs)
+	if !complete {
+		fmt.Fprintf(w, "id: %d\nevent: resync\ndata: {}\n\n", sub.StartID())
+	}
+	for _, e := range backlog {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		h.logger.Error().Err(err).Msg("Event stream does not support flushing")
+		return
+	}
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.Events():
+			if !ok {
+				// Dropped for falling behind; the client resumes from its last ID
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes a single SSE frame.
+func writeEvent(w io.Writer, e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+// ============================================================
+// Health Handlers
+// ============================================================
+
+// HealthCheck handles GET /health
+func (h *Handlers) HealthCheck(w http.ResponseWriter, r *http.Request) {
+	// Check database health
+	dbStatus, dbErr := h.db.Health(r.Context())
+
+	status := "healthy"
+	if dbErr != nil {
+		status = "unhealthy"
+	}
+
+	resp := protocol.HealthResponse{
+		Status:        status,
+		Version:       h.version,
+		UptimeSeconds: int64(time.Since(h.startTime).Seconds()),
+		Components: map[string]protocol.ComponentHealth{
+			"database": {
+				Status:    dbStatus,
+				LastCheck: time.Now(),
+			},
+		},
+	}
+
+	if dbErr != nil {
+		resp.Components["database"] = protocol.ComponentHealth{
+			Status:    "unhealthy",
+			LastCheck: time.Now(),
+			Details:   dbErr.Error(),
+		}
+	}
+
+	statusCode := http.StatusOK
+	if status != "healthy" {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	h.writeJSON(w, statusCode, resp)
+}
+
+// ReadyCheck handles GET /ready
+func (h *Handlers) ReadyCheck(w http.ResponseWriter, r *http.Request) {
+	// Check if we can handle requests
+	if err := h.db.Ping(r.Context()); err != nil {
+		h.writeError(w, r, http.StatusServiceUnavailable, "not_ready", "Database not available")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, map[string]bool{"ready": true})
+}
+
+// GetOpenAPI handles GET /api/openapi.json. The document is generated from
+// protocol.Endpoints and the protocol types.
+func (h *Handlers) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, protocol.OpenAPI(h.version))
+}
+
+// ============================================================
+// Helper methods
+// ============================================================
+
+func (h *Handlers) writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to encode JSON response")
+	}
+}
+
+// labScope returns the lab a request is scoped to, taken from the lab_id query
+// parameter or the X-Lab-ID header. An empty scope means all labs.
+func labScope(r *http.Request) string {
+	if labID := r.URL.Query().Get("lab_id"); labID != "" {
+		return labID
+	}
+	return r.Header.Get("X-Lab-ID")
+}
+
+// inLabScope reports whether a resource in labID is visible to the request.
+func inLabScope(r *http.Request, labID string) bool {
+	scope := labScope(r)
+	return scope == "" || scope == labID
+}
+
+// resolveLab picks the lab for a new resource (explicit ID, then request scope,
+// then the default lab) and verifies it exists. It writes an error response
+// and returns false on failure.
+func (h *Handlers) resolveLab(w http.ResponseWriter, r *http.Request, labID string) (string, bool) {
+	if labID == "" {
+		labID = labScope(r)
+	}
+	if labID == "" {
+		labID = storage.DefaultLabID
+	}
+
+	lab, err := h.db.GetLab(r.Context(), labID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("lab_id", labID).Msg("Failed to get lab")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get lab")
+		return "", false
+	}
+	if lab == nil {
+		h.writeError(w, r, http.StatusBadRequest, "lab_not_found", "Lab does not exist")
+		return "", false
+	}
+
+	return labID, true
+}
+
+func (h *Handlers) writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	resp := protocol.ErrorResponse{
+		Error:     code,
+		Message:   message,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+	h.writeJSON(w, status, resp)
+}
+
+// ============================================================
+// User Management Handlers (Impersonation Users)
+// ============================================================
+
+// CreateImpersonationUser handles POST /api/users
+func (h *Handlers) CreateImpersonationUser(w http.ResponseWriter, r *http.Request) {
+	var req protocol.CreateImpersonationUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+		return
+	}
+
+	// Validation
+	if req.Username == "" || req.Domain == "" || req.SAMAccountName == "" {
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "Username, domain, and sam_account_name are required")
+		return
+	}
+
+	labID, ok := h.resolveLab(w, r, req.LabID)
+	if !ok {
+		return
+	}
+
+	user := &storage.ImpersonationUser{
+		LabID:          labID,
+		Username:       req.Username,
+		Domain:         req.Domain,
+		SAMAccountName: req.SAMAccountName,
+		DisplayName:    req.DisplayName,
+		Department:     req.Department,
+		Title:          req.Title,
+		AllowedHosts:   req.AllowedHosts,
+	}
+
+	if req.Persona != nil {
+		user.Persona = &storage.UserPersona{
+			TypicalApps:  req.Persona.TypicalApps,
+			TypicalSites: req.Persona.TypicalSites,
+			FileTypes:    req.Persona.FileTypes,
+		}
+		if req.Persona.WorkHours != nil {
+			user.Persona.WorkHours = &storage.WorkHours{
+				Start: req.Persona.WorkHours.Start,
+				End:   req.Persona.WorkHours.End,
+			}
+		}
+	}
+
+	if err := h.db.CreateImpersonationUser(r.Context(), user); err != nil {
+		h.logger.Error().Err(err).Str("username", req.Username).Msg("Failed to create impersonation user")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create user")
+		return
+	}
+
+	h.writeJSON(w, http.StatusCreated, protocol.ImpersonationUserResponse{
+		ID:             user.ID,
+		LabID:          user.LabID,
+		Username:       user.Username,
+		Domain:         user.Domain,
+		SAMAccountName: user.SAMAccountName,
+		DisplayName:    user.DisplayName,
+		Department:     user.Department,
+		Title:          user.Title,
+		AllowedHosts:   user.AllowedHosts,
+		CreatedAt:      user.CreatedAt,
+	})
+}
+
+// GetImpersonationUser handles GET /api/users/{userID}
+func (h *Handlers) GetImpersonationUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+
+	user, err := h.db.GetImpersonationUser(r.Context(), userID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get impersonation user")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get user")
+		return
+	}
+
+	if user == nil || !inLabScope(r, user.LabID) {
+		h.writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, h.userToResponse(user))
+}
+
+// ListImpersonationUsers handles GET /api/users
+func (h *Handlers) ListImpersonationUsers(w http.ResponseWriter, r *http.Request) {
+	department := r.URL.Query().Get("department")
+
+	var users []*storage.ImpersonationUser
+	var err error
+
+	if department != "" {
+		users, err = h.db.ListImpersonationUsersByDepartment(r.Context(), labScope(r), department)
+	} else {
+		users, err = h.db.ListImpersonationUsers(r.Context(), labScope(r))
+	}
+
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to list impersonation users")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list users")
+		return
+	}
+
+	var userResponses []protocol.ImpersonationUserResponse
+	for _, user := range users {
+		userResponses = append(userResponses, h.userToResponse(user))
+	}
+
+	h.writeJSON(w, http.StatusOK, protocol.ListImpersonationUsersResponse{
+		Users: userResponses,
+		Total: len(userResponses),
+	})
+}
+
+// UpdateImpersonationUser handles PUT /api/users/{userID}
+func (h *Handlers) UpdateImpersonationUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+
+	var req protocol.UpdateImpersonationUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+		return
+	}
+
+	// Get existing user
+	user, err := h.db.GetImpersonationUser(r.Context(), userID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get impersonation user")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get user")
+		return
+	}
+
+	if user == nil || !inLabScope(r, user.LabID) {
+		h.writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
+	}
+
+	// Update fields
+	if req.DisplayName != nil {
+		user.DisplayName = *req.DisplayName
+	}
+	if req.Department != nil {
+		user.Department = *req.Department
+	}
+	if req.Title != nil {
+		user.Title = *req.Title
+	}
+	if req.AllowedHosts != nil {
+		user.AllowedHosts = req.AllowedHosts
+	}
+	if req.Persona != nil {
+		user.Persona = &storage.UserPersona{
+			TypicalApps:  req.Persona.TypicalApps,
+			TypicalSites: req.Persona.TypicalSites,
+			FileTypes:    req.Persona.FileTypes,
+		}
+		if req.Persona.WorkHours != nil {
+			user.Persona.WorkHours = &storage.WorkHours{
+				Start: req.Persona.WorkHours.Start,
+				End:   req.Persona.WorkHours.End,
+			}
+		}
+	}
+
+	if err := h.db.UpdateImpersonationUser(r.Context(), user); err != nil {
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to update impersonation user")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update user")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, h.userToResponse(user))
+}
+
+// DeleteImpersonationUser handles DELETE /api/users/{userID}
+func (h *Handlers) DeleteImpersonationUser(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+
+	if labScope(r) != "" {
+		user, err := h.db.GetImpersonationUser(r.Context(), userID)
+		if err != nil {
+			h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get impersonation user")
+			h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get user")
+			return
+		}
+		if user == nil || !inLabScope(r, user.LabID) {
+			h.writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+			return
+		}
+	}
+
+	if err := h.db.DeleteImpersonationUser(r.Context(), userID); err != nil {
+		if err.Error() == "impersonation user not found: "+userID {
+			h.writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+			return
+		}
+		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to delete impersonation user")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BulkCreateImpersonationUsers handles POST /api/users/bulk
+func (h *Handlers) BulkCreateImpersonationUsers(w http.ResponseWriter, r *http.Request) {
+	var req protocol.BulkCreateImpersonationUsersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+		return
+	}
+
+	if len(req.Users) == 0 {
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "At least one user is required")
+		return
+	}
+
+	var created []protocol.ImpersonationUserResponse
+	var errors []protocol.BulkUserError
+
+	labs := make(map[string]bool)
+
+	for i, userReq := range req.Users {
+		labID := userReq.LabID
+		if labID == "" {
+			labID = labScope(r)
+		}
+		if labID == "" {
+			labID = storage.DefaultLabID
+		}
+
+		if _, checked := labs[labID]; !checked {
+			lab, err := h.db.GetLab(r.Context(), labID)
+			if err != nil {
+				h.logger.Error().Err(err).Str("lab_id", labID).Msg("Failed to get lab")
+				h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get lab")
+				return
+			}
+			labs[labID] = lab != nil
+		}
+		if !labs[labID] {
+			errors = append(errors, protocol.BulkUserError{
+				Index:    i,
+				Username: userReq.Username,
+				Error:    "lab not found: " + labID,
+			})
+			continue
+		}
+
+		user := &storage.ImpersonationUser{
+			LabID:          labID,
+			Username:       userReq.Username,
+			Domain:         userReq.Domain,
+			SAMAccountName: userReq.SAMAccountName,
+			DisplayName:    userReq.DisplayName,
+			Department:     userReq.Department,
+			Title:          userReq.Title,
+			AllowedHosts:   userReq.AllowedHosts,
+		}
+
+		if userReq.Persona != nil {
+			user.Persona = &storage.UserPersona{
+				TypicalApps:  userReq.Persona.TypicalApps,
+				TypicalSites: userReq.Persona.TypicalSites,
+				FileTypes:    userReq.Persona.FileTypes,
+			}
+			if userReq.Persona.WorkHours != nil {
+				user.Persona.WorkHours = &storage.WorkHours{
+					Start: userReq.Persona.WorkHours.Start,
+					End:   userReq.Persona.WorkHours.End,
+				}
+			}
+		}
+
+		if err := h.db.CreateImpersonationUser(r.Context(), user); err != nil {
+			errors = append(errors, protocol.BulkUserError{
+				Index:    i,
+				Username: userReq.Username,
+				Error:    err.Error(),
+			})
+			continue
+		}
+
+		created = append(created, h.userToResponse(user))
+	}
+
+	h.writeJSON(w, http.StatusOK, protocol.BulkCreateImpersonationUsersResponse{
+		Created: created,
+		Errors:  errors,
+		Total:   len(req.Users),
+		Success: len(created),
+		Failed:  len(errors),
+	})
+}
+
+// userToResponse converts a storage user to a protocol response.
+func (h *Handlers) userToResponse(user *storage.ImpersonationUser) protocol.ImpersonationUserResponse {
+	resp := protocol.ImpersonationUserResponse{
+		ID:             user.ID,
+		LabID:          user.LabID,
+		Username:       user.Username,
+		Domain:         user.Domain,
+		SAMAccountName: user.SAMAccountName,
+		DisplayName:    user.DisplayName,
+		Department:     user.Department,
+		Title:          user.Title,
+		AllowedHosts:   user.AllowedHosts,
+		CreatedAt:      user.CreatedAt,
+		UpdatedAt:      user.UpdatedAt,
+	}
+
+	if user.Persona != nil {
+		resp.Persona = &protocol.UserPersonaResponse{
+			TypicalApps:  user.Persona.TypicalApps,
+			TypicalSites: user.Persona.TypicalSites,
+			FileTypes:    user.Persona.FileTypes,
+		}
+		if user.Persona.WorkHours != nil {
+			resp.Persona.WorkHours = &protocol.WorkHoursResponse{
+				Start: user.Persona.WorkHours.Start,
+				End:   user.Persona.WorkHours.End,
+			}
+		}
+	}
+
+	return resp
+}
+
+// ============================================================
+// Debug/Test Handlers
+// ============================================================
+
+// CreateTestJob handles POST /api/debug/test-job (creates a test job for the first online agent)
+func (h *Handlers) CreateTestJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Get first online agent
+	agents, err := h.registry.ListAgents(ctx, labScope(r))
+	if err != nil {
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list agents")
+		return
+	}
+
+	if len(agents) == 0 {
+		h.writeError(w, r, http.StatusBadRequest, "no_agents", "No agents registered")
+		return
+	}
+
+	agentID := agents[0].ID
+
+	// Create test job
+	job := &storage.Job{
+		ID:         uuid.New().String(),
+		AgentID:    agentID,
+		ActionType: "simulate_file_activity",
+		Parameters: map[string]interface{}{
+			"target_directory": "/tmp/cymconductor-test",
+			"operations":       []string{"create"},
+			"file_count":       3,
+			"file_types":       []string{"txt"},
+			"file_size_kb_min": 1,
+			"file_size_kb_max": 10,
+			"preserve_files":   true,
+		},
+		Status:      "pending",
+		Priority:    0,
+		MaxRetries:  3,
+		ScheduledAt: time.Now(),
+	}
+
+	if err := h.scheduler.CreateJob(ctx, job); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to create test job")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create job")
+		return
+	}
+
+	h.logger.Info().
+		Str("job_id", job.ID).
+		Str("agent_id", agentID).
+		Msg("Created test job")
+
+	h.writeJSON(w, http.StatusCreated, protocol.CreateTestJobResponse{
+		JobID:      job.ID,
+		AgentID:    agentID,
+		ActionType: job.ActionType,
+		Status:     job.Status,
+		Message:    "Test job created successfully. Agent will pick it up on next heartbeat.",
+	})
+}
+
+// ============================================================
+// Lab Handlers
+// ============================================================
+
+var labIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// ListLabs handles GET /api/labs
+func (h *Handlers) ListLabs(w http.ResponseWriter, r *http.Request) {
+	labs, err := h.db.ListLabs(r.Context())
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to list labs")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list labs")
+		return
+	}
+
+	resp := protocol.ListLabsResponse{
+		Labs:  make([]protocol.LabResponse, 0, len(labs)),
+		Total: len(labs),
+	}
+	for _, lab := range labs {
+		resp.Labs = append(resp.Labs, labToResponse(lab))
+	}
+
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// GetLab handles GET /api/labs/{labID}
+func (h *Handlers) GetLab(w http.ResponseWriter, r *http.Request) {
+	labID := chi.URLParam(r, "labID")
+
+	lab, err := h.db.GetLab(r.Context(), labID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("lab_id", labID).Msg("Failed to get lab")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get lab")
+		return
+	}
+	if lab == nil {
+		h.writeError(w, r, http.StatusNotFound, "lab_not_found", "Lab not found")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, labToResponse(lab))
+}
+
+// CreateLab handles POST /api/labs
+func (h *Handlers) CreateLab(w http.ResponseWriter, r *http.Request) {
+	var req protocol.CreateLabRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+		return
+	}
+
+	if !labIDPattern.MatchString(req.ID) || req.Name == "" {
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "A lowercase id slug and a name are required")
+		return
+	}
+	if !validCIDRs(req.AllowedNetworks) {
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "allowed_networks must contain valid CIDRs")
+		return
+	}
+
+	existing, err := h.db.GetLab(r.Context(), req.ID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("lab_id", req.ID).Msg("Failed to get lab")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create lab")
+		return
+	}
+	if existing != nil {
+		h.writeError(w, r, http.StatusConflict, "lab_exists", "A lab with this ID already exists")
+		return
+	}
+
+	lab := &storage.Lab{
+		ID:              req.ID,
+		Name:            req.Name,
+		Description:     req.Description,
+		AllowedNetworks: req.AllowedNetworks,
+		WebhookURL:      req.WebhookURL,
+	}
+
+	if err := h.db.CreateLab(r.Context(), lab); err != nil {
+		h.logger.Error().Err(err).Str("lab_id", req.ID).Msg("Failed to create lab")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create lab")
+		return
+	}
+
+	created, err := h.db.GetLab(r.Context(), lab.ID)
+	if err != nil || created == nil {
+		h.logger.Error().Err(err).Str("lab_id", lab.ID).Msg("Failed to reload lab")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create lab")
+		return
+	}
+
+	h.writeJSON(w, http.StatusCreated, labToResponse(created))
+}
+
+// UpdateLab handles PUT /api/labs/{labID}
+func (h *Handlers) UpdateLab(w http.ResponseWriter, r *http.Request) {
+	labID := chi.URLParam(r, "labID")
+
+	var req protocol.UpdateLabRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+		return
+	}
+
+	if !validCIDRs(req.AllowedNetworks) {
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "allowed_networks must contain valid CIDRs")
+		return
+	}
+
+	lab, err := h.db.GetLab(r.Context(), labID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("lab_id", labID).Msg("Failed to get lab")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get lab")
+		return
+	}
+	if lab == nil {
+		h.writeError(w, r, http.StatusNotFound, "lab_not_found", "Lab not found")
+		return
+	}
+
+	if req.Name != nil {
+		lab.Name = *req.Name
+	}
+	if req.Description != nil {
+		lab.Description = *req.Description
+	}
+	if req.AllowedNetworks != nil {
+		lab.AllowedNetworks = req.AllowedNetworks
+	}
+	if req.WebhookURL != nil {
+		lab.WebhookURL = *req.WebhookURL
+	}
+
+	if err := h.db.UpdateLab(r.Context(), lab); err != nil {
+		h.logger.Error().Err(err).Str("lab_id", labID).Msg("Failed to update lab")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update lab")
+		return
+	}
+
+	updated, err := h.db.GetLab(r.Context(), labID)
+	if err != nil || updated == nil {
+		h.logger.Error().Err(err).Str("lab_id", labID).Msg("Failed to reload lab")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update lab")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, labToResponse(updated))
+}
+
+// DeleteLab handles DELETE /api/labs/{labID}
+func (h *Handlers) DeleteLab(w http.ResponseWriter, r *http.Request) {
+	labID := chi.URLParam(r, "labID")
+
+	if labID == storage.DefaultLabID {
+		h.writeError(w, r, http.StatusConflict, "lab_protected", "The default lab cannot be deleted")
+		return
+	}
+
+	if err := h.db.DeleteLab(r.Context(), labID); err != nil {
+		switch err.Error() {
+		case "lab not found: " + labID:
+			h.writeError(w, r, http.StatusNotFound, "lab_not_found", "Lab not found")
+		case "lab not empty: " + labID:
+			h.writeError(w, r, http.StatusConflict, "lab_not_empty", "Lab still has agents, scenarios or users")
+		default:
+			h.logger.Error().Err(err).Str("lab_id", labID).Msg("Failed to delete lab")
+			h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete lab")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func labToResponse(lab *storage.Lab) protocol.LabResponse {
+	networks := lab.AllowedNetworks
+	if networks == nil {
+		networks = []string{}
+	}
+	return protocol.LabResponse{
+		ID:              lab.ID,
+		Name:            lab.Name,
+		Description:     lab.Description,
+		AllowedNetworks: networks,
+		WebhookURL:      lab.WebhookURL,
+		CreatedAt:       lab.CreatedAt,
+		UpdatedAt:       lab.UpdatedAt,
+	}
+}
+
+func validCIDRs(cidrs []string) bool {
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ============================================================
+// Audit Handlers
 // ============================================================
 
-func (h *Handlers) writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		h.logger.Error().Err(err).Msg("Failed to encode JSON response")
+// parseAuditFilter reads the audit query parameters shared by ListAudit and
+// ExportAudit. It writes a 400 response and returns false on invalid input.
+func (h *Handlers) parseAuditFilter(w http.ResponseWriter, r *http.Request) (storage.AuditFilter, bool) {
+	q := r.URL.Query()
+	filter := storage.AuditFilter{
+		EntityType: q.Get("entity_type"),
+		EntityID:   q.Get("entity_id"),
+		Action:     q.Get("action"),
+		Actor:      q.Get("actor"),
+	}
+
+	for param, dest := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				h.writeError(w, r, http.StatusBadRequest, "invalid_request", param+" must be an RFC 3339 timestamp")
+				return filter, false
+			}
+			*dest = t
+		}
 	}
+
+	return filter, true
 }
 
-func (h *Handlers) writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
-	resp := protocol.ErrorResponse{
-		Error:     code,
-		Message:   message,
-		RequestID: middleware.GetReqID(r.Context()),
+// ListAudit handles GET /api/audit
+//
+// Filters: entity_type, entity_id, action, actor and since/until (RFC 3339).
+// Entries are returned newest first; pass next_cursor as cursor for older
+// entries. limit defaults to 100 (max 1000).
+func (h *Handlers) ListAudit(w http.ResponseWriter, r *http.Request) {
+	filter, ok := h.parseAuditFilter(w, r)
+	if !ok {
+		return
 	}
-	h.writeJSON(w, status, resp)
+
+	filter.Limit = 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			filter.Limit = l
+		}
+	}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		id, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || id <= 0 {
+			h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Invalid cursor")
+			return
+		}
+		filter.BeforeID = id
+	}
+
+	entries, err := h.db.ListAuditEntries(r.Context(), filter)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to list audit entries")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list audit entries")
+		return
+	}
+
+	resp := protocol.ListAuditResponse{
+		Entries: make([]protocol.AuditEntryResponse, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		resp.Entries = append(resp.Entries, auditEntryToResponse(entry))
+	}
+	if len(entries) == filter.Limit {
+		resp.NextCursor = strconv.FormatInt(entries[len(entries)-1].ID, 10)
+	}
+
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// ExportAudit handles GET /api/audit/export
+//
+// Streams every matching entry, oldest first, as JSON lines (format=jsonl,
+// the default) or CSV (format=csv). Accepts the same filters as ListAudit.
+func (h *Handlers) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	filter, ok := h.parseAuditFilter(w, r)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "jsonl"
+	}
+
+	var write func(*storage.AuditEntry) error
+	var flush func() error
+	switch format {
+	case "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		write = func(entry *storage.AuditEntry) error {
+			return enc.Encode(auditEntryToResponse(entry))
+		}
+		flush = func() error { return nil }
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"id", "created_at", "entity_type", "entity_id", "action", "actor", "old_value", "new_value", "metadata"}); err != nil {
+			return
+		}
+		write = func(entry *storage.AuditEntry) error {
+			return cw.Write([]string{
+				strconv.FormatInt(entry.ID, 10),
+				entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+				entry.EntityType,
+				entry.EntityID,
+				entry.Action,
+				entry.Actor,
+				auditJSON(entry.OldValue),
+				auditJSON(entry.NewValue),
+				auditJSON(entry.Metadata),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "format must be jsonl or csv")
+		return
+	}
+
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="audit-%s.%s"`, time.Now().UTC().Format("20060102T150405Z"), format))
+
+	// Headers are sent with the first entry, so errors from here on can only
+	// be logged
+	if err := h.db.ExportAuditEntries(r.Context(), filter, write); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to export audit entries")
+		return
+	}
+	if err := flush(); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to export audit entries")
+	}
+}
+
+func auditEntryToResponse(entry *storage.AuditEntry) protocol.AuditEntryResponse {
+	return protocol.AuditEntryResponse{
+		ID:         entry.ID,
+		EntityType: entry.EntityType,
+		EntityID:   entry.EntityID,
+		Action:     entry.Action,
+		Actor:      entry.Actor,
+		OldValue:   entry.OldValue,
+		NewValue:   entry.NewValue,
+		Metadata:   entry.Metadata,
+		CreatedAt:  entry.CreatedAt,
+	}
+}
+
+// auditJSON encodes an audit value for a CSV cell.
+func auditJSON(v map[string]interface{}) string {
+	if v == nil {
+		return ""
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
 }
 
 // ============================================================
-// User Management Handlers (Impersonation Users)
+// Backup and State Transfer Handlers
 // ============================================================
 
-// CreateImpersonationUser handles POST /api/users
-func (h *Handlers) CreateImpersonationUser(w http.ResponseWriter, r *http.Request) {
-	var req protocol.CreateImpersonationUserRequest
+// BackupDatabase handles GET /api/admin/backup. It takes an online backup of
+// the database and streams it as a SQLite file that can later be restored
+// with the orchestrator's -restore flag. Stores without a backing file, such
+// as the in-memory demo store, answer 501.
+func (h *Handlers) BackupDatabase(w http.ResponseWriter, r *http.Request) {
+	backuper, ok := h.db.(storage.Backuper)
+	if !ok {
+		h.writeError(w, r, http.StatusNotImplemented, "not_implemented", "The configured store does not support backups")
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "cymconductor-backup-")
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to create backup directory")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to back up database")
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "orchestrator.db")
+	if err := backuper.Backup(r.Context(), path); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to back up database")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to back up database")
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to open database backup")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to back up database")
+		return
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="orchestrator-%s.db"`, time.Now().UTC().Format("20060102T150405Z")))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, f); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to send database backup")
+	}
+}
+
+// ExportState handles GET /api/admin/export
+func (h *Handlers) ExportState(w http.ResponseWriter, r *http.Request) {
+	doc, err := backup.Export(r.Context(), h.db, h.version)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to export state")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to export state")
+		return
+	}
+
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="cymconductor-state-%s.json"`, doc.ExportedAt.Format("20060102T150405Z")))
+	h.writeJSON(w, http.StatusOK, doc)
+}
+
+// ImportState handles POST /api/admin/import. Existing labs and users are
+// skipped unless ?overwrite=true.
+func (h *Handlers) ImportState(w http.ResponseWriter, r *http.Request) {
+	var doc protocol.StateExport
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+		return
+	}
+
+	overwrite := false
+	if v := r.URL.Query().Get("overwrite"); v != "" {
+		var err error
+		if overwrite, err = strconv.ParseBool(v); err != nil {
+			h.writeError(w, r, http.StatusBadRequest, "invalid_request", "overwrite must be true or false")
+			return
+		}
+	}
+
+	if doc.Format != protocol.StateExportFormat || doc.Version < 1 || doc.Version > protocol.StateExportVersion {
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed",
+			fmt.Sprintf("Expected a %s document of version 1-%d", protocol.StateExportFormat, protocol.StateExportVersion))
+		return
+	}
+
+	result, err := backup.Import(r.Context(), h.db, &doc, backup.ImportOptions{
+		Overwrite: overwrite,
+		Validator: h.validator,
+	})
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to import state")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to import state")
+		return
+	}
+
+	h.logger.Info().
+		Interface("labs", result.Labs).
+		Interface("users", result.Users).
+		Interface("scenarios", result.Scenarios).
+		Int("errors", len(result.Errors)).
+		Msg("State imported")
+
+	h.writeJSON(w, http.StatusOK, result)
+}
+
+// ============================================================
+// API Key Handlers
+// ============================================================
+
+// WhoAmI handles GET /api/auth/whoami
+func (h *Handlers) WhoAmI(w http.ResponseWriter, r *http.Request) {
+	resp := protocol.WhoAmIResponse{Actor: auth.ActorFromContext(r.Context())}
+	if principal := auth.PrincipalFromContext(r.Context()); principal != nil {
+		resp.Role = string(principal.Role)
+		resp.KeyID = principal.KeyID
+	}
+
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// CreateAPIKey handles POST /api/auth/keys
+func (h *Handlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req protocol.CreateAPIKeyRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
 		return
 	}
 
-	// Validation
-	if req.Username == "" || req.Domain == "" || req.SAMAccountName == "" {
-		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "Username, domain, and sam_account_name are required")
+	if req.Name == "" {
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "Name is required")
 		return
 	}
 
-	user := &storage.ImpersonationUser{
-		Username:       req.Username,
-		Domain:         req.Domain,
-		SAMAccountName: req.SAMAccountName,
-		DisplayName:    req.DisplayName,
-		Department:     req.Department,
-		Title:          req.Title,
-		AllowedHosts:   req.AllowedHosts,
+	role, ok := auth.ParseRole(req.Role)
+	if !ok {
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "Role must be one of viewer, operator, admin")
+		return
 	}
 
-	if req.Persona != nil {
-		user.Persona = &storage.UserPersona{
-			TypicalApps:  req.Persona.TypicalApps,
-			TypicalSites: req.Persona.TypicalSites,
-			FileTypes:    req.Persona.FileTypes,
-		}
-		if req.Persona.WorkHours != nil {
-			user.Persona.WorkHours = &storage.WorkHours{
-				Start: req.Persona.WorkHours.Start,
-				End:   req.Persona.WorkHours.End,
-			}
-		}
+	key, prefix, err := auth.GenerateKey()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to generate API key")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to generate API key")
+		return
 	}
 
-	if err := h.db.CreateImpersonationUser(r.Context(), user); err != nil {
-		h.logger.Error().Err(err).Str("username", req.Username).Msg("Failed to create impersonation user")
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create user")
+	actor := auth.ActorFromContext(r.Context())
+	apiKey := &storage.APIKey{
+		Name:      req.Name,
+		KeyPrefix: prefix,
+		KeyHash:   auth.HashKey(key),
+		Role:      string(role),
+		CreatedBy: &actor,
+		ExpiresAt: req.ExpiresAt,
+	}
+
+	if err := h.db.CreateAPIKey(r.Context(), apiKey); err != nil {
+		h.logger.Error().Err(err).Str("name", req.Name).Msg("Failed to create API key")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create API key")
 		return
 	}
 
-	h.writeJSON(w, http.StatusCreated, protocol.ImpersonationUserResponse{
-		ID:             user.ID,
-		Username:       user.Username,
-		Domain:         user.Domain,
-		SAMAccountName: user.SAMAccountName,
-		DisplayName:    user.DisplayName,
-		Department:     user.Department,
-		Title:          user.Title,
-		AllowedHosts:   user.AllowedHosts,
-		CreatedAt:      user.CreatedAt,
+	h.writeJSON(w, http.StatusCreated, protocol.CreateAPIKeyResponse{
+		APIKeyResponse: apiKeyToResponse(apiKey),
+		Key:            key,
 	})
 }
 
-// GetImpersonationUser handles GET /api/users/{userID}
-func (h *Handlers) GetImpersonationUser(w http.ResponseWriter, r *http.Request) {
-	userID := chi.URLParam(r, "userID")
+// ListAPIKeys handles GET /api/auth/keys
+func (h *Handlers) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.db.ListAPIKeys(r.Context())
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to list API keys")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list API keys")
+		return
+	}
 
-	user, err := h.db.GetImpersonationUser(r.Context(), userID)
+	resp := protocol.ListAPIKeysResponse{
+		Keys:  make([]protocol.APIKeyResponse, 0, len(keys)),
+		Total: len(keys),
+	}
+	for _, key := range keys {
+		resp.Keys = append(resp.Keys, apiKeyToResponse(key))
+	}
+
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// RevokeAPIKey handles DELETE /api/auth/keys/{keyID}
+func (h *Handlers) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID := chi.URLParam(r, "keyID")
+
+	if err := h.db.RevokeAPIKey(r.Context(), keyID); err != nil {
+		if err.Error() == "api key not found: "+keyID {
+			h.writeError(w, r, http.StatusNotFound, "api_key_not_found", "API key not found or already revoked")
+			return
+		}
+		h.logger.Error().Err(err).Str("key_id", keyID).Msg("Failed to revoke API key")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to revoke API key")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiKeyToResponse(key *storage.APIKey) protocol.APIKeyResponse {
+	resp := protocol.APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		KeyPrefix:  key.KeyPrefix,
+		Role:       key.Role,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+		ExpiresAt:  key.ExpiresAt,
+		RevokedAt:  key.RevokedAt,
+	}
+	if key.CreatedBy != nil {
+		resp.CreatedBy = *key.CreatedBy
+	}
+	return resp
+}
+
+// ============================================================
+// Webhook Subscription Handlers
+// ============================================================
+
+// Webhook retry policy limits, matching the protocol request validation.
+const (
+	maxWebhookRetries        = 10
+	minWebhookRetryBackoffMs = 100
+	maxWebhookRetryBackoffMs = 300000
+	minWebhookTimeoutMs      = 100
+	maxWebhookTimeoutMs      = 60000
+	minWebhookSecretLength   = 16
+)
+
+// ListWebhooks handles GET /api/webhooks
+//
+// With a lab scope, only subscriptions for that lab or for all labs are listed.
+func (h *Handlers) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	subs, err := h.db.ListWebhookSubscriptions(r.Context())
 	if err != nil {
-		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get impersonation user")
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get user")
+		h.logger.Error().Err(err).Msg("Failed to list webhook subscriptions")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list webhook subscriptions")
+		return
+	}
+
+	resp := protocol.ListWebhooksResponse{
+		Webhooks: make([]protocol.WebhookResponse, 0, len(subs)),
+	}
+	for _, sub := range subs {
+		if sub.LabID != "" && !inLabScope(r, sub.LabID) {
+			continue
+		}
+		resp.Webhooks = append(resp.Webhooks, webhookToResponse(sub, false))
+	}
+	resp.Total = len(resp.Webhooks)
+
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// GetWebhook handles GET /api/webhooks/{webhookID}
+func (h *Handlers) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, webhookToResponse(sub, false))
+}
+
+// CreateWebhook handles POST /api/webhooks
+//
+// The secret is generated unless one is given, and is only returned here.
+func (h *Handlers) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req protocol.CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+		return
+	}
+
+	actor := auth.ActorFromContext(r.Context())
+	sub := &storage.WebhookSubscription{
+		Name:         req.Name,
+		URL:          req.URL,
+		Secret:       req.Secret,
+		EventTypes:   req.EventTypes,
+		LabID:        req.LabID,
+		ScenarioID:   req.ScenarioID,
+		Enabled:      req.Enabled == nil || *req.Enabled,
+		MaxRetries:   webhooks.DefaultMaxRetries,
+		RetryBackoff: webhooks.DefaultRetryBackoff,
+		Timeout:      webhooks.DefaultTimeout,
+		CreatedBy:    &actor,
+	}
+	if req.MaxRetries != nil {
+		sub.MaxRetries = *req.MaxRetries
+	}
+	if req.RetryBackoffMs != nil {
+		sub.RetryBackoff = time.Duration(*req.RetryBackoffMs) * time.Millisecond
+	}
+	if req.TimeoutMs != nil {
+		sub.Timeout = time.Duration(*req.TimeoutMs) * time.Millisecond
+	}
+
+	if sub.Secret == "" {
+		secret, err := webhooks.GenerateSecret()
+		if err != nil {
+			h.logger.Error().Err(err).Msg("Failed to generate webhook secret")
+			h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to generate webhook secret")
+			return
+		}
+		sub.Secret = secret
+	}
+
+	if !h.validateWebhook(w, r, sub) {
+		return
+	}
+
+	if err := h.db.CreateWebhookSubscription(r.Context(), sub); err != nil {
+		h.logger.Error().Err(err).Str("name", sub.Name).Msg("Failed to create webhook subscription")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create webhook subscription")
+		return
+	}
+	h.reloadWebhooks(r.Context())
+
+	h.writeJSON(w, http.StatusCreated, webhookToResponse(sub, true))
+}
+
+// UpdateWebhook handles PUT /api/webhooks/{webhookID}
+//
+// The secret is returned only if it was replaced or rotated.
+func (h *Handlers) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req protocol.UpdateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+		return
+	}
+	if req.RotateSecret && req.Secret != nil {
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "Set either secret or rotate_secret, not both")
+		return
+	}
+
+	sub, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	if req.Name != nil {
+		sub.Name = *req.Name
+	}
+	if req.URL != nil {
+		sub.URL = *req.URL
+	}
+	if req.Secret != nil {
+		sub.Secret = *req.Secret
+	}
+	if req.EventTypes != nil {
+		sub.EventTypes = *req.EventTypes
+	}
+	if req.LabID != nil {
+		sub.LabID = *req.LabID
+	}
+	if req.ScenarioID != nil {
+		sub.ScenarioID = *req.ScenarioID
+	}
+	if req.Enabled != nil {
+		sub.Enabled = *req.Enabled
+	}
+	if req.MaxRetries != nil {
+		sub.MaxRetries = *req.MaxRetries
+	}
+	if req.RetryBackoffMs != nil {
+		sub.RetryBackoff = time.Duration(*req.RetryBackoffMs) * time.Millisecond
+	}
+	if req.TimeoutMs != nil {
+		sub.Timeout = time.Duration(*req.TimeoutMs) * time.Millisecond
+	}
+	if req.RotateSecret {
+		secret, err := webhooks.GenerateSecret()
+		if err != nil {
+			h.logger.Error().Err(err).Msg("Failed to generate webhook secret")
+			h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to generate webhook secret")
+			return
+		}
+		sub.Secret = secret
+	}
+
+	if !h.validateWebhook(w, r, sub) {
+		return
+	}
+
+	if err := h.db.UpdateWebhookSubscription(r.Context(), sub); err != nil {
+		h.logger.Error().Err(err).Str("subscription_id", sub.ID).Msg("Failed to update webhook subscription")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update webhook subscription")
 		return
 	}
+	h.reloadWebhooks(r.Context())
 
-	if user == nil {
-		h.writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+	updated, err := h.db.GetWebhookSubscription(r.Context(), sub.ID)
+	if err != nil || updated == nil {
+		h.logger.Error().Err(err).Str("subscription_id", sub.ID).Msg("Failed to reload webhook subscription")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update webhook subscription")
 		return
 	}
 
-	h.writeJSON(w, http.StatusOK, h.userToResponse(user))
+	h.writeJSON(w, http.StatusOK, webhookToResponse(updated, req.Secret != nil || req.RotateSecret))
 }
 
-// ListImpersonationUsers handles GET /api/users
-func (h *Handlers) ListImpersonationUsers(w http.ResponseWriter, r *http.Request) {
-	department := r.URL.Query().Get("department")
+// DeleteWebhook handles DELETE /api/webhooks/{webhookID}
+func (h *Handlers) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
 
-	var users []*storage.ImpersonationUser
-	var err error
+	if err := h.db.DeleteWebhookSubscription(r.Context(), sub.ID); err != nil {
+		h.logger.Error().Err(err).Str("subscription_id", sub.ID).Msg("Failed to delete webhook subscription")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete webhook subscription")
+		return
+	}
+	h.reloadWebhooks(r.Context())
 
-	if department != "" {
-		users, err = h.db.ListImpersonationUsersByDepartment(r.Context(), department)
-	} else {
-		users, err = h.db.ListImpersonationUsers(r.Context())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWebhookDeliveries handles GET /api/webhooks/{webhookID}/deliveries
+func (h *Handlers) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	sub, ok := h.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	limit := storage.WebhookDeliveryHistory
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			h.writeError(w, r, http.StatusBadRequest, "invalid_request", "limit must be a positive number")
+			return
+		}
+		limit = n
 	}
 
+	deliveries, err := h.db.ListWebhookDeliveries(r.Context(), sub.ID, limit)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("Failed to list impersonation users")
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list users")
+		h.logger.Error().Err(err).Str("subscription_id", sub.ID).Msg("Failed to list webhook deliveries")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list webhook deliveries")
 		return
 	}
 
-	var userResponses []protocol.ImpersonationUserResponse
-	for _, user := range users {
-		userResponses = append(userResponses, h.userToResponse(user))
+	resp := protocol.ListWebhookDeliveriesResponse{
+		Deliveries: make([]protocol.WebhookDeliveryResponse, 0, len(deliveries)),
+		Total:      len(deliveries),
+	}
+	for _, delivery := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, webhookDeliveryToResponse(delivery))
 	}
 
-	h.writeJSON(w, http.StatusOK, protocol.ListImpersonationUsersResponse{
-		Users: userResponses,
-		Total: len(userResponses),
-	})
+	h.writeJSON(w, http.StatusOK, resp)
 }
 
-// UpdateImpersonationUser handles PUT /api/users/{userID}
-func (h *Handlers) UpdateImpersonationUser(w http.ResponseWriter, r *http.Request) {
-	userID := chi.URLParam(r, "userID")
+// TestWebhook handles POST /api/webhooks/{webhookID}/test
+//
+// It sends a "webhook.test" event once, without retries, and returns the
+// delivery whether or not it succeeded.
+func (h *Handlers) TestWebhook(w http.ResponseWriter, r *http.Request) {
+	if h.webhooks == nil {
+		h.writeError(w, r, http.StatusServiceUnavailable, "webhooks_unavailable", "Webhook delivery is not enabled")
+		return
+	}
 
-	var req protocol.UpdateImpersonationUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+	sub, ok := h.loadWebhook(w, r)
+	if !ok {
 		return
 	}
 
-	// Get existing user
-	user, err := h.db.GetImpersonationUser(r.Context(), userID)
+	delivery := h.webhooks.Test(r.Context(), sub)
+	h.writeJSON(w, http.StatusOK, webhookDeliveryToResponse(delivery))
+}
+
+// loadWebhook fetches the subscription named in the URL, writing a 404 if it
+// does not exist or is outside the request's lab scope.
+func (h *Handlers) loadWebhook(w http.ResponseWriter, r *http.Request) (*storage.WebhookSubscription, bool) {
+	webhookID := chi.URLParam(r, "webhookID")
+
+	sub, err := h.db.GetWebhookSubscription(r.Context(), webhookID)
 	if err != nil {
-		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get impersonation user")
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get user")
-		return
+		h.logger.Error().Err(err).Str("subscription_id", webhookID).Msg("Failed to get webhook subscription")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get webhook subscription")
+		return nil, false
+	}
+	if sub == nil || (sub.LabID != "" && !inLabScope(r, sub.LabID)) {
+		h.writeError(w, r, http.StatusNotFound, "webhook_not_found", "Webhook subscription not found")
+		return nil, false
 	}
+	return sub, true
+}
 
-	if user == nil {
-		h.writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
-		return
+// validateWebhook checks a subscription before it is saved, writing an error
+// response and returning false if it is invalid.
+func (h *Handlers) validateWebhook(w http.ResponseWriter, r *http.Request, sub *storage.WebhookSubscription) bool {
+	fail := func(msg string) bool {
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed", msg)
+		return false
 	}
 
-	// Update fields
-	if req.DisplayName != nil {
-		user.DisplayName = *req.DisplayName
+	if sub.Name == "" || len(sub.Name) > 64 {
+		return fail("A name of at most 64 characters is required")
 	}
-	if req.Department != nil {
-		user.Department = *req.Department
+	if u, err := url.Parse(sub.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fail("url must be an http(s) URL")
 	}
-	if req.Title != nil {
-		user.Title = *req.Title
+	if len(sub.Secret) < minWebhookSecretLength {
+		return fail(fmt.Sprintf("secret must be at least %d characters", minWebhookSecretLength))
 	}
-	if req.AllowedHosts != nil {
-		user.AllowedHosts = req.AllowedHosts
+	for _, t := range sub.EventTypes {
+		if !events.KnownType(t) {
+			return fail(fmt.Sprintf("Unknown event type %q", t))
+		}
 	}
-	if req.Persona != nil {
-		user.Persona = &storage.UserPersona{
-			TypicalApps:  req.Persona.TypicalApps,
-			TypicalSites: req.Persona.TypicalSites,
-			FileTypes:    req.Persona.FileTypes,
+	if sub.MaxRetries < 0 || sub.MaxRetries > maxWebhookRetries {
+		return fail(fmt.Sprintf("max_retries must be between 0 and %d", maxWebhookRetries))
+	}
+	if ms := sub.RetryBackoff.Milliseconds(); ms < minWebhookRetryBackoffMs || ms > maxWebhookRetryBackoffMs {
+		return fail(fmt.Sprintf("retry_backoff_ms must be between %d and %d", minWebhookRetryBackoffMs, maxWebhookRetryBackoffMs))
+	}
+	if ms := sub.Timeout.Milliseconds(); ms < minWebhookTimeoutMs || ms > maxWebhookTimeoutMs {
+		return fail(fmt.Sprintf("timeout_ms must be between %d and %d", minWebhookTimeoutMs, maxWebhookTimeoutMs))
+	}
+
+	if sub.LabID != "" {
+		lab, err := h.db.GetLab(r.Context(), sub.LabID)
+		if err != nil {
+			h.logger.Error().Err(err).Str("lab_id", sub.LabID).Msg("Failed to get lab")
+			h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get lab")
+			return false
 		}
-		if req.Persona.WorkHours != nil {
-			user.Persona.WorkHours = &storage.WorkHours{
-				Start: req.Persona.WorkHours.Start,
-				End:   req.Persona.WorkHours.End,
-			}
+		if lab == nil {
+			h.writeError(w, r, http.StatusNotFound, "lab_not_found", "Lab not found")
+			return false
+		}
+	}
+	if sub.ScenarioID != "" {
+		scenario, err := h.db.GetScenario(r.Context(), sub.ScenarioID)
+		if err != nil {
+			h.logger.Error().Err(err).Str("scenario_id", sub.ScenarioID).Msg("Failed to get scenario")
+			h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get scenario")
+			return false
+		}
+		if scenario == nil {
+			h.writeError(w, r, http.StatusNotFound, "scenario_not_found", "Scenario not found")
+			return false
 		}
 	}
 
-	if err := h.db.UpdateImpersonationUser(r.Context(), user); err != nil {
-		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to update impersonation user")
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to update user")
-		return
+	existing, err := h.db.ListWebhookSubscriptions(r.Context())
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to list webhook subscriptions")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to save webhook subscription")
+		return false
+	}
+	for _, other := range existing {
+		if other.ID != sub.ID && other.Name == sub.Name {
+			h.writeError(w, r, http.StatusConflict, "webhook_exists", "A webhook subscription with this name already exists")
+			return false
+		}
 	}
 
-	h.writeJSON(w, http.StatusOK, h.userToResponse(user))
+	return true
 }
 
-// DeleteImpersonationUser handles DELETE /api/users/{userID}
-func (h *Handlers) DeleteImpersonationUser(w http.ResponseWriter, r *http.Request) {
-	userID := chi.URLParam(r, "userID")
-
-	if err := h.db.DeleteImpersonationUser(r.Context(), userID); err != nil {
-		if err.Error() == "impersonation user not found: "+userID {
-			h.writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
-			return
-		}
-		h.logger.Error().Err(err).Str("user_id", userID).Msg("Failed to delete impersonation user")
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to delete user")
+// reloadWebhooks refreshes the dispatcher after subscriptions change.
+func (h *Handlers) reloadWebhooks(ctx context.Context) {
+	if h.webhooks == nil {
 		return
 	}
-
-	w.WriteHeader(http.StatusNoContent)
+	if err := h.webhooks.Reload(ctx); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to reload webhook subscriptions")
+	}
 }
 
-// BulkCreateImpersonationUsers handles POST /api/users/bulk
-func (h *Handlers) BulkCreateImpersonationUsers(w http.ResponseWriter, r *http.Request) {
-	var req protocol.BulkCreateImpersonationUsersRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
-		return
+func webhookToResponse(sub *storage.WebhookSubscription, withSecret bool) protocol.WebhookResponse {
+	eventTypes := sub.EventTypes
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	resp := protocol.WebhookResponse{
+		ID:             sub.ID,
+		Name:           sub.Name,
+		URL:            sub.URL,
+		EventTypes:     eventTypes,
+		LabID:          sub.LabID,
+		ScenarioID:     sub.ScenarioID,
+		Enabled:        sub.Enabled,
+		MaxRetries:     sub.MaxRetries,
+		RetryBackoffMs: sub.RetryBackoff.Milliseconds(),
+		TimeoutMs:      sub.Timeout.Milliseconds(),
+		CreatedAt:      sub.CreatedAt,
+		UpdatedAt:      sub.UpdatedAt,
+	}
+	if withSecret {
+		resp.Secret = sub.Secret
+	}
+	if sub.CreatedBy != nil {
+		resp.CreatedBy = *sub.CreatedBy
 	}
+	return resp
+}
 
-	if len(req.Users) == 0 {
-		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "At least one user is required")
-		return
+func webhookDeliveryToResponse(delivery *storage.WebhookDelivery) protocol.WebhookDeliveryResponse {
+	resp := protocol.WebhookDeliveryResponse{
+		ID:             delivery.ID,
+		SubscriptionID: delivery.SubscriptionID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Test:           delivery.Test,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		ResponseStatus: delivery.ResponseStatus,
+		DurationMs:     delivery.DurationMs,
+		CreatedAt:      delivery.CreatedAt,
+		CompletedAt:    delivery.CompletedAt,
+	}
+	if delivery.Error != nil {
+		resp.Error = *delivery.Error
 	}
+	return resp
+}
 
-	var created []protocol.ImpersonationUserResponse
-	var errors []protocol.BulkUserError
+// ============================================================
+// Outbox Handlers
+// ============================================================
 
-	for i, userReq := range req.Users {
-		user := &storage.ImpersonationUser{
-			Username:       userReq.Username,
-			Domain:         userReq.Domain,
-			SAMAccountName: userReq.SAMAccountName,
-			DisplayName:    userReq.DisplayName,
-			Department:     userReq.Department,
-			Title:          userReq.Title,
-			AllowedHosts:   userReq.AllowedHosts,
+// ListOutbox handles GET /api/outbox
+//
+// Filters: target (scoring, messenger) and status (pending, delivered, dead).
+// Entries are returned newest first without their payloads; limit defaults
+// to 100 (max 1000).
+func (h *Handlers) ListOutbox(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := storage.OutboxFilter{
+		Target: q.Get("target"),
+		Status: q.Get("status"),
+		Limit:  100,
+	}
+	if limitStr := q.Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			filter.Limit = l
 		}
+	}
 
-		if userReq.Persona != nil {
-			user.Persona = &storage.UserPersona{
-				TypicalApps:  userReq.Persona.TypicalApps,
-				TypicalSites: userReq.Persona.TypicalSites,
-				FileTypes:    userReq.Persona.FileTypes,
-			}
-			if userReq.Persona.WorkHours != nil {
-				user.Persona.WorkHours = &storage.WorkHours{
-					Start: userReq.Persona.WorkHours.Start,
-					End:   userReq.Persona.WorkHours.End,
-				}
-			}
-		}
+	entries, err := h.db.ListOutboxEntries(r.Context(), filter)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to list outbox entries")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list outbox entries")
+		return
+	}
 
-		if err := h.db.CreateImpersonationUser(r.Context(), user); err != nil {
-			errors = append(errors, protocol.BulkUserError{
-				Index:    i,
-				Username: userReq.Username,
-				Error:    err.Error(),
-			})
-			continue
-		}
+	counts, err := h.db.CountOutboxEntries(r.Context())
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to count outbox entries")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to count outbox entries")
+		return
+	}
 
-		created = append(created, h.userToResponse(user))
+	resp := protocol.ListOutboxResponse{
+		Entries: make([]protocol.OutboxEntryResponse, 0, len(entries)),
+		Total:   len(entries),
+		Counts:  counts,
+	}
+	for _, entry := range entries {
+		resp.Entries = append(resp.Entries, outboxEntryToResponse(entry, false))
 	}
 
-	h.writeJSON(w, http.StatusOK, protocol.BulkCreateImpersonationUsersResponse{
-		Created: created,
-		Errors:  errors,
-		Total:   len(req.Users),
-		Success: len(created),
-		Failed:  len(errors),
-	})
+	h.writeJSON(w, http.StatusOK, resp)
 }
 
-// userToResponse converts a storage user to a protocol response.
-func (h *Handlers) userToResponse(user *storage.ImpersonationUser) protocol.ImpersonationUserResponse {
-	resp := protocol.ImpersonationUserResponse{
-		ID:             user.ID,
-		Username:       user.Username,
-		Domain:         user.Domain,
-		SAMAccountName: user.SAMAccountName,
-		DisplayName:    user.DisplayName,
-		Department:     user.Department,
-		Title:          user.Title,
-		AllowedHosts:   user.AllowedHosts,
-		CreatedAt:      user.CreatedAt,
-		UpdatedAt:      user.UpdatedAt,
-	}
-
-	if user.Persona != nil {
-		resp.Persona = &protocol.UserPersonaResponse{
-			TypicalApps:  user.Persona.TypicalApps,
-			TypicalSites: user.Persona.TypicalSites,
-			FileTypes:    user.Persona.FileTypes,
-		}
-		if user.Persona.WorkHours != nil {
-			resp.Persona.WorkHours = &protocol.WorkHoursResponse{
-				Start: user.Persona.WorkHours.Start,
-				End:   user.Persona.WorkHours.End,
-			}
-		}
+// GetOutboxEntry handles GET /api/outbox/{entryID}
+func (h *Handlers) GetOutboxEntry(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.loadOutboxEntry(w, r)
+	if !ok {
+		return
 	}
 
-	return resp
+	h.writeJSON(w, http.StatusOK, outboxEntryToResponse(entry, true))
 }
 
-// ============================================================
-// Debug/Test Handlers
-// ============================================================
-
-// CreateTestJob handles POST /api/debug/test-job (creates a test job for the first online agent)
-func (h *Handlers) CreateTestJob(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+// ReplayOutboxEntry handles POST /api/outbox/{entryID}/replay
+//
+// The entry goes back to pending with a fresh attempt budget and keeps its
+// event ID, so a target that already received it can discard the duplicate.
+func (h *Handlers) ReplayOutboxEntry(w http.ResponseWriter, r *http.Request) {
+	entry, ok := h.loadOutboxEntry(w, r)
+	if !ok {
+		return
+	}
+	if entry.Status == storage.OutboxStatusDelivered {
+		h.writeError(w, r, http.StatusConflict, "already_delivered", "Outbox entry has already been delivered")
+		return
+	}
 
-	// Get first online agent
-	agents, err := h.registry.ListAgents(ctx)
-	if err != nil {
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list agents")
+	if err := h.db.ReplayOutboxEntry(r.Context(), entry.ID); err != nil {
+		h.logger.Error().Err(err).Str("entry_id", entry.ID).Msg("Failed to replay outbox entry")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to replay outbox entry")
 		return
 	}
+	h.notifyOutbox()
 
-	if len(agents) == 0 {
-		h.writeError(w, r, http.StatusBadRequest, "no_agents", "No agents registered")
+	entry, err := h.db.GetOutboxEntry(r.Context(), entry.ID)
+	if err != nil || entry == nil {
+		h.logger.Error().Err(err).Str("entry_id", chi.URLParam(r, "entryID")).Msg("Failed to get replayed outbox entry")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get outbox entry")
 		return
 	}
 
-	agentID := agents[0].ID
+	h.writeJSON(w, http.StatusOK, outboxEntryToResponse(entry, true))
+}
 
-	// Create test job
-	job := &storage.Job{
-		ID:         uuid.New().String(),
-		AgentID:    agentID,
-		ActionType: "simulate_file_activity",
-		Parameters: map[string]interface{}{
-			"target_directory": "/tmp/cymconductor-test",
-			"operations":       []string{"create"},
-			"file_count":       3,
-			"file_types":       []string{"txt"},
-			"file_size_kb_min": 1,
-			"file_size_kb_max": 10,
-			"preserve_files":   true,
-		},
-		Status:      "pending",
-		Priority:    0,
-		MaxRetries:  3,
-		ScheduledAt: time.Now(),
+// ReplayOutbox handles POST /api/outbox/replay
+//
+// Every dead-lettered entry, optionally only those for one target, goes back
+// to pending with a fresh attempt budget. The body may be empty.
+func (h *Handlers) ReplayOutbox(w http.ResponseWriter, r *http.Request) {
+	var req protocol.ReplayOutboxRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		h.writeError(w, r, http.StatusBadRequest, "invalid_request", "Failed to parse request body")
+		return
+	}
+	switch req.Target {
+	case "", storage.OutboxTargetScoring, storage.OutboxTargetMessenger:
+	default:
+		h.writeError(w, r, http.StatusBadRequest, "validation_failed", "target must be scoring or messenger")
+		return
 	}
 
-	if err := h.db.CreateJob(ctx, job); err != nil {
-		h.logger.Error().Err(err).Msg("Failed to create test job")
-		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to create job")
+	n, err := h.db.ReplayDeadOutboxEntries(r.Context(), req.Target)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to replay outbox entries")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to replay outbox entries")
 		return
 	}
+	if n > 0 {
+		h.notifyOutbox()
+	}
 
-	h.logger.Info().
-		Str("job_id", job.ID).
-		Str("agent_id", agentID).
-		Msg("Created test job")
+	h.writeJSON(w, http.StatusOK, protocol.ReplayOutboxResponse{Replayed: n})
+}
 
-	h.writeJSON(w, http.StatusCreated, map[string]interface{}{
-		"job_id":      job.ID,
-		"agent_id":    agentID,
-		"action_type": job.ActionType,
-		"status":      job.Status,
-		"message":     "Test job created successfully. Agent will pick it up on next heartbeat.",
-	})
+// loadOutboxEntry fetches the entry named in the URL, writing a 404 if it
+// does not exist.
+func (h *Handlers) loadOutboxEntry(w http.ResponseWriter, r *http.Request) (*storage.OutboxEntry, bool) {
+	entryID := chi.URLParam(r, "entryID")
+
+	entry, err := h.db.GetOutboxEntry(r.Context(), entryID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("entry_id", entryID).Msg("Failed to get outbox entry")
+		h.writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to get outbox entry")
+		return nil, false
+	}
+	if entry == nil {
+		h.writeError(w, r, http.StatusNotFound, "outbox_entry_not_found", "Outbox entry not found")
+		return nil, false
+	}
+	return entry, true
+}
+
+// notifyOutbox wakes the outbox worker so replayed entries are delivered
+// without waiting for the next poll.
+func (h *Handlers) notifyOutbox() {
+	if h.outbox != nil {
+		h.outbox.Notify()
+	}
+}
+
+func outboxEntryToResponse(entry *storage.OutboxEntry, withPayload bool) protocol.OutboxEntryResponse {
+	resp := protocol.OutboxEntryResponse{
+		ID:          entry.ID,
+		EventID:     entry.EventID,
+		Target:      entry.Target,
+		Destination: entry.Destination,
+		EventType:   entry.EventType,
+		Status:      entry.Status,
+		Attempts:    entry.Attempts,
+		CreatedAt:   entry.CreatedAt,
+		UpdatedAt:   entry.UpdatedAt,
+		DeliveredAt: entry.DeliveredAt,
+	}
+	if entry.Status == storage.OutboxStatusPending {
+		next := entry.NextAttemptAt
+		resp.NextAttemptAt = &next
+	}
+	if entry.LastError != nil {
+		resp.LastError = *entry.LastError
+	}
+	if withPayload {
+		resp.Payload = entry.Payload
+	}
+	return resp
 }
@@ -1,27 +1,55 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog"
+	"golang.org/x/net/websocket"
 
+	"cymbytes.com/cymconductor/internal/orchestrator/auth"
+	"cymbytes.com/cymconductor/internal/orchestrator/compiler"
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+	"cymbytes.com/cymconductor/internal/orchestrator/outbox"
 	"cymbytes.com/cymconductor/internal/orchestrator/registry"
 	"cymbytes.com/cymconductor/internal/orchestrator/scheduler"
+	"cymbytes.com/cymconductor/internal/orchestrator/scoring"
 	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/internal/orchestrator/validator"
+	"cymbytes.com/cymconductor/internal/orchestrator/webhooks"
+	"cymbytes.com/cymconductor/pkg/dsl"
 	"cymbytes.com/cymconductor/pkg/protocol"
 )
 
-// setupTestHandlers creates handlers with temporary database for testing
-func setupTestHandlers(t *testing.T) (*Handlers, *storage.DB, *registry.Registry, func()) {
+// setupTestHandlers creates handlers backed by the in-memory store, or by a
+// temporary SQLite database when CYMCONDUCTOR_TEST_STORE=sqlite
+func setupTestHandlers(t *testing.T) (*Handlers, storage.Store, *registry.Registry, func()) {
+	t.Helper()
+
+	if os.Getenv("CYMCONDUCTOR_TEST_STORE") == "sqlite" {
+		return setupSQLiteTestHandlers(t)
+	}
+
+	db := storage.NewMemory(zerolog.Nop())
+	handlers, reg := newTestHandlers(db)
+	return handlers, db, reg, func() { db.Close() }
+}
+
+// setupSQLiteTestHandlers creates handlers with temporary database for testing
+func setupSQLiteTestHandlers(t *testing.T) (*Handlers, *storage.DB, *registry.Registry, func()) {
 	t.Helper()
 
 	ctx := context.Background()
@@ -38,14 +66,7 @@ func setupTestHandlers(t *testing.T) (*Handlers, *storage.DB, *registry.Registry
 		t.Fatalf("Failed to create test database: %v", err)
 	}
 
-	// Create registry
-	reg := registry.New(db, registry.DefaultConfig(), zerolog.Nop())
-
-	// Create scheduler
-	sched := scheduler.New(db, scheduler.DefaultConfig(), zerolog.Nop())
-
-	// Create handlers
-	handlers := New(db, reg, sched, "test", time.Now(), zerolog.Nop())
+	handlers, reg := newTestHandlers(db)
 
 	// Cleanup function
 	cleanup := func() {
@@ -59,6 +80,13 @@ func setupTestHandlers(t *testing.T) (*Handlers, *storage.DB, *registry.Registry
 	return handlers, db, reg, cleanup
 }
 
+// newTestHandlers wires a registry, scheduler and handlers to a store
+func newTestHandlers(db storage.Store) (*Handlers, *registry.Registry) {
+	reg := registry.New(db, registry.DefaultConfig(), zerolog.Nop())
+	sched := scheduler.New(db, scheduler.DefaultConfig(), zerolog.Nop())
+	return New(db, reg, sched, "test", time.Now(), zerolog.Nop()), reg
+}
+
 // registerTestAgent helper to register an agent in the registry
 func registerTestAgent(t *testing.T, reg *registry.Registry, agentID, labHostID string) *registry.CachedAgent {
 	t.Helper()
@@ -985,6 +1013,130 @@ func TestRegisterAgent_DuplicateRegistration(t *testing.T) {
 	}
 }
 
+func TestRegisterAgent_CapabilitiesTargeting(t *testing.T) {
+	handlers, _, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	register := func(agentID, hostID string, caps *protocol.AgentCapabilities) {
+		t.Helper()
+		_, err := reg.RegisterAgent(ctx, &protocol.RegisterAgentRequest{
+			AgentID:      agentID,
+			LabHostID:    hostID,
+			Hostname:     hostID,
+			IPAddress:    "192.168.1.10",
+			Labels:       map[string]string{"role": "workstation"},
+			Version:      "1.2.0",
+			Capabilities: caps,
+		})
+		if err != nil {
+			t.Fatalf("Failed to register %s: %v", hostID, err)
+		}
+	}
+	linuxCaps := &protocol.AgentCapabilities{
+		Actions:     []string{"email_receive", "simulate_browsing"},
+		Unavailable: map[string]string{"observe_user_state": "only supported on Windows"},
+		Backends:    map[string][]string{"email_receive": {"imap"}},
+		OS:          "linux",
+		Arch:        "amd64",
+	}
+	register("agent-linux", "lnx1", &protocol.AgentCapabilities{Actions: []string{"simulate_browsing"}})
+	register("agent-linux", "lnx1", linuxCaps) // re-registration replaces capabilities
+
+	// Capabilities are returned with the agent
+	req := httptest.NewRequest(http.MethodGet, "/api/agents/agent-linux", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("agentID", "agent-linux")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	handlers.GetAgent(w, req)
+	var info protocol.AgentInfo
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if info.Capabilities == nil || len(info.Capabilities.Actions) != 2 || info.Capabilities.OS != "linux" ||
+		info.Capabilities.Unavailable["observe_user_state"] == "" {
+		t.Fatalf("Expected the re-registered capabilities, got %+v", info.Capabilities)
+	}
+
+	scenarioJSON := `{
+		"$schema": "cymbytes-scenario-v1",
+		"id": "6f1c2a52-3b7e-4d8e-9a63-1d2e3f4a5b6c",
+		"name": "Capabilities",
+		"version": 1,
+		"schedule": {"type": "immediate"},
+		"steps": [
+			{"id": "0b8e8d2c-5f7a-4c1e-8d9b-2a3b4c5d6e71", "order": 1, "action_type": "simulate_browsing",
+			 "target": {"labels": {"role": "workstation"}, "count": "all"},
+			 "parameters": {"urls": ["http://intranet.lab.local"], "duration_seconds": 60}},
+			{"id": "0b8e8d2c-5f7a-4c1e-8d9b-2a3b4c5d6e72", "order": 2, "action_type": "observe_user_state",
+			 "target": {"labels": {"role": "workstation"}, "count": "all"},
+			 "parameters": {"username": "jsmith"}},
+			{"id": "0b8e8d2c-5f7a-4c1e-8d9b-2a3b4c5d6e73", "order": 3, "action_type": "email_receive",
+			 "target": {"labels": {"role": "workstation"}, "count": "all"},
+			 "parameters": {"backend": "outlook"}}
+		]
+	}`
+
+	// The validator rejects steps no matching agent can run
+	agents, err := reg.GetOnlineAgents(ctx, storage.DefaultLabID)
+	if err != nil {
+		t.Fatalf("Failed to list agents: %v", err)
+	}
+	var scenario dsl.Scenario
+	if err := json.Unmarshal([]byte(scenarioJSON), &scenario); err != nil {
+		t.Fatalf("Failed to parse scenario: %v", err)
+	}
+	result := validator.New().WithAgents(agents).ValidateScenario(&scenario)
+	var capabilityErrors []string
+	for _, e := range result.Errors {
+		if e.Rule == "capable_agent" {
+			capabilityErrors = append(capabilityErrors, e.Field+": "+e.Message)
+		} else {
+			t.Errorf("Unexpected validation error: %+v", e)
+		}
+	}
+	if len(capabilityErrors) != 2 || !strings.Contains(capabilityErrors[0], "steps[1]") ||
+		!strings.Contains(capabilityErrors[0], "only supported on Windows") ||
+		!strings.Contains(capabilityErrors[1], "backend outlook") {
+		t.Errorf("Expected capability errors for steps 2 and 3, got %v", capabilityErrors)
+	}
+
+	// With only incapable agents the compiler reports the steps it skipped
+	comp := compiler.New(reg, zerolog.Nop())
+	compiled, err := comp.Compile(ctx, &scenario, storage.DefaultLabID, time.Now())
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if len(compiled.Jobs) != 1 || len(compiled.Errors) != 2 ||
+		!strings.Contains(compiled.Errors[0], "none can run observe_user_state: lnx1: observe_user_state unavailable") {
+		t.Errorf("Expected one job and errors for steps 2 and 3, got %d jobs and %v", len(compiled.Jobs), compiled.Errors)
+	}
+
+	// Incapable agents are skipped; agents that did not report capabilities
+	// are still targeted
+	register("agent-windows", "win1", nil)
+	compiled, err = comp.Compile(ctx, &scenario, storage.DefaultLabID, time.Now())
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if len(compiled.Errors) != 0 {
+		t.Errorf("Expected no compile errors, got %v", compiled.Errors)
+	}
+	jobsByAction := map[string][]string{}
+	for _, job := range compiled.Jobs {
+		jobsByAction[job.ActionType] = append(jobsByAction[job.ActionType], job.AgentID)
+	}
+	if len(jobsByAction["simulate_browsing"]) != 2 {
+		t.Errorf("Expected browsing on both agents, got %v", jobsByAction["simulate_browsing"])
+	}
+	for _, action := range []string{"observe_user_state", "email_receive"} {
+		if got := jobsByAction[action]; len(got) != 1 || got[0] != "agent-windows" {
+			t.Errorf("Expected %s only on agent-windows, got %v", action, got)
+		}
+	}
+}
+
 // ============================================================
 // Agent Heartbeat Tests
 // ============================================================
@@ -1373,7 +1525,7 @@ func TestReadyCheck_ContentType(t *testing.T) {
 // ============================================================
 
 // Helper function to create a test scenario
-func createTestScenario(t *testing.T, db *storage.DB, scenarioID, name, status string) {
+func createTestScenario(t *testing.T, db storage.Store, scenarioID, name, status string) {
 	t.Helper()
 
 	scenario := &storage.Scenario{
@@ -1389,6 +1541,87 @@ func createTestScenario(t *testing.T, db *storage.DB, scenarioID, name, status s
 	}
 }
 
+func TestGetOpenAPI(t *testing.T) {
+	handlers, _, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil)
+	w := httptest.NewRecorder()
+
+	handlers.GetOpenAPI(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{} `json:"properties"`
+				Required   []string                          `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if doc.OpenAPI != "3.0.3" || doc.Info.Version != "test" {
+		t.Errorf("Expected OpenAPI 3.0.3 for version test, got %s for %s", doc.OpenAPI, doc.Info.Version)
+	}
+
+	// Every endpoint is described, operator endpoints with their role
+	for _, ep := range protocol.Endpoints {
+		op, ok := doc.Paths[ep.Path][strings.ToLower(ep.Method)]
+		if !ok {
+			t.Errorf("Expected %s %s in paths", ep.Method, ep.Path)
+			continue
+		}
+		if ep.Role != "" && op["x-required-role"] != ep.Role {
+			t.Errorf("Expected %s %s to require %s, got %v", ep.Method, ep.Path, ep.Role, op["x-required-role"])
+		}
+	}
+	if _, ok := doc.Paths["/api/agents/{agentID}/jobs/{jobID}/result"]["post"]; !ok {
+		t.Error("Expected the job result endpoint")
+	}
+
+	// Schemas follow the JSON encoding of the protocol types
+	assignment, ok := doc.Components.Schemas["JobAssignment"]
+	if !ok {
+		t.Fatal("Expected JobAssignment schema")
+	}
+	if ref := assignment.Properties["run_as"]["$ref"]; ref != "#/components/schemas/RunAsConfig" {
+		t.Errorf("Expected run_as to reference RunAsConfig, got %v", ref)
+	}
+	if format := assignment.Properties["scheduled_at"]["format"]; format != "date-time" {
+		t.Errorf("Expected scheduled_at to be a date-time, got %v", format)
+	}
+
+	retryAt := doc.Components.Schemas["JobResultResponse"].Properties["retry_at"]
+	if retryAt["type"] != "string" || retryAt["format"] != "date-time" {
+		t.Errorf("Expected retry_at to be a date-time string, got %v", retryAt)
+	}
+
+	// Embedded structs are flattened
+	created := doc.Components.Schemas["CreateAPIKeyResponse"]
+	if _, ok := created.Properties["key_prefix"]; !ok {
+		t.Error("Expected CreateAPIKeyResponse to include the embedded APIKeyResponse fields")
+	}
+
+	required := map[string]bool{}
+	for _, name := range doc.Components.Schemas["RegisterAgentRequest"].Required {
+		required[name] = true
+	}
+	if !required["agent_id"] || required["lab_id"] {
+		t.Errorf("Expected agent_id but not lab_id to be required, got %v", doc.Components.Schemas["RegisterAgentRequest"].Required)
+	}
+}
+
 func TestCreateScenario_NotImplemented(t *testing.T) {
 	handlers, _, _, cleanup := setupTestHandlers(t)
 	defer cleanup()
@@ -1422,6 +1655,208 @@ func TestCreateScenario_NotImplemented(t *testing.T) {
 	}
 }
 
+// assignTestJobs dispatches an agent's jobs scheduled for now. The SQLite
+// store compares scheduled times to the second, so it retries briefly.
+func assignTestJobs(t *testing.T, handlers *Handlers, agentID string) []protocol.JobAssignment {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		assignments, _, err := handlers.scheduler.GetNextJobsForAgent(context.Background(), agentID, 10)
+		if err != nil {
+			t.Fatalf("Failed to assign jobs: %v", err)
+		}
+		if len(assignments) > 0 || time.Now().After(deadline) {
+			return assignments
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// testScenarioDefinition returns a one-step file activity scenario targeting
+// agents labeled role.
+func testScenarioDefinition(id, role, dir string) string {
+	return fmt.Sprintf(`{
+		"$schema": "cymbytes-scenario-v1",
+		"id": %q,
+		"name": "Files",
+		"version": 1,
+		"schedule": {"type": "immediate"},
+		"steps": [{
+			"id": "0b8e8d2c-5f7a-4c1e-8d9b-2a3b4c5d6e7f",
+			"order": 1,
+			"action_type": "simulate_file_activity",
+			"target": {"labels": {"role": %q}, "count": "all"},
+			"parameters": {"target_directory": %q, "operations": ["create"], "file_count": 3}
+		}]
+	}`, id, role, dir)
+}
+
+func TestCreateScenario_FromDefinition(t *testing.T) {
+	handlers, db, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	registerTestAgent(t, reg, "agent-create-1", "ws1")
+
+	submit := func(def string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(protocol.CreateScenarioRequest{
+			Name:     "Files",
+			Scenario: &protocol.ScenarioInput{Definition: def},
+		})
+		w := httptest.NewRecorder()
+		handlers.CreateScenario(w, httptest.NewRequest(http.MethodPost, "/api/scenarios", bytes.NewReader(body)))
+		return w
+	}
+
+	const id = "6f1c2a52-3b7e-4d8e-9a63-1d2e3f4a5b6c"
+	w := submit(testScenarioDefinition(id, "test", "/home/user/docs"))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var resp protocol.CreateScenarioResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.ScenarioID != id || resp.Status != storage.ScenarioStatusActive || resp.StepCount != 1 || resp.JobCount != 1 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+
+	scenario, _ := db.GetScenario(ctx, id)
+	if scenario == nil || scenario.Status != storage.ScenarioStatusActive || scenario.ValidatedDSL == nil {
+		t.Fatalf("Expected an active scenario with its DSL, got %+v", scenario)
+	}
+	jobs, _ := db.ListJobsByScenario(ctx, id)
+	if len(jobs) != 1 || jobs[0].AgentID != "agent-create-1" {
+		t.Errorf("Expected one job for agent-create-1, got %+v", jobs)
+	}
+
+	if w := submit(testScenarioDefinition(id, "test", "/home/user/docs")); w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for a duplicate ID, got %d", http.StatusConflict, w.Code)
+	}
+	if w := submit(testScenarioDefinition("7f1c2a52-3b7e-4d8e-9a63-1d2e3f4a5b6c", "test", "/etc/app")); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d for an invalid scenario, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+	const unmatched = "8f1c2a52-3b7e-4d8e-9a63-1d2e3f4a5b6c"
+	w = submit(testScenarioDefinition(unmatched, "server", "/home/user/docs"))
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "compile_failed") {
+		t.Errorf("Expected compile_failed for unmatched labels, got %d: %s", w.Code, w.Body.String())
+	}
+	if scenario, _ := db.GetScenario(ctx, unmatched); scenario != nil {
+		t.Error("Expected a scenario that failed to compile not to be stored")
+	}
+}
+
+func TestCreateScenario_WithScoringRun(t *testing.T) {
+	handlers, db, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	handlers.scheduler.SetScoringForwarder(scoring.NewEventForwarder(
+		scoring.Config{Enabled: true, EngineURL: "http://scoring.invalid", Timeout: time.Second}, zerolog.Nop()))
+	registerTestAgent(t, reg, "agent-scored", "ws1")
+
+	const id = "9a1c2a52-3b7e-4d8e-9a63-1d2e3f4a5b6c"
+	body, _ := json.Marshal(protocol.CreateScenarioRequest{
+		Name:         "Scored",
+		Scenario:     &protocol.ScenarioInput{Definition: testScenarioDefinition(id, "test", "/home/user/docs")},
+		ScoringRunID: "run-42",
+	})
+	w := httptest.NewRecorder()
+	handlers.CreateScenario(w, httptest.NewRequest(http.MethodPost, "/api/scenarios", bytes.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	scenario, _ := db.GetScenario(ctx, id)
+	if scenario == nil || scenario.ScoringRunID == nil || *scenario.ScoringRunID != "run-42" {
+		t.Fatalf("Expected the scenario to carry run-42, got %+v", scenario)
+	}
+
+	started := func() []*storage.OutboxEntry {
+		entries, err := db.ListOutboxEntries(ctx, storage.OutboxFilter{Target: storage.OutboxTargetScoring})
+		if err != nil {
+			t.Fatalf("Failed to list outbox entries: %v", err)
+		}
+		return entries
+	}
+	entries := started()
+	if len(entries) != 1 || entries[0].Destination != "run-42" || entries[0].EventType != scoring.EventScenarioStarted {
+		t.Fatalf("Expected one scenario-started entry for run-42, got %+v", entries)
+	}
+
+	// Dispatching the jobs does not announce the start again
+	if assignments := assignTestJobs(t, handlers, "agent-scored"); len(assignments) != 1 {
+		t.Fatalf("Expected one assignment, got %d", len(assignments))
+	}
+	if entries := started(); len(entries) != 1 {
+		t.Errorf("Expected one scenario-started entry after dispatch, got %d", len(entries))
+	}
+}
+
+func TestCreateScenario_PublishesLifecycleEvents(t *testing.T) {
+	handlers, _, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	bus := enableTestEvents(handlers, reg, events.DefaultConfig())
+	ctx := context.Background()
+
+	registerTestAgent(t, reg, "agent-lifecycle", "ws1")
+	sub, _, _ := bus.Subscribe(events.Filter{Types: []string{"scenario"}}, 0)
+	defer sub.Close()
+
+	const id = "5e1c2a52-3b7e-4d8e-9a63-1d2e3f4a5b6c"
+	body, _ := json.Marshal(protocol.CreateScenarioRequest{
+		Name:     "Lifecycle",
+		Scenario: &protocol.ScenarioInput{Definition: testScenarioDefinition(id, "test", "/home/user/docs")},
+	})
+	w := httptest.NewRecorder()
+	handlers.CreateScenario(w, httptest.NewRequest(http.MethodPost, "/api/scenarios", bytes.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	expect := func(eventType, from, to string) {
+		t.Helper()
+		select {
+		case e := <-sub.Events():
+			if e.Type != eventType || e.ScenarioID != id || e.Data["name"] != "Lifecycle" {
+				t.Fatalf("Expected %s for %s, got %s %+v", eventType, id, e.Type, e)
+			}
+			if from != "" && (e.Data["from"] != from || e.Data["to"] != to) {
+				t.Errorf("Expected %s -> %s, got %v -> %v", from, to, e.Data["from"], e.Data["to"])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", eventType)
+		}
+	}
+	// The scenario is stored as active with its jobs in one step
+	expect(events.ScenarioCreated, "", "")
+	expect(events.ScenarioStarted, "", "")
+
+	// Dispatching the jobs of a started scenario does not start it again
+	assignments := assignTestJobs(t, handlers, "agent-lifecycle")
+	if len(assignments) != 1 {
+		t.Fatalf("Expected one assignment, got %d", len(assignments))
+	}
+	now := time.Now()
+	if _, _, err := handlers.scheduler.ProcessJobResult(ctx, "agent-lifecycle", assignments[0].JobID, &protocol.JobResultRequest{
+		Status:      "completed",
+		StartedAt:   now.Add(-time.Second),
+		CompletedAt: now,
+	}); err != nil {
+		t.Fatalf("Failed to process job result: %v", err)
+	}
+
+	cfg := scheduler.DefaultConfig()
+	cfg.PollInterval = 10 * time.Millisecond
+	handlers.scheduler.UpdateConfig(cfg)
+	handlers.scheduler.Start(ctx)
+	defer handlers.scheduler.Stop()
+
+	expect(events.ScenarioStatusChanged, storage.ScenarioStatusActive, storage.ScenarioStatusCompleted)
+	expect(events.ScenarioCompleted, "", "")
+}
+
 func TestCreateScenario_MissingName(t *testing.T) {
 	handlers, _, _, cleanup := setupTestHandlers(t)
 	defer cleanup()
@@ -1937,7 +2372,7 @@ func TestDeleteScenario_CancelsJobs(t *testing.T) {
 // ============================================================
 
 // Helper function to create a test impersonation user
-func createTestUser(t *testing.T, db *storage.DB, username, domain, samAccountName string) *storage.ImpersonationUser {
+func createTestUser(t *testing.T, db storage.Store, username, domain, samAccountName string) *storage.ImpersonationUser {
 	t.Helper()
 
 	user := &storage.ImpersonationUser{
@@ -2797,3 +3232,2182 @@ func TestGetJobStats_SingleStatus(t *testing.T) {
 		t.Errorf("Expected 5 pending jobs, got %d", stats["pending"])
 	}
 }
+
+// ============================================================
+// Agent Deletion Tests
+// ============================================================
+
+func TestDeleteAgent_Success(t *testing.T) {
+	handlers, _, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	agentID := "agent-to-delete"
+	registerTestAgent(t, reg, agentID, "lab-host-delete")
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/agents/"+agentID, nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("agentID", agentID)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+
+	handlers.DeleteAgent(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+
+	agent, err := reg.GetAgent(context.Background(), agentID)
+	if err != nil {
+		t.Fatalf("Failed to get agent: %v", err)
+	}
+	if agent != nil {
+		t.Error("Expected agent to be deleted")
+	}
+}
+
+func TestDeleteAgent_NotFound(t *testing.T) {
+	handlers, _, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/agents/missing", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("agentID", "missing")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+
+	handlers.DeleteAgent(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// ============================================================
+// API Key Tests
+// ============================================================
+
+func TestCreateAPIKey_Success(t *testing.T) {
+	handlers, db, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	body, _ := json.Marshal(protocol.CreateAPIKeyRequest{Name: "instructor", Role: "operator"})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/keys", bytes.NewReader(body))
+	req = req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{KeyID: "k1", Name: "root", Role: auth.RoleAdmin}))
+	w := httptest.NewRecorder()
+
+	handlers.CreateAPIKey(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var resp protocol.CreateAPIKeyResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if resp.Key == "" || resp.KeyPrefix == "" {
+		t.Fatal("Expected plaintext key and prefix in response")
+	}
+	if resp.Role != "operator" {
+		t.Errorf("Expected role 'operator', got %q", resp.Role)
+	}
+	if resp.CreatedBy != "key:root" {
+		t.Errorf("Expected created_by 'key:root', got %q", resp.CreatedBy)
+	}
+
+	// Only the hash is stored
+	stored, err := db.GetAPIKeyByHash(context.Background(), auth.HashKey(resp.Key))
+	if err != nil {
+		t.Fatalf("Failed to look up key: %v", err)
+	}
+	if stored == nil || stored.ID != resp.ID {
+		t.Fatal("Expected key to be stored by hash")
+	}
+	if stored.KeyHash == resp.Key {
+		t.Error("Expected key to be stored hashed")
+	}
+}
+
+func TestCreateAPIKey_InvalidRole(t *testing.T) {
+	handlers, _, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	body, _ := json.Marshal(protocol.CreateAPIKeyRequest{Name: "bad", Role: "superuser"})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/keys", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handlers.CreateAPIKey(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	handlers, db, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	key := &storage.APIKey{Name: "temp", KeyPrefix: "cyk_temp", KeyHash: auth.HashKey("cyk_temp"), Role: "viewer"}
+	if err := db.CreateAPIKey(context.Background(), key); err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+
+	revoke := func() int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/auth/keys/"+key.ID, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("keyID", key.ID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		handlers.RevokeAPIKey(w, req)
+		return w.Code
+	}
+
+	if code := revoke(); code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, code)
+	}
+	if code := revoke(); code != http.StatusNotFound {
+		t.Errorf("Expected status %d for already revoked key, got %d", http.StatusNotFound, code)
+	}
+
+	// Listing still shows the revoked key
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/keys", nil)
+	w := httptest.NewRecorder()
+	handlers.ListAPIKeys(w, req)
+
+	var list protocol.ListAPIKeysResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if list.Total != 1 || list.Keys[0].RevokedAt == nil {
+		t.Errorf("Expected one revoked key, got %+v", list.Keys)
+	}
+}
+
+func TestAuthRequire_Roles(t *testing.T) {
+	handlers, db, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	for _, k := range []struct{ name, role string }{{"viewer-key", "viewer"}, {"admin-key", "admin"}} {
+		if err := db.CreateAPIKey(ctx, &storage.APIKey{Name: k.name, KeyPrefix: k.name, KeyHash: auth.HashKey(k.name), Role: k.role}); err != nil {
+			t.Fatalf("Failed to create key: %v", err)
+		}
+	}
+
+	authn := auth.New(db, auth.Config{Enabled: true}, zerolog.Nop())
+	router := chi.NewRouter()
+	router.With(authn.Require(auth.RoleViewer)).Get("/api/users", handlers.ListImpersonationUsers)
+	router.With(authn.Require(auth.RoleAdmin)).Post("/api/users", handlers.CreateImpersonationUser)
+
+	tests := []struct {
+		name   string
+		method string
+		key    string
+		body   string
+		status int
+	}{
+		{"no key", http.MethodGet, "", "", http.StatusUnauthorized},
+		{"unknown key", http.MethodGet, "nope", "", http.StatusUnauthorized},
+		{"viewer reads", http.MethodGet, "viewer-key", "", http.StatusOK},
+		{"viewer writes", http.MethodPost, "viewer-key", `{}`, http.StatusForbidden},
+		{"admin writes", http.MethodPost, "admin-key", `{"username":"LAB\\u","domain":"LAB","sam_account_name":"u"}`, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/users", bytes.NewBufferString(tt.body))
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// The admin write is audited with the key name as actor
+	entries, err := db.ListAuditEntries(ctx, storage.AuditFilter{EntityType: storage.AuditEntityRequest})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected 1 request audit entry, got %d (err: %v)", len(entries), err)
+	}
+	actor, action := entries[0].Actor, entries[0].Action
+	if actor != "key:admin-key" {
+		t.Errorf("Expected actor 'key:admin-key', got %q", actor)
+	}
+	if action != "POST /api/users" {
+		t.Errorf("Expected action 'POST /api/users', got %q", action)
+	}
+}
+
+func TestAuthRequire_DisabledIsAnonymousAdmin(t *testing.T) {
+	handlers, db, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	authn := auth.New(db, auth.Config{Enabled: false}, zerolog.Nop())
+	h := authn.Require(auth.RoleAdmin)(http.HandlerFunc(handlers.WhoAmI))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/whoami", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	var resp protocol.WhoAmIResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Actor != auth.AnonymousActor || resp.Role != "admin" {
+		t.Errorf("Expected anonymous admin, got %+v", resp)
+	}
+}
+
+// ============================================================
+// Lab Tests
+// ============================================================
+
+func createTestLab(t *testing.T, db storage.Store, labID string, allowedNetworks []string) {
+	t.Helper()
+
+	err := db.CreateLab(context.Background(), &storage.Lab{
+		ID:              labID,
+		Name:            "Lab " + labID,
+		AllowedNetworks: allowedNetworks,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test lab: %v", err)
+	}
+}
+
+func TestCreateLab_Success(t *testing.T) {
+	handlers, _, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	body, _ := json.Marshal(protocol.CreateLabRequest{
+		ID:              "class-a",
+		Name:            "Class A",
+		AllowedNetworks: []string{"10.10.0.0/16"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/labs", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handlers.CreateLab(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var resp protocol.LabResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.ID != "class-a" || len(resp.AllowedNetworks) != 1 {
+		t.Errorf("Unexpected lab response: %+v", resp)
+	}
+
+	// Duplicate ID is rejected
+	req = httptest.NewRequest(http.MethodPost, "/api/labs", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	handlers.CreateLab(w, req)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for duplicate lab, got %d", http.StatusConflict, w.Code)
+	}
+}
+
+func TestCreateLab_InvalidCIDR(t *testing.T) {
+	handlers, _, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	body, _ := json.Marshal(protocol.CreateLabRequest{
+		ID:              "class-b",
+		Name:            "Class B",
+		AllowedNetworks: []string{"not-a-cidr"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/labs", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handlers.CreateLab(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestDeleteLab_NotEmpty(t *testing.T) {
+	handlers, db, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	createTestLab(t, db, "class-c", nil)
+
+	user := &storage.ImpersonationUser{
+		LabID:          "class-c",
+		Username:       "LAB\\jdoe",
+		Domain:         "LAB",
+		SAMAccountName: "jdoe",
+		AllowedHosts:   []string{},
+	}
+	if err := db.CreateImpersonationUser(context.Background(), user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/labs/class-c", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("labID", "class-c")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+
+	handlers.DeleteLab(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+}
+
+func TestRegisterAgent_LabScoping(t *testing.T) {
+	handlers, db, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	createTestLab(t, db, "class-d", []string{"10.20.0.0/16"})
+
+	register := func(agentID, labID, ip string) int {
+		body, _ := json.Marshal(protocol.RegisterAgentRequest{
+			AgentID:   agentID,
+			LabHostID: "ws1",
+			Hostname:  "ws1",
+			IPAddress: ip,
+			Labels:    map[string]string{"role": "workstation"},
+			Version:   "test",
+			LabID:     labID,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/agents/register", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handlers.RegisterAgent(w, req)
+		return w.Code
+	}
+
+	if code := register("agent-unknown-lab", "missing", "10.20.0.5"); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for unknown lab, got %d", http.StatusBadRequest, code)
+	}
+	if code := register("agent-outside", "class-d", "192.168.1.5"); code != http.StatusForbidden {
+		t.Errorf("Expected status %d for disallowed IP, got %d", http.StatusForbidden, code)
+	}
+	if code := register("agent-inside", "class-d", "10.20.0.5"); code != http.StatusCreated {
+		t.Errorf("Expected status %d for allowed IP, got %d", http.StatusCreated, code)
+	}
+	if code := register("agent-default", "", "192.168.1.5"); code != http.StatusCreated {
+		t.Errorf("Expected status %d for default lab, got %d", http.StatusCreated, code)
+	}
+
+	// Listing with a lab scope only returns that lab's agents
+	req := httptest.NewRequest(http.MethodGet, "/api/agents?lab_id=class-d", nil)
+	w := httptest.NewRecorder()
+	handlers.ListAgents(w, req)
+
+	var resp protocol.ListAgentsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Agents) != 1 || resp.Agents[0].AgentID != "agent-inside" || resp.Agents[0].LabID != "class-d" {
+		t.Errorf("Expected only agent-inside in class-d, got %+v", resp.Agents)
+	}
+
+	// Out-of-scope agents are not visible
+	req = httptest.NewRequest(http.MethodGet, "/api/agents/agent-default", nil)
+	req.Header.Set("X-Lab-ID", "class-d")
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("agentID", "agent-default")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w = httptest.NewRecorder()
+	handlers.GetAgent(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for out-of-scope agent, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestImpersonationUsers_SameUsernameInTwoLabs(t *testing.T) {
+	handlers, db, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	createTestLab(t, db, "class-e", nil)
+
+	create := func(labID string) int {
+		body, _ := json.Marshal(protocol.CreateImpersonationUserRequest{
+			LabID:          labID,
+			Username:       "CYMBYTES\\jsmith",
+			Domain:         "CYMBYTES",
+			SAMAccountName: "jsmith",
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/users", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		handlers.CreateImpersonationUser(w, req)
+		return w.Code
+	}
+
+	if code := create(""); code != http.StatusCreated {
+		t.Fatalf("Expected status %d in default lab, got %d", http.StatusCreated, code)
+	}
+	if code := create("class-e"); code != http.StatusCreated {
+		t.Fatalf("Expected status %d in class-e, got %d", http.StatusCreated, code)
+	}
+	if code := create("missing"); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for unknown lab, got %d", http.StatusBadRequest, code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users?lab_id=class-e", nil)
+	w := httptest.NewRecorder()
+	handlers.ListImpersonationUsers(w, req)
+
+	var resp protocol.ListImpersonationUsersResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Users) != 1 || resp.Users[0].LabID != "class-e" {
+		t.Errorf("Expected one user in class-e, got %+v", resp.Users)
+	}
+}
+
+// ============================================================
+// Long-Poll and Agent Channel Tests
+// ============================================================
+
+func newPendingJob(jobID, agentID string) *storage.Job {
+	return &storage.Job{
+		ID:          jobID,
+		AgentID:     agentID,
+		ActionType:  "test_action",
+		Parameters:  map[string]interface{}{"key": "value"},
+		Status:      "pending",
+		Priority:    5,
+		MaxRetries:  3,
+		ScheduledAt: time.Now().UTC().Add(-1 * time.Minute),
+	}
+}
+
+func TestGetNextJobs_LongPollWakesOnNewJob(t *testing.T) {
+	handlers, _, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	agentID := "test-agent-longpoll"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agents/"+agentID+"/jobs/next?wait=10", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("agentID", agentID)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		if err := handlers.scheduler.CreateJob(context.Background(), newPendingJob("job-longpoll", agentID)); err != nil {
+			t.Errorf("Failed to create test job: %v", err)
+		}
+	}()
+
+	start := time.Now()
+	handlers.GetNextJobs(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Long-poll returned after %v, expected to wake on job creation", elapsed)
+	}
+
+	var response protocol.GetJobsResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Jobs) != 1 || response.Jobs[0].JobID != "job-longpoll" {
+		t.Errorf("Expected job-longpoll, got %+v", response.Jobs)
+	}
+}
+
+func TestGetNextJobs_LongPollTimeout(t *testing.T) {
+	handlers, _, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	agentID := "test-agent-longpoll-timeout"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agents/"+agentID+"/jobs/next?wait=1", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("agentID", agentID)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	handlers.GetNextJobs(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Long-poll returned after %v, expected to wait 1s", elapsed)
+	}
+}
+
+func TestRegisterAgent_AdvertisesTransports(t *testing.T) {
+	handlers, _, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	body, _ := json.Marshal(protocol.RegisterAgentRequest{
+		AgentID:   "agent-transports",
+		LabHostID: "ws1",
+		Hostname:  "ws1",
+		IPAddress: "192.168.1.5",
+		Labels:    map[string]string{"role": "workstation"},
+		Version:   "test",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/agents/register", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handlers.RegisterAgent(w, req)
+
+	var resp protocol.RegisterAgentResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Transports) == 0 || resp.Transports[0] != protocol.TransportWebSocket {
+		t.Errorf("Expected websocket to be the preferred transport, got %v", resp.Transports)
+	}
+	if resp.LongPollMaxMs <= 0 {
+		t.Errorf("Expected long_poll_max_ms to be set, got %d", resp.LongPollMaxMs)
+	}
+}
+
+// openTestChannel serves the agent channel route and dials it.
+func openTestChannel(t *testing.T, handlers *Handlers, agentID string) (*websocket.Conn, func()) {
+	t.Helper()
+
+	router := chi.NewRouter()
+	router.Get("/api/agents/{agentID}/ws", handlers.AgentChannel)
+	server := httptest.NewServer(router)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/agents/" + agentID + "/ws"
+	conn, err := websocket.Dial(wsURL, "", server.URL)
+	if err != nil {
+		server.Close()
+		t.Fatalf("Failed to dial agent channel: %v", err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		server.Close()
+	}
+}
+
+func sendChannelMessage(t *testing.T, conn *websocket.Conn, msgType string, payload interface{}) {
+	t.Helper()
+
+	msg, err := protocol.NewChannelMessage(msgType, payload)
+	if err != nil {
+		t.Fatalf("Failed to build message: %v", err)
+	}
+	if err := websocket.JSON.Send(conn, msg); err != nil {
+		t.Fatalf("Failed to send %s: %v", msgType, err)
+	}
+}
+
+func receiveChannelMessage(t *testing.T, conn *websocket.Conn) *protocol.ChannelMessage {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg protocol.ChannelMessage
+	if err := websocket.JSON.Receive(conn, &msg); err != nil {
+		t.Fatalf("Failed to receive message: %v", err)
+	}
+	return &msg
+}
+
+func TestAgentChannel_HeartbeatPollAndResult(t *testing.T) {
+	handlers, db, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	agentID := "test-agent-channel"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+
+	conn, closeChannel := openTestChannel(t, handlers, agentID)
+	defer closeChannel()
+
+	// Heartbeat is acknowledged
+	sendChannelMessage(t, conn, protocol.MessageHeartbeat, protocol.HeartbeatRequest{Status: "online"})
+	if msg := receiveChannelMessage(t, conn); msg.Type != protocol.MessageHeartbeatAck {
+		t.Fatalf("Expected %s, got %s", protocol.MessageHeartbeatAck, msg.Type)
+	}
+
+	// A poll is answered once a job is created
+	sendChannelMessage(t, conn, protocol.MessagePoll, protocol.PollMessage{Max: 1})
+	time.Sleep(100 * time.Millisecond)
+	if err := handlers.scheduler.CreateJob(context.Background(), newPendingJob("job-channel", agentID)); err != nil {
+		t.Fatalf("Failed to create test job: %v", err)
+	}
+
+	msg := receiveChannelMessage(t, conn)
+	if msg.Type != protocol.MessageJobs {
+		t.Fatalf("Expected %s, got %s", protocol.MessageJobs, msg.Type)
+	}
+	var jobs protocol.GetJobsResponse
+	if err := json.Unmarshal(msg.Payload, &jobs); err != nil {
+		t.Fatalf("Failed to decode jobs: %v", err)
+	}
+	if len(jobs.Jobs) != 1 || jobs.Jobs[0].JobID != "job-channel" {
+		t.Fatalf("Expected job-channel, got %+v", jobs.Jobs)
+	}
+
+	// The result is recorded and acknowledged
+	now := time.Now().UTC()
+	sendChannelMessage(t, conn, protocol.MessageJobResult, protocol.JobResultMessage{
+		JobID: "job-channel",
+		Result: protocol.JobResultRequest{
+			Status:      "completed",
+			StartedAt:   now.Add(-time.Second),
+			CompletedAt: now,
+		},
+	})
+	msg = receiveChannelMessage(t, conn)
+	if msg.Type != protocol.MessageJobResultAck {
+		t.Fatalf("Expected %s, got %s", protocol.MessageJobResultAck, msg.Type)
+	}
+
+	job, err := db.GetJob(context.Background(), "job-channel")
+	if err != nil || job == nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	if job.Status != storage.JobStatusCompleted {
+		t.Errorf("Expected job status %s, got %s", storage.JobStatusCompleted, job.Status)
+	}
+}
+
+func TestAgentChannel_DeletedAgentIsAskedToReregister(t *testing.T) {
+	handlers, _, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	agentID := "test-agent-channel-deleted"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+
+	conn, closeChannel := openTestChannel(t, handlers, agentID)
+	defer closeChannel()
+
+	if err := reg.DeleteAgent(context.Background(), agentID); err != nil {
+		t.Fatalf("Failed to delete agent: %v", err)
+	}
+
+	sendChannelMessage(t, conn, protocol.MessageHeartbeat, protocol.HeartbeatRequest{Status: "online"})
+	msg := receiveChannelMessage(t, conn)
+	if msg.Type != protocol.MessageCommand {
+		t.Fatalf("Expected %s, got %s", protocol.MessageCommand, msg.Type)
+	}
+
+	var cmd protocol.AgentCommand
+	if err := json.Unmarshal(msg.Payload, &cmd); err != nil {
+		t.Fatalf("Failed to decode command: %v", err)
+	}
+	if cmd.Type != protocol.CommandReregister {
+		t.Errorf("Expected command %s, got %s", protocol.CommandReregister, cmd.Type)
+	}
+}
+
+func TestAgentChannel_UnknownAgent(t *testing.T) {
+	handlers, _, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agents/missing/ws", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("agentID", "missing")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+
+	handlers.AgentChannel(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// ============================================================
+// Event Stream Tests
+// ============================================================
+
+// enableTestEvents wires a new event bus into the handlers, registry and scheduler.
+func enableTestEvents(handlers *Handlers, reg *registry.Registry, cfg events.Config) *events.Bus {
+	bus := events.New(cfg, zerolog.Nop())
+	handlers.SetEventBus(bus)
+	reg.SetEventBus(bus)
+	handlers.scheduler.SetEventBus(bus)
+	return bus
+}
+
+// openTestEventStream connects to GET /api/events with the given query string.
+func openTestEventStream(t *testing.T, handlers *Handlers, query, lastEventID string) (*bufio.Reader, func()) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(handlers.StreamEvents))
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/events?"+query, nil)
+	if err != nil {
+		server.Close()
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		server.Close()
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		server.Close()
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected Content-Type text/event-stream, got %q", ct)
+	}
+
+	return bufio.NewReader(resp.Body), func() {
+		resp.Body.Close()
+		server.Close()
+	}
+}
+
+// readTestEvent reads the next SSE frame carrying an event, skipping the
+// retry hint and keepalive comments.
+func readTestEvent(t *testing.T, r *bufio.Reader) (id, eventType string, event events.Event) {
+	t.Helper()
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("Failed to decode event data: %v", err)
+			}
+		case line == "" && eventType != "":
+			return id, eventType, event
+		}
+	}
+}
+
+func TestStreamEvents_FiltersByTypeAndAgent(t *testing.T) {
+	handlers, _, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	enableTestEvents(handlers, reg, events.DefaultConfig())
+
+	registerTestAgent(t, reg, "test-agent-events-a", "test-lab-host-a")
+	registerTestAgent(t, reg, "test-agent-events-b", "test-lab-host-b")
+
+	stream, closeStream := openTestEventStream(t, handlers, "type=job.*&agent_id=test-agent-events-a", "")
+	defer closeStream()
+
+	ctx := context.Background()
+	for _, job := range []*storage.Job{
+		newPendingJob("job-events-b", "test-agent-events-b"),
+		newPendingJob("job-events-a", "test-agent-events-a"),
+	} {
+		if err := handlers.scheduler.CreateJob(ctx, job); err != nil {
+			t.Fatalf("Failed to create job: %v", err)
+		}
+	}
+
+	id, eventType, event := readTestEvent(t, stream)
+	if eventType != events.JobCreated {
+		t.Fatalf("Expected %s, got %s", events.JobCreated, eventType)
+	}
+	if event.JobID != "job-events-a" || event.AgentID != "test-agent-events-a" {
+		t.Errorf("Expected job-events-a for test-agent-events-a, got %+v", event)
+	}
+	if event.LabID != storage.DefaultLabID {
+		t.Errorf("Expected lab %s, got %q", storage.DefaultLabID, event.LabID)
+	}
+	if id != fmt.Sprint(event.ID) {
+		t.Errorf("Expected SSE id %d, got %s", event.ID, id)
+	}
+}
+
+func TestStreamEvents_ResumesFromLastEventID(t *testing.T) {
+	handlers, _, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	bus := enableTestEvents(handlers, reg, events.DefaultConfig())
+
+	sub, _, _ := bus.Subscribe(events.Filter{}, 0)
+	defer sub.Close()
+
+	registerTestAgent(t, reg, "test-agent-resume", "test-lab-host")
+	first := <-sub.Events()
+	if first.Type != events.AgentOnline {
+		t.Fatalf("Expected %s, got %s", events.AgentOnline, first.Type)
+	}
+
+	if err := handlers.scheduler.CreateJob(context.Background(), newPendingJob("job-resume", "test-agent-resume")); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+
+	// Events published while disconnected are replayed
+	stream, closeStream := openTestEventStream(t, handlers, "", fmt.Sprint(first.ID))
+	defer closeStream()
+
+	_, eventType, event := readTestEvent(t, stream)
+	if eventType != events.JobCreated || event.JobID != "job-resume" {
+		t.Errorf("Expected replayed job.created for job-resume, got %s %+v", eventType, event)
+	}
+}
+
+func TestStreamEvents_ResyncWhenEventsWereMissed(t *testing.T) {
+	handlers, _, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	bus := enableTestEvents(handlers, reg, events.Config{BufferSize: 2})
+
+	sub, _, _ := bus.Subscribe(events.Filter{}, 0)
+	defer sub.Close()
+
+	for i := 0; i < 4; i++ {
+		bus.Publish(events.Event{Type: events.ScenarioDeleted, ScenarioID: fmt.Sprintf("scenario-%d", i)})
+	}
+	first := <-sub.Events()
+
+	stream, closeStream := openTestEventStream(t, handlers, "", fmt.Sprint(first.ID))
+	defer closeStream()
+
+	if _, eventType, _ := readTestEvent(t, stream); eventType != "resync" {
+		t.Fatalf("Expected resync, got %s", eventType)
+	}
+
+	// The buffered events follow
+	if _, _, event := readTestEvent(t, stream); event.ScenarioID != "scenario-2" {
+		t.Errorf("Expected scenario-2 after resync, got %+v", event)
+	}
+}
+
+func TestStreamEvents_LabScoped(t *testing.T) {
+	handlers, db, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	bus := enableTestEvents(handlers, reg, events.DefaultConfig())
+
+	createTestLab(t, db, "lab-events", nil)
+
+	stream, closeStream := openTestEventStream(t, handlers, "lab_id=lab-events&type=agent", "")
+	defer closeStream()
+
+	bus.Publish(events.Event{Type: events.AgentOnline, LabID: storage.DefaultLabID, AgentID: "other-lab-agent"})
+	bus.Publish(events.Event{Type: events.JobCreated, LabID: "lab-events", AgentID: "lab-agent"})
+	bus.Publish(events.Event{Type: events.AgentOnline, LabID: "lab-events", AgentID: "lab-agent"})
+
+	_, eventType, event := readTestEvent(t, stream)
+	if eventType != events.AgentOnline || event.AgentID != "lab-agent" {
+		t.Errorf("Expected agent.online for lab-agent, got %s %+v", eventType, event)
+	}
+}
+
+func TestStreamEvents_Disabled(t *testing.T) {
+	handlers, _, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/events", nil)
+	w := httptest.NewRecorder()
+
+	handlers.StreamEvents(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestAgentChannel_CommandAckPublished(t *testing.T) {
+	handlers, _, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	bus := enableTestEvents(handlers, reg, events.DefaultConfig())
+
+	agentID := "test-agent-command-ack"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+
+	conn, closeChannel := openTestChannel(t, handlers, agentID)
+	defer closeChannel()
+
+	sub, _, _ := bus.Subscribe(events.Filter{Types: []string{"command"}}, 0)
+	defer sub.Close()
+
+	if err := reg.DeleteAgent(context.Background(), agentID); err != nil {
+		t.Fatalf("Failed to delete agent: %v", err)
+	}
+
+	sendChannelMessage(t, conn, protocol.MessageHeartbeat, protocol.HeartbeatRequest{Status: "online"})
+	msg := receiveChannelMessage(t, conn)
+	var cmd protocol.AgentCommand
+	if err := json.Unmarshal(msg.Payload, &cmd); err != nil {
+		t.Fatalf("Failed to decode command: %v", err)
+	}
+	if cmd.ID == "" {
+		t.Fatal("Expected command to carry an ID")
+	}
+
+	sendChannelMessage(t, conn, protocol.MessageCommandAck, protocol.CommandAckMessage{
+		CommandID: cmd.ID,
+		Type:      cmd.Type,
+		Success:   true,
+	})
+
+	for _, want := range []string{events.CommandSent, events.CommandAcked} {
+		select {
+		case e := <-sub.Events():
+			if e.Type != want || e.AgentID != agentID || e.Data["command_id"] != cmd.ID {
+				t.Errorf("Expected %s for command %s, got %+v", want, cmd.ID, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", want)
+		}
+	}
+}
+
+// ============================================================
+// Job Query Tests
+// ============================================================
+
+func TestListJobs_FiltersAndPaginates(t *testing.T) {
+	handlers, db, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	agentID := "test-agent-list-jobs"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+
+	runAs := `CORP\alice`
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	for i := 0; i < 5; i++ {
+		job := newPendingJob(fmt.Sprintf("job-list-%d", i), agentID)
+		job.ScheduledAt = base.Add(time.Duration(i) * time.Minute)
+		if i%2 == 1 {
+			job.ActionType = "other_action"
+			job.RunAsUser = &runAs
+		}
+		if err := db.CreateJob(ctx, job); err != nil {
+			t.Fatalf("Failed to create job: %v", err)
+		}
+	}
+
+	listJobs := func(query string) protocol.ListJobsResponse {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/jobs?"+query, nil)
+		w := httptest.NewRecorder()
+		handlers.ListJobs(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d for %q, got %d: %s", http.StatusOK, query, w.Code, w.Body.String())
+		}
+		var resp protocol.ListJobsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	// Page through all jobs two at a time, oldest first
+	var ids []string
+	query := "order=asc&limit=2"
+	for page := 0; page < 5; page++ {
+		resp := listJobs(query)
+		for _, job := range resp.Jobs {
+			ids = append(ids, job.ID)
+			if job.Parameters != nil {
+				t.Errorf("Expected parameters to be omitted from listings")
+			}
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		query = "order=asc&limit=2&cursor=" + resp.NextCursor
+	}
+	want := []string{"job-list-0", "job-list-1", "job-list-2", "job-list-3", "job-list-4"}
+	if strings.Join(ids, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected jobs %v, got %v", want, ids)
+	}
+
+	// Default order is newest first
+	resp := listJobs("limit=1")
+	if len(resp.Jobs) != 1 || resp.Jobs[0].ID != "job-list-4" || resp.NextCursor == "" {
+		t.Errorf("Expected newest job first with a next cursor, got %+v", resp)
+	}
+
+	resp = listJobs("action_type=other_action&run_as=" + url.QueryEscape(runAs))
+	if len(resp.Jobs) != 2 {
+		t.Fatalf("Expected 2 impersonated jobs, got %d", len(resp.Jobs))
+	}
+	if resp.Jobs[0].RunAsUser != runAs || resp.Jobs[0].RunAsLogonType != "interactive" {
+		t.Errorf("Expected run_as %q (interactive), got %q (%q)", runAs, resp.Jobs[0].RunAsUser, resp.Jobs[0].RunAsLogonType)
+	}
+
+	since := base.Add(90 * time.Second).Format(time.RFC3339)
+	resp = listJobs("status=pending,failed&since=" + url.QueryEscape(since))
+	if len(resp.Jobs) != 3 {
+		t.Errorf("Expected 3 jobs scheduled after %s, got %d", since, len(resp.Jobs))
+	}
+
+	for _, bad := range []string{"sort=priority", "order=up", "since=yesterday", "cursor=not-a-cursor"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/jobs?"+bad, nil)
+		w := httptest.NewRecorder()
+		handlers.ListJobs(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %q, got %d", http.StatusBadRequest, bad, w.Code)
+		}
+	}
+}
+
+func TestGetJob_ReturnsDetail(t *testing.T) {
+	handlers, db, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	agentID := "test-agent-get-job"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+
+	if err := db.CreateJob(ctx, newPendingJob("job-detail", agentID)); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	if err := db.UpdateJobFailed(ctx, "job-detail", time.Now().UTC(), "boom", false); err != nil {
+		t.Fatalf("Failed to fail job: %v", err)
+	}
+
+	for _, tc := range []struct {
+		jobID string
+		want  int
+	}{
+		{"job-detail", http.StatusOK},
+		{"job-missing", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/jobs/"+tc.jobID, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("jobID", tc.jobID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+
+		handlers.GetJob(w, req)
+
+		if w.Code != tc.want {
+			t.Fatalf("Expected status %d for %s, got %d", tc.want, tc.jobID, w.Code)
+		}
+		if tc.want != http.StatusOK {
+			continue
+		}
+
+		var job protocol.JobResponse
+		if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if job.Status != storage.JobStatusFailed || job.ErrorMessage != "boom" || job.Parameters["key"] != "value" {
+			t.Errorf("Expected failed job with parameters and error, got %+v", job)
+		}
+	}
+}
+
+func TestRetryAndCancelJob(t *testing.T) {
+	handlers, db, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	agentID := "test-agent-retry-job"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+
+	if err := db.CreateJob(ctx, newPendingJob("job-manual", agentID)); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+
+	post := func(action string, handler http.HandlerFunc) (int, protocol.JobResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/jobs/job-manual/"+action, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("jobID", "job-manual")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		var job protocol.JobResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return w.Code, job
+	}
+
+	// A pending job cannot be retried, but can be cancelled
+	if code, _ := post("retry", handlers.RetryJob); code != http.StatusConflict {
+		t.Errorf("Expected status %d retrying a pending job, got %d", http.StatusConflict, code)
+	}
+	code, job := post("cancel", handlers.CancelJob)
+	if code != http.StatusOK || job.Status != storage.JobStatusCancelled {
+		t.Fatalf("Expected job to be cancelled, got %d %+v", code, job)
+	}
+	if code, _ := post("cancel", handlers.CancelJob); code != http.StatusConflict {
+		t.Errorf("Expected status %d cancelling a cancelled job, got %d", http.StatusConflict, code)
+	}
+
+	// Retrying requeues the job for immediate dispatch
+	code, job = post("retry", handlers.RetryJob)
+	if code != http.StatusOK || job.Status != storage.JobStatusPending || job.CompletedAt != nil {
+		t.Fatalf("Expected job to be pending again, got %d %+v", code, job)
+	}
+	jobs, _, err := handlers.scheduler.GetNextJobsForAgent(ctx, agentID, 5)
+	if err != nil || len(jobs) != 1 || jobs[0].JobID != "job-manual" {
+		t.Errorf("Expected retried job to be dispatched, got %+v (err: %v)", jobs, err)
+	}
+}
+
+// ============================================================
+// Audit Tests
+// ============================================================
+
+func TestListAndExportAudit(t *testing.T) {
+	handlers, db, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	agentID := "test-agent-audit"
+
+	body, _ := json.Marshal(protocol.RegisterAgentRequest{
+		AgentID:   agentID,
+		LabHostID: "lab-host-audit",
+		Hostname:  "ws-audit",
+		IPAddress: "192.168.1.50",
+	})
+	w := httptest.NewRecorder()
+	handlers.RegisterAgent(w, httptest.NewRequest(http.MethodPost, "/api/agents/register", bytes.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	if err := db.CreateJob(ctx, newPendingJob("job-audit", agentID)); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/jobs/job-audit/cancel", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("jobID", "job-audit")
+	reqCtx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
+	reqCtx = auth.WithPrincipal(reqCtx, &auth.Principal{KeyID: "k1", Name: "ops", Role: auth.RoleOperator})
+	w = httptest.NewRecorder()
+	handlers.CancelJob(w, req.WithContext(reqCtx))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	listAudit := func(query string) protocol.ListAuditResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handlers.ListAudit(w, httptest.NewRequest(http.MethodGet, "/api/audit?"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var resp protocol.ListAuditResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	// Agents write as themselves and operators as their API key; the
+	// storage tests cover the entries in detail
+	resp := listAudit("entity_type=agent&entity_id=" + agentID)
+	if len(resp.Entries) != 1 || resp.Entries[0].Actor != storage.AgentActor(agentID) {
+		t.Errorf("Expected agent creation by the agent, got %+v", resp.Entries)
+	}
+
+	// Paging with limit=1 walks back through both job entries
+	page := listAudit("entity_type=job&limit=1")
+	if len(page.Entries) != 1 || page.NextCursor == "" || page.Entries[0].Actor != "key:ops" {
+		t.Fatalf("Expected the cancellation by key:ops with a cursor, got %+v", page)
+	}
+	page = listAudit("entity_type=job&limit=1&cursor=" + page.NextCursor)
+	if len(page.Entries) != 1 || page.Entries[0].Action != storage.AuditActionCreated {
+		t.Errorf("Expected second page to hold the creation entry, got %+v", page.Entries)
+	}
+
+	w = httptest.NewRecorder()
+	handlers.ExportAudit(w, httptest.NewRequest(http.MethodGet, "/api/audit/export?format=csv&entity_type=job", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("Expected CSV export, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "id,created_at,entity_type") || !strings.Contains(lines[2], "key:ops") {
+		t.Errorf("Expected header plus 2 rows oldest first, got %q", lines)
+	}
+}
+
+func TestExportImportState_RoundTrip(t *testing.T) {
+	handlers, db, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	createTestLab(t, db, "class-a", []string{"10.10.0.0/16"})
+	user := &storage.ImpersonationUser{
+		LabID:          "class-a",
+		Username:       "CORP\\alice",
+		Domain:         "CORP",
+		SAMAccountName: "alice",
+		Persona:        &storage.UserPersona{TypicalApps: []string{"outlook"}, WorkHours: &storage.WorkHours{Start: 8, End: 17}},
+	}
+	if err := db.CreateImpersonationUser(ctx, user); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := db.CreateScenario(ctx, &storage.Scenario{ID: "scn-export", LabID: "class-a", Name: "Phish", Intent: `{"goal":"phish"}`, Source: "api", Status: storage.ScenarioStatusPending}); err != nil {
+		t.Fatalf("Failed to create scenario: %v", err)
+	}
+	if err := db.UpdateScenarioValidatedDSL(ctx, "scn-export", `{"steps":[]}`); err != nil {
+		t.Fatalf("Failed to validate scenario: %v", err)
+	}
+	createTestScenario(t, db, "scn-unvalidated", "Draft", storage.ScenarioStatusPending)
+
+	w := httptest.NewRecorder()
+	handlers.ExportState(w, httptest.NewRequest(http.MethodGet, "/api/admin/export", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	exported := w.Body.Bytes()
+
+	var doc protocol.StateExport
+	if err := json.Unmarshal(exported, &doc); err != nil {
+		t.Fatalf("Failed to decode export: %v", err)
+	}
+	if doc.Format != protocol.StateExportFormat || len(doc.Labs) != 2 || len(doc.Users) != 1 || len(doc.Scenarios) != 1 {
+		t.Fatalf("Unexpected export: %s", exported)
+	}
+
+	// Rebuild: remove everything the export carries
+	if err := db.DeleteScenario(ctx, "scn-export"); err != nil {
+		t.Fatalf("Failed to delete scenario: %v", err)
+	}
+	if err := db.DeleteImpersonationUser(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if err := db.DeleteLab(ctx, "class-a"); err != nil {
+		t.Fatalf("Failed to delete lab: %v", err)
+	}
+
+	importState := func() protocol.ImportStateResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handlers.ImportState(w, httptest.NewRequest(http.MethodPost, "/api/admin/import", bytes.NewReader(exported)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var resp protocol.ImportStateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	resp := importState()
+	if resp.Labs.Created != 1 || resp.Labs.Skipped != 1 || resp.Users.Created != 1 || resp.Scenarios.Created != 1 || len(resp.Errors) != 0 {
+		t.Errorf("Unexpected first import result: %+v", resp)
+	}
+
+	lab, _ := db.GetLab(ctx, "class-a")
+	if lab == nil || len(lab.AllowedNetworks) != 1 {
+		t.Errorf("Expected lab to be restored, got %+v", lab)
+	}
+	restored, _ := db.GetImpersonationUserByUsername(ctx, "class-a", "CORP\\alice")
+	if restored == nil || restored.Persona == nil || restored.Persona.WorkHours == nil || restored.Persona.WorkHours.End != 17 {
+		t.Errorf("Expected user with persona to be restored, got %+v", restored)
+	}
+	scenario, _ := db.GetScenario(ctx, "scn-export")
+	if scenario == nil || scenario.Status != storage.ScenarioStatusValidated || scenario.ValidatedDSL == nil || *scenario.ValidatedDSL != `{"steps":[]}` {
+		t.Errorf("Expected validated scenario to be restored, got %+v", scenario)
+	}
+
+	// Importing again changes nothing
+	resp = importState()
+	if resp.Labs.Created != 0 || resp.Users.Skipped != 1 || resp.Scenarios.Skipped != 1 {
+		t.Errorf("Unexpected second import result: %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	handlers.ImportState(w, httptest.NewRequest(http.MethodPost, "/api/admin/import", strings.NewReader(`{"format":"other","version":1}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a foreign document, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestImportState_ValidatesScenariosAgainstPolicy(t *testing.T) {
+	handlers, db, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	val := validator.New()
+	handlers.SetValidator(val)
+
+	scenarioDoc := func(id, dir string) protocol.ExportedScenario {
+		dslDoc := fmt.Sprintf(`{
+			"$schema": "cymbytes-scenario-v1",
+			"id": "6f1c2a52-3b7e-4d8e-9a63-1d2e3f4a5b6c",
+			"name": "Files",
+			"version": 1,
+			"schedule": {"type": "immediate"},
+			"steps": [{
+				"id": "0b8e8d2c-5f7a-4c1e-8d9b-2a3b4c5d6e7f",
+				"order": 1,
+				"action_type": "simulate_file_activity",
+				"target": {"labels": {"role": "workstation"}, "count": "all"},
+				"parameters": {"target_directory": %q, "operations": ["create"], "file_count": 3}
+			}]
+		}`, dir)
+		return protocol.ExportedScenario{ID: id, Name: "Files", Intent: json.RawMessage(`{}`), ValidatedDSL: json.RawMessage(dslDoc)}
+	}
+	importScenarios := func(scenarios ...protocol.ExportedScenario) protocol.ImportStateResponse {
+		t.Helper()
+		body, _ := json.Marshal(protocol.StateExport{
+			Format:    protocol.StateExportFormat,
+			Version:   protocol.StateExportVersion,
+			Scenarios: scenarios,
+		})
+		w := httptest.NewRecorder()
+		handlers.ImportState(w, httptest.NewRequest(http.MethodPost, "/api/admin/import", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var resp protocol.ImportStateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	resp := importScenarios(scenarioDoc("scn-ok", "/home/user/docs"), scenarioDoc("scn-etc", "/etc/app"))
+	if resp.Scenarios.Created != 1 || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0], "scn-etc") {
+		t.Errorf("Expected the built-in path rule to reject scn-etc, got %+v", resp)
+	}
+
+	// A reloaded policy applies to the next import
+	val.SetPolicy(validator.Policy{BlockedPaths: []string{"/home/user/private"}})
+	resp = importScenarios(scenarioDoc("scn-private", "/home/user/private/x"))
+	if resp.Scenarios.Created != 0 || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0], "not allowed") {
+		t.Errorf("Expected the blocked path to be rejected, got %+v", resp)
+	}
+
+	val.SetPolicy(validator.Policy{DeniedActions: []dsl.ActionType{dsl.ActionSimulateFileActivity}})
+	resp = importScenarios(scenarioDoc("scn-denied", "/home/user/docs"))
+	if resp.Scenarios.Created != 0 || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0], "denied by policy") {
+		t.Errorf("Expected the denied action to be rejected, got %+v", resp)
+	}
+
+	if scenario, _ := db.GetScenario(context.Background(), "scn-ok"); scenario == nil {
+		t.Error("Expected the valid scenario to be imported")
+	}
+}
+
+func TestBackupDatabase_RestoresToCopy(t *testing.T) {
+	handlers, db, _, cleanup := setupSQLiteTestHandlers(t)
+	defer cleanup()
+
+	createTestUser(t, db, "CORP\\bob", "CORP", "bob")
+
+	w := httptest.NewRecorder()
+	handlers.BackupDatabase(w, httptest.NewRequest(http.MethodGet, "/api/admin/backup", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/vnd.sqlite3" {
+		t.Errorf("Expected SQLite content type, got %q", ct)
+	}
+
+	dir := t.TempDir()
+	backupPath := dir + "/backup.db"
+	if err := os.WriteFile(backupPath, w.Body.Bytes(), 0o600); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+
+	dbPath := dir + "/restored.db"
+	if err := storage.Restore(context.Background(), backupPath, dbPath); err != nil {
+		t.Fatalf("Failed to restore backup: %v", err)
+	}
+	restored, err := storage.New(context.Background(), storage.Config{Path: dbPath}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer restored.Close()
+
+	user, err := restored.GetImpersonationUserByUsername(context.Background(), "", "CORP\\bob")
+	if err != nil || user == nil {
+		t.Errorf("Expected user in restored database, got %v (err: %v)", user, err)
+	}
+
+	if err := os.WriteFile(backupPath, []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("Failed to write backup: %v", err)
+	}
+	if err := storage.Restore(context.Background(), backupPath, dbPath); err == nil {
+		t.Error("Expected restoring a corrupt backup to fail")
+	}
+}
+
+func TestBackupDatabase_UnsupportedStore(t *testing.T) {
+	db := storage.NewMemory(zerolog.Nop())
+	defer db.Close()
+	handlers, _ := newTestHandlers(db)
+
+	w := httptest.NewRecorder()
+	handlers.BackupDatabase(w, httptest.NewRequest(http.MethodGet, "/api/admin/backup", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d for the in-memory store, got %d", http.StatusNotImplemented, w.Code)
+	}
+}
+
+// webhookRequest builds a request for a /api/webhooks/{webhookID} route
+func webhookRequest(method, webhookID string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, "/api/webhooks/"+webhookID, bytes.NewReader(body))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("webhookID", webhookID)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestCreateWebhook_SecretOnlyReturnedWhenSet(t *testing.T) {
+	handlers, _, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	body, _ := json.Marshal(protocol.CreateWebhookRequest{
+		Name:       "tickets",
+		URL:        "http://tickets.local/api/webhooks/orchestrator",
+		EventTypes: []string{"job.completed", "scenario"},
+	})
+	w := httptest.NewRecorder()
+	handlers.CreateWebhook(w, httptest.NewRequest(http.MethodPost, "/api/webhooks", bytes.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var created protocol.WebhookResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !strings.HasPrefix(created.Secret, webhooks.SecretPrefix) || !created.Enabled {
+		t.Errorf("Expected an enabled subscription with a generated secret, got %+v", created)
+	}
+	if created.MaxRetries != webhooks.DefaultMaxRetries || created.TimeoutMs != webhooks.DefaultTimeout.Milliseconds() {
+		t.Errorf("Expected the default retry policy, got %+v", created)
+	}
+
+	// The secret is not shown again
+	w = httptest.NewRecorder()
+	handlers.GetWebhook(w, webhookRequest(http.MethodGet, created.ID, nil))
+	var fetched protocol.WebhookResponse
+	if err := json.NewDecoder(w.Body).Decode(&fetched); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if fetched.Secret != "" || fetched.Name != "tickets" {
+		t.Errorf("Expected the subscription without its secret, got %+v", fetched)
+	}
+
+	// Rotating returns the new secret
+	body, _ = json.Marshal(protocol.UpdateWebhookRequest{RotateSecret: true})
+	w = httptest.NewRecorder()
+	handlers.UpdateWebhook(w, webhookRequest(http.MethodPut, created.ID, body))
+	var rotated protocol.WebhookResponse
+	if err := json.NewDecoder(w.Body).Decode(&rotated); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if rotated.Secret == "" || rotated.Secret == created.Secret {
+		t.Errorf("Expected a new secret after rotation, got %q", rotated.Secret)
+	}
+
+	// Names are unique
+	body, _ = json.Marshal(protocol.CreateWebhookRequest{Name: "tickets", URL: "http://other.local/hook"})
+	w = httptest.NewRecorder()
+	handlers.CreateWebhook(w, httptest.NewRequest(http.MethodPost, "/api/webhooks", bytes.NewReader(body)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for a duplicate name, got %d", http.StatusConflict, w.Code)
+	}
+}
+
+func TestCreateWebhook_Validation(t *testing.T) {
+	handlers, _, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	retries := 50
+	tests := []struct {
+		name string
+		req  protocol.CreateWebhookRequest
+		want int
+	}{
+		{"unknown event type", protocol.CreateWebhookRequest{Name: "a", URL: "http://a.local", EventTypes: []string{"jobs.done"}}, http.StatusBadRequest},
+		{"not http", protocol.CreateWebhookRequest{Name: "b", URL: "ftp://b.local"}, http.StatusBadRequest},
+		{"short secret", protocol.CreateWebhookRequest{Name: "c", URL: "http://c.local", Secret: "short"}, http.StatusBadRequest},
+		{"too many retries", protocol.CreateWebhookRequest{Name: "d", URL: "http://d.local", MaxRetries: &retries}, http.StatusBadRequest},
+		{"unknown lab", protocol.CreateWebhookRequest{Name: "e", URL: "http://e.local", LabID: "missing"}, http.StatusNotFound},
+		{"unknown scenario", protocol.CreateWebhookRequest{Name: "f", URL: "http://f.local", ScenarioID: "missing"}, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(tt.req)
+			w := httptest.NewRecorder()
+			handlers.CreateWebhook(w, httptest.NewRequest(http.MethodPost, "/api/webhooks", bytes.NewReader(body)))
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTestWebhook_RecordsFailedDelivery(t *testing.T) {
+	handlers, db, _, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	sub := &storage.WebhookSubscription{
+		Name:         "messenger",
+		URL:          server.URL,
+		Secret:       "test-secret-0123456789",
+		MaxRetries:   3,
+		RetryBackoff: time.Minute,
+		Timeout:      time.Second,
+	}
+	if err := db.CreateWebhookSubscription(context.Background(), sub); err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+
+	// Without a dispatcher there is nothing to send with
+	w := httptest.NewRecorder()
+	handlers.TestWebhook(w, webhookRequest(http.MethodPost, sub.ID, nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d without a dispatcher, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	handlers.SetWebhookDispatcher(webhooks.NewDispatcher(db, events.New(events.DefaultConfig(), zerolog.Nop()), zerolog.Nop()))
+
+	// Test events go out once, even to a disabled subscription
+	w = httptest.NewRecorder()
+	handlers.TestWebhook(w, webhookRequest(http.MethodPost, sub.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var delivery protocol.WebhookDeliveryResponse
+	if err := json.NewDecoder(w.Body).Decode(&delivery); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if delivery.Status != storage.WebhookDeliveryFailed || delivery.Attempts != 1 || !delivery.Test ||
+		delivery.ResponseStatus == nil || *delivery.ResponseStatus != http.StatusInternalServerError {
+		t.Errorf("Expected one failed test attempt, got %+v", delivery)
+	}
+
+	w = httptest.NewRecorder()
+	handlers.ListWebhookDeliveries(w, webhookRequest(http.MethodGet, sub.ID, nil))
+	var history protocol.ListWebhookDeliveriesResponse
+	if err := json.NewDecoder(w.Body).Decode(&history); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if history.Total != 1 || history.Deliveries[0].EventType != webhooks.TestEventType || history.Deliveries[0].Error == "" {
+		t.Errorf("Expected the failed test delivery in the history, got %+v", history)
+	}
+
+	// Deleting the subscription removes it and its history
+	w = httptest.NewRecorder()
+	handlers.DeleteWebhook(w, webhookRequest(http.MethodDelete, sub.ID, nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if deliveries, _ := db.ListWebhookDeliveries(context.Background(), sub.ID, 0); len(deliveries) != 0 {
+		t.Errorf("Expected the history to be removed, got %d deliveries", len(deliveries))
+	}
+}
+
+func outboxRequest(method, entryID string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, "/api/outbox/"+entryID, bytes.NewReader(body))
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("entryID", entryID)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
+func TestListAndReplayOutbox(t *testing.T) {
+	handlers, db, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	handlers.scheduler.SetMessengerForwarder(webhooks.NewForwarder(webhooks.Config{
+		Enabled:      true,
+		MessengerURL: "http://messenger.invalid",
+		Timeout:      time.Second,
+	}, zerolog.Nop()))
+	handlers.SetOutbox(outbox.New(db, outbox.Config{}, zerolog.Nop()))
+
+	ctx := context.Background()
+	agentID := "test-agent-outbox"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+	if err := handlers.scheduler.CreateJob(ctx, newPendingJob("job-outbox", agentID)); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	if _, _, err := handlers.scheduler.GetNextJobsForAgent(ctx, agentID, 1); err != nil {
+		t.Fatalf("Failed to assign job: %v", err)
+	}
+	now := time.Now()
+	_, _, err := handlers.scheduler.ProcessJobResult(ctx, agentID, "job-outbox", &protocol.JobResultRequest{
+		Status:      "completed",
+		StartedAt:   now.Add(-time.Second),
+		CompletedAt: now,
+	})
+	if err != nil {
+		t.Fatalf("Failed to process job result: %v", err)
+	}
+
+	// The event is written with the result; dead-letter it as the worker
+	// would after exhausting its attempts
+	entries, err := db.ListOutboxEntries(ctx, storage.OutboxFilter{Target: storage.OutboxTargetMessenger})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected one messenger entry, got %d (err: %v)", len(entries), err)
+	}
+	lastError := "503 Service Unavailable"
+	entries[0].Status = storage.OutboxStatusDead
+	entries[0].Attempts = 2
+	entries[0].LastError = &lastError
+	if err := db.UpdateOutboxEntry(ctx, entries[0]); err != nil {
+		t.Fatalf("Failed to update entry: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	handlers.ListOutbox(w, httptest.NewRequest(http.MethodGet, "/api/outbox?status=dead", nil))
+	var list protocol.ListOutboxResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if list.Total != 1 || list.Counts[storage.OutboxStatusDead] != 1 {
+		t.Fatalf("Expected 1 dead entry, got %+v", list)
+	}
+	dead := list.Entries[0]
+	if dead.Target != storage.OutboxTargetMessenger || dead.EventType != "job.completed" || dead.Attempts != 2 ||
+		dead.LastError != lastError || dead.Payload != nil {
+		t.Errorf("Unexpected dead entry: %+v", dead)
+	}
+
+	// A single entry includes its payload
+	w = httptest.NewRecorder()
+	handlers.GetOutboxEntry(w, outboxRequest(http.MethodGet, dead.ID, nil))
+	var entry protocol.OutboxEntryResponse
+	if err := json.NewDecoder(w.Body).Decode(&entry); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !strings.Contains(string(entry.Payload), dead.EventID) {
+		t.Errorf("Expected the payload to carry event ID %s, got %s", dead.EventID, entry.Payload)
+	}
+
+	// Replaying returns the entry to pending with a fresh attempt budget
+	w = httptest.NewRecorder()
+	handlers.ReplayOutbox(w, httptest.NewRequest(http.MethodPost, "/api/outbox/replay", strings.NewReader(`{"target":"messenger"}`)))
+	var replay protocol.ReplayOutboxResponse
+	if err := json.NewDecoder(w.Body).Decode(&replay); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if replay.Replayed != 1 {
+		t.Fatalf("Expected 1 replayed entry, got %d", replay.Replayed)
+	}
+	replayed, err := db.GetOutboxEntry(ctx, dead.ID)
+	if err != nil || replayed == nil {
+		t.Fatalf("Failed to get entry: %v", err)
+	}
+	if replayed.Status != storage.OutboxStatusPending || replayed.Attempts != 0 {
+		t.Errorf("Expected the entry pending with no attempts, got %+v", replayed)
+	}
+
+	// A delivered entry cannot be replayed
+	deliveredAt := time.Now().UTC()
+	replayed.Status = storage.OutboxStatusDelivered
+	replayed.DeliveredAt = &deliveredAt
+	if err := db.UpdateOutboxEntry(ctx, replayed); err != nil {
+		t.Fatalf("Failed to update entry: %v", err)
+	}
+	w = httptest.NewRecorder()
+	handlers.ReplayOutboxEntry(w, outboxRequest(http.MethodPost, dead.ID, nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handlers.GetOutboxEntry(w, outboxRequest(http.MethodGet, "missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestSetScenarioScoringRun_ForwardsLifecycleAndResults(t *testing.T) {
+	handlers, db, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	var received []string
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/runs" {
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"run_id":"run-1"}`)
+			return
+		}
+		var event scoring.ScoringEvent
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		received = append(received, r.URL.Path+" "+event.EventType)
+		mu.Unlock()
+	}))
+	defer engine.Close()
+
+	scoringCfg := scoring.Config{Enabled: true, EngineURL: engine.URL, Timeout: time.Second}
+	worker := outbox.New(db, outbox.Config{}, zerolog.Nop())
+	worker.Register(storage.OutboxTargetScoring, scoring.NewEventForwarder(scoringCfg, zerolog.Nop()))
+
+	ctx := context.Background()
+	scenarioID := "scenario-scoring"
+	createTestScenario(t, db, scenarioID, "Scored Scenario", storage.ScenarioStatusActive)
+	setRun := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/scenarios/"+scenarioID+"/scoring-run", strings.NewReader(body))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("scenarioID", scenarioID)
+		w := httptest.NewRecorder()
+		handlers.SetScenarioScoringRun(w, req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx)))
+		return w
+	}
+
+	// Creating a run needs the scoring engine
+	if w := setRun(`{"create":true}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d without a scoring engine, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if w := setRun(`{"run_id":"run-0","create":true}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for both run_id and create, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	handlers.scheduler.SetScoringForwarder(scoring.NewEventForwarder(scoringCfg, zerolog.Nop()))
+
+	// Dispatch the scenario's job before the run is attached
+	agentID := "test-agent-scoring"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+	job := newPendingJob("job-scoring", agentID)
+	job.ActionType = "observe_file_state"
+	job.ScenarioID = &scenarioID
+	if err := handlers.scheduler.CreateJob(ctx, job); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	if _, _, err := handlers.scheduler.GetNextJobsForAgent(ctx, agentID, 1); err != nil {
+		t.Fatalf("Failed to assign job: %v", err)
+	}
+
+	w := setRun(`{"create":true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp protocol.ScoringRunResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.ScoringRunID != "run-1" || !resp.Created {
+		t.Errorf("Expected created run run-1, got %+v", resp)
+	}
+	scenario, _ := db.GetScenario(ctx, scenarioID)
+	if scenario.ScoringRunID == nil || *scenario.ScoringRunID != "run-1" {
+		t.Errorf("Expected the scenario to carry run-1, got %v", scenario.ScoringRunID)
+	}
+
+	now := time.Now()
+	_, _, err := handlers.scheduler.ProcessJobResult(ctx, agentID, job.ID, &protocol.JobResultRequest{
+		Status:      "completed",
+		StartedAt:   now.Add(-time.Second),
+		CompletedAt: now,
+		Result:      &protocol.JobResult{Data: map[string]interface{}{"state_matches": true}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to process job result: %v", err)
+	}
+
+	// The scheduler loop completes the scenario
+	cfg := scheduler.DefaultConfig()
+	cfg.PollInterval = 10 * time.Millisecond
+	handlers.scheduler.UpdateConfig(cfg)
+	handlers.scheduler.Start(ctx)
+	defer handlers.scheduler.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		scenario, _ = db.GetScenario(ctx, scenarioID)
+		if scenario.Status == storage.ScenarioStatusCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Scenario was not completed, status %s", scenario.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := worker.Drain(ctx); n != 3 {
+		t.Fatalf("Expected 3 scoring deliveries, got %d", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"/api/v1/runs/run-1/events scenario.started",
+		"/api/v1/runs/run-1/events state.verified",
+		"/api/v1/runs/run-1/events scenario.completed",
+	}
+	if strings.Join(received, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected deliveries %v, got %v", want, received)
+	}
+
+	// A finished scenario cannot be given a run
+	if w := setRun(`{"run_id":"run-2"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, w.Code)
+	}
+}
+
+func TestGetScenarioScorecard_GradesObjectives(t *testing.T) {
+	handlers, db, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	bus := enableTestEvents(handlers, reg, events.DefaultConfig())
+	ctx := context.Background()
+
+	processStep := "550e8400-e29b-41d4-a716-446655440001"
+	historyStep := "550e8400-e29b-41d4-a716-446655440002"
+	doc := &dsl.Scenario{
+		Schema:  dsl.SchemaVersion,
+		ID:      "550e8400-e29b-41d4-a716-446655440000",
+		Name:    "Graded response",
+		Version: 1,
+		Steps: []dsl.Step{
+			{ID: processStep, Order: 1, ActionType: dsl.ActionObserveProcessState,
+				Target:     dsl.Target{Labels: map[string]string{"role": "test"}, Count: "any"},
+				Parameters: json.RawMessage(`{"process_name": "updater.exe"}`)},
+			{ID: historyStep, Order: 2, ActionType: dsl.ActionCapturePowerShellHistory,
+				Target:     dsl.Target{Labels: map[string]string{"role": "test"}, Count: "any"},
+				Parameters: json.RawMessage(`{"command_pattern": "Stop-Process"}`)},
+		},
+		Schedule: dsl.Schedule{Type: "immediate"},
+		Objectives: []dsl.Objective{
+			{ID: "contain", Name: "Process stopped", StepIDs: []string{processStep},
+				DeadlineSeconds: 3600, RetryIntervalSeconds: 60},
+			{ID: "evidence", Name: "Stop-Process used", StepIDs: []string{historyStep},
+				Assert: []string{"has_matches == true", "match_count >= 1"}, Weight: 2},
+		},
+	}
+
+	// Objectives must reference steps and parse
+	val := validator.New()
+	if result := val.ValidateScenario(doc); !result.Valid {
+		t.Fatalf("Expected the objectives to be valid, got %+v", result.Errors)
+	}
+	invalid := *doc
+	invalid.Objectives = []dsl.Objective{
+		{ID: "a", Name: "Unknown step", StepIDs: []string{"550e8400-e29b-41d4-a716-446655440099"}},
+		{ID: "a", Name: "Bad assertion", StepIDs: []string{historyStep}, Assert: []string{"match_count >= many"}},
+		{ID: "b", Name: "Retry without deadline", StepIDs: []string{processStep}, RetryIntervalSeconds: 60},
+	}
+	rules := map[string]bool{}
+	for _, e := range val.ValidateScenario(&invalid).Errors {
+		rules[e.Field+" "+e.Rule] = true
+	}
+	for _, want := range []string{
+		"objectives[0].step_ids[0] step_exists",
+		"objectives[1].id unique_id",
+		"objectives[1].assert[0] valid_assertion",
+		"objectives[2].deadline_seconds required_with",
+	} {
+		if !rules[want] {
+			t.Errorf("Expected validation error %q, got %v", want, rules)
+		}
+	}
+
+	scenarioID := "scenario-scorecard"
+	createTestScenario(t, db, scenarioID, "Graded response", storage.ScenarioStatusActive)
+	dslJSON, _ := json.Marshal(doc)
+	if err := db.UpdateScenarioValidatedDSL(ctx, scenarioID, string(dslJSON)); err != nil {
+		t.Fatalf("Failed to store DSL: %v", err)
+	}
+
+	agentID := "test-agent-scorecard"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+	for _, step := range doc.Steps {
+		job := newPendingJob("job-"+string(step.ActionType), agentID)
+		job.ActionType = string(step.ActionType)
+		job.ScenarioID = &scenarioID
+		job.ScenarioStepID = &step.ID
+		if err := handlers.scheduler.CreateJob(ctx, job); err != nil {
+			t.Fatalf("Failed to create job: %v", err)
+		}
+	}
+
+	sub, _, _ := bus.Subscribe(events.Filter{Types: []string{"objective", events.JobRetryScheduled}}, 0)
+	defer sub.Close()
+
+	report := func(jobID string, data map[string]interface{}) {
+		t.Helper()
+		now := time.Now()
+		_, _, err := handlers.scheduler.ProcessJobResult(ctx, agentID, jobID, &protocol.JobResultRequest{
+			Status:      "completed",
+			StartedAt:   now.Add(-time.Second),
+			CompletedAt: now,
+			Result:      &protocol.JobResult{Data: data},
+		})
+		if err != nil {
+			t.Fatalf("Failed to process job result: %v", err)
+		}
+	}
+
+	// The process is still running: the check is re-run before the deadline
+	report("job-observe_process_state", map[string]interface{}{"state_matches": false})
+	e := <-sub.Events()
+	if e.Type != events.JobRetryScheduled || e.Data["objective_id"] != "contain" {
+		t.Errorf("Expected a re-run for objective contain, got %s %v", e.Type, e.Data)
+	}
+	job, _ := db.GetJob(ctx, "job-observe_process_state")
+	if job.Status != storage.JobStatusPending || !job.ScheduledAt.After(time.Now().Add(50*time.Second)) {
+		t.Errorf("Expected the job to be pending for a minute, got %s at %v", job.Status, job.ScheduledAt)
+	}
+
+	report("job-capture_powershell_history", map[string]interface{}{"has_matches": true, "match_count": 2})
+	e = <-sub.Events()
+	if e.Type != events.ObjectivePassed || e.ScenarioID != scenarioID || e.Data["objective_id"] != "evidence" {
+		t.Errorf("Expected objective.passed for evidence, got %s %v", e.Type, e.Data)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/scenarios/"+scenarioID+"/scorecard", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("scenarioID", scenarioID)
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	handlers.GetScenarioScorecard(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var card protocol.ScorecardResponse
+	if err := json.NewDecoder(w.Body).Decode(&card); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if card.Score != 2 || card.MaxScore != 3 || card.Passed != 1 || card.Pending != 1 || card.Failed != 0 {
+		t.Errorf("Expected 2/3 with one objective pending, got %+v", card)
+	}
+	if len(card.Objectives) != 2 {
+		t.Fatalf("Expected 2 objectives, got %d", len(card.Objectives))
+	}
+	contain, evidence := card.Objectives[0], card.Objectives[1]
+	if contain.Status != "pending" || contain.Deadline == nil || contain.Require != "all" || len(contain.Checks) != 1 || contain.Checks[0].Passed {
+		t.Errorf("Expected contain to be pending with a failing check, got %+v", contain)
+	}
+	if evidence.Status != "passed" || evidence.Score != 2 || evidence.PassedAt == nil || len(evidence.Checks) != 1 || !evidence.Checks[0].Passed {
+		t.Errorf("Expected evidence to have passed, got %+v", evidence)
+	}
+}
+
+func TestGetScenarioTimeline_Formats(t *testing.T) {
+	handlers, db, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	scenarioID := "scenario-timeline"
+	createTestScenario(t, db, scenarioID, "Timeline", storage.ScenarioStatusActive)
+	agentID := "test-agent-timeline"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+
+	runAs := `CORP\jdoe`
+	browse := newPendingJob("job-browse", agentID)
+	browse.ActionType = string(dsl.ActionSimulateBrowsing)
+	browse.Parameters = map[string]interface{}{"urls": []interface{}{"https://intranet.corp.local"}}
+	browse.RunAsUser = &runAs
+	email := newPendingJob("job-email", agentID)
+	email.ActionType = string(dsl.ActionSimulateEmailTraffic)
+	email.Parameters = map[string]interface{}{"server": "mail.corp.local", "recipients": []interface{}{"a@corp.local"}, "password": "secret"}
+	email.MaxRetries = 0
+	pending := newPendingJob("job-pending", agentID)
+	for _, job := range []*storage.Job{browse, email, pending} {
+		job.ScenarioID = &scenarioID
+		if err := handlers.scheduler.CreateJob(ctx, job); err != nil {
+			t.Fatalf("Failed to create job: %v", err)
+		}
+	}
+
+	// The email job started first even though it reported last
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	report := func(jobID, status string, started time.Time, data map[string]interface{}) {
+		t.Helper()
+		req := &protocol.JobResultRequest{Status: status, StartedAt: started, CompletedAt: started.Add(1500 * time.Millisecond)}
+		if status == "completed" {
+			req.Result = &protocol.JobResult{Data: data}
+		} else {
+			req.Error = &protocol.JobError{Code: "smtp_error", Message: "connection refused"}
+		}
+		if _, _, err := handlers.scheduler.ProcessJobResult(ctx, agentID, jobID, req); err != nil {
+			t.Fatalf("Failed to process job result: %v", err)
+		}
+	}
+	report("job-browse", "completed", base.Add(time.Minute), map[string]interface{}{"pages_visited": 3, "note": "ok"})
+	report("job-email", "failed", base, nil)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/scenarios/"+scenarioID+"/timeline"+query, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("scenarioID", scenarioID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		handlers.GetScenarioTimeline(w, req)
+		return w
+	}
+
+	w := get("")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var tl protocol.TimelineResponse
+	if err := json.NewDecoder(w.Body).Decode(&tl); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(tl.Entries) != 2 || tl.Entries[0].JobID != "job-email" || tl.Entries[1].JobID != "job-browse" {
+		t.Fatalf("Expected the email then the browsing job, got %+v", tl.Entries)
+	}
+	if tl.Entries[0].Error == "" || tl.Entries[1].Hostname != "test-host" || tl.Entries[1].RunAs != runAs {
+		t.Errorf("Unexpected entries: %+v", tl.Entries)
+	}
+
+	w = get("?format=csv")
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+	if len(records) != 3 || records[0][0] != "started_at" || records[2][7] != string(dsl.ActionSimulateBrowsing) {
+		t.Errorf("Unexpected CSV export: %v", records)
+	}
+
+	w = get("?format=ecs&bulk=true")
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected NDJSON, got %q", ct)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected an action and a document per entry, got %d lines", len(lines))
+	}
+	var action map[string]interface{}
+	json.Unmarshal([]byte(lines[2]), &action)
+	if create, _ := action["create"].(map[string]interface{}); create["_id"] != "job-browse" {
+		t.Errorf("Expected a create action for job-browse, got %v", action)
+	}
+
+	if w = get("?format=xml"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown format, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestGetScenarioReport_Formats(t *testing.T) {
+	handlers, db, reg, cleanup := setupTestHandlers(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	browseStep := "550e8400-e29b-41d4-a716-446655440011"
+	emailStep := "550e8400-e29b-41d4-a716-446655440012"
+	doc := &dsl.Scenario{
+		Schema:  dsl.SchemaVersion,
+		ID:      "550e8400-e29b-41d4-a716-446655440010",
+		Name:    "After-action <review>",
+		Version: 1,
+		Steps: []dsl.Step{
+			{ID: browseStep, Order: 1, ActionType: dsl.ActionSimulateBrowsing,
+				Target:     dsl.Target{Labels: map[string]string{"role": "test"}, Count: "all"},
+				Parameters: json.RawMessage(`{"urls": ["https://intranet.corp.local"]}`),
+				RunAs:      &dsl.RunAs{User: `CORP\jdoe`}},
+			{ID: emailStep, Order: 2, ActionType: dsl.ActionSimulateEmailTraffic,
+				Target:     dsl.Target{Labels: map[string]string{"role": "test"}, Count: "any"},
+				Parameters: json.RawMessage(`{"server": "mail.corp.local", "password": "hunter2"}`)},
+		},
+		Schedule: dsl.Schedule{Type: "immediate"},
+	}
+
+	scenarioID := "scenario-report"
+	createTestScenario(t, db, scenarioID, doc.Name, storage.ScenarioStatusActive)
+	dslJSON, _ := json.Marshal(doc)
+	if err := db.UpdateScenarioValidatedDSL(ctx, scenarioID, string(dslJSON)); err != nil {
+		t.Fatalf("Failed to store DSL: %v", err)
+	}
+
+	agentID := "test-agent-report"
+	registerTestAgent(t, reg, agentID, "test-lab-host")
+	runAs := `CORP\jdoe`
+	jobs := map[string]*storage.Job{}
+	for _, id := range []string{"job-browse", "job-email", "job-email-2"} {
+		job := newPendingJob(id, agentID)
+		job.ScenarioID = &scenarioID
+		job.MaxRetries = 0
+		if id == "job-browse" {
+			job.ActionType = string(dsl.ActionSimulateBrowsing)
+			job.ScenarioStepID = &browseStep
+			job.RunAsUser = &runAs
+		} else {
+			job.ActionType = string(dsl.ActionSimulateEmailTraffic)
+			job.ScenarioStepID = &emailStep
+		}
+		if err := handlers.scheduler.CreateJob(ctx, job); err != nil {
+			t.Fatalf("Failed to create job: %v", err)
+		}
+		jobs[id] = job
+	}
+
+	now := time.Now().UTC()
+	if _, _, err := handlers.scheduler.ProcessJobResult(ctx, agentID, "job-browse", &protocol.JobResultRequest{
+		Status: "completed", StartedAt: now.Add(-3 * time.Second), CompletedAt: now.Add(-time.Second),
+		Result: &protocol.JobResult{Data: map[string]interface{}{"pages_loaded": 2}},
+	}); err != nil {
+		t.Fatalf("Failed to process job result: %v", err)
+	}
+	if _, _, err := handlers.scheduler.ProcessJobResult(ctx, agentID, "job-email", &protocol.JobResultRequest{
+		Status: "failed", StartedAt: now.Add(-2 * time.Second), CompletedAt: now,
+		Error: &protocol.JobError{Code: "smtp_error", Message: "connection refused"},
+	}); err != nil {
+		t.Fatalf("Failed to process job result: %v", err)
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/scenarios/"+scenarioID+"/report"+query, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("scenarioID", scenarioID)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		handlers.GetScenarioReport(w, req)
+		return w
+	}
+
+	w := get("")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("Expected the password to be redacted, got %s", w.Body.String())
+	}
+	var rep protocol.ScenarioReport
+	if err := json.NewDecoder(w.Body).Decode(&rep); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(rep.Steps) != 2 || rep.Steps[1].Jobs != 2 || len(rep.Failures) != 1 || rep.Timing.Jobs != 3 {
+		t.Errorf("Unexpected report: %+v", rep)
+	}
+
+	w = get("?format=html")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Expected HTML, got %q", ct)
+	}
+	if html := w.Body.String(); !strings.Contains(html, "After-action &lt;review&gt;") || strings.Contains(html, "hunter2") {
+		t.Errorf("Unexpected HTML report: %s", html)
+	}
+
+	w = get("?format=md")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/markdown") {
+		t.Errorf("Expected Markdown, got %q", ct)
+	}
+	if md := w.Body.String(); !strings.Contains(md, "## Plan and Execution") {
+		t.Errorf("Unexpected Markdown report: %s", md)
+	}
+
+	if w = get("?format=pdf"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unknown format, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -12,9 +13,15 @@ import (
 	"github.com/rs/zerolog"
 
 	"cymbytes.com/cymconductor/internal/orchestrator/api/handlers"
+	"cymbytes.com/cymconductor/internal/orchestrator/auth"
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+	"cymbytes.com/cymconductor/internal/orchestrator/outbox"
 	"cymbytes.com/cymconductor/internal/orchestrator/registry"
 	"cymbytes.com/cymconductor/internal/orchestrator/scheduler"
 	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/internal/orchestrator/validator"
+	"cymbytes.com/cymconductor/internal/orchestrator/webhooks"
 )
 
 // Server is the HTTP API server.
@@ -38,6 +45,13 @@ type Config struct {
 
 	// Web directory for dashboard static files
 	WebDir string
+
+	// Auth configures API key enforcement
+	Auth auth.Config
+
+	// AllowedOrigins lists origins permitted for cross-origin requests.
+	// Empty means same-origin only; "*" allows any origin.
+	AllowedOrigins []string
 }
 
 // DefaultConfig returns sensible defaults.
@@ -54,9 +68,14 @@ func DefaultConfig() Config {
 
 // Dependencies holds the dependencies needed by the API handlers.
 type Dependencies struct {
-	DB        *storage.DB
+	DB        storage.Store
 	Registry  *registry.Registry
 	Scheduler *scheduler.Scheduler
+	Events    *events.Bus
+	Validator *validator.Validator
+	Webhooks  *webhooks.Dispatcher
+	Outbox    *outbox.Worker
+	Metrics   *metrics.Metrics
 	Version   string
 	StartTime time.Time
 }
@@ -67,6 +86,14 @@ func New(cfg Config, deps Dependencies, logger zerolog.Logger) *Server {
 
 	// Create handlers
 	h := handlers.New(deps.DB, deps.Registry, deps.Scheduler, deps.Version, deps.StartTime, logger)
+	h.SetEventBus(deps.Events)
+	h.SetValidator(deps.Validator)
+	h.SetWebhookDispatcher(deps.Webhooks)
+	h.SetOutbox(deps.Outbox)
+	authn := auth.New(deps.DB, cfg.Auth, logger)
+	viewer := authn.Require(auth.RoleViewer)
+	operator := authn.Require(auth.RoleOperator)
+	admin := authn.Require(auth.RoleAdmin)
 
 	// Create router
 	router := chi.NewRouter()
@@ -74,21 +101,28 @@ func New(cfg Config, deps Dependencies, logger zerolog.Logger) *Server {
 	// Middleware stack
 	router.Use(middleware.RequestID)
 	router.Use(middleware.RealIP)
-	router.Use(corsMiddleware)
+	router.Use(corsMiddleware(cfg.AllowedOrigins))
 	router.Use(requestLogger(logger))
 	router.Use(middleware.Recoverer)
-	router.Use(middleware.Timeout(cfg.ReadTimeout))
+	router.Use(timeoutMiddleware(cfg.ReadTimeout))
 
 	// Routes
 	router.Route("/api", func(r chi.Router) {
-		// Agent endpoints
+		// API description (public, like /health)
+		r.Get("/openapi.json", h.GetOpenAPI)
+
+		// Agent endpoints. Agent-facing calls (register, heartbeat, jobs) are
+		// not subject to operator API keys.
 		r.Route("/agents", func(r chi.Router) {
 			r.Post("/register", h.RegisterAgent)
-			r.Get("/", h.ListAgents)
+			r.With(viewer).Get("/", h.ListAgents)
 
 			r.Route("/{agentID}", func(r chi.Router) {
+				r.Use(agentActor)
 				r.Post("/heartbeat", h.AgentHeartbeat)
-				r.Get("/", h.GetAgent)
+				r.Get("/ws", h.AgentChannel)
+				r.With(viewer).Get("/", h.GetAgent)
+				r.With(admin).Delete("/", h.DeleteAgent)
 
 				// Job endpoints for agents
 				r.Route("/jobs", func(r chi.Router) {
@@ -100,36 +134,117 @@ func New(cfg Config, deps Dependencies, logger zerolog.Logger) *Server {
 
 		// Scenario endpoints
 		r.Route("/scenarios", func(r chi.Router) {
-			r.Post("/", h.CreateScenario)
-			r.Get("/", h.ListScenarios)
+			r.With(operator).Post("/", h.CreateScenario)
+			r.With(viewer).Get("/", h.ListScenarios)
 
 			r.Route("/{scenarioID}", func(r chi.Router) {
-				r.Get("/", h.GetScenario)
-				r.Get("/status", h.GetScenarioStatus)
-				r.Delete("/", h.DeleteScenario)
+				r.With(viewer).Get("/", h.GetScenario)
+				r.With(viewer).Get("/status", h.GetScenarioStatus)
+				r.With(viewer).Get("/scorecard", h.GetScenarioScorecard)
+				r.With(viewer).Get("/timeline", h.GetScenarioTimeline)
+				r.With(viewer).Get("/report", h.GetScenarioReport)
+				r.With(operator).Delete("/", h.DeleteScenario)
+				r.With(operator).Put("/scoring-run", h.SetScenarioScoringRun)
 			})
 		})
 
-		// Job admin endpoints
+		// Job query and admin endpoints
 		r.Route("/jobs", func(r chi.Router) {
-			r.Get("/stats", h.GetJobStats)
+			r.With(viewer).Get("/", h.ListJobs)
+			r.With(viewer).Get("/stats", h.GetJobStats)
+
+			r.Route("/{jobID}", func(r chi.Router) {
+				r.With(viewer).Get("/", h.GetJob)
+				r.With(operator).Post("/retry", h.RetryJob)
+				r.With(operator).Post("/cancel", h.CancelJob)
+			})
 		})
 
+		// Live event stream (SSE)
+		r.With(viewer).Get("/events", h.StreamEvents)
+
 		// Debug endpoints (for development/testing)
 		r.Route("/debug", func(r chi.Router) {
+			r.Use(admin)
 			r.Post("/test-job", h.CreateTestJob)
 		})
 
 		// User management endpoints (impersonation users)
 		r.Route("/users", func(r chi.Router) {
-			r.Post("/", h.CreateImpersonationUser)
-			r.Post("/bulk", h.BulkCreateImpersonationUsers)
-			r.Get("/", h.ListImpersonationUsers)
+			r.With(admin).Post("/", h.CreateImpersonationUser)
+			r.With(admin).Post("/bulk", h.BulkCreateImpersonationUsers)
+			r.With(viewer).Get("/", h.ListImpersonationUsers)
 
 			r.Route("/{userID}", func(r chi.Router) {
-				r.Get("/", h.GetImpersonationUser)
-				r.Put("/", h.UpdateImpersonationUser)
-				r.Delete("/", h.DeleteImpersonationUser)
+				r.With(viewer).Get("/", h.GetImpersonationUser)
+				r.With(admin).Put("/", h.UpdateImpersonationUser)
+				r.With(admin).Delete("/", h.DeleteImpersonationUser)
+			})
+		})
+
+		// Lab management
+		r.Route("/labs", func(r chi.Router) {
+			r.With(viewer).Get("/", h.ListLabs)
+			r.With(admin).Post("/", h.CreateLab)
+
+			r.Route("/{labID}", func(r chi.Router) {
+				r.With(viewer).Get("/", h.GetLab)
+				r.With(admin).Put("/", h.UpdateLab)
+				r.With(admin).Delete("/", h.DeleteLab)
+			})
+		})
+
+		// Webhook subscriptions (URLs may carry credentials, so admin only)
+		r.Route("/webhooks", func(r chi.Router) {
+			r.Use(admin)
+			r.Get("/", h.ListWebhooks)
+			r.Post("/", h.CreateWebhook)
+
+			r.Route("/{webhookID}", func(r chi.Router) {
+				r.Get("/", h.GetWebhook)
+				r.Put("/", h.UpdateWebhook)
+				r.Delete("/", h.DeleteWebhook)
+				r.Get("/deliveries", h.ListWebhookDeliveries)
+				r.Post("/test", h.TestWebhook)
+			})
+		})
+
+		// Outbox of scoring and messenger events
+		r.Route("/outbox", func(r chi.Router) {
+			r.Use(admin)
+			r.Get("/", h.ListOutbox)
+			r.Post("/replay", h.ReplayOutbox)
+
+			r.Route("/{entryID}", func(r chi.Router) {
+				r.Get("/", h.GetOutboxEntry)
+				r.Post("/replay", h.ReplayOutboxEntry)
+			})
+		})
+
+		// Audit log
+		r.Route("/audit", func(r chi.Router) {
+			r.Use(admin)
+			r.Get("/", h.ListAudit)
+			r.Get("/export", h.ExportAudit)
+		})
+
+		// Database backup and state export/import
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(admin)
+			r.Get("/backup", h.BackupDatabase)
+			r.Get("/export", h.ExportState)
+			r.Post("/import", h.ImportState)
+		})
+
+		// Authentication and API key management
+		r.Route("/auth", func(r chi.Router) {
+			r.With(viewer).Get("/whoami", h.WhoAmI)
+
+			r.Route("/keys", func(r chi.Router) {
+				r.Use(admin)
+				r.Get("/", h.ListAPIKeys)
+				r.Post("/", h.CreateAPIKey)
+				r.Delete("/{keyID}", h.RevokeAPIKey)
 			})
 		})
 	})
@@ -138,6 +253,11 @@ func New(cfg Config, deps Dependencies, logger zerolog.Logger) *Server {
 	router.Get("/health", h.HealthCheck)
 	router.Get("/ready", h.ReadyCheck)
 
+	// Prometheus metrics
+	if deps.Metrics != nil {
+		router.With(viewer).Method(http.MethodGet, "/metrics", deps.Metrics.Handler())
+	}
+
 	// Agent binary downloads
 	if cfg.DownloadsDir != "" {
 		fileServer := http.FileServer(http.Dir(cfg.DownloadsDir))
@@ -193,6 +313,31 @@ func (s *Server) Router() chi.Router {
 	return s.router
 }
 
+// timeoutMiddleware applies middleware.Timeout to every request except
+// WebSocket upgrades and event streams, which are long-lived.
+func timeoutMiddleware(timeout time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		withTimeout := middleware.Timeout(timeout)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+				strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			withTimeout.ServeHTTP(w, r)
+		})
+	}
+}
+
+// agentActor attributes storage writes on agent routes to the agent in the
+// URL. Operator routes below it override this with the API key's actor.
+func agentActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := storage.WithActor(r.Context(), storage.AgentActor(chi.URLParam(r, "agentID")))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 // requestLogger returns a middleware that logs requests.
 func requestLogger(logger zerolog.Logger) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -229,18 +374,34 @@ func requestLogger(logger zerolog.Logger) func(next http.Handler) http.Handler {
 	}
 }
 
-// corsMiddleware adds CORS headers for development and cross-origin requests.
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-Request-ID")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+// corsMiddleware adds CORS headers for the configured allowed origins.
+func corsMiddleware(allowedOrigins []string) func(next http.Handler) http.Handler {
+	allowAll := false
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin == "*" {
+			allowAll = true
 		}
+		allowed[origin] = true
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin != "" && (allowAll || allowed[origin]) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-API-Key, X-Request-ID")
+			}
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+	"cymbytes.com/cymconductor/internal/orchestrator/registry"
+	"cymbytes.com/cymconductor/internal/orchestrator/scheduler"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// TestRouter_MatchesEndpoints keeps the OpenAPI description in step with the
+// routes the server registers.
+func TestRouter_MatchesEndpoints(t *testing.T) {
+	logger := zerolog.Nop()
+	db := storage.NewMemory(logger)
+	cfg := DefaultConfig()
+	cfg.WebDir = "/srv/web"
+	server := New(cfg, Dependencies{
+		DB:        db,
+		Registry:  registry.New(db, registry.DefaultConfig(), logger),
+		Scheduler: scheduler.New(db, scheduler.DefaultConfig(), logger),
+		Metrics:   metrics.New(),
+		StartTime: time.Now(),
+	}, logger)
+
+	routes := map[string]bool{}
+	err := chi.Walk(server.Router(), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		// Agent downloads and the dashboard are static files, not API
+		// operations
+		if strings.HasSuffix(route, "/*") {
+			return nil
+		}
+		if route != "/" {
+			route = strings.TrimSuffix(route, "/")
+		}
+		routes[method+" "+route] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("chi.Walk() error = %v", err)
+	}
+
+	documented := map[string]bool{}
+	for _, ep := range protocol.Endpoints {
+		key := ep.Method + " " + ep.Path
+		if documented[key] {
+			t.Errorf("%s is listed in protocol.Endpoints more than once", key)
+		}
+		documented[key] = true
+	}
+
+	var undocumented, unrouted []string
+	for route := range routes {
+		if !documented[route] {
+			undocumented = append(undocumented, route)
+		}
+	}
+	for ep := range documented {
+		if !routes[ep] {
+			unrouted = append(unrouted, ep)
+		}
+	}
+	sort.Strings(undocumented)
+	sort.Strings(unrouted)
+	if len(undocumented) > 0 {
+		t.Errorf("Routes missing from protocol.Endpoints: %v", undocumented)
+	}
+	if len(unrouted) > 0 {
+		t.Errorf("protocol.Endpoints without a route: %v", unrouted)
+	}
+}
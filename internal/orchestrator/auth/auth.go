@@ -0,0 +1,305 @@
+// Package auth provides API key authentication and role-based access control
+// for the orchestrator REST API.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// Role is an operator role. Roles are hierarchical: admin > operator > viewer.
+type Role string
+
+const (
+	// RoleViewer can read agents, scenarios, jobs and users.
+	RoleViewer Role = "viewer"
+	// RoleOperator can additionally create and delete scenarios.
+	RoleOperator Role = "operator"
+	// RoleAdmin can additionally manage users, agents, API keys and debug endpoints.
+	RoleAdmin Role = "admin"
+)
+
+// KeyPrefix is prepended to every generated API key.
+const KeyPrefix = "cyk_"
+
+// AnonymousActor is recorded as the actor when authentication is disabled.
+const AnonymousActor = "anonymous"
+
+// HeaderAPIKey is the header carrying an API key. Keys may also be sent as
+// "Authorization: Bearer <key>".
+const HeaderAPIKey = "X-API-Key"
+
+var roleRank = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ParseRole converts a string into a Role.
+func ParseRole(s string) (Role, bool) {
+	r := Role(strings.ToLower(strings.TrimSpace(s)))
+	_, ok := roleRank[r]
+	return r, ok
+}
+
+// Allows reports whether r grants at least the required role.
+func (r Role) Allows(required Role) bool {
+	return roleRank[r] >= roleRank[required]
+}
+
+// Principal identifies the caller of an authenticated request.
+type Principal struct {
+	KeyID string
+	Name  string
+	Role  Role
+}
+
+// Actor returns the identifier recorded in the audit log.
+func (p *Principal) Actor() string {
+	if p.KeyID == "" {
+		return p.Name
+	}
+	return "key:" + p.Name
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a context carrying the principal. Storage writes made
+// with the context are audited as the principal's actor.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	ctx = storage.WithActor(ctx, p.Actor())
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// PrincipalFromContext returns the principal of the request, or nil.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
+
+// ActorFromContext returns the audit actor for the request.
+func ActorFromContext(ctx context.Context) string {
+	if p := PrincipalFromContext(ctx); p != nil {
+		return p.Actor()
+	}
+	return AnonymousActor
+}
+
+// HashKey returns the SHA256 hex digest of an API key.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateKey creates a new random API key and returns it with its display prefix.
+func GenerateKey() (key, prefix string, err error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	key = KeyPrefix + hex.EncodeToString(buf)
+	return key, displayPrefix(key), nil
+}
+
+func displayPrefix(key string) string {
+	if len(key) <= len(KeyPrefix)+8 {
+		return key
+	}
+	return key[:len(KeyPrefix)+8]
+}
+
+// Config holds authentication configuration.
+type Config struct {
+	// Enabled turns on API key enforcement. When disabled, every request is
+	// treated as an anonymous admin (but still audited).
+	Enabled bool
+}
+
+// Authenticator resolves API keys and enforces roles.
+type Authenticator struct {
+	db      storage.Store
+	enabled bool
+	logger  zerolog.Logger
+}
+
+// New creates a new Authenticator.
+func New(db storage.Store, cfg Config, logger zerolog.Logger) *Authenticator {
+	return &Authenticator{
+		db:      db,
+		enabled: cfg.Enabled,
+		logger:  logger.With().Str("component", "auth").Logger(),
+	}
+}
+
+// Enabled reports whether API key enforcement is on.
+func (a *Authenticator) Enabled() bool {
+	return a.enabled
+}
+
+// Require returns middleware that authenticates the request, rejects callers
+// below the required role and records mutating requests in the audit log.
+func (a *Authenticator) Require(role Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := a.authenticate(r)
+			if err != nil {
+				a.logger.Error().Err(err).Msg("Failed to authenticate request")
+				writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to authenticate request")
+				return
+			}
+
+			if principal == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cymconductor"`)
+				writeError(w, r, http.StatusUnauthorized, "unauthorized", "A valid API key is required")
+				return
+			}
+
+			if !principal.Role.Allows(role) {
+				writeError(w, r, http.StatusForbidden, "forbidden", fmt.Sprintf("Role %q is required", role))
+				return
+			}
+
+			r = r.WithContext(WithPrincipal(r.Context(), principal))
+
+			if !isMutating(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+			a.audit(r, principal, ww.Status())
+		})
+	}
+}
+
+// authenticate resolves the principal for a request. It returns nil when no
+// valid credentials were presented and authentication is enabled.
+func (a *Authenticator) authenticate(r *http.Request) (*Principal, error) {
+	key := extractKey(r)
+
+	if key != "" {
+		apiKey, err := a.db.GetAPIKeyByHash(r.Context(), HashKey(key))
+		if err != nil {
+			return nil, err
+		}
+		if apiKey != nil && apiKey.IsActive(time.Now().UTC()) {
+			if err := a.db.TouchAPIKey(r.Context(), apiKey.ID); err != nil {
+				a.logger.Warn().Err(err).Str("key_id", apiKey.ID).Msg("Failed to record API key usage")
+			}
+			return &Principal{KeyID: apiKey.ID, Name: apiKey.Name, Role: Role(apiKey.Role)}, nil
+		}
+		if a.enabled {
+			a.logger.Warn().Str("remote", r.RemoteAddr).Msg("Rejected invalid or revoked API key")
+			return nil, nil
+		}
+	}
+
+	if !a.enabled {
+		return &Principal{Name: AnonymousActor, Role: RoleAdmin}, nil
+	}
+
+	return nil, nil
+}
+
+// audit records a completed mutating request.
+func (a *Authenticator) audit(r *http.Request, principal *Principal, status int) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	route := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		route = rctx.RoutePattern()
+	}
+
+	entry := &storage.AuditEntry{
+		EntityType: storage.AuditEntityRequest,
+		EntityID:   middleware.GetReqID(r.Context()),
+		Action:     r.Method + " " + route,
+		Actor:      principal.Actor(),
+		Metadata: map[string]interface{}{
+			"path":   r.URL.Path,
+			"status": status,
+			"role":   string(principal.Role),
+			"remote": r.RemoteAddr,
+		},
+	}
+
+	// Use a fresh context so the entry is written even if the request was cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := a.db.CreateAuditEntry(ctx, entry); err != nil {
+		a.logger.Error().Err(err).Str("action", entry.Action).Msg("Failed to write audit entry")
+	}
+}
+
+// EnsureBootstrapKey stores the configured bootstrap key as an admin key if it
+// is not already present. It is a no-op for an empty key.
+func EnsureBootstrapKey(ctx context.Context, db storage.Store, key string) error {
+	if key == "" {
+		return nil
+	}
+
+	hash := HashKey(key)
+	existing, err := db.GetAPIKeyByHash(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return nil
+	}
+
+	createdBy := "config"
+	return db.CreateAPIKey(ctx, &storage.APIKey{
+		Name:      "bootstrap",
+		KeyPrefix: displayPrefix(key),
+		KeyHash:   hash,
+		Role:      string(RoleAdmin),
+		CreatedBy: &createdBy,
+	})
+}
+
+func extractKey(r *http.Request) string {
+	if key := r.Header.Get(HeaderAPIKey); key != "" {
+		return strings.TrimSpace(key)
+	}
+	authz := r.Header.Get("Authorization")
+	if len(authz) > 7 && strings.EqualFold(authz[:7], "bearer ") {
+		return strings.TrimSpace(authz[7:])
+	}
+	return ""
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(protocol.ErrorResponse{
+		Error:     code,
+		Message:   message,
+		RequestID: middleware.GetReqID(r.Context()),
+	})
+}
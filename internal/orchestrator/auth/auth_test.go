@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+)
+
+func TestWithPrincipal_SetsAuditActor(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *Principal
+		want      string
+	}{
+		{
+			name:      "API key",
+			principal: &Principal{KeyID: "k1", Name: "ops", Role: RoleOperator},
+			want:      "key:ops",
+		},
+		{
+			name:      "bootstrap admin",
+			principal: &Principal{Name: "admin", Role: RoleAdmin},
+			want:      "admin",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := WithPrincipal(context.Background(), tt.principal)
+			if got := ActorFromContext(ctx); got != tt.want {
+				t.Errorf("ActorFromContext() = %q, want %q", got, tt.want)
+			}
+			// Storage writes made with the context are audited as the principal
+			if got := storage.ActorFromContext(ctx); got != tt.want {
+				t.Errorf("storage.ActorFromContext() = %q, want %q", got, tt.want)
+			}
+			if PrincipalFromContext(ctx) != tt.principal {
+				t.Error("Expected the principal to be carried by the context")
+			}
+		})
+	}
+
+	if got := ActorFromContext(context.Background()); got != AnonymousActor {
+		t.Errorf("ActorFromContext() without a principal = %q, want %q", got, AnonymousActor)
+	}
+	if got := storage.ActorFromContext(context.Background()); got != storage.AuditActorSystem {
+		t.Errorf("storage.ActorFromContext() without an actor = %q, want %q", got, storage.AuditActorSystem)
+	}
+}
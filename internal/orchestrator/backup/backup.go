@@ -0,0 +1,344 @@
+// Package backup exports and imports the orchestrator state worth carrying
+// across range rebuilds: labs, impersonation users and validated scenarios.
+//
+// The export document (protocol.StateExport) uses the API's own request
+// shapes rather than database rows, so it does not depend on the schema
+// version: an import always writes through the storage layer of a database
+// that has already been migrated to the current schema. Byte-for-byte copies
+// of a SQLite database are taken with storage.DB.Backup instead.
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/internal/orchestrator/validator"
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// labIDPattern matches the lab ID slugs accepted by the labs API.
+var labIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// Export collects every lab, impersonation user and validated scenario.
+func Export(ctx context.Context, db storage.Store, version string) (*protocol.StateExport, error) {
+	doc := &protocol.StateExport{
+		Format:              protocol.StateExportFormat,
+		Version:             protocol.StateExportVersion,
+		ExportedAt:          time.Now().UTC(),
+		OrchestratorVersion: version,
+		Labs:                []protocol.CreateLabRequest{},
+		Users:               []protocol.CreateImpersonationUserRequest{},
+		Scenarios:           []protocol.ExportedScenario{},
+	}
+
+	labs, err := db.ListLabs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, lab := range labs {
+		doc.Labs = append(doc.Labs, protocol.CreateLabRequest{
+			ID:              lab.ID,
+			Name:            lab.Name,
+			Description:     lab.Description,
+			AllowedNetworks: lab.AllowedNetworks,
+			WebhookURL:      lab.WebhookURL,
+		})
+	}
+
+	users, err := db.ListImpersonationUsers(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		doc.Users = append(doc.Users, userToExport(user))
+	}
+
+	// LIMIT -1 lists every scenario
+	scenarios, err := db.ListScenarios(ctx, "", "", -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, scenario := range scenarios {
+		if scenario.ValidatedDSL == nil {
+			continue
+		}
+		exported := protocol.ExportedScenario{
+			ID:           scenario.ID,
+			LabID:        scenario.LabID,
+			Name:         scenario.Name,
+			Source:       scenario.Source,
+			Intent:       rawJSON(scenario.Intent),
+			ValidatedDSL: rawJSON(*scenario.ValidatedDSL),
+			CreatedAt:    scenario.CreatedAt,
+		}
+		if scenario.Description != nil {
+			exported.Description = *scenario.Description
+		}
+		doc.Scenarios = append(doc.Scenarios, exported)
+	}
+
+	return doc, nil
+}
+
+// ImportOptions controls how an import treats entries that already exist.
+type ImportOptions struct {
+	// Overwrite updates existing labs (by ID) and users (by lab and
+	// username) from the document; otherwise they are skipped. Existing
+	// scenarios (by ID) are always skipped.
+	Overwrite bool
+
+	// Validator, if set, re-validates each scenario's DSL against the
+	// current policy and its lab's networks; failing scenarios are rejected.
+	Validator *validator.Validator
+}
+
+// Import writes the entries of doc into db: labs first, then the users and
+// scenarios that belong to them. An entry that cannot be imported is reported
+// in the response and does not stop the rest. An error is returned only for
+// an unusable document or a database failure.
+func Import(ctx context.Context, db storage.Store, doc *protocol.StateExport, opts ImportOptions) (*protocol.ImportStateResponse, error) {
+	if doc.Format != protocol.StateExportFormat {
+		return nil, fmt.Errorf("not a state export (format %q)", doc.Format)
+	}
+	if doc.Version < 1 || doc.Version > protocol.StateExportVersion {
+		return nil, fmt.Errorf("unsupported state export version %d (supported: 1-%d)", doc.Version, protocol.StateExportVersion)
+	}
+
+	result := &protocol.ImportStateResponse{}
+	reject := func(format string, args ...interface{}) {
+		result.Errors = append(result.Errors, fmt.Sprintf(format, args...))
+	}
+
+	for _, req := range doc.Labs {
+		if !labIDPattern.MatchString(req.ID) || req.Name == "" {
+			reject("lab %q: a lowercase id slug and a name are required", req.ID)
+			continue
+		}
+		if !validCIDRs(req.AllowedNetworks) {
+			reject("lab %q: allowed_networks must contain valid CIDRs", req.ID)
+			continue
+		}
+
+		existing, err := db.GetLab(ctx, req.ID)
+		if err != nil {
+			return result, err
+		}
+		lab := &storage.Lab{
+			ID:              req.ID,
+			Name:            req.Name,
+			Description:     req.Description,
+			AllowedNetworks: req.AllowedNetworks,
+			WebhookURL:      req.WebhookURL,
+		}
+
+		switch {
+		case existing == nil:
+			if err := db.CreateLab(ctx, lab); err != nil {
+				return result, err
+			}
+			result.Labs.Created++
+		case opts.Overwrite:
+			if err := db.UpdateLab(ctx, lab); err != nil {
+				return result, err
+			}
+			result.Labs.Updated++
+		default:
+			result.Labs.Skipped++
+		}
+	}
+
+	for _, req := range doc.Users {
+		labID := req.LabID
+		if labID == "" {
+			labID = storage.DefaultLabID
+		}
+		if req.Username == "" || req.Domain == "" || req.SAMAccountName == "" {
+			reject("user %q: username, domain, and sam_account_name are required", req.Username)
+			continue
+		}
+		if lab, err := db.GetLab(ctx, labID); err != nil {
+			return result, err
+		} else if lab == nil {
+			reject("user %q: lab %q does not exist", req.Username, labID)
+			continue
+		}
+
+		existing, err := db.GetImpersonationUserByUsername(ctx, labID, req.Username)
+		if err != nil {
+			return result, err
+		}
+		user := userFromExport(req, labID)
+
+		switch {
+		case existing == nil:
+			if err := db.CreateImpersonationUser(ctx, user); err != nil {
+				return result, err
+			}
+			result.Users.Created++
+		case opts.Overwrite:
+			user.ID = existing.ID
+			if err := db.UpdateImpersonationUser(ctx, user); err != nil {
+				return result, err
+			}
+			result.Users.Updated++
+		default:
+			result.Users.Skipped++
+		}
+	}
+
+	for _, exported := range doc.Scenarios {
+		labID := exported.LabID
+		if labID == "" {
+			labID = storage.DefaultLabID
+		}
+		if exported.ID == "" || exported.Name == "" {
+			reject("scenario %q: an id and a name are required", exported.ID)
+			continue
+		}
+		if !json.Valid(exported.Intent) || !json.Valid(exported.ValidatedDSL) {
+			reject("scenario %q: intent and validated_dsl must be JSON documents", exported.ID)
+			continue
+		}
+		lab, err := db.GetLab(ctx, labID)
+		if err != nil {
+			return result, err
+		} else if lab == nil {
+			reject("scenario %q: lab %q does not exist", exported.ID, labID)
+			continue
+		}
+		if opts.Validator != nil {
+			if msg := validateDSL(opts.Validator, lab, exported.ValidatedDSL); msg != "" {
+				reject("scenario %q: %s", exported.ID, msg)
+				continue
+			}
+		}
+
+		existing, err := db.GetScenario(ctx, exported.ID)
+		if err != nil {
+			return result, err
+		}
+		if existing != nil {
+			result.Scenarios.Skipped++
+			continue
+		}
+
+		source := exported.Source
+		if source == "" {
+			source = storage.ScenarioSourceAPI
+		}
+		scenario := &storage.Scenario{
+			ID:     exported.ID,
+			LabID:  labID,
+			Name:   exported.Name,
+			Intent: string(exported.Intent),
+			Source: source,
+			Status: storage.ScenarioStatusPending,
+		}
+		if exported.Description != "" {
+			scenario.Description = &exported.Description
+		}
+
+		if err := db.CreateScenario(ctx, scenario); err != nil {
+			return result, err
+		}
+		if err := db.UpdateScenarioValidatedDSL(ctx, scenario.ID, string(exported.ValidatedDSL)); err != nil {
+			return result, err
+		}
+		result.Scenarios.Created++
+	}
+
+	return result, nil
+}
+
+// validateDSL re-validates a scenario's DSL for its lab and returns a
+// description of the first problem, or "" if it is valid.
+func validateDSL(v *validator.Validator, lab *storage.Lab, data json.RawMessage) string {
+	scoped, err := v.WithAllowedNetworks(lab.AllowedNetworks)
+	if err != nil {
+		return err.Error()
+	}
+	if _, result := scoped.ValidateScenarioJSON(data); !result.Valid {
+		first := result.Errors[0]
+		if first.Field == "" {
+			return "validated_dsl is invalid: " + first.Message
+		}
+		return fmt.Sprintf("validated_dsl is invalid: %s: %s", first.Field, first.Message)
+	}
+	return ""
+}
+
+func userToExport(user *storage.ImpersonationUser) protocol.CreateImpersonationUserRequest {
+	req := protocol.CreateImpersonationUserRequest{
+		LabID:          user.LabID,
+		Username:       user.Username,
+		Domain:         user.Domain,
+		SAMAccountName: user.SAMAccountName,
+		DisplayName:    user.DisplayName,
+		Department:     user.Department,
+		Title:          user.Title,
+		AllowedHosts:   user.AllowedHosts,
+	}
+	if user.Persona != nil {
+		req.Persona = &protocol.UserPersonaInput{
+			TypicalApps:  user.Persona.TypicalApps,
+			TypicalSites: user.Persona.TypicalSites,
+			FileTypes:    user.Persona.FileTypes,
+		}
+		if user.Persona.WorkHours != nil {
+			req.Persona.WorkHours = &protocol.WorkHoursInput{
+				Start: user.Persona.WorkHours.Start,
+				End:   user.Persona.WorkHours.End,
+			}
+		}
+	}
+	return req
+}
+
+func userFromExport(req protocol.CreateImpersonationUserRequest, labID string) *storage.ImpersonationUser {
+	user := &storage.ImpersonationUser{
+		LabID:          labID,
+		Username:       req.Username,
+		Domain:         req.Domain,
+		SAMAccountName: req.SAMAccountName,
+		DisplayName:    req.DisplayName,
+		Department:     req.Department,
+		Title:          req.Title,
+		AllowedHosts:   req.AllowedHosts,
+	}
+	if req.Persona != nil {
+		user.Persona = &storage.UserPersona{
+			TypicalApps:  req.Persona.TypicalApps,
+			TypicalSites: req.Persona.TypicalSites,
+			FileTypes:    req.Persona.FileTypes,
+		}
+		if req.Persona.WorkHours != nil {
+			user.Persona.WorkHours = &storage.WorkHours{
+				Start: req.Persona.WorkHours.Start,
+				End:   req.Persona.WorkHours.End,
+			}
+		}
+	}
+	return user
+}
+
+// rawJSON returns s as an embedded JSON document, or null if it is not JSON.
+func rawJSON(s string) json.RawMessage {
+	if !json.Valid([]byte(s)) {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(s)
+}
+
+func validCIDRs(cidrs []string) bool {
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"math/rand"
 	"strconv"
+	"strings"
 	"time"
 
 	"cymbytes.com/cymconductor/internal/orchestrator/registry"
@@ -38,10 +39,12 @@ func New(reg *registry.Registry, logger zerolog.Logger) *Compiler {
 	}
 }
 
-// Compile converts a validated scenario into concrete jobs for specific agents.
-func (c *Compiler) Compile(ctx context.Context, scenario *dsl.Scenario, labStartTime time.Time) (*CompileResult, error) {
+// Compile converts a validated scenario into concrete jobs for agents in the given lab.
+// Agents in other labs are never targeted.
+func (c *Compiler) Compile(ctx context.Context, scenario *dsl.Scenario, labID string, labStartTime time.Time) (*CompileResult, error) {
 	c.logger.Info().
 		Str("scenario_id", scenario.ID).
+		Str("lab_id", labID).
 		Str("scenario_name", scenario.Name).
 		Int("step_count", len(scenario.Steps)).
 		Msg("Compiling scenario")
@@ -52,14 +55,14 @@ func (c *Compiler) Compile(ctx context.Context, scenario *dsl.Scenario, labStart
 		Steps:      make([]*storage.ScenarioStep, 0),
 	}
 
-	// Get all online agents
-	agents, err := c.registry.GetOnlineAgents(ctx)
+	// Get all online agents in the lab
+	agents, err := c.registry.GetOnlineAgents(ctx, labID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get agents: %w", err)
 	}
 
 	if len(agents) == 0 {
-		return nil, fmt.Errorf("no online agents available")
+		return nil, fmt.Errorf("no online agents available in lab %s", labID)
 	}
 
 	c.logger.Debug().Int("agent_count", len(agents)).Msg("Found online agents")
@@ -92,6 +95,26 @@ func (c *Compiler) Compile(ctx context.Context, scenario *dsl.Scenario, labStart
 			continue
 		}
 
+		// Drop agents that cannot run the step's action
+		params := rawJSONToMap(step.Parameters)
+		matchingAgents, excluded := filterCapableAgents(matchingAgents, string(step.ActionType), params)
+		if len(matchingAgents) == 0 {
+			c.logger.Warn().
+				Str("step_id", step.ID).
+				Str("action", string(step.ActionType)).
+				Strs("excluded", excluded).
+				Msg("No matching agent can run step action")
+			result.Errors = append(result.Errors, fmt.Sprintf("Step %d: %d agent(s) match labels %v but none can run %s: %s",
+				step.Order, len(excluded), step.Target.Labels, step.ActionType, strings.Join(excluded, "; ")))
+			continue
+		}
+		if len(excluded) > 0 {
+			c.logger.Info().
+				Str("step_id", step.ID).
+				Strs("excluded", excluded).
+				Msg("Excluded agents that cannot run step action")
+		}
+
 		// Select target agents based on count
 		selectedAgents, err := c.selectTargetAgents(matchingAgents, step.Target.Count)
 		if err != nil {
@@ -118,6 +141,7 @@ func (c *Compiler) Compile(ctx context.Context, scenario *dsl.Scenario, labStart
 				ScenarioID:     &scenario.ID,
 				ScenarioStepID: &step.ID,
 				AgentID:        agent.ID,
+				LabID:          agent.LabID,
 				ActionType:     string(step.ActionType),
 				Parameters:     rawJSONToMap(step.Parameters),
 				Status:         storage.JobStatusPending,
@@ -125,6 +149,12 @@ func (c *Compiler) Compile(ctx context.Context, scenario *dsl.Scenario, labStart
 				ScheduledAt:    scheduledAt,
 				MaxRetries:     3,
 			}
+			if step.RunAs != nil {
+				job.RunAsUser = &step.RunAs.User
+				if step.RunAs.LogonType != "" {
+					job.RunAsLogonType = &step.RunAs.LogonType
+				}
+			}
 
 			result.Jobs = append(result.Jobs, job)
 
@@ -160,6 +190,23 @@ func (c *Compiler) findMatchingAgents(agents []*storage.Agent, labels map[string
 	return matching
 }
 
+// filterCapableAgents returns the agents that can run action with the backend
+// selected in params, and a "host: reason" entry for each agent left out.
+func filterCapableAgents(agents []*storage.Agent, action string, params map[string]interface{}) ([]*storage.Agent, []string) {
+	backend, _ := params["backend"].(string)
+
+	var capable []*storage.Agent
+	var excluded []string
+	for _, agent := range agents {
+		if err := agent.CanRun(action, backend); err != nil {
+			excluded = append(excluded, fmt.Sprintf("%s: %v", agent.LabHostID, err))
+			continue
+		}
+		capable = append(capable, agent)
+	}
+	return capable, excluded
+}
+
 // matchesLabels checks if agent labels satisfy the selector.
 func matchesLabels(agentLabels, selector map[string]string) bool {
 	for key, value := range selector {
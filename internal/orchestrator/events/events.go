@@ -0,0 +1,266 @@
+// Package events provides the orchestrator's in-process event bus.
+//
+// Components publish agent, job, scenario, objective and command events; consumers such
+// as the SSE endpoint subscribe with a filter. Recent events are kept in a ring
+// buffer so subscribers can resume from the last event ID they saw.
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Event types.
+const (
+	AgentOnline  = "agent.online"
+	AgentOffline = "agent.offline"
+
+	JobCreated        = "job.created"
+	JobAssigned       = "job.assigned"
+	JobCompleted      = "job.completed"
+	JobFailed         = "job.failed"
+	JobRetryScheduled = "job.retry_scheduled"
+	JobCancelled      = "job.cancelled"
+
+	ScenarioCreated       = "scenario.created"
+	ScenarioStarted       = "scenario.started"
+	ScenarioStatusChanged = "scenario.status_changed"
+	ScenarioCompleted     = "scenario.completed"
+	ScenarioDeleted       = "scenario.deleted"
+
+	ObjectivePassed = "objective.passed"
+	ObjectiveFailed = "objective.failed"
+
+	CommandSent  = "command.sent"
+	CommandAcked = "command.acked"
+)
+
+// Types lists every event type published on the bus.
+var Types = []string{
+	AgentOnline, AgentOffline,
+	JobCreated, JobAssigned, JobCompleted, JobFailed, JobRetryScheduled, JobCancelled,
+	ScenarioCreated, ScenarioStarted, ScenarioStatusChanged, ScenarioCompleted, ScenarioDeleted,
+	ObjectivePassed, ObjectiveFailed,
+	CommandSent, CommandAcked,
+}
+
+// KnownType reports whether t names an event type or a category of them
+// ("job" or "job.*") in Types.
+func KnownType(t string) bool {
+	category := strings.TrimSuffix(strings.TrimSuffix(t, "*"), ".")
+	for _, known := range Types {
+		if t == known || strings.HasPrefix(known, category+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is a single state change.
+type Event struct {
+	ID         uint64                 `json:"id"`
+	Type       string                 `json:"type"`
+	Time       time.Time              `json:"time"`
+	LabID      string                 `json:"lab_id,omitempty"`
+	AgentID    string                 `json:"agent_id,omitempty"`
+	ScenarioID string                 `json:"scenario_id,omitempty"`
+	JobID      string                 `json:"job_id,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+}
+
+// Filter selects events for a subscriber. Empty fields match everything.
+type Filter struct {
+	// Types are exact event types ("job.completed") or categories ("job" or "job.*")
+	Types      []string
+	LabID      string
+	AgentID    string
+	ScenarioID string
+}
+
+// Match reports whether the event passes the filter.
+func (f Filter) Match(e Event) bool {
+	if f.LabID != "" && e.LabID != f.LabID {
+		return false
+	}
+	if f.AgentID != "" && e.AgentID != f.AgentID {
+		return false
+	}
+	if f.ScenarioID != "" && e.ScenarioID != f.ScenarioID {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+
+	for _, t := range f.Types {
+		category := strings.TrimSuffix(strings.TrimSuffix(t, "*"), ".")
+		if t == e.Type || strings.HasPrefix(e.Type, category+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Config holds event bus configuration.
+type Config struct {
+	// BufferSize is how many recent events are kept for resuming subscribers
+	BufferSize int
+
+	// SubscriberBuffer is how many events may queue for a subscriber before
+	// it is dropped (it can then resume from its last event ID)
+	SubscriberBuffer int
+}
+
+// DefaultConfig returns sensible defaults.
+func DefaultConfig() Config {
+	return Config{
+		BufferSize:       1000,
+		SubscriberBuffer: 256,
+	}
+}
+
+// Bus fans events out to subscribers. A nil *Bus discards published events.
+type Bus struct {
+	mu     sync.Mutex
+	lastID uint64
+
+	// Ring buffer of recent events
+	ring  []Event
+	head  int // index of the oldest event
+	count int
+
+	subs             map[*Subscription]struct{}
+	subscriberBuffer int
+	logger           zerolog.Logger
+}
+
+// New creates a new event bus.
+func New(cfg Config, logger zerolog.Logger) *Bus {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = DefaultConfig().BufferSize
+	}
+	if cfg.SubscriberBuffer <= 0 {
+		cfg.SubscriberBuffer = DefaultConfig().SubscriberBuffer
+	}
+
+	return &Bus{
+		// Seed IDs from the clock so they keep increasing across restarts and a
+		// subscriber resuming with a pre-restart ID is told to resync.
+		lastID:           uint64(time.Now().UnixMilli()) * 1000,
+		ring:             make([]Event, cfg.BufferSize),
+		subs:             make(map[*Subscription]struct{}),
+		subscriberBuffer: cfg.SubscriberBuffer,
+		logger:           logger.With().Str("component", "events").Logger(),
+	}
+}
+
+// Publish assigns the event an ID and timestamp and delivers it to matching
+// subscribers. Subscribers that cannot keep up are dropped.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	// Append to the ring buffer, overwriting the oldest event when full
+	if b.count < len(b.ring) {
+		b.ring[(b.head+b.count)%len(b.ring)] = e
+		b.count++
+	} else {
+		b.ring[b.head] = e
+		b.head = (b.head + 1) % len(b.ring)
+	}
+
+	for sub := range b.subs {
+		if !sub.filter.Match(e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			b.logger.Warn().Uint64("event_id", e.ID).Msg("Dropping slow event subscriber")
+			b.removeLocked(sub)
+		}
+	}
+}
+
+// Subscribe registers a subscriber. If lastEventID is non-zero, buffered
+// events after it that match the filter are returned for replay; complete is
+// false when events after lastEventID are no longer buffered and the
+// subscriber should resynchronise its state.
+func (b *Bus) Subscribe(filter Filter, lastEventID uint64) (sub *Subscription, backlog []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &Subscription{
+		bus:     b,
+		filter:  filter,
+		ch:      make(chan Event, b.subscriberBuffer),
+		startID: b.lastID,
+	}
+	b.subs[sub] = struct{}{}
+
+	if lastEventID == 0 {
+		return sub, nil, true
+	}
+
+	// Replay is complete only if every event after lastEventID is still buffered
+	complete = lastEventID == b.lastID ||
+		(lastEventID < b.lastID && b.count > 0 && b.ring[b.head].ID <= lastEventID+1)
+
+	for i := 0; i < b.count; i++ {
+		e := b.ring[(b.head+i)%len(b.ring)]
+		if e.ID > lastEventID && filter.Match(e) {
+			backlog = append(backlog, e)
+		}
+	}
+
+	return sub, backlog, complete
+}
+
+// removeLocked unregisters a subscriber. b.mu must be held.
+func (b *Bus) removeLocked(sub *Subscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.ch)
+}
+
+// Subscription receives events matching its filter.
+type Subscription struct {
+	bus     *Bus
+	filter  Filter
+	ch      chan Event
+	startID uint64
+}
+
+// StartID returns the ID of the last event published before the subscription
+// started. Every later matching event is delivered on Events.
+func (s *Subscription) StartID() uint64 {
+	return s.startID
+}
+
+// Events returns the subscriber's channel. It is closed when the subscription
+// is closed or dropped for falling behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Close unregisters the subscription.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.removeLocked(s)
+}
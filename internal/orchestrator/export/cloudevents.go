@@ -0,0 +1,202 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+)
+
+// CloudEvents content modes.
+const (
+	ModeStructured = "structured"
+	ModeBinary     = "binary"
+)
+
+// CloudEvents defaults, used when a sink leaves them unset.
+const (
+	DefaultCloudEventsSource     = "/cymconductor/orchestrator"
+	DefaultCloudEventsTypePrefix = "com.cymbytes.cymconductor."
+)
+
+// cloudEventsSpecVersion is the CloudEvents version produced.
+const cloudEventsSpecVersion = "1.0"
+
+// CloudEventsConfig configures a CloudEvents HTTP sink.
+type CloudEventsConfig struct {
+	// URL events are POSTed to
+	URL string
+
+	// Mode is ModeStructured (the whole event as application/cloudevents+json)
+	// or ModeBinary (attributes in ce-* headers, data as the body); empty
+	// means structured
+	Mode string
+
+	// Source is the CloudEvents source attribute
+	Source string
+
+	// TypePrefix is prepended to the orchestrator event type to form the
+	// CloudEvents type attribute (e.g. "com.cymbytes.cymconductor.job.completed")
+	TypePrefix string
+
+	// Headers are added to every request, e.g. for authentication
+	Headers map[string]string
+}
+
+func (c CloudEventsConfig) validate() error {
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http(s) URL")
+	}
+	switch c.Mode {
+	case "", ModeStructured, ModeBinary:
+	default:
+		return fmt.Errorf("mode must be %s or %s (got %q)", ModeStructured, ModeBinary, c.Mode)
+	}
+	return nil
+}
+
+// CloudEvent is a CloudEvents 1.0 event in the JSON event format. The
+// orchestrator's lab, agent, scenario and job IDs are carried as extension
+// attributes.
+type CloudEvent struct {
+	SpecVersion     string                 `json:"specversion"`
+	ID              string                 `json:"id"`
+	Source          string                 `json:"source"`
+	Type            string                 `json:"type"`
+	Subject         string                 `json:"subject,omitempty"`
+	Time            string                 `json:"time"`
+	DataContentType string                 `json:"datacontenttype"`
+	LabID           string                 `json:"labid,omitempty"`
+	AgentID         string                 `json:"agentid,omitempty"`
+	ScenarioID      string                 `json:"scenarioid,omitempty"`
+	JobID           string                 `json:"jobid,omitempty"`
+	Data            map[string]interface{} `json:"data,omitempty"`
+}
+
+// NewCloudEvent converts an orchestrator event to a CloudEvent. The event ID
+// is kept as the CloudEvents id, so consumers can discard duplicates.
+func NewCloudEvent(e events.Event, source, typePrefix string) CloudEvent {
+	if source == "" {
+		source = DefaultCloudEventsSource
+	}
+	if typePrefix == "" {
+		typePrefix = DefaultCloudEventsTypePrefix
+	}
+	return CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              strconv.FormatUint(e.ID, 10),
+		Source:          source,
+		Type:            typePrefix + e.Type,
+		Subject:         subject(e),
+		Time:            e.Time.UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		LabID:           e.LabID,
+		AgentID:         e.AgentID,
+		ScenarioID:      e.ScenarioID,
+		JobID:           e.JobID,
+		Data:            e.Data,
+	}
+}
+
+// CloudEventsSink POSTs events to an HTTP endpoint as CloudEvents.
+type CloudEventsSink struct {
+	config     CloudEventsConfig
+	httpClient *http.Client
+}
+
+// NewCloudEventsSink creates a CloudEvents sink whose requests time out
+// after timeout.
+func NewCloudEventsSink(cfg CloudEventsConfig, timeout time.Duration) *CloudEventsSink {
+	return &CloudEventsSink{
+		config: cfg,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+// Send POSTs e and expects a 2xx response.
+func (s *CloudEventsSink) Send(ctx context.Context, e events.Event) error {
+	ce := NewCloudEvent(e, s.config.Source, s.config.TypePrefix)
+
+	req, err := s.newRequest(ctx, ce)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "CymConductor-Export/1.0")
+	for key, value := range s.config.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// newRequest encodes ce in the sink's content mode.
+func (s *CloudEventsSink) newRequest(ctx context.Context, ce CloudEvent) (*http.Request, error) {
+	if s.config.Mode != ModeBinary {
+		body, err := json.Marshal(ce)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal event: %w", err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/cloudevents+json; charset=utf-8")
+		return req, nil
+	}
+
+	data := ce.Data
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event data: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", ce.DataContentType)
+	for name, value := range map[string]string{
+		"ce-specversion": ce.SpecVersion,
+		"ce-id":          ce.ID,
+		"ce-source":      ce.Source,
+		"ce-type":        ce.Type,
+		"ce-subject":     ce.Subject,
+		"ce-time":        ce.Time,
+		"ce-labid":       ce.LabID,
+		"ce-agentid":     ce.AgentID,
+		"ce-scenarioid":  ce.ScenarioID,
+		"ce-jobid":       ce.JobID,
+	} {
+		if value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	return req, nil
+}
+
+// Close releases idle connections.
+func (s *CloudEventsSink) Close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,385 @@
+// Package export streams orchestrator events to external systems.
+//
+// The Exporter follows the event bus and hands each event to every sink whose
+// event types match it. Sinks encode events in a standard envelope, such as
+// CloudEvents over HTTP or RFC 5424 syslog, so SIEMs and brokers can ingest
+// the orchestrator's ground truth without a bespoke integration.
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+)
+
+// Sink types.
+const (
+	SinkCloudEvents = "cloudevents"
+	SinkSyslog      = "syslog"
+)
+
+// Sink delivers events to one external system.
+type Sink interface {
+	// Send delivers a single event. It is never called concurrently.
+	Send(ctx context.Context, e events.Event) error
+
+	// Close releases the sink's connections.
+	Close() error
+}
+
+// SinkConfig configures one sink. Fields that do not apply to the sink's
+// type are ignored.
+type SinkConfig struct {
+	// Name identifies the sink in logs and metrics
+	Name string
+
+	// Type is SinkCloudEvents or SinkSyslog
+	Type string
+
+	// EventTypes are exact event types ("job.completed") or categories
+	// ("job" or "job.*") to export; empty exports every event
+	EventTypes []string
+
+	// RetryCount is how many times a failed send is retried
+	RetryCount int
+
+	// RetryDelay is the delay before the first retry; it doubles after each
+	RetryDelay time.Duration
+
+	// Timeout bounds each send
+	Timeout time.Duration
+
+	CloudEvents CloudEventsConfig
+	Syslog      SyslogConfig
+}
+
+// Config holds exporter configuration.
+type Config struct {
+	Sinks []SinkConfig
+
+	// QueueSize is how many events may wait for a sink before new events for
+	// it are dropped
+	QueueSize int
+}
+
+// DefaultConfig returns sensible defaults.
+func DefaultConfig() Config {
+	return Config{
+		QueueSize: 1000,
+	}
+}
+
+// Validate checks every sink, reporting all problems.
+func (c Config) Validate() error {
+	var problems []string
+	names := map[string]bool{}
+	for i, sc := range c.Sinks {
+		label := fmt.Sprintf("sink %d", i+1)
+		if sc.Name != "" {
+			label = fmt.Sprintf("sink %q", sc.Name)
+		}
+		if err := sc.validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", label, err))
+		}
+		if sc.Name != "" && names[sc.Name] {
+			problems = append(problems, fmt.Sprintf("%s: name is used by another sink", label))
+		}
+		names[sc.Name] = true
+	}
+	if c.QueueSize < 0 {
+		problems = append(problems, "queue_size must not be negative")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (sc SinkConfig) validate() error {
+	if sc.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	for _, t := range sc.EventTypes {
+		if !events.KnownType(t) {
+			return fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	if sc.RetryCount < 0 || sc.RetryDelay < 0 || sc.Timeout < 0 {
+		return fmt.Errorf("retry_count, retry_delay and timeout must not be negative")
+	}
+
+	switch sc.Type {
+	case SinkCloudEvents:
+		return sc.CloudEvents.validate()
+	case SinkSyslog:
+		return sc.Syslog.validate()
+	default:
+		return fmt.Errorf("type must be %s or %s (got %q)", SinkCloudEvents, SinkSyslog, sc.Type)
+	}
+}
+
+// NewSink creates the sink described by sc.
+func NewSink(sc SinkConfig) (Sink, error) {
+	if err := sc.validate(); err != nil {
+		return nil, err
+	}
+	timeout := sc.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	switch sc.Type {
+	case SinkCloudEvents:
+		return NewCloudEventsSink(sc.CloudEvents, timeout), nil
+	default:
+		return NewSyslogSink(sc.Syslog, timeout)
+	}
+}
+
+// Exporter delivers events from the event bus to its sinks. Each sink has its
+// own queue and worker, so a slow or unreachable sink delays only its own
+// events; when its queue is full, new events for it are dropped and counted
+// as failed deliveries.
+type Exporter struct {
+	bus     *events.Bus
+	sinks   []*sinkWorker
+	logger  zerolog.Logger
+	metrics *metrics.Metrics
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// sinkWorker feeds one sink from its queue.
+type sinkWorker struct {
+	config SinkConfig
+	filter events.Filter
+	sink   Sink
+	queue  chan events.Event
+}
+
+// New creates an exporter for the configured sinks.
+func New(cfg Config, bus *events.Bus, logger zerolog.Logger) (*Exporter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultConfig().QueueSize
+	}
+
+	x := &Exporter{
+		bus:    bus,
+		logger: logger.With().Str("component", "exporter").Logger(),
+		stopCh: make(chan struct{}),
+	}
+	for _, sc := range cfg.Sinks {
+		sink, err := NewSink(sc)
+		if err != nil {
+			x.closeSinks()
+			return nil, fmt.Errorf("sink %q: %w", sc.Name, err)
+		}
+		x.addSink(sc, sink, cfg.QueueSize)
+	}
+	return x, nil
+}
+
+// addSink registers a sink with its own queue.
+func (x *Exporter) addSink(sc SinkConfig, sink Sink, queueSize int) {
+	x.sinks = append(x.sinks, &sinkWorker{
+		config: sc,
+		filter: events.Filter{Types: sc.EventTypes},
+		sink:   sink,
+		queue:  make(chan events.Event, queueSize),
+	})
+}
+
+// SetMetrics sets the metrics that deliveries and retries are recorded in.
+func (x *Exporter) SetMetrics(m *metrics.Metrics) {
+	x.metrics = m
+}
+
+// Start begins exporting events published from now on. It does nothing if no
+// sinks are configured.
+func (x *Exporter) Start(ctx context.Context) {
+	if len(x.sinks) == 0 {
+		return
+	}
+
+	names := make([]string, len(x.sinks))
+	for i, w := range x.sinks {
+		names[i] = w.config.Name
+	}
+	x.logger.Info().Strs("sinks", names).Msg("Starting event exporter")
+
+	// Subscribe before returning so no event published after Start is missed
+	sub, _, _ := x.bus.Subscribe(events.Filter{}, 0)
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-x.stopCh:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	for _, w := range x.sinks {
+		x.wg.Add(1)
+		go x.runSink(ctx, w)
+	}
+	x.wg.Add(1)
+	go x.run(ctx, sub)
+}
+
+// Stop halts exporting, abandoning queued events, and closes the sinks.
+func (x *Exporter) Stop() {
+	if len(x.sinks) == 0 {
+		return
+	}
+	x.logger.Info().Msg("Stopping event exporter")
+	close(x.stopCh)
+	x.wg.Wait()
+	x.closeSinks()
+}
+
+func (x *Exporter) closeSinks() {
+	for _, w := range x.sinks {
+		if err := w.sink.Close(); err != nil {
+			x.logger.Warn().Err(err).Str("sink", w.config.Name).Msg("Failed to close export sink")
+		}
+	}
+}
+
+// run reads the event bus until stopped. If the exporter is dropped by the
+// bus, it resubscribes from the last event it handled so buffered events are
+// not lost.
+func (x *Exporter) run(ctx context.Context, sub *events.Subscription) {
+	defer x.wg.Done()
+
+	lastID := sub.StartID()
+	var backlog []events.Event
+	for {
+		for _, e := range backlog {
+			x.enqueue(e)
+			lastID = e.ID
+		}
+
+		for dropped := false; !dropped; {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+				return
+			case e, ok := <-sub.Events():
+				if !ok {
+					dropped = true
+					break
+				}
+				x.enqueue(e)
+				lastID = e.ID
+			}
+		}
+
+		var complete bool
+		sub, backlog, complete = x.bus.Subscribe(events.Filter{}, lastID)
+		if !complete {
+			x.logger.Warn().Uint64("last_event_id", lastID).Msg("Event exporter fell behind; some events were not exported")
+		}
+	}
+}
+
+// enqueue queues e for every sink it matches without blocking.
+func (x *Exporter) enqueue(e events.Event) {
+	for _, w := range x.sinks {
+		if !w.filter.Match(e) {
+			continue
+		}
+		select {
+		case w.queue <- e:
+		default:
+			x.metrics.ForwarderDelivered(metrics.ForwarderExport, false)
+			x.logger.Warn().
+				Str("sink", w.config.Name).
+				Uint64("event_id", e.ID).
+				Str("event_type", e.Type).
+				Msg("Export queue full; dropping event")
+		}
+	}
+}
+
+// runSink sends queued events to one sink until stopped.
+func (x *Exporter) runSink(ctx context.Context, w *sinkWorker) {
+	defer x.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-w.queue:
+			x.send(ctx, w, e)
+		}
+	}
+}
+
+// send delivers e to a sink, retrying with a doubling backoff.
+func (x *Exporter) send(ctx context.Context, w *sinkWorker, e events.Event) {
+	delay := w.config.RetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+
+	var err error
+	for attempt := 0; attempt <= w.config.RetryCount; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay *= 2
+			x.metrics.ForwarderRetried(metrics.ForwarderExport)
+		}
+
+		if err = w.sink.Send(ctx, e); err == nil {
+			x.metrics.ForwarderDelivered(metrics.ForwarderExport, true)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		x.logger.Warn().
+			Err(err).
+			Int("attempt", attempt+1).
+			Str("sink", w.config.Name).
+			Str("event_type", e.Type).
+			Msg("Event export failed")
+	}
+
+	x.metrics.ForwarderDelivered(metrics.ForwarderExport, false)
+	x.logger.Error().
+		Err(err).
+		Str("sink", w.config.Name).
+		Uint64("event_id", e.ID).
+		Str("event_type", e.Type).
+		Msg("Event export failed after all retries")
+}
+
+// subject returns the most specific resource an event is about.
+func subject(e events.Event) string {
+	switch {
+	case e.JobID != "":
+		return e.JobID
+	case e.ScenarioID != "":
+		return e.ScenarioID
+	case e.AgentID != "":
+		return e.AgentID
+	default:
+		return e.LabID
+	}
+}
@@ -0,0 +1,160 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+)
+
+func TestEventExporter_CloudEventsAndSyslog(t *testing.T) {
+	received := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		received <- r
+	}))
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			// Octet-counting framing: "<length> <message>"
+			prefix, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			var n int
+			fmt.Sscanf(prefix, "%d", &n)
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(reader, msg); err != nil {
+				return
+			}
+			lines <- string(msg)
+		}
+	}()
+
+	bus := events.New(events.DefaultConfig(), zerolog.Nop())
+	exporter, err := New(Config{Sinks: []SinkConfig{
+		{
+			Name:        "structured",
+			Type:        SinkCloudEvents,
+			EventTypes:  []string{"job"},
+			CloudEvents: CloudEventsConfig{URL: server.URL + "/structured", Headers: map[string]string{"Authorization": "Bearer test"}},
+		},
+		{
+			Name:        "binary",
+			Type:        SinkCloudEvents,
+			EventTypes:  []string{events.AgentOnline},
+			CloudEvents: CloudEventsConfig{URL: server.URL + "/binary", Mode: ModeBinary},
+		},
+		{
+			Name:       "siem",
+			Type:       SinkSyslog,
+			EventTypes: []string{events.JobFailed},
+			Syslog:     SyslogConfig{Network: NetworkTCP, Address: listener.Addr().String(), Format: FormatCEF, Hostname: "orch"},
+		},
+	}}, bus, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+	exporter.Start(context.Background())
+	defer exporter.Stop()
+
+	bus.Publish(events.Event{Type: events.AgentOnline, AgentID: "agent-1", LabID: "lab-1"})
+	bus.Publish(events.Event{Type: events.JobFailed, JobID: "job-1", ScenarioID: "scn-1", AgentID: "agent-1",
+		Data: map[string]interface{}{"action_type": "create_file", "error": "a=b|c"}})
+
+	requests := map[string]*http.Request{}
+	for len(requests) < 2 {
+		select {
+		case r := <-received:
+			requests[r.URL.Path] = r
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for CloudEvents deliveries; got %v", requests)
+		}
+	}
+
+	// Structured mode carries every attribute in the JSON body
+	r := requests["/structured"]
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/cloudevents+json") || r.Header.Get("Authorization") != "Bearer test" {
+		t.Errorf("Unexpected structured headers: %v", r.Header)
+	}
+	var ce CloudEvent
+	if err := json.NewDecoder(r.Body).Decode(&ce); err != nil {
+		t.Fatalf("Failed to decode CloudEvent: %v", err)
+	}
+	if ce.SpecVersion != "1.0" || ce.Type != "com.cymbytes.cymconductor.job.failed" || ce.Source != DefaultCloudEventsSource ||
+		ce.Subject != "job-1" || ce.JobID != "job-1" || ce.ScenarioID != "scn-1" || ce.ID == "" || ce.Data["action_type"] != "create_file" {
+		t.Errorf("Unexpected structured CloudEvent: %+v", ce)
+	}
+
+	// Binary mode carries the attributes in headers and the data as the body
+	r = requests["/binary"]
+	if r.Header.Get("ce-specversion") != "1.0" || r.Header.Get("ce-type") != "com.cymbytes.cymconductor.agent.online" ||
+		r.Header.Get("ce-agentid") != "agent-1" || r.Header.Get("ce-labid") != "lab-1" || r.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected binary headers: %v", r.Header)
+	}
+
+	// Only the failed job reaches syslog, as a warning on local0 with CEF
+	select {
+	case line := <-lines:
+		if !strings.HasPrefix(line, "<132>1 ") || !strings.Contains(line, " orch cymconductor ") {
+			t.Errorf("Unexpected syslog header: %s", line)
+		}
+		if !strings.Contains(line, `[cymconductor@32473 id="`) || !strings.Contains(line, `job_id="job-1"`) {
+			t.Errorf("Expected structured data with the job ID: %s", line)
+		}
+		if !strings.Contains(line, "CEF:0|CymBytes|CymConductor|") || !strings.Contains(line, "|job.failed|Job failed|7|") ||
+			!strings.Contains(line, "cs3Label=job_id cs3=job-1") || !strings.Contains(line, "act=create_file") || !strings.Contains(line, `a\=b|c`) {
+			t.Errorf("Unexpected CEF record: %s", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the syslog message")
+	}
+	select {
+	case line := <-lines:
+		t.Errorf("Unexpected second syslog message: %s", line)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEventExporter_ValidatesSinks(t *testing.T) {
+	err := Config{Sinks: []SinkConfig{
+		{Name: "a", Type: SinkCloudEvents, CloudEvents: CloudEventsConfig{URL: "ftp://x"}},
+		{Name: "b", Type: SinkSyslog, EventTypes: []string{"nope"}, Syslog: SyslogConfig{Network: "udp", Address: "x:514"}},
+		{Name: "c", Type: SinkSyslog, Syslog: SyslogConfig{Network: "tcp", Address: "x:514", Facility: "local9"}},
+		{Name: "c", Type: "kafka"},
+	}}.Validate()
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+	for _, want := range []string{`sink "a": url`, `sink "b": unknown event type "nope"`, `unknown facility "local9"`, "type must be", "name is used by another sink"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %v", want, err)
+		}
+	}
+}
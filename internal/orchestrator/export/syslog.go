@@ -0,0 +1,383 @@
+package export
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+)
+
+// Syslog transports.
+const (
+	NetworkUDP = "udp"
+	NetworkTCP = "tcp"
+	NetworkTLS = "tls"
+)
+
+// Syslog message formats.
+const (
+	FormatJSON = "json"
+	FormatCEF  = "cef"
+)
+
+// DefaultSyslogAppName is the APP-NAME used when a sink leaves it unset.
+const DefaultSyslogAppName = "cymconductor"
+
+// sdID names the structured data element carrying the orchestrator IDs. 32473
+// is the private enterprise number reserved for documentation (RFC 5612).
+const sdID = "cymconductor@32473"
+
+// Syslog severities (RFC 5424 section 6.2.1).
+const (
+	severityWarning       = 4
+	severityNotice        = 5
+	severityInformational = 6
+)
+
+// facilities maps facility names to their RFC 5424 codes.
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogConfig configures a syslog sink.
+type SyslogConfig struct {
+	// Network is NetworkUDP, NetworkTCP or NetworkTLS
+	Network string
+
+	// Address is the collector's host:port
+	Address string
+
+	// Format of the message body: FormatJSON (the event as JSON) or FormatCEF
+	// (ArcSight Common Event Format); empty means JSON
+	Format string
+
+	// Facility name, e.g. "local0" (the default)
+	Facility string
+
+	// AppName is the APP-NAME header field
+	AppName string
+
+	// Hostname is the HOSTNAME header field; empty uses the machine's name
+	Hostname string
+
+	// CAFile verifies the collector's certificate for NetworkTLS; empty uses
+	// the system roots
+	CAFile string
+
+	// InsecureSkipVerify disables certificate verification for NetworkTLS
+	InsecureSkipVerify bool
+
+	// ProductVersion is the CEF device version
+	ProductVersion string
+}
+
+func (c SyslogConfig) validate() error {
+	switch c.Network {
+	case NetworkUDP, NetworkTCP, NetworkTLS:
+	default:
+		return fmt.Errorf("network must be %s, %s or %s (got %q)", NetworkUDP, NetworkTCP, NetworkTLS, c.Network)
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("address must be host:port (got %q)", c.Address)
+	}
+	switch c.Format {
+	case "", FormatJSON, FormatCEF:
+	default:
+		return fmt.Errorf("format must be %s or %s (got %q)", FormatJSON, FormatCEF, c.Format)
+	}
+	if _, ok := facilities[c.Facility]; c.Facility != "" && !ok {
+		return fmt.Errorf("unknown facility %q", c.Facility)
+	}
+	if c.CAFile != "" {
+		if c.Network != NetworkTLS {
+			return fmt.Errorf("ca_file requires the %s network", NetworkTLS)
+		}
+		if _, err := os.Stat(c.CAFile); err != nil {
+			return fmt.Errorf("ca_file: %v", err)
+		}
+	}
+	return nil
+}
+
+// SyslogSink writes events as RFC 5424 messages. UDP sends one message per
+// datagram; TCP and TLS use octet-counting framing (RFC 6587, RFC 5425) over
+// a connection that is reopened after an error.
+type SyslogSink struct {
+	config   SyslogConfig
+	timeout  time.Duration
+	hostname string
+	facility int
+	tls      *tls.Config
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogSink creates a syslog sink whose writes time out after timeout.
+// The connection is opened on the first send.
+func NewSyslogSink(cfg SyslogConfig, timeout time.Duration) (*SyslogSink, error) {
+	s := &SyslogSink{
+		config:   cfg,
+		timeout:  timeout,
+		hostname: cfg.Hostname,
+		facility: facilities["local0"],
+	}
+	if cfg.Facility != "" {
+		s.facility = facilities[cfg.Facility]
+	}
+	if s.config.AppName == "" {
+		s.config.AppName = DefaultSyslogAppName
+	}
+	if s.hostname == "" {
+		s.hostname, _ = os.Hostname()
+	}
+
+	if cfg.Network == NetworkTLS {
+		host, _, _ := net.SplitHostPort(cfg.Address)
+		s.tls = &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: cfg.InsecureSkipVerify,
+			MinVersion:         tls.VersionTLS12,
+		}
+		if cfg.CAFile != "" {
+			pem, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ca_file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("ca_file %q contains no certificates", cfg.CAFile)
+			}
+			s.tls.RootCAs = pool
+		}
+	}
+	return s, nil
+}
+
+// Send writes e as a single syslog message.
+func (s *SyslogSink) Send(ctx context.Context, e events.Event) error {
+	msg, err := s.Format(e)
+	if err != nil {
+		return err
+	}
+	if s.config.Network != NetworkUDP {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if s.conn, err = s.dial(ctx); err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", s.config.Address, err)
+		}
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("failed to write to %s: %w", s.config.Address, err)
+	}
+	return nil
+}
+
+func (s *SyslogSink) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.timeout}
+	switch s.config.Network {
+	case NetworkTLS:
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: s.tls}
+		return tlsDialer.DialContext(ctx, "tcp", s.config.Address)
+	default:
+		return dialer.DialContext(ctx, s.config.Network, s.config.Address)
+	}
+}
+
+// Close closes the connection to the collector.
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// Format renders e as an RFC 5424 message without transport framing. The
+// MSGID is the event type and the orchestrator IDs are carried as structured
+// data, so collectors can index them without parsing the body.
+func (s *SyslogSink) Format(e events.Event) (string, error) {
+	severity := eventSeverity(e.Type)
+
+	var body string
+	if s.config.Format == FormatCEF {
+		body = FormatCEFEvent(e, s.config.ProductVersion)
+	} else {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal event: %w", err)
+		}
+		body = string(data)
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		s.facility*8+severity,
+		e.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(s.hostname, 255),
+		headerField(s.config.AppName, 48),
+		os.Getpid(),
+		headerField(e.Type, 32),
+		structuredData(e),
+		body,
+	), nil
+}
+
+// eventSeverity grades an event: failures (of jobs or objectives) and lost
+// agents are warnings, retries and cancellations notices, and everything else
+// informational.
+func eventSeverity(eventType string) int {
+	switch eventType {
+	case events.JobFailed, events.AgentOffline, events.ObjectiveFailed:
+		return severityWarning
+	case events.JobRetryScheduled, events.JobCancelled, events.ScenarioDeleted:
+		return severityNotice
+	default:
+		return severityInformational
+	}
+}
+
+// headerField returns value as an RFC 5424 header field: printable ASCII
+// without spaces, truncated to max, or "-" when empty.
+func headerField(value string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, value)
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+// structuredData returns the SD-ELEMENT carrying the event's IDs.
+func structuredData(e events.Event) string {
+	params := []struct{ name, value string }{
+		{"id", strconv.FormatUint(e.ID, 10)},
+		{"lab_id", e.LabID},
+		{"agent_id", e.AgentID},
+		{"scenario_id", e.ScenarioID},
+		{"job_id", e.JobID},
+	}
+
+	var b strings.Builder
+	b.WriteString("[" + sdID)
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(p.value)
+		fmt.Fprintf(&b, ` %s="%s"`, p.name, value)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// ============================================================
+// Common Event Format
+// ============================================================
+
+// cefSeverity maps syslog severities to the CEF 0-10 scale.
+var cefSeverity = map[int]int{
+	severityWarning:       7,
+	severityNotice:        5,
+	severityInformational: 3,
+}
+
+// FormatCEFEvent renders e as a CEF:0 record. The event type is the signature
+// ID; the orchestrator IDs use the customer string extensions cs1-cs4, and
+// the remaining event data is appended as JSON in msg.
+func FormatCEFEvent(e events.Event, productVersion string) string {
+	if productVersion == "" {
+		productVersion = "1.0"
+	}
+
+	ext := []struct{ key, value string }{
+		{"rt", strconv.FormatInt(e.Time.UnixMilli(), 10)},
+		{"externalId", strconv.FormatUint(e.ID, 10)},
+		{"cs1Label", "lab_id"}, {"cs1", e.LabID},
+		{"cs2Label", "scenario_id"}, {"cs2", e.ScenarioID},
+		{"cs3Label", "job_id"}, {"cs3", e.JobID},
+		{"cs4Label", "agent_id"}, {"cs4", e.AgentID},
+	}
+	if v, ok := e.Data["action_type"].(string); ok {
+		ext = append(ext, struct{ key, value string }{"act", v})
+	}
+	if v, ok := e.Data["status"].(string); ok {
+		ext = append(ext, struct{ key, value string }{"outcome", v})
+	}
+	if len(e.Data) > 0 {
+		if data, err := json.Marshal(e.Data); err == nil {
+			ext = append(ext, struct{ key, value string }{"msg", string(data)})
+		}
+	}
+
+	var extension []string
+	for i := 0; i < len(ext); i++ {
+		kv := ext[i]
+		// Skip empty custom strings together with their labels
+		if strings.HasSuffix(kv.key, "Label") && i+1 < len(ext) && ext[i+1].value == "" {
+			i++
+			continue
+		}
+		if kv.value == "" {
+			continue
+		}
+		extension = append(extension, kv.key+"="+cefExtensionValue(kv.value))
+	}
+
+	return fmt.Sprintf("CEF:0|CymBytes|CymConductor|%s|%s|%s|%d|%s",
+		cefHeaderValue(productVersion),
+		cefHeaderValue(e.Type),
+		cefHeaderValue(cefName(e.Type)),
+		cefSeverity[eventSeverity(e.Type)],
+		strings.Join(extension, " "),
+	)
+}
+
+// cefName turns an event type into a readable name ("job.retry_scheduled"
+// becomes "Job retry scheduled").
+func cefName(eventType string) string {
+	name := strings.NewReplacer(".", " ", "_", " ").Replace(eventType)
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// cefHeaderValue escapes a CEF header field.
+func cefHeaderValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ").Replace(v)
+}
+
+// cefExtensionValue escapes a CEF extension value.
+func cefExtensionValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`).Replace(v)
+}
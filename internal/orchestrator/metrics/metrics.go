@@ -0,0 +1,364 @@
+// Package metrics provides Prometheus instrumentation for the orchestrator.
+//
+// Components record what happens as it happens (registrations, job
+// transitions, forwarder deliveries); nothing is read from the database when
+// /metrics is scraped except the connection pool statistics.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cymconductor"
+
+// Job transition statuses recorded by JobTransition.
+const (
+	JobCreated   = "created"
+	JobAssigned  = "assigned"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobRetried   = "retried"
+	JobCancelled = "cancelled"
+)
+
+// Forwarder names used as the "forwarder" label.
+const (
+	ForwarderScoring   = "scoring"
+	ForwarderMessenger = "messenger"
+	ForwarderWebhook   = "webhook"
+	ForwarderExport    = "export"
+)
+
+// Metrics holds the orchestrator's collectors. A nil *Metrics records nothing,
+// so components can be used without instrumentation (e.g. in tests).
+type Metrics struct {
+	registry *prometheus.Registry
+	agents   *agentCollector
+
+	jobs            *prometheus.CounterVec
+	dispatchLatency *prometheus.HistogramVec
+	jobDuration     *prometheus.HistogramVec
+	jobFailures     *prometheus.CounterVec
+	jobRetries      *prometheus.CounterVec
+
+	forwarderDeliveries *prometheus.CounterVec
+	forwarderRetries    *prometheus.CounterVec
+	outboxEntries       *prometheus.GaugeVec
+
+	retentionDeleted *prometheus.CounterVec
+}
+
+// New creates the orchestrator metrics and registers them, along with the Go
+// runtime and process collectors, on a dedicated registry.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		agents:   newAgentCollector(),
+
+		jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_total",
+			Help:      "Job state transitions by status and action type.",
+		}, []string{"status", "action_type"}),
+
+		dispatchLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_dispatch_latency_seconds",
+			Help:      "Time from a job's scheduled_at to its assignment to an agent.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+		}, []string{"action_type"}),
+
+		jobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_duration_seconds",
+			Help:      "Job execution duration reported by agents.",
+			Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800},
+		}, []string{"action_type", "status"}),
+
+		jobFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "job_failures_total",
+			Help:      "Failed job executions by action type and error code.",
+		}, []string{"action_type", "error_code"}),
+
+		jobRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "job_retries_total",
+			Help:      "Job retries scheduled by action type and error code.",
+		}, []string{"action_type", "error_code"}),
+
+		forwarderDeliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "forwarder_deliveries_total",
+			Help:      "Events delivered by the scoring, messenger and webhook subscription forwarders, by result.",
+		}, []string{"forwarder", "result"}),
+
+		forwarderRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "forwarder_retries_total",
+			Help:      "Delivery attempts retried by the scoring, messenger and webhook subscription forwarders.",
+		}, []string{"forwarder"}),
+
+		outboxEntries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "outbox_entries",
+			Help:      "Scoring and messenger events in the outbox, by status.",
+		}, []string{"status"}),
+
+		retentionDeleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retention_deleted_total",
+			Help:      "Rows deleted by retention policies, by entity.",
+		}, []string{"entity"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.agents,
+		m.jobs,
+		m.dispatchLatency,
+		m.jobDuration,
+		m.jobFailures,
+		m.jobRetries,
+		m.forwarderDeliveries,
+		m.forwarderRetries,
+		m.outboxEntries,
+		m.retentionDeleted,
+	)
+
+	return m
+}
+
+// RegisterDB exports the connection pool statistics (sql.DB.Stats) of db.
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler returns the /metrics HTTP handler.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ============================================================
+// Agents
+// ============================================================
+
+// SetAgent records an agent's status and labels.
+func (m *Metrics) SetAgent(agentID, status string, labels map[string]string) {
+	if m == nil {
+		return
+	}
+	m.agents.set(agentID, status, labels)
+}
+
+// SetAgentStatus updates the status of a recorded agent.
+func (m *Metrics) SetAgentStatus(agentID, status string) {
+	if m == nil {
+		return
+	}
+	m.agents.setStatus(agentID, status)
+}
+
+// RemoveAgent forgets a deleted agent.
+func (m *Metrics) RemoveAgent(agentID string) {
+	if m == nil {
+		return
+	}
+	m.agents.remove(agentID)
+}
+
+// ============================================================
+// Jobs
+// ============================================================
+
+// JobTransition counts n jobs of an action type reaching a status.
+func (m *Metrics) JobTransition(status, actionType string, n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.jobs.WithLabelValues(status, actionType).Add(float64(n))
+}
+
+// ObserveDispatch records how long a job waited between becoming due and
+// being assigned.
+func (m *Metrics) ObserveDispatch(actionType string, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	if latency < 0 {
+		latency = 0
+	}
+	m.dispatchLatency.WithLabelValues(actionType).Observe(latency.Seconds())
+}
+
+// ObserveJobDuration records a job's execution time.
+func (m *Metrics) ObserveJobDuration(actionType, status string, duration time.Duration) {
+	if m == nil || duration < 0 {
+		return
+	}
+	m.jobDuration.WithLabelValues(actionType, status).Observe(duration.Seconds())
+}
+
+// JobFailed counts a failed execution, and the retry if one was scheduled.
+func (m *Metrics) JobFailed(actionType, errorCode string, retryScheduled bool) {
+	if m == nil {
+		return
+	}
+	if errorCode == "" {
+		errorCode = "unknown"
+	}
+	m.jobFailures.WithLabelValues(actionType, errorCode).Inc()
+	if retryScheduled {
+		m.jobRetries.WithLabelValues(actionType, errorCode).Inc()
+	}
+}
+
+// ============================================================
+// Forwarders
+// ============================================================
+
+// ForwarderDelivered counts a delivery that eventually succeeded or failed.
+func (m *Metrics) ForwarderDelivered(forwarder string, success bool) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	m.forwarderDeliveries.WithLabelValues(forwarder, result).Inc()
+}
+
+// ForwarderRetried counts a retried delivery attempt.
+func (m *Metrics) ForwarderRetried(forwarder string) {
+	if m == nil {
+		return
+	}
+	m.forwarderRetries.WithLabelValues(forwarder).Inc()
+}
+
+// outboxStatuses are always exported, even when no entry has them.
+var outboxStatuses = []string{"pending", "delivered", "dead"}
+
+// SetOutboxEntries records the number of outbox entries by status.
+func (m *Metrics) SetOutboxEntries(counts map[string]int) {
+	if m == nil {
+		return
+	}
+	for _, status := range outboxStatuses {
+		m.outboxEntries.WithLabelValues(status).Set(float64(counts[status]))
+	}
+}
+
+// ============================================================
+// Retention
+// ============================================================
+
+// RetentionDeleted counts rows of an entity removed by retention policies.
+func (m *Metrics) RetentionDeleted(entity string, n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.retentionDeleted.WithLabelValues(entity).Add(float64(n))
+}
+
+// ============================================================
+// Agent collector
+// ============================================================
+
+// agentStatuses are always exported, even when no agent has them.
+var agentStatuses = []string{"online", "offline", "error"}
+
+type agentState struct {
+	status string
+	labels map[string]string
+}
+
+// agentCollector exports agent counts from the state recorded by the registry.
+type agentCollector struct {
+	mu     sync.Mutex
+	agents map[string]agentState
+
+	byStatus *prometheus.Desc
+	byLabel  *prometheus.Desc
+}
+
+func newAgentCollector() *agentCollector {
+	return &agentCollector{
+		agents: make(map[string]agentState),
+		byStatus: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "agents"),
+			"Registered agents by status.",
+			[]string{"status"}, nil,
+		),
+		byLabel: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "agents_by_label"),
+			"Registered agents by label and status.",
+			[]string{"label", "value", "status"}, nil,
+		),
+	}
+}
+
+func (c *agentCollector) set(agentID, status string, labels map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.agents[agentID] = agentState{status: status, labels: labels}
+}
+
+func (c *agentCollector) setStatus(agentID, status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state, ok := c.agents[agentID]; ok {
+		state.status = status
+		c.agents[agentID] = state
+	}
+}
+
+func (c *agentCollector) remove(agentID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.agents, agentID)
+}
+
+// Describe implements prometheus.Collector.
+func (c *agentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.byStatus
+	ch <- c.byLabel
+}
+
+// Collect implements prometheus.Collector.
+func (c *agentCollector) Collect(ch chan<- prometheus.Metric) {
+	type labelKey struct{ label, value, status string }
+
+	c.mu.Lock()
+	byStatus := make(map[string]int, len(agentStatuses))
+	for _, status := range agentStatuses {
+		byStatus[status] = 0
+	}
+	byLabel := make(map[labelKey]int)
+	for _, state := range c.agents {
+		byStatus[state.status]++
+		for label, value := range state.labels {
+			byLabel[labelKey{label, value, state.status}]++
+		}
+	}
+	c.mu.Unlock()
+
+	for status, n := range byStatus {
+		ch <- prometheus.MustNewConstMetric(c.byStatus, prometheus.GaugeValue, float64(n), status)
+	}
+	for key, n := range byLabel {
+		ch <- prometheus.MustNewConstMetric(c.byLabel, prometheus.GaugeValue, float64(n), key.label, key.value, key.status)
+	}
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// scrape returns the metrics exposition served by m.
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	return w.Body.String()
+}
+
+// expectMetrics checks that body has every line in want and none in missing.
+func expectMetrics(t *testing.T, body string, want, missing []string) {
+	t.Helper()
+
+	for _, line := range want {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected metrics output to contain %q", line)
+		}
+	}
+	for _, line := range missing {
+		if strings.Contains(body, line) {
+			t.Errorf("Expected metrics output not to contain %q", line)
+		}
+	}
+}
+
+func TestMetrics_Agents(t *testing.T) {
+	m := New()
+
+	// Every status is exported before any agent registers
+	expectMetrics(t, scrape(t, m), []string{
+		`cymconductor_agents{status="online"} 0`,
+		`cymconductor_agents{status="offline"} 0`,
+		`cymconductor_agents{status="error"} 0`,
+	}, nil)
+
+	m.SetAgent("agent-1", "online", map[string]string{"role": "workstation"})
+	m.SetAgent("agent-2", "online", map[string]string{"role": "workstation", "os": "windows"})
+	m.SetAgentStatus("agent-2", "offline")
+	m.SetAgentStatus("agent-unknown", "offline")
+	expectMetrics(t, scrape(t, m), []string{
+		`cymconductor_agents{status="online"} 1`,
+		`cymconductor_agents{status="offline"} 1`,
+		`cymconductor_agents_by_label{label="role",status="online",value="workstation"} 1`,
+		`cymconductor_agents_by_label{label="role",status="offline",value="workstation"} 1`,
+		`cymconductor_agents_by_label{label="os",status="offline",value="windows"} 1`,
+	}, nil)
+
+	m.RemoveAgent("agent-2")
+	expectMetrics(t, scrape(t, m), []string{
+		`cymconductor_agents{status="offline"} 0`,
+	}, []string{
+		`value="windows"`,
+	})
+}
+
+func TestMetrics_Jobs(t *testing.T) {
+	m := New()
+
+	m.JobTransition(JobCreated, "simulate_browsing", 3)
+	m.JobTransition(JobCancelled, "simulate_browsing", 0)
+	m.ObserveDispatch("simulate_browsing", -time.Second)
+	m.ObserveJobDuration("simulate_browsing", JobCompleted, 2*time.Second)
+	m.ObserveJobDuration("simulate_browsing", JobFailed, -time.Second)
+	m.JobFailed("simulate_browsing", "TIMEOUT", true)
+	m.JobFailed("simulate_browsing", "", false)
+
+	expectMetrics(t, scrape(t, m), []string{
+		`cymconductor_jobs_total{action_type="simulate_browsing",status="created"} 3`,
+		// A job dispatched before it was due counts as dispatched at once
+		`cymconductor_job_dispatch_latency_seconds_bucket{action_type="simulate_browsing",le="0.05"} 1`,
+		`cymconductor_job_duration_seconds_count{action_type="simulate_browsing",status="completed"} 1`,
+		`cymconductor_job_duration_seconds_sum{action_type="simulate_browsing",status="completed"} 2`,
+		`cymconductor_job_failures_total{action_type="simulate_browsing",error_code="TIMEOUT"} 1`,
+		`cymconductor_job_failures_total{action_type="simulate_browsing",error_code="unknown"} 1`,
+		`cymconductor_job_retries_total{action_type="simulate_browsing",error_code="TIMEOUT"} 1`,
+	}, []string{
+		`status="cancelled"`,
+		`status="failed"`,
+		`cymconductor_job_retries_total{action_type="simulate_browsing",error_code="unknown"}`,
+	})
+}
+
+func TestMetrics_ForwardersOutboxAndRetention(t *testing.T) {
+	m := New()
+
+	m.ForwarderDelivered(ForwarderScoring, true)
+	m.ForwarderDelivered(ForwarderScoring, false)
+	m.ForwarderRetried(ForwarderMessenger)
+	m.SetOutboxEntries(map[string]int{"pending": 4, "dead": 1})
+	m.RetentionDeleted("jobs", 25)
+	m.RetentionDeleted("audit_log", 0)
+
+	expectMetrics(t, scrape(t, m), []string{
+		`cymconductor_forwarder_deliveries_total{forwarder="scoring",result="success"} 1`,
+		`cymconductor_forwarder_deliveries_total{forwarder="scoring",result="failure"} 1`,
+		`cymconductor_forwarder_retries_total{forwarder="messenger"} 1`,
+		`cymconductor_outbox_entries{status="pending"} 4`,
+		`cymconductor_outbox_entries{status="delivered"} 0`,
+		`cymconductor_outbox_entries{status="dead"} 1`,
+		`cymconductor_retention_deleted_total{entity="jobs"} 25`,
+	}, []string{
+		`entity="audit_log"`,
+	})
+
+	// A later count replaces the previous one
+	m.SetOutboxEntries(map[string]int{"delivered": 2})
+	expectMetrics(t, scrape(t, m), []string{
+		`cymconductor_outbox_entries{status="pending"} 0`,
+		`cymconductor_outbox_entries{status="delivered"} 2`,
+	}, nil)
+}
+
+func TestMetrics_RegisterDB(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m := New()
+	m.RegisterDB(db, "orchestrator")
+	expectMetrics(t, scrape(t, m), []string{
+		`go_sql_open_connections{db_name="orchestrator"} 0`,
+	}, nil)
+}
+
+func TestMetrics_NilRecordsNothing(t *testing.T) {
+	var m *Metrics
+
+	// None of these may panic
+	m.RegisterDB(nil, "orchestrator")
+	m.SetAgent("agent-1", "online", nil)
+	m.SetAgentStatus("agent-1", "offline")
+	m.RemoveAgent("agent-1")
+	m.JobTransition(JobCreated, "simulate_browsing", 1)
+	m.ObserveDispatch("simulate_browsing", time.Second)
+	m.ObserveJobDuration("simulate_browsing", JobCompleted, time.Second)
+	m.JobFailed("simulate_browsing", "TIMEOUT", true)
+	m.ForwarderDelivered(ForwarderWebhook, true)
+	m.ForwarderRetried(ForwarderWebhook)
+	m.SetOutboxEntries(map[string]int{"pending": 1})
+	m.RetentionDeleted("jobs", 1)
+}
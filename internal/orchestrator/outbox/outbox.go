@@ -0,0 +1,286 @@
+// Package outbox delivers the events the scheduler writes to the
+// transactional outbox.
+//
+// Scoring and messenger events are stored in the same transaction as the job
+// or scenario update that produced them, so they survive restarts and target
+// outages. A background worker drains due entries, backing off exponentially
+// after each failed attempt, and dead-letters entries that exhaust their
+// attempts. Dead entries stay in the outbox until an operator replays them.
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+)
+
+// Sender delivers entries for one target.
+type Sender interface {
+	// IsEnabled reports whether the target is configured to receive events
+	IsEnabled() bool
+
+	// Deliver sends an entry's payload to its destination
+	Deliver(ctx context.Context, entry *storage.OutboxEntry) error
+}
+
+// Config holds outbox worker configuration.
+type Config struct {
+	// PollInterval is how often to look for due entries
+	PollInterval time.Duration
+
+	// BatchSize is the most entries handled per poll
+	BatchSize int
+
+	// MaxAttempts is how many attempts an entry gets before it is dead-lettered
+	MaxAttempts int
+
+	// InitialBackoff is the delay after the first failed attempt; it doubles
+	// after each further failure
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the delay between attempts
+	MaxBackoff time.Duration
+}
+
+// DefaultConfig returns sensible defaults. An entry is retried for a little
+// over an hour before it is dead-lettered.
+func DefaultConfig() Config {
+	return Config{
+		PollInterval:   2 * time.Second,
+		BatchSize:      50,
+		MaxAttempts:    12,
+		InitialBackoff: 5 * time.Second,
+		MaxBackoff:     15 * time.Minute,
+	}
+}
+
+// Worker drains the outbox.
+type Worker struct {
+	db      storage.OutboxStore
+	config  Config
+	senders map[string]Sender
+	logger  zerolog.Logger
+	metrics *metrics.Metrics
+
+	// Background worker
+	wake   chan struct{}
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New creates an outbox worker.
+func New(db storage.OutboxStore, cfg Config, logger zerolog.Logger) *Worker {
+	defaults := DefaultConfig()
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaults.PollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaults.MaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaults.InitialBackoff
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = cfg.InitialBackoff
+	}
+
+	return &Worker{
+		db:      db,
+		config:  cfg,
+		senders: make(map[string]Sender),
+		logger:  logger.With().Str("component", "outbox").Logger(),
+		wake:    make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Register sets the sender for a target. It must be called before Start.
+func (w *Worker) Register(target string, sender Sender) {
+	w.senders[target] = sender
+}
+
+// SetMetrics sets the metrics that deliveries and retries are recorded in.
+func (w *Worker) SetMetrics(m *metrics.Metrics) {
+	w.metrics = m
+}
+
+// Start begins draining the outbox in the background.
+func (w *Worker) Start(ctx context.Context) {
+	w.logger.Info().
+		Dur("poll_interval", w.config.PollInterval).
+		Int("max_attempts", w.config.MaxAttempts).
+		Msg("Starting outbox worker")
+
+	w.wg.Add(1)
+	go w.run(ctx)
+}
+
+// Stop halts the worker and waits for the delivery in progress to finish.
+func (w *Worker) Stop() {
+	w.logger.Info().Msg("Stopping outbox worker")
+	close(w.stopCh)
+	w.wg.Wait()
+}
+
+// Notify wakes the worker so new entries are delivered without waiting for
+// the next poll.
+func (w *Worker) Notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run drains the outbox until stopped.
+func (w *Worker) run(ctx context.Context) {
+	defer w.wg.Done()
+
+	// Deliveries are cancelled when the worker stops
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-w.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		w.Drain(ctx)
+		w.refreshGauge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.wake:
+		}
+	}
+}
+
+// Drain makes one delivery attempt for each due entry and returns how many
+// were attempted. After a failure, the target's remaining entries wait for
+// the next poll so an unreachable target does not stall the others.
+func (w *Worker) Drain(ctx context.Context) int {
+	entries, err := w.db.ListDueOutboxEntries(ctx, time.Now().UTC(), w.config.BatchSize)
+	if err != nil {
+		w.logger.Error().Err(err).Msg("Failed to list due outbox entries")
+		return 0
+	}
+
+	// Outcomes are recorded even if the worker stops mid-delivery
+	recordCtx := context.WithoutCancel(ctx)
+
+	failing := make(map[string]bool)
+	attempted := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			break
+		}
+		if failing[entry.Target] {
+			continue
+		}
+
+		sender := w.senders[entry.Target]
+		if sender == nil || !sender.IsEnabled() {
+			// Hold the entry until the target is enabled again
+			entry.NextAttemptAt = time.Now().UTC().Add(w.config.MaxBackoff)
+			if err := w.db.UpdateOutboxEntry(recordCtx, entry); err != nil {
+				w.logger.Error().Err(err).Str("entry_id", entry.ID).Msg("Failed to defer outbox entry")
+			}
+			continue
+		}
+
+		err := sender.Deliver(ctx, entry)
+		if err != nil && ctx.Err() != nil {
+			// Interrupted by shutdown; the attempt is not counted
+			break
+		}
+
+		attempted++
+		w.record(recordCtx, entry, err)
+		if err != nil {
+			failing[entry.Target] = true
+		}
+	}
+
+	return attempted
+}
+
+// record stores the outcome of a delivery attempt.
+func (w *Worker) record(ctx context.Context, entry *storage.OutboxEntry, err error) {
+	now := time.Now().UTC()
+	entry.Attempts++
+
+	if err == nil {
+		entry.Status = storage.OutboxStatusDelivered
+		entry.DeliveredAt = &now
+		entry.LastError = nil
+		w.metrics.ForwarderDelivered(entry.Target, true)
+	} else {
+		msg := err.Error()
+		entry.LastError = &msg
+
+		if entry.Attempts >= w.config.MaxAttempts {
+			entry.Status = storage.OutboxStatusDead
+			w.metrics.ForwarderDelivered(entry.Target, false)
+			w.logger.Error().
+				Err(err).
+				Str("entry_id", entry.ID).
+				Str("event_id", entry.EventID).
+				Str("target", entry.Target).
+				Int("attempts", entry.Attempts).
+				Msg("Outbox entry dead-lettered after all attempts")
+		} else {
+			entry.NextAttemptAt = now.Add(w.backoff(entry.Attempts))
+			w.metrics.ForwarderRetried(entry.Target)
+			w.logger.Warn().
+				Err(err).
+				Str("entry_id", entry.ID).
+				Str("event_id", entry.EventID).
+				Str("target", entry.Target).
+				Int("attempts", entry.Attempts).
+				Time("next_attempt_at", entry.NextAttemptAt).
+				Msg("Outbox delivery failed; will retry")
+		}
+	}
+
+	if err := w.db.UpdateOutboxEntry(ctx, entry); err != nil {
+		w.logger.Error().Err(err).Str("entry_id", entry.ID).Msg("Failed to record outbox delivery")
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts.
+func (w *Worker) backoff(attempts int) time.Duration {
+	delay := w.config.InitialBackoff
+	for i := 1; i < attempts && delay < w.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, w.config.MaxBackoff)
+}
+
+// refreshGauge updates the outbox gauge. Delivered and dead entries are
+// removed by the retention janitor.
+func (w *Worker) refreshGauge(ctx context.Context) {
+	if w.metrics == nil {
+		return
+	}
+	counts, err := w.db.CountOutboxEntries(ctx)
+	if err != nil {
+		w.logger.Error().Err(err).Msg("Failed to count outbox entries")
+		return
+	}
+	w.metrics.SetOutboxEntries(counts)
+}
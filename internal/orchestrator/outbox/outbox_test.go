@@ -0,0 +1,197 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+)
+
+// fakeSender records the event IDs it is given and fails while err is set.
+type fakeSender struct {
+	mu       sync.Mutex
+	disabled bool
+	err      error
+	received []string
+}
+
+func (s *fakeSender) IsEnabled() bool {
+	return !s.disabled
+}
+
+func (s *fakeSender) Deliver(ctx context.Context, entry *storage.OutboxEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, entry.EventID)
+	return s.err
+}
+
+func (s *fakeSender) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// seedOutbox writes entries to db the way the scheduler does, alongside a
+// scenario update.
+func seedOutbox(t *testing.T, db storage.Store, target string, eventIDs ...string) {
+	t.Helper()
+	var entries []*storage.OutboxEntry
+	for _, eventID := range eventIDs {
+		entry, err := storage.NewOutboxEntry(target, "default", eventID, "scenario.started", map[string]string{"event_id": eventID})
+		if err != nil {
+			t.Fatalf("NewOutboxEntry() error = %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	scenarioID := fmt.Sprintf("scenario-%s-%s", target, eventIDs[0])
+	scenario := &storage.Scenario{ID: scenarioID, LabID: "default", Name: scenarioID, Intent: "{}", Source: storage.ScenarioSourceAPI}
+	if err := db.CreateActiveScenario(context.Background(), scenario, nil, nil, entries...); err != nil {
+		t.Fatalf("CreateActiveScenario() error = %v", err)
+	}
+}
+
+func outboxEntry(t *testing.T, db storage.Store, eventID string) *storage.OutboxEntry {
+	t.Helper()
+	entries, err := db.ListOutboxEntries(context.Background(), storage.OutboxFilter{})
+	if err != nil {
+		t.Fatalf("ListOutboxEntries() error = %v", err)
+	}
+	for _, entry := range entries {
+		if entry.EventID == eventID {
+			return entry
+		}
+	}
+	t.Fatalf("Outbox entry for event %s not found", eventID)
+	return nil
+}
+
+func TestWorker_Backoff(t *testing.T) {
+	w := New(storage.NewMemory(zerolog.Nop()), Config{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}, zerolog.Nop())
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := w.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestWorker_DeadLettersAndReplays(t *testing.T) {
+	ctx := context.Background()
+	db := storage.NewMemory(zerolog.Nop())
+	sender := &fakeSender{err: errors.New("service unavailable")}
+	w := New(db, Config{MaxAttempts: 2, InitialBackoff: time.Nanosecond}, zerolog.Nop())
+	w.Register(storage.OutboxTargetMessenger, sender)
+
+	seedOutbox(t, db, storage.OutboxTargetMessenger, "event-1")
+
+	// The first failure schedules a retry; the second dead-letters the entry
+	if n := w.Drain(ctx); n != 1 {
+		t.Fatalf("Expected 1 delivery attempt, got %d", n)
+	}
+	entry := outboxEntry(t, db, "event-1")
+	if entry.Status != storage.OutboxStatusPending || entry.Attempts != 1 || entry.LastError == nil {
+		t.Errorf("Expected a pending entry with one failed attempt, got %+v", entry)
+	}
+
+	time.Sleep(time.Millisecond)
+	if n := w.Drain(ctx); n != 1 {
+		t.Fatalf("Expected 1 delivery attempt, got %d", n)
+	}
+	if entry := outboxEntry(t, db, "event-1"); entry.Status != storage.OutboxStatusDead || entry.Attempts != 2 {
+		t.Fatalf("Expected a dead entry after 2 attempts, got %+v", entry)
+	}
+
+	// Dead entries are not retried
+	if n := w.Drain(ctx); n != 0 {
+		t.Errorf("Expected no attempts on a dead entry, got %d", n)
+	}
+
+	// A replay gets a fresh attempt budget and delivers under the same event ID
+	sender.setErr(nil)
+	if n, err := db.ReplayDeadOutboxEntries(ctx, storage.OutboxTargetMessenger); err != nil || n != 1 {
+		t.Fatalf("ReplayDeadOutboxEntries() = %d, %v", n, err)
+	}
+	if n := w.Drain(ctx); n != 1 {
+		t.Fatalf("Expected 1 delivery attempt after replay, got %d", n)
+	}
+	entry = outboxEntry(t, db, "event-1")
+	if entry.Status != storage.OutboxStatusDelivered || entry.Attempts != 1 || entry.DeliveredAt == nil || entry.LastError != nil {
+		t.Errorf("Expected the entry delivered on its first replayed attempt, got %+v", entry)
+	}
+	if got := fmt.Sprint(sender.received); got != "[event-1 event-1 event-1]" {
+		t.Errorf("Expected 3 deliveries of event-1, got %s", got)
+	}
+}
+
+func TestWorker_FailingTargetDoesNotStallOthers(t *testing.T) {
+	ctx := context.Background()
+	db := storage.NewMemory(zerolog.Nop())
+	messenger := &fakeSender{err: errors.New("connection refused")}
+	scoring := &fakeSender{}
+	w := New(db, Config{InitialBackoff: time.Hour}, zerolog.Nop())
+	w.Register(storage.OutboxTargetMessenger, messenger)
+	w.Register(storage.OutboxTargetScoring, scoring)
+
+	seedOutbox(t, db, storage.OutboxTargetMessenger, "messenger-1", "messenger-2")
+	seedOutbox(t, db, storage.OutboxTargetScoring, "scoring-1")
+
+	// The messenger's second entry waits for the next poll
+	if n := w.Drain(ctx); n != 2 {
+		t.Fatalf("Expected 2 delivery attempts, got %d", n)
+	}
+	if len(messenger.received) != 1 {
+		t.Errorf("Expected one messenger attempt, got %v", messenger.received)
+	}
+	if entry := outboxEntry(t, db, "scoring-1"); entry.Status != storage.OutboxStatusDelivered {
+		t.Errorf("Expected the scoring entry delivered, got %s", entry.Status)
+	}
+	if entry := outboxEntry(t, db, "messenger-2"); entry.Attempts != 0 {
+		t.Errorf("Expected the second messenger entry untouched, got %d attempts", entry.Attempts)
+	}
+}
+
+func TestWorker_HoldsEntriesForDisabledTargets(t *testing.T) {
+	ctx := context.Background()
+	db := storage.NewMemory(zerolog.Nop())
+	sender := &fakeSender{disabled: true}
+	w := New(db, Config{MaxBackoff: time.Hour}, zerolog.Nop())
+	w.Register(storage.OutboxTargetScoring, sender)
+
+	seedOutbox(t, db, storage.OutboxTargetScoring, "scoring-1")
+	seedOutbox(t, db, storage.OutboxTargetMessenger, "messenger-1")
+
+	// Neither a disabled nor an unregistered target is attempted, and
+	// neither uses up an attempt
+	if n := w.Drain(ctx); n != 0 {
+		t.Fatalf("Expected no delivery attempts, got %d", n)
+	}
+	for _, eventID := range []string{"scoring-1", "messenger-1"} {
+		entry := outboxEntry(t, db, eventID)
+		if entry.Status != storage.OutboxStatusPending || entry.Attempts != 0 {
+			t.Errorf("Expected %s pending with no attempts, got %+v", eventID, entry)
+		}
+		if entry.NextAttemptAt.Before(time.Now().Add(30 * time.Minute)) {
+			t.Errorf("Expected %s held for the max backoff, next attempt at %s", eventID, entry.NextAttemptAt)
+		}
+	}
+	if len(sender.received) != 0 {
+		t.Errorf("Expected nothing sent to a disabled target, got %v", sender.received)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	"cymbytes.com/cymconductor/internal/orchestrator/registry"
@@ -20,7 +21,7 @@ type Planner struct {
 	client    *Client
 	validator *validator.Validator
 	registry  *registry.Registry
-	db        *storage.DB
+	db        storage.Store
 	logger    zerolog.Logger
 }
 
@@ -42,7 +43,7 @@ func DefaultConfig() Config {
 }
 
 // New creates a new planner.
-func New(cfg Config, reg *registry.Registry, db *storage.DB, logger zerolog.Logger) (*Planner, error) {
+func New(cfg Config, reg *registry.Registry, db storage.Store, logger zerolog.Logger) (*Planner, error) {
 	if cfg.APIKey == "" {
 		return nil, fmt.Errorf("API key is required")
 	}
@@ -69,7 +70,13 @@ type PlanResult struct {
 
 // Plan generates a scenario from an intent.
 func (p *Planner) Plan(ctx context.Context, intent *dsl.Intent) (*PlanResult, error) {
+	labID := intent.LabID
+	if labID == "" {
+		labID = storage.DefaultLabID
+	}
+
 	p.logger.Info().
+		Str("lab_id", labID).
 		Str("lab_type", intent.LabType).
 		Int("duration_minutes", intent.DurationMinutes).
 		Str("difficulty", intent.Difficulty).
@@ -77,17 +84,24 @@ func (p *Planner) Plan(ctx context.Context, intent *dsl.Intent) (*PlanResult, er
 
 	result := &PlanResult{}
 
+	// Scope validation to the lab's allowed networks
+	val, err := p.labValidator(ctx, labID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get current agent inventory
-	agents, err := p.registry.GetOnlineAgents(ctx)
+	agents, err := p.registry.GetOnlineAgents(ctx, labID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get agent inventory: %w", err)
 	}
+	val = val.WithAgents(agents)
 
 	// Build inventory summary for AI
 	inventory := p.buildInventorySummary(agents)
 
 	// Build user context for AI (personas for impersonation)
-	userContext := p.buildUserContext(ctx)
+	userContext := p.buildUserContext(ctx, labID)
 
 	// Generate scenario using AI
 	prompt := p.buildPrompt(intent, inventory, userContext)
@@ -109,7 +123,7 @@ func (p *Planner) Plan(ctx context.Context, intent *dsl.Intent) (*PlanResult, er
 	}
 
 	// Validate the scenario
-	validation := p.validator.ValidateScenario(scenario)
+	validation := val.ValidateScenario(scenario)
 	result.Validation = validation
 
 	if !validation.Valid {
@@ -130,6 +144,23 @@ func (p *Planner) Plan(ctx context.Context, intent *dsl.Intent) (*PlanResult, er
 	return result, nil
 }
 
+// labValidator returns a validator restricted to the lab's allowed networks.
+func (p *Planner) labValidator(ctx context.Context, labID string) (*validator.Validator, error) {
+	if p.db == nil {
+		return p.validator, nil
+	}
+
+	lab, err := p.db.GetLab(ctx, labID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lab: %w", err)
+	}
+	if lab == nil {
+		return nil, fmt.Errorf("lab not found: %s", labID)
+	}
+
+	return p.validator.WithAllowedNetworks(lab.AllowedNetworks)
+}
+
 // buildInventorySummary creates a summary of available agents for the AI.
 func (p *Planner) buildInventorySummary(agents []*storage.Agent) string {
 	if len(agents) == 0 {
@@ -143,7 +174,16 @@ func (p *Planner) buildInventorySummary(agents []*storage.Agent) string {
 		if role == "" {
 			role = "unknown"
 		}
-		byRole[role] = append(byRole[role], fmt.Sprintf("%s (%s, %s)", agent.LabHostID, agent.Labels["os"], agent.IPAddress))
+		host := fmt.Sprintf("%s (%s, %s)", agent.LabHostID, agent.Labels["os"], agent.IPAddress)
+		if caps := agent.Capabilities; caps != nil && len(caps.Unavailable) > 0 {
+			unavailable := make([]string, 0, len(caps.Unavailable))
+			for action := range caps.Unavailable {
+				unavailable = append(unavailable, action)
+			}
+			sort.Strings(unavailable)
+			host += fmt.Sprintf(" cannot run %s", strings.Join(unavailable, ", "))
+		}
+		byRole[role] = append(byRole[role], host)
 	}
 
 	summary := fmt.Sprintf("Available agents (%d total):\n", len(agents))
@@ -155,12 +195,12 @@ func (p *Planner) buildInventorySummary(agents []*storage.Agent) string {
 }
 
 // buildUserContext creates a summary of available users for impersonation.
-func (p *Planner) buildUserContext(ctx context.Context) string {
+func (p *Planner) buildUserContext(ctx context.Context, labID string) string {
 	if p.db == nil {
 		return ""
 	}
 
-	users, err := p.db.ListImpersonationUsers(ctx)
+	users, err := p.db.ListImpersonationUsers(ctx, labID)
 	if err != nil {
 		p.logger.Warn().Err(err).Msg("Failed to fetch impersonation users")
 		return ""
@@ -117,9 +117,85 @@ Parameters:
   "interact": false
 }
 
+### 5. email_receive
+Reads received email as a user would, e.g. to open a phishing lure.
+Parameters (all optional; connection settings default to the agent's):
+{
+  "operation": "read",  // list (default), read, extract (save attachments), execute
+  "backend": "auto",  // auto, imap, outlook
+  "folder": "INBOX",
+  "subject": "Invoice",  // substring match
+  "sender": "billing@lab.local",  // substring match
+  "since": "2024-01-02T09:00:00Z",
+  "has_attachment": true,
+  "max_results": 10,
+  "save_directory": "C:\\Users\\user\\Downloads"  // required for extract and execute
+}
+
+## Verification Actions
+
+These actions check the lab state or collect evidence after the trainees respond.
+They change nothing on the host. Add them as the final steps, timed after the
+activity they verify, when the goals mention detection or response.
+
+### 6. observe_process_state
+Checks whether a process is running.
+Parameters:
+{
+  "process_name": "updater.exe",
+  "expected_state": "not_running"  // running, not_running (default)
+}
+
+### 7. observe_file_state
+Checks whether a file exists, was deleted or was modified.
+Parameters:
+{
+  "file_path": "C:\\Users\\Public\\updater.exe",
+  "expected_state": "deleted",  // exists, deleted (default), modified
+  "check_hash": true,
+  "expected_hash": "<sha256-hex>"  // optional; a different hash means modified
+}
+
+### 8. observe_user_state (Windows only)
+Checks whether a domain account is enabled.
+Parameters:
+{
+  "username": "jsmith",
+  "domain": "dc01.lab.local",  // optional
+  "expected_state": "disabled"  // enabled, disabled (default)
+}
+
+### 9. capture_powershell_history (Windows only)
+Collects PowerShell history lines matching a pattern as evidence of response actions.
+Parameters:
+{
+  "command_pattern": "(?i)(Stop-Process|Disable-ADAccount)",  // optional regular expression
+  "scope": "all_users",  // current_user, all_users (default)
+  "max_lines": 1000
+}
+
+## Objectives
+
+When the goals describe what trainees must achieve, turn each one into an
+objective graded on the results of verification steps. Add a top-level
+"objectives" array:
+
+"objectives": [
+  {
+    "id": "contain-malware",            // short unique ID
+    "name": "Malicious process stopped",
+    "step_ids": ["<verification-step-uuid>"],
+    "weight": 1,                         // optional, 1-100
+    "deadline_seconds": 1800,            // optional, from scenario start
+    "retry_interval_seconds": 60         // optional, re-check until the deadline
+  }
+]
+
+Objectives may only reference verification steps (actions 6-9).
+
 ## Constraints
 
-1. ONLY use the four action types listed above
+1. ONLY use the action types listed above
 2. All parameters must match the schemas exactly
 3. Generate unique UUID v4 values for scenario ID and each step ID
 4. Step order must be sequential starting from 1
@@ -128,6 +204,8 @@ Parameters:
 7. File paths must be user-accessible directories (e.g., Documents, Desktop)
 8. DO NOT generate shell commands or arbitrary code
 9. Keep timing realistic for human-like activity
+10. Target observe_user_state and capture_powershell_history at Windows hosts only
+11. Process and user names must be plain names without quotes or shell characters
 
 ## Target Labels
 
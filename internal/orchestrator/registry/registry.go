@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
 	"cymbytes.com/cymconductor/internal/orchestrator/storage"
 	"cymbytes.com/cymconductor/pkg/protocol"
 	"github.com/rs/zerolog"
@@ -14,12 +16,16 @@ import (
 
 // Registry manages registered agents and their status.
 type Registry struct {
-	db     *storage.DB
-	logger zerolog.Logger
+	db      storage.Store
+	events  *events.Bus
+	metrics *metrics.Metrics
+	logger  zerolog.Logger
 
-	// Configuration
+	// Configuration (changed at runtime by UpdateConfig)
+	configMu         sync.RWMutex
 	heartbeatTimeout time.Duration
 	cleanupInterval  time.Duration
+	reconfigured     chan struct{}
 
 	// In-memory cache for quick lookups
 	cache     map[string]*CachedAgent
@@ -34,6 +40,7 @@ type Registry struct {
 // CachedAgent is a lightweight in-memory representation of an agent.
 type CachedAgent struct {
 	ID              string
+	LabID           string
 	LabHostID       string
 	Hostname        string
 	IPAddress       string
@@ -64,24 +71,73 @@ func DefaultConfig() Config {
 }
 
 // New creates a new agent registry.
-func New(db *storage.DB, cfg Config, logger zerolog.Logger) *Registry {
+func New(db storage.Store, cfg Config, logger zerolog.Logger) *Registry {
 	return &Registry{
 		db:               db,
 		logger:           logger.With().Str("component", "registry").Logger(),
 		heartbeatTimeout: cfg.HeartbeatTimeout,
 		cleanupInterval:  cfg.CleanupInterval,
+		reconfigured:     make(chan struct{}, 1),
 		cache:            make(map[string]*CachedAgent),
 		stopCh:           make(chan struct{}),
 	}
 }
 
+// UpdateConfig applies new timeouts to a running registry. The cleanup loop
+// picks up a new interval on its next tick.
+func (r *Registry) UpdateConfig(cfg Config) {
+	r.configMu.Lock()
+	r.heartbeatTimeout = cfg.HeartbeatTimeout
+	r.cleanupInterval = cfg.CleanupInterval
+	r.configMu.Unlock()
+
+	select {
+	case r.reconfigured <- struct{}{}:
+	default:
+	}
+
+	r.logger.Info().
+		Dur("heartbeat_timeout", cfg.HeartbeatTimeout).
+		Dur("cleanup_interval", cfg.CleanupInterval).
+		Msg("Registry configuration updated")
+}
+
+// timeouts returns the current heartbeat timeout and cleanup interval.
+func (r *Registry) timeouts() (heartbeatTimeout, cleanupInterval time.Duration) {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	return r.heartbeatTimeout, r.cleanupInterval
+}
+
+// SetEventBus sets the bus that agent online/offline events are published to.
+func (r *Registry) SetEventBus(bus *events.Bus) {
+	r.events = bus
+}
+
+// SetMetrics sets the metrics that agent status changes are recorded in.
+func (r *Registry) SetMetrics(m *metrics.Metrics) {
+	r.metrics = m
+}
+
 // Start begins background tasks (stale agent cleanup).
 func (r *Registry) Start(ctx context.Context) {
+	heartbeatTimeout, cleanupInterval := r.timeouts()
 	r.logger.Info().
-		Dur("heartbeat_timeout", r.heartbeatTimeout).
-		Dur("cleanup_interval", r.cleanupInterval).
+		Dur("heartbeat_timeout", heartbeatTimeout).
+		Dur("cleanup_interval", cleanupInterval).
 		Msg("Starting agent registry")
 
+	// Seed agent metrics with the agents registered before this start
+	if r.metrics != nil {
+		agents, err := r.db.ListAgents(ctx, "", "")
+		if err != nil {
+			r.logger.Error().Err(err).Msg("Failed to load agents for metrics")
+		}
+		for _, agent := range agents {
+			r.metrics.SetAgent(agent.ID, agent.Status, agent.Labels)
+		}
+	}
+
 	r.wg.Add(1)
 	go r.cleanupLoop(ctx)
 }
@@ -97,7 +153,8 @@ func (r *Registry) Stop() {
 func (r *Registry) cleanupLoop(ctx context.Context) {
 	defer r.wg.Done()
 
-	ticker := time.NewTicker(r.cleanupInterval)
+	_, cleanupInterval := r.timeouts()
+	ticker := time.NewTicker(cleanupInterval)
 	defer ticker.Stop()
 
 	for {
@@ -106,6 +163,9 @@ func (r *Registry) cleanupLoop(ctx context.Context) {
 			return
 		case <-r.stopCh:
 			return
+		case <-r.reconfigured:
+			_, cleanupInterval := r.timeouts()
+			ticker.Reset(cleanupInterval)
 		case <-ticker.C:
 			if err := r.cleanupStaleAgents(ctx); err != nil {
 				r.logger.Error().Err(err).Msg("Failed to cleanup stale agents")
@@ -115,30 +175,59 @@ func (r *Registry) cleanupLoop(ctx context.Context) {
 }
 
 func (r *Registry) cleanupStaleAgents(ctx context.Context) error {
-	count, err := r.db.MarkStaleAgentsOffline(ctx, r.heartbeatTimeout)
+	heartbeatTimeout, _ := r.timeouts()
+	stale, err := r.db.MarkStaleAgentsOffline(ctx, heartbeatTimeout)
 	if err != nil {
 		return err
 	}
 
-	if count > 0 {
+	if len(stale) > 0 {
 		// Invalidate cache
 		r.cacheMu.Lock()
 		r.cache = make(map[string]*CachedAgent)
 		r.cacheMu.Unlock()
 	}
 
+	for _, agent := range stale {
+		r.metrics.SetAgentStatus(agent.ID, storage.AgentStatusOffline)
+		r.events.Publish(events.Event{
+			Type:    events.AgentOffline,
+			LabID:   agent.LabID,
+			AgentID: agent.ID,
+			Data:    map[string]interface{}{"reason": "heartbeat_timeout"},
+		})
+	}
+
 	return nil
 }
 
 // RegisterAgent handles new agent registration.
 func (r *Registry) RegisterAgent(ctx context.Context, req *protocol.RegisterAgentRequest) (*protocol.RegisterAgentResponse, error) {
+	labID := req.LabID
+	if labID == "" {
+		labID = storage.DefaultLabID
+	}
+
 	r.logger.Info().
 		Str("agent_id", req.AgentID).
+		Str("lab_id", labID).
 		Str("lab_host_id", req.LabHostID).
 		Str("hostname", req.Hostname).
 		Str("ip", req.IPAddress).
 		Msg("Agent registration request")
 
+	// Agents may only join an existing lab, from within its allowed networks
+	lab, err := r.db.GetLab(ctx, labID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lab: %w", err)
+	}
+	if lab == nil {
+		return nil, fmt.Errorf("lab not found: %s", labID)
+	}
+	if !lab.AllowsIP(req.IPAddress) {
+		return nil, fmt.Errorf("ip address %s is not allowed in lab %s", req.IPAddress, labID)
+	}
+
 	// Check if agent already exists
 	existing, err := r.db.GetAgent(ctx, req.AgentID)
 	if err != nil {
@@ -146,22 +235,48 @@ func (r *Registry) RegisterAgent(ctx context.Context, req *protocol.RegisterAgen
 	}
 
 	now := time.Now()
+	caps := agentCapabilities(req.Capabilities)
 
 	if existing != nil {
 		// Agent re-registering (e.g., after restart)
 		if err := r.db.UpdateAgentHeartbeat(ctx, req.AgentID, storage.AgentStatusOnline, req.IPAddress); err != nil {
 			return nil, fmt.Errorf("failed to update agent: %w", err)
 		}
+		// An upgraded or reconfigured agent may run different actions
+		if err := r.db.UpdateAgentCapabilities(ctx, req.AgentID, req.Version, caps); err != nil {
+			return nil, fmt.Errorf("failed to update agent: %w", err)
+		}
+		if existing.LabID != labID {
+			if err := r.db.UpdateAgentLab(ctx, req.AgentID, labID); err != nil {
+				return nil, fmt.Errorf("failed to update agent: %w", err)
+			}
+			r.logger.Info().
+				Str("agent_id", req.AgentID).
+				Str("old_lab_id", existing.LabID).
+				Str("lab_id", labID).
+				Msg("Agent moved to a different lab")
+		}
 		r.logger.Info().Str("agent_id", req.AgentID).Msg("Agent re-registered")
+		r.db.Audit(ctx, storage.AuditEntityAgent, req.AgentID, storage.AuditActionUpdated,
+			map[string]interface{}{"status": existing.Status, "ip_address": existing.IPAddress},
+			map[string]interface{}{"status": storage.AgentStatusOnline, "ip_address": req.IPAddress},
+			map[string]interface{}{"reason": "reregistered"})
+		r.metrics.SetAgent(req.AgentID, storage.AgentStatusOnline, existing.Labels)
+
+		if existing.Status != storage.AgentStatusOnline || existing.LabID != labID {
+			r.publishOnline(req.AgentID, labID, "reregistered")
+		}
 	} else {
 		// New agent
 		agent := &storage.Agent{
 			ID:              req.AgentID,
+			LabID:           labID,
 			LabHostID:       req.LabHostID,
 			Hostname:        req.Hostname,
 			IPAddress:       req.IPAddress,
 			Labels:          req.Labels,
 			Version:         req.Version,
+			Capabilities:    caps,
 			Status:          storage.AgentStatusOnline,
 			LastHeartbeatAt: now,
 			RegisteredAt:    now,
@@ -170,11 +285,15 @@ func (r *Registry) RegisterAgent(ctx context.Context, req *protocol.RegisterAgen
 		if err := r.db.CreateAgent(ctx, agent); err != nil {
 			return nil, fmt.Errorf("failed to create agent: %w", err)
 		}
+
+		r.metrics.SetAgent(req.AgentID, storage.AgentStatusOnline, req.Labels)
+		r.publishOnline(req.AgentID, labID, "registered")
 	}
 
 	// Update cache
 	r.updateCache(req.AgentID, &CachedAgent{
 		ID:              req.AgentID,
+		LabID:           labID,
 		LabHostID:       req.LabHostID,
 		Hostname:        req.Hostname,
 		IPAddress:       req.IPAddress,
@@ -183,10 +302,11 @@ func (r *Registry) RegisterAgent(ctx context.Context, req *protocol.RegisterAgen
 		LastHeartbeatAt: now,
 	})
 
+	heartbeatTimeout, _ := r.timeouts()
 	return &protocol.RegisterAgentResponse{
 		AgentID:             req.AgentID,
 		RegisteredAt:        now,
-		HeartbeatIntervalMs: int(r.heartbeatTimeout.Milliseconds() / 2), // Recommend heartbeat at half timeout
+		HeartbeatIntervalMs: int(heartbeatTimeout.Milliseconds() / 2), // Recommend heartbeat at half timeout
 		Config: &protocol.AgentConfig{
 			MaxConcurrentJobs: 3,
 			LogLevel:          "info",
@@ -194,6 +314,20 @@ func (r *Registry) RegisterAgent(ctx context.Context, req *protocol.RegisterAgen
 	}, nil
 }
 
+// agentCapabilities converts reported capabilities for storage.
+func agentCapabilities(caps *protocol.AgentCapabilities) *storage.AgentCapabilities {
+	if caps == nil {
+		return nil
+	}
+	return &storage.AgentCapabilities{
+		Actions:     caps.Actions,
+		Unavailable: caps.Unavailable,
+		Backends:    caps.Backends,
+		OS:          caps.OS,
+		Arch:        caps.Arch,
+	}
+}
+
 // ProcessHeartbeat handles agent heartbeat.
 func (r *Registry) ProcessHeartbeat(ctx context.Context, agentID string, req *protocol.HeartbeatRequest) (*protocol.HeartbeatResponse, error) {
 	// Verify agent exists
@@ -218,6 +352,17 @@ func (r *Registry) ProcessHeartbeat(ctx context.Context, agentID string, req *pr
 
 	// Update cache
 	r.updateCacheHeartbeat(agentID, status)
+	r.metrics.SetAgentStatus(agentID, status)
+
+	if agent.Status != status {
+		r.db.Audit(ctx, storage.AuditEntityAgent, agentID, storage.AuditActionStatusChanged,
+			map[string]interface{}{"status": agent.Status},
+			map[string]interface{}{"status": status},
+			map[string]interface{}{"reason": "heartbeat"})
+	}
+	if agent.Status != storage.AgentStatusOnline && status == storage.AgentStatusOnline {
+		r.publishOnline(agentID, agent.LabID, "heartbeat")
+	}
 
 	return &protocol.HeartbeatResponse{
 		Acknowledged: true,
@@ -231,19 +376,22 @@ func (r *Registry) GetAgent(ctx context.Context, agentID string) (*storage.Agent
 	return r.db.GetAgent(ctx, agentID)
 }
 
-// GetOnlineAgents returns all online agents.
-func (r *Registry) GetOnlineAgents(ctx context.Context) ([]*storage.Agent, error) {
-	return r.db.ListAgents(ctx, storage.AgentStatusOnline)
+// GetOnlineAgents returns all online agents in a lab.
+func (r *Registry) GetOnlineAgents(ctx context.Context, labID string) ([]*storage.Agent, error) {
+	if labID == "" {
+		labID = storage.DefaultLabID
+	}
+	return r.db.ListAgents(ctx, labID, storage.AgentStatusOnline)
 }
 
-// GetAgentsByLabels returns agents matching the given labels.
-func (r *Registry) GetAgentsByLabels(ctx context.Context, labels map[string]string) ([]*storage.Agent, error) {
-	return r.db.ListAgentsByLabels(ctx, labels)
+// GetAgentsByLabels returns online agents in a lab matching the given labels.
+func (r *Registry) GetAgentsByLabels(ctx context.Context, labID string, labels map[string]string) ([]*storage.Agent, error) {
+	return r.db.ListAgentsByLabels(ctx, labID, labels)
 }
 
-// ListAgents returns all agents.
-func (r *Registry) ListAgents(ctx context.Context) ([]*storage.Agent, error) {
-	return r.db.ListAgents(ctx, "")
+// ListAgents returns all agents in a lab, or in every lab if labID is empty.
+func (r *Registry) ListAgents(ctx context.Context, labID string) ([]*storage.Agent, error) {
+	return r.db.ListAgents(ctx, labID, "")
 }
 
 // CountAgents returns agent counts.
@@ -273,6 +421,11 @@ func (r *Registry) AgentExists(ctx context.Context, agentID string) (bool, error
 
 // DeleteAgent removes an agent registration.
 func (r *Registry) DeleteAgent(ctx context.Context, agentID string) error {
+	agent, err := r.db.GetAgent(ctx, agentID)
+	if err != nil {
+		return fmt.Errorf("failed to get agent: %w", err)
+	}
+
 	if err := r.db.DeleteAgent(ctx, agentID); err != nil {
 		return err
 	}
@@ -282,9 +435,30 @@ func (r *Registry) DeleteAgent(ctx context.Context, agentID string) error {
 	delete(r.cache, agentID)
 	r.cacheMu.Unlock()
 
+	r.metrics.RemoveAgent(agentID)
+
+	if agent != nil && agent.Status == storage.AgentStatusOnline {
+		r.events.Publish(events.Event{
+			Type:    events.AgentOffline,
+			LabID:   agent.LabID,
+			AgentID: agentID,
+			Data:    map[string]interface{}{"reason": "deleted"},
+		})
+	}
+
 	return nil
 }
 
+// publishOnline publishes an agent.online event.
+func (r *Registry) publishOnline(agentID, labID, reason string) {
+	r.events.Publish(events.Event{
+		Type:    events.AgentOnline,
+		LabID:   labID,
+		AgentID: agentID,
+		Data:    map[string]interface{}{"reason": reason},
+	})
+}
+
 // ============================================================
 // Cache operations
 // ============================================================
@@ -313,7 +487,7 @@ func (r *Registry) GetCachedAgent(agentID string) *CachedAgent {
 
 // RefreshCache reloads all agents into the cache.
 func (r *Registry) RefreshCache(ctx context.Context) error {
-	agents, err := r.db.ListAgents(ctx, "")
+	agents, err := r.db.ListAgents(ctx, "", "")
 	if err != nil {
 		return err
 	}
@@ -325,6 +499,7 @@ func (r *Registry) RefreshCache(ctx context.Context) error {
 	for _, agent := range agents {
 		r.cache[agent.ID] = &CachedAgent{
 			ID:              agent.ID,
+			LabID:           agent.LabID,
 			LabHostID:       agent.LabHostID,
 			Hostname:        agent.Hostname,
 			IPAddress:       agent.IPAddress,
@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+func TestRegistry_RecordsAgentMetrics(t *testing.T) {
+	db := storage.NewMemory(zerolog.Nop())
+	defer db.Close()
+
+	reg := New(db, DefaultConfig(), zerolog.Nop())
+	m := metrics.New()
+	reg.SetMetrics(m)
+	ctx := context.Background()
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return w.Body.String()
+	}
+
+	_, err := reg.RegisterAgent(ctx, &protocol.RegisterAgentRequest{
+		AgentID:   "agent-metrics",
+		LabHostID: "host-metrics",
+		Hostname:  "ws-metrics",
+		IPAddress: "192.168.1.1",
+		Labels:    map[string]string{"role": "test"},
+		Version:   "test",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register agent: %v", err)
+	}
+	body := scrape()
+	for _, want := range []string{
+		`cymconductor_agents{status="online"} 1`,
+		`cymconductor_agents_by_label{label="role",status="online",value="test"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics output to contain %q", want)
+		}
+	}
+
+	if err := reg.DeleteAgent(ctx, "agent-metrics"); err != nil {
+		t.Fatalf("Failed to delete agent: %v", err)
+	}
+	if !strings.Contains(scrape(), `cymconductor_agents{status="online"} 0`) {
+		t.Error("Expected the deleted agent to be removed from agent metrics")
+	}
+}
@@ -0,0 +1,148 @@
+package report
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// Formats a report can be rendered in.
+const (
+	FormatJSON     = "json"
+	FormatMarkdown = "md"
+	FormatHTML     = "html"
+)
+
+// ContentTypes maps each format to its Content-Type.
+var ContentTypes = map[string]string{
+	FormatJSON:     "application/json",
+	FormatMarkdown: "text/markdown; charset=utf-8",
+	FormatHTML:     "text/html; charset=utf-8",
+}
+
+//go:embed templates/*.tmpl
+var templatesFS embed.FS
+
+var funcs = map[string]interface{}{
+	"time":     formatTime,
+	"duration": formatDuration,
+	"json":     prettyJSON,
+	"params":   formatParams,
+	"join":     strings.Join,
+	"orDash":   orDash,
+	"cell":     markdownCell,
+	"passed":   passedChecks,
+}
+
+var (
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("report.html.tmpl").
+			Funcs(htmltemplate.FuncMap(funcs)).ParseFS(templatesFS, "templates/report.html.tmpl"))
+	markdownTemplate = template.Must(template.New("report.md.tmpl").
+				Funcs(template.FuncMap(funcs)).ParseFS(templatesFS, "templates/report.md.tmpl"))
+)
+
+// Render writes the report in format (json, md or html). The HTML report is a
+// single file with inline styles and no external assets.
+func Render(w io.Writer, report *protocol.ScenarioReport, format string) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case FormatMarkdown:
+		return markdownTemplate.Execute(w, report)
+	case FormatHTML:
+		return htmlTemplate.Execute(w, report)
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+func formatTime(t interface{}) string {
+	switch v := t.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return "-"
+		}
+		return v.UTC().Format("2006-01-02 15:04:05Z")
+	case *time.Time:
+		if v == nil {
+			return "-"
+		}
+		return formatTime(*v)
+	}
+	return "-"
+}
+
+func formatDuration(ms int64) string {
+	if ms <= 0 {
+		return "-"
+	}
+	return (time.Duration(ms) * time.Millisecond).String()
+}
+
+// prettyJSON indents a JSON document for display.
+func prettyJSON(doc json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, doc, "", "  "); err != nil {
+		return string(doc)
+	}
+	return buf.String()
+}
+
+// formatParams formats parameters as "key=value" pairs in key order.
+func formatParams(params map[string]interface{}) string {
+	if len(params) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := params[k]
+		if list, ok := v.([]interface{}); ok {
+			items := make([]string, 0, len(list))
+			for _, item := range list {
+				items = append(items, fmt.Sprint(item))
+			}
+			v = strings.Join(items, ", ")
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+	}
+	return strings.Join(pairs, "; ")
+}
+
+func passedChecks(checks []protocol.ObjectiveCheck) int {
+	passed := 0
+	for _, check := range checks {
+		if check.Passed {
+			passed++
+		}
+	}
+	return passed
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// markdownCell makes s safe to use in a Markdown table cell.
+func markdownCell(s string) string {
+	s = strings.NewReplacer("|", `\|`, "\r", " ", "\n", " ").Replace(s)
+	return orDash(s)
+}
@@ -0,0 +1,369 @@
+// Package report builds after-action reports of scenarios and renders them as
+// JSON, Markdown or self-contained HTML.
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/internal/orchestrator/timeline"
+	"cymbytes.com/cymconductor/pkg/dsl"
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// redacted replaces the values of credential parameters.
+const redacted = "[redacted]"
+
+// Build assembles the report of a scenario from storage. objectives holds the
+// scenario's objective grades.
+func Build(ctx context.Context, db storage.Store, scenario *storage.Scenario, objectives protocol.ScorecardResponse, now time.Time) (*protocol.ScenarioReport, error) {
+	report := &protocol.ScenarioReport{
+		ScenarioID:   scenario.ID,
+		LabID:        scenario.LabID,
+		Name:         scenario.Name,
+		Source:       scenario.Source,
+		Status:       scenario.Status,
+		CreatedAt:    scenario.CreatedAt,
+		CompletedAt:  scenario.CompletedAt,
+		GeneratedAt:  now.UTC(),
+		Intent:       redactJSON(scenario.Intent),
+		Objectives:   objectives,
+		Steps:        []protocol.ReportStep{},
+		Agents:       []protocol.ReportAgentSummary{},
+		Users:        []protocol.ReportUserSummary{},
+		Failures:     []protocol.TimelineEntry{},
+		ErrorMessage: deref(scenario.ErrorMessage),
+		Description:  deref(scenario.Description),
+	}
+	if scenario.AIOutput != nil {
+		report.AIOutput = redactJSON(*scenario.AIOutput)
+	}
+	if scenario.ValidatedDSL != nil {
+		report.ValidatedDSL = redactJSON(*scenario.ValidatedDSL)
+	}
+
+	steps, err := plannedSteps(ctx, db, scenario)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := db.ListJobsByScenario(ctx, scenario.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scenario jobs: %w", err)
+	}
+
+	agents := make(map[string]*storage.Agent)
+	stepIndex := make(map[string]int, len(steps))
+	for i, step := range steps {
+		stepIndex[step.StepID] = i
+	}
+	agentIndex := make(map[string]int)
+	userIndex := make(map[string]int)
+	stepHosts := make(map[int]map[string]bool)
+	userHosts := make(map[int]map[string]bool)
+	userActions := make(map[int]map[string]bool)
+	stepDurations := make(map[int][]int64)
+
+	var durations []int64
+	var queueTotal, queued int64
+	timing := &report.Timing
+
+	for _, job := range jobs {
+		agent, ok := agents[job.AgentID]
+		if !ok {
+			// A deleted agent leaves only its ID in the report
+			if agent, err = db.GetAgent(ctx, job.AgentID); err != nil {
+				return nil, fmt.Errorf("failed to get agent %s: %w", job.AgentID, err)
+			}
+			agents[job.AgentID] = agent
+		}
+		entry := timeline.NewEntry(job, agent)
+		ran := job.Status == storage.JobStatusCompleted || job.Status == storage.JobStatusFailed
+		host := entry.Hostname
+		if host == "" {
+			host = entry.AgentID
+		}
+
+		// Step
+		si, ok := stepIndex[entry.StepID]
+		if !ok {
+			si = len(steps)
+			stepIndex[entry.StepID] = si
+			steps = append(steps, protocol.ReportStep{StepID: entry.StepID, ActionType: entry.ActionType})
+		}
+		step := &steps[si]
+		step.Jobs++
+		count(job.Status, &step.Completed, &step.Failed, &step.Other)
+		addTo(stepHosts, si, host)
+		if entry.StartedAt != nil && (step.FirstStartedAt == nil || entry.StartedAt.Before(*step.FirstStartedAt)) {
+			step.FirstStartedAt = entry.StartedAt
+		}
+		if ran && entry.CompletedAt != nil && (step.LastCompletedAt == nil || entry.CompletedAt.After(*step.LastCompletedAt)) {
+			step.LastCompletedAt = entry.CompletedAt
+		}
+
+		// Agent
+		ai, ok := agentIndex[job.AgentID]
+		if !ok {
+			ai = len(report.Agents)
+			agentIndex[job.AgentID] = ai
+			report.Agents = append(report.Agents, protocol.ReportAgentSummary{AgentID: job.AgentID, Hostname: entry.Hostname})
+		}
+		agentSummary := &report.Agents[ai]
+		agentSummary.Jobs++
+		count(job.Status, &agentSummary.Completed, &agentSummary.Failed, &agentSummary.Other)
+		agentSummary.TotalDurationMs += entry.DurationMs
+
+		// User
+		ui, ok := userIndex[entry.RunAs]
+		if !ok {
+			ui = len(report.Users)
+			userIndex[entry.RunAs] = ui
+			report.Users = append(report.Users, protocol.ReportUserSummary{RunAs: entry.RunAs})
+		}
+		user := &report.Users[ui]
+		user.Jobs++
+		count(job.Status, &user.Completed, &user.Failed, &user.Other)
+		addTo(userHosts, ui, host)
+		addTo(userActions, ui, entry.ActionType)
+
+		// Timing
+		timing.Jobs++
+		count(job.Status, &timing.Completed, &timing.Failed, &timing.Other)
+		if entry.StartedAt != nil {
+			if timing.FirstStartedAt == nil || entry.StartedAt.Before(*timing.FirstStartedAt) {
+				timing.FirstStartedAt = entry.StartedAt
+			}
+			if queue := entry.StartedAt.Sub(job.CreatedAt); queue >= 0 {
+				queueTotal += queue.Milliseconds()
+				queued++
+			}
+		}
+		if ran && entry.CompletedAt != nil && (timing.LastCompletedAt == nil || entry.CompletedAt.After(*timing.LastCompletedAt)) {
+			timing.LastCompletedAt = entry.CompletedAt
+		}
+		if ran && entry.StartedAt != nil && entry.CompletedAt != nil {
+			durations = append(durations, entry.DurationMs)
+			stepDurations[si] = append(stepDurations[si], entry.DurationMs)
+		}
+
+		if job.Status == storage.JobStatusFailed {
+			report.Failures = append(report.Failures, entry)
+		}
+	}
+
+	for i := range steps {
+		steps[i].Hosts = sortedKeys(stepHosts[i])
+		steps[i].AvgDurationMs = average(stepDurations[i])
+	}
+	report.Steps = append(report.Steps, steps...)
+	sort.SliceStable(report.Steps, func(i, j int) bool {
+		// Jobs outside the plan come last
+		a, b := report.Steps[i], report.Steps[j]
+		if (a.Order == 0) != (b.Order == 0) {
+			return b.Order == 0
+		}
+		return a.Order < b.Order
+	})
+
+	for i := range report.Users {
+		report.Users[i].Hosts = sortedKeys(userHosts[i])
+		report.Users[i].Actions = sortedKeys(userActions[i])
+	}
+	sort.Slice(report.Users, func(i, j int) bool { return report.Users[i].RunAs < report.Users[j].RunAs })
+	sort.Slice(report.Agents, func(i, j int) bool {
+		a, b := report.Agents[i], report.Agents[j]
+		if a.Hostname != b.Hostname {
+			return a.Hostname < b.Hostname
+		}
+		return a.AgentID < b.AgentID
+	})
+	timeline.Sort(report.Failures)
+
+	if timing.FirstStartedAt != nil && timing.LastCompletedAt != nil && timing.LastCompletedAt.After(*timing.FirstStartedAt) {
+		timing.WallClockMs = timing.LastCompletedAt.Sub(*timing.FirstStartedAt).Milliseconds()
+	}
+	if len(durations) > 0 {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		timing.MinDurationMs = durations[0]
+		timing.MaxDurationMs = durations[len(durations)-1]
+		timing.AvgDurationMs = average(durations)
+		timing.P95DurationMs = durations[(len(durations)*95+99)/100-1]
+	}
+	if queued > 0 {
+		timing.AvgQueueMs = queueTotal / queued
+	}
+
+	return report, nil
+}
+
+// plannedSteps returns the scenario's steps from its validated DSL, or from
+// its compiled steps if it has none.
+func plannedSteps(ctx context.Context, db storage.Store, scenario *storage.Scenario) ([]protocol.ReportStep, error) {
+	var steps []protocol.ReportStep
+
+	if scenario.ValidatedDSL != nil {
+		var doc dsl.Scenario
+		if err := json.Unmarshal([]byte(*scenario.ValidatedDSL), &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse scenario DSL: %w", err)
+		}
+		for _, s := range doc.Steps {
+			var params map[string]interface{}
+			_ = json.Unmarshal(s.Parameters, &params)
+			step := protocol.ReportStep{
+				StepID:        s.ID,
+				Order:         s.Order,
+				ActionType:    string(s.ActionType),
+				Target:        target(s.Target.Labels, s.Target.Count),
+				Parameters:    timeline.KeyParameters(string(s.ActionType), params),
+				DelayBeforeMs: s.Timing.DelayBeforeMs,
+				DelayAfterMs:  s.Timing.DelayAfterMs,
+			}
+			if s.RunAs != nil {
+				step.RunAs = s.RunAs.User
+			}
+			steps = append(steps, step)
+		}
+		return steps, nil
+	}
+
+	stored, err := db.GetScenarioSteps(ctx, scenario.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scenario steps: %w", err)
+	}
+	for _, s := range stored {
+		steps = append(steps, protocol.ReportStep{
+			StepID:        s.ID,
+			Order:         s.StepOrder,
+			ActionType:    s.ActionType,
+			Target:        target(s.TargetLabels, s.TargetCount),
+			Parameters:    timeline.KeyParameters(s.ActionType, s.Parameters),
+			DelayBeforeMs: s.DelayBeforeMs,
+			DelayAfterMs:  s.DelayAfterMs,
+		})
+	}
+	return steps, nil
+}
+
+// target describes a step's target as "key=value,... (count)".
+func target(labels map[string]string, count string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	s := strings.Join(pairs, ",")
+	if count != "" {
+		s += " (" + count + ")"
+	}
+	return strings.TrimSpace(s)
+}
+
+// redactJSON returns a stored JSON document with the values of credential
+// fields replaced. Text that is not JSON is returned as a JSON string.
+func redactJSON(doc string) json.RawMessage {
+	if strings.TrimSpace(doc) == "" {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		v = doc
+	}
+
+	// Keep <, > and & readable in the Markdown report; the HTML template
+	// escapes them itself
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(redact(v)); err != nil {
+		return nil
+	}
+	return bytes.TrimSpace(buf.Bytes())
+}
+
+func redact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if secretKey(k) {
+				t[k] = redacted
+			} else {
+				t[k] = redact(val)
+			}
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = redact(val)
+		}
+	}
+	return v
+}
+
+// secretKey reports whether a field name holds a credential.
+func secretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range []string{"password", "secret", "token", "api_key"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// count increments the counter for a job status.
+func count(status string, completed, failed, other *int) {
+	switch status {
+	case storage.JobStatusCompleted:
+		*completed++
+	case storage.JobStatusFailed:
+		*failed++
+	default:
+		*other++
+	}
+}
+
+func addTo(sets map[int]map[string]bool, i int, value string) {
+	if value == "" {
+		return
+	}
+	if sets[i] == nil {
+		sets[i] = make(map[string]bool)
+	}
+	sets[i][value] = true
+}
+
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func average(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var total int64
+	for _, v := range values {
+		total += v
+	}
+	return total / int64(len(values))
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,223 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/pkg/dsl"
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+const (
+	browseStep = "550e8400-e29b-41d4-a716-446655440011"
+	emailStep  = "550e8400-e29b-41d4-a716-446655440012"
+)
+
+// createReportScenario stores a two-step scenario with a completed browsing
+// job, a failed email job and an email job that never ran.
+func createReportScenario(t *testing.T, db storage.Store, validatedDSL *string, steps []*storage.ScenarioStep) *storage.Scenario {
+	t.Helper()
+	ctx := context.Background()
+	if err := db.CreateAgent(ctx, &storage.Agent{ID: "agent-1", LabHostID: "host-1", Hostname: "ws-01", Status: "online"}); err != nil {
+		t.Fatalf("CreateAgent() error = %v", err)
+	}
+
+	scenarioID := "scenario-report"
+	runAs := `CORP\jdoe`
+	var jobs []*storage.Job
+	for _, id := range []string{"job-browse", "job-email", "job-email-2"} {
+		job := &storage.Job{ID: id, ScenarioID: &scenarioID, AgentID: "agent-1", Status: storage.JobStatusPending, ScheduledAt: time.Now().UTC()}
+		step := emailStep
+		job.ActionType = string(dsl.ActionSimulateEmailTraffic)
+		if id == "job-browse" {
+			step = browseStep
+			job.ActionType = string(dsl.ActionSimulateBrowsing)
+			job.RunAsUser = &runAs
+		}
+		job.ScenarioStepID = &step
+		jobs = append(jobs, job)
+	}
+	scenario := &storage.Scenario{
+		ID: scenarioID, LabID: "default", Name: "After-action <review>", Intent: `{"password":"hunter2"}`,
+		Source: storage.ScenarioSourceAPI, ValidatedDSL: validatedDSL,
+	}
+	if err := db.CreateActiveScenario(ctx, scenario, steps, jobs); err != nil {
+		t.Fatalf("CreateActiveScenario() error = %v", err)
+	}
+
+	now := time.Now().UTC()
+	if err := db.UpdateJobStarted(ctx, "job-browse", now.Add(-3*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateJobCompleted(ctx, "job-browse", now.Add(-time.Second), map[string]interface{}{"pages_loaded": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateJobStarted(ctx, "job-email", now.Add(-2*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateJobFailed(ctx, "job-email", now, "connection refused", false); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := db.GetScenario(ctx, scenarioID)
+	if err != nil || stored == nil {
+		t.Fatalf("GetScenario() error = %v", err)
+	}
+	return stored
+}
+
+func TestBuild(t *testing.T) {
+	doc := &dsl.Scenario{
+		Schema:  dsl.SchemaVersion,
+		ID:      "550e8400-e29b-41d4-a716-446655440010",
+		Name:    "After-action <review>",
+		Version: 1,
+		Steps: []dsl.Step{
+			{ID: browseStep, Order: 1, ActionType: dsl.ActionSimulateBrowsing,
+				Target:     dsl.Target{Labels: map[string]string{"role": "test", "os": "windows"}, Count: "all"},
+				Parameters: json.RawMessage(`{"urls": ["https://intranet.corp.local"]}`),
+				RunAs:      &dsl.RunAs{User: `CORP\jdoe`}},
+			{ID: emailStep, Order: 2, ActionType: dsl.ActionSimulateEmailTraffic,
+				Target:     dsl.Target{Labels: map[string]string{"role": "test"}, Count: "any"},
+				Parameters: json.RawMessage(`{"server": "mail.corp.local", "password": "hunter2"}`)},
+		},
+		Schedule: dsl.Schedule{Type: "immediate"},
+	}
+	data, _ := json.Marshal(doc)
+	validatedDSL := string(data)
+
+	db := storage.NewMemory(zerolog.Nop())
+	scenario := createReportScenario(t, db, &validatedDSL, nil)
+
+	rep, err := Build(context.Background(), db, scenario, protocol.ScorecardResponse{}, time.Now())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	if len(rep.Steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %+v", rep.Steps)
+	}
+	browse, email := rep.Steps[0], rep.Steps[1]
+	if browse.StepID != browseStep || browse.Target != "os=windows,role=test (all)" || browse.RunAs != `CORP\jdoe` ||
+		browse.Completed != 1 || len(browse.Hosts) != 1 || browse.AvgDurationMs != 2000 {
+		t.Errorf("Unexpected browsing step: %+v", browse)
+	}
+	if email.Jobs != 2 || email.Failed != 1 || email.Other != 1 || email.Parameters["server"] != "mail.corp.local" {
+		t.Errorf("Unexpected email step: %+v", email)
+	}
+	if _, ok := email.Parameters["password"]; ok {
+		t.Errorf("Expected the password to be left out of the step, got %v", email.Parameters)
+	}
+	if len(rep.Failures) != 1 || rep.Failures[0].JobID != "job-email" || rep.Failures[0].Error != "connection refused" {
+		t.Errorf("Expected the email job to be reported as failed, got %+v", rep.Failures)
+	}
+	if len(rep.Agents) != 1 || rep.Agents[0].Hostname != "ws-01" || rep.Agents[0].Jobs != 3 || rep.Agents[0].TotalDurationMs != 4000 {
+		t.Errorf("Unexpected agents: %+v", rep.Agents)
+	}
+	if len(rep.Users) != 2 || rep.Users[0].RunAs != "" || rep.Users[1].RunAs != `CORP\jdoe` || rep.Users[1].Actions[0] != string(dsl.ActionSimulateBrowsing) {
+		t.Errorf("Unexpected users: %+v", rep.Users)
+	}
+	if rep.Timing.Jobs != 3 || rep.Timing.Completed != 1 || rep.Timing.Failed != 1 || rep.Timing.Other != 1 ||
+		rep.Timing.MinDurationMs != 2000 || rep.Timing.MaxDurationMs != 2000 || rep.Timing.WallClockMs != 3000 {
+		t.Errorf("Unexpected timing: %+v", rep.Timing)
+	}
+	for name, doc := range map[string]json.RawMessage{"intent": rep.Intent, "validated_dsl": rep.ValidatedDSL} {
+		if strings.Contains(string(doc), "hunter2") || !strings.Contains(string(doc), redacted) {
+			t.Errorf("Expected the %s password to be redacted, got %s", name, doc)
+		}
+	}
+}
+
+func TestBuild_StoredSteps(t *testing.T) {
+	db := storage.NewMemory(zerolog.Nop())
+	scenario := createReportScenario(t, db, nil, []*storage.ScenarioStep{
+		{ID: browseStep, ScenarioID: "scenario-report", StepOrder: 1, ActionType: string(dsl.ActionSimulateBrowsing),
+			TargetLabels: map[string]string{"role": "test"}, TargetCount: "all"},
+	})
+
+	rep, err := Build(context.Background(), db, scenario, protocol.ScorecardResponse{}, time.Now())
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	// Jobs of a step outside the plan are reported after the planned steps
+	if len(rep.Steps) != 2 || rep.Steps[0].StepID != browseStep || rep.Steps[0].Target != "role=test (all)" ||
+		rep.Steps[1].StepID != emailStep || rep.Steps[1].Order != 0 || rep.Steps[1].Jobs != 2 {
+		t.Errorf("Unexpected steps: %+v", rep.Steps)
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{"empty", "  ", ""},
+		{"not JSON", "send <mail>", `"send <mail>"`},
+		{"nested", `{"steps":[{"api_key":"k","urls":["a&b"]}],"auth":{"Password":"p"}}`,
+			`{"auth":{"Password":"[redacted]"},"steps":[{"api_key":"[redacted]","urls":["a&b"]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(redactJSON(tt.doc)); got != tt.want {
+				t.Errorf("redactJSON() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	completed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	rep := &protocol.ScenarioReport{
+		ScenarioID:  "scenario-1",
+		Name:        "After-action <review>",
+		Status:      "completed",
+		CompletedAt: &completed,
+		Steps: []protocol.ReportStep{
+			{Order: 1, ActionType: string(dsl.ActionSimulateBrowsing), Target: "role=a|b", RunAs: `CORP\jdoe`,
+				Parameters: map[string]interface{}{"urls": []interface{}{"https://a", "https://b"}}},
+		},
+		Failures: []protocol.TimelineEntry{{JobID: "job-1", Status: "failed", Error: "connection\nrefused"}},
+	}
+
+	var buf bytes.Buffer
+	if err := Render(&buf, rep, FormatMarkdown); err != nil {
+		t.Fatalf("Render(md) error = %v", err)
+	}
+	md := buf.String()
+	for _, want := range []string{"# After-action <review>", "## Plan and Execution", `role=a\|b`, `CORP\jdoe`, "urls=https://a, https://b", "2026-01-02 03:04:05Z"} {
+		if !strings.Contains(md, want) {
+			t.Errorf("Expected %q in the Markdown report:\n%s", want, md)
+		}
+	}
+
+	buf.Reset()
+	if err := Render(&buf, rep, FormatHTML); err != nil {
+		t.Fatalf("Render(html) error = %v", err)
+	}
+	html := buf.String()
+	if !strings.Contains(html, "After-action &lt;review&gt;") || strings.Contains(html, "<script") || strings.Contains(html, "<link") {
+		t.Errorf("Expected an escaped, self-contained HTML report:\n%s", html)
+	}
+
+	buf.Reset()
+	if err := Render(&buf, rep, FormatJSON); err != nil {
+		t.Fatalf("Render(json) error = %v", err)
+	}
+	var decoded protocol.ScenarioReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || decoded.ScenarioID != rep.ScenarioID {
+		t.Errorf("Expected the JSON report to round-trip, got %+v (err: %v)", decoded, err)
+	}
+
+	if err := Render(&buf, rep, "pdf"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
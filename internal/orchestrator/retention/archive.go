@@ -0,0 +1,61 @@
+package retention
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+)
+
+// writeArchive writes records as gzip-compressed JSON lines to a new file in
+// dir named <entity>-<UTC timestamp>-<suffix>.jsonl.gz, and returns its path.
+// The file is written under a temporary name and renamed once synced, so a
+// file with the final name is always complete.
+func writeArchive(dir, entity string, records []storage.Record) (string, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.jsonl.gz",
+		entity, time.Now().UTC().Format("20060102T150405Z"), uuid.New().String()[:8])
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return "", fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer os.Remove(tmp) // no-op once renamed
+
+	gz := gzip.NewWriter(f)
+	enc := json.NewEncoder(gz)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			f.Close()
+			return "", fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+
+	if err := gz.Close(); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to sync archive: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to close archive: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("failed to finalize archive: %w", err)
+	}
+
+	return path, nil
+}
@@ -0,0 +1,408 @@
+// Package retention enforces how long finished jobs, scenarios, webhook
+// deliveries, outbox entries and audit entries are kept, and performs routine
+// SQLite maintenance.
+//
+// A background janitor periodically selects rows older than their policy
+// allows, optionally writes them to compressed JSONL archives, and then
+// deletes them. The same janitor checkpoints the write-ahead log and vacuums
+// the database on their own schedules.
+//
+// Metrics are not stored in the database; they live in memory and are
+// exported at /metrics, so their retention belongs to the Prometheus server.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+)
+
+// Entities with retention policies, used in archive names and metrics.
+const (
+	EntityJobs              = "jobs"
+	EntityScenarios         = "scenarios"
+	EntityWebhookDeliveries = "webhook_deliveries"
+	EntityOutbox            = "outbox"
+	EntityAuditLog          = "audit_log"
+)
+
+// Statuses that can carry a retention policy. Only finished rows are ever
+// removed; pending and in-flight work is kept regardless of age.
+var (
+	jobStatuses      = []string{storage.JobStatusCompleted, storage.JobStatusFailed, storage.JobStatusCancelled}
+	scenarioStatuses = []string{storage.ScenarioStatusCompleted, storage.ScenarioStatusFailed}
+	deliveryStatuses = []string{storage.WebhookDeliverySucceeded, storage.WebhookDeliveryFailed}
+	outboxStatuses   = []string{storage.OutboxStatusDelivered, storage.OutboxStatusDead}
+)
+
+// Config holds retention policies and maintenance schedules. A zero duration
+// disables the corresponding policy or task.
+type Config struct {
+	// Interval is how often retention policies are enforced
+	Interval time.Duration
+
+	// BatchSize caps the rows archived and deleted per statement
+	BatchSize int
+
+	// ArchiveDir receives gzip-compressed JSONL archives of deleted rows;
+	// empty deletes without archiving
+	ArchiveDir string
+
+	// Jobs maps a finished job status to how long such jobs are kept
+	Jobs map[string]time.Duration
+
+	// Scenarios maps a finished scenario status to how long such scenarios
+	// (and their steps) are kept
+	Scenarios map[string]time.Duration
+
+	// WebhookDeliveries maps a finished delivery status to how long such
+	// deliveries are kept
+	WebhookDeliveries map[string]time.Duration
+
+	// Outbox maps a delivered or dead outbox status to how long such entries
+	// are kept
+	Outbox map[string]time.Duration
+
+	// AuditLog is how long audit entries are kept
+	AuditLog time.Duration
+
+	// CheckpointInterval is how often the WAL is checkpointed and truncated
+	CheckpointInterval time.Duration
+
+	// VacuumInterval is how often the database file is rebuilt
+	VacuumInterval time.Duration
+}
+
+// DefaultConfig returns sensible defaults. Nothing is deleted until a policy
+// is configured; only the WAL checkpoint runs.
+func DefaultConfig() Config {
+	return Config{
+		Interval:           time.Hour,
+		BatchSize:          500,
+		CheckpointInterval: 15 * time.Minute,
+	}
+}
+
+// Validate reports policies for unknown statuses or negative durations.
+func (c Config) Validate() error {
+	for _, policy := range []struct {
+		entity   string
+		allowed  []string
+		policies map[string]time.Duration
+	}{
+		{EntityJobs, jobStatuses, c.Jobs},
+		{EntityScenarios, scenarioStatuses, c.Scenarios},
+		{EntityWebhookDeliveries, deliveryStatuses, c.WebhookDeliveries},
+		{EntityOutbox, outboxStatuses, c.Outbox},
+	} {
+		for status, keep := range policy.policies {
+			if !contains(policy.allowed, status) {
+				return fmt.Errorf("retention: %s policy for unsupported status %q (allowed: %v)", policy.entity, status, policy.allowed)
+			}
+			if keep < 0 {
+				return fmt.Errorf("retention: %s policy for %q must not be negative", policy.entity, status)
+			}
+		}
+	}
+	if c.AuditLog < 0 {
+		return fmt.Errorf("retention: audit_log policy must not be negative")
+	}
+	return nil
+}
+
+// Result summarises one enforcement run.
+type Result struct {
+	// Deleted counts removed rows by entity
+	Deleted map[string]int
+
+	// Archives lists the archive files written
+	Archives []string
+}
+
+// Janitor enforces retention policies in the background.
+type Janitor struct {
+	db      *storage.DB
+	cfg     Config
+	metrics *metrics.Metrics
+	logger  zerolog.Logger
+
+	// mu serialises enforcement and maintenance runs
+	mu sync.Mutex
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New creates a janitor. Zero Interval and BatchSize fall back to the defaults.
+func New(db *storage.DB, cfg Config, logger zerolog.Logger) *Janitor {
+	defaults := DefaultConfig()
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaults.Interval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+
+	return &Janitor{
+		db:     db,
+		cfg:    cfg,
+		logger: logger.With().Str("component", "retention").Logger(),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// SetMetrics sets the metrics that deleted rows are recorded in.
+func (j *Janitor) SetMetrics(m *metrics.Metrics) {
+	j.metrics = m
+}
+
+// Start begins enforcing policies and running maintenance in the background.
+func (j *Janitor) Start(ctx context.Context) {
+	j.logger.Info().
+		Dur("interval", j.cfg.Interval).
+		Str("archive_dir", j.cfg.ArchiveDir).
+		Interface("jobs", j.cfg.Jobs).
+		Interface("scenarios", j.cfg.Scenarios).
+		Interface("webhook_deliveries", j.cfg.WebhookDeliveries).
+		Interface("outbox", j.cfg.Outbox).
+		Dur("audit_log", j.cfg.AuditLog).
+		Dur("checkpoint_interval", j.cfg.CheckpointInterval).
+		Dur("vacuum_interval", j.cfg.VacuumInterval).
+		Msg("Starting retention janitor")
+
+	ctx = storage.WithActor(ctx, storage.AuditActorSystem)
+
+	j.wg.Add(1)
+	go j.loop(ctx, j.cfg.Interval, func(ctx context.Context) {
+		if _, err := j.Enforce(ctx); err != nil {
+			j.logger.Error().Err(err).Msg("Failed to enforce retention policies")
+		}
+	})
+
+	if j.cfg.CheckpointInterval > 0 {
+		j.wg.Add(1)
+		go j.loop(ctx, j.cfg.CheckpointInterval, func(ctx context.Context) {
+			if err := j.withLock(func() error { return j.db.Checkpoint(ctx) }); err != nil {
+				j.logger.Warn().Err(err).Msg("WAL checkpoint failed")
+			}
+		})
+	}
+
+	if j.cfg.VacuumInterval > 0 {
+		j.wg.Add(1)
+		go j.loop(ctx, j.cfg.VacuumInterval, func(ctx context.Context) {
+			if err := j.withLock(func() error { return j.db.Vacuum(ctx) }); err != nil {
+				j.logger.Error().Err(err).Msg("Vacuum failed")
+			}
+		})
+	}
+}
+
+// Stop halts background work and waits for an in-progress run to finish.
+func (j *Janitor) Stop() {
+	j.logger.Info().Msg("Stopping retention janitor")
+	close(j.stopCh)
+	j.wg.Wait()
+}
+
+// loop calls fn every interval until stopped.
+func (j *Janitor) loop(ctx context.Context, interval time.Duration, fn func(context.Context)) {
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-j.stopCh:
+			return
+		case <-ticker.C:
+			fn(ctx)
+		}
+	}
+}
+
+func (j *Janitor) withLock(fn func() error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return fn()
+}
+
+// Enforce applies every configured policy once, archiving rows before they
+// are deleted. Jobs are purged before scenarios so a scenario's archive never
+// precedes that of its jobs.
+func (j *Janitor) Enforce(ctx context.Context) (*Result, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	result := &Result{Deleted: make(map[string]int)}
+	now := time.Now()
+
+	for _, status := range sortedKeys(j.cfg.Jobs) {
+		keep := j.cfg.Jobs[status]
+		if keep <= 0 {
+			continue
+		}
+		err := j.purge(ctx, result, EntityJobs, status, keep,
+			func(limit int) ([]storage.Record, error) {
+				return j.db.ExpiredJobs(ctx, status, now.Add(-keep), limit)
+			},
+			j.db.DeleteJobsByID)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	for _, status := range sortedKeys(j.cfg.Scenarios) {
+		keep := j.cfg.Scenarios[status]
+		if keep <= 0 {
+			continue
+		}
+		err := j.purge(ctx, result, EntityScenarios, status, keep,
+			func(limit int) ([]storage.Record, error) {
+				return j.db.ExpiredScenarios(ctx, status, now.Add(-keep), limit)
+			},
+			j.db.DeleteScenariosByID)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	for _, status := range sortedKeys(j.cfg.WebhookDeliveries) {
+		keep := j.cfg.WebhookDeliveries[status]
+		if keep <= 0 {
+			continue
+		}
+		err := j.purge(ctx, result, EntityWebhookDeliveries, status, keep,
+			func(limit int) ([]storage.Record, error) {
+				return j.db.ExpiredWebhookDeliveries(ctx, status, now.Add(-keep), limit)
+			},
+			j.db.DeleteWebhookDeliveriesByID)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	for _, status := range sortedKeys(j.cfg.Outbox) {
+		keep := j.cfg.Outbox[status]
+		if keep <= 0 {
+			continue
+		}
+		err := j.purge(ctx, result, EntityOutbox, status, keep,
+			func(limit int) ([]storage.Record, error) {
+				return j.db.ExpiredOutboxEntries(ctx, status, now.Add(-keep), limit)
+			},
+			j.db.DeleteOutboxEntriesByID)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	if keep := j.cfg.AuditLog; keep > 0 {
+		err := j.purge(ctx, result, EntityAuditLog, "", keep,
+			func(limit int) ([]storage.Record, error) {
+				return j.db.ExpiredAuditEntries(ctx, now.Add(-keep), limit)
+			},
+			j.db.DeleteAuditEntriesByID)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// purge repeatedly selects a batch of expired rows, archives it and deletes
+// it, until a short batch shows nothing is left.
+func (j *Janitor) purge(
+	ctx context.Context,
+	result *Result,
+	entity, status string,
+	keep time.Duration,
+	list func(limit int) ([]storage.Record, error),
+	remove func(ctx context.Context, ids []interface{}, metadata map[string]interface{}) (int, error),
+) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		records, err := list(j.cfg.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		metadata := map[string]interface{}{
+			"reason":    "retention",
+			"retention": keep.String(),
+		}
+		if status != "" {
+			metadata["status"] = status
+		}
+
+		// The archive is complete on disk before anything is deleted, so a
+		// failed write leaves the rows for the next run
+		if j.cfg.ArchiveDir != "" {
+			path, err := writeArchive(j.cfg.ArchiveDir, entity, records)
+			if err != nil {
+				return fmt.Errorf("failed to archive %s: %w", entity, err)
+			}
+			result.Archives = append(result.Archives, path)
+			metadata["archive"] = path
+		}
+
+		ids := make([]interface{}, len(records))
+		for i, record := range records {
+			ids[i] = record.ID()
+		}
+
+		n, err := remove(ctx, ids, metadata)
+		if err != nil {
+			return err
+		}
+		result.Deleted[entity] += n
+		j.metrics.RetentionDeleted(entity, n)
+
+		j.logger.Info().
+			Str("entity", entity).
+			Str("status", status).
+			Int("count", n).
+			Dur("retention", keep).
+			Interface("archive", metadata["archive"]).
+			Msg("Purged expired rows")
+
+		if len(records) < j.cfg.BatchSize {
+			return nil
+		}
+	}
+}
+
+func sortedKeys(m map[string]time.Duration) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,419 @@
+package retention
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+)
+
+// setupTestDB opens a fresh SQLite database in a temporary directory.
+func setupTestDB(t *testing.T) *storage.DB {
+	t.Helper()
+
+	db, err := storage.New(context.Background(), storage.Config{
+		Path: filepath.Join(t.TempDir(), "retention.db"),
+	}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// exec runs a statement against the test database.
+func exec(t *testing.T, db *storage.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.GetDB().Exec(query, args...); err != nil {
+		t.Fatalf("Failed to seed database: %v", err)
+	}
+}
+
+func insertJob(t *testing.T, db *storage.DB, id, status string, finished time.Time, scenarioID interface{}) {
+	exec(t, db, `
+		INSERT INTO jobs (id, scenario_id, agent_id, action_type, status, scheduled_at, completed_at, created_at, updated_at)
+		VALUES (?, ?, 'agent-1', 'simulate_browsing', ?, ?, ?, ?, ?)
+	`, id, scenarioID, status, finished.UTC(), finished.UTC(), finished.UTC(), finished.UTC())
+}
+
+func insertScenario(t *testing.T, db *storage.DB, id, status string, finished time.Time) {
+	exec(t, db, `
+		INSERT INTO scenarios (id, name, intent, status, completed_at, created_at, updated_at)
+		VALUES (?, ?, '{}', ?, ?, ?, ?)
+	`, id, "Scenario "+id, status, finished.UTC(), finished.UTC(), finished.UTC())
+	exec(t, db, `
+		INSERT INTO scenario_steps (id, scenario_id, step_order, action_type) VALUES (?, ?, 1, 'simulate_browsing')
+	`, id+"-step", id)
+}
+
+func insertDelivery(t *testing.T, db *storage.DB, id, status string, finished time.Time) {
+	exec(t, db, `
+		INSERT INTO webhook_deliveries (id, subscription_id, event_id, event_type, status, created_at, completed_at)
+		VALUES (?, 'sub-1', 1, 'job.completed', ?, ?, ?)
+	`, id, status, finished.UTC(), finished.UTC())
+}
+
+func insertOutbox(t *testing.T, db *storage.DB, id, status string, updated time.Time, delivered interface{}) {
+	exec(t, db, `
+		INSERT INTO outbox (id, event_id, target, event_type, payload, status, created_at, updated_at, delivered_at)
+		VALUES (?, ?, 'scoring', 'scenario.started', '{}', ?, ?, ?, ?)
+	`, id, "event-"+id, status, updated.UTC(), updated.UTC(), delivered)
+}
+
+// ids returns the sorted IDs left in table.
+func ids(t *testing.T, db *storage.DB, table string) []string {
+	t.Helper()
+	rows, err := db.GetDB().Query("SELECT id FROM " + table + " ORDER BY id")
+	if err != nil {
+		t.Fatalf("Failed to list %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, id)
+	}
+	return out
+}
+
+// readArchive returns the records in a gzip-compressed JSONL archive.
+func readArchive(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Archive is not gzip: %v", err)
+	}
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid archive line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	return records
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{
+			name: "all policies",
+			cfg: Config{
+				Jobs:              map[string]time.Duration{"completed": time.Hour, "failed": time.Hour, "cancelled": 0},
+				Scenarios:         map[string]time.Duration{"completed": time.Hour, "failed": time.Hour},
+				WebhookDeliveries: map[string]time.Duration{"succeeded": time.Hour, "failed": time.Hour},
+				Outbox:            map[string]time.Duration{"delivered": time.Hour, "dead": time.Hour},
+				AuditLog:          time.Hour,
+			},
+		},
+		{name: "empty", cfg: Config{}},
+		{
+			name:    "unfinished job status",
+			cfg:     Config{Jobs: map[string]time.Duration{"running": time.Hour}},
+			wantErr: `jobs policy for unsupported status "running"`,
+		},
+		{
+			name:    "negative scenario policy",
+			cfg:     Config{Scenarios: map[string]time.Duration{"completed": -time.Hour}},
+			wantErr: "scenarios policy for \"completed\" must not be negative",
+		},
+		{
+			name:    "pending webhook deliveries",
+			cfg:     Config{WebhookDeliveries: map[string]time.Duration{"pending": time.Hour}},
+			wantErr: `webhook_deliveries policy for unsupported status "pending"`,
+		},
+		{
+			name:    "pending outbox entries",
+			cfg:     Config{Outbox: map[string]time.Duration{"pending": time.Hour}},
+			wantErr: `outbox policy for unsupported status "pending"`,
+		},
+		{
+			name:    "negative audit log policy",
+			cfg:     Config{AuditLog: -time.Hour},
+			wantErr: "audit_log policy must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJanitor_EnforcePolicies(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	recent := now.Add(-time.Hour)
+
+	insertJob(t, db, "job-old-completed", storage.JobStatusCompleted, old, nil)
+	insertJob(t, db, "job-recent-completed", storage.JobStatusCompleted, recent, nil)
+	insertJob(t, db, "job-old-failed", storage.JobStatusFailed, old, nil) // no policy
+	insertJob(t, db, "job-old-pending", storage.JobStatusPending, old, "scenario-busy")
+
+	insertScenario(t, db, "scenario-done", storage.ScenarioStatusCompleted, old)
+	insertScenario(t, db, "scenario-busy", storage.ScenarioStatusCompleted, old) // has a pending job
+	insertScenario(t, db, "scenario-recent", storage.ScenarioStatusCompleted, recent)
+
+	insertDelivery(t, db, "delivery-old-succeeded", storage.WebhookDeliverySucceeded, old)
+	insertDelivery(t, db, "delivery-old-failed", storage.WebhookDeliveryFailed, old)
+	insertDelivery(t, db, "delivery-old-pending", storage.WebhookDeliveryPending, old)
+	insertDelivery(t, db, "delivery-recent-succeeded", storage.WebhookDeliverySucceeded, recent)
+
+	insertOutbox(t, db, "outbox-old-delivered", storage.OutboxStatusDelivered, old, old.UTC())
+	insertOutbox(t, db, "outbox-old-dead", storage.OutboxStatusDead, old, nil)
+	insertOutbox(t, db, "outbox-old-pending", storage.OutboxStatusPending, old, nil)
+	insertOutbox(t, db, "outbox-recent-delivered", storage.OutboxStatusDelivered, recent, recent.UTC())
+
+	janitor := New(db, Config{
+		Jobs:              map[string]time.Duration{storage.JobStatusCompleted: 24 * time.Hour, storage.JobStatusCancelled: 0},
+		Scenarios:         map[string]time.Duration{storage.ScenarioStatusCompleted: 24 * time.Hour},
+		WebhookDeliveries: map[string]time.Duration{storage.WebhookDeliverySucceeded: 24 * time.Hour, storage.WebhookDeliveryFailed: 72 * time.Hour},
+		Outbox:            map[string]time.Duration{storage.OutboxStatusDelivered: 24 * time.Hour, storage.OutboxStatusDead: 24 * time.Hour},
+	}, zerolog.Nop())
+
+	result, err := janitor.Enforce(ctx)
+	if err != nil {
+		t.Fatalf("Enforce() error = %v", err)
+	}
+
+	wantDeleted := map[string]int{
+		EntityJobs:              1,
+		EntityScenarios:         1,
+		EntityWebhookDeliveries: 1,
+		EntityOutbox:            2,
+	}
+	for entity, want := range wantDeleted {
+		if got := result.Deleted[entity]; got != want {
+			t.Errorf("Deleted[%s] = %d, want %d", entity, got, want)
+		}
+	}
+	if len(result.Archives) != 0 {
+		t.Errorf("Expected no archives without an archive directory, got %v", result.Archives)
+	}
+
+	remaining := map[string][]string{
+		"jobs":               {"job-old-failed", "job-old-pending", "job-recent-completed"},
+		"scenarios":          {"scenario-busy", "scenario-recent"},
+		"scenario_steps":     {"scenario-busy-step", "scenario-recent-step"},
+		"webhook_deliveries": {"delivery-old-failed", "delivery-old-pending", "delivery-recent-succeeded"},
+		"outbox":             {"outbox-old-pending", "outbox-recent-delivered"},
+	}
+	for table, want := range remaining {
+		if got := ids(t, db, table); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s left %v, want %v", table, got, want)
+		}
+	}
+
+	// Each deleted batch is audited with its reason
+	entries, err := db.ListAuditEntries(ctx, storage.AuditFilter{Action: storage.AuditActionDeleted})
+	if err != nil {
+		t.Fatalf("Failed to list audit entries: %v", err)
+	}
+	audited := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Metadata["reason"] == "retention" {
+			audited[entry.EntityType] = true
+		}
+	}
+	for _, entity := range []string{storage.AuditEntityJob, storage.AuditEntityScenario, storage.AuditEntityDelivery, storage.AuditEntityOutbox} {
+		if !audited[entity] {
+			t.Errorf("Expected a retention audit entry for %s", entity)
+		}
+	}
+
+	// A second run finds nothing left to delete
+	result, err = janitor.Enforce(ctx)
+	if err != nil {
+		t.Fatalf("Enforce() error = %v", err)
+	}
+	if len(result.Deleted) != 0 {
+		t.Errorf("Expected nothing deleted on the second run, got %v", result.Deleted)
+	}
+}
+
+func TestJanitor_ArchivesBeforeDelete(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	old := time.Now().Add(-48 * time.Hour)
+
+	insertScenario(t, db, "scenario-archived", storage.ScenarioStatusFailed, old)
+	insertOutbox(t, db, "outbox-archived", storage.OutboxStatusDead, old, nil)
+
+	dir := filepath.Join(t.TempDir(), "archive")
+	janitor := New(db, Config{
+		ArchiveDir: dir,
+		Scenarios:  map[string]time.Duration{storage.ScenarioStatusFailed: 24 * time.Hour},
+		Outbox:     map[string]time.Duration{storage.OutboxStatusDead: 24 * time.Hour},
+	}, zerolog.Nop())
+
+	result, err := janitor.Enforce(ctx)
+	if err != nil {
+		t.Fatalf("Enforce() error = %v", err)
+	}
+	if len(result.Archives) != 2 {
+		t.Fatalf("Expected 2 archives, got %v", result.Archives)
+	}
+
+	byEntity := make(map[string][]map[string]interface{})
+	for _, path := range result.Archives {
+		if filepath.Dir(path) != dir || !strings.HasSuffix(path, ".jsonl.gz") {
+			t.Errorf("Unexpected archive path %s", path)
+		}
+		entity := strings.SplitN(filepath.Base(path), "-", 2)[0]
+		byEntity[entity] = readArchive(t, path)
+	}
+
+	scenarios := byEntity[EntityScenarios]
+	if len(scenarios) != 1 || scenarios[0]["id"] != "scenario-archived" || scenarios[0]["status"] != storage.ScenarioStatusFailed {
+		t.Fatalf("Unexpected scenario archive: %v", scenarios)
+	}
+	steps, _ := scenarios[0]["steps"].([]interface{})
+	if len(steps) != 1 {
+		t.Errorf("Expected the archived scenario to carry its step, got %v", scenarios[0]["steps"])
+	}
+	if outbox := byEntity[EntityOutbox]; len(outbox) != 1 || outbox[0]["event_id"] != "event-outbox-archived" {
+		t.Errorf("Unexpected outbox archive: %v", outbox)
+	}
+
+	if left := ids(t, db, "scenarios"); len(left) != 0 {
+		t.Errorf("Expected archived scenarios to be deleted, got %v", left)
+	}
+
+	// No partial files are left behind
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".tmp") {
+			t.Errorf("Temporary archive %s left behind", f.Name())
+		}
+	}
+}
+
+func TestJanitor_KeepsRowsWhenArchiveFails(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	old := time.Now().Add(-48 * time.Hour)
+
+	insertJob(t, db, "job-kept", storage.JobStatusCompleted, old, nil)
+
+	// A file where the archive directory should be cannot be written to
+	dir := filepath.Join(t.TempDir(), "archive")
+	if err := os.WriteFile(dir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	janitor := New(db, Config{
+		ArchiveDir: dir,
+		Jobs:       map[string]time.Duration{storage.JobStatusCompleted: time.Hour},
+	}, zerolog.Nop())
+
+	if _, err := janitor.Enforce(ctx); err == nil {
+		t.Fatal("Expected Enforce() to fail when the archive cannot be written")
+	}
+	if left := ids(t, db, "jobs"); len(left) != 1 {
+		t.Errorf("Expected the job to be kept for the next run, got %v", left)
+	}
+}
+
+func TestJanitor_DeletesInBatches(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	old := time.Now().Add(-48 * time.Hour)
+
+	for i := 0; i < 5; i++ {
+		insertDelivery(t, db, fmt.Sprintf("delivery-%d", i), storage.WebhookDeliverySucceeded, old.Add(time.Duration(i)*time.Minute))
+	}
+
+	janitor := New(db, Config{
+		BatchSize:         2,
+		ArchiveDir:        t.TempDir(),
+		WebhookDeliveries: map[string]time.Duration{storage.WebhookDeliverySucceeded: time.Hour},
+	}, zerolog.Nop())
+
+	result, err := janitor.Enforce(ctx)
+	if err != nil {
+		t.Fatalf("Enforce() error = %v", err)
+	}
+	if result.Deleted[EntityWebhookDeliveries] != 5 {
+		t.Errorf("Expected 5 deliveries deleted, got %d", result.Deleted[EntityWebhookDeliveries])
+	}
+
+	// Batches of 2, 2 and 1, oldest first
+	if len(result.Archives) != 3 {
+		t.Fatalf("Expected 3 archives, got %v", result.Archives)
+	}
+	var sizes []int
+	var archived []string
+	for _, path := range result.Archives {
+		records := readArchive(t, path)
+		sizes = append(sizes, len(records))
+		for _, record := range records {
+			archived = append(archived, record["id"].(string))
+		}
+	}
+	if fmt.Sprint(sizes) != "[2 2 1]" {
+		t.Errorf("Expected batch sizes [2 2 1], got %v", sizes)
+	}
+	want := []string{"delivery-0", "delivery-1", "delivery-2", "delivery-3", "delivery-4"}
+	if strings.Join(archived, ",") != strings.Join(want, ",") {
+		t.Errorf("Archived %v, want %v", archived, want)
+	}
+	if left := ids(t, db, "webhook_deliveries"); len(left) != 0 {
+		t.Errorf("Expected every delivery deleted, got %v", left)
+	}
+}
+
+func TestJanitor_StopsWhenCancelled(t *testing.T) {
+	db := setupTestDB(t)
+	insertJob(t, db, "job-cancelled-run", storage.JobStatusCompleted, time.Now().Add(-48*time.Hour), nil)
+
+	janitor := New(db, Config{Jobs: map[string]time.Duration{storage.JobStatusCompleted: time.Hour}}, zerolog.Nop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := janitor.Enforce(ctx); err == nil {
+		t.Error("Expected Enforce() to stop on a cancelled context")
+	}
+	if left := ids(t, db, "jobs"); len(left) != 1 {
+		t.Errorf("Expected the job to be kept, got %v", left)
+	}
+}
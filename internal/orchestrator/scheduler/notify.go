@@ -0,0 +1,51 @@
+package scheduler
+
+import "sync"
+
+// jobNotifier lets long-poll and WebSocket waiters block until jobs are
+// created for their agent.
+type jobNotifier struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func newJobNotifier() *jobNotifier {
+	return &jobNotifier{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// subscribe registers a waiter for the agent. The returned channel receives a
+// value whenever notify is called for the agent; the function removes the waiter.
+func (n *jobNotifier) subscribe(agentID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	n.mu.Lock()
+	if n.waiters[agentID] == nil {
+		n.waiters[agentID] = make(map[chan struct{}]struct{})
+	}
+	n.waiters[agentID][ch] = struct{}{}
+	n.mu.Unlock()
+
+	return ch, func() {
+		n.mu.Lock()
+		delete(n.waiters[agentID], ch)
+		if len(n.waiters[agentID]) == 0 {
+			delete(n.waiters, agentID)
+		}
+		n.mu.Unlock()
+	}
+}
+
+// notify wakes every waiter for the agent without blocking.
+func (n *jobNotifier) notify(agentID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for ch := range n.waiters[agentID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+	"cymbytes.com/cymconductor/internal/orchestrator/scoring"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/pkg/dsl"
+)
+
+// Scorecard grades a scenario's objectives against its jobs' latest results.
+// A scenario without objectives gets an empty scorecard.
+func (s *Scheduler) Scorecard(ctx context.Context, scenario *storage.Scenario) (*scoring.Scorecard, error) {
+	card, _, err := s.scorecard(ctx, scenario, time.Now())
+	return card, err
+}
+
+// scorecard evaluates the scenario's objectives at now and also returns the
+// jobs they were evaluated against (nil if there are no objectives).
+// Deadlines count from the scenario's creation, which is when it became
+// active and its jobs were created.
+func (s *Scheduler) scorecard(ctx context.Context, scenario *storage.Scenario, now time.Time) (*scoring.Scorecard, []*storage.Job, error) {
+	objectives, err := s.scenarioObjectives(scenario)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(objectives) == 0 {
+		return &scoring.Scorecard{}, nil, nil
+	}
+
+	jobs, err := s.db.ListJobsByScenario(ctx, scenario.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list scenario jobs: %w", err)
+	}
+	return scoring.EvaluateObjectives(objectives, jobs, scenario.CreatedAt, now), jobs, nil
+}
+
+// scenarioObjectives returns the compiled objectives of a scenario. Those of
+// active scenarios are compiled once and kept until the scenario finishes.
+func (s *Scheduler) scenarioObjectives(scenario *storage.Scenario) ([]*scoring.Objective, error) {
+	s.objectivesMu.Lock()
+	objectives, ok := s.compiled[scenario.ID]
+	s.objectivesMu.Unlock()
+	if ok {
+		return objectives, nil
+	}
+
+	objectives, err := compileObjectives(scenario)
+	if err != nil {
+		return nil, err
+	}
+	if scenario.Status == storage.ScenarioStatusActive {
+		s.objectivesMu.Lock()
+		s.compiled[scenario.ID] = objectives
+		s.objectivesMu.Unlock()
+	}
+	return objectives, nil
+}
+
+// compileObjectives compiles the objectives in a scenario's validated DSL.
+func compileObjectives(scenario *storage.Scenario) ([]*scoring.Objective, error) {
+	if scenario.ValidatedDSL == nil {
+		return nil, nil
+	}
+
+	var doc dsl.Scenario
+	if err := json.Unmarshal([]byte(*scenario.ValidatedDSL), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario DSL: %w", err)
+	}
+	return scoring.CompileObjectives(&doc)
+}
+
+// checkObjectives grades the objectives that depend on a job that just
+// reported a result. If a completed job's result does not pass an objective
+// that is still pending and allows re-runs, the job is run again after the
+// objective's retry interval, as long as that is before its deadline.
+func (s *Scheduler) checkObjectives(ctx context.Context, job *storage.Job) {
+	if job.ScenarioID == nil || job.ScenarioStepID == nil {
+		return
+	}
+
+	scenario, err := s.db.GetScenario(ctx, *job.ScenarioID)
+	if err != nil || scenario == nil {
+		s.logger.Warn().Err(err).Str("job_id", job.ID).Msg("Failed to get scenario for objectives")
+		return
+	}
+
+	now := time.Now()
+	card, jobs, err := s.scorecard(ctx, scenario, now)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("scenario_id", scenario.ID).Msg("Failed to evaluate objectives")
+		return
+	}
+
+	var current *storage.Job
+	for _, j := range jobs {
+		if j.ID == job.ID {
+			current = j
+		}
+	}
+
+	var rerunAt *time.Time
+	var rerunFor string
+	if current != nil && current.Status == storage.JobStatusCompleted {
+		for _, result := range card.Objectives {
+			o := result.Objective
+			if result.Status != scoring.ObjectivePending || o.RetryIntervalSeconds == 0 ||
+				!o.Covers(*job.ScenarioStepID) || o.Passes(current) {
+				continue
+			}
+			at := now.Add(time.Duration(o.RetryIntervalSeconds) * time.Second)
+			if result.Deadline == nil || at.After(*result.Deadline) {
+				continue
+			}
+			if rerunAt == nil || at.Before(*rerunAt) {
+				rerunAt = &at
+				rerunFor = o.ID
+			}
+		}
+	}
+
+	if rerunAt != nil {
+		if err := s.db.RerunJob(ctx, job.ID, rerunAt.UTC()); err != nil {
+			s.logger.Warn().Err(err).Str("job_id", job.ID).Msg("Failed to re-run job for objective")
+		} else {
+			s.logger.Info().
+				Str("job_id", job.ID).
+				Str("objective_id", rerunFor).
+				Time("retry_at", *rerunAt).
+				Msg("Objective not passed yet, job will run again")
+
+			s.metrics.JobTransition(metrics.JobRetried, job.ActionType, 1)
+			s.publishJob(events.JobRetryScheduled, job, map[string]interface{}{
+				"objective_id": rerunFor,
+				"retry_at":     rerunAt.UTC(),
+			})
+		}
+	}
+
+	s.publishObjectives(scenario, card)
+}
+
+// checkObjectiveDeadlines grades the objectives of active scenarios whose
+// deadline passed without their outcome being reported, so those that did not
+// pass in time are reported as failed. Other scenarios are skipped without
+// listing their jobs.
+func (s *Scheduler) checkObjectiveDeadlines(ctx context.Context) error {
+	scenarios, err := s.db.ListScenarios(ctx, "", storage.ScenarioStatusActive, -1)
+	if err != nil {
+		return err
+	}
+	s.forgetInactiveObjectives(scenarios)
+
+	now := time.Now()
+	for _, scenario := range scenarios {
+		objectives, err := s.scenarioObjectives(scenario)
+		if err != nil {
+			s.logger.Warn().Err(err).Str("scenario_id", scenario.ID).Msg("Failed to compile objectives")
+			continue
+		}
+		if !s.deadlinePassed(scenario, objectives, now) {
+			continue
+		}
+
+		card, _, err := s.scorecard(ctx, scenario, now)
+		if err != nil {
+			s.logger.Warn().Err(err).Str("scenario_id", scenario.ID).Msg("Failed to evaluate objectives")
+			continue
+		}
+		s.publishObjectives(scenario, card)
+	}
+	return nil
+}
+
+// deadlinePassed reports whether any of the scenario's objectives reached its
+// deadline by now without its outcome having been reported.
+func (s *Scheduler) deadlinePassed(scenario *storage.Scenario, objectives []*scoring.Objective, now time.Time) bool {
+	s.objectivesMu.Lock()
+	defer s.objectivesMu.Unlock()
+
+	reported := s.objectives[scenario.ID]
+	for _, o := range objectives {
+		if deadline := o.Deadline(scenario.CreatedAt); deadline != nil && !now.Before(*deadline) && !reported[o.ID] {
+			return true
+		}
+	}
+	return false
+}
+
+// publishObjectives publishes objective.passed and objective.failed for the
+// objectives that reached a final status since they were last reported.
+// Outcomes are remembered in memory only, so an objective that finished
+// before a restart may be reported again once.
+func (s *Scheduler) publishObjectives(scenario *storage.Scenario, card *scoring.Scorecard) {
+	for _, result := range card.Objectives {
+		if result.Status == scoring.ObjectivePending {
+			continue
+		}
+
+		s.objectivesMu.Lock()
+		reported := s.objectives[scenario.ID]
+		if reported == nil {
+			reported = make(map[string]bool)
+			s.objectives[scenario.ID] = reported
+		}
+		seen := reported[result.Objective.ID]
+		reported[result.Objective.ID] = true
+		s.objectivesMu.Unlock()
+		if seen {
+			continue
+		}
+
+		eventType := events.ObjectivePassed
+		if result.Status == scoring.ObjectiveFailed {
+			eventType = events.ObjectiveFailed
+		}
+		data := map[string]interface{}{
+			"objective_id": result.Objective.ID,
+			"name":         result.Objective.Name,
+			"weight":       result.Objective.Weight,
+			"score":        result.Score,
+		}
+		if result.PassedAt != nil {
+			data["passed_at"] = result.PassedAt.UTC()
+		}
+		s.events.Publish(events.Event{
+			Type:       eventType,
+			LabID:      scenario.LabID,
+			ScenarioID: scenario.ID,
+			Data:       data,
+		})
+
+		s.logger.Info().
+			Str("scenario_id", scenario.ID).
+			Str("objective_id", result.Objective.ID).
+			Str("status", result.Status).
+			Msg("Objective graded")
+	}
+}
+
+// forgetObjectives drops the compiled objectives and reported outcomes of a
+// finished scenario.
+func (s *Scheduler) forgetObjectives(scenarioID string) {
+	s.objectivesMu.Lock()
+	delete(s.compiled, scenarioID)
+	delete(s.objectives, scenarioID)
+	s.objectivesMu.Unlock()
+}
+
+// forgetInactiveObjectives drops what is kept for scenarios that are no longer
+// active, e.g. because they were deleted.
+func (s *Scheduler) forgetInactiveObjectives(active []*storage.Scenario) {
+	ids := make(map[string]bool, len(active))
+	for _, scenario := range active {
+		ids[scenario.ID] = true
+	}
+
+	s.objectivesMu.Lock()
+	defer s.objectivesMu.Unlock()
+	for id := range s.compiled {
+		if !ids[id] {
+			delete(s.compiled, id)
+		}
+	}
+	for id := range s.objectives {
+		if !ids[id] {
+			delete(s.objectives, id)
+		}
+	}
+}
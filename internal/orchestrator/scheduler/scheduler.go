@@ -3,10 +3,14 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+	"cymbytes.com/cymconductor/internal/orchestrator/outbox"
 	"cymbytes.com/cymconductor/internal/orchestrator/scoring"
 	"cymbytes.com/cymconductor/internal/orchestrator/storage"
 	"cymbytes.com/cymconductor/internal/orchestrator/webhooks"
@@ -14,16 +18,42 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// ErrStopped is returned by WaitForJobs once the scheduler has been stopped.
+var ErrStopped = errors.New("scheduler stopped")
+
+// ErrScoringDisabled is returned by CreateScoringRun when no scoring engine is
+// configured.
+var ErrScoringDisabled = errors.New("scoring engine integration is disabled")
+
+// ErrInvalidJobState is returned by RetryJob and CancelJob when the job's
+// status does not allow the change.
+var ErrInvalidJobState = errors.New("invalid job state")
+
 // Scheduler manages job scheduling and dispatch.
 type Scheduler struct {
-	db                 *storage.DB
+	db                 storage.Store
 	logger             zerolog.Logger
 	scoringForwarder   *scoring.EventForwarder
 	messengerForwarder *webhooks.Forwarder
+	outbox             *outbox.Worker
+	events             *events.Bus
+	metrics            *metrics.Metrics
 
-	// Configuration
+	// Configuration (changed at runtime by UpdateConfig)
+	configMu        sync.RWMutex
 	pollInterval    time.Duration
 	maxJobsPerAgent int
+	longPollMax     time.Duration
+	reconfigured    chan struct{}
+
+	// Wakes agents blocked in WaitForJobs when jobs are created
+	notifier *jobNotifier
+
+	// Compiled objectives of active scenarios, and the objective outcomes
+	// published since startup, by scenario (and objective)
+	objectivesMu sync.Mutex
+	compiled     map[string][]*scoring.Objective
+	objectives   map[string]map[string]bool
 
 	// Background worker
 	stopCh chan struct{}
@@ -37,6 +67,10 @@ type Config struct {
 
 	// MaxJobsPerAgent is the maximum jobs to assign per poll
 	MaxJobsPerAgent int
+
+	// LongPollMax caps how long WaitForJobs blocks. Keep it below the HTTP
+	// server's read and write timeouts.
+	LongPollMax time.Duration
 }
 
 // DefaultConfig returns sensible defaults.
@@ -44,11 +78,16 @@ func DefaultConfig() Config {
 	return Config{
 		PollInterval:    time.Second,
 		MaxJobsPerAgent: 5,
+		LongPollMax:     25 * time.Second,
 	}
 }
 
 // New creates a new scheduler.
-func New(db *storage.DB, cfg Config, logger zerolog.Logger) *Scheduler {
+func New(db storage.Store, cfg Config, logger zerolog.Logger) *Scheduler {
+	if cfg.LongPollMax <= 0 {
+		cfg.LongPollMax = DefaultConfig().LongPollMax
+	}
+
 	return &Scheduler{
 		db:                 db,
 		logger:             logger.With().Str("component", "scheduler").Logger(),
@@ -56,10 +95,52 @@ func New(db *storage.DB, cfg Config, logger zerolog.Logger) *Scheduler {
 		messengerForwarder: nil,
 		pollInterval:       cfg.PollInterval,
 		maxJobsPerAgent:    cfg.MaxJobsPerAgent,
+		longPollMax:        cfg.LongPollMax,
+		reconfigured:       make(chan struct{}, 1),
+		notifier:           newJobNotifier(),
+		compiled:           make(map[string][]*scoring.Objective),
+		objectives:         make(map[string]map[string]bool),
 		stopCh:             make(chan struct{}),
 	}
 }
 
+// UpdateConfig applies a new configuration to a running scheduler. The loop
+// picks up a new poll interval on its next tick; long polls already waiting
+// keep the limits they started with.
+func (s *Scheduler) UpdateConfig(cfg Config) {
+	if cfg.LongPollMax <= 0 {
+		cfg.LongPollMax = DefaultConfig().LongPollMax
+	}
+
+	s.configMu.Lock()
+	s.pollInterval = cfg.PollInterval
+	s.maxJobsPerAgent = cfg.MaxJobsPerAgent
+	s.longPollMax = cfg.LongPollMax
+	s.configMu.Unlock()
+
+	select {
+	case s.reconfigured <- struct{}{}:
+	default:
+	}
+
+	s.logger.Info().
+		Dur("poll_interval", cfg.PollInterval).
+		Int("max_jobs_per_agent", cfg.MaxJobsPerAgent).
+		Dur("long_poll_max", cfg.LongPollMax).
+		Msg("Scheduler configuration updated")
+}
+
+// config returns the current configuration.
+func (s *Scheduler) config() Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return Config{
+		PollInterval:    s.pollInterval,
+		MaxJobsPerAgent: s.maxJobsPerAgent,
+		LongPollMax:     s.longPollMax,
+	}
+}
+
 // SetScoringForwarder sets the scoring event forwarder.
 func (s *Scheduler) SetScoringForwarder(forwarder *scoring.EventForwarder) {
 	s.scoringForwarder = forwarder
@@ -72,11 +153,28 @@ func (s *Scheduler) SetMessengerForwarder(forwarder *webhooks.Forwarder) {
 	s.logger.Info().Bool("enabled", forwarder != nil && forwarder.IsEnabled()).Msg("Messenger forwarder configured")
 }
 
+// SetOutbox sets the worker that delivers the scoring and messenger events
+// the scheduler writes to the outbox. It is woken whenever events are added.
+func (s *Scheduler) SetOutbox(worker *outbox.Worker) {
+	s.outbox = worker
+}
+
+// SetEventBus sets the bus that job and scenario events are published to.
+func (s *Scheduler) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// SetMetrics sets the metrics that job transitions are recorded in.
+func (s *Scheduler) SetMetrics(m *metrics.Metrics) {
+	s.metrics = m
+}
+
 // Start begins the scheduler background loop.
 func (s *Scheduler) Start(ctx context.Context) {
+	cfg := s.config()
 	s.logger.Info().
-		Dur("poll_interval", s.pollInterval).
-		Int("max_jobs_per_agent", s.maxJobsPerAgent).
+		Dur("poll_interval", cfg.PollInterval).
+		Int("max_jobs_per_agent", cfg.MaxJobsPerAgent).
 		Msg("Starting scheduler")
 
 	s.wg.Add(1)
@@ -94,7 +192,7 @@ func (s *Scheduler) Stop() {
 func (s *Scheduler) schedulerLoop(ctx context.Context) {
 	defer s.wg.Done()
 
-	ticker := time.NewTicker(s.pollInterval)
+	ticker := time.NewTicker(s.config().PollInterval)
 	defer ticker.Stop()
 
 	for {
@@ -103,7 +201,14 @@ func (s *Scheduler) schedulerLoop(ctx context.Context) {
 			return
 		case <-s.stopCh:
 			return
+		case <-s.reconfigured:
+			ticker.Reset(s.config().PollInterval)
 		case <-ticker.C:
+			// Grade objectives whose deadlines passed
+			if err := s.checkObjectiveDeadlines(ctx); err != nil {
+				s.logger.Error().Err(err).Msg("Failed to check objective deadlines")
+			}
+
 			// Check for completed scenarios
 			if err := s.checkScenarioCompletion(ctx); err != nil {
 				s.logger.Error().Err(err).Msg("Failed to check scenario completion")
@@ -114,42 +219,64 @@ func (s *Scheduler) schedulerLoop(ctx context.Context) {
 
 // checkScenarioCompletion checks if active scenarios are complete.
 func (s *Scheduler) checkScenarioCompletion(ctx context.Context) error {
-	scenarios, err := s.db.ListScenarios(ctx, storage.ScenarioStatusActive, 100)
+	scenarios, err := s.db.ListScenarios(ctx, "", storage.ScenarioStatusActive, 100)
 	if err != nil {
 		return err
 	}
 
 	for _, scenario := range scenarios {
-		total, completed, failed, _, pending, err := s.db.GetScenarioJobStats(ctx, scenario.ID)
-		if err != nil {
-			s.logger.Error().Err(err).Str("scenario_id", scenario.ID).Msg("Failed to get job stats")
-			continue
-		}
-
-		// Scenario is complete when all jobs are done (completed or failed)
-		if pending == 0 && total > 0 && (completed+failed) == total {
-			if err := s.db.UpdateScenarioCompleted(ctx, scenario.ID); err != nil {
-				s.logger.Error().Err(err).Str("scenario_id", scenario.ID).Msg("Failed to complete scenario")
-			} else {
-				s.logger.Info().
-					Str("scenario_id", scenario.ID).
-					Int("completed", completed).
-					Int("failed", failed).
-					Msg("Scenario completed")
-
-				// Forward to messenger (async)
-				s.forwardScenarioCompletedToMessenger(ctx, scenario.ID, scenario.Name, completed, failed)
-			}
-		}
+		s.completeScenario(ctx, scenario)
 	}
 
 	return nil
 }
 
+// completeScenario marks an active scenario completed once all its jobs are
+// finished (completed, failed or cancelled).
+func (s *Scheduler) completeScenario(ctx context.Context, scenario *storage.Scenario) {
+	total, completed, failed, cancelled, _, _, err := s.db.GetScenarioJobStats(ctx, scenario.ID)
+	if err != nil {
+		s.logger.Error().Err(err).Str("scenario_id", scenario.ID).Msg("Failed to get job stats")
+		return
+	}
+	if total == 0 || completed+failed+cancelled != total {
+		return
+	}
+
+	entries := s.scenarioCompletedOutbox(scenario, completed, failed)
+	if err := s.db.UpdateScenarioCompleted(ctx, scenario.ID, entries...); err != nil {
+		s.logger.Error().Err(err).Str("scenario_id", scenario.ID).Msg("Failed to complete scenario")
+		return
+	}
+
+	s.logger.Info().
+		Str("scenario_id", scenario.ID).
+		Int("completed", completed).
+		Int("failed", failed).
+		Int("cancelled", cancelled).
+		Msg("Scenario completed")
+
+	data := map[string]interface{}{
+		"name":      scenario.Name,
+		"completed": completed,
+		"failed":    failed,
+		"cancelled": cancelled,
+	}
+	if card, _, err := s.scorecard(ctx, scenario, time.Now()); err == nil && card.MaxScore > 0 {
+		data["score"] = card.Score
+		data["max_score"] = card.MaxScore
+	}
+	s.forgetObjectives(scenario.ID)
+
+	s.publishStatusChange(scenario, storage.ScenarioStatusCompleted)
+	s.publishScenario(events.ScenarioCompleted, scenario, data)
+	s.notifyOutbox(entries)
+}
+
 // GetNextJobsForAgent retrieves and assigns the next jobs for an agent.
 func (s *Scheduler) GetNextJobsForAgent(ctx context.Context, agentID string, max int) ([]protocol.JobAssignment, bool, error) {
-	if max > s.maxJobsPerAgent {
-		max = s.maxJobsPerAgent
+	if limit := s.config().MaxJobsPerAgent; max > limit {
+		max = limit
 	}
 
 	// Get pending jobs for this agent
@@ -168,6 +295,18 @@ func (s *Scheduler) GetNextJobsForAgent(ctx context.Context, agentID string, max
 		jobs = jobs[:max]
 	}
 
+	// Look up each scenario once, for the assignments
+	scenarios := make(map[string]*storage.Scenario)
+	for _, job := range jobs {
+		if job.ScenarioID == nil {
+			continue
+		}
+		if _, ok := scenarios[*job.ScenarioID]; !ok {
+			scenario, _ := s.db.GetScenario(ctx, *job.ScenarioID)
+			scenarios[*job.ScenarioID] = scenario
+		}
+	}
+
 	// Mark jobs as assigned
 	jobIDs := make([]string, len(jobs))
 	for i, job := range jobs {
@@ -180,7 +319,12 @@ func (s *Scheduler) GetNextJobsForAgent(ctx context.Context, agentID string, max
 
 	// Convert to response format
 	assignments := make([]protocol.JobAssignment, len(jobs))
+	now := time.Now()
 	for i, job := range jobs {
+		s.publishJob(events.JobAssigned, job, nil)
+		s.metrics.JobTransition(metrics.JobAssigned, job.ActionType, 1)
+		s.metrics.ObserveDispatch(job.ActionType, now.Sub(job.ScheduledAt))
+
 		assignments[i] = protocol.JobAssignment{
 			JobID:       job.ID,
 			ActionType:  job.ActionType,
@@ -192,12 +336,20 @@ func (s *Scheduler) GetNextJobsForAgent(ctx context.Context, agentID string, max
 		// Add scenario context if available
 		if job.ScenarioID != nil {
 			assignments[i].ScenarioID = *job.ScenarioID
-			// Optionally fetch scenario name
-			scenario, _ := s.db.GetScenario(ctx, *job.ScenarioID)
-			if scenario != nil {
+			if scenario := scenarios[*job.ScenarioID]; scenario != nil {
 				assignments[i].ScenarioName = scenario.Name
 			}
 		}
+		if job.ScenarioStepID != nil {
+			assignments[i].StepID = *job.ScenarioStepID
+		}
+
+		if job.RunAsUser != nil && *job.RunAsUser != "" {
+			assignments[i].RunAs = &protocol.RunAsConfig{User: *job.RunAsUser}
+			if job.RunAsLogonType != nil {
+				assignments[i].RunAs.LogonType = *job.RunAsLogonType
+			}
+		}
 	}
 
 	s.logger.Debug().
@@ -209,6 +361,71 @@ func (s *Scheduler) GetNextJobsForAgent(ctx context.Context, agentID string, max
 	return assignments, hasMore, nil
 }
 
+// WaitForJobs is the long-poll variant of GetNextJobsForAgent. It blocks until
+// at least one job is due for the agent, the timeout (capped at LongPollMax)
+// passes, or ctx is done. New jobs wake the caller immediately; jobs that
+// become due later are picked up within the poll interval.
+func (s *Scheduler) WaitForJobs(ctx context.Context, agentID string, max int, timeout time.Duration) ([]protocol.JobAssignment, bool, error) {
+	cfg := s.config()
+	if timeout > cfg.LongPollMax {
+		timeout = cfg.LongPollMax
+	}
+	deadline := time.Now().Add(timeout)
+
+	wake, unsubscribe := s.notifier.subscribe(agentID)
+	defer unsubscribe()
+
+	for {
+		jobs, hasMore, err := s.GetNextJobsForAgent(ctx, agentID, max)
+		if err != nil || len(jobs) > 0 {
+			return jobs, hasMore, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, false, nil
+		}
+
+		wait := cfg.PollInterval
+		if remaining < wait {
+			wait = remaining
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, false, nil
+		case <-s.stopCh:
+			timer.Stop()
+			return nil, false, ErrStopped
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// LongPollMax returns the longest wait WaitForJobs honours.
+func (s *Scheduler) LongPollMax() time.Duration {
+	return s.config().LongPollMax
+}
+
+// NotifyJobsAvailable wakes any WaitForJobs call blocked for the agent.
+func (s *Scheduler) NotifyJobsAvailable(agentID string) {
+	s.notifier.notify(agentID)
+}
+
+// ReleaseJobs returns jobs assigned to the agent that could not be delivered
+// to it to pending, so they are dispatched on its next poll.
+func (s *Scheduler) ReleaseJobs(ctx context.Context, agentID string, jobIDs []string) error {
+	if err := s.db.UnassignJobs(ctx, jobIDs); err != nil {
+		return fmt.Errorf("failed to release jobs: %w", err)
+	}
+	s.notifier.notify(agentID)
+	return nil
+}
+
 // ProcessJobResult handles the result of a completed job.
 func (s *Scheduler) ProcessJobResult(ctx context.Context, agentID, jobID string, req *protocol.JobResultRequest) (retryScheduled bool, retryAt *time.Time, err error) {
 	// Get job to verify ownership and existence
@@ -217,7 +434,7 @@ func (s *Scheduler) ProcessJobResult(ctx context.Context, agentID, jobID string,
 		return false, nil, fmt.Errorf("failed to get job: %w", err)
 	}
 	if job == nil {
-		return false, nil, fmt.Errorf("job not found: %s", jobID)
+		return false, nil, fmt.Errorf("%w: %s", storage.ErrJobNotFound, jobID)
 	}
 
 	// Verify agent owns this job
@@ -225,6 +442,13 @@ func (s *Scheduler) ProcessJobResult(ctx context.Context, agentID, jobID string,
 		return false, nil, fmt.Errorf("job %s not assigned to agent %s", jobID, agentID)
 	}
 
+	// Record when the agent started the job, for the scenario timeline
+	if !req.StartedAt.IsZero() && (req.Status == "completed" || req.Status == "failed") {
+		if err := s.db.UpdateJobStarted(ctx, jobID, req.StartedAt); err != nil {
+			s.logger.Warn().Err(err).Str("job_id", jobID).Msg("Failed to record job start time")
+		}
+	}
+
 	// Update job based on status
 	switch req.Status {
 	case "completed":
@@ -232,7 +456,8 @@ func (s *Scheduler) ProcessJobResult(ctx context.Context, agentID, jobID string,
 		if req.Result != nil {
 			result = req.Result.Data
 		}
-		if err := s.db.UpdateJobCompleted(ctx, jobID, req.CompletedAt, result); err != nil {
+		entries := s.jobResultOutbox(ctx, job, req)
+		if err := s.db.UpdateJobCompleted(ctx, jobID, req.CompletedAt, result, entries...); err != nil {
 			return false, nil, fmt.Errorf("failed to update job completed: %w", err)
 		}
 
@@ -242,21 +467,25 @@ func (s *Scheduler) ProcessJobResult(ctx context.Context, agentID, jobID string,
 			Str("action", job.ActionType).
 			Msg("Job completed successfully")
 
-		// Forward to scoring engine (async)
-		s.forwardJobResultToScoring(ctx, job, req)
-
-		// Forward to messenger (async)
-		s.forwardJobResultToMessenger(ctx, job, req)
+		s.publishJob(events.JobCompleted, job, nil)
+		s.metrics.JobTransition(metrics.JobCompleted, job.ActionType, 1)
+		if d, ok := resultDuration(req); ok {
+			s.metrics.ObserveJobDuration(job.ActionType, req.Status, d)
+		}
+		s.notifyOutbox(entries)
+		s.checkObjectives(ctx, job)
 
 	case "failed":
-		var errMsg string
+		var errMsg, errCode string
 		var retryable bool
 		if req.Error != nil {
 			errMsg = req.Error.Message
+			errCode = req.Error.Code
 			retryable = req.Error.Retryable
 		}
 
-		if err := s.db.UpdateJobFailed(ctx, jobID, req.CompletedAt, errMsg, retryable); err != nil {
+		entries := s.jobResultOutbox(ctx, job, req)
+		if err := s.db.UpdateJobFailed(ctx, jobID, req.CompletedAt, errMsg, retryable, entries...); err != nil {
 			return false, nil, fmt.Errorf("failed to update job failed: %w", err)
 		}
 
@@ -265,6 +494,11 @@ func (s *Scheduler) ProcessJobResult(ctx context.Context, agentID, jobID string,
 			retryScheduled = true
 			t := time.Now().Add(time.Duration(job.RetryCount+1) * 30 * time.Second) // Exponential backoff
 			retryAt = &t
+
+			// Hold the retry back until retryAt so pushed jobs don't retry back-to-back
+			if err := s.db.RescheduleJob(ctx, jobID, t.UTC()); err != nil {
+				s.logger.Warn().Err(err).Str("job_id", jobID).Msg("Failed to delay job retry")
+			}
 		}
 
 		s.logger.Warn().
@@ -275,11 +509,26 @@ func (s *Scheduler) ProcessJobResult(ctx context.Context, agentID, jobID string,
 			Bool("retry_scheduled", retryScheduled).
 			Msg("Job failed")
 
-		// Forward failure to scoring engine (async)
-		s.forwardJobResultToScoring(ctx, job, req)
+		s.metrics.JobFailed(job.ActionType, errCode, retryScheduled)
+		if d, ok := resultDuration(req); ok {
+			s.metrics.ObserveJobDuration(job.ActionType, req.Status, d)
+		}
 
-		// Forward failure to messenger (async)
-		s.forwardJobResultToMessenger(ctx, job, req)
+		if retryScheduled {
+			s.metrics.JobTransition(metrics.JobRetried, job.ActionType, 1)
+			s.publishJob(events.JobRetryScheduled, job, map[string]interface{}{
+				"error":    errMsg,
+				"attempt":  job.RetryCount + 1,
+				"retry_at": retryAt.UTC(),
+			})
+		} else {
+			s.metrics.JobTransition(metrics.JobFailed, job.ActionType, 1)
+			s.publishJob(events.JobFailed, job, map[string]interface{}{"error": errMsg})
+		}
+		s.notifyOutbox(entries)
+		if !retryScheduled {
+			s.checkObjectives(ctx, job)
+		}
 
 	default:
 		return false, nil, fmt.Errorf("invalid status: %s", req.Status)
@@ -288,10 +537,28 @@ func (s *Scheduler) ProcessJobResult(ctx context.Context, agentID, jobID string,
 	return retryScheduled, retryAt, nil
 }
 
-// forwardJobResultToScoring forwards job results to the scoring engine asynchronously.
-func (s *Scheduler) forwardJobResultToScoring(ctx context.Context, job *storage.Job, req *protocol.JobResultRequest) {
-	if s.scoringForwarder == nil {
-		return
+// jobResultOutbox builds the outbox entries that forward a job result to the
+// scoring engine and messenger. The event ID is derived from the job and its
+// run, so a result reported twice maps to the same event while a retry or
+// re-run gets a new one.
+func (s *Scheduler) jobResultOutbox(ctx context.Context, job *storage.Job, req *protocol.JobResultRequest) []*storage.OutboxEntry {
+	eventID := fmt.Sprintf("job-%s-%d", job.ID, job.RunCount)
+
+	var entries []*storage.OutboxEntry
+	if entry := s.scoringOutboxEntry(ctx, eventID, job, req); entry != nil {
+		entries = append(entries, entry)
+	}
+	if entry := s.messengerOutboxEntry(eventID, job, req); entry != nil {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// scoringOutboxEntry builds the scoring engine event for a job result, or
+// returns nil if the result is not forwarded.
+func (s *Scheduler) scoringOutboxEntry(ctx context.Context, eventID string, job *storage.Job, req *protocol.JobResultRequest) *storage.OutboxEntry {
+	if s.scoringForwarder == nil || !s.scoringForwarder.IsEnabled() {
+		return nil
 	}
 
 	// Get scenario to find scoring run ID
@@ -300,7 +567,7 @@ func (s *Scheduler) forwardJobResultToScoring(ctx context.Context, job *storage.
 		scenario, err := s.db.GetScenario(ctx, *job.ScenarioID)
 		if err != nil {
 			s.logger.Warn().Err(err).Str("scenario_id", *job.ScenarioID).Msg("Failed to get scenario for scoring")
-			return
+			return nil
 		}
 		if scenario != nil && scenario.ScoringRunID != nil {
 			scoringRunID = *scenario.ScoringRunID
@@ -309,7 +576,7 @@ func (s *Scheduler) forwardJobResultToScoring(ctx context.Context, job *storage.
 
 	if scoringRunID == "" {
 		s.logger.Debug().Str("job_id", job.ID).Msg("No scoring run ID, skipping event forwarding")
-		return
+		return nil
 	}
 
 	// Build job info
@@ -346,39 +613,217 @@ func (s *Scheduler) forwardJobResultToScoring(ctx context.Context, job *storage.
 		Error:       errMsg,
 	}
 
-	// Forward asynchronously
-	go func() {
-		forwardCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+	event, ok := s.scoringForwarder.NewJobResultEvent(eventID, jobInfo, jobResult)
+	if !ok {
+		s.logger.Debug().
+			Str("job_id", job.ID).
+			Str("action_type", job.ActionType).
+			Msg("No scoring rule matches the result, skipping event forwarding")
+		return nil
+	}
 
-		if err := s.scoringForwarder.ForwardJobResult(forwardCtx, scoringRunID, jobInfo, jobResult); err != nil {
-			s.logger.Error().
-				Err(err).
-				Str("job_id", job.ID).
-				Str("scoring_run_id", scoringRunID).
-				Msg("Failed to forward job result to scoring engine")
-		}
-	}()
+	entry, err := storage.NewOutboxEntry(storage.OutboxTargetScoring, scoringRunID, event.EventID, event.EventType, event)
+	if err != nil {
+		s.logger.Error().Err(err).Str("job_id", job.ID).Msg("Failed to build scoring event")
+		return nil
+	}
+	return entry
 }
 
 // CreateJob creates a new job.
 func (s *Scheduler) CreateJob(ctx context.Context, job *storage.Job) error {
-	return s.db.CreateJob(ctx, job)
+	if err := s.db.CreateJob(ctx, job); err != nil {
+		return err
+	}
+	s.publishJob(events.JobCreated, job, nil)
+	s.metrics.JobTransition(metrics.JobCreated, job.ActionType, 1)
+	s.notifier.notify(job.AgentID)
+	return nil
 }
 
 // CreateJobs creates multiple jobs in a batch.
 func (s *Scheduler) CreateJobs(ctx context.Context, jobs []*storage.Job) error {
-	return s.db.CreateJobBatch(ctx, jobs)
+	if err := s.db.CreateJobBatch(ctx, jobs); err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		s.publishJob(events.JobCreated, job, nil)
+		s.metrics.JobTransition(metrics.JobCreated, job.ActionType, 1)
+		s.notifier.notify(job.AgentID)
+	}
+	return nil
 }
 
 // CancelScenarioJobs cancels all pending jobs for a scenario.
 func (s *Scheduler) CancelScenarioJobs(ctx context.Context, scenarioID string) (int, error) {
-	return s.db.CancelJobsForScenario(ctx, scenarioID)
+	cancelled, err := s.db.CancelJobsForScenario(ctx, scenarioID)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for actionType, n := range cancelled {
+		s.metrics.JobTransition(metrics.JobCancelled, actionType, n)
+		count += n
+	}
+
+	if count > 0 {
+		var labID string
+		if scenario, _ := s.db.GetScenario(ctx, scenarioID); scenario != nil {
+			labID = scenario.LabID
+		}
+		s.events.Publish(events.Event{
+			Type:       events.JobCancelled,
+			LabID:      labID,
+			ScenarioID: scenarioID,
+			Data:       map[string]interface{}{"count": count},
+		})
+	}
+
+	return count, nil
+}
+
+// ListJobs returns one page of jobs matching the filter.
+func (s *Scheduler) ListJobs(ctx context.Context, filter storage.JobFilter) ([]*storage.Job, *storage.JobCursor, error) {
+	return s.db.ListJobs(ctx, filter)
+}
+
+// RetryJob manually requeues a failed or cancelled job to run now. The
+// job's automatic retry count is left unchanged.
+func (s *Scheduler) RetryJob(ctx context.Context, jobID string) (*storage.Job, error) {
+	job, err := s.db.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrJobNotFound, jobID)
+	}
+	if job.Status != storage.JobStatusFailed && job.Status != storage.JobStatusCancelled {
+		return nil, fmt.Errorf("%w: job %s cannot be retried while %s", ErrInvalidJobState, jobID, job.Status)
+	}
+
+	if err := s.db.RequeueJob(ctx, jobID, job.Status); err != nil {
+		if errors.Is(err, storage.ErrJobNotFound) {
+			// The job changed state since it was read
+			return nil, fmt.Errorf("%w: job %s cannot be retried: status changed", ErrInvalidJobState, jobID)
+		}
+		return nil, err
+	}
+
+	s.publishJob(events.JobRetryScheduled, job, map[string]interface{}{"manual": true})
+	s.metrics.JobTransition(metrics.JobRetried, job.ActionType, 1)
+	s.notifier.notify(job.AgentID)
+
+	s.logger.Info().
+		Str("job_id", jobID).
+		Str("previous_status", job.Status).
+		Msg("Job manually retried")
+
+	return s.db.GetJob(ctx, jobID)
+}
+
+// CancelJob cancels a job that has not started running yet.
+func (s *Scheduler) CancelJob(ctx context.Context, jobID string) (*storage.Job, error) {
+	job, err := s.db.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrJobNotFound, jobID)
+	}
+	if job.Status != storage.JobStatusPending && job.Status != storage.JobStatusAssigned {
+		return nil, fmt.Errorf("%w: job %s cannot be cancelled while %s", ErrInvalidJobState, jobID, job.Status)
+	}
+
+	if err := s.db.CancelJob(ctx, jobID, job.Status); err != nil {
+		if errors.Is(err, storage.ErrJobNotFound) {
+			// The job changed state since it was read
+			return nil, fmt.Errorf("%w: job %s cannot be cancelled: status changed", ErrInvalidJobState, jobID)
+		}
+		return nil, err
+	}
+
+	s.publishJob(events.JobCancelled, job, nil)
+	s.metrics.JobTransition(metrics.JobCancelled, job.ActionType, 1)
+
+	s.logger.Info().
+		Str("job_id", jobID).
+		Str("previous_status", job.Status).
+		Msg("Job cancelled")
+
+	// Cancelling a scenario's last unfinished job completes the scenario
+	if job.ScenarioID != nil {
+		scenario, err := s.db.GetScenario(ctx, *job.ScenarioID)
+		if err != nil {
+			s.logger.Warn().Err(err).Str("scenario_id", *job.ScenarioID).Msg("Failed to get scenario for completion")
+		} else if scenario != nil && scenario.Status == storage.ScenarioStatusActive {
+			s.completeScenario(ctx, scenario)
+		}
+	}
+
+	return s.db.GetJob(ctx, jobID)
+}
+
+// resultDuration returns the execution time reported by the agent, falling
+// back to the started/completed timestamps if no duration was reported.
+func resultDuration(req *protocol.JobResultRequest) (time.Duration, bool) {
+	if req.Result != nil && req.Result.DurationMs > 0 {
+		return time.Duration(req.Result.DurationMs) * time.Millisecond, true
+	}
+	if req.StartedAt.IsZero() || req.CompletedAt.Before(req.StartedAt) {
+		return 0, false
+	}
+	return req.CompletedAt.Sub(req.StartedAt), true
+}
+
+// publishJob publishes a job state transition.
+func (s *Scheduler) publishJob(eventType string, job *storage.Job, data map[string]interface{}) {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["action_type"] = job.ActionType
+
+	e := events.Event{
+		Type:    eventType,
+		LabID:   job.LabID,
+		AgentID: job.AgentID,
+		JobID:   job.ID,
+		Data:    data,
+	}
+	if job.ScenarioID != nil {
+		e.ScenarioID = *job.ScenarioID
+	}
+	s.events.Publish(e)
+}
+
+// publishScenario publishes a scenario lifecycle event.
+func (s *Scheduler) publishScenario(eventType string, scenario *storage.Scenario, data map[string]interface{}) {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["name"] = scenario.Name
+
+	s.events.Publish(events.Event{
+		Type:       eventType,
+		LabID:      scenario.LabID,
+		ScenarioID: scenario.ID,
+		Data:       data,
+	})
+}
+
+// publishStatusChange publishes a scenario's move from its current status to
+// status.
+func (s *Scheduler) publishStatusChange(scenario *storage.Scenario, status string) {
+	s.publishScenario(events.ScenarioStatusChanged, scenario, map[string]interface{}{
+		"from": scenario.Status,
+		"to":   status,
+	})
 }
 
 // GetJobStats returns job statistics.
-func (s *Scheduler) GetJobStats(ctx context.Context) (map[string]int, error) {
-	return s.db.CountJobsByStatus(ctx)
+// An empty labID counts jobs in all labs.
+func (s *Scheduler) GetJobStats(ctx context.Context, labID string) (map[string]int, error) {
+	return s.db.CountJobsByStatus(ctx, labID)
 }
 
 // CleanupOldJobs removes old completed/failed jobs.
@@ -386,35 +831,160 @@ func (s *Scheduler) CleanupOldJobs(ctx context.Context, olderThan time.Duration)
 	return s.db.CleanupOldJobs(ctx, olderThan)
 }
 
-// forwardScenarioCompletedToMessenger forwards scenario completion to the messenger asynchronously.
-func (s *Scheduler) forwardScenarioCompletedToMessenger(ctx context.Context, scenarioID, scenarioName string, completed, failed int) {
-	if s.messengerForwarder == nil {
-		return
+// scenarioCompletedOutbox builds the outbox entries that forward a scenario's
+// completion to the scoring engine and messenger.
+func (s *Scheduler) scenarioCompletedOutbox(scenario *storage.Scenario, completed, failed int) []*storage.OutboxEntry {
+	var entries []*storage.OutboxEntry
+
+	if runID := s.scoringRunID(scenario.ScoringRunID); runID != "" {
+		event := scoring.NewScenarioCompletedEvent(scenarioInfo(scenario), completed, failed)
+		entries = s.appendOutbox(entries, storage.OutboxTargetScoring, runID, event.EventID, event.EventType, event, scenario.ID)
+	}
+
+	if s.messengerForwarder != nil && s.messengerForwarder.IsEnabled() {
+		event := webhooks.NewScenarioCompletedEvent(scenario.LabID, scenario.ID, scenario.Name, completed, failed)
+		entries = s.appendOutbox(entries, storage.OutboxTargetMessenger, scenario.LabID, event.EventID, event.EventType, event, scenario.ID)
+	}
+
+	return entries
+}
+
+// scenarioStartedOutbox builds the outbox entries that announce the start of
+// a scenario with total jobs to its scoring engine run (if it has one) and
+// messenger.
+func (s *Scheduler) scenarioStartedOutbox(scenario *storage.Scenario, total int) []*storage.OutboxEntry {
+	scoringRun := s.scoringRunID(scenario.ScoringRunID)
+	messenger := s.messengerForwarder != nil && s.messengerForwarder.IsEnabled()
+	if scoringRun == "" && !messenger {
+		return nil
+	}
+
+	var entries []*storage.OutboxEntry
+	if scoringRun != "" {
+		event := scoring.NewScenarioStartedEvent(scenarioInfo(scenario), total)
+		entries = s.appendOutbox(entries, storage.OutboxTargetScoring, scoringRun, event.EventID, event.EventType, event, scenario.ID)
+	}
+	if messenger {
+		event := webhooks.NewScenarioStartedEvent(scenario.LabID, scenario.ID, scenario.Name, total)
+		entries = s.appendOutbox(entries, storage.OutboxTargetMessenger, scenario.LabID, event.EventID, event.EventType, event, scenario.ID)
+	}
+	return entries
+}
+
+// appendOutbox builds an outbox entry for event and appends it to entries,
+// logging and skipping it if the event cannot be encoded.
+func (s *Scheduler) appendOutbox(entries []*storage.OutboxEntry, target, destination, eventID, eventType string, event interface{}, scenarioID string) []*storage.OutboxEntry {
+	entry, err := storage.NewOutboxEntry(target, destination, eventID, eventType, event)
+	if err != nil {
+		s.logger.Error().Err(err).Str("scenario_id", scenarioID).Str("target", target).Msg("Failed to build scenario event")
+		return entries
+	}
+	return append(entries, entry)
+}
+
+// scoringRunID returns the run a scenario's events go to, or "" if the
+// scenario has no run or scoring is disabled.
+func (s *Scheduler) scoringRunID(runID *string) string {
+	if runID == nil || s.scoringForwarder == nil || !s.scoringForwarder.IsEnabled() {
+		return ""
+	}
+	return *runID
+}
+
+// CreateScenario stores a validated scenario as active together with its
+// steps and jobs, and announces its start to its scoring run and messenger.
+// Everything is written in one transaction, so a failure leaves no partial
+// scenario behind. This is the only place a scenario starts, so its started
+// events are written once.
+func (s *Scheduler) CreateScenario(ctx context.Context, scenario *storage.Scenario, steps []*storage.ScenarioStep, jobs []*storage.Job) error {
+	entries := s.scenarioStartedOutbox(scenario, len(jobs))
+	if err := s.db.CreateActiveScenario(ctx, scenario, steps, jobs, entries...); err != nil {
+		return err
+	}
+	s.notifyOutbox(entries)
+
+	s.publishScenario(events.ScenarioCreated, scenario, map[string]interface{}{
+		"source": scenario.Source,
+		"status": scenario.Status,
+	})
+	for _, job := range jobs {
+		s.publishJob(events.JobCreated, job, nil)
+		s.metrics.JobTransition(metrics.JobCreated, job.ActionType, 1)
+		s.notifier.notify(job.AgentID)
+	}
+	s.publishScenario(events.ScenarioStarted, scenario, nil)
+	return nil
+}
+
+// AttachScoringRun routes a scenario's scoring events to runID. If the
+// scenario's jobs are already being dispatched, the run is sent the
+// scenario-started event it missed; results reported before the run was
+// attached are not re-sent.
+func (s *Scheduler) AttachScoringRun(ctx context.Context, scenario *storage.Scenario, runID string) error {
+	var entries []*storage.OutboxEntry
+	if s.scoringRunID(&runID) != "" {
+		dispatched, err := s.scenarioDispatched(ctx, scenario.ID)
+		if err != nil {
+			return err
+		}
+		if dispatched {
+			total, _, _, _, _, _, _ := s.db.GetScenarioJobStats(ctx, scenario.ID)
+			event := scoring.NewScenarioStartedEvent(scenarioInfo(scenario), total)
+			entries = s.appendOutbox(entries, storage.OutboxTargetScoring, runID, event.EventID, event.EventType, event, scenario.ID)
+		}
 	}
 
-	go func() {
-		forwardCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+	if err := s.db.SetScenarioScoringRunID(ctx, scenario.ID, runID, entries...); err != nil {
+		return err
+	}
+	s.notifyOutbox(entries)
+	return nil
+}
+
+// CreateScoringRun opens a run for the scenario on the scoring engine and
+// returns its ID.
+func (s *Scheduler) CreateScoringRun(ctx context.Context, scenario *storage.Scenario) (string, error) {
+	if s.scoringForwarder == nil || !s.scoringForwarder.IsEnabled() {
+		return "", ErrScoringDisabled
+	}
+	return s.scoringForwarder.CreateRun(ctx, scenarioInfo(scenario))
+}
 
-		if err := s.messengerForwarder.ForwardScenarioCompleted(forwardCtx, scenarioID, scenarioName, completed, failed); err != nil {
-			s.logger.Error().
-				Err(err).
-				Str("scenario_id", scenarioID).
-				Msg("Failed to forward scenario completion to messenger")
+// scenarioDispatched reports whether any of the scenario's jobs has been
+// assigned to an agent.
+func (s *Scheduler) scenarioDispatched(ctx context.Context, scenarioID string) (bool, error) {
+	jobs, err := s.db.ListJobsByScenario(ctx, scenarioID)
+	if err != nil {
+		return false, fmt.Errorf("failed to list scenario jobs: %w", err)
+	}
+	for _, job := range jobs {
+		if job.AssignedAt != nil {
+			return true, nil
 		}
-	}()
+	}
+	return false, nil
 }
 
-// forwardJobResultToMessenger forwards job results to the messenger asynchronously.
-func (s *Scheduler) forwardJobResultToMessenger(ctx context.Context, job *storage.Job, req *protocol.JobResultRequest) {
-	if s.messengerForwarder == nil {
-		return
+func scenarioInfo(scenario *storage.Scenario) *scoring.ScenarioInfo {
+	return &scoring.ScenarioInfo{
+		ScenarioID: scenario.ID,
+		LabID:      scenario.LabID,
+		Name:       scenario.Name,
+	}
+}
+
+// messengerOutboxEntry builds the messenger event for a job result, or
+// returns nil if the messenger is disabled.
+func (s *Scheduler) messengerOutboxEntry(eventID string, job *storage.Job, req *protocol.JobResultRequest) *storage.OutboxEntry {
+	if s.messengerForwarder == nil || !s.messengerForwarder.IsEnabled() {
+		return nil
 	}
 
 	// Build job info
 	jobInfo := &webhooks.JobInfo{
 		JobID:       job.ID,
 		AgentID:     job.AgentID,
+		LabID:       job.LabID,
 		ActionType:  job.ActionType,
 		Parameters:  job.Parameters,
 		ScheduledAt: job.ScheduledAt,
@@ -445,16 +1015,18 @@ func (s *Scheduler) forwardJobResultToMessenger(ctx context.Context, job *storag
 		Error:       errMsg,
 	}
 
-	// Forward asynchronously
-	go func() {
-		forwardCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+	event := webhooks.NewJobEvent(eventID, jobInfo, jobResult)
+	entry, err := storage.NewOutboxEntry(storage.OutboxTargetMessenger, job.LabID, event.EventID, event.EventType, event)
+	if err != nil {
+		s.logger.Error().Err(err).Str("job_id", job.ID).Msg("Failed to build messenger event")
+		return nil
+	}
+	return entry
+}
 
-		if err := s.messengerForwarder.ForwardJobCompleted(forwardCtx, jobInfo, jobResult); err != nil {
-			s.logger.Error().
-				Err(err).
-				Str("job_id", job.ID).
-				Msg("Failed to forward job result to messenger")
-		}
-	}()
+// notifyOutbox wakes the outbox worker if entries were written.
+func (s *Scheduler) notifyOutbox(entries []*storage.OutboxEntry) {
+	if len(entries) > 0 && s.outbox != nil {
+		s.outbox.Notify()
+	}
 }
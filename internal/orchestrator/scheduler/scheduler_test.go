@@ -0,0 +1,388 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+	"cymbytes.com/cymconductor/internal/orchestrator/scoring"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/internal/orchestrator/webhooks"
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// newTestScheduler creates a scheduler backed by the in-memory store, with
+// the messenger enabled so job results are written to the outbox
+func newTestScheduler(t *testing.T) (*Scheduler, *storage.Memory) {
+	t.Helper()
+
+	db := storage.NewMemory(zerolog.Nop())
+	t.Cleanup(func() { db.Close() })
+
+	s := New(db, DefaultConfig(), zerolog.Nop())
+	s.SetMessengerForwarder(webhooks.NewForwarder(webhooks.Config{
+		Enabled:      true,
+		MessengerURL: "http://messenger.invalid",
+	}, zerolog.Nop()))
+	return s, db
+}
+
+// createTestAgent creates an online agent
+func createTestAgent(t *testing.T, db storage.Store, agentID string) {
+	t.Helper()
+
+	err := db.CreateAgent(context.Background(), &storage.Agent{ID: agentID, LabHostID: agentID, Hostname: agentID, Status: "online"})
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+}
+
+// createTestJob creates a due job for the agent, in the scenario if
+// scenarioID is set
+func createTestJob(t *testing.T, db storage.Store, jobID, agentID, scenarioID string) {
+	t.Helper()
+
+	job := &storage.Job{
+		ID:          jobID,
+		AgentID:     agentID,
+		ActionType:  "test_action",
+		Status:      storage.JobStatusPending,
+		MaxRetries:  3,
+		ScheduledAt: time.Now().UTC().Add(-time.Minute),
+	}
+	if scenarioID != "" {
+		job.ScenarioID = &scenarioID
+	}
+	if err := db.CreateJob(context.Background(), job); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+}
+
+// createActiveScenario creates an active scenario
+func createActiveScenario(t *testing.T, db storage.Store, scenarioID string) {
+	t.Helper()
+
+	ctx := context.Background()
+	err := db.CreateScenario(ctx, &storage.Scenario{ID: scenarioID, Name: scenarioID, Intent: "{}", Source: storage.ScenarioSourceAPI, Status: storage.ScenarioStatusValidated})
+	if err != nil {
+		t.Fatalf("Failed to create scenario: %v", err)
+	}
+	if err := db.UpdateScenarioActive(ctx, scenarioID); err != nil {
+		t.Fatalf("Failed to activate scenario: %v", err)
+	}
+}
+
+// runTestJob dispatches the agent's next job and reports status for it
+func runTestJob(t *testing.T, s *Scheduler, agentID, jobID, status string) {
+	t.Helper()
+
+	ctx := context.Background()
+	jobs, _, err := s.GetNextJobsForAgent(ctx, agentID, 1)
+	if err != nil || len(jobs) != 1 || jobs[0].JobID != jobID {
+		t.Fatalf("Expected %s to be dispatched, got %+v (err: %v)", jobID, jobs, err)
+	}
+
+	req := &protocol.JobResultRequest{Status: status, StartedAt: time.Now(), CompletedAt: time.Now()}
+	if status == storage.JobStatusFailed {
+		req.Error = &protocol.JobError{Code: "ERR", Message: "boom"}
+	}
+	if _, _, err := s.ProcessJobResult(ctx, agentID, jobID, req); err != nil {
+		t.Fatalf("Failed to process result: %v", err)
+	}
+}
+
+func TestJobResultOutbox_EachRunGetsItsOwnEvent(t *testing.T) {
+	s, db := newTestScheduler(t)
+	ctx := context.Background()
+	createTestAgent(t, db, "agent-runs")
+	createTestJob(t, db, "job-runs", "agent-runs", "")
+
+	// A failed run, a manual retry that completes, and an objective re-run
+	runTestJob(t, s, "agent-runs", "job-runs", storage.JobStatusFailed)
+	if _, err := s.RetryJob(ctx, "job-runs"); err != nil {
+		t.Fatalf("Failed to retry job: %v", err)
+	}
+	runTestJob(t, s, "agent-runs", "job-runs", storage.JobStatusCompleted)
+	if err := db.RerunJob(ctx, "job-runs", time.Now().UTC().Add(-time.Second)); err != nil {
+		t.Fatalf("Failed to re-run job: %v", err)
+	}
+	runTestJob(t, s, "agent-runs", "job-runs", storage.JobStatusCompleted)
+
+	entries, err := db.ListOutboxEntries(ctx, storage.OutboxFilter{Target: storage.OutboxTargetMessenger})
+	if err != nil {
+		t.Fatalf("Failed to list outbox: %v", err)
+	}
+	eventIDs := make(map[string]string)
+	for _, entry := range entries {
+		eventIDs[entry.EventID] = entry.EventType
+	}
+	want := map[string]string{
+		"job-job-runs-0": "job.failed",
+		"job-job-runs-1": "job.completed",
+		"job-job-runs-2": "job.completed",
+	}
+	if len(eventIDs) != len(want) {
+		t.Fatalf("Expected %d result events, got %v", len(want), eventIDs)
+	}
+	for id, eventType := range want {
+		if eventIDs[id] != eventType {
+			t.Errorf("Expected %s for event %s, got %q", eventType, id, eventIDs[id])
+		}
+	}
+}
+
+func TestCancelJob_CompletesScenario(t *testing.T) {
+	s, db := newTestScheduler(t)
+	ctx := context.Background()
+	createTestAgent(t, db, "agent-cancel")
+	createActiveScenario(t, db, "scenario-cancel")
+	createTestJob(t, db, "job-done", "agent-cancel", "scenario-cancel")
+	runTestJob(t, s, "agent-cancel", "job-done", storage.JobStatusCompleted)
+	createTestJob(t, db, "job-cancel", "agent-cancel", "scenario-cancel")
+
+	if err := s.checkScenarioCompletion(ctx); err != nil {
+		t.Fatalf("Failed to check completion: %v", err)
+	}
+	if scenario, _ := db.GetScenario(ctx, "scenario-cancel"); scenario.Status != storage.ScenarioStatusActive {
+		t.Fatalf("Expected the scenario to stay active with a pending job, got %s", scenario.Status)
+	}
+
+	if _, err := s.CancelJob(ctx, "job-cancel"); err != nil {
+		t.Fatalf("Failed to cancel job: %v", err)
+	}
+	total, completed, _, cancelled, _, _, err := db.GetScenarioJobStats(ctx, "scenario-cancel")
+	if err != nil || total != 2 || completed != 1 || cancelled != 1 {
+		t.Errorf("Unexpected job stats: total=%d completed=%d cancelled=%d (err: %v)", total, completed, cancelled, err)
+	}
+	if scenario, _ := db.GetScenario(ctx, "scenario-cancel"); scenario.Status != storage.ScenarioStatusCompleted {
+		t.Errorf("Expected cancelling the last job to complete the scenario, got %s", scenario.Status)
+	}
+}
+
+func TestRetryAndCancelJob_Errors(t *testing.T) {
+	s, db := newTestScheduler(t)
+	ctx := context.Background()
+	createTestAgent(t, db, "agent-errors")
+	createTestJob(t, db, "job-errors", "agent-errors", "")
+
+	tests := []struct {
+		name   string
+		change func(ctx context.Context, jobID string) (*storage.Job, error)
+		jobID  string
+		want   error
+	}{
+		{name: "retry missing job", change: s.RetryJob, jobID: "job-missing", want: storage.ErrJobNotFound},
+		{name: "cancel missing job", change: s.CancelJob, jobID: "job-missing", want: storage.ErrJobNotFound},
+		{name: "retry pending job", change: s.RetryJob, jobID: "job-errors", want: ErrInvalidJobState},
+		{name: "cancel pending job", change: s.CancelJob, jobID: "job-errors"},
+		{name: "cancel cancelled job", change: s.CancelJob, jobID: "job-errors", want: ErrInvalidJobState},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.change(ctx, tt.jobID)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestCreateScenario_StartsOnce(t *testing.T) {
+	s, db := newTestScheduler(t)
+	ctx := context.Background()
+	createTestAgent(t, db, "agent-start")
+
+	scenarioID := "scenario-start"
+	scenario := &storage.Scenario{ID: scenarioID, Name: scenarioID, Intent: "{}", Source: storage.ScenarioSourceAPI}
+	var jobs []*storage.Job
+	for _, id := range []string{"job-start-1", "job-start-2"} {
+		jobs = append(jobs, &storage.Job{
+			ID:          id,
+			ScenarioID:  &scenarioID,
+			AgentID:     "agent-start",
+			ActionType:  "test_action",
+			Status:      storage.JobStatusPending,
+			ScheduledAt: time.Now().UTC().Add(-time.Minute),
+		})
+	}
+	if err := s.CreateScenario(ctx, scenario, nil, jobs); err != nil {
+		t.Fatalf("Failed to create scenario: %v", err)
+	}
+	if stored, _ := db.GetScenario(ctx, scenarioID); stored == nil || stored.Status != storage.ScenarioStatusActive {
+		t.Fatalf("Expected an active scenario, got %+v", stored)
+	}
+
+	// Dispatching the jobs, including from a scheduler started after a
+	// restart, does not announce the scenario again
+	runTestJob(t, s, "agent-start", "job-start-1", storage.JobStatusCompleted)
+	restarted := New(db, DefaultConfig(), zerolog.Nop())
+	restarted.SetMessengerForwarder(s.messengerForwarder)
+	runTestJob(t, restarted, "agent-start", "job-start-2", storage.JobStatusCompleted)
+
+	entries, err := db.ListOutboxEntries(ctx, storage.OutboxFilter{Target: storage.OutboxTargetMessenger})
+	if err != nil {
+		t.Fatalf("Failed to list outbox: %v", err)
+	}
+	started := 0
+	for _, entry := range entries {
+		if entry.EventType == "scenario.started" {
+			started++
+		}
+	}
+	if started != 1 {
+		t.Errorf("Expected one scenario.started event, got %d", started)
+	}
+}
+
+func TestReleaseJobs(t *testing.T) {
+	s, db := newTestScheduler(t)
+	ctx := context.Background()
+	createTestAgent(t, db, "agent-release")
+	createTestJob(t, db, "job-release", "agent-release", "")
+
+	jobs, _, err := s.GetNextJobsForAgent(ctx, "agent-release", 1)
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Expected job-release to be dispatched, got %+v (err: %v)", jobs, err)
+	}
+
+	// The push to the agent failed, so the job is dispatched on its next poll
+	if err := s.ReleaseJobs(ctx, "agent-release", []string{"job-release"}); err != nil {
+		t.Fatalf("Failed to release jobs: %v", err)
+	}
+	runTestJob(t, s, "agent-release", "job-release", storage.JobStatusCompleted)
+}
+
+func TestScheduler_RecordsJobMetrics(t *testing.T) {
+	s, db := newTestScheduler(t)
+	ctx := context.Background()
+	m := metrics.New()
+	s.SetMetrics(m)
+	createTestAgent(t, db, "agent-metrics")
+
+	if err := s.CreateJob(ctx, &storage.Job{
+		ID:          "job-metrics",
+		AgentID:     "agent-metrics",
+		ActionType:  "test_action",
+		Status:      storage.JobStatusPending,
+		MaxRetries:  3,
+		ScheduledAt: time.Now().UTC().Add(-time.Minute),
+	}); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	if jobs, _, err := s.GetNextJobsForAgent(ctx, "agent-metrics", 1); err != nil || len(jobs) != 1 {
+		t.Fatalf("Expected job-metrics to be dispatched, got %+v (err: %v)", jobs, err)
+	}
+	now := time.Now()
+	if _, _, err := s.ProcessJobResult(ctx, "agent-metrics", "job-metrics", &protocol.JobResultRequest{
+		Status:      storage.JobStatusFailed,
+		StartedAt:   now.Add(-2 * time.Second),
+		CompletedAt: now,
+		Error:       &protocol.JobError{Code: "TIMEOUT", Message: "timed out", Retryable: true},
+	}); err != nil {
+		t.Fatalf("Failed to process result: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`cymconductor_jobs_total{action_type="test_action",status="created"} 1`,
+		`cymconductor_jobs_total{action_type="test_action",status="assigned"} 1`,
+		`cymconductor_jobs_total{action_type="test_action",status="retried"} 1`,
+		`cymconductor_job_failures_total{action_type="test_action",error_code="TIMEOUT"} 1`,
+		`cymconductor_job_retries_total{action_type="test_action",error_code="TIMEOUT"} 1`,
+		`cymconductor_job_dispatch_latency_seconds_count{action_type="test_action"} 1`,
+		`cymconductor_job_duration_seconds_count{action_type="test_action",status="failed"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected metrics output to contain %q", want)
+		}
+	}
+}
+
+func TestObjectiveDeadlines(t *testing.T) {
+	s, db := newTestScheduler(t)
+	ctx := context.Background()
+	bus := events.New(events.DefaultConfig(), zerolog.Nop())
+	s.SetEventBus(bus)
+	sub, _, _ := bus.Subscribe(events.Filter{Types: []string{"objective"}}, 0)
+	defer sub.Close()
+
+	// An objective due a minute after the scenario became active, over a step
+	// that has no jobs yet
+	doc := `{"steps":[{"id":"step-1","action_type":"observe_process_state"}],` +
+		`"objectives":[{"id":"contain","name":"Contain","step_ids":["step-1"],"deadline_seconds":60}]}`
+	err := db.CreateScenario(ctx, &storage.Scenario{ID: "scenario-deadline", Name: "deadline", Intent: "{}", Source: storage.ScenarioSourceAPI, Status: storage.ScenarioStatusPending})
+	if err != nil {
+		t.Fatalf("Failed to create scenario: %v", err)
+	}
+	if err := db.UpdateScenarioValidatedDSL(ctx, "scenario-deadline", doc); err != nil {
+		t.Fatalf("Failed to store DSL: %v", err)
+	}
+	if err := db.UpdateScenarioActive(ctx, "scenario-deadline"); err != nil {
+		t.Fatalf("Failed to activate scenario: %v", err)
+	}
+	scenario, _ := db.GetScenario(ctx, "scenario-deadline")
+
+	objectives, err := s.scenarioObjectives(scenario)
+	if err != nil || len(objectives) != 1 {
+		t.Fatalf("Expected one objective, got %d (err: %v)", len(objectives), err)
+	}
+	if again, _ := s.scenarioObjectives(scenario); len(again) != 1 || again[0] != objectives[0] {
+		t.Error("Expected the compiled objectives to be reused")
+	}
+
+	// The deadline counts from the scenario's creation
+	due := scenario.CreatedAt.Add(time.Minute)
+	if s.deadlinePassed(scenario, objectives, due.Add(-time.Second)) {
+		t.Error("Expected the deadline not to have passed yet")
+	}
+	if !s.deadlinePassed(scenario, objectives, due) {
+		t.Fatal("Expected the deadline to have passed")
+	}
+
+	card, _, err := s.scorecard(ctx, scenario, due)
+	if err != nil || len(card.Objectives) != 1 || card.Objectives[0].Status != scoring.ObjectiveFailed {
+		t.Fatalf("Expected the objective to fail, got %+v (err: %v)", card, err)
+	}
+	s.publishObjectives(scenario, card)
+	select {
+	case e := <-sub.Events():
+		if e.Type != events.ObjectiveFailed || e.ScenarioID != "scenario-deadline" {
+			t.Errorf("Expected objective.failed for the scenario, got %+v", e)
+		}
+	default:
+		t.Fatal("Expected an objective event")
+	}
+
+	// Once reported, the scenario is no longer graded for its deadline
+	if s.deadlinePassed(scenario, objectives, due) {
+		t.Error("Expected a reported objective not to be graded again")
+	}
+
+	// A deleted scenario's objectives are dropped on the next check
+	if err := db.DeleteScenario(ctx, "scenario-deadline"); err != nil {
+		t.Fatalf("Failed to delete scenario: %v", err)
+	}
+	if err := s.checkObjectiveDeadlines(ctx); err != nil {
+		t.Fatalf("Failed to check deadlines: %v", err)
+	}
+	if len(s.compiled) != 0 || len(s.objectives) != 0 {
+		t.Errorf("Expected the deleted scenario to be forgotten, got %d compiled and %d reported", len(s.compiled), len(s.objectives))
+	}
+}
@@ -7,19 +7,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
 )
 
 // EventForwarder forwards job results to the scoring engine.
 type EventForwarder struct {
-	engineURL  string
+	mu         sync.RWMutex
+	config     Config
 	httpClient *http.Client
 	logger     zerolog.Logger
-	enabled    bool
-	retryCount int
-	retryDelay time.Duration
+	metrics    *metrics.Metrics
 }
 
 // Config holds event forwarder configuration.
@@ -38,8 +41,15 @@ type Config struct {
 
 	// Timeout for HTTP requests
 	Timeout time.Duration
+
+	// Mapping decides which job results are forwarded and as which events;
+	// nil uses DefaultRules
+	Mapping *Mapping
 }
 
+// defaultMapping is used when the configuration has no mapping.
+var defaultMapping = DefaultMapping()
+
 // DefaultConfig returns sensible defaults.
 func DefaultConfig() Config {
 	return Config{
@@ -54,14 +64,11 @@ func DefaultConfig() Config {
 // NewEventForwarder creates a new event forwarder.
 func NewEventForwarder(cfg Config, logger zerolog.Logger) *EventForwarder {
 	return &EventForwarder{
-		engineURL: cfg.EngineURL,
+		config: cfg,
 		httpClient: &http.Client{
 			Timeout: cfg.Timeout,
 		},
-		logger:     logger.With().Str("component", "scoring_forwarder").Logger(),
-		enabled:    cfg.Enabled,
-		retryCount: cfg.RetryCount,
-		retryDelay: cfg.RetryDelay,
+		logger: logger.With().Str("component", "scoring_forwarder").Logger(),
 	}
 }
 
@@ -94,150 +101,159 @@ type JobResult struct {
 	Error       string
 }
 
-// ForwardJobResult sends a job result to the scoring engine.
-// This is called asynchronously after a job completes.
-func (f *EventForwarder) ForwardJobResult(ctx context.Context, runID string, job *JobInfo, result *JobResult) error {
-	if !f.enabled {
-		f.logger.Debug().Msg("Scoring forwarder disabled, skipping")
-		return nil
-	}
-
-	if runID == "" {
-		f.logger.Debug().Str("job_id", job.JobID).Msg("No scoring run ID, skipping")
-		return nil
+// NewJobResultEvent builds the scoring event for a job result using the
+// configured mapping. eventID must be stable for the result so the scoring
+// engine can discard duplicates when a delivery is retried. It returns false
+// if no rule forwards the result.
+func (f *EventForwarder) NewJobResultEvent(eventID string, job *JobInfo, result *JobResult) (ScoringEvent, bool) {
+	cfg, _ := f.settings()
+	mapping := cfg.Mapping
+	if mapping == nil {
+		mapping = defaultMapping
 	}
+	return mapping.Event(eventID, job, result)
+}
 
-	// Only forward observation actions
-	if !isObservationAction(job.ActionType) {
-		f.logger.Debug().
-			Str("job_id", job.JobID).
-			Str("action_type", job.ActionType).
-			Msg("Not an observation action, skipping")
-		return nil
-	}
+// ScenarioInfo contains scenario information for lifecycle events and run
+// creation.
+type ScenarioInfo struct {
+	ScenarioID string
+	LabID      string
+	Name       string
+}
 
-	// Map action result to scoring event type
-	eventType := mapActionToEventType(job.ActionType, result)
+// Scenario lifecycle event types.
+const (
+	EventScenarioStarted   = "scenario.started"
+	EventScenarioCompleted = "scenario.completed"
+)
 
-	event := ScoringEvent{
-		EventID:   fmt.Sprintf("job-%s-%d", job.JobID, time.Now().UnixNano()),
-		EventType: eventType,
-		Timestamp: result.CompletedAt,
+// NewScenarioStartedEvent builds the scoring event sent when a scenario's
+// first jobs are dispatched. A scenario starts once, so its event ID is
+// derived from the scenario ID.
+func NewScenarioStartedEvent(scenario *ScenarioInfo, totalJobs int) ScoringEvent {
+	return ScoringEvent{
+		EventID:   fmt.Sprintf("scenario-%s-started", scenario.ScenarioID),
+		EventType: EventScenarioStarted,
+		Timestamp: time.Now().UTC(),
 		Source:    "orchestrator",
 		Payload: map[string]interface{}{
-			"job_id":       job.JobID,
-			"agent_id":     job.AgentID,
-			"action_type":  job.ActionType,
-			"parameters":   job.Parameters,
-			"scenario_id":  job.ScenarioID,
-			"result":       result.Result,
-			"status":       result.Status,
-			"duration_ms":  result.DurationMs,
-			"scheduled_at": job.ScheduledAt.Format(time.RFC3339),
-			"started_at":   result.StartedAt.Format(time.RFC3339),
-			"completed_at": result.CompletedAt.Format(time.RFC3339),
+			"scenario_id":   scenario.ScenarioID,
+			"scenario_name": scenario.Name,
+			"lab_id":        scenario.LabID,
+			"total_jobs":    totalJobs,
 		},
 	}
+}
 
-	if result.Error != "" {
-		event.Payload["error"] = result.Error
+// NewScenarioCompletedEvent builds the scoring event sent when all of a
+// scenario's jobs have finished. A scenario completes once, so its event ID
+// is derived from the scenario ID.
+func NewScenarioCompletedEvent(scenario *ScenarioInfo, completed, failed int) ScoringEvent {
+	return ScoringEvent{
+		EventID:   fmt.Sprintf("scenario-%s-completed", scenario.ScenarioID),
+		EventType: EventScenarioCompleted,
+		Timestamp: time.Now().UTC(),
+		Source:    "orchestrator",
+		Payload: map[string]interface{}{
+			"scenario_id":    scenario.ScenarioID,
+			"scenario_name":  scenario.Name,
+			"lab_id":         scenario.LabID,
+			"completed_jobs": completed,
+			"failed_jobs":    failed,
+		},
 	}
+}
 
-	return f.sendEvent(ctx, runID, event)
+// createRunRequest is sent to the scoring engine to open a run.
+type createRunRequest struct {
+	Name       string `json:"name"`
+	ScenarioID string `json:"scenario_id"`
+	LabID      string `json:"lab_id,omitempty"`
+	Source     string `json:"source"`
 }
 
-// isObservationAction checks if an action type is an observation action.
-func isObservationAction(actionType string) bool {
-	observationActions := map[string]bool{
-		"observe_process_state":      true,
-		"observe_file_state":         true,
-		"observe_user_state":         true,
-		"observe_registry_state":     true,
-		"capture_siem_query":         true,
-		"verify_network_isolation":   true,
-		"capture_powershell_history": true,
-	}
-	return observationActions[actionType]
+// createRunResponse is the scoring engine's answer to a run creation.
+type createRunResponse struct {
+	RunID string `json:"run_id"`
+	ID    string `json:"id"`
 }
 
-// mapActionToEventType maps an action type and result to a scoring event type.
-func mapActionToEventType(actionType string, result *JobResult) string {
-	if result.Status == "failed" {
-		return "orchestrator.verification_failed"
+// CreateRun opens a run for a scenario on the scoring engine and returns its
+// ID. Unlike event delivery it is not retried; the caller reports failures to
+// the operator.
+func (f *EventForwarder) CreateRun(ctx context.Context, scenario *ScenarioInfo) (string, error) {
+	cfg, client := f.settings()
+	if !cfg.Enabled {
+		return "", fmt.Errorf("scoring forwarder is disabled")
 	}
 
-	switch actionType {
-	case "observe_process_state":
-		// Check if state matched
-		if result.Result != nil {
-			if matches, ok := result.Result["state_matches"].(bool); ok && matches {
-				return "state.verified"
-			}
-		}
-		return "state.check_failed"
+	body, err := json.Marshal(createRunRequest{
+		Name:       scenario.Name,
+		ScenarioID: scenario.ScenarioID,
+		LabID:      scenario.LabID,
+		Source:     "orchestrator",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal run: %w", err)
+	}
 
-	case "observe_file_state":
-		if result.Result != nil {
-			if matches, ok := result.Result["state_matches"].(bool); ok && matches {
-				return "state.verified"
-			}
-		}
-		return "state.check_failed"
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.EngineURL+"/api/v1/runs", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
 
-	case "observe_user_state":
-		if result.Result != nil {
-			if matches, ok := result.Result["state_matches"].(bool); ok && matches {
-				return "state.verified"
-			}
-		}
-		return "state.check_failed"
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
 
-	case "capture_siem_query":
-		if result.Result != nil {
-			if hasMatches, ok := result.Result["has_matches"].(bool); ok && hasMatches {
-				return "query.executed"
-			}
-		}
-		return "query.no_match"
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
 
-	case "capture_powershell_history":
-		if result.Result != nil {
-			if hasMatches, ok := result.Result["has_matches"].(bool); ok && hasMatches {
-				return "response.action_applied"
-			}
-		}
-		return "response.no_action_detected"
+	var created createRunResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("failed to decode run: %w", err)
+	}
+	runID := created.RunID
+	if runID == "" {
+		runID = created.ID
+	}
+	if runID == "" {
+		return "", fmt.Errorf("scoring engine returned no run ID")
+	}
 
-	case "verify_network_isolation":
-		if result.Result != nil {
-			if matches, ok := result.Result["state_matches"].(bool); ok && matches {
-				return "state.verified"
-			}
-		}
-		return "state.check_failed"
+	f.logger.Info().
+		Str("scenario_id", scenario.ScenarioID).
+		Str("run_id", runID).
+		Msg("Scoring run created")
 
-	default:
-		return "orchestrator.job_completed"
-	}
+	return runID, nil
 }
 
-// sendEvent sends an event to the scoring engine with retries.
-func (f *EventForwarder) sendEvent(ctx context.Context, runID string, event ScoringEvent) error {
-	url := fmt.Sprintf("%s/api/v1/runs/%s/events", f.engineURL, runID)
+// Deliver sends an outbox entry to the scoring engine run named by its
+// destination, retrying according to the configuration.
+func (f *EventForwarder) Deliver(ctx context.Context, entry *storage.OutboxEntry) error {
+	return f.sendEvent(ctx, entry.Destination, entry.EventID, entry.EventType, entry.Payload)
+}
 
-	body, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("failed to marshal event: %w", err)
-	}
+// sendEvent sends an event to the scoring engine with retries.
+func (f *EventForwarder) sendEvent(ctx context.Context, runID, eventID, eventType string, body []byte) error {
+	cfg, client := f.settings()
+	url := fmt.Sprintf("%s/api/v1/runs/%s/events", cfg.EngineURL, runID)
 
 	var lastErr error
-	for attempt := 0; attempt <= f.retryCount; attempt++ {
+	for attempt := 0; attempt <= cfg.RetryCount; attempt++ {
 		if attempt > 0 {
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case <-time.After(f.retryDelay):
+			case <-time.After(cfg.RetryDelay):
 			}
+			f.metrics.ForwarderRetried(metrics.ForwarderScoring)
 		}
 
 		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
@@ -247,7 +263,7 @@ func (f *EventForwarder) sendEvent(ctx context.Context, runID string, event Scor
 		}
 		req.Header.Set("Content-Type", "application/json")
 
-		resp, err := f.httpClient.Do(req)
+		resp, err := client.Do(req)
 		if err != nil {
 			lastErr = fmt.Errorf("request failed: %w", err)
 			f.logger.Warn().
@@ -257,27 +273,19 @@ func (f *EventForwarder) sendEvent(ctx context.Context, runID string, event Scor
 				Msg("Failed to forward event, retrying")
 			continue
 		}
-		defer resp.Body.Close()
+		resp.Body.Close()
 
+		// The scoring engine answers a duplicate event ID with 200 OK
 		if resp.StatusCode < 300 {
 			f.logger.Info().
-				Str("event_id", event.EventID).
-				Str("event_type", event.EventType).
+				Str("event_id", eventID).
+				Str("event_type", eventType).
 				Str("run_id", runID).
 				Int("status_code", resp.StatusCode).
 				Msg("Event forwarded to scoring engine")
 			return nil
 		}
 
-		// Handle duplicate (200 OK with status: duplicate)
-		if resp.StatusCode == 200 {
-			f.logger.Debug().
-				Str("event_id", event.EventID).
-				Str("run_id", runID).
-				Msg("Duplicate event detected")
-			return nil
-		}
-
 		lastErr = fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 		f.logger.Warn().
 			Int("status_code", resp.StatusCode).
@@ -286,16 +294,32 @@ func (f *EventForwarder) sendEvent(ctx context.Context, runID string, event Scor
 			Msg("Scoring engine returned error, retrying")
 	}
 
-	f.logger.Error().
-		Err(lastErr).
-		Str("event_id", event.EventID).
-		Str("run_id", runID).
-		Msg("Failed to forward event after all retries")
+	return fmt.Errorf("failed to forward event after %d attempts: %w", cfg.RetryCount+1, lastErr)
+}
 
-	return fmt.Errorf("failed to forward event after %d attempts: %w", f.retryCount+1, lastErr)
+// SetMetrics sets the metrics that deliveries and retries are recorded in.
+func (f *EventForwarder) SetMetrics(m *metrics.Metrics) {
+	f.metrics = m
 }
 
 // IsEnabled returns whether the forwarder is enabled.
 func (f *EventForwarder) IsEnabled() bool {
-	return f.enabled
+	cfg, _ := f.settings()
+	return cfg.Enabled
+}
+
+// UpdateConfig applies a new configuration. Deliveries already in progress
+// finish with the settings they started with.
+func (f *EventForwarder) UpdateConfig(cfg Config) {
+	f.mu.Lock()
+	f.config = cfg
+	f.httpClient = &http.Client{Timeout: cfg.Timeout}
+	f.mu.Unlock()
+}
+
+// settings returns the current configuration and HTTP client.
+func (f *EventForwarder) settings() (Config, *http.Client) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.config, f.httpClient
 }
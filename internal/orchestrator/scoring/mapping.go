@@ -0,0 +1,400 @@
+package scoring
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rule maps matching job results to a scoring event. Rules are tried in
+// order and the first match wins; results no rule matches are not forwarded.
+type Rule struct {
+	// ActionTypes the rule applies to; empty matches every action, so a
+	// catch-all rule can forward non-observation actions as activity events
+	ActionTypes []string
+
+	// Status the result must have (completed or failed); empty matches both
+	Status string
+
+	// When lists predicates on the result data that must all hold, such as
+	// "state_matches == true", "match_count >= 3", "error_code" (present and
+	// not false) or "!has_matches" (absent or false)
+	When []string
+
+	// EventType is the type of the emitted scoring event
+	EventType string
+
+	// Payload projects fields into the event payload, keyed by payload field.
+	// Sources are job.id, job.agent_id, job.action_type, job.scenario_id,
+	// job.parameters[.<key>], job.scheduled_at, status, error, duration_ms,
+	// started_at, completed_at and result[.<path>]. The job and scenario IDs
+	// are always included. Empty sends the full default payload.
+	Payload map[string]string
+}
+
+// observationActions are the actions forwarded by the default rules.
+var observationActions = []string{
+	"observe_process_state",
+	"observe_file_state",
+	"observe_user_state",
+	"observe_registry_state",
+	"capture_siem_query",
+	"verify_network_isolation",
+	"capture_powershell_history",
+}
+
+// DefaultRules returns the rules used when none are configured: observation
+// actions are graded on their state_matches and has_matches results, and
+// other actions are not forwarded.
+func DefaultRules() []Rule {
+	stateChecks := []string{"observe_process_state", "observe_file_state", "observe_user_state", "verify_network_isolation"}
+
+	return []Rule{
+		{ActionTypes: observationActions, Status: "failed", EventType: "orchestrator.verification_failed"},
+		{ActionTypes: stateChecks, When: []string{"state_matches == true"}, EventType: "state.verified"},
+		{ActionTypes: stateChecks, EventType: "state.check_failed"},
+		{ActionTypes: []string{"capture_siem_query"}, When: []string{"has_matches == true"}, EventType: "query.executed"},
+		{ActionTypes: []string{"capture_siem_query"}, EventType: "query.no_match"},
+		{ActionTypes: []string{"capture_powershell_history"}, When: []string{"has_matches == true"}, EventType: "response.action_applied"},
+		{ActionTypes: []string{"capture_powershell_history"}, EventType: "response.no_action_detected"},
+		{ActionTypes: observationActions, EventType: "orchestrator.job_completed"},
+	}
+}
+
+// Mapping decides which job results are forwarded and as which events.
+type Mapping struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	Rule
+	actions    map[string]bool
+	predicates []predicate
+}
+
+// NewMapping compiles rules into a mapping, reporting every invalid rule.
+func NewMapping(rules []Rule) (*Mapping, error) {
+	m := &Mapping{}
+	var problems []string
+	for i, rule := range rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("rule %d: %v", i+1, err))
+			continue
+		}
+		m.rules = append(m.rules, compiled)
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return m, nil
+}
+
+// DefaultMapping returns the mapping for DefaultRules.
+func DefaultMapping() *Mapping {
+	m, err := NewMapping(DefaultRules())
+	if err != nil {
+		panic(fmt.Sprintf("scoring: invalid default rules: %v", err))
+	}
+	return m
+}
+
+func compileRule(rule Rule) (compiledRule, error) {
+	compiled := compiledRule{Rule: rule}
+
+	if rule.EventType == "" {
+		return compiled, fmt.Errorf("event_type is required")
+	}
+	switch rule.Status {
+	case "", "completed", "failed":
+	default:
+		return compiled, fmt.Errorf("status must be completed or failed (got %q)", rule.Status)
+	}
+	if len(rule.ActionTypes) > 0 {
+		compiled.actions = make(map[string]bool, len(rule.ActionTypes))
+		for _, action := range rule.ActionTypes {
+			compiled.actions[action] = true
+		}
+	}
+	for _, expr := range rule.When {
+		p, err := parsePredicate(expr)
+		if err != nil {
+			return compiled, err
+		}
+		compiled.predicates = append(compiled.predicates, p)
+	}
+	for key, source := range rule.Payload {
+		if key == "" {
+			return compiled, fmt.Errorf("payload field names must not be empty")
+		}
+		if !validSource(source) {
+			return compiled, fmt.Errorf("payload field %q has unknown source %q", key, source)
+		}
+	}
+	return compiled, nil
+}
+
+// Event builds the scoring event for a job result from the first matching
+// rule. It returns false if no rule matches.
+func (m *Mapping) Event(eventID string, job *JobInfo, result *JobResult) (ScoringEvent, bool) {
+	for _, rule := range m.rules {
+		if !rule.matches(job, result) {
+			continue
+		}
+
+		event := ScoringEvent{
+			EventID:   eventID,
+			EventType: rule.EventType,
+			Timestamp: result.CompletedAt,
+			Source:    "orchestrator",
+		}
+		if len(rule.Payload) == 0 {
+			event.Payload = defaultPayload(job, result)
+		} else {
+			event.Payload = map[string]interface{}{
+				"job_id":      job.JobID,
+				"scenario_id": job.ScenarioID,
+			}
+			for key, source := range rule.Payload {
+				if v, ok := lookupSource(source, job, result); ok {
+					event.Payload[key] = v
+				}
+			}
+		}
+		return event, true
+	}
+	return ScoringEvent{}, false
+}
+
+func (r *compiledRule) matches(job *JobInfo, result *JobResult) bool {
+	if r.actions != nil && !r.actions[job.ActionType] {
+		return false
+	}
+	if r.Status != "" && r.Status != result.Status {
+		return false
+	}
+	for _, p := range r.predicates {
+		if !p.eval(result.Result) {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultPayload is the payload sent by rules that do not project one.
+func defaultPayload(job *JobInfo, result *JobResult) map[string]interface{} {
+	payload := map[string]interface{}{
+		"job_id":       job.JobID,
+		"agent_id":     job.AgentID,
+		"action_type":  job.ActionType,
+		"parameters":   job.Parameters,
+		"scenario_id":  job.ScenarioID,
+		"result":       result.Result,
+		"status":       result.Status,
+		"duration_ms":  result.DurationMs,
+		"scheduled_at": job.ScheduledAt.Format(time.RFC3339),
+		"started_at":   result.StartedAt.Format(time.RFC3339),
+		"completed_at": result.CompletedAt.Format(time.RFC3339),
+	}
+	if result.Error != "" {
+		payload["error"] = result.Error
+	}
+	return payload
+}
+
+// ============================================================
+// Payload sources
+// ============================================================
+
+var fixedSources = map[string]bool{
+	"job.id": true, "job.agent_id": true, "job.action_type": true, "job.scenario_id": true,
+	"job.parameters": true, "job.scheduled_at": true, "status": true, "error": true,
+	"duration_ms": true, "started_at": true, "completed_at": true, "result": true,
+}
+
+func validSource(source string) bool {
+	if fixedSources[source] {
+		return true
+	}
+	for _, prefix := range []string{"job.parameters.", "result."} {
+		if strings.HasPrefix(source, prefix) && len(source) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupSource resolves a payload source. It returns false for result and
+// parameter paths that are not present.
+func lookupSource(source string, job *JobInfo, result *JobResult) (interface{}, bool) {
+	switch source {
+	case "job.id":
+		return job.JobID, true
+	case "job.agent_id":
+		return job.AgentID, true
+	case "job.action_type":
+		return job.ActionType, true
+	case "job.scenario_id":
+		return job.ScenarioID, true
+	case "job.parameters":
+		return job.Parameters, true
+	case "job.scheduled_at":
+		return job.ScheduledAt.Format(time.RFC3339), true
+	case "status":
+		return result.Status, true
+	case "error":
+		return result.Error, result.Error != ""
+	case "duration_ms":
+		return result.DurationMs, true
+	case "started_at":
+		return result.StartedAt.Format(time.RFC3339), true
+	case "completed_at":
+		return result.CompletedAt.Format(time.RFC3339), true
+	case "result":
+		return result.Result, true
+	}
+	if path, ok := strings.CutPrefix(source, "job.parameters."); ok {
+		return lookupPath(job.Parameters, path)
+	}
+	if path, ok := strings.CutPrefix(source, "result."); ok {
+		return lookupPath(result.Result, path)
+	}
+	return nil, false
+}
+
+// lookupPath follows a dot-separated path through nested maps.
+func lookupPath(data map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// ============================================================
+// Predicates
+// ============================================================
+
+// predicate is a test on one result field.
+type predicate struct {
+	path  string
+	op    string // ==, !=, >, >=, <, <=, "present" or "absent"
+	value interface{}
+}
+
+// predicateOps are tried longest first so ">=" is not read as ">".
+var predicateOps = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+func parsePredicate(expr string) (predicate, error) {
+	expr = strings.TrimSpace(expr)
+	for _, op := range predicateOps {
+		if i := strings.Index(expr, op); i >= 0 {
+			path := strings.TrimSpace(expr[:i])
+			literal := strings.TrimSpace(expr[i+len(op):])
+			if path == "" || literal == "" {
+				return predicate{}, fmt.Errorf("predicate %q must be <field> %s <value>", expr, op)
+			}
+			value := parseLiteral(literal)
+			switch value.(type) {
+			case map[string]interface{}, []interface{}:
+				return predicate{}, fmt.Errorf("predicate %q must compare with a string, number, boolean or null", expr)
+			}
+			if op != "==" && op != "!=" {
+				if _, ok := toFloat(value); !ok {
+					return predicate{}, fmt.Errorf("predicate %q compares with %s, which needs a number", expr, op)
+				}
+			}
+			return predicate{path: path, op: op, value: value}, nil
+		}
+	}
+
+	if path, ok := strings.CutPrefix(expr, "!"); ok {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			return predicate{}, fmt.Errorf("predicate %q has no field", expr)
+		}
+		return predicate{path: path, op: "absent"}, nil
+	}
+	if expr == "" || strings.ContainsAny(expr, " \t") {
+		return predicate{}, fmt.Errorf("predicate %q is not <field>, !<field> or <field> <op> <value>", expr)
+	}
+	return predicate{path: expr, op: "present"}, nil
+}
+
+// parseLiteral reads a JSON literal (true, 3, "text", null), falling back to
+// the bare text as a string.
+func parseLiteral(literal string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(literal), &v); err == nil {
+		return v
+	}
+	return literal
+}
+
+func (p predicate) eval(data map[string]interface{}) bool {
+	actual, found := lookupPath(data, p.path)
+
+	switch p.op {
+	case "present":
+		return found && actual != nil && actual != false
+	case "absent":
+		return !found || actual == nil || actual == false
+	case "==":
+		return found && equalValues(actual, p.value)
+	case "!=":
+		return !found || !equalValues(actual, p.value)
+	}
+
+	a, ok := toFloat(actual)
+	if !found || !ok {
+		return false
+	}
+	b, _ := toFloat(p.value)
+	switch p.op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	return false
+}
+
+func equalValues(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return a == b
+}
+
+// toFloat converts the numeric types a result may carry.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
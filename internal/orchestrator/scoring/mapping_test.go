@@ -0,0 +1,84 @@
+package scoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestScoringEventMapping_ForwardsMatchingResults(t *testing.T) {
+	mapping, err := NewMapping([]Rule{
+		{ActionTypes: []string{"capture_siem_query"}, When: []string{"match_count >= 3", "!truncated"}, EventType: "query.detected",
+			Payload: map[string]string{"query": "job.parameters.query", "matches": "result.match_count"}},
+		{Status: "completed", EventType: "activity.performed", Payload: map[string]string{"action": "job.action_type"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to compile mapping: %v", err)
+	}
+	if _, err := NewMapping([]Rule{{EventType: "x", When: []string{"count > many"}}}); err == nil {
+		t.Error("Expected a non-numeric comparison to be rejected")
+	}
+
+	forwarder := NewEventForwarder(Config{
+		Enabled:   true,
+		EngineURL: "http://scoring.invalid",
+		Mapping:   mapping,
+	}, zerolog.Nop())
+
+	const scenarioID = "scenario-mapping"
+	event := func(jobID, actionType, status string, params, data map[string]interface{}) (ScoringEvent, bool) {
+		job := &JobInfo{
+			JobID:      jobID,
+			AgentID:    "test-agent-mapping",
+			ActionType: actionType,
+			Parameters: params,
+			ScenarioID: scenarioID,
+		}
+		result := &JobResult{Status: status, StartedAt: time.Now(), CompletedAt: time.Now(), Result: data}
+		if status != "completed" {
+			result.Error = "failed"
+		}
+		return forwarder.NewJobResultEvent(jobID+"-result", job, result)
+	}
+
+	siem, ok := event("job-siem", "capture_siem_query", "completed", map[string]interface{}{"query": "EventID=4625"}, map[string]interface{}{"match_count": 5})
+	if !ok || siem.EventID != "job-siem-result" || siem.EventType != "query.detected" || siem.Payload["query"] != "EventID=4625" ||
+		siem.Payload["matches"] != 5 || siem.Payload["scenario_id"] != scenarioID || siem.Payload["job_id"] != "job-siem" || siem.Payload["agent_id"] != nil {
+		t.Errorf("Unexpected projected event: %+v", siem)
+	}
+
+	few, ok := event("job-siem-few", "capture_siem_query", "completed", nil, map[string]interface{}{"match_count": 1})
+	if !ok || few.EventType != "activity.performed" || few.Payload["action"] != "capture_siem_query" {
+		t.Errorf("Expected the catch-all activity event, got %+v", few)
+	}
+
+	truncated, ok := event("job-siem-truncated", "capture_siem_query", "completed", nil, map[string]interface{}{"match_count": 9, "truncated": true})
+	if !ok || truncated.EventType != "activity.performed" {
+		t.Errorf("Expected a truncated result to fall through to the catch-all rule, got %+v", truncated)
+	}
+
+	if noise, ok := event("job-noise", "browse_web", "failed", nil, nil); ok {
+		t.Errorf("Expected no event for a failed result no rule matches, got %+v", noise)
+	}
+}
+
+func TestDefaultMapping_ForwardsObservations(t *testing.T) {
+	forwarder := NewEventForwarder(Config{Enabled: true, EngineURL: "http://scoring.invalid"}, zerolog.Nop())
+	job := &JobInfo{JobID: "job-1", AgentID: "agent-1", ActionType: "observe_file_state", ScenarioID: "scn-1"}
+
+	verified, ok := forwarder.NewJobResultEvent("e1", job, &JobResult{Status: "completed", Result: map[string]interface{}{"state_matches": true}})
+	if !ok || verified.EventType != "state.verified" || verified.Payload["agent_id"] != "agent-1" {
+		t.Errorf("Expected state.verified with the default payload, got %+v", verified)
+	}
+
+	failed, ok := forwarder.NewJobResultEvent("e2", job, &JobResult{Status: "completed", Result: map[string]interface{}{"state_matches": false}})
+	if !ok || failed.EventType != "state.check_failed" {
+		t.Errorf("Expected state.check_failed, got %+v", failed)
+	}
+
+	job.ActionType = "simulate_browsing"
+	if event, ok := forwarder.NewJobResultEvent("e3", job, &JobResult{Status: "completed"}); ok {
+		t.Errorf("Expected no event for an activity step, got %+v", event)
+	}
+}
@@ -0,0 +1,236 @@
+package scoring
+
+import (
+	"fmt"
+	"time"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/pkg/dsl"
+)
+
+// Objective statuses.
+const (
+	ObjectivePending = "pending"
+	ObjectivePassed  = "passed"
+	ObjectiveFailed  = "failed"
+)
+
+// Objective is a scenario objective with its assertions compiled, ready to
+// grade job results.
+type Objective struct {
+	dsl.Objective
+
+	// assertions holds the predicates each step's results must satisfy
+	assertions map[string][]predicate
+}
+
+// ValidatePredicate reports whether expr is a valid objective assertion or
+// mapping rule predicate.
+func ValidatePredicate(expr string) error {
+	_, err := parsePredicate(expr)
+	return err
+}
+
+// CompileObjectives compiles a scenario's objectives, filling in the default
+// weight, requirement and per-step assertions.
+func CompileObjectives(scenario *dsl.Scenario) ([]*Objective, error) {
+	actions := make(map[string]dsl.ActionType, len(scenario.Steps))
+	for _, step := range scenario.Steps {
+		actions[step.ID] = step.ActionType
+	}
+
+	objectives := make([]*Objective, 0, len(scenario.Objectives))
+	for _, o := range scenario.Objectives {
+		compiled := &Objective{Objective: o, assertions: make(map[string][]predicate, len(o.StepIDs))}
+		if compiled.Weight == 0 {
+			compiled.Weight = 1
+		}
+		if compiled.Require == "" {
+			compiled.Require = "all"
+		}
+
+		for _, stepID := range o.StepIDs {
+			action, ok := actions[stepID]
+			if !ok {
+				return nil, fmt.Errorf("objective %s: unknown step %s", o.ID, stepID)
+			}
+			exprs := o.Assert
+			if len(exprs) == 0 {
+				if def := dsl.DefaultObjectiveAssertion(action); def != "" {
+					exprs = []string{def}
+				}
+			}
+			if len(exprs) == 0 {
+				return nil, fmt.Errorf("objective %s: step %s (%s) needs an assertion", o.ID, stepID, action)
+			}
+			for _, expr := range exprs {
+				p, err := parsePredicate(expr)
+				if err != nil {
+					return nil, fmt.Errorf("objective %s: %w", o.ID, err)
+				}
+				compiled.assertions[stepID] = append(compiled.assertions[stepID], p)
+			}
+		}
+		objectives = append(objectives, compiled)
+	}
+	return objectives, nil
+}
+
+// Covers reports whether the objective checks the results of stepID.
+func (o *Objective) Covers(stepID string) bool {
+	_, ok := o.assertions[stepID]
+	return ok
+}
+
+// Deadline returns when the objective must have passed for a scenario that
+// started at start, or nil if it has no deadline.
+func (o *Objective) Deadline(start time.Time) *time.Time {
+	if o.DeadlineSeconds == 0 || start.IsZero() {
+		return nil
+	}
+	t := start.Add(time.Duration(o.DeadlineSeconds) * time.Second)
+	return &t
+}
+
+// Passes reports whether a completed job's result satisfies the objective's
+// assertions for the job's step.
+func (o *Objective) Passes(job *storage.Job) bool {
+	if job.ScenarioStepID == nil || job.Result == nil {
+		return false
+	}
+	predicates, ok := o.assertions[*job.ScenarioStepID]
+	if !ok {
+		return false
+	}
+	for _, p := range predicates {
+		if !p.eval(job.Result) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check is the latest result of one job an objective depends on.
+type Check struct {
+	JobID     string
+	StepID    string
+	AgentID   string
+	JobStatus string
+	Passed    bool
+	CheckedAt *time.Time
+}
+
+// ObjectiveResult is an objective's grade at a point in time.
+type ObjectiveResult struct {
+	Objective *Objective
+	Status    string
+	Score     int
+	Deadline  *time.Time
+	PassedAt  *time.Time
+	Checks    []Check
+}
+
+// Evaluate grades the objective against the scenario's jobs at now. The
+// scenario started at start (zero if it has not started).
+//
+// A job passes once it completed with a result satisfying the assertions
+// before the deadline. The objective passes when every check passes (require
+// all) or any does (require any), and fails when that can no longer happen:
+// a check failed for good or the deadline passed. A completed check that does
+// not pass stays open while re-runs are enabled and the deadline is ahead.
+func (o *Objective) Evaluate(jobs []*storage.Job, start, now time.Time) ObjectiveResult {
+	result := ObjectiveResult{Objective: o, Status: ObjectivePending, Deadline: o.Deadline(start)}
+	expired := result.Deadline != nil && !now.Before(*result.Deadline)
+
+	var passed, open int
+	for _, job := range jobs {
+		if job.ScenarioStepID == nil || !o.Covers(*job.ScenarioStepID) {
+			continue
+		}
+
+		check := Check{
+			JobID:     job.ID,
+			StepID:    *job.ScenarioStepID,
+			AgentID:   job.AgentID,
+			JobStatus: job.Status,
+			CheckedAt: job.CompletedAt,
+		}
+		inTime := job.CompletedAt != nil && (result.Deadline == nil || !job.CompletedAt.After(*result.Deadline))
+
+		switch job.Status {
+		case storage.JobStatusCompleted:
+			check.Passed = inTime && o.Passes(job)
+			if !check.Passed && o.RetryIntervalSeconds > 0 && !expired {
+				open++
+			}
+		case storage.JobStatusPending, storage.JobStatusAssigned, storage.JobStatusRunning:
+			open++
+		}
+
+		if check.Passed {
+			passed++
+			if result.PassedAt == nil ||
+				(o.Require == "all" && job.CompletedAt.After(*result.PassedAt)) ||
+				(o.Require == "any" && job.CompletedAt.Before(*result.PassedAt)) {
+				result.PassedAt = job.CompletedAt
+			}
+		}
+		result.Checks = append(result.Checks, check)
+	}
+
+	total := len(result.Checks)
+	switch {
+	case start.IsZero():
+		// Not started yet
+	case o.Require == "any" && passed > 0, o.Require == "all" && total > 0 && passed == total:
+		result.Status = ObjectivePassed
+		result.Score = o.Weight
+	case expired, o.Require == "any" && open == 0, o.Require == "all" && passed+open < total, total == 0:
+		result.Status = ObjectiveFailed
+	}
+	if result.Status != ObjectivePassed {
+		result.PassedAt = nil
+	}
+	return result
+}
+
+// Scorecard grades a scenario's objectives.
+type Scorecard struct {
+	Objectives []ObjectiveResult
+
+	// Score is the total weight of the passed objectives, out of MaxScore
+	Score    int
+	MaxScore int
+
+	Passed  int
+	Failed  int
+	Pending int
+}
+
+// Percent returns the score as a percentage of the maximum.
+func (s *Scorecard) Percent() float64 {
+	if s.MaxScore == 0 {
+		return 0
+	}
+	return float64(s.Score) / float64(s.MaxScore) * 100
+}
+
+// EvaluateObjectives grades every objective against the scenario's jobs.
+func EvaluateObjectives(objectives []*Objective, jobs []*storage.Job, start, now time.Time) *Scorecard {
+	card := &Scorecard{Objectives: make([]ObjectiveResult, 0, len(objectives))}
+	for _, o := range objectives {
+		result := o.Evaluate(jobs, start, now)
+		card.Objectives = append(card.Objectives, result)
+		card.Score += result.Score
+		card.MaxScore += o.Weight
+		switch result.Status {
+		case ObjectivePassed:
+			card.Passed++
+		case ObjectiveFailed:
+			card.Failed++
+		default:
+			card.Pending++
+		}
+	}
+	return card
+}
@@ -6,23 +6,89 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 )
 
 // Agent represents an agent record in the database.
 type Agent struct {
 	ID              string
+	LabID           string
 	LabHostID       string
 	Hostname        string
 	IPAddress       string
 	Labels          map[string]string
 	Version         string
+	Capabilities    *AgentCapabilities // nil for agents that did not report them
 	Status          string
 	LastHeartbeatAt time.Time
 	RegisteredAt    time.Time
 	UpdatedAt       time.Time
 }
 
+// AgentCapabilities holds the actions and backends an agent reported it can run.
+type AgentCapabilities struct {
+	Actions     []string            `json:"actions"`
+	Unavailable map[string]string   `json:"unavailable,omitempty"`
+	Backends    map[string][]string `json:"backends,omitempty"`
+	OS          string              `json:"os,omitempty"`
+	Arch        string              `json:"arch,omitempty"`
+}
+
+// CanRun returns why the agent cannot run action with the given backend
+// ("" or "auto" for any), or nil if it can. Agents that did not report
+// capabilities are assumed to run everything.
+func (a *Agent) CanRun(action, backend string) error {
+	caps := a.Capabilities
+	if caps == nil {
+		return nil
+	}
+	if reason, ok := caps.Unavailable[action]; ok {
+		return fmt.Errorf("%s unavailable: %s", action, reason)
+	}
+	if !containsString(caps.Actions, action) {
+		return fmt.Errorf("%s not supported by agent version %s", action, orUnknown(a.Version))
+	}
+	if backend == "" || backend == "auto" {
+		return nil
+	}
+	if backends, ok := caps.Backends[action]; ok && !containsString(backends, backend) {
+		return fmt.Errorf("%s backend %s unavailable on %s (has %s)", action, backend, orUnknown(caps.OS), strings.Join(backends, ", "))
+	}
+	return nil
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// marshalCapabilities encodes capabilities for the capabilities column (NULL for nil).
+func marshalCapabilities(caps *AgentCapabilities) (interface{}, error) {
+	if caps == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(caps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal capabilities: %w", err)
+	}
+	return string(data), nil
+}
+
+// unmarshalCapabilities decodes the capabilities column.
+func unmarshalCapabilities(raw sql.NullString) (*AgentCapabilities, error) {
+	if !raw.Valid || raw.String == "" {
+		return nil, nil
+	}
+	var caps AgentCapabilities
+	if err := json.Unmarshal([]byte(raw.String), &caps); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal capabilities: %w", err)
+	}
+	return &caps, nil
+}
+
 // AgentStatus constants
 const (
 	AgentStatusOnline  = "online"
@@ -37,18 +103,36 @@ func (d *DB) CreateAgent(ctx context.Context, agent *Agent) error {
 		return fmt.Errorf("failed to marshal labels: %w", err)
 	}
 
+	caps, err := marshalCapabilities(agent.Capabilities)
+	if err != nil {
+		return err
+	}
+
+	agent.LabID = labOrDefault(agent.LabID)
+
 	_, err = d.db.ExecContext(ctx, `
-		INSERT INTO agents (id, lab_host_id, hostname, ip_address, labels, version, status, last_heartbeat_at, registered_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, agent.ID, agent.LabHostID, agent.Hostname, agent.IPAddress, string(labels),
-		agent.Version, agent.Status, agent.LastHeartbeatAt, agent.RegisteredAt)
+		INSERT INTO agents (id, lab_id, lab_host_id, hostname, ip_address, labels, version, capabilities, status, last_heartbeat_at, registered_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, agent.ID, agent.LabID, agent.LabHostID, agent.Hostname, agent.IPAddress, string(labels),
+		agent.Version, caps, agent.Status, agent.LastHeartbeatAt, agent.RegisteredAt)
 
 	if err != nil {
 		return fmt.Errorf("failed to insert agent: %w", err)
 	}
 
+	d.Audit(ctx, AuditEntityAgent, agent.ID, AuditActionCreated, nil, map[string]interface{}{
+		"lab_id":      agent.LabID,
+		"lab_host_id": agent.LabHostID,
+		"hostname":    agent.Hostname,
+		"ip_address":  agent.IPAddress,
+		"labels":      agent.Labels,
+		"version":     agent.Version,
+		"status":      agent.Status,
+	}, nil)
+
 	d.logger.Info().
 		Str("agent_id", agent.ID).
+		Str("lab_id", agent.LabID).
 		Str("lab_host_id", agent.LabHostID).
 		Str("hostname", agent.Hostname).
 		Msg("Agent created")
@@ -60,14 +144,15 @@ func (d *DB) CreateAgent(ctx context.Context, agent *Agent) error {
 func (d *DB) GetAgent(ctx context.Context, id string) (*Agent, error) {
 	var agent Agent
 	var labelsJSON string
+	var capsJSON sql.NullString
 
 	err := d.db.QueryRowContext(ctx, `
-		SELECT id, lab_host_id, hostname, ip_address, labels, version, status,
+		SELECT id, lab_id, lab_host_id, hostname, ip_address, labels, version, capabilities, status,
 		       last_heartbeat_at, registered_at, updated_at
 		FROM agents WHERE id = ?
 	`, id).Scan(
-		&agent.ID, &agent.LabHostID, &agent.Hostname, &agent.IPAddress,
-		&labelsJSON, &agent.Version, &agent.Status,
+		&agent.ID, &agent.LabID, &agent.LabHostID, &agent.Hostname, &agent.IPAddress,
+		&labelsJSON, &agent.Version, &capsJSON, &agent.Status,
 		&agent.LastHeartbeatAt, &agent.RegisteredAt, &agent.UpdatedAt,
 	)
 
@@ -81,6 +166,9 @@ func (d *DB) GetAgent(ctx context.Context, id string) (*Agent, error) {
 	if err := json.Unmarshal([]byte(labelsJSON), &agent.Labels); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal labels: %w", err)
 	}
+	if agent.Capabilities, err = unmarshalCapabilities(capsJSON); err != nil {
+		return nil, err
+	}
 
 	return &agent, nil
 }
@@ -89,14 +177,15 @@ func (d *DB) GetAgent(ctx context.Context, id string) (*Agent, error) {
 func (d *DB) GetAgentByLabHostID(ctx context.Context, labHostID string) (*Agent, error) {
 	var agent Agent
 	var labelsJSON string
+	var capsJSON sql.NullString
 
 	err := d.db.QueryRowContext(ctx, `
-		SELECT id, lab_host_id, hostname, ip_address, labels, version, status,
+		SELECT id, lab_id, lab_host_id, hostname, ip_address, labels, version, capabilities, status,
 		       last_heartbeat_at, registered_at, updated_at
 		FROM agents WHERE lab_host_id = ?
 	`, labHostID).Scan(
-		&agent.ID, &agent.LabHostID, &agent.Hostname, &agent.IPAddress,
-		&labelsJSON, &agent.Version, &agent.Status,
+		&agent.ID, &agent.LabID, &agent.LabHostID, &agent.Hostname, &agent.IPAddress,
+		&labelsJSON, &agent.Version, &capsJSON, &agent.Status,
 		&agent.LastHeartbeatAt, &agent.RegisteredAt, &agent.UpdatedAt,
 	)
 
@@ -110,6 +199,9 @@ func (d *DB) GetAgentByLabHostID(ctx context.Context, labHostID string) (*Agent,
 	if err := json.Unmarshal([]byte(labelsJSON), &agent.Labels); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal labels: %w", err)
 	}
+	if agent.Capabilities, err = unmarshalCapabilities(capsJSON); err != nil {
+		return nil, err
+	}
 
 	return &agent, nil
 }
@@ -141,6 +233,51 @@ func (d *DB) UpdateAgentHeartbeat(ctx context.Context, id string, status string,
 	return nil
 }
 
+// UpdateAgentCapabilities records the version and capabilities an agent
+// reported when it re-registered.
+func (d *DB) UpdateAgentCapabilities(ctx context.Context, id string, version string, caps *AgentCapabilities) error {
+	capsValue, err := marshalCapabilities(caps)
+	if err != nil {
+		return err
+	}
+
+	result, err := d.db.ExecContext(ctx, `
+		UPDATE agents SET version = ?, capabilities = ? WHERE id = ?
+	`, version, capsValue, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update agent capabilities: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("agent not found: %s", id)
+	}
+
+	return nil
+}
+
+// UpdateAgentLab moves an agent into a different lab.
+func (d *DB) UpdateAgentLab(ctx context.Context, id string, labID string) error {
+	labID = labOrDefault(labID)
+	result, err := d.db.ExecContext(ctx, `
+		UPDATE agents SET lab_id = ? WHERE id = ?
+	`, labID, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update agent lab: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("agent not found: %s", id)
+	}
+
+	d.Audit(ctx, AuditEntityAgent, id, AuditActionUpdated, nil, map[string]interface{}{"lab_id": labID}, nil)
+
+	return nil
+}
+
 // UpdateAgentStatus updates the agent's status.
 func (d *DB) UpdateAgentStatus(ctx context.Context, id string, status string) error {
 	result, err := d.db.ExecContext(ctx, `
@@ -156,30 +293,31 @@ func (d *DB) UpdateAgentStatus(ctx context.Context, id string, status string) er
 		return fmt.Errorf("agent not found: %s", id)
 	}
 
+	oldValue, newValue := statusChange("", status)
+	d.Audit(ctx, AuditEntityAgent, id, AuditActionStatusChanged, oldValue, newValue, nil)
+
 	return nil
 }
 
-// ListAgents retrieves all agents, optionally filtered by status.
-func (d *DB) ListAgents(ctx context.Context, status string) ([]*Agent, error) {
-	var query string
+// ListAgents retrieves agents, optionally filtered by lab and status.
+// An empty labID lists agents in all labs.
+func (d *DB) ListAgents(ctx context.Context, labID, status string) ([]*Agent, error) {
+	query := `
+		SELECT id, lab_id, lab_host_id, hostname, ip_address, labels, version, capabilities, status,
+		       last_heartbeat_at, registered_at, updated_at
+		FROM agents WHERE 1=1
+	`
 	var args []interface{}
 
+	if labID != "" {
+		query += " AND lab_id = ?"
+		args = append(args, labID)
+	}
 	if status != "" {
-		query = `
-			SELECT id, lab_host_id, hostname, ip_address, labels, version, status,
-			       last_heartbeat_at, registered_at, updated_at
-			FROM agents WHERE status = ?
-			ORDER BY registered_at DESC
-		`
-		args = []interface{}{status}
-	} else {
-		query = `
-			SELECT id, lab_host_id, hostname, ip_address, labels, version, status,
-			       last_heartbeat_at, registered_at, updated_at
-			FROM agents
-			ORDER BY registered_at DESC
-		`
+		query += " AND status = ?"
+		args = append(args, status)
 	}
+	query += " ORDER BY registered_at DESC"
 
 	rows, err := d.db.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -191,10 +329,11 @@ func (d *DB) ListAgents(ctx context.Context, status string) ([]*Agent, error) {
 	for rows.Next() {
 		var agent Agent
 		var labelsJSON string
+		var capsJSON sql.NullString
 
 		if err := rows.Scan(
-			&agent.ID, &agent.LabHostID, &agent.Hostname, &agent.IPAddress,
-			&labelsJSON, &agent.Version, &agent.Status,
+			&agent.ID, &agent.LabID, &agent.LabHostID, &agent.Hostname, &agent.IPAddress,
+			&labelsJSON, &agent.Version, &capsJSON, &agent.Status,
 			&agent.LastHeartbeatAt, &agent.RegisteredAt, &agent.UpdatedAt,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan agent: %w", err)
@@ -203,6 +342,9 @@ func (d *DB) ListAgents(ctx context.Context, status string) ([]*Agent, error) {
 		if err := json.Unmarshal([]byte(labelsJSON), &agent.Labels); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal labels: %w", err)
 		}
+		if agent.Capabilities, err = unmarshalCapabilities(capsJSON); err != nil {
+			return nil, err
+		}
 
 		agents = append(agents, &agent)
 	}
@@ -210,10 +352,11 @@ func (d *DB) ListAgents(ctx context.Context, status string) ([]*Agent, error) {
 	return agents, rows.Err()
 }
 
-// ListAgentsByLabels retrieves agents matching the given label selector.
-func (d *DB) ListAgentsByLabels(ctx context.Context, labels map[string]string) ([]*Agent, error) {
-	// Get all online agents first
-	agents, err := d.ListAgents(ctx, AgentStatusOnline)
+// ListAgentsByLabels retrieves online agents in a lab matching the given label selector.
+// Label matching never crosses labs.
+func (d *DB) ListAgentsByLabels(ctx context.Context, labID string, labels map[string]string) ([]*Agent, error) {
+	// Get all online agents in the lab first
+	agents, err := d.ListAgents(ctx, labOrDefault(labID), AgentStatusOnline)
 	if err != nil {
 		return nil, err
 	}
@@ -229,6 +372,11 @@ func (d *DB) ListAgentsByLabels(ctx context.Context, labels map[string]string) (
 	return matched, nil
 }
 
+// MatchesLabels reports whether the agent's labels satisfy the selector.
+func (a *Agent) MatchesLabels(selector map[string]string) bool {
+	return matchLabels(a.Labels, selector)
+}
+
 // matchLabels checks if agent labels match the selector.
 // All selector labels must be present in agent labels with matching values.
 func matchLabels(agentLabels, selector map[string]string) bool {
@@ -240,42 +388,75 @@ func matchLabels(agentLabels, selector map[string]string) bool {
 	return true
 }
 
+// StaleAgent identifies an agent that was marked offline.
+type StaleAgent struct {
+	ID    string
+	LabID string
+}
+
 // MarkStaleAgentsOffline marks agents as offline if they haven't sent a heartbeat recently.
-func (d *DB) MarkStaleAgentsOffline(ctx context.Context, timeout time.Duration) (int, error) {
+// It returns the agents whose status changed.
+func (d *DB) MarkStaleAgentsOffline(ctx context.Context, timeout time.Duration) ([]StaleAgent, error) {
 	cutoff := time.Now().Add(-timeout)
 
-	result, err := d.db.ExecContext(ctx, `
+	rows, err := d.db.QueryContext(ctx, `
 		UPDATE agents
 		SET status = ?
 		WHERE status = ? AND last_heartbeat_at < ?
+		RETURNING id, lab_id
 	`, AgentStatusOffline, AgentStatusOnline, cutoff)
-
 	if err != nil {
-		return 0, fmt.Errorf("failed to mark stale agents offline: %w", err)
+		return nil, fmt.Errorf("failed to mark stale agents offline: %w", err)
 	}
+	defer rows.Close()
 
-	rows, _ := result.RowsAffected()
-	if rows > 0 {
+	var stale []StaleAgent
+	for rows.Next() {
+		var a StaleAgent
+		if err := rows.Scan(&a.ID, &a.LabID); err != nil {
+			return nil, fmt.Errorf("failed to scan stale agent: %w", err)
+		}
+		stale = append(stale, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to mark stale agents offline: %w", err)
+	}
+	rows.Close()
+
+	for _, a := range stale {
+		oldValue, newValue := statusChange(AgentStatusOnline, AgentStatusOffline)
+		d.Audit(ctx, AuditEntityAgent, a.ID, AuditActionStatusChanged, oldValue, newValue,
+			map[string]interface{}{"reason": "heartbeat_timeout", "timeout": timeout.String()})
+	}
+
+	if len(stale) > 0 {
 		d.logger.Info().
-			Int64("count", rows).
+			Int("count", len(stale)).
 			Dur("timeout", timeout).
 			Msg("Marked stale agents as offline")
 	}
 
-	return int(rows), nil
+	return stale, nil
 }
 
 // DeleteAgent removes an agent record.
 func (d *DB) DeleteAgent(ctx context.Context, id string) error {
-	result, err := d.db.ExecContext(ctx, "DELETE FROM agents WHERE id = ?", id)
+	var labID, labHostID, hostname string
+	err := d.db.QueryRowContext(ctx, `
+		DELETE FROM agents WHERE id = ? RETURNING lab_id, lab_host_id, hostname
+	`, id).Scan(&labID, &labHostID, &hostname)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("agent not found: %s", id)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to delete agent: %w", err)
 	}
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("agent not found: %s", id)
-	}
+	d.Audit(ctx, AuditEntityAgent, id, AuditActionDeleted, map[string]interface{}{
+		"lab_id":      labID,
+		"lab_host_id": labHostID,
+		"hostname":    hostname,
+	}, nil, nil)
 
 	d.logger.Info().Str("agent_id", id).Msg("Agent deleted")
 	return nil
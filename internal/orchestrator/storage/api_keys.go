@@ -0,0 +1,173 @@
+// Package storage provides SQLite database access for the orchestrator.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey represents an operator API key. Only the hash of the key is stored.
+type APIKey struct {
+	ID         string
+	Name       string
+	KeyPrefix  string
+	KeyHash    string
+	Role       string
+	CreatedBy  *string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+	RevokedAt  *time.Time
+}
+
+// IsActive reports whether the key is neither revoked nor expired.
+func (k *APIKey) IsActive(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	if k.ExpiresAt != nil && now.After(*k.ExpiresAt) {
+		return false
+	}
+	return true
+}
+
+// CreateAPIKey inserts a new API key record.
+func (d *DB) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	if key.ID == "" {
+		key.ID = uuid.New().String()
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO api_keys (id, name, key_prefix, key_hash, role, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, key.ID, key.Name, key.KeyPrefix, key.KeyHash, key.Role, key.CreatedBy, key.CreatedAt, key.ExpiresAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert api key: %w", err)
+	}
+
+	// Only the display prefix is recorded, never the key or its hash
+	newValue := map[string]interface{}{
+		"name":       key.Name,
+		"key_prefix": key.KeyPrefix,
+		"role":       key.Role,
+	}
+	if key.ExpiresAt != nil {
+		newValue["expires_at"] = *key.ExpiresAt
+	}
+	d.Audit(ctx, AuditEntityAPIKey, key.ID, AuditActionCreated, nil, newValue, nil)
+
+	d.logger.Info().
+		Str("key_id", key.ID).
+		Str("name", key.Name).
+		Str("role", key.Role).
+		Msg("API key created")
+
+	return nil
+}
+
+// GetAPIKey retrieves an API key by ID.
+func (d *DB) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	row := d.db.QueryRowContext(ctx, `
+		SELECT id, name, key_prefix, key_hash, role, created_by, created_at, last_used_at, expires_at, revoked_at
+		FROM api_keys WHERE id = ?
+	`, id)
+
+	return scanAPIKey(row)
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its secret.
+func (d *DB) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	row := d.db.QueryRowContext(ctx, `
+		SELECT id, name, key_prefix, key_hash, role, created_by, created_at, last_used_at, expires_at, revoked_at
+		FROM api_keys WHERE key_hash = ?
+	`, hash)
+
+	return scanAPIKey(row)
+}
+
+// ListAPIKeys returns all API keys, newest first.
+func (d *DB) ListAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, name, key_prefix, key_hash, role, created_by, created_at, last_used_at, expires_at, revoked_at
+		FROM api_keys
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey marks an API key as revoked.
+func (d *DB) RevokeAPIKey(ctx context.Context, id string) error {
+	result, err := d.db.ExecContext(ctx, `
+		UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL
+	`, time.Now().UTC(), id)
+
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("api key not found: %s", id)
+	}
+
+	oldValue, newValue := statusChange("active", "revoked")
+	d.Audit(ctx, AuditEntityAPIKey, id, AuditActionStatusChanged, oldValue, newValue, nil)
+
+	d.logger.Info().Str("key_id", id).Msg("API key revoked")
+	return nil
+}
+
+// TouchAPIKey records that an API key was just used.
+func (d *DB) TouchAPIKey(ctx context.Context, id string) error {
+	_, err := d.db.ExecContext(ctx, `
+		UPDATE api_keys SET last_used_at = ? WHERE id = ?
+	`, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update api key usage: %w", err)
+	}
+	return nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+
+	err := row.Scan(
+		&key.ID, &key.Name, &key.KeyPrefix, &key.KeyHash, &key.Role, &key.CreatedBy,
+		&key.CreatedAt, &key.LastUsedAt, &key.ExpiresAt, &key.RevokedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan api key: %w", err)
+	}
+
+	return &key, nil
+}
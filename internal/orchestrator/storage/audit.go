@@ -0,0 +1,305 @@
+// Package storage provides SQLite database access for the orchestrator.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audit entity types.
+const (
+	AuditEntityRequest  = "api_request"
+	AuditEntityAPIKey   = "api_key"
+	AuditEntityAgent    = "agent"
+	AuditEntityScenario = "scenario"
+	AuditEntityJob      = "job"
+	AuditEntityUser     = "impersonation_user"
+	AuditEntityLab      = "lab"
+	AuditEntityAuditLog = "audit_log"
+	AuditEntityConfig   = "config"
+	AuditEntityWebhook  = "webhook_subscription"
+	AuditEntityDelivery = "webhook_delivery"
+	AuditEntityOutbox   = "outbox_entry"
+)
+
+// Audit actions.
+const (
+	AuditActionCreated       = "created"
+	AuditActionUpdated       = "updated"
+	AuditActionDeleted       = "deleted"
+	AuditActionStatusChanged = "status_changed"
+	AuditActionReloaded      = "reloaded"
+	AuditActionReloadFailed  = "reload_failed"
+	AuditActionReplayed      = "replayed"
+)
+
+// AuditActorSystem is recorded for changes made by the orchestrator itself
+// (scheduler loop, stale agent cleanup, startup tasks).
+const AuditActorSystem = "system"
+
+type actorKey struct{}
+
+// AgentActor returns the audit actor for changes requested by an agent.
+func AgentActor(agentID string) string {
+	return "agent:" + agentID
+}
+
+// WithActor returns a context whose writes are audited as made by actor
+// (e.g. "key:ops" for an API key or "agent:<id>" for an agent).
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the audit actor attached to ctx, or
+// AuditActorSystem if there is none.
+func ActorFromContext(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return AuditActorSystem
+}
+
+// AuditEntry represents a row in the audit log.
+type AuditEntry struct {
+	ID         int64
+	EntityType string
+	EntityID   string
+	Action     string
+	Actor      string
+	OldValue   map[string]interface{}
+	NewValue   map[string]interface{}
+	Metadata   map[string]interface{}
+	CreatedAt  time.Time
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// CreateAuditEntry appends an entry to the audit log.
+func (d *DB) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	return insertAuditEntry(ctx, d.db, entry)
+}
+
+func insertAuditEntry(ctx context.Context, exec execer, entry *AuditEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+
+	oldValue, err := marshalAuditValue(entry.OldValue)
+	if err != nil {
+		return fmt.Errorf("failed to marshal old_value: %w", err)
+	}
+	newValue, err := marshalAuditValue(entry.NewValue)
+	if err != nil {
+		return fmt.Errorf("failed to marshal new_value: %w", err)
+	}
+	metadata, err := marshalAuditValue(entry.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	result, err := exec.ExecContext(ctx, `
+		INSERT INTO audit_log (entity_type, entity_id, action, actor, old_value, new_value, metadata, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, entry.EntityType, entry.EntityID, entry.Action, entry.Actor, oldValue, newValue, metadata, entry.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+
+	entry.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// Audit records a state change made by the actor in ctx. Failures are logged
+// rather than returned so auditing never fails the change itself.
+func (d *DB) Audit(ctx context.Context, entityType, entityID, action string, oldValue, newValue, metadata map[string]interface{}) {
+	d.auditWith(context.WithoutCancel(ctx), d.db, entityType, entityID, action, oldValue, newValue, metadata)
+}
+
+// auditWith records a state change through exec, so changes made inside a
+// transaction are audited atomically with it.
+func (d *DB) auditWith(ctx context.Context, exec execer, entityType, entityID, action string, oldValue, newValue, metadata map[string]interface{}) {
+	entry := &AuditEntry{
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		Actor:      ActorFromContext(ctx),
+		OldValue:   oldValue,
+		NewValue:   newValue,
+		Metadata:   metadata,
+	}
+	if err := insertAuditEntry(ctx, exec, entry); err != nil {
+		d.logger.Error().Err(err).
+			Str("entity_type", entityType).
+			Str("entity_id", entityID).
+			Str("action", action).
+			Msg("Failed to write audit entry")
+	}
+}
+
+// statusChange returns the old and new values of a status transition. An
+// empty from omits the old value.
+func statusChange(from, to string) (oldValue, newValue map[string]interface{}) {
+	if from != "" {
+		oldValue = map[string]interface{}{"status": from}
+	}
+	return oldValue, map[string]interface{}{"status": to}
+}
+
+// AuditFilter selects audit entries. Empty fields match everything.
+type AuditFilter struct {
+	EntityType string
+	EntityID   string
+	Action     string
+	Actor      string
+
+	// Since and Until bound created_at (inclusive); zero means unbounded
+	Since time.Time
+	Until time.Time
+
+	// BeforeID continues a newest-first listing below an entry ID
+	BeforeID int64
+	Limit    int
+}
+
+func (f AuditFilter) where() (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+
+	if f.EntityType != "" {
+		add("entity_type = ?", f.EntityType)
+	}
+	if f.EntityID != "" {
+		add("entity_id = ?", f.EntityID)
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.Actor != "" {
+		add("actor = ?", f.Actor)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= ?", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		add("created_at <= ?", f.Until.UTC())
+	}
+	if f.BeforeID > 0 {
+		add("id < ?", f.BeforeID)
+	}
+
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+const auditColumns = `id, entity_type, entity_id, action, actor, old_value, new_value, metadata, created_at`
+
+// ListAuditEntries retrieves audit entries newest first.
+func (d *DB) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	where, args := filter.where()
+	rows, err := d.db.QueryContext(ctx,
+		"SELECT "+auditColumns+" FROM audit_log"+where+" ORDER BY id DESC LIMIT ?",
+		append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*AuditEntry
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// ExportAuditEntries calls fn for every matching audit entry, oldest first.
+// The filter's BeforeID and Limit are ignored.
+func (d *DB) ExportAuditEntries(ctx context.Context, filter AuditFilter, fn func(*AuditEntry) error) error {
+	filter.BeforeID = 0
+	where, args := filter.where()
+
+	rows, err := d.db.QueryContext(ctx, "SELECT "+auditColumns+" FROM audit_log"+where+" ORDER BY id ASC", args...)
+	if err != nil {
+		return fmt.Errorf("failed to export audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func scanAuditEntry(row rowScanner) (*AuditEntry, error) {
+	var entry AuditEntry
+	var actor, oldValue, newValue, metadata sql.NullString
+
+	if err := row.Scan(
+		&entry.ID, &entry.EntityType, &entry.EntityID, &entry.Action, &actor,
+		&oldValue, &newValue, &metadata, &entry.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	entry.Actor = actor.String
+
+	for _, col := range []struct {
+		raw  sql.NullString
+		dest *map[string]interface{}
+	}{
+		{oldValue, &entry.OldValue},
+		{newValue, &entry.NewValue},
+		{metadata, &entry.Metadata},
+	} {
+		if !col.raw.Valid {
+			continue
+		}
+		if err := json.Unmarshal([]byte(col.raw.String), col.dest); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit value: %w", err)
+		}
+	}
+
+	return &entry, nil
+}
+
+// marshalAuditValue encodes an optional JSON column, returning nil for empty values.
+func marshalAuditValue(v map[string]interface{}) (*string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	return &s, nil
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStore_AuditRecordsActors(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		agentCtx := WithActor(context.Background(), AgentActor("agent-1"))
+		opsCtx := WithActor(context.Background(), "key:ops")
+		ctx := context.Background()
+
+		err := store.CreateAgent(agentCtx, &Agent{ID: "agent-1", LabHostID: "host-1", Hostname: "ws-audit", IPAddress: "10.0.0.1", Status: "online"})
+		if err != nil {
+			t.Fatalf("CreateAgent() error = %v", err)
+		}
+		createStoreJob(t, store, "job-1", "agent-1", nil, time.Now().UTC())
+		if err := store.CancelJob(opsCtx, "job-1", JobStatusPending); err != nil {
+			t.Fatalf("CancelJob() error = %v", err)
+		}
+
+		agents, err := store.ListAuditEntries(ctx, AuditFilter{EntityType: AuditEntityAgent, EntityID: "agent-1"})
+		if err != nil || len(agents) != 1 {
+			t.Fatalf("Expected one agent entry, got %d (err: %v)", len(agents), err)
+		}
+		if agents[0].Action != AuditActionCreated || agents[0].Actor != AgentActor("agent-1") || agents[0].NewValue["hostname"] != "ws-audit" {
+			t.Errorf("Expected agent creation by the agent, got %+v", agents[0])
+		}
+
+		// Newest first: cancelled by the operator after creation by the system
+		jobs, err := store.ListAuditEntries(ctx, AuditFilter{EntityType: AuditEntityJob, EntityID: "job-1"})
+		if err != nil || len(jobs) != 2 {
+			t.Fatalf("Expected two job entries, got %d (err: %v)", len(jobs), err)
+		}
+		cancelled, created := jobs[0], jobs[1]
+		if cancelled.Action != AuditActionStatusChanged || cancelled.Actor != "key:ops" ||
+			cancelled.OldValue["status"] != JobStatusPending || cancelled.NewValue["status"] != JobStatusCancelled {
+			t.Errorf("Expected pending -> cancelled by key:ops, got %+v", cancelled)
+		}
+		if created.Action != AuditActionCreated || created.Actor != AuditActorSystem {
+			t.Errorf("Expected job creation by system, got %+v", created)
+		}
+
+		// Filtering by actor
+		byOps, _ := store.ListAuditEntries(ctx, AuditFilter{Actor: "key:ops"})
+		if len(byOps) != 1 || byOps[0].ID != cancelled.ID {
+			t.Errorf("Expected only the cancellation by key:ops, got %+v", byOps)
+		}
+
+		// Paging with BeforeID walks back through both entries
+		page, _ := store.ListAuditEntries(ctx, AuditFilter{EntityType: AuditEntityJob, Limit: 1})
+		if len(page) != 1 || page[0].ID != cancelled.ID {
+			t.Fatalf("Expected the cancellation first, got %+v", page)
+		}
+		page, _ = store.ListAuditEntries(ctx, AuditFilter{EntityType: AuditEntityJob, Limit: 1, BeforeID: page[0].ID})
+		if len(page) != 1 || page[0].ID != created.ID {
+			t.Errorf("Expected the creation on the second page, got %+v", page)
+		}
+
+		// Exports run oldest first and ignore paging
+		var exported []int64
+		err = store.ExportAuditEntries(ctx, AuditFilter{EntityType: AuditEntityJob, Limit: 1, BeforeID: created.ID}, func(entry *AuditEntry) error {
+			exported = append(exported, entry.ID)
+			return nil
+		})
+		if err != nil || len(exported) != 2 || exported[0] != created.ID || exported[1] != cancelled.ID {
+			t.Errorf("Expected both entries oldest first, got %v (err: %v)", exported, err)
+		}
+	})
+}
+
+func TestStore_AuditsJobTransitions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := WithActor(context.Background(), AgentActor("agent-1"))
+		createStoreAgent(t, store, "agent-1", "", nil)
+		createStoreJob(t, store, "job-1", "agent-1", nil, time.Now().UTC().Add(-time.Minute))
+		createStoreJob(t, store, "job-2", "agent-1", nil, time.Now().UTC().Add(-time.Minute))
+
+		// statusChanges returns an entity's transitions, oldest first, as "old->new"
+		statusChanges := func(entityType, id string) []string {
+			t.Helper()
+			entries, err := store.ListAuditEntries(ctx, AuditFilter{EntityType: entityType, EntityID: id, Action: AuditActionStatusChanged})
+			if err != nil {
+				t.Fatalf("ListAuditEntries() error = %v", err)
+			}
+			var changes []string
+			for i := len(entries) - 1; i >= 0; i-- {
+				changes = append(changes, fmt.Sprintf("%v->%v", entries[i].OldValue["status"], entries[i].NewValue["status"]))
+			}
+			return changes
+		}
+
+		if err := store.AssignJobs(ctx, []string{"job-1"}); err != nil {
+			t.Fatalf("AssignJobs() error = %v", err)
+		}
+		if err := store.UpdateJobStarted(ctx, "job-1", time.Now().UTC()); err != nil {
+			t.Fatalf("UpdateJobStarted() error = %v", err)
+		}
+		if err := store.UpdateJobCompleted(ctx, "job-1", time.Now().UTC(), nil); err != nil {
+			t.Fatalf("UpdateJobCompleted() error = %v", err)
+		}
+		want := "[pending->assigned assigned->running running->completed]"
+		if got := fmt.Sprint(statusChanges(AuditEntityJob, "job-1")); got != want {
+			t.Errorf("Expected job-1 transitions %s, got %s", want, got)
+		}
+
+		// A retried failure goes back to pending; the last one fails the job
+		for i := 0; i < 2; i++ {
+			if err := store.UpdateJobFailed(ctx, "job-2", time.Now().UTC(), "timeout", true); err != nil {
+				t.Fatalf("UpdateJobFailed() error = %v", err)
+			}
+		}
+		want = "[pending->pending pending->failed]"
+		if got := fmt.Sprint(statusChanges(AuditEntityJob, "job-2")); got != want {
+			t.Errorf("Expected job-2 transitions %s, got %s", want, got)
+		}
+
+		if err := store.UpdateJobCompleted(ctx, "missing", time.Now().UTC(), nil); !errors.Is(err, ErrJobNotFound) {
+			t.Errorf("Expected ErrJobNotFound, got %v", err)
+		}
+		if err := store.UpdateJobFailed(ctx, "missing", time.Now().UTC(), "x", true); !errors.Is(err, ErrJobNotFound) {
+			t.Errorf("Expected ErrJobNotFound, got %v", err)
+		}
+
+		// Scenario transitions record the status they left too
+		scenario := &Scenario{ID: "scenario-1", LabID: "default", Name: "Audited", Intent: "{}", Source: ScenarioSourceAPI}
+		if err := store.CreateActiveScenario(ctx, scenario, nil, nil); err != nil {
+			t.Fatalf("CreateActiveScenario() error = %v", err)
+		}
+		if err := store.UpdateScenarioCompleted(ctx, "scenario-1"); err != nil {
+			t.Fatalf("UpdateScenarioCompleted() error = %v", err)
+		}
+		if got := fmt.Sprint(statusChanges(AuditEntityScenario, "scenario-1")); got != "[active->completed]" {
+			t.Errorf("Expected scenario transitions [active->completed], got %s", got)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+// Package storage provides SQLite database access for the orchestrator.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backup writes a consistent copy of the live database to path using the
+// SQLite online backup API. Writers are not blocked while it runs. The
+// destination must not already exist.
+func (d *DB) Backup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup destination already exists: %s", path)
+	}
+
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup destination: %w", err)
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open backup destination: %w", err)
+	}
+	defer destConn.Close()
+
+	srcConn, err := d.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire database connection: %w", err)
+	}
+	defer srcConn.Close()
+
+	start := time.Now()
+	err = destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			destSQLite, ok := destDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", destDriver)
+			}
+			srcSQLite, ok := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", srcDriver)
+			}
+
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+
+			// Copy every page in one step so the copy is a single snapshot
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+	if err == nil {
+		// The copy inherits WAL mode from the source; switch it back so the
+		// backup is a single self-contained file
+		_, err = destConn.ExecContext(ctx, "PRAGMA journal_mode=DELETE")
+	}
+	if err != nil {
+		destConn.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	d.logger.Info().Str("path", path).Dur("duration", time.Since(start)).Msg("Database backed up")
+	return nil
+}
+
+// Restore replaces the database at dbPath with the backup at backupPath. It
+// must run before the database is opened. The backup is integrity checked
+// and refused if it was taken by a newer orchestrator; older backups are
+// brought up to date by the migrations run when the database is opened. The
+// replaced database is kept alongside as <dbPath>.pre-restore-<timestamp>.
+func Restore(ctx context.Context, backupPath, dbPath string) error {
+	if err := checkBackup(ctx, backupPath); err != nil {
+		return err
+	}
+
+	// Stage the copy next to the database so the final rename is atomic
+	tmp := dbPath + ".restore"
+	if err := copyFile(backupPath, tmp); err != nil {
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+	defer os.Remove(tmp) // no-op once renamed
+
+	if _, err := os.Stat(dbPath); err == nil {
+		previous := fmt.Sprintf("%s.pre-restore-%s", dbPath, time.Now().UTC().Format("20060102T150405Z"))
+		if err := os.Rename(dbPath, previous); err != nil {
+			return fmt.Errorf("failed to move existing database aside: %w", err)
+		}
+	}
+
+	// A WAL left by the replaced database must not be replayed into the backup
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", dbPath+suffix, err)
+		}
+	}
+
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return fmt.Errorf("failed to install backup: %w", err)
+	}
+	return nil
+}
+
+// checkBackup verifies that path is an intact orchestrator database whose
+// migrations are all known to this build.
+func checkBackup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+filepath.ToSlash(path)+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer db.Close()
+
+	var integrity string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&integrity); err != nil {
+		return fmt.Errorf("failed to check backup integrity: %w", err)
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("backup failed integrity check: %s", integrity)
+	}
+
+	known, err := new(DB).loadMigrations()
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+	latest := 0
+	for _, m := range known {
+		if m.version > latest {
+			latest = m.version
+		}
+	}
+
+	var applied sql.NullInt64
+	if err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_version").Scan(&applied); err != nil {
+		return fmt.Errorf("backup is not an orchestrator database: %w", err)
+	}
+	if int(applied.Int64) > latest {
+		return fmt.Errorf("backup schema version %d is newer than this orchestrator supports (%d)", applied.Int64, latest)
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -4,8 +4,11 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -15,8 +18,11 @@ type Job struct {
 	ScenarioID     *string
 	ScenarioStepID *string
 	AgentID        string
+	LabID          string // Always the lab of the assigned agent
 	ActionType     string
 	Parameters     map[string]interface{}
+	RunAsUser      *string // Domain user to impersonate, if any
+	RunAsLogonType *string
 	Status         string
 	Priority       int
 	ScheduledAt    time.Time
@@ -27,6 +33,7 @@ type Job struct {
 	ErrorMessage   *string
 	RetryCount     int
 	MaxRetries     int
+	RunCount       int // Times the job went back to pending to run again
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
 }
@@ -41,24 +48,32 @@ const (
 	JobStatusCancelled = "cancelled"
 )
 
-// CreateJob inserts a new job record.
+// ErrJobNotFound is returned when a job does not exist, or is not in the
+// status an update requires.
+var ErrJobNotFound = errors.New("job not found")
+
+// CreateJob inserts a new job record. The job's LabID is set to its agent's lab.
 func (d *DB) CreateJob(ctx context.Context, job *Job) error {
 	params, err := json.Marshal(job.Parameters)
 	if err != nil {
 		return fmt.Errorf("failed to marshal parameters: %w", err)
 	}
 
-	_, err = d.db.ExecContext(ctx, `
-		INSERT INTO jobs (id, scenario_id, scenario_step_id, agent_id, action_type, parameters,
-		                  status, priority, scheduled_at, max_retries)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, job.ID, job.ScenarioID, job.ScenarioStepID, job.AgentID, job.ActionType,
-		string(params), job.Status, job.Priority, job.ScheduledAt, job.MaxRetries)
+	err = d.db.QueryRowContext(ctx, `
+		INSERT INTO jobs (id, scenario_id, scenario_step_id, agent_id, lab_id, action_type, parameters,
+		                  run_as_user, run_as_logon_type, status, priority, scheduled_at, max_retries)
+		VALUES (?, ?, ?, ?, COALESCE((SELECT lab_id FROM agents WHERE id = ?), 'default'), ?, ?,
+		        ?, COALESCE(?, 'interactive'), ?, ?, ?, ?)
+		RETURNING lab_id
+	`, job.ID, job.ScenarioID, job.ScenarioStepID, job.AgentID, job.AgentID, job.ActionType,
+		string(params), job.RunAsUser, job.RunAsLogonType, job.Status, job.Priority, job.ScheduledAt, job.MaxRetries).Scan(&job.LabID)
 
 	if err != nil {
 		return fmt.Errorf("failed to insert job: %w", err)
 	}
 
+	d.Audit(ctx, AuditEntityJob, job.ID, AuditActionCreated, nil, jobAuditValue(job), nil)
+
 	d.logger.Debug().
 		Str("job_id", job.ID).
 		Str("agent_id", job.AgentID).
@@ -69,6 +84,7 @@ func (d *DB) CreateJob(ctx context.Context, job *Job) error {
 }
 
 // CreateJobBatch inserts multiple jobs in a single transaction.
+// Each job's LabID is set to its agent's lab.
 func (d *DB) CreateJobBatch(ctx context.Context, jobs []*Job) error {
 	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -76,10 +92,27 @@ func (d *DB) CreateJobBatch(ctx context.Context, jobs []*Job) error {
 	}
 	defer tx.Rollback()
 
+	if err := d.insertJobs(ctx, tx, jobs); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	d.logger.Info().Int("count", len(jobs)).Msg("Jobs batch created")
+	return nil
+}
+
+// insertJobs inserts jobs within tx, setting each job's LabID to its agent's
+// lab.
+func (d *DB) insertJobs(ctx context.Context, tx *sql.Tx, jobs []*Job) error {
 	stmt, err := tx.PrepareContext(ctx, `
-		INSERT INTO jobs (id, scenario_id, scenario_step_id, agent_id, action_type, parameters,
-		                  status, priority, scheduled_at, max_retries)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO jobs (id, scenario_id, scenario_step_id, agent_id, lab_id, action_type, parameters,
+		                  run_as_user, run_as_logon_type, status, priority, scheduled_at, max_retries)
+		VALUES (?, ?, ?, ?, COALESCE((SELECT lab_id FROM agents WHERE id = ?), 'default'), ?, ?,
+		        ?, COALESCE(?, 'interactive'), ?, ?, ?, ?)
+		RETURNING lab_id
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %w", err)
@@ -92,37 +125,22 @@ func (d *DB) CreateJobBatch(ctx context.Context, jobs []*Job) error {
 			return fmt.Errorf("failed to marshal parameters for job %s: %w", job.ID, err)
 		}
 
-		_, err = stmt.ExecContext(ctx, job.ID, job.ScenarioID, job.ScenarioStepID, job.AgentID,
-			job.ActionType, string(params), job.Status, job.Priority, job.ScheduledAt, job.MaxRetries)
+		err = stmt.QueryRowContext(ctx, job.ID, job.ScenarioID, job.ScenarioStepID, job.AgentID, job.AgentID,
+			job.ActionType, string(params), job.RunAsUser, job.RunAsLogonType, job.Status, job.Priority, job.ScheduledAt, job.MaxRetries).Scan(&job.LabID)
 		if err != nil {
 			return fmt.Errorf("failed to insert job %s: %w", job.ID, err)
 		}
+		d.auditWith(ctx, tx, AuditEntityJob, job.ID, AuditActionCreated, nil, jobAuditValue(job), nil)
 	}
-
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
-	d.logger.Info().Int("count", len(jobs)).Msg("Jobs batch created")
 	return nil
 }
 
 // GetJob retrieves a job by ID.
 func (d *DB) GetJob(ctx context.Context, id string) (*Job, error) {
-	var job Job
-	var paramsJSON, resultJSON sql.NullString
-
-	err := d.db.QueryRowContext(ctx, `
-		SELECT id, scenario_id, scenario_step_id, agent_id, action_type, parameters,
-		       status, priority, scheduled_at, assigned_at, started_at, completed_at,
-		       result, error_message, retry_count, max_retries, created_at, updated_at
+	job, err := scanJob(d.db.QueryRowContext(ctx, `
+		SELECT `+jobColumns+`
 		FROM jobs WHERE id = ?
-	`, id).Scan(
-		&job.ID, &job.ScenarioID, &job.ScenarioStepID, &job.AgentID, &job.ActionType,
-		&paramsJSON, &job.Status, &job.Priority, &job.ScheduledAt, &job.AssignedAt,
-		&job.StartedAt, &job.CompletedAt, &resultJSON, &job.ErrorMessage,
-		&job.RetryCount, &job.MaxRetries, &job.CreatedAt, &job.UpdatedAt,
-	)
+	`, id))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -131,19 +149,7 @@ func (d *DB) GetJob(ctx context.Context, id string) (*Job, error) {
 		return nil, fmt.Errorf("failed to get job: %w", err)
 	}
 
-	if paramsJSON.Valid {
-		if err := json.Unmarshal([]byte(paramsJSON.String), &job.Parameters); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal parameters: %w", err)
-		}
-	}
-
-	if resultJSON.Valid {
-		if err := json.Unmarshal([]byte(resultJSON.String), &job.Result); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal result: %w", err)
-		}
-	}
-
-	return &job, nil
+	return job, nil
 }
 
 // GetNextJobsForAgent retrieves the next pending jobs for a specific agent.
@@ -153,9 +159,7 @@ func (d *DB) GetJob(ctx context.Context, id string) (*Job, error) {
 // 3. Ordered by priority DESC, scheduled_at ASC
 func (d *DB) GetNextJobsForAgent(ctx context.Context, agentID string, limit int) ([]*Job, error) {
 	rows, err := d.db.QueryContext(ctx, `
-		SELECT id, scenario_id, scenario_step_id, agent_id, action_type, parameters,
-		       status, priority, scheduled_at, assigned_at, started_at, completed_at,
-		       result, error_message, retry_count, max_retries, created_at, updated_at
+		SELECT `+jobColumns+`
 		FROM jobs
 		WHERE agent_id = ? AND status = ? AND scheduled_at <= CURRENT_TIMESTAMP
 		ORDER BY priority DESC, scheduled_at ASC
@@ -190,10 +194,48 @@ func (d *DB) AssignJobs(ctx context.Context, jobIDs []string) error {
 	}
 	defer stmt.Close()
 
+	oldValue, newValue := statusChange(JobStatusPending, JobStatusAssigned)
 	for _, id := range jobIDs {
 		if _, err := stmt.ExecContext(ctx, JobStatusAssigned, id); err != nil {
 			return fmt.Errorf("failed to assign job %s: %w", id, err)
 		}
+		d.auditWith(ctx, tx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue, nil)
+	}
+
+	return tx.Commit()
+}
+
+// UnassignJobs returns assigned jobs that never reached their agent to
+// pending, so they are dispatched again. Jobs no longer assigned are skipped.
+func (d *DB) UnassignJobs(ctx context.Context, jobIDs []string) error {
+	if len(jobIDs) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		UPDATE jobs SET status = ?, assigned_at = NULL WHERE id = ? AND status = ?
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	oldValue, newValue := statusChange(JobStatusAssigned, JobStatusPending)
+	for _, id := range jobIDs {
+		res, err := stmt.ExecContext(ctx, JobStatusPending, id, JobStatusAssigned)
+		if err != nil {
+			return fmt.Errorf("failed to unassign job %s: %w", id, err)
+		}
+		if rows, _ := res.RowsAffected(); rows > 0 {
+			d.auditWith(ctx, tx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue,
+				map[string]interface{}{"reason": "undelivered"})
+		}
 	}
 
 	return tx.Commit()
@@ -211,14 +253,18 @@ func (d *DB) UpdateJobStarted(ctx context.Context, id string, startedAt time.Tim
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("job not found: %s", id)
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
 	}
 
+	oldValue, newValue := statusChange(JobStatusAssigned, JobStatusRunning)
+	d.Audit(ctx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue, nil)
+
 	return nil
 }
 
-// UpdateJobCompleted marks a job as completed with results.
-func (d *DB) UpdateJobCompleted(ctx context.Context, id string, completedAt time.Time, result map[string]interface{}) error {
+// UpdateJobCompleted marks a job as completed with results. Outbox entries
+// are written in the same transaction.
+func (d *DB) UpdateJobCompleted(ctx context.Context, id string, completedAt time.Time, result map[string]interface{}, outbox ...*OutboxEntry) error {
 	var resultJSON *string
 	if result != nil {
 		data, err := json.Marshal(result)
@@ -229,7 +275,22 @@ func (d *DB) UpdateJobCompleted(ctx context.Context, id string, completedAt time
 		resultJSON = &s
 	}
 
-	res, err := d.db.ExecContext(ctx, `
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var oldStatus string
+	err = tx.QueryRowContext(ctx, "SELECT status FROM jobs WHERE id = ?", id).Scan(&oldStatus)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get job status: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
 		UPDATE jobs SET status = ?, completed_at = ?, result = ? WHERE id = ?
 	`, JobStatusCompleted, completedAt, resultJSON, id)
 
@@ -237,53 +298,72 @@ func (d *DB) UpdateJobCompleted(ctx context.Context, id string, completedAt time
 		return fmt.Errorf("failed to update job completed: %w", err)
 	}
 
-	rows, _ := res.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("job not found: %s", id)
+	if err := insertOutboxEntries(ctx, tx, outbox); err != nil {
+		return err
+	}
+
+	oldValue, newValue := statusChange(oldStatus, JobStatusCompleted)
+	d.auditWith(ctx, tx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue, nil)
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	d.logger.Debug().Str("job_id", id).Msg("Job completed")
 	return nil
 }
 
-// UpdateJobFailed marks a job as failed with an error message.
-func (d *DB) UpdateJobFailed(ctx context.Context, id string, completedAt time.Time, errorMsg string, retry bool) error {
-	var status string
-	var retryIncrement int
+// UpdateJobFailed marks a job as failed with an error message. Outbox entries
+// are written in the same transaction.
+func (d *DB) UpdateJobFailed(ctx context.Context, id string, completedAt time.Time, errorMsg string, retry bool, outbox ...*OutboxEntry) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	if retry {
-		// Check if we should retry
-		job, err := d.GetJob(ctx, id)
-		if err != nil {
-			return err
-		}
-		if job == nil {
-			return fmt.Errorf("job not found: %s", id)
-		}
+	var oldStatus string
+	var retryCount, maxRetries int
+	err = tx.QueryRowContext(ctx, `
+		SELECT status, retry_count, max_retries FROM jobs WHERE id = ?
+	`, id).Scan(&oldStatus, &retryCount, &maxRetries)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get job status: %w", err)
+	}
 
-		if job.RetryCount < job.MaxRetries {
-			status = JobStatusPending // Reset to pending for retry
-			retryIncrement = 1
-		} else {
-			status = JobStatusFailed
-		}
-	} else {
-		status = JobStatusFailed
+	status := JobStatusFailed
+	retryIncrement := 0
+	if retry && retryCount < maxRetries {
+		status = JobStatusPending // Reset to pending for retry
+		retryIncrement = 1
 	}
 
-	res, err := d.db.ExecContext(ctx, `
+	_, err = tx.ExecContext(ctx, `
 		UPDATE jobs
-		SET status = ?, completed_at = ?, error_message = ?, retry_count = retry_count + ?
+		SET status = ?, completed_at = ?, error_message = ?, retry_count = retry_count + ?,
+		    run_count = run_count + ?
 		WHERE id = ?
-	`, status, completedAt, errorMsg, retryIncrement, id)
+	`, status, completedAt, errorMsg, retryIncrement, retryIncrement, id)
 
 	if err != nil {
 		return fmt.Errorf("failed to update job failed: %w", err)
 	}
 
-	rows, _ := res.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("job not found: %s", id)
+	if err := insertOutboxEntries(ctx, tx, outbox); err != nil {
+		return err
+	}
+
+	oldValue, newValue := statusChange(oldStatus, status)
+	d.auditWith(ctx, tx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue, map[string]interface{}{
+		"error":           errorMsg,
+		"retry_scheduled": status == JobStatusPending,
+	})
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	d.logger.Debug().
@@ -295,26 +375,66 @@ func (d *DB) UpdateJobFailed(ctx context.Context, id string, completedAt time.Ti
 	return nil
 }
 
+// RescheduleJob moves a pending job's scheduled time (e.g. to delay a retry).
+func (d *DB) RescheduleJob(ctx context.Context, id string, scheduledAt time.Time) error {
+	res, err := d.db.ExecContext(ctx, `
+		UPDATE jobs SET scheduled_at = ? WHERE id = ? AND status = ?
+	`, scheduledAt, id, JobStatusPending)
+
+	if err != nil {
+		return fmt.Errorf("failed to reschedule job: %w", err)
+	}
+
+	rows, _ := res.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	d.Audit(ctx, AuditEntityJob, id, AuditActionUpdated, nil, map[string]interface{}{"scheduled_at": scheduledAt}, nil)
+
+	return nil
+}
+
 // CancelJobsForScenario cancels all pending jobs for a scenario.
-func (d *DB) CancelJobsForScenario(ctx context.Context, scenarioID string) (int, error) {
-	result, err := d.db.ExecContext(ctx, `
+// It returns the number of cancelled jobs per action type.
+func (d *DB) CancelJobsForScenario(ctx context.Context, scenarioID string) (map[string]int, error) {
+	rows, err := d.db.QueryContext(ctx, `
 		UPDATE jobs SET status = ? WHERE scenario_id = ? AND status IN (?, ?)
+		RETURNING id, action_type
 	`, JobStatusCancelled, scenarioID, JobStatusPending, JobStatusAssigned)
-
 	if err != nil {
-		return 0, fmt.Errorf("failed to cancel jobs: %w", err)
+		return nil, fmt.Errorf("failed to cancel jobs: %w", err)
 	}
+	defer rows.Close()
 
-	rows, _ := result.RowsAffected()
-	return int(rows), nil
+	cancelled := make(map[string]int)
+	var ids []string
+	for rows.Next() {
+		var id, actionType string
+		if err := rows.Scan(&id, &actionType); err != nil {
+			return nil, fmt.Errorf("failed to scan cancelled job: %w", err)
+		}
+		cancelled[actionType]++
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to cancel jobs: %w", err)
+	}
+	rows.Close()
+
+	_, newValue := statusChange("", JobStatusCancelled)
+	for _, id := range ids {
+		d.Audit(ctx, AuditEntityJob, id, AuditActionStatusChanged, nil, newValue,
+			map[string]interface{}{"scenario_id": scenarioID})
+	}
+
+	return cancelled, nil
 }
 
 // ListJobsByScenario retrieves all jobs for a scenario.
 func (d *DB) ListJobsByScenario(ctx context.Context, scenarioID string) ([]*Job, error) {
 	rows, err := d.db.QueryContext(ctx, `
-		SELECT id, scenario_id, scenario_step_id, agent_id, action_type, parameters,
-		       status, priority, scheduled_at, assigned_at, started_at, completed_at,
-		       result, error_message, retry_count, max_retries, created_at, updated_at
+		SELECT `+jobColumns+`
 		FROM jobs WHERE scenario_id = ?
 		ORDER BY scheduled_at ASC
 	`, scenarioID)
@@ -334,9 +454,7 @@ func (d *DB) ListJobsByAgent(ctx context.Context, agentID string, status string,
 
 	if status != "" {
 		query = `
-			SELECT id, scenario_id, scenario_step_id, agent_id, action_type, parameters,
-			       status, priority, scheduled_at, assigned_at, started_at, completed_at,
-			       result, error_message, retry_count, max_retries, created_at, updated_at
+			SELECT ` + jobColumns + `
 			FROM jobs WHERE agent_id = ? AND status = ?
 			ORDER BY created_at DESC
 			LIMIT ?
@@ -344,9 +462,7 @@ func (d *DB) ListJobsByAgent(ctx context.Context, agentID string, status string,
 		args = []interface{}{agentID, status, limit}
 	} else {
 		query = `
-			SELECT id, scenario_id, scenario_step_id, agent_id, action_type, parameters,
-			       status, priority, scheduled_at, assigned_at, started_at, completed_at,
-			       result, error_message, retry_count, max_retries, created_at, updated_at
+			SELECT ` + jobColumns + `
 			FROM jobs WHERE agent_id = ?
 			ORDER BY created_at DESC
 			LIMIT ?
@@ -363,11 +479,242 @@ func (d *DB) ListJobsByAgent(ctx context.Context, agentID string, status string,
 	return d.scanJobs(rows)
 }
 
+// Job sort columns accepted by ListJobs.
+const (
+	JobSortScheduledAt = "scheduled_at"
+	JobSortCreatedAt   = "created_at"
+	JobSortUpdatedAt   = "updated_at"
+)
+
+// JobFilter selects jobs for ListJobs. Empty fields match everything.
+type JobFilter struct {
+	LabID          string
+	ScenarioID     string
+	ScenarioStepID string
+	AgentID        string
+	Statuses       []string
+	ActionTypes    []string
+	RunAsUser      string
+
+	// Since and Until bound the sort column (inclusive); zero means unbounded
+	Since time.Time
+	Until time.Time
+
+	// SortBy is one of the JobSort constants (default scheduled_at)
+	SortBy     string
+	Descending bool
+
+	// After continues a previous listing from its last job
+	After *JobCursor
+	Limit int
+}
+
+// JobCursor marks a position in a ListJobs ordering: the raw value of the
+// sort column and the job ID of the last job returned.
+type JobCursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// Encode returns the cursor as an opaque URL-safe string.
+func (c *JobCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseJobCursor decodes a cursor produced by JobCursor.Encode.
+func ParseJobCursor(s string) (*JobCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var c JobCursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &c, nil
+}
+
+// ListJobs retrieves one page of jobs matching the filter, ordered by the sort
+// column and then by ID. The returned cursor continues the listing and is nil
+// on the last page.
+func (d *DB) ListJobs(ctx context.Context, filter JobFilter) ([]*Job, *JobCursor, error) {
+	sortBy := filter.SortBy
+	switch sortBy {
+	case "":
+		sortBy = JobSortScheduledAt
+	case JobSortScheduledAt, JobSortCreatedAt, JobSortUpdatedAt:
+	default:
+		return nil, nil, fmt.Errorf("invalid sort column: %s", sortBy)
+	}
+	order, cmp := "ASC", ">"
+	if filter.Descending {
+		order, cmp = "DESC", "<"
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+
+	var where []string
+	var args []interface{}
+	addEq := func(column, value string) {
+		if value != "" {
+			where = append(where, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	addIn := func(column string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		where = append(where, column+" IN (?"+strings.Repeat(", ?", len(values)-1)+")")
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+
+	addEq("lab_id", filter.LabID)
+	addEq("scenario_id", filter.ScenarioID)
+	addEq("scenario_step_id", filter.ScenarioStepID)
+	addEq("agent_id", filter.AgentID)
+	addEq("run_as_user", filter.RunAsUser)
+	addIn("status", filter.Statuses)
+	addIn("action_type", filter.ActionTypes)
+	if !filter.Since.IsZero() {
+		where = append(where, sortBy+" >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, sortBy+" <= ?")
+		args = append(args, filter.Until.UTC())
+	}
+	if filter.After != nil {
+		where = append(where, fmt.Sprintf("(CAST(%[1]s AS TEXT) %[2]s ? OR (CAST(%[1]s AS TEXT) = ? AND id %[2]s ?))", sortBy, cmp))
+		args = append(args, filter.After.Value, filter.After.Value, filter.After.ID)
+	}
+
+	query := "SELECT " + jobColumns + ", CAST(" + sortBy + " AS TEXT) FROM jobs"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT ?", sortBy, order, order)
+	args = append(args, limit+1)
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	var sortValues []string
+	for rows.Next() {
+		var sortValue string
+		job, err := scanJob(rows, &sortValue)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, job)
+		sortValues = append(sortValues, sortValue)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+
+	if len(jobs) <= limit {
+		return jobs, nil, nil
+	}
+	jobs = jobs[:limit]
+	return jobs, &JobCursor{Value: sortValues[limit-1], ID: jobs[limit-1].ID}, nil
+}
+
+// RequeueJob returns a finished job to pending so it is dispatched again
+// immediately. Only jobs currently in fromStatus are requeued.
+func (d *DB) RequeueJob(ctx context.Context, id, fromStatus string) error {
+	res, err := d.db.ExecContext(ctx, `
+		UPDATE jobs SET status = ?, scheduled_at = CURRENT_TIMESTAMP, assigned_at = NULL,
+		       started_at = NULL, completed_at = NULL, result = NULL, error_message = NULL,
+		       run_count = run_count + 1
+		WHERE id = ? AND status = ?
+	`, JobStatusPending, id, fromStatus)
+
+	if err != nil {
+		return fmt.Errorf("failed to requeue job: %w", err)
+	}
+
+	rows, _ := res.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	oldValue, newValue := statusChange(fromStatus, JobStatusPending)
+	d.Audit(ctx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue,
+		map[string]interface{}{"reason": "manual_retry"})
+
+	return nil
+}
+
+// RerunJob returns a completed job to pending so it runs again at
+// scheduledAt, e.g. to re-check an objective that has not passed yet. The
+// last result is kept until the next one is reported.
+func (d *DB) RerunJob(ctx context.Context, id string, scheduledAt time.Time) error {
+	res, err := d.db.ExecContext(ctx, `
+		UPDATE jobs SET status = ?, scheduled_at = ?, assigned_at = NULL, started_at = NULL,
+		       run_count = run_count + 1
+		WHERE id = ? AND status = ?
+	`, JobStatusPending, scheduledAt, id, JobStatusCompleted)
+
+	if err != nil {
+		return fmt.Errorf("failed to rerun job: %w", err)
+	}
+
+	rows, _ := res.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	oldValue, newValue := statusChange(JobStatusCompleted, JobStatusPending)
+	d.Audit(ctx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue,
+		map[string]interface{}{"reason": "objective_retry", "scheduled_at": scheduledAt})
+
+	return nil
+}
+
+// CancelJob cancels a single job that is still in fromStatus.
+func (d *DB) CancelJob(ctx context.Context, id, fromStatus string) error {
+	res, err := d.db.ExecContext(ctx, `
+		UPDATE jobs SET status = ?, completed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?
+	`, JobStatusCancelled, id, fromStatus)
+
+	if err != nil {
+		return fmt.Errorf("failed to cancel job: %w", err)
+	}
+
+	rows, _ := res.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	oldValue, newValue := statusChange(fromStatus, JobStatusCancelled)
+	d.Audit(ctx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue,
+		map[string]interface{}{"reason": "manual_cancel"})
+
+	return nil
+}
+
 // CountJobsByStatus returns job counts grouped by status.
-func (d *DB) CountJobsByStatus(ctx context.Context) (map[string]int, error) {
-	rows, err := d.db.QueryContext(ctx, `
-		SELECT status, COUNT(*) FROM jobs GROUP BY status
-	`)
+// An empty labID counts jobs in all labs.
+func (d *DB) CountJobsByStatus(ctx context.Context, labID string) (map[string]int, error) {
+	query := "SELECT status, COUNT(*) FROM jobs"
+	var args []interface{}
+	if labID != "" {
+		query += " WHERE lab_id = ?"
+		args = append(args, labID)
+	}
+	query += " GROUP BY status"
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to count jobs: %w", err)
 	}
@@ -386,13 +733,14 @@ func (d *DB) CountJobsByStatus(ctx context.Context) (map[string]int, error) {
 	return counts, rows.Err()
 }
 
-// GetScenarioJobStats returns job statistics for a scenario.
-func (d *DB) GetScenarioJobStats(ctx context.Context, scenarioID string) (total, completed, failed, running, pending int, err error) {
+// GetScenarioJobStats returns job statistics for a scenario. Completed,
+// failed and cancelled jobs are finished.
+func (d *DB) GetScenarioJobStats(ctx context.Context, scenarioID string) (total, completed, failed, cancelled, running, pending int, err error) {
 	rows, err := d.db.QueryContext(ctx, `
 		SELECT status, COUNT(*) FROM jobs WHERE scenario_id = ? GROUP BY status
 	`, scenarioID)
 	if err != nil {
-		return 0, 0, 0, 0, 0, fmt.Errorf("failed to get job stats: %w", err)
+		return 0, 0, 0, 0, 0, 0, fmt.Errorf("failed to get job stats: %w", err)
 	}
 	defer rows.Close()
 
@@ -400,7 +748,7 @@ func (d *DB) GetScenarioJobStats(ctx context.Context, scenarioID string) (total,
 		var status string
 		var count int
 		if err := rows.Scan(&status, &count); err != nil {
-			return 0, 0, 0, 0, 0, err
+			return 0, 0, 0, 0, 0, 0, err
 		}
 		total += count
 		switch status {
@@ -408,6 +756,8 @@ func (d *DB) GetScenarioJobStats(ctx context.Context, scenarioID string) (total,
 			completed = count
 		case JobStatusFailed:
 			failed = count
+		case JobStatusCancelled:
+			cancelled = count
 		case JobStatusRunning:
 			running = count
 		case JobStatusPending, JobStatusAssigned:
@@ -415,7 +765,44 @@ func (d *DB) GetScenarioJobStats(ctx context.Context, scenarioID string) (total,
 		}
 	}
 
-	return total, completed, failed, running, pending, rows.Err()
+	return total, completed, failed, cancelled, running, pending, rows.Err()
+}
+
+// jobColumns is the column list read by scanJob.
+const jobColumns = `id, scenario_id, scenario_step_id, agent_id, lab_id, action_type, parameters,
+		       run_as_user, run_as_logon_type, status, priority, scheduled_at, assigned_at,
+		       started_at, completed_at, result, error_message, retry_count, max_retries,
+		       run_count, created_at, updated_at`
+
+// scanJob scans a row selected with jobColumns, followed by any extra
+// destinations for additional selected columns.
+func scanJob(row rowScanner, extra ...interface{}) (*Job, error) {
+	var job Job
+	var paramsJSON, resultJSON sql.NullString
+
+	dest := []interface{}{
+		&job.ID, &job.ScenarioID, &job.ScenarioStepID, &job.AgentID, &job.LabID, &job.ActionType,
+		&paramsJSON, &job.RunAsUser, &job.RunAsLogonType, &job.Status, &job.Priority,
+		&job.ScheduledAt, &job.AssignedAt, &job.StartedAt, &job.CompletedAt, &resultJSON,
+		&job.ErrorMessage, &job.RetryCount, &job.MaxRetries, &job.RunCount, &job.CreatedAt, &job.UpdatedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	if paramsJSON.Valid {
+		if err := json.Unmarshal([]byte(paramsJSON.String), &job.Parameters); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal parameters: %w", err)
+		}
+	}
+
+	if resultJSON.Valid {
+		if err := json.Unmarshal([]byte(resultJSON.String), &job.Result); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal result: %w", err)
+		}
+	}
+
+	return &job, nil
 }
 
 // scanJobs is a helper to scan multiple job rows.
@@ -423,36 +810,37 @@ func (d *DB) scanJobs(rows *sql.Rows) ([]*Job, error) {
 	var jobs []*Job
 
 	for rows.Next() {
-		var job Job
-		var paramsJSON, resultJSON sql.NullString
-
-		if err := rows.Scan(
-			&job.ID, &job.ScenarioID, &job.ScenarioStepID, &job.AgentID, &job.ActionType,
-			&paramsJSON, &job.Status, &job.Priority, &job.ScheduledAt, &job.AssignedAt,
-			&job.StartedAt, &job.CompletedAt, &resultJSON, &job.ErrorMessage,
-			&job.RetryCount, &job.MaxRetries, &job.CreatedAt, &job.UpdatedAt,
-		); err != nil {
+		job, err := scanJob(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan job: %w", err)
 		}
-
-		if paramsJSON.Valid {
-			if err := json.Unmarshal([]byte(paramsJSON.String), &job.Parameters); err != nil {
-				return nil, fmt.Errorf("failed to unmarshal parameters: %w", err)
-			}
-		}
-
-		if resultJSON.Valid {
-			if err := json.Unmarshal([]byte(resultJSON.String), &job.Result); err != nil {
-				return nil, fmt.Errorf("failed to unmarshal result: %w", err)
-			}
-		}
-
-		jobs = append(jobs, &job)
+		jobs = append(jobs, job)
 	}
 
 	return jobs, rows.Err()
 }
 
+// jobAuditValue describes a new job in the audit log.
+func jobAuditValue(job *Job) map[string]interface{} {
+	v := map[string]interface{}{
+		"agent_id":     job.AgentID,
+		"lab_id":       job.LabID,
+		"action_type":  job.ActionType,
+		"status":       job.Status,
+		"scheduled_at": job.ScheduledAt,
+	}
+	if job.ScenarioID != nil {
+		v["scenario_id"] = *job.ScenarioID
+	}
+	if job.ScenarioStepID != nil {
+		v["scenario_step_id"] = *job.ScenarioStepID
+	}
+	if job.RunAsUser != nil {
+		v["run_as_user"] = *job.RunAsUser
+	}
+	return v
+}
+
 // DeleteJob removes a job record.
 func (d *DB) DeleteJob(ctx context.Context, id string) error {
 	result, err := d.db.ExecContext(ctx, "DELETE FROM jobs WHERE id = ?", id)
@@ -462,9 +850,11 @@ func (d *DB) DeleteJob(ctx context.Context, id string) error {
 
 	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return fmt.Errorf("job not found: %s", id)
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
 	}
 
+	d.Audit(ctx, AuditEntityJob, id, AuditActionDeleted, nil, nil, nil)
+
 	return nil
 }
 
@@ -483,6 +873,12 @@ func (d *DB) CleanupOldJobs(ctx context.Context, olderThan time.Duration) (int,
 
 	rows, _ := result.RowsAffected()
 	if rows > 0 {
+		// One entry for the whole cleanup rather than one per job
+		d.Audit(ctx, AuditEntityJob, "", AuditActionDeleted, nil, nil, map[string]interface{}{
+			"reason":     "cleanup",
+			"count":      rows,
+			"older_than": olderThan.String(),
+		})
 		d.logger.Info().Int64("count", rows).Dur("older_than", olderThan).Msg("Cleaned up old jobs")
 	}
 
@@ -0,0 +1,218 @@
+// Package storage provides SQLite database access for the orchestrator.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultLabID is the lab used when no lab is specified.
+const DefaultLabID = "default"
+
+// Lab represents an isolated lab namespace.
+type Lab struct {
+	ID              string
+	Name            string
+	Description     string
+	AllowedNetworks []string // CIDRs
+	WebhookURL      string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// AllowsIP reports whether ip falls within the lab's allowed networks.
+// A lab without allowed networks accepts any address.
+func (l *Lab) AllowsIP(ip string) bool {
+	if len(l.AllowedNetworks) == 0 {
+		return true
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, cidr := range l.AllowedNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreateLab inserts a new lab.
+func (d *DB) CreateLab(ctx context.Context, lab *Lab) error {
+	networks, err := json.Marshal(nonNilStrings(lab.AllowedNetworks))
+	if err != nil {
+		return fmt.Errorf("failed to marshal allowed_networks: %w", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, `
+		INSERT INTO labs (id, name, description, allowed_networks, webhook_url)
+		VALUES (?, ?, ?, ?, ?)
+	`, lab.ID, lab.Name, nullIfEmpty(lab.Description), string(networks), nullIfEmpty(lab.WebhookURL))
+
+	if err != nil {
+		return fmt.Errorf("failed to insert lab: %w", err)
+	}
+
+	d.Audit(ctx, AuditEntityLab, lab.ID, AuditActionCreated, nil, labAuditValue(lab), nil)
+
+	d.logger.Info().Str("lab_id", lab.ID).Str("name", lab.Name).Msg("Lab created")
+	return nil
+}
+
+// GetLab retrieves a lab by ID.
+func (d *DB) GetLab(ctx context.Context, id string) (*Lab, error) {
+	row := d.db.QueryRowContext(ctx, `
+		SELECT id, name, description, allowed_networks, webhook_url, created_at, updated_at
+		FROM labs WHERE id = ?
+	`, id)
+
+	return scanLab(row)
+}
+
+// ListLabs returns all labs ordered by ID.
+func (d *DB) ListLabs(ctx context.Context) ([]*Lab, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, name, description, allowed_networks, webhook_url, created_at, updated_at
+		FROM labs
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labs: %w", err)
+	}
+	defer rows.Close()
+
+	var labs []*Lab
+	for rows.Next() {
+		lab, err := scanLab(rows)
+		if err != nil {
+			return nil, err
+		}
+		labs = append(labs, lab)
+	}
+
+	return labs, rows.Err()
+}
+
+// UpdateLab updates a lab's mutable fields.
+func (d *DB) UpdateLab(ctx context.Context, lab *Lab) error {
+	networks, err := json.Marshal(nonNilStrings(lab.AllowedNetworks))
+	if err != nil {
+		return fmt.Errorf("failed to marshal allowed_networks: %w", err)
+	}
+
+	result, err := d.db.ExecContext(ctx, `
+		UPDATE labs SET name = ?, description = ?, allowed_networks = ?, webhook_url = ?
+		WHERE id = ?
+	`, lab.Name, nullIfEmpty(lab.Description), string(networks), nullIfEmpty(lab.WebhookURL), lab.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update lab: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("lab not found: %s", lab.ID)
+	}
+
+	d.Audit(ctx, AuditEntityLab, lab.ID, AuditActionUpdated, nil, labAuditValue(lab), nil)
+
+	return nil
+}
+
+// DeleteLab removes a lab. It fails if any agents, scenarios or users still belong to it.
+func (d *DB) DeleteLab(ctx context.Context, id string) error {
+	var inUse int
+	err := d.db.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM agents WHERE lab_id = ?)
+		     + (SELECT COUNT(*) FROM scenarios WHERE lab_id = ?)
+		     + (SELECT COUNT(*) FROM impersonation_users WHERE lab_id = ?)
+	`, id, id, id).Scan(&inUse)
+	if err != nil {
+		return fmt.Errorf("failed to check lab usage: %w", err)
+	}
+	if inUse > 0 {
+		return fmt.Errorf("lab not empty: %s", id)
+	}
+
+	result, err := d.db.ExecContext(ctx, "DELETE FROM labs WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete lab: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("lab not found: %s", id)
+	}
+
+	d.Audit(ctx, AuditEntityLab, id, AuditActionDeleted, nil, nil, nil)
+
+	d.logger.Info().Str("lab_id", id).Msg("Lab deleted")
+	return nil
+}
+
+// labAuditValue describes a lab in the audit log.
+func labAuditValue(lab *Lab) map[string]interface{} {
+	return map[string]interface{}{
+		"name":             lab.Name,
+		"description":      lab.Description,
+		"allowed_networks": nonNilStrings(lab.AllowedNetworks),
+		"webhook_url":      lab.WebhookURL,
+	}
+}
+
+func scanLab(row rowScanner) (*Lab, error) {
+	var lab Lab
+	var description, webhookURL sql.NullString
+	var networksJSON string
+
+	err := row.Scan(&lab.ID, &lab.Name, &description, &networksJSON, &webhookURL, &lab.CreatedAt, &lab.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan lab: %w", err)
+	}
+
+	lab.Description = description.String
+	lab.WebhookURL = webhookURL.String
+
+	if err := json.Unmarshal([]byte(networksJSON), &lab.AllowedNetworks); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal allowed_networks: %w", err)
+	}
+
+	return &lab, nil
+}
+
+// labOrDefault returns the lab ID, falling back to the default lab.
+func labOrDefault(labID string) string {
+	if labID == "" {
+		return DefaultLabID
+	}
+	return labID
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,2470 @@
+// Package storage provides SQLite database access for the orchestrator.
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// Memory is an in-process Store with the same semantics as DB: the same
+// defaults, uniqueness rules, error messages and audit entries. Nothing is
+// persisted, so it suits tests and ephemeral demo orchestrators. Values are
+// copied on the way in and out, so callers never share state with the store.
+type Memory struct {
+	mu     sync.Mutex
+	logger zerolog.Logger
+	closed bool
+
+	agents    *table[Agent]
+	jobs      *table[Job]
+	scenarios *table[Scenario]
+	steps     *table[ScenarioStep]
+	users     *table[ImpersonationUser]
+	labs      *table[Lab]
+	apiKeys   *table[APIKey]
+	webhooks  *table[WebhookSubscription]
+	outbox    *table[OutboxEntry]
+
+	// deliveries holds each subscription's delivery history, oldest first
+	deliveries map[string][]*WebhookDelivery
+
+	audit       []*AuditEntry
+	nextAuditID int64
+}
+
+// NewMemory creates an empty in-memory store containing only the default lab.
+func NewMemory(logger zerolog.Logger) *Memory {
+	logger = logger.With().Str("component", "storage").Logger()
+
+	m := &Memory{
+		logger:    logger,
+		agents:    newTable[Agent](),
+		jobs:      newTable[Job](),
+		scenarios: newTable[Scenario](),
+		steps:     newTable[ScenarioStep](),
+		users:     newTable[ImpersonationUser](),
+		labs:      newTable[Lab](),
+		apiKeys:   newTable[APIKey](),
+		webhooks:  newTable[WebhookSubscription](),
+		outbox:    newTable[OutboxEntry](),
+
+		deliveries: make(map[string][]*WebhookDelivery),
+	}
+
+	// Mirrors the default lab inserted by the labs migration
+	now := m.now()
+	m.labs.insert(DefaultLabID, &Lab{
+		ID:              DefaultLabID,
+		Name:            "Default Lab",
+		Description:     "Lab for agents and scenarios registered without a lab ID",
+		AllowedNetworks: []string{},
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	})
+
+	logger.Info().Msg("Using in-memory store; data is not persisted")
+	return m
+}
+
+// Close releases the store. Ping and Health report it as closed afterwards.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+// Ping verifies the store has not been closed.
+func (m *Memory) Ping(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return fmt.Errorf("store is closed")
+	}
+	return nil
+}
+
+// Health returns the health status of the store.
+func (m *Memory) Health(ctx context.Context) (string, error) {
+	if err := m.Ping(ctx); err != nil {
+		return "unhealthy", err
+	}
+	return "healthy", nil
+}
+
+func (m *Memory) now() time.Time {
+	return time.Now().UTC()
+}
+
+// ============================================================
+// Agents
+// ============================================================
+
+// CreateAgent inserts a new agent record.
+func (m *Memory) CreateAgent(ctx context.Context, agent *Agent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent.LabID = labOrDefault(agent.LabID)
+
+	stored := cloneAgent(agent)
+	stored.UpdatedAt = m.now()
+	if !m.agents.insert(agent.ID, stored) {
+		return fmt.Errorf("failed to insert agent: %w", errUnique("agents.id"))
+	}
+
+	m.auditLocked(ctx, AuditEntityAgent, agent.ID, AuditActionCreated, nil, map[string]interface{}{
+		"lab_id":      agent.LabID,
+		"lab_host_id": agent.LabHostID,
+		"hostname":    agent.Hostname,
+		"ip_address":  agent.IPAddress,
+		"labels":      agent.Labels,
+		"version":     agent.Version,
+		"status":      agent.Status,
+	}, nil)
+
+	m.logger.Info().
+		Str("agent_id", agent.ID).
+		Str("lab_id", agent.LabID).
+		Str("lab_host_id", agent.LabHostID).
+		Str("hostname", agent.Hostname).
+		Msg("Agent created")
+
+	return nil
+}
+
+// GetAgent retrieves an agent by ID.
+func (m *Memory) GetAgent(ctx context.Context, id string) (*Agent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if agent := m.agents.get(id); agent != nil {
+		return cloneAgent(agent), nil
+	}
+	return nil, nil
+}
+
+// GetAgentByLabHostID retrieves an agent by lab host ID.
+func (m *Memory) GetAgentByLabHostID(ctx context.Context, labHostID string) (*Agent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, agent := range m.agents.all() {
+		if agent.LabHostID == labHostID {
+			return cloneAgent(agent), nil
+		}
+	}
+	return nil, nil
+}
+
+// UpdateAgentHeartbeat updates the agent's heartbeat timestamp and status.
+func (m *Memory) UpdateAgentHeartbeat(ctx context.Context, id string, status string, ipAddress string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent := m.agents.get(id)
+	if agent == nil {
+		return fmt.Errorf("agent not found: %s", id)
+	}
+
+	now := m.now()
+	agent.LastHeartbeatAt = now
+	agent.Status = status
+	agent.IPAddress = ipAddress
+	agent.UpdatedAt = now
+
+	m.logger.Debug().
+		Str("agent_id", id).
+		Str("status", status).
+		Msg("Agent heartbeat updated")
+
+	return nil
+}
+
+// UpdateAgentCapabilities records the version and capabilities an agent
+// reported when it re-registered.
+func (m *Memory) UpdateAgentCapabilities(ctx context.Context, id string, version string, caps *AgentCapabilities) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent := m.agents.get(id)
+	if agent == nil {
+		return fmt.Errorf("agent not found: %s", id)
+	}
+	agent.Version = version
+	agent.Capabilities = cloneCapabilities(caps)
+	agent.UpdatedAt = m.now()
+
+	return nil
+}
+
+// UpdateAgentLab moves an agent into a different lab.
+func (m *Memory) UpdateAgentLab(ctx context.Context, id string, labID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labID = labOrDefault(labID)
+	agent := m.agents.get(id)
+	if agent == nil {
+		return fmt.Errorf("agent not found: %s", id)
+	}
+	agent.LabID = labID
+	agent.UpdatedAt = m.now()
+
+	m.auditLocked(ctx, AuditEntityAgent, id, AuditActionUpdated, nil, map[string]interface{}{"lab_id": labID}, nil)
+
+	return nil
+}
+
+// UpdateAgentStatus updates the agent's status.
+func (m *Memory) UpdateAgentStatus(ctx context.Context, id string, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent := m.agents.get(id)
+	if agent == nil {
+		return fmt.Errorf("agent not found: %s", id)
+	}
+	agent.Status = status
+	agent.UpdatedAt = m.now()
+
+	oldValue, newValue := statusChange("", status)
+	m.auditLocked(ctx, AuditEntityAgent, id, AuditActionStatusChanged, oldValue, newValue, nil)
+
+	return nil
+}
+
+// ListAgents retrieves agents, optionally filtered by lab and status.
+// An empty labID lists agents in all labs.
+func (m *Memory) ListAgents(ctx context.Context, labID, status string) ([]*Agent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.listAgentsLocked(labID, status), nil
+}
+
+func (m *Memory) listAgentsLocked(labID, status string) []*Agent {
+	var agents []*Agent
+	for _, agent := range m.agents.all() {
+		if (labID == "" || agent.LabID == labID) && (status == "" || agent.Status == status) {
+			agents = append(agents, cloneAgent(agent))
+		}
+	}
+	sort.SliceStable(agents, func(i, j int) bool {
+		return agents[i].RegisteredAt.After(agents[j].RegisteredAt)
+	})
+	return agents
+}
+
+// ListAgentsByLabels retrieves online agents in a lab matching the given label selector.
+// Label matching never crosses labs.
+func (m *Memory) ListAgentsByLabels(ctx context.Context, labID string, labels map[string]string) ([]*Agent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matched []*Agent
+	for _, agent := range m.listAgentsLocked(labOrDefault(labID), AgentStatusOnline) {
+		if matchLabels(agent.Labels, labels) {
+			matched = append(matched, agent)
+		}
+	}
+	return matched, nil
+}
+
+// MarkStaleAgentsOffline marks agents as offline if they haven't sent a heartbeat recently.
+// It returns the agents whose status changed.
+func (m *Memory) MarkStaleAgentsOffline(ctx context.Context, timeout time.Duration) ([]StaleAgent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := time.Now().Add(-timeout)
+	now := m.now()
+
+	var stale []StaleAgent
+	for _, agent := range m.agents.all() {
+		if agent.Status != AgentStatusOnline || !agent.LastHeartbeatAt.Before(cutoff) {
+			continue
+		}
+		agent.Status = AgentStatusOffline
+		agent.UpdatedAt = now
+		stale = append(stale, StaleAgent{ID: agent.ID, LabID: agent.LabID})
+	}
+
+	for _, a := range stale {
+		oldValue, newValue := statusChange(AgentStatusOnline, AgentStatusOffline)
+		m.auditLocked(ctx, AuditEntityAgent, a.ID, AuditActionStatusChanged, oldValue, newValue,
+			map[string]interface{}{"reason": "heartbeat_timeout", "timeout": timeout.String()})
+	}
+
+	if len(stale) > 0 {
+		m.logger.Info().
+			Int("count", len(stale)).
+			Dur("timeout", timeout).
+			Msg("Marked stale agents as offline")
+	}
+
+	return stale, nil
+}
+
+// DeleteAgent removes an agent record.
+func (m *Memory) DeleteAgent(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent := m.agents.remove(id)
+	if agent == nil {
+		return fmt.Errorf("agent not found: %s", id)
+	}
+
+	m.auditLocked(ctx, AuditEntityAgent, id, AuditActionDeleted, map[string]interface{}{
+		"lab_id":      agent.LabID,
+		"lab_host_id": agent.LabHostID,
+		"hostname":    agent.Hostname,
+	}, nil, nil)
+
+	m.logger.Info().Str("agent_id", id).Msg("Agent deleted")
+	return nil
+}
+
+// CountAgents returns the total number of agents, optionally filtered by status.
+func (m *Memory) CountAgents(ctx context.Context, status string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, agent := range m.agents.all() {
+		if status == "" || agent.Status == status {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// AgentExists checks if an agent with the given ID exists.
+func (m *Memory) AgentExists(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.agents.get(id) != nil, nil
+}
+
+// ============================================================
+// Jobs
+// ============================================================
+
+// CreateJob inserts a new job record. The job's LabID is set to its agent's lab.
+func (m *Memory) CreateJob(ctx context.Context, job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.jobs.get(job.ID) != nil {
+		return fmt.Errorf("failed to insert job: %w", errUnique("jobs.id"))
+	}
+	stored, err := m.newJobLocked(job)
+	if err != nil {
+		return err
+	}
+	m.jobs.insert(job.ID, stored)
+
+	m.auditLocked(ctx, AuditEntityJob, job.ID, AuditActionCreated, nil, jobAuditValue(job), nil)
+
+	m.logger.Debug().
+		Str("job_id", job.ID).
+		Str("agent_id", job.AgentID).
+		Str("action", job.ActionType).
+		Msg("Job created")
+
+	return nil
+}
+
+// CreateJobBatch inserts multiple jobs atomically.
+// Each job's LabID is set to its agent's lab.
+func (m *Memory) CreateJobBatch(ctx context.Context, jobs []*Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Build every row before inserting any, so a failure leaves nothing behind
+	stored, err := m.newJobRowsLocked(jobs)
+	if err != nil {
+		return err
+	}
+	m.insertJobRowsLocked(ctx, jobs, stored)
+
+	m.logger.Info().Int("count", len(jobs)).Msg("Jobs batch created")
+	return nil
+}
+
+// newJobRowsLocked builds the stored rows for new jobs without inserting
+// them, failing if any ID is taken.
+func (m *Memory) newJobRowsLocked(jobs []*Job) ([]*Job, error) {
+	stored := make([]*Job, len(jobs))
+	seen := make(map[string]bool, len(jobs))
+	for i, job := range jobs {
+		if seen[job.ID] || m.jobs.get(job.ID) != nil {
+			return nil, fmt.Errorf("failed to insert job %s: %w", job.ID, errUnique("jobs.id"))
+		}
+		seen[job.ID] = true
+
+		row, err := m.newJobLocked(job)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal parameters for job %s: %w", job.ID, err)
+		}
+		stored[i] = row
+	}
+	return stored, nil
+}
+
+// insertJobRowsLocked inserts rows built by newJobRowsLocked.
+func (m *Memory) insertJobRowsLocked(ctx context.Context, jobs, stored []*Job) {
+	for i, job := range jobs {
+		m.jobs.insert(job.ID, stored[i])
+		m.auditLocked(ctx, AuditEntityJob, job.ID, AuditActionCreated, nil, jobAuditValue(job), nil)
+	}
+}
+
+// newJobLocked builds the stored row for a new job, applying the column
+// defaults, and sets job.LabID to the lab of its agent.
+func (m *Memory) newJobLocked(job *Job) (*Job, error) {
+	params, err := normalizeJSON(job.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal parameters: %w", err)
+	}
+
+	job.LabID = DefaultLabID
+	if agent := m.agents.get(job.AgentID); agent != nil {
+		job.LabID = agent.LabID
+	}
+
+	now := m.now()
+	logonType := "interactive"
+	if job.RunAsLogonType != nil {
+		logonType = *job.RunAsLogonType
+	}
+
+	return &Job{
+		ID:             job.ID,
+		ScenarioID:     clonePtr(job.ScenarioID),
+		ScenarioStepID: clonePtr(job.ScenarioStepID),
+		AgentID:        job.AgentID,
+		LabID:          job.LabID,
+		ActionType:     job.ActionType,
+		Parameters:     params,
+		RunAsUser:      clonePtr(job.RunAsUser),
+		RunAsLogonType: &logonType,
+		Status:         job.Status,
+		Priority:       job.Priority,
+		ScheduledAt:    job.ScheduledAt,
+		MaxRetries:     job.MaxRetries,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}, nil
+}
+
+// GetJob retrieves a job by ID.
+func (m *Memory) GetJob(ctx context.Context, id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job := m.jobs.get(id); job != nil {
+		return cloneJob(job), nil
+	}
+	return nil, nil
+}
+
+// GetNextJobsForAgent retrieves the next pending jobs for a specific agent,
+// highest priority first and then in scheduled order.
+func (m *Memory) GetNextJobsForAgent(ctx context.Context, agentID string, limit int) ([]*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	jobs := m.selectJobsLocked(func(job *Job) bool {
+		return job.AgentID == agentID && job.Status == JobStatusPending && !job.ScheduledAt.After(now)
+	})
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if jobs[i].Priority != jobs[j].Priority {
+			return jobs[i].Priority > jobs[j].Priority
+		}
+		return jobs[i].ScheduledAt.Before(jobs[j].ScheduledAt)
+	})
+
+	return limitRows(jobs, limit), nil
+}
+
+// AssignJobs marks jobs as assigned to an agent (changes status from pending to assigned).
+func (m *Memory) AssignJobs(ctx context.Context, jobIDs []string) error {
+	if len(jobIDs) == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	oldValue, newValue := statusChange(JobStatusPending, JobStatusAssigned)
+	for _, id := range jobIDs {
+		if job := m.jobs.get(id); job != nil {
+			job.Status = JobStatusAssigned
+			job.AssignedAt = &now
+			job.UpdatedAt = now
+		}
+		m.auditLocked(ctx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue, nil)
+	}
+
+	return nil
+}
+
+// UnassignJobs returns assigned jobs that never reached their agent to
+// pending, so they are dispatched again. Jobs no longer assigned are skipped.
+func (m *Memory) UnassignJobs(ctx context.Context, jobIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	oldValue, newValue := statusChange(JobStatusAssigned, JobStatusPending)
+	for _, id := range jobIDs {
+		job := m.jobs.get(id)
+		if job == nil || job.Status != JobStatusAssigned {
+			continue
+		}
+		job.Status = JobStatusPending
+		job.AssignedAt = nil
+		job.UpdatedAt = now
+		m.auditLocked(ctx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue,
+			map[string]interface{}{"reason": "undelivered"})
+	}
+
+	return nil
+}
+
+// UpdateJobStarted marks a job as running with a start time.
+func (m *Memory) UpdateJobStarted(ctx context.Context, id string, startedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.jobs.get(id)
+	if job == nil {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	job.Status = JobStatusRunning
+	job.StartedAt = &startedAt
+	job.UpdatedAt = m.now()
+
+	oldValue, newValue := statusChange(JobStatusAssigned, JobStatusRunning)
+	m.auditLocked(ctx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue, nil)
+
+	return nil
+}
+
+// UpdateJobCompleted marks a job as completed with results. Outbox entries
+// are added with it.
+func (m *Memory) UpdateJobCompleted(ctx context.Context, id string, completedAt time.Time, result map[string]interface{}, outbox ...*OutboxEntry) error {
+	stored, err := normalizeJSON(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.jobs.get(id)
+	if job == nil {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	oldStatus := job.Status
+	job.Status = JobStatusCompleted
+	job.CompletedAt = &completedAt
+	job.Result = stored
+	job.UpdatedAt = m.now()
+	m.insertOutboxLocked(outbox)
+
+	oldValue, newValue := statusChange(oldStatus, JobStatusCompleted)
+	m.auditLocked(ctx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue, nil)
+
+	m.logger.Debug().Str("job_id", id).Msg("Job completed")
+	return nil
+}
+
+// UpdateJobFailed marks a job as failed with an error message. With retry,
+// a job that has retries left goes back to pending instead. Outbox entries
+// are added with it.
+func (m *Memory) UpdateJobFailed(ctx context.Context, id string, completedAt time.Time, errorMsg string, retry bool, outbox ...*OutboxEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.jobs.get(id)
+	if job == nil {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	oldStatus := job.Status
+	status := JobStatusFailed
+	if retry && job.RetryCount < job.MaxRetries {
+		status = JobStatusPending // Reset to pending for retry
+		job.RetryCount++
+		job.RunCount++
+	}
+	job.Status = status
+	job.CompletedAt = &completedAt
+	job.ErrorMessage = &errorMsg
+	job.UpdatedAt = m.now()
+	m.insertOutboxLocked(outbox)
+
+	oldValue, newValue := statusChange(oldStatus, status)
+	m.auditLocked(ctx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue, map[string]interface{}{
+		"error":           errorMsg,
+		"retry_scheduled": status == JobStatusPending,
+	})
+
+	m.logger.Debug().
+		Str("job_id", id).
+		Str("status", status).
+		Bool("retry", retry && status == JobStatusPending).
+		Msg("Job failed")
+
+	return nil
+}
+
+// RescheduleJob moves a pending job's scheduled time (e.g. to delay a retry).
+func (m *Memory) RescheduleJob(ctx context.Context, id string, scheduledAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.jobs.get(id)
+	if job == nil || job.Status != JobStatusPending {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	job.ScheduledAt = scheduledAt
+	job.UpdatedAt = m.now()
+
+	m.auditLocked(ctx, AuditEntityJob, id, AuditActionUpdated, nil, map[string]interface{}{"scheduled_at": scheduledAt}, nil)
+
+	return nil
+}
+
+// CancelJobsForScenario cancels all pending jobs for a scenario.
+// It returns the number of cancelled jobs per action type.
+func (m *Memory) CancelJobsForScenario(ctx context.Context, scenarioID string) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	cancelled := make(map[string]int)
+	var ids []string
+	for _, job := range m.jobs.all() {
+		if job.ScenarioID == nil || *job.ScenarioID != scenarioID {
+			continue
+		}
+		if job.Status != JobStatusPending && job.Status != JobStatusAssigned {
+			continue
+		}
+		job.Status = JobStatusCancelled
+		job.UpdatedAt = now
+		cancelled[job.ActionType]++
+		ids = append(ids, job.ID)
+	}
+
+	_, newValue := statusChange("", JobStatusCancelled)
+	for _, id := range ids {
+		m.auditLocked(ctx, AuditEntityJob, id, AuditActionStatusChanged, nil, newValue,
+			map[string]interface{}{"scenario_id": scenarioID})
+	}
+
+	return cancelled, nil
+}
+
+// ListJobsByScenario retrieves all jobs for a scenario.
+func (m *Memory) ListJobsByScenario(ctx context.Context, scenarioID string) ([]*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := m.selectJobsLocked(func(job *Job) bool {
+		return job.ScenarioID != nil && *job.ScenarioID == scenarioID
+	})
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].ScheduledAt.Before(jobs[j].ScheduledAt)
+	})
+	return jobs, nil
+}
+
+// ListJobsByAgent retrieves jobs for a specific agent, optionally filtered by status.
+func (m *Memory) ListJobsByAgent(ctx context.Context, agentID string, status string, limit int) ([]*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := m.selectJobsLocked(func(job *Job) bool {
+		return job.AgentID == agentID && (status == "" || job.Status == status)
+	})
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return limitRows(jobs, limit), nil
+}
+
+// ListJobs retrieves one page of jobs matching the filter, ordered by the sort
+// column and then by ID. The returned cursor continues the listing and is nil
+// on the last page.
+func (m *Memory) ListJobs(ctx context.Context, filter JobFilter) ([]*Job, *JobCursor, error) {
+	sortBy := filter.SortBy
+	switch sortBy {
+	case "":
+		sortBy = JobSortScheduledAt
+	case JobSortScheduledAt, JobSortCreatedAt, JobSortUpdatedAt:
+	default:
+		return nil, nil, fmt.Errorf("invalid sort column: %s", sortBy)
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+
+	// Cursor values are fixed-width UTC timestamps, so they order as strings
+	// the same way the times they encode do
+	sortValue := func(job *Job) string {
+		var t time.Time
+		switch sortBy {
+		case JobSortCreatedAt:
+			t = job.CreatedAt
+		case JobSortUpdatedAt:
+			t = job.UpdatedAt
+		default:
+			t = job.ScheduledAt
+		}
+		return t.UTC().Format("2006-01-02 15:04:05.000000000")
+	}
+	before := func(a, b *Job) bool {
+		va, vb := sortValue(a), sortValue(b)
+		if va != vb {
+			return va < vb
+		}
+		return a.ID < b.ID
+	}
+	if filter.Descending {
+		asc := before
+		before = func(a, b *Job) bool { return asc(b, a) }
+	}
+
+	var since, until string
+	if !filter.Since.IsZero() {
+		since = filter.Since.UTC().Format("2006-01-02 15:04:05.000000000")
+	}
+	if !filter.Until.IsZero() {
+		until = filter.Until.UTC().Format("2006-01-02 15:04:05.000000000")
+	}
+
+	m.mu.Lock()
+	jobs := m.selectJobsLocked(func(job *Job) bool {
+		switch {
+		case filter.LabID != "" && job.LabID != filter.LabID,
+			filter.ScenarioID != "" && derefString(job.ScenarioID) != filter.ScenarioID,
+			filter.ScenarioStepID != "" && derefString(job.ScenarioStepID) != filter.ScenarioStepID,
+			filter.AgentID != "" && job.AgentID != filter.AgentID,
+			filter.RunAsUser != "" && derefString(job.RunAsUser) != filter.RunAsUser,
+			len(filter.Statuses) > 0 && !containsString(filter.Statuses, job.Status),
+			len(filter.ActionTypes) > 0 && !containsString(filter.ActionTypes, job.ActionType),
+			since != "" && sortValue(job) < since,
+			until != "" && sortValue(job) > until:
+			return false
+		}
+		if filter.After == nil {
+			return true
+		}
+		// Keep jobs strictly past the cursor in the listing order
+		if v := sortValue(job); v != filter.After.Value {
+			return (v > filter.After.Value) != filter.Descending
+		}
+		return job.ID != filter.After.ID && (job.ID > filter.After.ID) != filter.Descending
+	})
+	m.mu.Unlock()
+
+	sort.SliceStable(jobs, func(i, j int) bool { return before(jobs[i], jobs[j]) })
+
+	if len(jobs) <= limit {
+		return jobs, nil, nil
+	}
+	jobs = jobs[:limit]
+	last := jobs[limit-1]
+	return jobs, &JobCursor{Value: sortValue(last), ID: last.ID}, nil
+}
+
+// RequeueJob returns a finished job to pending so it is dispatched again
+// immediately. Only jobs currently in fromStatus are requeued.
+func (m *Memory) RequeueJob(ctx context.Context, id, fromStatus string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.jobs.get(id)
+	if job == nil || job.Status != fromStatus {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	now := m.now()
+	job.Status = JobStatusPending
+	job.ScheduledAt = now
+	job.AssignedAt = nil
+	job.StartedAt = nil
+	job.CompletedAt = nil
+	job.Result = nil
+	job.ErrorMessage = nil
+	job.RunCount++
+	job.UpdatedAt = now
+
+	oldValue, newValue := statusChange(fromStatus, JobStatusPending)
+	m.auditLocked(ctx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue,
+		map[string]interface{}{"reason": "manual_retry"})
+
+	return nil
+}
+
+// RerunJob returns a completed job to pending so it runs again at
+// scheduledAt, keeping its last result until the next one is reported.
+func (m *Memory) RerunJob(ctx context.Context, id string, scheduledAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.jobs.get(id)
+	if job == nil || job.Status != JobStatusCompleted {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	job.Status = JobStatusPending
+	job.ScheduledAt = scheduledAt
+	job.AssignedAt = nil
+	job.StartedAt = nil
+	job.RunCount++
+	job.UpdatedAt = m.now()
+
+	oldValue, newValue := statusChange(JobStatusCompleted, JobStatusPending)
+	m.auditLocked(ctx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue,
+		map[string]interface{}{"reason": "objective_retry", "scheduled_at": scheduledAt})
+
+	return nil
+}
+
+// CancelJob cancels a single job that is still in fromStatus.
+func (m *Memory) CancelJob(ctx context.Context, id, fromStatus string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.jobs.get(id)
+	if job == nil || job.Status != fromStatus {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	now := m.now()
+	job.Status = JobStatusCancelled
+	job.CompletedAt = &now
+	job.UpdatedAt = now
+
+	oldValue, newValue := statusChange(fromStatus, JobStatusCancelled)
+	m.auditLocked(ctx, AuditEntityJob, id, AuditActionStatusChanged, oldValue, newValue,
+		map[string]interface{}{"reason": "manual_cancel"})
+
+	return nil
+}
+
+// CountJobsByStatus returns job counts grouped by status.
+// An empty labID counts jobs in all labs.
+func (m *Memory) CountJobsByStatus(ctx context.Context, labID string) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, job := range m.jobs.all() {
+		if labID == "" || job.LabID == labID {
+			counts[job.Status]++
+		}
+	}
+	return counts, nil
+}
+
+// GetScenarioJobStats returns job statistics for a scenario. Completed,
+// failed and cancelled jobs are finished.
+func (m *Memory) GetScenarioJobStats(ctx context.Context, scenarioID string) (total, completed, failed, cancelled, running, pending int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, job := range m.jobs.all() {
+		if job.ScenarioID == nil || *job.ScenarioID != scenarioID {
+			continue
+		}
+		total++
+		switch job.Status {
+		case JobStatusCompleted:
+			completed++
+		case JobStatusFailed:
+			failed++
+		case JobStatusCancelled:
+			cancelled++
+		case JobStatusRunning:
+			running++
+		case JobStatusPending, JobStatusAssigned:
+			pending++
+		}
+	}
+	return total, completed, failed, cancelled, running, pending, nil
+}
+
+// DeleteJob removes a job record.
+func (m *Memory) DeleteJob(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.jobs.remove(id) == nil {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+
+	m.auditLocked(ctx, AuditEntityJob, id, AuditActionDeleted, nil, nil, nil)
+
+	return nil
+}
+
+// CleanupOldJobs removes completed/failed jobs older than the specified duration.
+func (m *Memory) CleanupOldJobs(ctx context.Context, olderThan time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+
+	rows := 0
+	for _, job := range m.jobs.all() {
+		switch job.Status {
+		case JobStatusCompleted, JobStatusFailed, JobStatusCancelled:
+		default:
+			continue
+		}
+		if job.CompletedAt != nil && job.CompletedAt.Before(cutoff) {
+			m.jobs.remove(job.ID)
+			rows++
+		}
+	}
+
+	if rows > 0 {
+		// One entry for the whole cleanup rather than one per job
+		m.auditLocked(ctx, AuditEntityJob, "", AuditActionDeleted, nil, nil, map[string]interface{}{
+			"reason":     "cleanup",
+			"count":      rows,
+			"older_than": olderThan.String(),
+		})
+		m.logger.Info().Int("count", rows).Dur("older_than", olderThan).Msg("Cleaned up old jobs")
+	}
+
+	return rows, nil
+}
+
+// selectJobsLocked returns copies of the jobs matching keep, in insertion order.
+func (m *Memory) selectJobsLocked(keep func(*Job) bool) []*Job {
+	var jobs []*Job
+	for _, job := range m.jobs.all() {
+		if keep(job) {
+			jobs = append(jobs, cloneJob(job))
+		}
+	}
+	return jobs
+}
+
+// ============================================================
+// Scenarios
+// ============================================================
+
+// CreateScenario inserts a new scenario record.
+func (m *Memory) CreateScenario(ctx context.Context, scenario *Scenario) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	scenario.LabID = labOrDefault(scenario.LabID)
+
+	now := m.now()
+	stored := &Scenario{
+		ID:           scenario.ID,
+		LabID:        scenario.LabID,
+		Name:         scenario.Name,
+		Description:  clonePtr(scenario.Description),
+		Intent:       scenario.Intent,
+		Source:       scenario.Source,
+		Status:       scenario.Status,
+		CreatedAt:    now,
+		ScoringRunID: clonePtr(scenario.ScoringRunID),
+		UpdatedAt:    now,
+	}
+	if !m.scenarios.insert(scenario.ID, stored) {
+		return fmt.Errorf("failed to insert scenario: %w", errUnique("scenarios.id"))
+	}
+
+	m.auditLocked(ctx, AuditEntityScenario, scenario.ID, AuditActionCreated, nil, map[string]interface{}{
+		"lab_id": scenario.LabID,
+		"name":   scenario.Name,
+		"source": scenario.Source,
+		"status": scenario.Status,
+	}, nil)
+
+	m.logger.Info().
+		Str("scenario_id", scenario.ID).
+		Str("lab_id", scenario.LabID).
+		Str("name", scenario.Name).
+		Str("source", scenario.Source).
+		Msg("Scenario created")
+
+	return nil
+}
+
+// CreateActiveScenario inserts a validated scenario as active together with
+// its steps, its jobs and outbox entries. Every row is built before any is
+// inserted, so a failure leaves nothing behind.
+func (m *Memory) CreateActiveScenario(ctx context.Context, scenario *Scenario, steps []*ScenarioStep, jobs []*Job, outbox ...*OutboxEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	scenario.LabID = labOrDefault(scenario.LabID)
+	scenario.Status = ScenarioStatusActive
+
+	if m.scenarios.get(scenario.ID) != nil {
+		return fmt.Errorf("failed to insert scenario: %w", errUnique("scenarios.id"))
+	}
+	stepRows, err := m.newStepRowsLocked(steps)
+	if err != nil {
+		return err
+	}
+	jobRows, err := m.newJobRowsLocked(jobs)
+	if err != nil {
+		return err
+	}
+
+	now := m.now()
+	m.scenarios.insert(scenario.ID, &Scenario{
+		ID:           scenario.ID,
+		LabID:        scenario.LabID,
+		Name:         scenario.Name,
+		Description:  clonePtr(scenario.Description),
+		Intent:       scenario.Intent,
+		Source:       scenario.Source,
+		Status:       scenario.Status,
+		ValidatedDSL: clonePtr(scenario.ValidatedDSL),
+		CreatedAt:    now,
+		ScoringRunID: clonePtr(scenario.ScoringRunID),
+		UpdatedAt:    now,
+	})
+	m.auditLocked(ctx, AuditEntityScenario, scenario.ID, AuditActionCreated, nil, map[string]interface{}{
+		"lab_id": scenario.LabID,
+		"name":   scenario.Name,
+		"source": scenario.Source,
+		"status": scenario.Status,
+	}, nil)
+	for i, step := range steps {
+		m.steps.insert(step.ID, stepRows[i])
+	}
+	m.insertJobRowsLocked(ctx, jobs, jobRows)
+	m.insertOutboxLocked(outbox)
+
+	m.logger.Info().
+		Str("scenario_id", scenario.ID).
+		Str("lab_id", scenario.LabID).
+		Str("name", scenario.Name).
+		Int("jobs", len(jobs)).
+		Msg("Scenario created")
+
+	return nil
+}
+
+// GetScenario retrieves a scenario by ID.
+func (m *Memory) GetScenario(ctx context.Context, id string) (*Scenario, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if scenario := m.scenarios.get(id); scenario != nil {
+		return cloneScenario(scenario), nil
+	}
+	return nil, nil
+}
+
+// SetScenarioScoringRunID sets the scoring engine run ID for a scenario.
+// Outbox entries are added with it.
+func (m *Memory) SetScenarioScoringRunID(ctx context.Context, scenarioID string, runID string, outbox ...*OutboxEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	scenario := m.scenarios.get(scenarioID)
+	if scenario == nil {
+		return fmt.Errorf("scenario not found: %s", scenarioID)
+	}
+	scenario.ScoringRunID = &runID
+	scenario.UpdatedAt = m.now()
+	m.insertOutboxLocked(outbox)
+
+	m.auditLocked(ctx, AuditEntityScenario, scenarioID, AuditActionUpdated, nil,
+		map[string]interface{}{"scoring_run_id": runID}, nil)
+
+	m.logger.Info().
+		Str("scenario_id", scenarioID).
+		Str("scoring_run_id", runID).
+		Msg("Scoring run ID set for scenario")
+
+	return nil
+}
+
+// UpdateScenarioStatus updates the scenario status.
+func (m *Memory) UpdateScenarioStatus(ctx context.Context, id string, status string) error {
+	return m.updateScenario(ctx, id, status, nil, func(*Scenario) {})
+}
+
+// UpdateScenarioAIOutput stores the raw AI output.
+func (m *Memory) UpdateScenarioAIOutput(ctx context.Context, id string, aiOutput string) error {
+	return m.updateScenario(ctx, id, ScenarioStatusPlanning, nil, func(s *Scenario) {
+		s.AIOutput = &aiOutput
+	})
+}
+
+// UpdateScenarioValidatedDSL stores the validated DSL.
+func (m *Memory) UpdateScenarioValidatedDSL(ctx context.Context, id string, validatedDSL string) error {
+	return m.updateScenario(ctx, id, ScenarioStatusValidated, nil, func(s *Scenario) {
+		s.ValidatedDSL = &validatedDSL
+	})
+}
+
+// UpdateScenarioCompiled marks a scenario as compiled.
+func (m *Memory) UpdateScenarioCompiled(ctx context.Context, id string) error {
+	return m.UpdateScenarioStatus(ctx, id, ScenarioStatusCompiled)
+}
+
+// UpdateScenarioActive marks a scenario as active. Outbox entries are added
+// with it.
+func (m *Memory) UpdateScenarioActive(ctx context.Context, id string, outbox ...*OutboxEntry) error {
+	return m.updateScenario(ctx, id, ScenarioStatusActive, nil, func(*Scenario) {
+		m.insertOutboxLocked(outbox)
+	})
+}
+
+// UpdateScenarioCompleted marks a scenario as completed. Outbox entries are
+// added with it.
+func (m *Memory) UpdateScenarioCompleted(ctx context.Context, id string, outbox ...*OutboxEntry) error {
+	err := m.updateScenario(ctx, id, ScenarioStatusCompleted, nil, func(s *Scenario) {
+		now := m.now()
+		s.CompletedAt = &now
+		m.insertOutboxLocked(outbox)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.Info().Str("scenario_id", id).Msg("Scenario completed")
+	return nil
+}
+
+// UpdateScenarioFailed marks a scenario as failed with an error message.
+func (m *Memory) UpdateScenarioFailed(ctx context.Context, id string, errorMsg string) error {
+	err := m.updateScenario(ctx, id, ScenarioStatusFailed, map[string]interface{}{"error": errorMsg}, func(s *Scenario) {
+		now := m.now()
+		s.ErrorMessage = &errorMsg
+		s.CompletedAt = &now
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.Warn().Str("scenario_id", id).Str("error", errorMsg).Msg("Scenario failed")
+	return nil
+}
+
+// updateScenario sets a scenario's status, applies any other changes and
+// audits the status change.
+func (m *Memory) updateScenario(ctx context.Context, id, status string, metadata map[string]interface{}, apply func(*Scenario)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	scenario := m.scenarios.get(id)
+	if scenario == nil {
+		return fmt.Errorf("scenario not found: %s", id)
+	}
+	oldStatus := scenario.Status
+	scenario.Status = status
+	apply(scenario)
+	scenario.UpdatedAt = m.now()
+
+	oldValue, newValue := statusChange(oldStatus, status)
+	m.auditLocked(ctx, AuditEntityScenario, id, AuditActionStatusChanged, oldValue, newValue, metadata)
+
+	return nil
+}
+
+// ListScenarios retrieves scenarios, optionally filtered by lab and status.
+// An empty labID lists scenarios in all labs; a negative limit lists all.
+func (m *Memory) ListScenarios(ctx context.Context, labID, status string, limit int) ([]*Scenario, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var scenarios []*Scenario
+	for _, scenario := range m.scenarios.all() {
+		if (labID == "" || scenario.LabID == labID) && (status == "" || scenario.Status == status) {
+			scenarios = append(scenarios, cloneScenario(scenario))
+		}
+	}
+	sort.SliceStable(scenarios, func(i, j int) bool {
+		return scenarios[i].CreatedAt.After(scenarios[j].CreatedAt)
+	})
+	return limitRows(scenarios, limit), nil
+}
+
+// CreateScenarioStep inserts a step for a scenario.
+func (m *Memory) CreateScenarioStep(ctx context.Context, step *ScenarioStep) error {
+	return m.CreateScenarioStepsBatch(ctx, []*ScenarioStep{step})
+}
+
+// CreateScenarioStepsBatch inserts multiple steps atomically.
+func (m *Memory) CreateScenarioStepsBatch(ctx context.Context, steps []*ScenarioStep) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, err := m.newStepRowsLocked(steps)
+	if err != nil {
+		return err
+	}
+	for i, step := range steps {
+		m.steps.insert(step.ID, stored[i])
+	}
+	return nil
+}
+
+// newStepRowsLocked builds the stored rows for new steps without inserting
+// them, failing if any ID is taken.
+func (m *Memory) newStepRowsLocked(steps []*ScenarioStep) ([]*ScenarioStep, error) {
+	now := m.now()
+	stored := make([]*ScenarioStep, len(steps))
+	seen := make(map[string]bool, len(steps))
+	for i, step := range steps {
+		if seen[step.ID] || m.steps.get(step.ID) != nil {
+			return nil, fmt.Errorf("failed to insert step: %w", errUnique("scenario_steps.id"))
+		}
+		seen[step.ID] = true
+
+		params, err := normalizeJSON(step.Parameters)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal parameters: %w", err)
+		}
+		row := cloneStep(step)
+		row.Parameters = params
+		row.CreatedAt = now
+		stored[i] = row
+	}
+	return stored, nil
+}
+
+// GetScenarioSteps retrieves all steps for a scenario.
+func (m *Memory) GetScenarioSteps(ctx context.Context, scenarioID string) ([]*ScenarioStep, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var steps []*ScenarioStep
+	for _, step := range m.steps.all() {
+		if step.ScenarioID == scenarioID {
+			steps = append(steps, cloneStep(step))
+		}
+	}
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].StepOrder < steps[j].StepOrder
+	})
+	return steps, nil
+}
+
+// GetScenarioStep retrieves a specific step by ID.
+func (m *Memory) GetScenarioStep(ctx context.Context, id string) (*ScenarioStep, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if step := m.steps.get(id); step != nil {
+		return cloneStep(step), nil
+	}
+	return nil, nil
+}
+
+// DeleteScenarioSteps removes all steps for a scenario.
+func (m *Memory) DeleteScenarioSteps(ctx context.Context, scenarioID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteStepsLocked(scenarioID)
+	return nil
+}
+
+func (m *Memory) deleteStepsLocked(scenarioID string) {
+	for _, step := range m.steps.all() {
+		if step.ScenarioID == scenarioID {
+			m.steps.remove(step.ID)
+		}
+	}
+}
+
+// CountScenarioSteps returns the number of steps in a scenario.
+func (m *Memory) CountScenarioSteps(ctx context.Context, scenarioID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, step := range m.steps.all() {
+		if step.ScenarioID == scenarioID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DeleteScenario removes a scenario and its steps.
+func (m *Memory) DeleteScenario(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	scenario := m.scenarios.remove(id)
+	if scenario == nil {
+		return fmt.Errorf("scenario not found: %s", id)
+	}
+	m.deleteStepsLocked(id)
+
+	m.auditLocked(ctx, AuditEntityScenario, id, AuditActionDeleted, map[string]interface{}{
+		"lab_id": scenario.LabID,
+		"name":   scenario.Name,
+		"status": scenario.Status,
+	}, nil, nil)
+
+	m.logger.Info().Str("scenario_id", id).Msg("Scenario deleted")
+	return nil
+}
+
+// CountScenarios returns the total number of scenarios, optionally filtered by status.
+func (m *Memory) CountScenarios(ctx context.Context, status string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, scenario := range m.scenarios.all() {
+		if status == "" || scenario.Status == status {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ============================================================
+// Impersonation users
+// ============================================================
+
+// CreateImpersonationUser creates a new impersonation user.
+func (m *Memory) CreateImpersonationUser(ctx context.Context, user *ImpersonationUser) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	user.LabID = labOrDefault(user.LabID)
+
+	if m.findUserLocked(user.LabID, user.Username) != nil {
+		return fmt.Errorf("failed to create impersonation user: %w",
+			errUnique("impersonation_users.lab_id, impersonation_users.username"))
+	}
+
+	now := m.now()
+	stored := cloneUser(user)
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	if !m.users.insert(user.ID, stored) {
+		return fmt.Errorf("failed to create impersonation user: %w", errUnique("impersonation_users.id"))
+	}
+
+	m.auditLocked(ctx, AuditEntityUser, user.ID, AuditActionCreated, nil, map[string]interface{}{
+		"lab_id":           user.LabID,
+		"username":         user.Username,
+		"domain":           user.Domain,
+		"sam_account_name": user.SAMAccountName,
+		"display_name":     user.DisplayName,
+		"department":       user.Department,
+		"title":            user.Title,
+		"allowed_hosts":    user.AllowedHosts,
+	}, nil)
+
+	m.logger.Info().
+		Str("user_id", user.ID).
+		Str("lab_id", user.LabID).
+		Str("username", user.Username).
+		Msg("Created impersonation user")
+
+	return nil
+}
+
+// GetImpersonationUser retrieves an impersonation user by ID.
+func (m *Memory) GetImpersonationUser(ctx context.Context, id string) (*ImpersonationUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user := m.users.get(id); user != nil {
+		return cloneUser(user), nil
+	}
+	return nil, nil
+}
+
+// GetImpersonationUserByUsername retrieves an impersonation user by username within a lab.
+func (m *Memory) GetImpersonationUserByUsername(ctx context.Context, labID, username string) (*ImpersonationUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user := m.findUserLocked(labOrDefault(labID), username); user != nil {
+		return cloneUser(user), nil
+	}
+	return nil, nil
+}
+
+func (m *Memory) findUserLocked(labID, username string) *ImpersonationUser {
+	for _, user := range m.users.all() {
+		if user.LabID == labID && user.Username == username {
+			return user
+		}
+	}
+	return nil
+}
+
+// ListImpersonationUsers returns impersonation users, optionally scoped to a lab.
+// An empty labID lists users in all labs.
+func (m *Memory) ListImpersonationUsers(ctx context.Context, labID string) ([]*ImpersonationUser, error) {
+	return m.listUsers(labID, func(*ImpersonationUser) bool { return true }), nil
+}
+
+// ListImpersonationUsersByDepartment returns users in a specific department, optionally scoped to a lab.
+func (m *Memory) ListImpersonationUsersByDepartment(ctx context.Context, labID, department string) ([]*ImpersonationUser, error) {
+	return m.listUsers(labID, func(user *ImpersonationUser) bool { return user.Department == department }), nil
+}
+
+func (m *Memory) listUsers(labID string, keep func(*ImpersonationUser) bool) []*ImpersonationUser {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var users []*ImpersonationUser
+	for _, user := range m.users.all() {
+		if (labID == "" || user.LabID == labID) && keep(user) {
+			users = append(users, cloneUser(user))
+		}
+	}
+	sort.SliceStable(users, func(i, j int) bool {
+		if users[i].DisplayName != users[j].DisplayName {
+			return users[i].DisplayName < users[j].DisplayName
+		}
+		return users[i].Username < users[j].Username
+	})
+	return users
+}
+
+// UpdateImpersonationUser updates an existing impersonation user.
+func (m *Memory) UpdateImpersonationUser(ctx context.Context, user *ImpersonationUser) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.users.get(user.ID)
+	if stored == nil {
+		return fmt.Errorf("impersonation user not found: %s", user.ID)
+	}
+	updated := cloneUser(user)
+	stored.DisplayName = updated.DisplayName
+	stored.Department = updated.Department
+	stored.Title = updated.Title
+	stored.AllowedHosts = updated.AllowedHosts
+	stored.Persona = updated.Persona
+	stored.UpdatedAt = m.now()
+
+	m.auditLocked(ctx, AuditEntityUser, user.ID, AuditActionUpdated, nil, map[string]interface{}{
+		"display_name":  user.DisplayName,
+		"department":    user.Department,
+		"title":         user.Title,
+		"allowed_hosts": user.AllowedHosts,
+	}, nil)
+
+	return nil
+}
+
+// DeleteImpersonationUser deletes an impersonation user.
+func (m *Memory) DeleteImpersonationUser(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.users.remove(id) == nil {
+		return fmt.Errorf("impersonation user not found: %s", id)
+	}
+
+	m.auditLocked(ctx, AuditEntityUser, id, AuditActionDeleted, nil, nil, nil)
+
+	m.logger.Info().Str("user_id", id).Msg("Deleted impersonation user")
+	return nil
+}
+
+// CountImpersonationUsers returns the total number of impersonation users.
+func (m *Memory) CountImpersonationUsers(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.users.len(), nil
+}
+
+// ============================================================
+// Labs
+// ============================================================
+
+// CreateLab inserts a new lab.
+func (m *Memory) CreateLab(ctx context.Context, lab *Lab) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	stored := cloneLab(lab)
+	stored.AllowedNetworks = nonNilStrings(stored.AllowedNetworks)
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	if !m.labs.insert(lab.ID, stored) {
+		return fmt.Errorf("failed to insert lab: %w", errUnique("labs.id"))
+	}
+
+	m.auditLocked(ctx, AuditEntityLab, lab.ID, AuditActionCreated, nil, labAuditValue(lab), nil)
+
+	m.logger.Info().Str("lab_id", lab.ID).Str("name", lab.Name).Msg("Lab created")
+	return nil
+}
+
+// GetLab retrieves a lab by ID.
+func (m *Memory) GetLab(ctx context.Context, id string) (*Lab, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if lab := m.labs.get(id); lab != nil {
+		return cloneLab(lab), nil
+	}
+	return nil, nil
+}
+
+// ListLabs returns all labs ordered by ID.
+func (m *Memory) ListLabs(ctx context.Context) ([]*Lab, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var labs []*Lab
+	for _, lab := range m.labs.all() {
+		labs = append(labs, cloneLab(lab))
+	}
+	sort.Slice(labs, func(i, j int) bool { return labs[i].ID < labs[j].ID })
+	return labs, nil
+}
+
+// UpdateLab updates a lab's mutable fields.
+func (m *Memory) UpdateLab(ctx context.Context, lab *Lab) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.labs.get(lab.ID)
+	if stored == nil {
+		return fmt.Errorf("lab not found: %s", lab.ID)
+	}
+	stored.Name = lab.Name
+	stored.Description = lab.Description
+	stored.AllowedNetworks = append([]string{}, lab.AllowedNetworks...)
+	stored.WebhookURL = lab.WebhookURL
+	stored.UpdatedAt = m.now()
+
+	m.auditLocked(ctx, AuditEntityLab, lab.ID, AuditActionUpdated, nil, labAuditValue(lab), nil)
+
+	return nil
+}
+
+// DeleteLab removes a lab. It fails if any agents, scenarios or users still belong to it.
+func (m *Memory) DeleteLab(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, agent := range m.agents.all() {
+		if agent.LabID == id {
+			return fmt.Errorf("lab not empty: %s", id)
+		}
+	}
+	for _, scenario := range m.scenarios.all() {
+		if scenario.LabID == id {
+			return fmt.Errorf("lab not empty: %s", id)
+		}
+	}
+	for _, user := range m.users.all() {
+		if user.LabID == id {
+			return fmt.Errorf("lab not empty: %s", id)
+		}
+	}
+
+	if m.labs.remove(id) == nil {
+		return fmt.Errorf("lab not found: %s", id)
+	}
+
+	m.auditLocked(ctx, AuditEntityLab, id, AuditActionDeleted, nil, nil, nil)
+
+	m.logger.Info().Str("lab_id", id).Msg("Lab deleted")
+	return nil
+}
+
+// ============================================================
+// API keys
+// ============================================================
+
+// CreateAPIKey inserts a new API key record.
+func (m *Memory) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key.ID == "" {
+		key.ID = uuid.New().String()
+	}
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now().UTC()
+	}
+
+	for _, existing := range m.apiKeys.all() {
+		if existing.KeyHash == key.KeyHash {
+			return fmt.Errorf("failed to insert api key: %w", errUnique("api_keys.key_hash"))
+		}
+	}
+	stored := cloneAPIKey(key)
+	stored.LastUsedAt = nil
+	stored.RevokedAt = nil
+	if !m.apiKeys.insert(key.ID, stored) {
+		return fmt.Errorf("failed to insert api key: %w", errUnique("api_keys.id"))
+	}
+
+	// Only the display prefix is recorded, never the key or its hash
+	newValue := map[string]interface{}{
+		"name":       key.Name,
+		"key_prefix": key.KeyPrefix,
+		"role":       key.Role,
+	}
+	if key.ExpiresAt != nil {
+		newValue["expires_at"] = *key.ExpiresAt
+	}
+	m.auditLocked(ctx, AuditEntityAPIKey, key.ID, AuditActionCreated, nil, newValue, nil)
+
+	m.logger.Info().
+		Str("key_id", key.ID).
+		Str("name", key.Name).
+		Str("role", key.Role).
+		Msg("API key created")
+
+	return nil
+}
+
+// GetAPIKey retrieves an API key by ID.
+func (m *Memory) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key := m.apiKeys.get(id); key != nil {
+		return cloneAPIKey(key), nil
+	}
+	return nil, nil
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its secret.
+func (m *Memory) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.apiKeys.all() {
+		if key.KeyHash == hash {
+			return cloneAPIKey(key), nil
+		}
+	}
+	return nil, nil
+}
+
+// ListAPIKeys returns all API keys, newest first.
+func (m *Memory) ListAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []*APIKey
+	for _, key := range m.apiKeys.all() {
+		keys = append(keys, cloneAPIKey(key))
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// RevokeAPIKey marks an API key as revoked.
+func (m *Memory) RevokeAPIKey(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := m.apiKeys.get(id)
+	if key == nil || key.RevokedAt != nil {
+		return fmt.Errorf("api key not found: %s", id)
+	}
+	now := m.now()
+	key.RevokedAt = &now
+
+	oldValue, newValue := statusChange("active", "revoked")
+	m.auditLocked(ctx, AuditEntityAPIKey, id, AuditActionStatusChanged, oldValue, newValue, nil)
+
+	m.logger.Info().Str("key_id", id).Msg("API key revoked")
+	return nil
+}
+
+// TouchAPIKey records that an API key was just used.
+func (m *Memory) TouchAPIKey(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key := m.apiKeys.get(id); key != nil {
+		now := m.now()
+		key.LastUsedAt = &now
+	}
+	return nil
+}
+
+// ============================================================
+// Webhook subscriptions
+// ============================================================
+
+// CreateWebhookSubscription inserts a new webhook subscription.
+func (m *Memory) CreateWebhookSubscription(ctx context.Context, sub *WebhookSubscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sub.ID == "" {
+		sub.ID = uuid.New().String()
+	}
+	now := m.now()
+	sub.CreatedAt = now
+	sub.UpdatedAt = now
+
+	if m.webhookNameTakenLocked(sub.ID, sub.Name) {
+		return fmt.Errorf("failed to insert webhook subscription: %w", errUnique("webhook_subscriptions.name"))
+	}
+	stored := cloneWebhookSubscription(sub)
+	stored.EventTypes = nonNilStrings(stored.EventTypes)
+	if !m.webhooks.insert(sub.ID, stored) {
+		return fmt.Errorf("failed to insert webhook subscription: %w", errUnique("webhook_subscriptions.id"))
+	}
+
+	m.auditLocked(ctx, AuditEntityWebhook, sub.ID, AuditActionCreated, nil, webhookAuditValue(sub), nil)
+
+	m.logger.Info().
+		Str("subscription_id", sub.ID).
+		Str("name", sub.Name).
+		Msg("Webhook subscription created")
+
+	return nil
+}
+
+// GetWebhookSubscription retrieves a webhook subscription by ID.
+func (m *Memory) GetWebhookSubscription(ctx context.Context, id string) (*WebhookSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sub := m.webhooks.get(id); sub != nil {
+		return cloneWebhookSubscription(sub), nil
+	}
+	return nil, nil
+}
+
+// ListWebhookSubscriptions returns all webhook subscriptions ordered by name.
+func (m *Memory) ListWebhookSubscriptions(ctx context.Context) ([]*WebhookSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var subs []*WebhookSubscription
+	for _, sub := range m.webhooks.all() {
+		subs = append(subs, cloneWebhookSubscription(sub))
+	}
+	sort.SliceStable(subs, func(i, j int) bool { return subs[i].Name < subs[j].Name })
+	return subs, nil
+}
+
+// UpdateWebhookSubscription updates a subscription's mutable fields.
+func (m *Memory) UpdateWebhookSubscription(ctx context.Context, sub *WebhookSubscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.webhooks.get(sub.ID)
+	if stored == nil {
+		return fmt.Errorf("webhook subscription not found: %s", sub.ID)
+	}
+	if m.webhookNameTakenLocked(sub.ID, sub.Name) {
+		return fmt.Errorf("failed to update webhook subscription: %w", errUnique("webhook_subscriptions.name"))
+	}
+	stored.Name = sub.Name
+	stored.URL = sub.URL
+	stored.Secret = sub.Secret
+	stored.EventTypes = append([]string{}, sub.EventTypes...)
+	stored.LabID = sub.LabID
+	stored.ScenarioID = sub.ScenarioID
+	stored.Enabled = sub.Enabled
+	stored.MaxRetries = sub.MaxRetries
+	stored.RetryBackoff = sub.RetryBackoff
+	stored.Timeout = sub.Timeout
+	stored.UpdatedAt = m.now()
+
+	m.auditLocked(ctx, AuditEntityWebhook, sub.ID, AuditActionUpdated, nil, webhookAuditValue(sub), nil)
+
+	return nil
+}
+
+// DeleteWebhookSubscription removes a subscription and its delivery history.
+func (m *Memory) DeleteWebhookSubscription(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.webhooks.remove(id) == nil {
+		return fmt.Errorf("webhook subscription not found: %s", id)
+	}
+	delete(m.deliveries, id)
+
+	m.auditLocked(ctx, AuditEntityWebhook, id, AuditActionDeleted, nil, nil, nil)
+
+	m.logger.Info().Str("subscription_id", id).Msg("Webhook subscription deleted")
+	return nil
+}
+
+// CreateWebhookDelivery records a new delivery and prunes the subscription's
+// history to the most recent WebhookDeliveryHistory entries.
+func (m *Memory) CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if delivery.ID == "" {
+		delivery.ID = uuid.New().String()
+	}
+	if delivery.Status == "" {
+		delivery.Status = WebhookDeliveryPending
+	}
+	if delivery.CreatedAt.IsZero() {
+		delivery.CreatedAt = m.now()
+	}
+
+	history := append(m.deliveries[delivery.SubscriptionID], cloneWebhookDelivery(delivery))
+	if len(history) > WebhookDeliveryHistory {
+		history = append([]*WebhookDelivery{}, history[len(history)-WebhookDeliveryHistory:]...)
+	}
+	m.deliveries[delivery.SubscriptionID] = history
+	return nil
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery.
+func (m *Memory) UpdateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.deliveries[delivery.SubscriptionID] {
+		if stored.ID == delivery.ID {
+			stored.Status = delivery.Status
+			stored.Attempts = delivery.Attempts
+			stored.ResponseStatus = clonePtr(delivery.ResponseStatus)
+			stored.Error = clonePtr(delivery.Error)
+			stored.DurationMs = clonePtr(delivery.DurationMs)
+			stored.CompletedAt = clonePtr(delivery.CompletedAt)
+			break
+		}
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns a subscription's deliveries, newest first.
+func (m *Memory) ListWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]*WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if limit <= 0 {
+		limit = WebhookDeliveryHistory
+	}
+
+	history := m.deliveries[subscriptionID]
+	var deliveries []*WebhookDelivery
+	for i := len(history) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, cloneWebhookDelivery(history[i]))
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+	})
+	return limitRows(deliveries, limit), nil
+}
+
+// webhookNameTakenLocked reports whether another subscription uses name.
+func (m *Memory) webhookNameTakenLocked(id, name string) bool {
+	for _, existing := range m.webhooks.all() {
+		if existing.ID != id && existing.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================
+// Outbox
+// ============================================================
+
+// insertOutboxLocked adds entries alongside the change that produced them.
+// An entry whose event ID was already added for its target is skipped.
+func (m *Memory) insertOutboxLocked(entries []*OutboxEntry) {
+	now := m.now()
+	for _, entry := range entries {
+		if entry.ID == "" {
+			entry.ID = uuid.New().String()
+		}
+		entry.Status = OutboxStatusPending
+		entry.CreatedAt = now
+		entry.UpdatedAt = now
+		if entry.NextAttemptAt.IsZero() {
+			entry.NextAttemptAt = now
+		}
+
+		duplicate := false
+		for _, existing := range m.outbox.all() {
+			if existing.Target == entry.Target && existing.EventID == entry.EventID {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			m.outbox.insert(entry.ID, cloneOutboxEntry(entry))
+		}
+	}
+}
+
+// GetOutboxEntry retrieves an outbox entry by ID.
+func (m *Memory) GetOutboxEntry(ctx context.Context, id string) (*OutboxEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry := m.outbox.get(id); entry != nil {
+		return cloneOutboxEntry(entry), nil
+	}
+	return nil, nil
+}
+
+// ListOutboxEntries returns outbox entries, newest first.
+func (m *Memory) ListOutboxEntries(ctx context.Context, filter OutboxFilter) ([]*OutboxEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows := m.outbox.all()
+	var entries []*OutboxEntry
+	for i := len(rows) - 1; i >= 0; i-- {
+		entry := rows[i]
+		if (filter.Target == "" || entry.Target == filter.Target) && (filter.Status == "" || entry.Status == filter.Status) {
+			entries = append(entries, cloneOutboxEntry(entry))
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	return limitRows(entries, limit), nil
+}
+
+// ListDueOutboxEntries returns up to limit pending entries whose next attempt
+// is due at now, oldest first.
+func (m *Memory) ListDueOutboxEntries(ctx context.Context, now time.Time, limit int) ([]*OutboxEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []*OutboxEntry
+	for _, entry := range m.outbox.all() {
+		if entry.Status == OutboxStatusPending && !entry.NextAttemptAt.After(now) {
+			entries = append(entries, cloneOutboxEntry(entry))
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].NextAttemptAt.Before(entries[j].NextAttemptAt)
+	})
+	return limitRows(entries, limit), nil
+}
+
+// CountOutboxEntries returns the number of outbox entries by status.
+func (m *Memory) CountOutboxEntries(ctx context.Context) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, entry := range m.outbox.all() {
+		counts[entry.Status]++
+	}
+	return counts, nil
+}
+
+// UpdateOutboxEntry records the outcome of a delivery attempt.
+func (m *Memory) UpdateOutboxEntry(ctx context.Context, entry *OutboxEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.outbox.get(entry.ID)
+	if stored == nil {
+		return fmt.Errorf("outbox entry not found: %s", entry.ID)
+	}
+	stored.Status = entry.Status
+	stored.Attempts = entry.Attempts
+	stored.NextAttemptAt = entry.NextAttemptAt.UTC()
+	stored.LastError = clonePtr(entry.LastError)
+	stored.DeliveredAt = clonePtr(entry.DeliveredAt)
+	stored.UpdatedAt = m.now()
+	return nil
+}
+
+// ReplayOutboxEntry returns an entry to pending with a fresh attempt budget
+// so the worker delivers it again immediately.
+func (m *Memory) ReplayOutboxEntry(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.outbox.get(id)
+	if entry == nil {
+		return fmt.Errorf("outbox entry not found: %s", id)
+	}
+
+	oldValue, newValue := statusChange(entry.Status, OutboxStatusPending)
+	m.auditLocked(ctx, AuditEntityOutbox, id, AuditActionReplayed, oldValue, newValue, outboxAuditMetadata(entry))
+
+	now := m.now()
+	entry.Status = OutboxStatusPending
+	entry.Attempts = 0
+	entry.NextAttemptAt = now
+	entry.DeliveredAt = nil
+	entry.UpdatedAt = now
+	return nil
+}
+
+// ReplayDeadOutboxEntries returns every dead entry, optionally only those for
+// target, to pending with a fresh attempt budget and returns how many were
+// replayed.
+func (m *Memory) ReplayDeadOutboxEntries(ctx context.Context, target string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	n := 0
+	for _, entry := range m.outbox.all() {
+		if entry.Status == OutboxStatusDead && (target == "" || entry.Target == target) {
+			entry.Status = OutboxStatusPending
+			entry.Attempts = 0
+			entry.NextAttemptAt = now
+			entry.UpdatedAt = now
+			n++
+		}
+	}
+
+	if n > 0 {
+		m.auditLocked(ctx, AuditEntityOutbox, "", AuditActionReplayed, nil, nil, map[string]interface{}{
+			"target": target,
+			"count":  n,
+		})
+	}
+	return n, nil
+}
+
+// ============================================================
+// Audit log
+// ============================================================
+
+// CreateAuditEntry appends an entry to the audit log.
+func (m *Memory) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertAuditEntryLocked(entry)
+}
+
+// Audit records a state change made by the actor in ctx. Failures are logged
+// rather than returned so auditing never fails the change itself.
+func (m *Memory) Audit(ctx context.Context, entityType, entityID, action string, oldValue, newValue, metadata map[string]interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.auditLocked(ctx, entityType, entityID, action, oldValue, newValue, metadata)
+}
+
+func (m *Memory) auditLocked(ctx context.Context, entityType, entityID, action string, oldValue, newValue, metadata map[string]interface{}) {
+	entry := &AuditEntry{
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		Actor:      ActorFromContext(ctx),
+		OldValue:   oldValue,
+		NewValue:   newValue,
+		Metadata:   metadata,
+	}
+	if err := m.insertAuditEntryLocked(entry); err != nil {
+		m.logger.Error().Err(err).
+			Str("entity_type", entityType).
+			Str("entity_id", entityID).
+			Str("action", action).
+			Msg("Failed to write audit entry")
+	}
+}
+
+func (m *Memory) insertAuditEntryLocked(entry *AuditEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+
+	// Values are stored as they would read back from the JSON columns
+	oldValue, err := normalizeJSON(entry.OldValue)
+	if err != nil {
+		return fmt.Errorf("failed to marshal old_value: %w", err)
+	}
+	newValue, err := normalizeJSON(entry.NewValue)
+	if err != nil {
+		return fmt.Errorf("failed to marshal new_value: %w", err)
+	}
+	metadata, err := normalizeJSON(entry.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	m.nextAuditID++
+	entry.ID = m.nextAuditID
+
+	stored := *entry
+	stored.OldValue = oldValue
+	stored.NewValue = newValue
+	stored.Metadata = metadata
+	m.audit = append(m.audit, &stored)
+	return nil
+}
+
+// ListAuditEntries retrieves audit entries newest first.
+func (m *Memory) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []*AuditEntry
+	for i := len(m.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		if filter.matches(m.audit[i]) {
+			entries = append(entries, cloneAuditEntry(m.audit[i]))
+		}
+	}
+	return entries, nil
+}
+
+// ExportAuditEntries calls fn for every matching audit entry, oldest first.
+// The filter's BeforeID and Limit are ignored.
+func (m *Memory) ExportAuditEntries(ctx context.Context, filter AuditFilter, fn func(*AuditEntry) error) error {
+	filter.BeforeID = 0
+
+	// Collect first so fn may call back into the store
+	m.mu.Lock()
+	var entries []*AuditEntry
+	for _, entry := range m.audit {
+		if filter.matches(entry) {
+			entries = append(entries, cloneAuditEntry(entry))
+		}
+	}
+	m.mu.Unlock()
+
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether entry is selected by the filter, as where does in SQL.
+func (f AuditFilter) matches(entry *AuditEntry) bool {
+	switch {
+	case f.EntityType != "" && entry.EntityType != f.EntityType,
+		f.EntityID != "" && entry.EntityID != f.EntityID,
+		f.Action != "" && entry.Action != f.Action,
+		f.Actor != "" && entry.Actor != f.Actor,
+		!f.Since.IsZero() && entry.CreatedAt.Before(f.Since),
+		!f.Until.IsZero() && entry.CreatedAt.After(f.Until),
+		f.BeforeID > 0 && entry.ID >= f.BeforeID:
+		return false
+	}
+	return true
+}
+
+// ============================================================
+// Helpers
+// ============================================================
+
+// table holds rows by ID. Rows are listed in insertion order, which stands in
+// for SQLite's rowid order when sort keys tie.
+type table[T any] struct {
+	rows  map[string]*T
+	order []string
+}
+
+func newTable[T any]() *table[T] {
+	return &table[T]{rows: make(map[string]*T)}
+}
+
+func (t *table[T]) get(id string) *T {
+	return t.rows[id]
+}
+
+// insert adds a row, reporting false if the ID is already taken.
+func (t *table[T]) insert(id string, row *T) bool {
+	if _, ok := t.rows[id]; ok {
+		return false
+	}
+	t.rows[id] = row
+	t.order = append(t.order, id)
+	return true
+}
+
+// remove deletes and returns a row, or returns nil if there is none.
+func (t *table[T]) remove(id string) *T {
+	row, ok := t.rows[id]
+	if !ok {
+		return nil
+	}
+	delete(t.rows, id)
+	for i, v := range t.order {
+		if v == id {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+	return row
+}
+
+// all returns every row in insertion order. The slice is a snapshot, so rows
+// may be removed while iterating over it.
+func (t *table[T]) all() []*T {
+	rows := make([]*T, 0, len(t.order))
+	for _, id := range t.order {
+		rows = append(rows, t.rows[id])
+	}
+	return rows
+}
+
+func (t *table[T]) len() int {
+	return len(t.rows)
+}
+
+// errUnique reports a uniqueness violation worded like SQLite's.
+func errUnique(columns string) error {
+	return fmt.Errorf("UNIQUE constraint failed: %s", columns)
+}
+
+// limitRows applies a SQL LIMIT, where a negative limit means no limit.
+func limitRows[T any](rows []T, limit int) []T {
+	if limit >= 0 && len(rows) > limit {
+		return rows[:limit]
+	}
+	return rows
+}
+
+// normalizeJSON returns v as it would read back from a JSON column, so
+// numbers become float64 and times become strings just as they do in SQLite.
+func normalizeJSON(v map[string]interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// cloneJSONMap deep-copies a map produced by normalizeJSON.
+func cloneJSONMap(v map[string]interface{}) map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(v))
+	for k, val := range v {
+		out[k] = cloneJSONValue(val)
+	}
+	return out
+}
+
+func cloneJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return cloneJSONMap(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			out[i] = cloneJSONValue(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+func cloneLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
+
+func cloneAgent(a *Agent) *Agent {
+	out := *a
+	out.Labels = cloneLabels(a.Labels)
+	out.Capabilities = cloneCapabilities(a.Capabilities)
+	return &out
+}
+
+func cloneCapabilities(c *AgentCapabilities) *AgentCapabilities {
+	if c == nil {
+		return nil
+	}
+	out := *c
+	out.Actions = append([]string(nil), c.Actions...)
+	out.Unavailable = cloneLabels(c.Unavailable)
+	if c.Backends != nil {
+		out.Backends = make(map[string][]string, len(c.Backends))
+		for action, backends := range c.Backends {
+			out.Backends[action] = append([]string(nil), backends...)
+		}
+	}
+	return &out
+}
+
+func cloneJob(j *Job) *Job {
+	out := *j
+	out.ScenarioID = clonePtr(j.ScenarioID)
+	out.ScenarioStepID = clonePtr(j.ScenarioStepID)
+	out.Parameters = cloneJSONMap(j.Parameters)
+	out.RunAsUser = clonePtr(j.RunAsUser)
+	out.RunAsLogonType = clonePtr(j.RunAsLogonType)
+	out.AssignedAt = clonePtr(j.AssignedAt)
+	out.StartedAt = clonePtr(j.StartedAt)
+	out.CompletedAt = clonePtr(j.CompletedAt)
+	out.Result = cloneJSONMap(j.Result)
+	out.ErrorMessage = clonePtr(j.ErrorMessage)
+	return &out
+}
+
+func cloneScenario(s *Scenario) *Scenario {
+	out := *s
+	out.Description = clonePtr(s.Description)
+	out.AIOutput = clonePtr(s.AIOutput)
+	out.ValidatedDSL = clonePtr(s.ValidatedDSL)
+	out.ErrorMessage = clonePtr(s.ErrorMessage)
+	out.ScoringRunID = clonePtr(s.ScoringRunID)
+	out.CompletedAt = clonePtr(s.CompletedAt)
+	return &out
+}
+
+func cloneStep(s *ScenarioStep) *ScenarioStep {
+	out := *s
+	out.TargetLabels = cloneLabels(s.TargetLabels)
+	out.Parameters = cloneJSONMap(s.Parameters)
+	return &out
+}
+
+func cloneUser(u *ImpersonationUser) *ImpersonationUser {
+	out := *u
+	out.AllowedHosts = cloneStrings(u.AllowedHosts)
+	if u.Persona != nil {
+		persona := *u.Persona
+		persona.WorkHours = clonePtr(u.Persona.WorkHours)
+		persona.TypicalApps = cloneStrings(u.Persona.TypicalApps)
+		persona.TypicalSites = cloneStrings(u.Persona.TypicalSites)
+		persona.FileTypes = cloneStrings(u.Persona.FileTypes)
+		out.Persona = &persona
+	}
+	return &out
+}
+
+func cloneLab(l *Lab) *Lab {
+	out := *l
+	out.AllowedNetworks = cloneStrings(l.AllowedNetworks)
+	return &out
+}
+
+func cloneAPIKey(k *APIKey) *APIKey {
+	out := *k
+	out.CreatedBy = clonePtr(k.CreatedBy)
+	out.LastUsedAt = clonePtr(k.LastUsedAt)
+	out.ExpiresAt = clonePtr(k.ExpiresAt)
+	out.RevokedAt = clonePtr(k.RevokedAt)
+	return &out
+}
+
+func cloneWebhookSubscription(w *WebhookSubscription) *WebhookSubscription {
+	out := *w
+	out.EventTypes = cloneStrings(w.EventTypes)
+	out.CreatedBy = clonePtr(w.CreatedBy)
+	return &out
+}
+
+func cloneWebhookDelivery(d *WebhookDelivery) *WebhookDelivery {
+	out := *d
+	out.ResponseStatus = clonePtr(d.ResponseStatus)
+	out.Error = clonePtr(d.Error)
+	out.DurationMs = clonePtr(d.DurationMs)
+	out.CompletedAt = clonePtr(d.CompletedAt)
+	return &out
+}
+
+func cloneOutboxEntry(e *OutboxEntry) *OutboxEntry {
+	out := *e
+	out.Payload = append(json.RawMessage(nil), e.Payload...)
+	out.LastError = clonePtr(e.LastError)
+	out.DeliveredAt = clonePtr(e.DeliveredAt)
+	return &out
+}
+
+func cloneAuditEntry(e *AuditEntry) *AuditEntry {
+	out := *e
+	out.OldValue = cloneJSONMap(e.OldValue)
+	out.NewValue = cloneJSONMap(e.NewValue)
+	out.Metadata = cloneJSONMap(e.Metadata)
+	return &out
+}
+
+func derefString(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+// Package storage provides SQLite database access for the orchestrator.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outbox targets.
+const (
+	OutboxTargetScoring   = "scoring"
+	OutboxTargetMessenger = "messenger"
+)
+
+// Outbox entry statuses.
+const (
+	OutboxStatusPending   = "pending"
+	OutboxStatusDelivered = "delivered"
+	OutboxStatusDead      = "dead"
+)
+
+// OutboxEntry is an event waiting to be delivered to the scoring engine or
+// messenger. Entries are written in the same transaction as the state change
+// that produced them, so an event is never lost to a restart or an outage.
+type OutboxEntry struct {
+	ID            string
+	EventID       string // stable across attempts so the target can deduplicate
+	Target        string
+	Destination   string // scoring run ID or lab ID
+	EventType     string
+	Payload       json.RawMessage
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     *string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeliveredAt   *time.Time
+}
+
+// OutboxFilter selects outbox entries. Empty fields match everything.
+type OutboxFilter struct {
+	Target string
+	Status string
+	Limit  int
+}
+
+// NewOutboxEntry builds an entry that delivers event, marshalled as JSON, to
+// target. destination is the scoring run ID or lab ID the event is routed by.
+func NewOutboxEntry(target, destination, eventID, eventType string, event interface{}) (*OutboxEntry, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal outbox event: %w", err)
+	}
+	return &OutboxEntry{
+		EventID:     eventID,
+		Target:      target,
+		Destination: destination,
+		EventType:   eventType,
+		Payload:     payload,
+	}, nil
+}
+
+const outboxColumns = `id, event_id, target, destination, event_type, payload, status, attempts,
+	next_attempt_at, last_error, created_at, updated_at, delivered_at`
+
+// insertOutboxEntries writes entries through exec, so they commit or roll
+// back with the state change that produced them. An entry whose event ID was
+// already written for its target is skipped.
+func insertOutboxEntries(ctx context.Context, exec execer, entries []*OutboxEntry) error {
+	now := time.Now().UTC()
+	for _, entry := range entries {
+		if entry.ID == "" {
+			entry.ID = uuid.New().String()
+		}
+		entry.Status = OutboxStatusPending
+		entry.CreatedAt = now
+		entry.UpdatedAt = now
+		if entry.NextAttemptAt.IsZero() {
+			entry.NextAttemptAt = now
+		}
+
+		_, err := exec.ExecContext(ctx, `
+			INSERT INTO outbox (id, event_id, target, destination, event_type, payload, status, attempts,
+				next_attempt_at, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?)
+			ON CONFLICT(target, event_id) DO NOTHING
+		`, entry.ID, entry.EventID, entry.Target, entry.Destination, entry.EventType, string(entry.Payload),
+			entry.Status, entry.NextAttemptAt, now, now)
+		if err != nil {
+			return fmt.Errorf("failed to insert outbox entry: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetOutboxEntry retrieves an outbox entry by ID.
+func (d *DB) GetOutboxEntry(ctx context.Context, id string) (*OutboxEntry, error) {
+	row := d.db.QueryRowContext(ctx, "SELECT "+outboxColumns+" FROM outbox WHERE id = ?", id)
+
+	return scanOutboxEntry(row)
+}
+
+// ListOutboxEntries returns outbox entries, newest first.
+func (d *DB) ListOutboxEntries(ctx context.Context, filter OutboxFilter) ([]*OutboxEntry, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	var where []string
+	var args []interface{}
+	if filter.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, filter.Target)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	query := "SELECT " + outboxColumns + " FROM outbox"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	rows, err := d.db.QueryContext(ctx, query+" ORDER BY created_at DESC, rowid DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox entries: %w", err)
+	}
+	defer rows.Close()
+
+	return scanOutboxEntries(rows)
+}
+
+// ListDueOutboxEntries returns up to limit pending entries whose next attempt
+// is due at now, oldest first.
+func (d *DB) ListDueOutboxEntries(ctx context.Context, now time.Time, limit int) ([]*OutboxEntry, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT `+outboxColumns+`
+		FROM outbox
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at ASC, rowid ASC
+		LIMIT ?
+	`, OutboxStatusPending, now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due outbox entries: %w", err)
+	}
+	defer rows.Close()
+
+	return scanOutboxEntries(rows)
+}
+
+// CountOutboxEntries returns the number of outbox entries by status.
+func (d *DB) CountOutboxEntries(ctx context.Context) (map[string]int, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT status, COUNT(*) FROM outbox GROUP BY status")
+	if err != nil {
+		return nil, fmt.Errorf("failed to count outbox entries: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox count: %w", err)
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+// UpdateOutboxEntry records the outcome of a delivery attempt.
+func (d *DB) UpdateOutboxEntry(ctx context.Context, entry *OutboxEntry) error {
+	result, err := d.db.ExecContext(ctx, `
+		UPDATE outbox
+		SET status = ?, attempts = ?, next_attempt_at = ?, last_error = ?, delivered_at = ?
+		WHERE id = ?
+	`, entry.Status, entry.Attempts, entry.NextAttemptAt.UTC(), entry.LastError, entry.DeliveredAt, entry.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update outbox entry: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("outbox entry not found: %s", entry.ID)
+	}
+	return nil
+}
+
+// ReplayOutboxEntry returns an entry to pending with a fresh attempt budget
+// so the worker delivers it again immediately.
+func (d *DB) ReplayOutboxEntry(ctx context.Context, id string) error {
+	entry, err := d.GetOutboxEntry(ctx, id)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("outbox entry not found: %s", id)
+	}
+
+	_, err = d.db.ExecContext(ctx, `
+		UPDATE outbox SET status = ?, attempts = 0, next_attempt_at = ?, delivered_at = NULL WHERE id = ?
+	`, OutboxStatusPending, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to replay outbox entry: %w", err)
+	}
+
+	oldValue, newValue := statusChange(entry.Status, OutboxStatusPending)
+	d.Audit(ctx, AuditEntityOutbox, id, AuditActionReplayed, oldValue, newValue, outboxAuditMetadata(entry))
+
+	return nil
+}
+
+// ReplayDeadOutboxEntries returns every dead entry, optionally only those for
+// target, to pending with a fresh attempt budget and returns how many were
+// replayed.
+func (d *DB) ReplayDeadOutboxEntries(ctx context.Context, target string) (int, error) {
+	result, err := d.db.ExecContext(ctx, `
+		UPDATE outbox SET status = ?, attempts = 0, next_attempt_at = ?
+		WHERE status = ? AND (? = '' OR target = ?)
+	`, OutboxStatusPending, time.Now().UTC(), OutboxStatusDead, target, target)
+	if err != nil {
+		return 0, fmt.Errorf("failed to replay outbox entries: %w", err)
+	}
+
+	n, _ := result.RowsAffected()
+	if n > 0 {
+		d.Audit(ctx, AuditEntityOutbox, "", AuditActionReplayed, nil, nil, map[string]interface{}{
+			"target": target,
+			"count":  n,
+		})
+	}
+	return int(n), nil
+}
+
+// outboxAuditMetadata describes an entry in the audit log.
+func outboxAuditMetadata(entry *OutboxEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"event_id":   entry.EventID,
+		"target":     entry.Target,
+		"event_type": entry.EventType,
+		"attempts":   entry.Attempts,
+	}
+}
+
+func scanOutboxEntries(rows *sql.Rows) ([]*OutboxEntry, error) {
+	var entries []*OutboxEntry
+	for rows.Next() {
+		entry, err := scanOutboxEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func scanOutboxEntry(row rowScanner) (*OutboxEntry, error) {
+	var entry OutboxEntry
+	var payload string
+
+	err := row.Scan(
+		&entry.ID, &entry.EventID, &entry.Target, &entry.Destination, &entry.EventType, &payload,
+		&entry.Status, &entry.Attempts, &entry.NextAttemptAt, &entry.LastError,
+		&entry.CreatedAt, &entry.UpdatedAt, &entry.DeliveredAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan outbox entry: %w", err)
+	}
+
+	entry.Payload = json.RawMessage(payload)
+	return &entry, nil
+}
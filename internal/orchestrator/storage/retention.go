@@ -0,0 +1,284 @@
+// Package storage provides SQLite database access for the orchestrator.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Record is a raw database row keyed by column name. Retention archives
+// store rows in this form so they can be read back without the Go types.
+type Record map[string]interface{}
+
+// ID returns the record's "id" column.
+func (r Record) ID() interface{} {
+	return r["id"]
+}
+
+// ExpiredJobs returns up to limit jobs with status that finished before
+// cutoff. Jobs that never recorded a completion time use updated_at.
+func (d *DB) ExpiredJobs(ctx context.Context, status string, cutoff time.Time, limit int) ([]Record, error) {
+	records, err := d.queryRecords(ctx, `
+		SELECT * FROM jobs
+		WHERE status = ? AND COALESCE(completed_at, updated_at) < ?
+		ORDER BY COALESCE(completed_at, updated_at) ASC, id ASC
+		LIMIT ?
+	`, status, cutoff.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired jobs: %w", err)
+	}
+	return records, nil
+}
+
+// DeleteJobsByID removes the given jobs, recording a single audit entry for
+// the batch with metadata describing why they were removed.
+func (d *DB) DeleteJobsByID(ctx context.Context, ids []interface{}, metadata map[string]interface{}) (int, error) {
+	n, err := d.deleteByID(ctx, "jobs", ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete jobs: %w", err)
+	}
+	if n > 0 {
+		d.Audit(ctx, AuditEntityJob, "", AuditActionDeleted, nil, nil, withCount(metadata, n))
+	}
+	return n, nil
+}
+
+// ExpiredScenarios returns up to limit scenarios with status that finished
+// before cutoff, each with its steps under "steps". Scenarios that still have
+// unfinished jobs are never returned.
+func (d *DB) ExpiredScenarios(ctx context.Context, status string, cutoff time.Time, limit int) ([]Record, error) {
+	records, err := d.queryRecords(ctx, `
+		SELECT * FROM scenarios
+		WHERE status = ? AND COALESCE(completed_at, updated_at) < ?
+		AND NOT EXISTS (
+			SELECT 1 FROM jobs
+			WHERE jobs.scenario_id = scenarios.id AND jobs.status IN (?, ?, ?)
+		)
+		ORDER BY COALESCE(completed_at, updated_at) ASC, id ASC
+		LIMIT ?
+	`, status, cutoff.UTC(), JobStatusPending, JobStatusAssigned, JobStatusRunning, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired scenarios: %w", err)
+	}
+
+	for _, record := range records {
+		steps, err := d.queryRecords(ctx, `
+			SELECT * FROM scenario_steps WHERE scenario_id = ? ORDER BY step_order ASC
+		`, record.ID())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list scenario steps: %w", err)
+		}
+		record["steps"] = steps
+	}
+
+	return records, nil
+}
+
+// DeleteScenariosByID removes the given scenarios and their steps, recording
+// a single audit entry for the batch. Jobs that belonged to the scenarios are
+// kept; they are governed by their own retention policy.
+func (d *DB) DeleteScenariosByID(ctx context.Context, ids []interface{}, metadata map[string]interface{}) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	in := placeholders(len(ids))
+	if _, err := tx.ExecContext(ctx, "DELETE FROM scenario_steps WHERE scenario_id IN ("+in+")", ids...); err != nil {
+		return 0, fmt.Errorf("failed to delete scenario steps: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM scenarios WHERE id IN ("+in+")", ids...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete scenarios: %w", err)
+	}
+	n, _ := result.RowsAffected()
+
+	if n > 0 {
+		d.auditWith(ctx, tx, AuditEntityScenario, "", AuditActionDeleted, nil, nil, withCount(metadata, int(n)))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return int(n), nil
+}
+
+// ExpiredAuditEntries returns up to limit audit entries created before cutoff,
+// oldest first.
+func (d *DB) ExpiredAuditEntries(ctx context.Context, cutoff time.Time, limit int) ([]Record, error) {
+	records, err := d.queryRecords(ctx, `
+		SELECT * FROM audit_log WHERE created_at < ? ORDER BY id ASC LIMIT ?
+	`, cutoff.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired audit entries: %w", err)
+	}
+	return records, nil
+}
+
+// DeleteAuditEntriesByID removes the given audit entries. The removal is
+// itself audited so the log shows where history was truncated.
+func (d *DB) DeleteAuditEntriesByID(ctx context.Context, ids []interface{}, metadata map[string]interface{}) (int, error) {
+	n, err := d.deleteByID(ctx, "audit_log", ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete audit entries: %w", err)
+	}
+	if n > 0 {
+		d.Audit(ctx, AuditEntityAuditLog, "", AuditActionDeleted, nil, nil, withCount(metadata, n))
+	}
+	return n, nil
+}
+
+// ExpiredWebhookDeliveries returns up to limit webhook deliveries with status
+// that finished before cutoff. Deliveries that never completed use created_at.
+func (d *DB) ExpiredWebhookDeliveries(ctx context.Context, status string, cutoff time.Time, limit int) ([]Record, error) {
+	records, err := d.queryRecords(ctx, `
+		SELECT * FROM webhook_deliveries
+		WHERE status = ? AND COALESCE(completed_at, created_at) < ?
+		ORDER BY COALESCE(completed_at, created_at) ASC, id ASC
+		LIMIT ?
+	`, status, cutoff.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired webhook deliveries: %w", err)
+	}
+	return records, nil
+}
+
+// DeleteWebhookDeliveriesByID removes the given webhook deliveries, recording
+// a single audit entry for the batch.
+func (d *DB) DeleteWebhookDeliveriesByID(ctx context.Context, ids []interface{}, metadata map[string]interface{}) (int, error) {
+	n, err := d.deleteByID(ctx, "webhook_deliveries", ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete webhook deliveries: %w", err)
+	}
+	if n > 0 {
+		d.Audit(ctx, AuditEntityDelivery, "", AuditActionDeleted, nil, nil, withCount(metadata, n))
+	}
+	return n, nil
+}
+
+// ExpiredOutboxEntries returns up to limit outbox entries with status that
+// were last updated before cutoff: delivered entries by delivered_at, dead
+// ones by when they were given up on.
+func (d *DB) ExpiredOutboxEntries(ctx context.Context, status string, cutoff time.Time, limit int) ([]Record, error) {
+	records, err := d.queryRecords(ctx, `
+		SELECT * FROM outbox
+		WHERE status = ? AND COALESCE(delivered_at, updated_at) < ?
+		ORDER BY COALESCE(delivered_at, updated_at) ASC, id ASC
+		LIMIT ?
+	`, status, cutoff.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired outbox entries: %w", err)
+	}
+	return records, nil
+}
+
+// DeleteOutboxEntriesByID removes the given outbox entries, recording a single
+// audit entry for the batch.
+func (d *DB) DeleteOutboxEntriesByID(ctx context.Context, ids []interface{}, metadata map[string]interface{}) (int, error) {
+	n, err := d.deleteByID(ctx, "outbox", ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete outbox entries: %w", err)
+	}
+	if n > 0 {
+		d.Audit(ctx, AuditEntityOutbox, "", AuditActionDeleted, nil, nil, withCount(metadata, n))
+	}
+	return n, nil
+}
+
+// Checkpoint copies the write-ahead log into the database file and truncates
+// it. It is a no-op when the database is not in WAL mode.
+func (d *DB) Checkpoint(ctx context.Context) error {
+	var busy, logFrames, checkpointed int
+	err := d.db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed)
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint database: %w", err)
+	}
+	if busy != 0 {
+		return fmt.Errorf("failed to checkpoint database: database busy")
+	}
+	return nil
+}
+
+// Vacuum rebuilds the database file, returning space freed by deletions to
+// the filesystem. Writers are blocked while it runs.
+func (d *DB) Vacuum(ctx context.Context) error {
+	start := time.Now()
+	if _, err := d.db.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	d.logger.Info().Dur("duration", time.Since(start)).Msg("Database vacuumed")
+	return nil
+}
+
+// queryRecords runs query and returns every row as a Record.
+func (d *DB) queryRecords(ctx context.Context, query string, args ...interface{}) ([]Record, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		record := make(Record, len(columns))
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				record[col] = string(b)
+			} else {
+				record[col] = values[i]
+			}
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// deleteByID removes rows of table whose id is in ids.
+func (d *DB) deleteByID(ctx context.Context, table string, ids []interface{}) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result, err := d.db.ExecContext(ctx, "DELETE FROM "+table+" WHERE id IN ("+placeholders(len(ids))+")", ids...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	return int(n), nil
+}
+
+// placeholders returns n comma-separated bind parameters.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// withCount copies metadata and adds the number of affected rows.
+func withCount(metadata map[string]interface{}, n int) map[string]interface{} {
+	out := make(map[string]interface{}, len(metadata)+1)
+	for k, v := range metadata {
+		out[k] = v
+	}
+	out["count"] = n
+	return out
+}
@@ -12,6 +12,7 @@ import (
 // Scenario represents a scenario record in the database.
 type Scenario struct {
 	ID           string
+	LabID        string
 	Name         string
 	Description  *string
 	Intent       string // JSON
@@ -60,17 +61,28 @@ const (
 
 // CreateScenario inserts a new scenario record.
 func (d *DB) CreateScenario(ctx context.Context, scenario *Scenario) error {
+	scenario.LabID = labOrDefault(scenario.LabID)
+
 	_, err := d.db.ExecContext(ctx, `
-		INSERT INTO scenarios (id, name, description, intent, source, status)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, scenario.ID, scenario.Name, scenario.Description, scenario.Intent, scenario.Source, scenario.Status)
+		INSERT INTO scenarios (id, lab_id, name, description, intent, source, status, scoring_run_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, scenario.ID, scenario.LabID, scenario.Name, scenario.Description, scenario.Intent, scenario.Source, scenario.Status,
+		scenario.ScoringRunID)
 
 	if err != nil {
 		return fmt.Errorf("failed to insert scenario: %w", err)
 	}
 
+	d.Audit(ctx, AuditEntityScenario, scenario.ID, AuditActionCreated, nil, map[string]interface{}{
+		"lab_id": scenario.LabID,
+		"name":   scenario.Name,
+		"source": scenario.Source,
+		"status": scenario.Status,
+	}, nil)
+
 	d.logger.Info().
 		Str("scenario_id", scenario.ID).
+		Str("lab_id", scenario.LabID).
 		Str("name", scenario.Name).
 		Str("source", scenario.Source).
 		Msg("Scenario created")
@@ -78,16 +90,68 @@ func (d *DB) CreateScenario(ctx context.Context, scenario *Scenario) error {
 	return nil
 }
 
+// CreateActiveScenario inserts a validated scenario as active together with
+// its steps, its jobs and outbox entries, in one transaction, so a failure
+// leaves nothing behind. Each job's LabID is set to its agent's lab.
+func (d *DB) CreateActiveScenario(ctx context.Context, scenario *Scenario, steps []*ScenarioStep, jobs []*Job, outbox ...*OutboxEntry) error {
+	scenario.LabID = labOrDefault(scenario.LabID)
+	scenario.Status = ScenarioStatusActive
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO scenarios (id, lab_id, name, description, intent, source, status, validated_dsl, scoring_run_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, scenario.ID, scenario.LabID, scenario.Name, scenario.Description, scenario.Intent, scenario.Source, scenario.Status,
+		scenario.ValidatedDSL, scenario.ScoringRunID)
+	if err != nil {
+		return fmt.Errorf("failed to insert scenario: %w", err)
+	}
+	d.auditWith(ctx, tx, AuditEntityScenario, scenario.ID, AuditActionCreated, nil, map[string]interface{}{
+		"lab_id": scenario.LabID,
+		"name":   scenario.Name,
+		"source": scenario.Source,
+		"status": scenario.Status,
+	}, nil)
+
+	if err := insertScenarioSteps(ctx, tx, steps); err != nil {
+		return err
+	}
+	if err := d.insertJobs(ctx, tx, jobs); err != nil {
+		return err
+	}
+	if err := insertOutboxEntries(ctx, tx, outbox); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	d.logger.Info().
+		Str("scenario_id", scenario.ID).
+		Str("lab_id", scenario.LabID).
+		Str("name", scenario.Name).
+		Int("jobs", len(jobs)).
+		Msg("Scenario created")
+
+	return nil
+}
+
 // GetScenario retrieves a scenario by ID.
 func (d *DB) GetScenario(ctx context.Context, id string) (*Scenario, error) {
 	var scenario Scenario
 
 	err := d.db.QueryRowContext(ctx, `
-		SELECT id, name, description, intent, source, status, ai_output, validated_dsl,
+		SELECT id, lab_id, name, description, intent, source, status, ai_output, validated_dsl,
 		       error_message, scoring_run_id, created_at, updated_at, completed_at
 		FROM scenarios WHERE id = ?
 	`, id).Scan(
-		&scenario.ID, &scenario.Name, &scenario.Description, &scenario.Intent,
+		&scenario.ID, &scenario.LabID, &scenario.Name, &scenario.Description, &scenario.Intent,
 		&scenario.Source, &scenario.Status, &scenario.AIOutput, &scenario.ValidatedDSL,
 		&scenario.ErrorMessage, &scenario.ScoringRunID, &scenario.CreatedAt, &scenario.UpdatedAt, &scenario.CompletedAt,
 	)
@@ -103,8 +167,15 @@ func (d *DB) GetScenario(ctx context.Context, id string) (*Scenario, error) {
 }
 
 // SetScenarioScoringRunID sets the scoring engine run ID for a scenario.
-func (d *DB) SetScenarioScoringRunID(ctx context.Context, scenarioID string, runID string) error {
-	result, err := d.db.ExecContext(ctx, `
+// Outbox entries are written in the same transaction.
+func (d *DB) SetScenarioScoringRunID(ctx context.Context, scenarioID string, runID string, outbox ...*OutboxEntry) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
 		UPDATE scenarios SET scoring_run_id = ? WHERE id = ?
 	`, runID, scenarioID)
 
@@ -117,6 +188,17 @@ func (d *DB) SetScenarioScoringRunID(ctx context.Context, scenarioID string, run
 		return fmt.Errorf("scenario not found: %s", scenarioID)
 	}
 
+	if err := insertOutboxEntries(ctx, tx, outbox); err != nil {
+		return err
+	}
+
+	d.auditWith(ctx, tx, AuditEntityScenario, scenarioID, AuditActionUpdated, nil,
+		map[string]interface{}{"scoring_run_id": runID}, nil)
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	d.logger.Info().
 		Str("scenario_id", scenarioID).
 		Str("scoring_run_id", runID).
@@ -127,56 +209,17 @@ func (d *DB) SetScenarioScoringRunID(ctx context.Context, scenarioID string, run
 
 // UpdateScenarioStatus updates the scenario status.
 func (d *DB) UpdateScenarioStatus(ctx context.Context, id string, status string) error {
-	result, err := d.db.ExecContext(ctx, `
-		UPDATE scenarios SET status = ? WHERE id = ?
-	`, status, id)
-
-	if err != nil {
-		return fmt.Errorf("failed to update scenario status: %w", err)
-	}
-
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("scenario not found: %s", id)
-	}
-
-	return nil
+	return d.updateScenario(ctx, id, status, nil, nil, "")
 }
 
 // UpdateScenarioAIOutput stores the raw AI output.
 func (d *DB) UpdateScenarioAIOutput(ctx context.Context, id string, aiOutput string) error {
-	result, err := d.db.ExecContext(ctx, `
-		UPDATE scenarios SET ai_output = ?, status = ? WHERE id = ?
-	`, aiOutput, ScenarioStatusPlanning, id)
-
-	if err != nil {
-		return fmt.Errorf("failed to update AI output: %w", err)
-	}
-
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("scenario not found: %s", id)
-	}
-
-	return nil
+	return d.updateScenario(ctx, id, ScenarioStatusPlanning, nil, nil, "ai_output = ?", aiOutput)
 }
 
 // UpdateScenarioValidatedDSL stores the validated DSL.
 func (d *DB) UpdateScenarioValidatedDSL(ctx context.Context, id string, validatedDSL string) error {
-	result, err := d.db.ExecContext(ctx, `
-		UPDATE scenarios SET validated_dsl = ?, status = ? WHERE id = ?
-	`, validatedDSL, ScenarioStatusValidated, id)
-
-	if err != nil {
-		return fmt.Errorf("failed to update validated DSL: %w", err)
-	}
-
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("scenario not found: %s", id)
-	}
-
-	return nil
+	return d.updateScenario(ctx, id, ScenarioStatusValidated, nil, nil, "validated_dsl = ?", validatedDSL)
 }
 
 // UpdateScenarioCompiled marks a scenario as compiled.
@@ -184,24 +227,17 @@ func (d *DB) UpdateScenarioCompiled(ctx context.Context, id string) error {
 	return d.UpdateScenarioStatus(ctx, id, ScenarioStatusCompiled)
 }
 
-// UpdateScenarioActive marks a scenario as active.
-func (d *DB) UpdateScenarioActive(ctx context.Context, id string) error {
-	return d.UpdateScenarioStatus(ctx, id, ScenarioStatusActive)
+// UpdateScenarioActive marks a scenario as active. Outbox entries are written
+// in the same transaction.
+func (d *DB) UpdateScenarioActive(ctx context.Context, id string, outbox ...*OutboxEntry) error {
+	return d.updateScenario(ctx, id, ScenarioStatusActive, nil, outbox, "")
 }
 
-// UpdateScenarioCompleted marks a scenario as completed.
-func (d *DB) UpdateScenarioCompleted(ctx context.Context, id string) error {
-	result, err := d.db.ExecContext(ctx, `
-		UPDATE scenarios SET status = ?, completed_at = CURRENT_TIMESTAMP WHERE id = ?
-	`, ScenarioStatusCompleted, id)
-
-	if err != nil {
-		return fmt.Errorf("failed to complete scenario: %w", err)
-	}
-
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("scenario not found: %s", id)
+// UpdateScenarioCompleted marks a scenario as completed. Outbox entries are
+// written in the same transaction.
+func (d *DB) UpdateScenarioCompleted(ctx context.Context, id string, outbox ...*OutboxEntry) error {
+	if err := d.updateScenario(ctx, id, ScenarioStatusCompleted, nil, outbox, "completed_at = CURRENT_TIMESTAMP"); err != nil {
+		return err
 	}
 
 	d.logger.Info().Str("scenario_id", id).Msg("Scenario completed")
@@ -210,47 +246,36 @@ func (d *DB) UpdateScenarioCompleted(ctx context.Context, id string) error {
 
 // UpdateScenarioFailed marks a scenario as failed with an error message.
 func (d *DB) UpdateScenarioFailed(ctx context.Context, id string, errorMsg string) error {
-	result, err := d.db.ExecContext(ctx, `
-		UPDATE scenarios SET status = ?, error_message = ?, completed_at = CURRENT_TIMESTAMP WHERE id = ?
-	`, ScenarioStatusFailed, errorMsg, id)
-
+	err := d.updateScenario(ctx, id, ScenarioStatusFailed, map[string]interface{}{"error": errorMsg}, nil,
+		"error_message = ?, completed_at = CURRENT_TIMESTAMP", errorMsg)
 	if err != nil {
-		return fmt.Errorf("failed to update scenario failed: %w", err)
-	}
-
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("scenario not found: %s", id)
+		return err
 	}
 
 	d.logger.Warn().Str("scenario_id", id).Str("error", errorMsg).Msg("Scenario failed")
 	return nil
 }
 
-// ListScenarios retrieves scenarios, optionally filtered by status.
-func (d *DB) ListScenarios(ctx context.Context, status string, limit int) ([]*Scenario, error) {
-	var query string
+// ListScenarios retrieves scenarios, optionally filtered by lab and status.
+// An empty labID lists scenarios in all labs.
+func (d *DB) ListScenarios(ctx context.Context, labID, status string, limit int) ([]*Scenario, error) {
+	query := `
+		SELECT id, lab_id, name, description, intent, source, status, ai_output, validated_dsl,
+		       error_message, scoring_run_id, created_at, updated_at, completed_at
+		FROM scenarios WHERE 1=1
+	`
 	var args []interface{}
 
+	if labID != "" {
+		query += " AND lab_id = ?"
+		args = append(args, labID)
+	}
 	if status != "" {
-		query = `
-			SELECT id, name, description, intent, source, status, ai_output, validated_dsl,
-			       error_message, scoring_run_id, created_at, updated_at, completed_at
-			FROM scenarios WHERE status = ?
-			ORDER BY created_at DESC
-			LIMIT ?
-		`
-		args = []interface{}{status, limit}
-	} else {
-		query = `
-			SELECT id, name, description, intent, source, status, ai_output, validated_dsl,
-			       error_message, scoring_run_id, created_at, updated_at, completed_at
-			FROM scenarios
-			ORDER BY created_at DESC
-			LIMIT ?
-		`
-		args = []interface{}{limit}
+		query += " AND status = ?"
+		args = append(args, status)
 	}
+	query += " ORDER BY created_at DESC LIMIT ?"
+	args = append(args, limit)
 
 	rows, err := d.db.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -262,7 +287,7 @@ func (d *DB) ListScenarios(ctx context.Context, status string, limit int) ([]*Sc
 	for rows.Next() {
 		var scenario Scenario
 		if err := rows.Scan(
-			&scenario.ID, &scenario.Name, &scenario.Description, &scenario.Intent,
+			&scenario.ID, &scenario.LabID, &scenario.Name, &scenario.Description, &scenario.Intent,
 			&scenario.Source, &scenario.Status, &scenario.AIOutput, &scenario.ValidatedDSL,
 			&scenario.ErrorMessage, &scenario.ScoringRunID, &scenario.CreatedAt, &scenario.UpdatedAt, &scenario.CompletedAt,
 		); err != nil {
@@ -312,6 +337,15 @@ func (d *DB) CreateScenarioStepsBatch(ctx context.Context, steps []*ScenarioStep
 	}
 	defer tx.Rollback()
 
+	if err := insertScenarioSteps(ctx, tx, steps); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertScenarioSteps inserts steps within tx.
+func insertScenarioSteps(ctx context.Context, tx *sql.Tx, steps []*ScenarioStep) error {
 	stmt, err := tx.PrepareContext(ctx, `
 		INSERT INTO scenario_steps (id, scenario_id, step_order, action_type, target_labels,
 		                            target_count, parameters, delay_before_ms, delay_after_ms, jitter_ms)
@@ -340,8 +374,7 @@ func (d *DB) CreateScenarioStepsBatch(ctx context.Context, steps []*ScenarioStep
 			return fmt.Errorf("failed to insert step: %w", err)
 		}
 	}
-
-	return tx.Commit()
+	return nil
 }
 
 // GetScenarioSteps retrieves all steps for a scenario.
@@ -439,17 +472,39 @@ func (d *DB) CountScenarioSteps(ctx context.Context, scenarioID string) (int, er
 	return count, nil
 }
 
-// DeleteScenario removes a scenario and its steps (cascading).
+// DeleteScenario removes a scenario and its steps. Foreign keys are not
+// enforced, so the steps are deleted explicitly rather than by cascade.
 func (d *DB) DeleteScenario(ctx context.Context, id string) error {
-	result, err := d.db.ExecContext(ctx, "DELETE FROM scenarios WHERE id = ?", id)
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to delete scenario: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
+	var labID, name, status string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM scenarios WHERE id = ? RETURNING lab_id, name, status
+	`, id).Scan(&labID, &name, &status)
+	if err == sql.ErrNoRows {
 		return fmt.Errorf("scenario not found: %s", id)
 	}
+	if err != nil {
+		return fmt.Errorf("failed to delete scenario: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM scenario_steps WHERE scenario_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete scenario steps: %w", err)
+	}
+
+	d.auditWith(ctx, tx, AuditEntityScenario, id, AuditActionDeleted, map[string]interface{}{
+		"lab_id": labID,
+		"name":   name,
+		"status": status,
+	}, nil, nil)
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 
 	d.logger.Info().Str("scenario_id", id).Msg("Scenario deleted")
 	return nil
@@ -475,3 +530,45 @@ func (d *DB) CountScenarios(ctx context.Context, status string) (int, error) {
 
 	return count, nil
 }
+
+// updateScenario sets a scenario's status and any other columns (given as
+// "column = ?, ..." with their values) and audits the change from the status
+// it had, all in one transaction with the outbox entries.
+func (d *DB) updateScenario(ctx context.Context, id, status string, metadata map[string]interface{}, outbox []*OutboxEntry, columns string, args ...interface{}) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var oldStatus string
+	err = tx.QueryRowContext(ctx, "SELECT status FROM scenarios WHERE id = ?", id).Scan(&oldStatus)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("scenario not found: %s", id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get scenario status: %w", err)
+	}
+
+	query := "UPDATE scenarios SET status = ?"
+	if columns != "" {
+		query += ", " + columns
+	}
+	query += " WHERE id = ?"
+	args = append(append([]interface{}{status}, args...), id)
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("failed to update scenario status: %w", err)
+	}
+
+	if err := insertOutboxEntries(ctx, tx, outbox); err != nil {
+		return err
+	}
+
+	oldValue, newValue := statusChange(oldStatus, status)
+	d.auditWith(ctx, tx, AuditEntityScenario, id, AuditActionStatusChanged, oldValue, newValue, metadata)
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Package storage provides SQLite database access for the orchestrator.
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// AgentStore persists registered agents.
+type AgentStore interface {
+	CreateAgent(ctx context.Context, agent *Agent) error
+	GetAgent(ctx context.Context, id string) (*Agent, error)
+	GetAgentByLabHostID(ctx context.Context, labHostID string) (*Agent, error)
+	UpdateAgentHeartbeat(ctx context.Context, id string, status string, ipAddress string) error
+	UpdateAgentCapabilities(ctx context.Context, id string, version string, caps *AgentCapabilities) error
+	UpdateAgentLab(ctx context.Context, id string, labID string) error
+	UpdateAgentStatus(ctx context.Context, id string, status string) error
+	ListAgents(ctx context.Context, labID, status string) ([]*Agent, error)
+	ListAgentsByLabels(ctx context.Context, labID string, labels map[string]string) ([]*Agent, error)
+	MarkStaleAgentsOffline(ctx context.Context, timeout time.Duration) ([]StaleAgent, error)
+	DeleteAgent(ctx context.Context, id string) error
+	CountAgents(ctx context.Context, status string) (int, error)
+	AgentExists(ctx context.Context, id string) (bool, error)
+}
+
+// JobStore persists jobs and their lifecycle transitions.
+type JobStore interface {
+	CreateJob(ctx context.Context, job *Job) error
+	CreateJobBatch(ctx context.Context, jobs []*Job) error
+	GetJob(ctx context.Context, id string) (*Job, error)
+	GetNextJobsForAgent(ctx context.Context, agentID string, limit int) ([]*Job, error)
+	AssignJobs(ctx context.Context, jobIDs []string) error
+	UnassignJobs(ctx context.Context, jobIDs []string) error
+	UpdateJobStarted(ctx context.Context, id string, startedAt time.Time) error
+	UpdateJobCompleted(ctx context.Context, id string, completedAt time.Time, result map[string]interface{}, outbox ...*OutboxEntry) error
+	UpdateJobFailed(ctx context.Context, id string, completedAt time.Time, errorMsg string, retry bool, outbox ...*OutboxEntry) error
+	RescheduleJob(ctx context.Context, id string, scheduledAt time.Time) error
+	CancelJobsForScenario(ctx context.Context, scenarioID string) (map[string]int, error)
+	ListJobsByScenario(ctx context.Context, scenarioID string) ([]*Job, error)
+	ListJobsByAgent(ctx context.Context, agentID string, status string, limit int) ([]*Job, error)
+	ListJobs(ctx context.Context, filter JobFilter) ([]*Job, *JobCursor, error)
+	RequeueJob(ctx context.Context, id, fromStatus string) error
+	RerunJob(ctx context.Context, id string, scheduledAt time.Time) error
+	CancelJob(ctx context.Context, id, fromStatus string) error
+	CountJobsByStatus(ctx context.Context, labID string) (map[string]int, error)
+	GetScenarioJobStats(ctx context.Context, scenarioID string) (total, completed, failed, cancelled, running, pending int, err error)
+	DeleteJob(ctx context.Context, id string) error
+	CleanupOldJobs(ctx context.Context, olderThan time.Duration) (int, error)
+}
+
+// ScenarioStore persists scenarios and their compiled steps.
+type ScenarioStore interface {
+	CreateScenario(ctx context.Context, scenario *Scenario) error
+	CreateActiveScenario(ctx context.Context, scenario *Scenario, steps []*ScenarioStep, jobs []*Job, outbox ...*OutboxEntry) error
+	GetScenario(ctx context.Context, id string) (*Scenario, error)
+	SetScenarioScoringRunID(ctx context.Context, scenarioID string, runID string, outbox ...*OutboxEntry) error
+	UpdateScenarioStatus(ctx context.Context, id string, status string) error
+	UpdateScenarioAIOutput(ctx context.Context, id string, aiOutput string) error
+	UpdateScenarioValidatedDSL(ctx context.Context, id string, validatedDSL string) error
+	UpdateScenarioCompiled(ctx context.Context, id string) error
+	UpdateScenarioActive(ctx context.Context, id string, outbox ...*OutboxEntry) error
+	UpdateScenarioCompleted(ctx context.Context, id string, outbox ...*OutboxEntry) error
+	UpdateScenarioFailed(ctx context.Context, id string, errorMsg string) error
+	ListScenarios(ctx context.Context, labID, status string, limit int) ([]*Scenario, error)
+	CreateScenarioStep(ctx context.Context, step *ScenarioStep) error
+	CreateScenarioStepsBatch(ctx context.Context, steps []*ScenarioStep) error
+	GetScenarioSteps(ctx context.Context, scenarioID string) ([]*ScenarioStep, error)
+	GetScenarioStep(ctx context.Context, id string) (*ScenarioStep, error)
+	DeleteScenarioSteps(ctx context.Context, scenarioID string) error
+	CountScenarioSteps(ctx context.Context, scenarioID string) (int, error)
+	DeleteScenario(ctx context.Context, id string) error
+	CountScenarios(ctx context.Context, status string) (int, error)
+}
+
+// UserStore persists impersonation users.
+type UserStore interface {
+	CreateImpersonationUser(ctx context.Context, user *ImpersonationUser) error
+	GetImpersonationUser(ctx context.Context, id string) (*ImpersonationUser, error)
+	GetImpersonationUserByUsername(ctx context.Context, labID, username string) (*ImpersonationUser, error)
+	ListImpersonationUsers(ctx context.Context, labID string) ([]*ImpersonationUser, error)
+	ListImpersonationUsersByDepartment(ctx context.Context, labID, department string) ([]*ImpersonationUser, error)
+	UpdateImpersonationUser(ctx context.Context, user *ImpersonationUser) error
+	DeleteImpersonationUser(ctx context.Context, id string) error
+	CountImpersonationUsers(ctx context.Context) (int, error)
+}
+
+// LabStore persists labs.
+type LabStore interface {
+	CreateLab(ctx context.Context, lab *Lab) error
+	GetLab(ctx context.Context, id string) (*Lab, error)
+	ListLabs(ctx context.Context) ([]*Lab, error)
+	UpdateLab(ctx context.Context, lab *Lab) error
+	DeleteLab(ctx context.Context, id string) error
+}
+
+// APIKeyStore persists operator API keys.
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]*APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	TouchAPIKey(ctx context.Context, id string) error
+}
+
+// WebhookStore persists webhook subscriptions and their delivery history.
+type WebhookStore interface {
+	CreateWebhookSubscription(ctx context.Context, sub *WebhookSubscription) error
+	GetWebhookSubscription(ctx context.Context, id string) (*WebhookSubscription, error)
+	ListWebhookSubscriptions(ctx context.Context) ([]*WebhookSubscription, error)
+	UpdateWebhookSubscription(ctx context.Context, sub *WebhookSubscription) error
+	DeleteWebhookSubscription(ctx context.Context, id string) error
+	CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	UpdateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	ListWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]*WebhookDelivery, error)
+}
+
+// OutboxStore persists events awaiting delivery to the scoring engine and
+// messenger. Entries are added by the job and scenario updates that produce
+// them.
+type OutboxStore interface {
+	GetOutboxEntry(ctx context.Context, id string) (*OutboxEntry, error)
+	ListOutboxEntries(ctx context.Context, filter OutboxFilter) ([]*OutboxEntry, error)
+	ListDueOutboxEntries(ctx context.Context, now time.Time, limit int) ([]*OutboxEntry, error)
+	CountOutboxEntries(ctx context.Context) (map[string]int, error)
+	UpdateOutboxEntry(ctx context.Context, entry *OutboxEntry) error
+	ReplayOutboxEntry(ctx context.Context, id string) error
+	ReplayDeadOutboxEntries(ctx context.Context, target string) (int, error)
+}
+
+// AuditStore persists the audit log.
+type AuditStore interface {
+	CreateAuditEntry(ctx context.Context, entry *AuditEntry) error
+	Audit(ctx context.Context, entityType, entityID, action string, oldValue, newValue, metadata map[string]interface{})
+	ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error)
+	ExportAuditEntries(ctx context.Context, filter AuditFilter, fn func(*AuditEntry) error) error
+}
+
+// Store is the full set of persistence operations used by the orchestrator.
+// DB (SQLite) is the default implementation; Memory keeps everything in
+// process for tests and ephemeral demo orchestrators.
+type Store interface {
+	AgentStore
+	JobStore
+	ScenarioStore
+	UserStore
+	LabStore
+	APIKeyStore
+	WebhookStore
+	OutboxStore
+	AuditStore
+
+	// Ping verifies the store is reachable
+	Ping(ctx context.Context) error
+
+	// Health returns "healthy", "degraded" or "unhealthy"
+	Health(ctx context.Context) (string, error)
+
+	Close() error
+}
+
+// Backuper is implemented by stores that can write a consistent copy of
+// themselves to a file.
+type Backuper interface {
+	Backup(ctx context.Context, path string) error
+}
+
+var (
+	_ Store    = (*DB)(nil)
+	_ Backuper = (*DB)(nil)
+	_ Store    = (*Memory)(nil)
+)
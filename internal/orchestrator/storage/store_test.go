@@ -0,0 +1,428 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// forEachStore runs a contract test against both Store implementations, so
+// the in-memory store used by most tests behaves like SQLite.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := New(context.Background(), Config{Path: filepath.Join(t.TempDir(), "store.db")}, zerolog.Nop())
+		if err != nil {
+			t.Fatalf("Failed to create test database: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		test(t, db)
+	})
+	t.Run("memory", func(t *testing.T) {
+		m := NewMemory(zerolog.Nop())
+		t.Cleanup(func() { m.Close() })
+		test(t, m)
+	})
+}
+
+func createStoreAgent(t *testing.T, store Store, id, labID string, labels map[string]string) {
+	t.Helper()
+	err := store.CreateAgent(context.Background(), &Agent{
+		ID:        id,
+		LabID:     labID,
+		LabHostID: "host-" + id,
+		Hostname:  "ws-" + id,
+		IPAddress: "10.0.0.1",
+		Labels:    labels,
+		Status:    "online",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create agent: %v", err)
+	}
+}
+
+func createStoreJob(t *testing.T, store Store, id, agentID string, scenarioID *string, scheduledAt time.Time) {
+	t.Helper()
+	err := store.CreateJob(context.Background(), &Job{
+		ID:          id,
+		ScenarioID:  scenarioID,
+		AgentID:     agentID,
+		ActionType:  "simulate_browsing",
+		Parameters:  map[string]interface{}{"urls": []interface{}{"https://example.com"}},
+		Status:      JobStatusPending,
+		MaxRetries:  1,
+		ScheduledAt: scheduledAt,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+}
+
+func TestStore_Agents(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		createStoreAgent(t, store, "agent-1", "", map[string]string{"role": "workstation", "os": "windows"})
+		createStoreAgent(t, store, "agent-2", "", map[string]string{"role": "server"})
+
+		if err := store.CreateAgent(ctx, &Agent{ID: "agent-1", LabHostID: "other", Hostname: "x", Status: "online"}); err == nil {
+			t.Error("Expected a duplicate agent ID to be rejected")
+		}
+
+		agent, err := store.GetAgent(ctx, "agent-1")
+		if err != nil || agent == nil {
+			t.Fatalf("GetAgent() = %v, %v", agent, err)
+		}
+		if agent.LabID != "default" || agent.Labels["os"] != "windows" || agent.Hostname != "ws-agent-1" {
+			t.Errorf("Unexpected agent: %+v", agent)
+		}
+		if missing, err := store.GetAgent(ctx, "agent-missing"); missing != nil || err != nil {
+			t.Errorf("Expected nil for a missing agent, got %+v (err: %v)", missing, err)
+		}
+		if byHost, _ := store.GetAgentByLabHostID(ctx, "host-agent-2"); byHost == nil || byHost.ID != "agent-2" {
+			t.Errorf("Expected agent-2 by lab host ID, got %+v", byHost)
+		}
+
+		workstations, err := store.ListAgentsByLabels(ctx, "", map[string]string{"role": "workstation"})
+		if err != nil || len(workstations) != 1 || workstations[0].ID != "agent-1" {
+			t.Errorf("Expected agent-1 for role=workstation, got %+v (err: %v)", workstations, err)
+		}
+
+		if err := store.UpdateAgentStatus(ctx, "agent-2", "offline"); err != nil {
+			t.Fatalf("UpdateAgentStatus() error = %v", err)
+		}
+		if online, _ := store.CountAgents(ctx, "online"); online != 1 {
+			t.Errorf("Expected 1 online agent, got %d", online)
+		}
+		offline, err := store.ListAgents(ctx, "", "offline")
+		if err != nil || len(offline) != 1 || offline[0].ID != "agent-2" {
+			t.Errorf("Expected agent-2 offline, got %+v (err: %v)", offline, err)
+		}
+
+		if err := store.DeleteAgent(ctx, "agent-2"); err != nil {
+			t.Fatalf("DeleteAgent() error = %v", err)
+		}
+		if exists, _ := store.AgentExists(ctx, "agent-2"); exists {
+			t.Error("Expected agent-2 to be deleted")
+		}
+	})
+}
+
+func TestStore_JobLifecycle(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		past := time.Now().UTC().Add(-time.Minute)
+		createStoreAgent(t, store, "agent-1", "", nil)
+		createStoreJob(t, store, "job-due", "agent-1", nil, past)
+		createStoreJob(t, store, "job-later", "agent-1", nil, time.Now().UTC().Add(time.Hour))
+
+		job, err := store.GetJob(ctx, "job-due")
+		if err != nil || job == nil || job.LabID != "default" || job.Status != JobStatusPending {
+			t.Fatalf("Unexpected job: %+v (err: %v)", job, err)
+		}
+
+		// Only due jobs are handed out
+		next, err := store.GetNextJobsForAgent(ctx, "agent-1", 10)
+		if err != nil || len(next) != 1 || next[0].ID != "job-due" {
+			t.Fatalf("Expected only job-due, got %+v (err: %v)", next, err)
+		}
+		if err := store.AssignJobs(ctx, []string{"job-due"}); err != nil {
+			t.Fatalf("AssignJobs() error = %v", err)
+		}
+		if next, _ := store.GetNextJobsForAgent(ctx, "agent-1", 10); len(next) != 0 {
+			t.Errorf("Expected no due jobs after assignment, got %d", len(next))
+		}
+
+		// A job that never reached its agent is handed out again
+		if err := store.UnassignJobs(ctx, []string{"job-due", "job-later"}); err != nil {
+			t.Fatalf("UnassignJobs() error = %v", err)
+		}
+		job, _ = store.GetJob(ctx, "job-due")
+		if job.Status != JobStatusPending || job.AssignedAt != nil || job.RunCount != 0 {
+			t.Errorf("Expected an unassigned job, got status=%s runs=%d", job.Status, job.RunCount)
+		}
+		if next, _ := store.GetNextJobsForAgent(ctx, "agent-1", 10); len(next) != 1 || next[0].ID != "job-due" {
+			t.Fatalf("Expected job-due to be due again, got %+v", next)
+		}
+		if err := store.AssignJobs(ctx, []string{"job-due"}); err != nil {
+			t.Fatalf("AssignJobs() error = %v", err)
+		}
+
+		// A retryable failure with retries left goes back to pending
+		if err := store.UpdateJobFailed(ctx, "job-due", time.Now().UTC(), "boom", true); err != nil {
+			t.Fatalf("UpdateJobFailed() error = %v", err)
+		}
+		job, _ = store.GetJob(ctx, "job-due")
+		if job.Status != JobStatusPending || job.RetryCount != 1 || job.RunCount != 1 {
+			t.Errorf("Expected a pending retry, got status=%s retries=%d runs=%d", job.Status, job.RetryCount, job.RunCount)
+		}
+
+		// Without retries left it fails
+		if err := store.UpdateJobFailed(ctx, "job-due", time.Now().UTC(), "boom again", true); err != nil {
+			t.Fatalf("UpdateJobFailed() error = %v", err)
+		}
+		job, _ = store.GetJob(ctx, "job-due")
+		if job.Status != JobStatusFailed || job.ErrorMessage == nil || *job.ErrorMessage != "boom again" {
+			t.Errorf("Expected the job to fail, got %+v", job)
+		}
+
+		// Requeue only applies to jobs in the given status
+		if err := store.RequeueJob(ctx, "job-due", JobStatusCancelled); !errors.Is(err, ErrJobNotFound) {
+			t.Errorf("Expected ErrJobNotFound requeueing from the wrong status, got %v", err)
+		}
+		if err := store.RequeueJob(ctx, "job-due", JobStatusFailed); err != nil {
+			t.Fatalf("RequeueJob() error = %v", err)
+		}
+		job, _ = store.GetJob(ctx, "job-due")
+		if job.Status != JobStatusPending || job.ErrorMessage != nil || job.RunCount != 2 {
+			t.Errorf("Expected a requeued job, got %+v", job)
+		}
+
+		// Results are stored as JSON
+		result := map[string]interface{}{"pages_loaded": 3, "title": "Example"}
+		if err := store.UpdateJobCompleted(ctx, "job-due", time.Now().UTC(), result); err != nil {
+			t.Fatalf("UpdateJobCompleted() error = %v", err)
+		}
+		job, _ = store.GetJob(ctx, "job-due")
+		if job.Status != JobStatusCompleted || job.Result["pages_loaded"] != float64(3) || job.Result["title"] != "Example" {
+			t.Errorf("Unexpected completed job: %+v", job)
+		}
+		if job.Parameters["urls"].([]interface{})[0] != "https://example.com" {
+			t.Errorf("Unexpected parameters: %+v", job.Parameters)
+		}
+
+		rerunAt := time.Now().UTC().Add(time.Minute).Truncate(time.Second)
+		if err := store.RerunJob(ctx, "job-due", rerunAt); err != nil {
+			t.Fatalf("RerunJob() error = %v", err)
+		}
+		job, _ = store.GetJob(ctx, "job-due")
+		if job.Status != JobStatusPending || !job.ScheduledAt.Equal(rerunAt) || job.RunCount != 3 || job.Result["title"] != "Example" {
+			t.Errorf("Expected a re-run that keeps the last result, got %+v", job)
+		}
+
+		if err := store.CancelJob(ctx, "job-later", JobStatusPending); err != nil {
+			t.Fatalf("CancelJob() error = %v", err)
+		}
+		if err := store.CancelJob(ctx, "job-later", JobStatusPending); !errors.Is(err, ErrJobNotFound) {
+			t.Errorf("Expected ErrJobNotFound cancelling twice, got %v", err)
+		}
+
+		counts, err := store.CountJobsByStatus(ctx, "")
+		if err != nil || counts[JobStatusPending] != 1 || counts[JobStatusCancelled] != 1 {
+			t.Errorf("Unexpected job counts: %v (err: %v)", counts, err)
+		}
+	})
+}
+
+func TestStore_ScenarioJobStats(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		past := time.Now().UTC().Add(-time.Minute)
+		scenarioID := "scenario-1"
+		createStoreAgent(t, store, "agent-1", "", nil)
+		if err := store.CreateScenario(ctx, &Scenario{ID: scenarioID, Name: "Stats", Intent: "{}", Source: ScenarioSourceAPI, Status: ScenarioStatusValidated}); err != nil {
+			t.Fatalf("CreateScenario() error = %v", err)
+		}
+		for _, id := range []string{"job-1", "job-2", "job-3", "job-4"} {
+			createStoreJob(t, store, id, "agent-1", &scenarioID, past)
+		}
+
+		_ = store.UpdateJobCompleted(ctx, "job-1", time.Now().UTC(), nil)
+		_ = store.UpdateJobFailed(ctx, "job-2", time.Now().UTC(), "boom", false)
+		_ = store.CancelJob(ctx, "job-3", JobStatusPending)
+
+		total, completed, failed, cancelled, running, pending, err := store.GetScenarioJobStats(ctx, scenarioID)
+		if err != nil {
+			t.Fatalf("GetScenarioJobStats() error = %v", err)
+		}
+		if total != 4 || completed != 1 || failed != 1 || cancelled != 1 || running != 0 || pending != 1 {
+			t.Errorf("Unexpected stats: total=%d completed=%d failed=%d cancelled=%d running=%d pending=%d",
+				total, completed, failed, cancelled, running, pending)
+		}
+
+		// Cancelling the scenario's pending jobs reports them by action type
+		byAction, err := store.CancelJobsForScenario(ctx, scenarioID)
+		if err != nil || byAction["simulate_browsing"] != 1 {
+			t.Errorf("Expected one cancelled job, got %v (err: %v)", byAction, err)
+		}
+	})
+}
+
+func TestStore_ScenarioLifecycle(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		scenario := &Scenario{ID: "scenario-1", Name: "Lifecycle", Intent: "{}", Source: ScenarioSourceAPI, Status: ScenarioStatusPending}
+		if err := store.CreateScenario(ctx, scenario); err != nil {
+			t.Fatalf("CreateScenario() error = %v", err)
+		}
+		if err := store.CreateScenario(ctx, scenario); err == nil {
+			t.Error("Expected a duplicate scenario ID to be rejected")
+		}
+
+		steps := []*ScenarioStep{
+			{ID: "step-1", ScenarioID: "scenario-1", StepOrder: 1, ActionType: "simulate_browsing", Parameters: map[string]interface{}{"urls": []interface{}{"https://example.com"}}},
+			{ID: "step-2", ScenarioID: "scenario-1", StepOrder: 2, ActionType: "simulate_file_activity"},
+		}
+		if err := store.CreateScenarioStepsBatch(ctx, steps); err != nil {
+			t.Fatalf("CreateScenarioStepsBatch() error = %v", err)
+		}
+		got, err := store.GetScenarioSteps(ctx, "scenario-1")
+		if err != nil || len(got) != 2 || got[0].ID != "step-1" || got[1].StepOrder != 2 {
+			t.Errorf("Unexpected steps: %+v (err: %v)", got, err)
+		}
+
+		if err := store.UpdateScenarioActive(ctx, "scenario-1"); err != nil {
+			t.Fatalf("UpdateScenarioActive() error = %v", err)
+		}
+		active, _ := store.ListScenarios(ctx, "", ScenarioStatusActive, 10)
+		if len(active) != 1 || active[0].ID != "scenario-1" || active[0].LabID != "default" {
+			t.Errorf("Expected scenario-1 to be active, got %+v", active)
+		}
+
+		if err := store.SetScenarioScoringRunID(ctx, "scenario-1", "run-1"); err != nil {
+			t.Fatalf("SetScenarioScoringRunID() error = %v", err)
+		}
+		if err := store.UpdateScenarioCompleted(ctx, "scenario-1"); err != nil {
+			t.Fatalf("UpdateScenarioCompleted() error = %v", err)
+		}
+		done, _ := store.GetScenario(ctx, "scenario-1")
+		if done.Status != ScenarioStatusCompleted || done.CompletedAt == nil || done.ScoringRunID == nil || *done.ScoringRunID != "run-1" {
+			t.Errorf("Unexpected completed scenario: %+v", done)
+		}
+
+		if err := store.DeleteScenario(ctx, "scenario-1"); err != nil {
+			t.Fatalf("DeleteScenario() error = %v", err)
+		}
+		if gone, err := store.GetScenario(ctx, "scenario-1"); gone != nil || err != nil {
+			t.Errorf("Expected the scenario to be deleted, got %+v (err: %v)", gone, err)
+		}
+		if n, _ := store.CountScenarioSteps(ctx, "scenario-1"); n != 0 {
+			t.Errorf("Expected the steps to be deleted with the scenario, got %d", n)
+		}
+	})
+}
+
+func TestStore_CreateActiveScenario(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		createStoreAgent(t, store, "agent-1", "lab-a", nil)
+		createStoreJob(t, store, "job-taken", "agent-1", nil, time.Now().UTC())
+
+		scenarioID := "scenario-1"
+		dsl := `{"steps":[]}`
+		newScenario := func() *Scenario {
+			return &Scenario{ID: scenarioID, LabID: "lab-a", Name: "Atomic", Intent: "{}", Source: ScenarioSourceAPI, ValidatedDSL: &dsl}
+		}
+		steps := []*ScenarioStep{{ID: "step-1", ScenarioID: scenarioID, StepOrder: 1, ActionType: "simulate_browsing"}}
+		newJobs := func(ids ...string) []*Job {
+			var jobs []*Job
+			for _, id := range ids {
+				jobs = append(jobs, &Job{ID: id, ScenarioID: &scenarioID, AgentID: "agent-1", ActionType: "simulate_browsing", Status: JobStatusPending, ScheduledAt: time.Now().UTC()})
+			}
+			return jobs
+		}
+		started, err := NewOutboxEntry(OutboxTargetMessenger, "lab-a", "scenario-1-started", "scenario.started", map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A job that cannot be inserted leaves no partial scenario behind
+		if err := store.CreateActiveScenario(ctx, newScenario(), steps, newJobs("job-1", "job-taken"), started); err == nil {
+			t.Fatal("Expected a duplicate job ID to be rejected")
+		}
+		if scenario, _ := store.GetScenario(ctx, scenarioID); scenario != nil {
+			t.Errorf("Expected no scenario after a failed create, got %+v", scenario)
+		}
+		if n, _ := store.CountScenarioSteps(ctx, scenarioID); n != 0 {
+			t.Errorf("Expected no steps after a failed create, got %d", n)
+		}
+		if job, _ := store.GetJob(ctx, "job-1"); job != nil {
+			t.Error("Expected no jobs after a failed create")
+		}
+		if entries, _ := store.ListOutboxEntries(ctx, OutboxFilter{}); len(entries) != 0 {
+			t.Errorf("Expected no outbox entries after a failed create, got %d", len(entries))
+		}
+
+		// So the retry succeeds
+		scenario := newScenario()
+		if err := store.CreateActiveScenario(ctx, scenario, steps, newJobs("job-1", "job-2"), started); err != nil {
+			t.Fatalf("CreateActiveScenario() error = %v", err)
+		}
+		stored, _ := store.GetScenario(ctx, scenarioID)
+		if stored == nil || stored.Status != ScenarioStatusActive || stored.ValidatedDSL == nil || *stored.ValidatedDSL != dsl {
+			t.Errorf("Unexpected scenario: %+v", stored)
+		}
+		if total, _, _, _, _, pending, _ := store.GetScenarioJobStats(ctx, scenarioID); total != 2 || pending != 2 {
+			t.Errorf("Expected two pending jobs, got total=%d pending=%d", total, pending)
+		}
+		if job, _ := store.GetJob(ctx, "job-1"); job == nil || job.LabID != "lab-a" {
+			t.Errorf("Expected job-1 in lab-a, got %+v", job)
+		}
+		if n, _ := store.CountScenarioSteps(ctx, scenarioID); n != 1 {
+			t.Errorf("Expected one step, got %d", n)
+		}
+		if entries, _ := store.ListOutboxEntries(ctx, OutboxFilter{}); len(entries) != 1 {
+			t.Errorf("Expected the started entry, got %d entries", len(entries))
+		}
+	})
+}
+
+func TestStore_Outbox(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		createStoreAgent(t, store, "agent-1", "", nil)
+		createStoreJob(t, store, "job-1", "agent-1", nil, time.Now().UTC().Add(-time.Minute))
+
+		entry := func(eventID string) *OutboxEntry {
+			e, err := NewOutboxEntry(OutboxTargetMessenger, "default", eventID, "job.completed", map[string]string{"job_id": "job-1"})
+			if err != nil {
+				t.Fatalf("NewOutboxEntry() error = %v", err)
+			}
+			return e
+		}
+
+		// Entries are written with the job update; a repeated event ID is dropped
+		if err := store.UpdateJobCompleted(ctx, "job-1", time.Now().UTC(), nil, entry("job-job-1-0")); err != nil {
+			t.Fatalf("UpdateJobCompleted() error = %v", err)
+		}
+		if err := store.UpdateJobCompleted(ctx, "job-1", time.Now().UTC(), nil, entry("job-job-1-0")); err != nil {
+			t.Fatalf("UpdateJobCompleted() error = %v", err)
+		}
+		entries, err := store.ListOutboxEntries(ctx, OutboxFilter{Target: OutboxTargetMessenger})
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("Expected one outbox entry, got %d (err: %v)", len(entries), err)
+		}
+		if entries[0].Status != OutboxStatusPending || string(entries[0].Payload) != `{"job_id":"job-1"}` {
+			t.Errorf("Unexpected entry: %+v", entries[0])
+		}
+
+		due, err := store.ListDueOutboxEntries(ctx, time.Now().UTC().Add(time.Second), 10)
+		if err != nil || len(due) != 1 {
+			t.Fatalf("Expected one due entry, got %d (err: %v)", len(due), err)
+		}
+
+		// A dead entry is no longer due until it is replayed
+		dead := due[0]
+		dead.Status = OutboxStatusDead
+		dead.Attempts = 5
+		if err := store.UpdateOutboxEntry(ctx, dead); err != nil {
+			t.Fatalf("UpdateOutboxEntry() error = %v", err)
+		}
+		if due, _ := store.ListDueOutboxEntries(ctx, time.Now().UTC().Add(time.Second), 10); len(due) != 0 {
+			t.Errorf("Expected no due entries, got %d", len(due))
+		}
+		if counts, _ := store.CountOutboxEntries(ctx); counts[OutboxStatusDead] != 1 {
+			t.Errorf("Expected one dead entry, got %v", counts)
+		}
+
+		if n, err := store.ReplayDeadOutboxEntries(ctx, OutboxTargetMessenger); err != nil || n != 1 {
+			t.Fatalf("Expected one replayed entry, got %d (err: %v)", n, err)
+		}
+		replayed, _ := store.GetOutboxEntry(ctx, dead.ID)
+		if replayed.Status != OutboxStatusPending || replayed.EventID != "job-job-1-0" {
+			t.Errorf("Expected a pending entry with the same event ID, got %+v", replayed)
+		}
+	})
+}
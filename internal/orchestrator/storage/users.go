@@ -14,6 +14,7 @@ import (
 // ImpersonationUser represents a domain user available for agent impersonation.
 type ImpersonationUser struct {
 	ID             string       `json:"id"`
+	LabID          string       `json:"lab_id"`
 	Username       string       `json:"username"`         // Full username (DOMAIN\user)
 	Domain         string       `json:"domain"`           // Domain name
 	SAMAccountName string       `json:"sam_account_name"` // SAM account name
@@ -59,17 +60,31 @@ func (d *DB) CreateImpersonationUser(ctx context.Context, user *ImpersonationUse
 		}
 	}
 
+	user.LabID = labOrDefault(user.LabID)
+
 	_, err = d.db.ExecContext(ctx, `
-		INSERT INTO impersonation_users (id, username, domain, sam_account_name, display_name, department, title, allowed_hosts, persona)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, user.ID, user.Username, user.Domain, user.SAMAccountName, user.DisplayName, user.Department, user.Title, string(allowedHostsJSON), string(personaJSON))
+		INSERT INTO impersonation_users (id, lab_id, username, domain, sam_account_name, display_name, department, title, allowed_hosts, persona)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, user.ID, user.LabID, user.Username, user.Domain, user.SAMAccountName, user.DisplayName, user.Department, user.Title, string(allowedHostsJSON), string(personaJSON))
 
 	if err != nil {
 		return fmt.Errorf("failed to create impersonation user: %w", err)
 	}
 
+	d.Audit(ctx, AuditEntityUser, user.ID, AuditActionCreated, nil, map[string]interface{}{
+		"lab_id":           user.LabID,
+		"username":         user.Username,
+		"domain":           user.Domain,
+		"sam_account_name": user.SAMAccountName,
+		"display_name":     user.DisplayName,
+		"department":       user.Department,
+		"title":            user.Title,
+		"allowed_hosts":    user.AllowedHosts,
+	}, nil)
+
 	d.logger.Info().
 		Str("user_id", user.ID).
+		Str("lab_id", user.LabID).
 		Str("username", user.Username).
 		Msg("Created impersonation user")
 
@@ -79,7 +94,7 @@ func (d *DB) CreateImpersonationUser(ctx context.Context, user *ImpersonationUse
 // GetImpersonationUser retrieves an impersonation user by ID.
 func (d *DB) GetImpersonationUser(ctx context.Context, id string) (*ImpersonationUser, error) {
 	row := d.db.QueryRowContext(ctx, `
-		SELECT id, username, domain, sam_account_name, display_name, department, title, allowed_hosts, persona, created_at, updated_at
+		SELECT id, lab_id, username, domain, sam_account_name, display_name, department, title, allowed_hosts, persona, created_at, updated_at
 		FROM impersonation_users
 		WHERE id = ?
 	`, id)
@@ -87,24 +102,26 @@ func (d *DB) GetImpersonationUser(ctx context.Context, id string) (*Impersonatio
 	return d.scanImpersonationUser(row)
 }
 
-// GetImpersonationUserByUsername retrieves an impersonation user by username.
-func (d *DB) GetImpersonationUserByUsername(ctx context.Context, username string) (*ImpersonationUser, error) {
+// GetImpersonationUserByUsername retrieves an impersonation user by username within a lab.
+func (d *DB) GetImpersonationUserByUsername(ctx context.Context, labID, username string) (*ImpersonationUser, error) {
 	row := d.db.QueryRowContext(ctx, `
-		SELECT id, username, domain, sam_account_name, display_name, department, title, allowed_hosts, persona, created_at, updated_at
+		SELECT id, lab_id, username, domain, sam_account_name, display_name, department, title, allowed_hosts, persona, created_at, updated_at
 		FROM impersonation_users
-		WHERE username = ?
-	`, username)
+		WHERE lab_id = ? AND username = ?
+	`, labOrDefault(labID), username)
 
 	return d.scanImpersonationUser(row)
 }
 
-// ListImpersonationUsers returns all impersonation users.
-func (d *DB) ListImpersonationUsers(ctx context.Context) ([]*ImpersonationUser, error) {
+// ListImpersonationUsers returns impersonation users, optionally scoped to a lab.
+// An empty labID lists users in all labs.
+func (d *DB) ListImpersonationUsers(ctx context.Context, labID string) ([]*ImpersonationUser, error) {
 	rows, err := d.db.QueryContext(ctx, `
-		SELECT id, username, domain, sam_account_name, display_name, department, title, allowed_hosts, persona, created_at, updated_at
+		SELECT id, lab_id, username, domain, sam_account_name, display_name, department, title, allowed_hosts, persona, created_at, updated_at
 		FROM impersonation_users
+		WHERE ? = '' OR lab_id = ?
 		ORDER BY display_name, username
-	`)
+	`, labID, labID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list impersonation users: %w", err)
 	}
@@ -122,14 +139,14 @@ func (d *DB) ListImpersonationUsers(ctx context.Context) ([]*ImpersonationUser,
 	return users, rows.Err()
 }
 
-// ListImpersonationUsersByDepartment returns users in a specific department.
-func (d *DB) ListImpersonationUsersByDepartment(ctx context.Context, department string) ([]*ImpersonationUser, error) {
+// ListImpersonationUsersByDepartment returns users in a specific department, optionally scoped to a lab.
+func (d *DB) ListImpersonationUsersByDepartment(ctx context.Context, labID, department string) ([]*ImpersonationUser, error) {
 	rows, err := d.db.QueryContext(ctx, `
-		SELECT id, username, domain, sam_account_name, display_name, department, title, allowed_hosts, persona, created_at, updated_at
+		SELECT id, lab_id, username, domain, sam_account_name, display_name, department, title, allowed_hosts, persona, created_at, updated_at
 		FROM impersonation_users
-		WHERE department = ?
+		WHERE department = ? AND (? = '' OR lab_id = ?)
 		ORDER BY display_name, username
-	`, department)
+	`, department, labID, labID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list impersonation users by department: %w", err)
 	}
@@ -177,6 +194,13 @@ func (d *DB) UpdateImpersonationUser(ctx context.Context, user *ImpersonationUse
 		return fmt.Errorf("impersonation user not found: %s", user.ID)
 	}
 
+	d.Audit(ctx, AuditEntityUser, user.ID, AuditActionUpdated, nil, map[string]interface{}{
+		"display_name":  user.DisplayName,
+		"department":    user.Department,
+		"title":         user.Title,
+		"allowed_hosts": user.AllowedHosts,
+	}, nil)
+
 	return nil
 }
 
@@ -192,6 +216,8 @@ func (d *DB) DeleteImpersonationUser(ctx context.Context, id string) error {
 		return fmt.Errorf("impersonation user not found: %s", id)
 	}
 
+	d.Audit(ctx, AuditEntityUser, id, AuditActionDeleted, nil, nil, nil)
+
 	d.logger.Info().Str("user_id", id).Msg("Deleted impersonation user")
 	return nil
 }
@@ -212,6 +238,7 @@ func (d *DB) scanImpersonationUser(row *sql.Row) (*ImpersonationUser, error) {
 
 	err := row.Scan(
 		&user.ID,
+		&user.LabID,
 		&user.Username,
 		&user.Domain,
 		&user.SAMAccountName,
@@ -257,6 +284,7 @@ func (d *DB) scanImpersonationUserRow(rows *sql.Rows) (*ImpersonationUser, error
 
 	err := rows.Scan(
 		&user.ID,
+		&user.LabID,
 		&user.Username,
 		&user.Domain,
 		&user.SAMAccountName,
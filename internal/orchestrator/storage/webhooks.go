@@ -0,0 +1,326 @@
+// Package storage provides SQLite database access for the orchestrator.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Webhook delivery statuses.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookDeliveryHistory is how many deliveries are kept per subscription.
+// Older ones are pruned as new ones are recorded.
+const WebhookDeliveryHistory = 100
+
+// WebhookSubscription is an external endpoint that receives orchestrator
+// events matching its filters.
+type WebhookSubscription struct {
+	ID           string
+	Name         string
+	URL          string
+	Secret       string
+	EventTypes   []string // event types or categories; empty matches all
+	LabID        string   // empty matches all labs
+	ScenarioID   string   // empty matches all scenarios
+	Enabled      bool
+	MaxRetries   int
+	RetryBackoff time.Duration
+	Timeout      time.Duration
+	CreatedBy    *string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// WebhookDelivery records one event sent to a subscription.
+type WebhookDelivery struct {
+	ID             string
+	SubscriptionID string
+	EventID        uint64
+	EventType      string
+	Test           bool
+	Status         string
+	Attempts       int
+	ResponseStatus *int
+	Error          *string
+	DurationMs     *int64
+	CreatedAt      time.Time
+	CompletedAt    *time.Time
+}
+
+// CreateWebhookSubscription inserts a new webhook subscription.
+func (d *DB) CreateWebhookSubscription(ctx context.Context, sub *WebhookSubscription) error {
+	if sub.ID == "" {
+		sub.ID = uuid.New().String()
+	}
+	now := time.Now().UTC()
+	sub.CreatedAt = now
+	sub.UpdatedAt = now
+
+	eventTypes, err := json.Marshal(nonNilStrings(sub.EventTypes))
+	if err != nil {
+		return fmt.Errorf("failed to marshal event_types: %w", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, `
+		INSERT INTO webhook_subscriptions (id, name, url, secret, event_types, lab_id, scenario_id, enabled,
+			max_retries, retry_backoff_ms, timeout_ms, created_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, sub.ID, sub.Name, sub.URL, sub.Secret, string(eventTypes), nullIfEmpty(sub.LabID), nullIfEmpty(sub.ScenarioID),
+		sub.Enabled, sub.MaxRetries, sub.RetryBackoff.Milliseconds(), sub.Timeout.Milliseconds(), sub.CreatedBy, now, now)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook subscription: %w", err)
+	}
+
+	d.Audit(ctx, AuditEntityWebhook, sub.ID, AuditActionCreated, nil, webhookAuditValue(sub), nil)
+
+	d.logger.Info().
+		Str("subscription_id", sub.ID).
+		Str("name", sub.Name).
+		Msg("Webhook subscription created")
+
+	return nil
+}
+
+// GetWebhookSubscription retrieves a webhook subscription by ID.
+func (d *DB) GetWebhookSubscription(ctx context.Context, id string) (*WebhookSubscription, error) {
+	row := d.db.QueryRowContext(ctx, `
+		SELECT id, name, url, secret, event_types, lab_id, scenario_id, enabled,
+			max_retries, retry_backoff_ms, timeout_ms, created_by, created_at, updated_at
+		FROM webhook_subscriptions WHERE id = ?
+	`, id)
+
+	return scanWebhookSubscription(row)
+}
+
+// ListWebhookSubscriptions returns all webhook subscriptions ordered by name.
+func (d *DB) ListWebhookSubscriptions(ctx context.Context) ([]*WebhookSubscription, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, name, url, secret, event_types, lab_id, scenario_id, enabled,
+			max_retries, retry_backoff_ms, timeout_ms, created_by, created_at, updated_at
+		FROM webhook_subscriptions
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []*WebhookSubscription
+	for rows.Next() {
+		sub, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+// UpdateWebhookSubscription updates a subscription's mutable fields.
+func (d *DB) UpdateWebhookSubscription(ctx context.Context, sub *WebhookSubscription) error {
+	eventTypes, err := json.Marshal(nonNilStrings(sub.EventTypes))
+	if err != nil {
+		return fmt.Errorf("failed to marshal event_types: %w", err)
+	}
+
+	result, err := d.db.ExecContext(ctx, `
+		UPDATE webhook_subscriptions
+		SET name = ?, url = ?, secret = ?, event_types = ?, lab_id = ?, scenario_id = ?, enabled = ?,
+			max_retries = ?, retry_backoff_ms = ?, timeout_ms = ?
+		WHERE id = ?
+	`, sub.Name, sub.URL, sub.Secret, string(eventTypes), nullIfEmpty(sub.LabID), nullIfEmpty(sub.ScenarioID), sub.Enabled,
+		sub.MaxRetries, sub.RetryBackoff.Milliseconds(), sub.Timeout.Milliseconds(), sub.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update webhook subscription: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("webhook subscription not found: %s", sub.ID)
+	}
+
+	d.Audit(ctx, AuditEntityWebhook, sub.ID, AuditActionUpdated, nil, webhookAuditValue(sub), nil)
+
+	return nil
+}
+
+// DeleteWebhookSubscription removes a subscription and its delivery history.
+func (d *DB) DeleteWebhookSubscription(ctx context.Context, id string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE subscription_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete webhook deliveries: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM webhook_subscriptions WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", err)
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("webhook subscription not found: %s", id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	d.Audit(ctx, AuditEntityWebhook, id, AuditActionDeleted, nil, nil, nil)
+
+	d.logger.Info().Str("subscription_id", id).Msg("Webhook subscription deleted")
+	return nil
+}
+
+// CreateWebhookDelivery records a new delivery and prunes the subscription's
+// history to the most recent WebhookDeliveryHistory entries.
+func (d *DB) CreateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	if delivery.ID == "" {
+		delivery.ID = uuid.New().String()
+	}
+	if delivery.Status == "" {
+		delivery.Status = WebhookDeliveryPending
+	}
+	if delivery.CreatedAt.IsZero() {
+		delivery.CreatedAt = time.Now().UTC()
+	}
+
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (id, subscription_id, event_id, event_type, test, status, attempts,
+			response_status, error, duration_ms, created_at, completed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, delivery.ID, delivery.SubscriptionID, int64(delivery.EventID), delivery.EventType, delivery.Test, delivery.Status,
+		delivery.Attempts, delivery.ResponseStatus, delivery.Error, delivery.DurationMs, delivery.CreatedAt, delivery.CompletedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook delivery: %w", err)
+	}
+
+	_, err = d.db.ExecContext(ctx, `
+		DELETE FROM webhook_deliveries
+		WHERE subscription_id = ? AND id NOT IN (
+			SELECT id FROM webhook_deliveries WHERE subscription_id = ?
+			ORDER BY created_at DESC, rowid DESC LIMIT ?
+		)
+	`, delivery.SubscriptionID, delivery.SubscriptionID, WebhookDeliveryHistory)
+	if err != nil {
+		return fmt.Errorf("failed to prune webhook deliveries: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery.
+func (d *DB) UpdateWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	_, err := d.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, response_status = ?, error = ?, duration_ms = ?, completed_at = ?
+		WHERE id = ?
+	`, delivery.Status, delivery.Attempts, delivery.ResponseStatus, delivery.Error, delivery.DurationMs,
+		delivery.CompletedAt, delivery.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns a subscription's deliveries, newest first.
+func (d *DB) ListWebhookDeliveries(ctx context.Context, subscriptionID string, limit int) ([]*WebhookDelivery, error) {
+	if limit <= 0 {
+		limit = WebhookDeliveryHistory
+	}
+
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, subscription_id, event_id, event_type, test, status, attempts,
+			response_status, error, duration_ms, created_at, completed_at
+		FROM webhook_deliveries
+		WHERE subscription_id = ?
+		ORDER BY created_at DESC, rowid DESC
+		LIMIT ?
+	`, subscriptionID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var eventID int64
+		err := rows.Scan(
+			&delivery.ID, &delivery.SubscriptionID, &eventID, &delivery.EventType, &delivery.Test,
+			&delivery.Status, &delivery.Attempts, &delivery.ResponseStatus, &delivery.Error,
+			&delivery.DurationMs, &delivery.CreatedAt, &delivery.CompletedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		delivery.EventID = uint64(eventID)
+		deliveries = append(deliveries, &delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// webhookAuditValue describes a subscription in the audit log. The secret is
+// never recorded.
+func webhookAuditValue(sub *WebhookSubscription) map[string]interface{} {
+	return map[string]interface{}{
+		"name":             sub.Name,
+		"url":              sub.URL,
+		"event_types":      nonNilStrings(sub.EventTypes),
+		"lab_id":           sub.LabID,
+		"scenario_id":      sub.ScenarioID,
+		"enabled":          sub.Enabled,
+		"max_retries":      sub.MaxRetries,
+		"retry_backoff_ms": sub.RetryBackoff.Milliseconds(),
+		"timeout_ms":       sub.Timeout.Milliseconds(),
+	}
+}
+
+func scanWebhookSubscription(row rowScanner) (*WebhookSubscription, error) {
+	var sub WebhookSubscription
+	var eventTypesJSON string
+	var labID, scenarioID sql.NullString
+	var backoffMs, timeoutMs int64
+
+	err := row.Scan(
+		&sub.ID, &sub.Name, &sub.URL, &sub.Secret, &eventTypesJSON, &labID, &scenarioID, &sub.Enabled,
+		&sub.MaxRetries, &backoffMs, &timeoutMs, &sub.CreatedBy, &sub.CreatedAt, &sub.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan webhook subscription: %w", err)
+	}
+
+	sub.LabID = labID.String
+	sub.ScenarioID = scenarioID.String
+	sub.RetryBackoff = time.Duration(backoffMs) * time.Millisecond
+	sub.Timeout = time.Duration(timeoutMs) * time.Millisecond
+
+	if err := json.Unmarshal([]byte(eventTypesJSON), &sub.EventTypes); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event_types: %w", err)
+	}
+
+	return &sub, nil
+}
@@ -0,0 +1,178 @@
+package timeline
+
+import (
+	"strings"
+	"time"
+
+	"cymbytes.com/cymconductor/pkg/dsl"
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// ECSVersion is the Elastic Common Schema version the documents follow.
+const ECSVersion = "8.11.0"
+
+// ECSDataset is the event.dataset of timeline documents.
+const ECSDataset = "cymconductor.timeline"
+
+// ecsCategory is the ECS event.category and event.type of each action.
+var ecsCategory = map[dsl.ActionType][2][]string{
+	dsl.ActionSimulateBrowsing:         {{"web", "network"}, {"access"}},
+	dsl.ActionSimulateFileActivity:     {{"file"}, {"change"}},
+	dsl.ActionSimulateEmailTraffic:     {{"email"}, {"info"}},
+	dsl.ActionSimulateProcessActivity:  {{"process"}, {"start"}},
+	dsl.ActionEmailReceive:             {{"email"}, {"access"}},
+	dsl.ActionObserveProcessState:      {{"process"}, {"info"}},
+	dsl.ActionObserveFileState:         {{"file"}, {"info"}},
+	dsl.ActionObserveUserState:         {{"iam"}, {"user", "info"}},
+	dsl.ActionCapturePowerShellHistory: {{"process"}, {"info"}},
+}
+
+// ECSDocument returns an entry as an Elastic Common Schema document, ready
+// to be indexed next to the lab's SIEM data. Standard fields carry the host,
+// user and the objects the activity touched; everything else is under
+// "cymconductor", and every document is tagged "simulated".
+func ECSDocument(e protocol.TimelineEntry) map[string]interface{} {
+	category, ok := ecsCategory[dsl.ActionType(e.ActionType)]
+	if !ok {
+		category = [2][]string{{"host"}, {"info"}}
+	}
+	outcome := "success"
+	if e.Status != "completed" {
+		outcome = "failure"
+	}
+
+	event := map[string]interface{}{
+		"kind":     "event",
+		"module":   "cymconductor",
+		"dataset":  ECSDataset,
+		"id":       e.JobID,
+		"action":   e.ActionType,
+		"category": category[0],
+		"type":     category[1],
+		"outcome":  outcome,
+	}
+	if e.StartedAt != nil {
+		event["start"] = e.StartedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if e.CompletedAt != nil {
+		event["end"] = e.CompletedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if e.DurationMs > 0 {
+		event["duration"] = e.DurationMs * int64(time.Millisecond)
+	}
+
+	doc := map[string]interface{}{
+		"@timestamp": entryTime(e).UTC().Format(time.RFC3339Nano),
+		"ecs":        map[string]interface{}{"version": ECSVersion},
+		"event":      event,
+		"tags":       []string{"cymconductor", "simulated"},
+		"labels": map[string]interface{}{
+			"lab_id":      e.LabID,
+			"scenario_id": e.ScenarioID,
+		},
+		"agent": map[string]interface{}{
+			"id":   e.AgentID,
+			"type": "cymconductor-agent",
+		},
+	}
+
+	host := map[string]interface{}{}
+	if e.Hostname != "" {
+		host["name"] = e.Hostname
+		host["hostname"] = e.Hostname
+	}
+	if e.IPAddress != "" {
+		host["ip"] = []string{e.IPAddress}
+	}
+	if e.OS != "" {
+		host["os"] = map[string]interface{}{"type": e.OS}
+	}
+	if len(host) > 0 {
+		doc["host"] = host
+	}
+
+	if e.RunAs != "" {
+		user := map[string]interface{}{"name": e.RunAs}
+		if domain, name, ok := strings.Cut(e.RunAs, `\`); ok {
+			user["domain"] = domain
+			user["name"] = name
+		}
+		doc["user"] = user
+	}
+
+	addActionFields(doc, dsl.ActionType(e.ActionType), e.Parameters)
+
+	custom := map[string]interface{}{
+		"job_id":      e.JobID,
+		"scenario_id": e.ScenarioID,
+		"status":      e.Status,
+	}
+	if e.StepID != "" {
+		custom["step_id"] = e.StepID
+	}
+	if e.LogonType != "" {
+		custom["logon_type"] = e.LogonType
+	}
+	if len(e.Parameters) > 0 {
+		custom["parameters"] = e.Parameters
+	}
+	if len(e.Counters) > 0 {
+		custom["counters"] = e.Counters
+	}
+	doc["cymconductor"] = custom
+
+	if e.Error != "" {
+		doc["error"] = map[string]interface{}{"message": e.Error}
+	}
+
+	return doc
+}
+
+// addActionFields maps the action's key parameters to standard ECS fields.
+// Fields that hold several values (e.g. the URLs of a browsing step) are
+// arrays, which ECS allows for any field.
+func addActionFields(doc map[string]interface{}, action dsl.ActionType, params map[string]interface{}) {
+	set := func(group, field string, value interface{}) {
+		if value == nil || value == "" {
+			return
+		}
+		m, _ := doc[group].(map[string]interface{})
+		if m == nil {
+			m = map[string]interface{}{}
+			doc[group] = m
+		}
+		m[field] = value
+	}
+
+	switch action {
+	case dsl.ActionSimulateBrowsing:
+		set("url", "full", params["urls"])
+	case dsl.ActionSimulateFileActivity:
+		set("file", "directory", params["target_directory"])
+	case dsl.ActionSimulateEmailTraffic:
+		if to := params["recipients"]; to != nil {
+			set("email", "to", map[string]interface{}{"address": to})
+		}
+		set("destination", "address", params["server"])
+	case dsl.ActionSimulateProcessActivity:
+		set("process", "name", params["allowed_processes"])
+	case dsl.ActionEmailReceive:
+		set("email", "subject", params["subject"])
+		if from := params["sender"]; from != nil {
+			set("email", "from", map[string]interface{}{"address": from})
+		}
+		set("destination", "address", params["server"])
+	case dsl.ActionObserveProcessState:
+		set("process", "name", params["process_name"])
+	case dsl.ActionObserveFileState:
+		set("file", "path", params["file_path"])
+	case dsl.ActionObserveUserState:
+		if name := params["username"]; name != nil {
+			target := map[string]interface{}{"name": name}
+			if domain := params["domain"]; domain != nil {
+				target["domain"] = domain
+			}
+			set("user", "target", target)
+		}
+	}
+}
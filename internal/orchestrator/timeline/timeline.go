@@ -0,0 +1,214 @@
+// Package timeline builds the ground-truth record of the activity a scenario
+// generated, so detections written against a lab's SIEM data can be graded
+// against what was simulated.
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/pkg/dsl"
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// keyParameters are the parameters reported for each action: what the
+// activity touched, never credentials or templates.
+var keyParameters = map[dsl.ActionType][]string{
+	dsl.ActionSimulateBrowsing:         {"urls"},
+	dsl.ActionSimulateFileActivity:     {"target_directory", "operations", "file_types", "file_count"},
+	dsl.ActionSimulateEmailTraffic:     {"protocol", "server", "recipients", "actions", "email_count"},
+	dsl.ActionSimulateProcessActivity:  {"allowed_processes", "spawn_count"},
+	dsl.ActionEmailReceive:             {"operation", "server", "folder", "subject", "sender", "save_directory"},
+	dsl.ActionObserveProcessState:      {"process_name", "expected_state"},
+	dsl.ActionObserveFileState:         {"file_path", "expected_state"},
+	dsl.ActionObserveUserState:         {"username", "domain", "expected_state"},
+	dsl.ActionCapturePowerShellHistory: {"command_pattern", "scope"},
+}
+
+// Build returns an entry for every job of the scenario that ran (completed
+// or failed), ordered by start time. A job that ran more than once, after a
+// retry, is reported with its last run.
+func Build(ctx context.Context, db storage.Store, scenarioID string) ([]protocol.TimelineEntry, error) {
+	jobs, err := db.ListJobsByScenario(ctx, scenarioID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scenario jobs: %w", err)
+	}
+
+	agents := make(map[string]*storage.Agent)
+	entries := make([]protocol.TimelineEntry, 0, len(jobs))
+	for _, job := range jobs {
+		if job.Status != storage.JobStatusCompleted && job.Status != storage.JobStatusFailed {
+			continue
+		}
+
+		agent, ok := agents[job.AgentID]
+		if !ok {
+			// A deleted agent leaves only its ID in the entry
+			if agent, err = db.GetAgent(ctx, job.AgentID); err != nil {
+				return nil, fmt.Errorf("failed to get agent %s: %w", job.AgentID, err)
+			}
+			agents[job.AgentID] = agent
+		}
+		entries = append(entries, NewEntry(job, agent))
+	}
+
+	Sort(entries)
+	return entries, nil
+}
+
+// Sort orders entries by start time, falling back to completion time.
+func Sort(entries []protocol.TimelineEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entryTime(entries[i]).Before(entryTime(entries[j]))
+	})
+}
+
+// NewEntry describes a job that ran on agent (nil if unknown).
+func NewEntry(job *storage.Job, agent *storage.Agent) protocol.TimelineEntry {
+	entry := protocol.TimelineEntry{
+		JobID:       job.ID,
+		LabID:       job.LabID,
+		AgentID:     job.AgentID,
+		ActionType:  job.ActionType,
+		Status:      job.Status,
+		StartedAt:   job.StartedAt,
+		CompletedAt: job.CompletedAt,
+	}
+	if job.ScenarioID != nil {
+		entry.ScenarioID = *job.ScenarioID
+	}
+	if job.ScenarioStepID != nil {
+		entry.StepID = *job.ScenarioStepID
+	}
+	if job.RunAsUser != nil {
+		entry.RunAs = *job.RunAsUser
+	}
+	if job.RunAsLogonType != nil {
+		entry.LogonType = *job.RunAsLogonType
+	}
+	if job.ErrorMessage != nil && job.Status == storage.JobStatusFailed {
+		entry.Error = *job.ErrorMessage
+	}
+	if agent != nil {
+		entry.Hostname = agent.Hostname
+		entry.IPAddress = agent.IPAddress
+		entry.OS = agent.Labels["os"]
+	}
+	if job.StartedAt != nil && job.CompletedAt != nil && !job.CompletedAt.Before(*job.StartedAt) {
+		entry.DurationMs = job.CompletedAt.Sub(*job.StartedAt).Milliseconds()
+	}
+
+	entry.Parameters = KeyParameters(job.ActionType, job.Parameters)
+
+	if job.Status == storage.JobStatusCompleted {
+		for key, v := range job.Result {
+			if n, ok := number(v); ok {
+				if entry.Counters == nil {
+					entry.Counters = make(map[string]float64)
+				}
+				entry.Counters[key] = n
+			}
+		}
+	}
+
+	return entry
+}
+
+// KeyParameters returns the parameters of an action that describe what it
+// touched, or nil if it has none.
+func KeyParameters(actionType string, params map[string]interface{}) map[string]interface{} {
+	var key map[string]interface{}
+	for _, name := range keyParameters[dsl.ActionType(actionType)] {
+		if v, ok := params[name]; ok && v != nil && v != "" {
+			if key == nil {
+				key = make(map[string]interface{})
+			}
+			key[name] = v
+		}
+	}
+	return key
+}
+
+// number converts the numeric types a result may carry.
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// entryTime is when the entry's activity started, falling back to when it
+// completed for agents that do not report start times.
+func entryTime(e protocol.TimelineEntry) time.Time {
+	if e.StartedAt != nil {
+		return *e.StartedAt
+	}
+	if e.CompletedAt != nil {
+		return *e.CompletedAt
+	}
+	return time.Time{}
+}
+
+// ============================================================
+// CSV
+// ============================================================
+
+// CSVHeader is the header row of the CSV export.
+var CSVHeader = []string{
+	"started_at", "completed_at", "duration_ms", "hostname", "ip_address", "os", "run_as",
+	"action_type", "status", "parameters", "counters", "error", "agent_id", "job_id", "step_id",
+}
+
+// CSVRecord returns the CSV row for an entry. Parameters and counters are
+// JSON objects.
+func CSVRecord(e protocol.TimelineEntry) []string {
+	return []string{
+		formatTime(e.StartedAt),
+		formatTime(e.CompletedAt),
+		strconv.FormatInt(e.DurationMs, 10),
+		e.Hostname,
+		e.IPAddress,
+		e.OS,
+		e.RunAs,
+		e.ActionType,
+		e.Status,
+		jsonCell(e.Parameters),
+		jsonCell(e.Counters),
+		e.Error,
+		e.AgentID,
+		e.JobID,
+		e.StepID,
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// jsonCell encodes a map for a CSV cell, or "" when empty.
+func jsonCell[V any](m map[string]V) string {
+	if len(m) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -0,0 +1,210 @@
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+	"cymbytes.com/cymconductor/pkg/dsl"
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+func TestBuild(t *testing.T) {
+	ctx := context.Background()
+	db := storage.NewMemory(zerolog.Nop())
+	if err := db.CreateAgent(ctx, &storage.Agent{
+		ID: "agent-1", LabHostID: "host-1", Hostname: "ws-01", IPAddress: "10.0.0.5",
+		Labels: map[string]string{"os": "windows"}, Status: "online",
+	}); err != nil {
+		t.Fatalf("CreateAgent() error = %v", err)
+	}
+
+	scenarioID := "scenario-1"
+	var jobs []*storage.Job
+	for _, id := range []string{"job-browse", "job-email", "job-pending"} {
+		jobs = append(jobs, &storage.Job{
+			ID: id, ScenarioID: &scenarioID, AgentID: "agent-1", ActionType: string(dsl.ActionSimulateBrowsing),
+			Status: storage.JobStatusPending, ScheduledAt: time.Now().UTC(),
+		})
+	}
+	scenario := &storage.Scenario{ID: scenarioID, LabID: "default", Name: "Timeline", Intent: "{}", Source: storage.ScenarioSourceAPI}
+	if err := db.CreateActiveScenario(ctx, scenario, nil, jobs); err != nil {
+		t.Fatalf("CreateActiveScenario() error = %v", err)
+	}
+
+	// The email job started first even though it finished last
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	if err := db.UpdateJobStarted(ctx, "job-browse", base.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateJobCompleted(ctx, "job-browse", base.Add(2*time.Minute), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateJobStarted(ctx, "job-email", base); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateJobFailed(ctx, "job-email", base.Add(3*time.Minute), "connection refused", false); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := Build(ctx, db, scenarioID)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].JobID != "job-email" || entries[1].JobID != "job-browse" {
+		t.Fatalf("Expected the email then the browsing job, got %+v", entries)
+	}
+	if entries[0].Error != "connection refused" || entries[0].ScenarioID != scenarioID {
+		t.Errorf("Unexpected email entry: %+v", entries[0])
+	}
+	if entries[1].Hostname != "ws-01" || entries[1].IPAddress != "10.0.0.5" || entries[1].OS != "windows" {
+		t.Errorf("Expected the agent's host details, got %+v", entries[1])
+	}
+}
+
+func TestNewEntry(t *testing.T) {
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	completed := started.Add(1500 * time.Millisecond)
+	stepID, runAs, errorMsg := "step-1", `CORP\jdoe`, "previous attempt failed"
+
+	job := &storage.Job{
+		ID:             "job-1",
+		AgentID:        "agent-1",
+		ScenarioStepID: &stepID,
+		ActionType:     string(dsl.ActionSimulateEmailTraffic),
+		Parameters:     map[string]interface{}{"server": "mail.corp.local", "recipients": []interface{}{"a@corp.local"}, "password": "secret", "subject": ""},
+		Status:         storage.JobStatusCompleted,
+		RunAsUser:      &runAs,
+		ErrorMessage:   &errorMsg,
+		StartedAt:      &started,
+		CompletedAt:    &completed,
+		Result:         map[string]interface{}{"emails_sent": 3, "bytes": json.Number("2048"), "note": "ok"},
+	}
+
+	entry := NewEntry(job, nil)
+	if entry.StepID != stepID || entry.RunAs != runAs || entry.DurationMs != 1500 || entry.Hostname != "" {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	if entry.Error != "" {
+		t.Errorf("Expected a completed job to carry no error, got %q", entry.Error)
+	}
+	if len(entry.Parameters) != 2 || entry.Parameters["server"] != "mail.corp.local" {
+		t.Errorf("Expected only the server and recipients, got %v", entry.Parameters)
+	}
+	if len(entry.Counters) != 2 || entry.Counters["emails_sent"] != 3 || entry.Counters["bytes"] != 2048 {
+		t.Errorf("Expected the numeric results as counters, got %v", entry.Counters)
+	}
+
+	// A failed job reports its error and no counters
+	job.Status = storage.JobStatusFailed
+	entry = NewEntry(job, nil)
+	if entry.Error != errorMsg || entry.Counters != nil {
+		t.Errorf("Expected the error without counters, got %+v", entry)
+	}
+}
+
+func TestKeyParameters(t *testing.T) {
+	if got := KeyParameters("unknown_action", map[string]interface{}{"urls": "x"}); got != nil {
+		t.Errorf("Expected nil for an unknown action, got %v", got)
+	}
+	if got := KeyParameters(string(dsl.ActionSimulateBrowsing), map[string]interface{}{"timeout": 5}); got != nil {
+		t.Errorf("Expected nil when no key parameters are set, got %v", got)
+	}
+}
+
+func TestSort(t *testing.T) {
+	at := func(minutes int) *time.Time {
+		ts := time.Date(2026, 1, 1, 0, minutes, 0, 0, time.UTC)
+		return &ts
+	}
+	entries := []protocol.TimelineEntry{
+		{JobID: "started-2", StartedAt: at(2)},
+		{JobID: "completed-1", CompletedAt: at(1)},
+		{JobID: "started-0", StartedAt: at(0), CompletedAt: at(5)},
+	}
+	Sort(entries)
+	if entries[0].JobID != "started-0" || entries[1].JobID != "completed-1" || entries[2].JobID != "started-2" {
+		t.Errorf("Unexpected order: %+v", entries)
+	}
+}
+
+func TestCSVRecord(t *testing.T) {
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	record := CSVRecord(protocol.TimelineEntry{
+		JobID:      "job-1",
+		ActionType: string(dsl.ActionSimulateBrowsing),
+		Status:     "completed",
+		StartedAt:  &started,
+		DurationMs: 250,
+		Parameters: map[string]interface{}{"urls": []interface{}{"https://a"}},
+	})
+
+	if len(record) != len(CSVHeader) {
+		t.Fatalf("Expected %d columns, got %d", len(CSVHeader), len(record))
+	}
+	want := map[int]string{
+		0:  "2026-01-02T02:04:05Z",
+		1:  "",
+		2:  "250",
+		9:  `{"urls":["https://a"]}`,
+		10: "",
+		13: "job-1",
+	}
+	for i, v := range want {
+		if record[i] != v {
+			t.Errorf("Expected %s %q, got %q", CSVHeader[i], v, record[i])
+		}
+	}
+}
+
+func TestECSDocument(t *testing.T) {
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	doc := ECSDocument(protocol.TimelineEntry{
+		JobID:      "job-1",
+		ScenarioID: "scenario-1",
+		ActionType: string(dsl.ActionSimulateBrowsing),
+		Status:     "completed",
+		RunAs:      `CORP\jdoe`,
+		Hostname:   "ws-01",
+		StartedAt:  &started,
+		DurationMs: 1500,
+		Parameters: map[string]interface{}{"urls": []interface{}{"https://intranet.corp.local"}},
+	})
+
+	event, _ := doc["event"].(map[string]interface{})
+	if event["outcome"] != "success" || event["dataset"] != ECSDataset || event["duration"] != int64(1500*time.Millisecond) {
+		t.Errorf("Unexpected event: %v", event)
+	}
+	if doc["@timestamp"] != "2026-01-02T03:04:05Z" {
+		t.Errorf("Expected the start time as the timestamp, got %v", doc["@timestamp"])
+	}
+	user, _ := doc["user"].(map[string]interface{})
+	if user["name"] != "jdoe" || user["domain"] != "CORP" {
+		t.Errorf("Expected the domain split from the user, got %v", user)
+	}
+	url, _ := doc["url"].(map[string]interface{})
+	if url["full"] == nil {
+		t.Errorf("Expected the URLs in url.full, got %v", doc["url"])
+	}
+	host, _ := doc["host"].(map[string]interface{})
+	if host["name"] != "ws-01" {
+		t.Errorf("Expected the host name, got %v", host)
+	}
+
+	// Failures and unknown actions still produce a document
+	doc = ECSDocument(protocol.TimelineEntry{JobID: "job-2", ActionType: "unknown", Status: "failed", Error: "boom"})
+	event, _ = doc["event"].(map[string]interface{})
+	if event["outcome"] != "failure" || event["category"].([]string)[0] != "host" {
+		t.Errorf("Unexpected event: %v", event)
+	}
+	if _, ok := doc["host"]; ok {
+		t.Errorf("Expected no host without host details, got %v", doc["host"])
+	}
+	if errField, _ := doc["error"].(map[string]interface{}); errField["message"] != "boom" {
+		t.Errorf("Expected the error message, got %v", doc["error"])
+	}
+}
@@ -4,10 +4,14 @@ package validator
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync/atomic"
 
+	"cymbytes.com/cymconductor/internal/orchestrator/scoring"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
 	"cymbytes.com/cymconductor/pkg/dsl"
 	"github.com/go-playground/validator/v10"
 )
@@ -25,9 +29,32 @@ type ValidationResult struct {
 	Errors []ValidationError `json:"errors,omitempty"`
 }
 
+// Policy holds the operator-configured rules applied on top of the built-in
+// checks. The zero value adds no restrictions.
+type Policy struct {
+	// DeniedActions are action types rejected even though the DSL allows them
+	DeniedActions []dsl.ActionType
+
+	// MaxSteps caps the number of steps in a scenario (0 means no cap)
+	MaxSteps int
+
+	// BlockedPaths are path prefixes rejected in addition to the built-in
+	// sensitive paths (case-insensitive)
+	BlockedPaths []string
+}
+
 // Validator validates DSL scenarios.
 type Validator struct {
 	validate *validator.Validate
+
+	// policy is shared with scoped validators so SetPolicy applies to all
+	policy *atomic.Pointer[Policy]
+
+	// allowedNetworks restricts IP targets to a lab's networks (optional)
+	allowedNetworks []*net.IPNet
+
+	// agents, when set, are checked for one that can run each step
+	agents []*storage.Agent
 }
 
 // New creates a new validator.
@@ -38,14 +65,52 @@ func New() *Validator {
 	_ = v.RegisterValidation("safe_path", validateSafePath)
 	_ = v.RegisterValidation("safe_url", validateSafeURL)
 
+	policy := &atomic.Pointer[Policy]{}
+	policy.Store(&Policy{})
+
 	return &Validator{
 		validate: v,
+		policy:   policy,
+	}
+}
+
+// SetPolicy replaces the policy. It is safe to call while scenarios are being
+// validated, and applies to validators returned by WithAllowedNetworks too.
+func (v *Validator) SetPolicy(policy Policy) {
+	v.policy.Store(&policy)
+}
+
+// Policy returns the current policy.
+func (v *Validator) Policy() Policy {
+	return *v.policy.Load()
+}
+
+// WithAllowedNetworks returns a validator that only accepts IP targets inside
+// the given CIDRs, replacing the default 10.0.0.0/8 lab network rule.
+func (v *Validator) WithAllowedNetworks(cidrs []string) (*Validator, error) {
+	scoped := &Validator{validate: v.validate, policy: v.policy, agents: v.agents}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", cidr, err)
+		}
+		scoped.allowedNetworks = append(scoped.allowedNetworks, network)
 	}
+	return scoped, nil
+}
+
+// WithAgents returns a validator that rejects steps whose target labels match
+// agents of which none can run the step's action.
+func (v *Validator) WithAgents(agents []*storage.Agent) *Validator {
+	scoped := *v
+	scoped.agents = agents
+	return &scoped
 }
 
 // ValidateScenario validates a complete scenario.
 func (v *Validator) ValidateScenario(scenario *dsl.Scenario) *ValidationResult {
 	result := &ValidationResult{Valid: true}
+	policy := v.policy.Load()
 
 	// 1. Validate schema version
 	if scenario.Schema != dsl.SchemaVersion {
@@ -77,26 +142,42 @@ func (v *Validator) ValidateScenario(scenario *dsl.Scenario) *ValidationResult {
 		result.Errors = append(result.Errors, *err)
 	}
 
-	// 4. Validate each step's action type and parameters
+	// 4. Enforce the policy's step cap
+	if policy.MaxSteps > 0 && len(scenario.Steps) > policy.MaxSteps {
+		result.Valid = false
+		result.Errors = append(result.Errors, ValidationError{
+			Field:   "steps",
+			Rule:    "max_steps",
+			Message: fmt.Sprintf("Scenario has %d steps; policy allows at most %d", len(scenario.Steps), policy.MaxSteps),
+		})
+	}
+
+	// 5. Validate each step's action type and parameters
 	for i, step := range scenario.Steps {
-		stepErrors := v.validateStep(&step, i)
+		stepErrors := v.validateStep(&step, i, policy)
 		if len(stepErrors) > 0 {
 			result.Valid = false
 			result.Errors = append(result.Errors, stepErrors...)
 		}
 	}
 
-	// 5. Check for duplicate step IDs
+	// 6. Check for duplicate step IDs
 	if err := validateUniqueStepIDs(scenario.Steps); err != nil {
 		result.Valid = false
 		result.Errors = append(result.Errors, *err)
 	}
 
+	// 7. Check objectives reference steps they can grade
+	if objectiveErrors := validateObjectives(scenario); len(objectiveErrors) > 0 {
+		result.Valid = false
+		result.Errors = append(result.Errors, objectiveErrors...)
+	}
+
 	return result
 }
 
 // validateStep validates a single step.
-func (v *Validator) validateStep(step *dsl.Step, index int) []ValidationError {
+func (v *Validator) validateStep(step *dsl.Step, index int, policy *Policy) []ValidationError {
 	var errors []ValidationError
 	prefix := fmt.Sprintf("steps[%d]", index)
 
@@ -109,6 +190,16 @@ func (v *Validator) validateStep(step *dsl.Step, index int) []ValidationError {
 		})
 		return errors // Can't validate parameters for unknown action type
 	}
+	for _, denied := range policy.DeniedActions {
+		if step.ActionType == denied {
+			errors = append(errors, ValidationError{
+				Field:   prefix + ".action_type",
+				Rule:    "denied_action",
+				Message: fmt.Sprintf("Action type '%s' is denied by policy", step.ActionType),
+			})
+			return errors
+		}
+	}
 
 	// Validate target
 	if len(step.Target.Labels) == 0 {
@@ -156,21 +247,25 @@ func (v *Validator) validateStep(step *dsl.Step, index int) []ValidationError {
 	}
 
 	// Additional security validations based on action type
-	securityErrors := v.validateSecurityConstraints(step.ActionType, params, prefix)
+	securityErrors := v.validateSecurityConstraints(step.ActionType, params, prefix, policy)
 	errors = append(errors, securityErrors...)
 
+	if err := v.checkCapableAgents(step, prefix); err != nil {
+		errors = append(errors, *err)
+	}
+
 	return errors
 }
 
 // validateSecurityConstraints performs security-specific validations.
-func (v *Validator) validateSecurityConstraints(actionType dsl.ActionType, params interface{}, prefix string) []ValidationError {
+func (v *Validator) validateSecurityConstraints(actionType dsl.ActionType, params interface{}, prefix string, policy *Policy) []ValidationError {
 	var errors []ValidationError
 
 	switch actionType {
 	case dsl.ActionSimulateBrowsing:
 		p := params.(*dsl.SimulateBrowsingParams)
 		for i, urlStr := range p.URLs {
-			if err := validateURLSecurity(urlStr); err != nil {
+			if err := v.checkURL(urlStr); err != nil {
 				errors = append(errors, ValidationError{
 					Field:   fmt.Sprintf("%s.parameters.urls[%d]", prefix, i),
 					Rule:    "secure_url",
@@ -181,7 +276,7 @@ func (v *Validator) validateSecurityConstraints(actionType dsl.ActionType, param
 
 	case dsl.ActionSimulateFileActivity:
 		p := params.(*dsl.SimulateFileActivityParams)
-		if err := validatePathSecurity(p.TargetDirectory); err != nil {
+		if err := validatePathSecurity(p.TargetDirectory, policy.BlockedPaths); err != nil {
 			errors = append(errors, ValidationError{
 				Field:   prefix + ".parameters.target_directory",
 				Rule:    "secure_path",
@@ -213,11 +308,193 @@ func (v *Validator) validateSecurityConstraints(actionType dsl.ActionType, param
 				Message: "Cannot use public email servers in lab scenarios",
 			})
 		}
+		if err := v.checkNetwork(p.Server); err != nil {
+			errors = append(errors, ValidationError{
+				Field:   prefix + ".parameters.server",
+				Rule:    "lab_network",
+				Message: err.Error(),
+			})
+		}
+
+	case dsl.ActionEmailReceive:
+		p := params.(*dsl.EmailReceiveParams)
+		if p.Server != "" {
+			if isPublicEmailServer(p.Server) {
+				errors = append(errors, ValidationError{
+					Field:   prefix + ".parameters.server",
+					Rule:    "no_public_server",
+					Message: "Cannot use public email servers in lab scenarios",
+				})
+			}
+			if err := v.checkNetwork(p.Server); err != nil {
+				errors = append(errors, ValidationError{
+					Field:   prefix + ".parameters.server",
+					Rule:    "lab_network",
+					Message: err.Error(),
+				})
+			}
+		}
+		// Attachments are written to disk for extract and execute
+		if p.Operation == "extract" || p.Operation == "execute" {
+			if p.SaveDirectory == "" {
+				errors = append(errors, ValidationError{
+					Field:   prefix + ".parameters.save_directory",
+					Rule:    "required",
+					Message: fmt.Sprintf("save_directory is required for the %s operation", p.Operation),
+				})
+			} else if err := validatePathSecurity(p.SaveDirectory, policy.BlockedPaths); err != nil {
+				errors = append(errors, ValidationError{
+					Field:   prefix + ".parameters.save_directory",
+					Rule:    "secure_path",
+					Message: err.Error(),
+				})
+			}
+		}
+
+	case dsl.ActionObserveProcessState:
+		p := params.(*dsl.ObserveProcessStateParams)
+		// The name is passed to tasklist/ps as a filter
+		if !safeProcessName.MatchString(p.ProcessName) {
+			errors = append(errors, ValidationError{
+				Field:   prefix + ".parameters.process_name",
+				Rule:    "safe_name",
+				Message: "Process name may only contain letters, digits, spaces, '.', '_' and '-'",
+			})
+		}
+
+	case dsl.ActionObserveFileState:
+		p := params.(*dsl.ObserveFileStateParams)
+		// Observations only stat and hash the file, so system paths may be
+		// checked (e.g. that a dropped binary was removed from C:\Windows\Temp);
+		// only the policy's blocked paths apply.
+		if err := validateObservedPath(p.FilePath, policy.BlockedPaths); err != nil {
+			errors = append(errors, ValidationError{
+				Field:   prefix + ".parameters.file_path",
+				Rule:    "secure_path",
+				Message: err.Error(),
+			})
+		}
+
+	case dsl.ActionObserveUserState:
+		p := params.(*dsl.ObserveUserStateParams)
+		// Both values are quoted into a Get-ADUser PowerShell command
+		if !safeAccountName.MatchString(p.Username) {
+			errors = append(errors, ValidationError{
+				Field:   prefix + ".parameters.username",
+				Rule:    "safe_name",
+				Message: "Username may only contain letters, digits, '.', '_', '-' and '@'",
+			})
+		}
+		if p.Domain != "" && !safeDomainName.MatchString(p.Domain) {
+			errors = append(errors, ValidationError{
+				Field:   prefix + ".parameters.domain",
+				Rule:    "safe_name",
+				Message: "Domain may only contain letters, digits, '.' and '-'",
+			})
+		}
+
+	case dsl.ActionCapturePowerShellHistory:
+		p := params.(*dsl.CapturePowerShellHistoryParams)
+		if p.CommandPattern != "" {
+			if _, err := regexp.Compile(p.CommandPattern); err != nil {
+				errors = append(errors, ValidationError{
+					Field:   prefix + ".parameters.command_pattern",
+					Rule:    "valid_regex",
+					Message: fmt.Sprintf("Invalid regular expression: %v", err),
+				})
+			}
+		}
 	}
 
 	return errors
 }
 
+// Names that observation actions pass to host commands.
+var (
+	safeProcessName = regexp.MustCompile(`^[A-Za-z0-9._\- ]+$`)
+	safeAccountName = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+	safeDomainName  = regexp.MustCompile(`^[A-Za-z0-9.-]+$`)
+)
+
+// checkCapableAgents reports a step whose target labels match agents that
+// all lack the step's action (or the backend it selects). Steps matching no
+// agents are left to the compiler, since agents may come online later.
+func (v *Validator) checkCapableAgents(step *dsl.Step, prefix string) *ValidationError {
+	if v.agents == nil {
+		return nil
+	}
+
+	var options struct {
+		Backend string `json:"backend"`
+	}
+	_ = json.Unmarshal(step.Parameters, &options)
+
+	var reasons []string
+	for _, agent := range v.agents {
+		if !agent.MatchesLabels(step.Target.Labels) {
+			continue
+		}
+		err := agent.CanRun(string(step.ActionType), options.Backend)
+		if err == nil {
+			return nil
+		}
+		reasons = append(reasons, fmt.Sprintf("%s: %v", agent.LabHostID, err))
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	return &ValidationError{
+		Field:   prefix + ".action_type",
+		Rule:    "capable_agent",
+		Message: fmt.Sprintf("No agent matching the target labels can run %s (%s)", step.ActionType, strings.Join(reasons, "; ")),
+	}
+}
+
+// checkURL validates a URL against the default rules, or against the lab's
+// allowed networks when configured.
+func (v *Validator) checkURL(urlStr string) error {
+	if len(v.allowedNetworks) == 0 {
+		return validateURLSecurity(urlStr)
+	}
+
+	parsed, err := url.Parse(urlStr)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("URL scheme must be http or https")
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	if host == "localhost" || host == "127.0.0.1" {
+		return fmt.Errorf("localhost URLs are not allowed")
+	}
+
+	return v.checkNetwork(host)
+}
+
+// checkNetwork rejects IP hosts outside the lab's allowed networks.
+// Hostnames and validators without allowed networks are not restricted.
+func (v *Validator) checkNetwork(host string) error {
+	if len(v.allowedNetworks) == 0 {
+		return nil
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	for _, network := range v.allowedNetworks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is outside the lab's allowed networks", host)
+}
+
 // ValidateScenarioJSON validates a scenario from raw JSON.
 func (v *Validator) ValidateScenarioJSON(jsonData []byte) (*dsl.Scenario, *ValidationResult) {
 	var scenario dsl.Scenario
@@ -276,6 +553,79 @@ func validateUniqueStepIDs(steps []dsl.Step) *ValidationError {
 	return nil
 }
 
+// validateObjectives checks that objective IDs are unique, that every
+// referenced step exists, and that each step's results have an assertion to
+// grade them: the objective's own (which must parse) or the action's default.
+func validateObjectives(scenario *dsl.Scenario) []ValidationError {
+	var errors []ValidationError
+
+	actions := make(map[string]dsl.ActionType, len(scenario.Steps))
+	for _, step := range scenario.Steps {
+		actions[step.ID] = step.ActionType
+	}
+
+	seen := make(map[string]int)
+	for i, o := range scenario.Objectives {
+		prefix := fmt.Sprintf("objectives[%d]", i)
+
+		if prev, ok := seen[o.ID]; ok {
+			errors = append(errors, ValidationError{
+				Field:   prefix + ".id",
+				Rule:    "unique_id",
+				Message: fmt.Sprintf("Duplicate objective ID: %s (also at objective %d)", o.ID, prev),
+			})
+		}
+		seen[o.ID] = i
+
+		for j, expr := range o.Assert {
+			if err := scoring.ValidatePredicate(expr); err != nil {
+				errors = append(errors, ValidationError{
+					Field:   fmt.Sprintf("%s.assert[%d]", prefix, j),
+					Rule:    "valid_assertion",
+					Message: err.Error(),
+				})
+			}
+		}
+
+		steps := make(map[string]bool)
+		for j, stepID := range o.StepIDs {
+			field := fmt.Sprintf("%s.step_ids[%d]", prefix, j)
+			action, ok := actions[stepID]
+			switch {
+			case !ok:
+				errors = append(errors, ValidationError{
+					Field:   field,
+					Rule:    "step_exists",
+					Message: fmt.Sprintf("Step %s does not exist", stepID),
+				})
+			case steps[stepID]:
+				errors = append(errors, ValidationError{
+					Field:   field,
+					Rule:    "unique_step",
+					Message: fmt.Sprintf("Step %s is listed more than once", stepID),
+				})
+			case len(o.Assert) == 0 && dsl.DefaultObjectiveAssertion(action) == "":
+				errors = append(errors, ValidationError{
+					Field:   field,
+					Rule:    "assertion_required",
+					Message: fmt.Sprintf("Step %s (%s) has no default assertion; set assert", stepID, action),
+				})
+			}
+			steps[stepID] = true
+		}
+
+		if o.RetryIntervalSeconds > 0 && o.DeadlineSeconds == 0 {
+			errors = append(errors, ValidationError{
+				Field:   prefix + ".deadline_seconds",
+				Rule:    "required_with",
+				Message: "deadline_seconds is required when retry_interval_seconds is set",
+			})
+		}
+	}
+
+	return errors
+}
+
 func validateURLSecurity(urlStr string) error {
 	parsed, err := url.Parse(urlStr)
 	if err != nil {
@@ -306,15 +656,9 @@ func validateURLSecurity(urlStr string) error {
 	return nil
 }
 
-func validatePathSecurity(path string) error {
-	// Check for path traversal
-	if strings.Contains(path, "..") {
-		return fmt.Errorf("path traversal not allowed")
-	}
-
-	// Check for shell metacharacters
-	if containsShellMetachars(path) {
-		return fmt.Errorf("path contains shell metacharacters")
+func validatePathSecurity(path string, blocked []string) error {
+	if err := checkPathSyntax(path); err != nil {
+		return err
 	}
 
 	// Block sensitive paths
@@ -322,13 +666,42 @@ func validatePathSecurity(path string) error {
 		"/etc", "/var", "/usr", "/bin", "/sbin", "/root",
 		"C:\\Windows", "C:\\Program Files",
 	}
-	lowerPath := strings.ToLower(path)
-	for _, sensitive := range sensitivePaths {
-		if strings.HasPrefix(lowerPath, strings.ToLower(sensitive)) {
-			return fmt.Errorf("access to %s is not allowed", sensitive)
-		}
+	return checkBlockedPaths(path, append(sensitivePaths, blocked...))
+}
+
+// validateObservedPath checks a path that is only inspected, never written:
+// only the policy's blocked paths apply.
+func validateObservedPath(path string, blocked []string) error {
+	if err := checkPathSyntax(path); err != nil {
+		return err
 	}
+	return checkBlockedPaths(path, blocked)
+}
 
+// checkPathSyntax rejects path traversal and shell metacharacters. Backslashes
+// are Windows path separators, not escapes, and are allowed.
+func checkPathSyntax(path string) error {
+	if strings.Contains(path, "..") {
+		return fmt.Errorf("path traversal not allowed")
+	}
+	if containsShellMetachars(strings.ReplaceAll(path, "\\", "/")) {
+		return fmt.Errorf("path contains shell metacharacters")
+	}
+	return nil
+}
+
+// checkBlockedPaths rejects a path under any of the given prefixes, comparing
+// case-insensitively with either separator.
+func checkBlockedPaths(path string, blocked []string) error {
+	normalize := func(p string) string {
+		return strings.ToLower(strings.ReplaceAll(p, "\\", "/"))
+	}
+	normalized := normalize(path)
+	for _, prefix := range blocked {
+		if strings.HasPrefix(normalized, normalize(prefix)) {
+			return fmt.Errorf("access to %s is not allowed", prefix)
+		}
+	}
 	return nil
 }
 
@@ -352,7 +725,7 @@ func isPublicEmailServer(server string) bool {
 }
 
 func validateSafePath(fl validator.FieldLevel) bool {
-	return validatePathSecurity(fl.Field().String()) == nil
+	return validatePathSecurity(fl.Field().String(), nil) == nil
 }
 
 func validateSafeURL(fl validator.FieldLevel) bool {
@@ -373,6 +746,12 @@ func formatValidationError(e validator.FieldError) string {
 		return fmt.Sprintf("%s must be a valid URL", e.Field())
 	case "uuid4":
 		return fmt.Sprintf("%s must be a valid UUID v4", e.Field())
+	case "len":
+		return fmt.Sprintf("%s must be exactly %s characters", e.Field(), e.Param())
+	case "hexadecimal":
+		return fmt.Sprintf("%s must be hexadecimal", e.Field())
+	case "datetime":
+		return fmt.Sprintf("%s must be an RFC 3339 time (e.g. 2024-01-02T15:04:05Z)", e.Field())
 	default:
 		return fmt.Sprintf("%s failed validation: %s", e.Field(), e.Tag())
 	}
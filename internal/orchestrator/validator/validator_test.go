@@ -0,0 +1,78 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"cymbytes.com/cymconductor/pkg/dsl"
+)
+
+func TestValidateScenario_ObservationAndEvidenceSteps(t *testing.T) {
+	step := func(order int, action dsl.ActionType, params string) dsl.Step {
+		return dsl.Step{
+			ID:         fmt.Sprintf("550e8400-e29b-41d4-a716-4466554400%02d", order),
+			Order:      order,
+			ActionType: action,
+			Target:     dsl.Target{Labels: map[string]string{"os": "windows"}, Count: "any"},
+			Parameters: json.RawMessage(params),
+		}
+	}
+	scenario := func(steps ...dsl.Step) *dsl.Scenario {
+		return &dsl.Scenario{
+			Schema:   dsl.SchemaVersion,
+			ID:       "550e8400-e29b-41d4-a716-446655440000",
+			Name:     "Verify response",
+			Version:  1,
+			Steps:    steps,
+			Schedule: dsl.Schedule{Type: "immediate"},
+		}
+	}
+
+	val := New()
+	result := val.ValidateScenario(scenario(
+		step(1, dsl.ActionEmailReceive, `{"operation": "extract", "subject": "Invoice", "since": "2024-01-02T09:00:00Z", "save_directory": "C:\\Users\\jsmith\\Downloads"}`),
+		step(2, dsl.ActionObserveProcessState, `{"process_name": "updater.exe", "expected_state": "not_running"}`),
+		step(3, dsl.ActionObserveFileState, `{"file_path": "C:\\Windows\\Temp\\updater.exe", "expected_hash": "`+strings.Repeat("ab", 32)+`"}`),
+		step(4, dsl.ActionObserveUserState, `{"username": "jsmith", "domain": "dc01.lab.local", "expected_state": "disabled"}`),
+		step(5, dsl.ActionCapturePowerShellHistory, `{"command_pattern": "(?i)Stop-Process", "scope": "all_users"}`),
+	))
+	if !result.Valid {
+		t.Fatalf("Expected the verification steps to be valid, got %+v", result.Errors)
+	}
+
+	result = val.ValidateScenario(scenario(
+		step(1, dsl.ActionEmailReceive, `{"operation": "execute", "server": "smtp.gmail.com", "since": "yesterday"}`),
+		step(2, dsl.ActionObserveProcessState, `{"process_name": "x.exe\" & calc", "expected_state": "stopped"}`),
+		step(3, dsl.ActionObserveFileState, `{"file_path": "C:\\Temp\\..\\x", "expected_hash": "abc"}`),
+		step(4, dsl.ActionObserveUserState, `{"username": "jsmith'; Remove-ADUser x; '"}`),
+		step(5, dsl.ActionCapturePowerShellHistory, `{"command_pattern": "(unclosed"}`),
+	))
+	rules := map[string]bool{}
+	for _, e := range result.Errors {
+		rules[e.Field+" "+e.Rule] = true
+	}
+	for _, want := range []string{
+		"steps[0].parameters.Since datetime",
+		"steps[0].parameters.server no_public_server",
+		"steps[0].parameters.save_directory required",
+		"steps[1].parameters.ExpectedState oneof",
+		"steps[1].parameters.process_name safe_name",
+		"steps[2].parameters.ExpectedHash len",
+		"steps[2].parameters.file_path secure_path",
+		"steps[3].parameters.username safe_name",
+		"steps[4].parameters.command_pattern valid_regex",
+	} {
+		if !rules[want] {
+			t.Errorf("Expected error %q, got %v", want, rules)
+		}
+	}
+
+	// Policy blocked paths still apply to observed files
+	val.SetPolicy(Policy{BlockedPaths: []string{"C:\\Secrets"}})
+	result = val.ValidateScenario(scenario(step(1, dsl.ActionObserveFileState, `{"file_path": "c:/secrets/keys.txt"}`)))
+	if result.Valid {
+		t.Error("Expected a blocked path to be rejected for observe_file_state")
+	}
+}
@@ -4,22 +4,25 @@ package webhooks
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
 )
 
 // Forwarder forwards events to webhook endpoints (e.g., messenger service).
 type Forwarder struct {
-	messengerURL string
-	httpClient   *http.Client
-	logger       zerolog.Logger
-	enabled      bool
-	retryCount   int
-	retryDelay   time.Duration
+	targetResolver TargetResolver
+	mu             sync.RWMutex
+	config         Config
+	httpClient     *http.Client
+	logger         zerolog.Logger
+	metrics        *metrics.Metrics
 }
 
 // Config holds webhook forwarder configuration.
@@ -40,6 +43,9 @@ type Config struct {
 	Timeout time.Duration
 }
 
+// TargetResolver returns the webhook URL for a lab, or "" to use the default.
+type TargetResolver func(ctx context.Context, labID string) string
+
 // DefaultConfig returns sensible defaults.
 func DefaultConfig() Config {
 	return Config{
@@ -54,21 +60,24 @@ func DefaultConfig() Config {
 // NewForwarder creates a new webhook forwarder.
 func NewForwarder(cfg Config, logger zerolog.Logger) *Forwarder {
 	return &Forwarder{
-		messengerURL: cfg.MessengerURL,
+		config: cfg,
 		httpClient: &http.Client{
 			Timeout: cfg.Timeout,
 		},
-		logger:     logger.With().Str("component", "webhook_forwarder").Logger(),
-		enabled:    cfg.Enabled,
-		retryCount: cfg.RetryCount,
-		retryDelay: cfg.RetryDelay,
+		logger: logger.With().Str("component", "webhook_forwarder").Logger(),
 	}
 }
 
+// SetTargetResolver configures per-lab webhook targets.
+func (f *Forwarder) SetTargetResolver(resolver TargetResolver) {
+	f.targetResolver = resolver
+}
+
 // WebhookEvent is the event payload sent to webhook endpoints.
 type WebhookEvent struct {
 	EventType  string                 `json:"event_type"`
 	EventID    string                 `json:"event_id"`
+	LabID      string                 `json:"lab_id,omitempty"`
 	ScenarioID string                 `json:"scenario_id"`
 	Timestamp  time.Time              `json:"timestamp"`
 	Source     string                 `json:"source"`
@@ -79,6 +88,7 @@ type WebhookEvent struct {
 type JobInfo struct {
 	JobID       string
 	AgentID     string
+	LabID       string
 	ActionType  string
 	Parameters  map[string]interface{}
 	ScenarioID  string
@@ -95,13 +105,10 @@ type JobResult struct {
 	Error       string
 }
 
-// ForwardJobCompleted sends a job completion event to the messenger.
-func (f *Forwarder) ForwardJobCompleted(ctx context.Context, job *JobInfo, result *JobResult) error {
-	if !f.enabled {
-		f.logger.Debug().Msg("Webhook forwarder disabled, skipping")
-		return nil
-	}
-
+// NewJobEvent builds the messenger event for a job result. eventID must be
+// stable for the result so the messenger can discard duplicates when a
+// delivery is retried.
+func NewJobEvent(eventID string, job *JobInfo, result *JobResult) WebhookEvent {
 	eventType := "job.completed"
 	if result.Status == "failed" {
 		eventType = "job.failed"
@@ -109,7 +116,8 @@ func (f *Forwarder) ForwardJobCompleted(ctx context.Context, job *JobInfo, resul
 
 	event := WebhookEvent{
 		EventType:  eventType,
-		EventID:    fmt.Sprintf("job-%s-%d", job.JobID, time.Now().UnixNano()),
+		EventID:    eventID,
+		LabID:      job.LabID,
 		ScenarioID: job.ScenarioID,
 		Timestamp:  result.CompletedAt,
 		Source:     "orchestrator",
@@ -133,21 +141,19 @@ func (f *Forwarder) ForwardJobCompleted(ctx context.Context, job *JobInfo, resul
 		event.Payload["error"] = result.Error
 	}
 
-	return f.sendEvent(ctx, event)
+	return event
 }
 
-// ForwardScenarioCompleted sends a scenario completion event to the messenger.
-func (f *Forwarder) ForwardScenarioCompleted(ctx context.Context, scenarioID, scenarioName string, completed, failed int) error {
-	if !f.enabled {
-		f.logger.Debug().Msg("Webhook forwarder disabled, skipping")
-		return nil
-	}
-
-	event := WebhookEvent{
+// NewScenarioCompletedEvent builds the messenger event for a completed
+// scenario. A scenario completes once, so its event ID is derived from the
+// scenario ID.
+func NewScenarioCompletedEvent(labID, scenarioID, scenarioName string, completed, failed int) WebhookEvent {
+	return WebhookEvent{
 		EventType:  "scenario.completed",
-		EventID:    fmt.Sprintf("scenario-%s-%d", scenarioID, time.Now().UnixNano()),
+		EventID:    fmt.Sprintf("scenario-%s-completed", scenarioID),
+		LabID:      labID,
 		ScenarioID: scenarioID,
-		Timestamp:  time.Now(),
+		Timestamp:  time.Now().UTC(),
 		Source:     "orchestrator",
 		Payload: map[string]interface{}{
 			"scenario_id":    scenarioID,
@@ -157,22 +163,17 @@ func (f *Forwarder) ForwardScenarioCompleted(ctx context.Context, scenarioID, sc
 			"status":         "completed",
 		},
 	}
-
-	return f.sendEvent(ctx, event)
 }
 
-// ForwardScenarioStarted sends a scenario start event to the messenger.
-func (f *Forwarder) ForwardScenarioStarted(ctx context.Context, scenarioID, scenarioName string, totalJobs int) error {
-	if !f.enabled {
-		f.logger.Debug().Msg("Webhook forwarder disabled, skipping")
-		return nil
-	}
-
-	event := WebhookEvent{
+// NewScenarioStartedEvent builds the messenger event for a started scenario.
+// A scenario starts once, so its event ID is derived from the scenario ID.
+func NewScenarioStartedEvent(labID, scenarioID, scenarioName string, totalJobs int) WebhookEvent {
+	return WebhookEvent{
 		EventType:  "scenario.started",
-		EventID:    fmt.Sprintf("scenario-%s-%d", scenarioID, time.Now().UnixNano()),
+		EventID:    fmt.Sprintf("scenario-%s-started", scenarioID),
+		LabID:      labID,
 		ScenarioID: scenarioID,
-		Timestamp:  time.Now(),
+		Timestamp:  time.Now().UTC(),
 		Source:     "orchestrator",
 		Payload: map[string]interface{}{
 			"scenario_id":   scenarioID,
@@ -181,51 +182,60 @@ func (f *Forwarder) ForwardScenarioStarted(ctx context.Context, scenarioID, scen
 			"status":        "active",
 		},
 	}
+}
 
-	return f.sendEvent(ctx, event)
+// Deliver sends an outbox entry to the messenger endpoint of the lab named by
+// its destination, retrying according to the configuration.
+func (f *Forwarder) Deliver(ctx context.Context, entry *storage.OutboxEntry) error {
+	return f.sendEvent(ctx, entry.Destination, entry.EventID, entry.EventType, entry.Payload)
 }
 
 // sendEvent sends an event to the messenger webhook endpoint with retries.
-func (f *Forwarder) sendEvent(ctx context.Context, event WebhookEvent) error {
-	body, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("failed to marshal event: %w", err)
+func (f *Forwarder) sendEvent(ctx context.Context, labID, eventID, eventType string, body []byte) error {
+	cfg, client := f.settings()
+
+	target := cfg.MessengerURL
+	if f.targetResolver != nil {
+		if labTarget := f.targetResolver(ctx, labID); labTarget != "" {
+			target = labTarget
+		}
 	}
 
 	var lastErr error
-	for attempt := 0; attempt <= f.retryCount; attempt++ {
+	for attempt := 0; attempt <= cfg.RetryCount; attempt++ {
 		if attempt > 0 {
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case <-time.After(f.retryDelay):
+			case <-time.After(cfg.RetryDelay):
 			}
+			f.metrics.ForwarderRetried(metrics.ForwarderMessenger)
 		}
 
-		req, err := http.NewRequestWithContext(ctx, "POST", f.messengerURL, bytes.NewReader(body))
+		req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(body))
 		if err != nil {
 			lastErr = fmt.Errorf("failed to create request: %w", err)
 			continue
 		}
 		req.Header.Set("Content-Type", "application/json")
 
-		resp, err := f.httpClient.Do(req)
+		resp, err := client.Do(req)
 		if err != nil {
 			lastErr = fmt.Errorf("request failed: %w", err)
 			f.logger.Warn().
 				Err(err).
 				Int("attempt", attempt+1).
-				Str("event_type", event.EventType).
+				Str("event_type", eventType).
 				Msg("Failed to forward webhook, retrying")
 			continue
 		}
-		defer resp.Body.Close()
+		resp.Body.Close()
 
 		if resp.StatusCode < 300 {
 			f.logger.Info().
-				Str("event_id", event.EventID).
-				Str("event_type", event.EventType).
-				Str("scenario_id", event.ScenarioID).
+				Str("event_id", eventID).
+				Str("event_type", eventType).
+				Str("lab_id", labID).
 				Int("status_code", resp.StatusCode).
 				Msg("Webhook forwarded to messenger")
 			return nil
@@ -235,20 +245,36 @@ func (f *Forwarder) sendEvent(ctx context.Context, event WebhookEvent) error {
 		f.logger.Warn().
 			Int("status_code", resp.StatusCode).
 			Int("attempt", attempt+1).
-			Str("event_type", event.EventType).
+			Str("event_type", eventType).
 			Msg("Messenger returned error, retrying")
 	}
 
-	f.logger.Error().
-		Err(lastErr).
-		Str("event_id", event.EventID).
-		Str("event_type", event.EventType).
-		Msg("Failed to forward webhook after all retries")
+	return fmt.Errorf("failed to forward webhook after %d attempts: %w", cfg.RetryCount+1, lastErr)
+}
 
-	return fmt.Errorf("failed to forward webhook after %d attempts: %w", f.retryCount+1, lastErr)
+// SetMetrics sets the metrics that deliveries and retries are recorded in.
+func (f *Forwarder) SetMetrics(m *metrics.Metrics) {
+	f.metrics = m
 }
 
 // IsEnabled returns whether the forwarder is enabled.
 func (f *Forwarder) IsEnabled() bool {
-	return f.enabled
+	cfg, _ := f.settings()
+	return cfg.Enabled
+}
+
+// UpdateConfig applies a new configuration. Deliveries already in progress
+// finish with the settings they started with.
+func (f *Forwarder) UpdateConfig(cfg Config) {
+	f.mu.Lock()
+	f.config = cfg
+	f.httpClient = &http.Client{Timeout: cfg.Timeout}
+	f.mu.Unlock()
+}
+
+// settings returns the current configuration and HTTP client.
+func (f *Forwarder) settings() (Config, *http.Client) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.config, f.httpClient
 }
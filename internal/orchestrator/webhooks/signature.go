@@ -0,0 +1,68 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every subscription delivery.
+const (
+	HeaderEvent     = "X-CymConductor-Event"
+	HeaderDelivery  = "X-CymConductor-Delivery"
+	HeaderTimestamp = "X-CymConductor-Timestamp"
+	HeaderSignature = "X-CymConductor-Signature"
+)
+
+// SecretPrefix starts every generated subscription secret.
+const SecretPrefix = "whsec_"
+
+// signaturePrefix identifies the signature algorithm in HeaderSignature.
+const signaturePrefix = "sha256="
+
+// Sign returns the HeaderSignature value for a delivery: an HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the subscription secret. Including the
+// timestamp lets receivers reject replayed deliveries.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature and that its timestamp is within
+// tolerance of now. A zero tolerance skips the timestamp check.
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return errors.New("unsupported signature algorithm")
+	}
+	if !hmac.Equal([]byte(Sign(secret, ts, body)), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+	if tolerance > 0 {
+		age := time.Since(time.Unix(ts, 0))
+		if age > tolerance || age < -tolerance {
+			return errors.New("timestamp outside tolerance")
+		}
+	}
+	return nil
+}
+
+// GenerateSecret creates a new random subscription secret.
+func GenerateSecret() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return SecretPrefix + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,132 @@
+package webhooks
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	const secret = "whsec_test"
+	body := []byte(`{"type":"job.completed"}`)
+	now := time.Now().Unix()
+	stale := time.Now().Add(-10 * time.Minute).Unix()
+	future := time.Now().Add(10 * time.Minute).Unix()
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      []byte
+		tolerance time.Duration
+		wantErr   string
+	}{
+		{
+			name:      "valid",
+			timestamp: strconv.FormatInt(now, 10),
+			signature: Sign(secret, now, body),
+			body:      body,
+			tolerance: time.Minute,
+		},
+		{
+			name:      "wrong secret",
+			timestamp: strconv.FormatInt(now, 10),
+			signature: Sign("whsec_other", now, body),
+			body:      body,
+			tolerance: time.Minute,
+			wantErr:   "signature mismatch",
+		},
+		{
+			name:      "tampered body",
+			timestamp: strconv.FormatInt(now, 10),
+			signature: Sign(secret, now, body),
+			body:      []byte(`{"type":"job.failed"}`),
+			tolerance: time.Minute,
+			wantErr:   "signature mismatch",
+		},
+		{
+			name:      "timestamp does not match signature",
+			timestamp: strconv.FormatInt(now+1, 10),
+			signature: Sign(secret, now, body),
+			body:      body,
+			tolerance: time.Minute,
+			wantErr:   "signature mismatch",
+		},
+		{
+			name:      "invalid timestamp",
+			timestamp: "yesterday",
+			signature: Sign(secret, now, body),
+			body:      body,
+			wantErr:   "invalid timestamp",
+		},
+		{
+			name:      "unsupported algorithm",
+			timestamp: strconv.FormatInt(now, 10),
+			signature: "sha1=" + strings.TrimPrefix(Sign(secret, now, body), "sha256="),
+			body:      body,
+			wantErr:   "unsupported signature algorithm",
+		},
+		{
+			name:      "replayed delivery",
+			timestamp: strconv.FormatInt(stale, 10),
+			signature: Sign(secret, stale, body),
+			body:      body,
+			tolerance: 5 * time.Minute,
+			wantErr:   "timestamp outside tolerance",
+		},
+		{
+			name:      "timestamp in the future",
+			timestamp: strconv.FormatInt(future, 10),
+			signature: Sign(secret, future, body),
+			body:      body,
+			tolerance: 5 * time.Minute,
+			wantErr:   "timestamp outside tolerance",
+		},
+		{
+			name:      "zero tolerance skips the age check",
+			timestamp: strconv.FormatInt(stale, 10),
+			signature: Sign(secret, stale, body),
+			body:      body,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(secret, tt.timestamp, tt.signature, tt.body, tt.tolerance)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Verify() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Verify() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSign_Format(t *testing.T) {
+	sig := Sign("whsec_test", 1700000000, []byte("{}"))
+	if !strings.HasPrefix(sig, "sha256=") || len(sig) != len("sha256=")+64 {
+		t.Errorf("Expected a sha256= prefixed hex digest, got %s", sig)
+	}
+	if Sign("whsec_test", 1700000000, []byte("{}")) != sig {
+		t.Error("Expected signing to be deterministic")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	b, _ := GenerateSecret()
+	if !strings.HasPrefix(a, SecretPrefix) || len(a) != len(SecretPrefix)+48 {
+		t.Errorf("Unexpected secret format: %s", a)
+	}
+	if a == b {
+		t.Error("Expected generated secrets to differ")
+	}
+}
@@ -0,0 +1,379 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+	"cymbytes.com/cymconductor/internal/orchestrator/metrics"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+)
+
+// TestEventType is the event type sent by Dispatcher.Test.
+const TestEventType = "webhook.test"
+
+// Subscription defaults, used when a subscription leaves them unset.
+const (
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = time.Second
+	DefaultTimeout      = 10 * time.Second
+)
+
+// maxRetryBackoff caps the doubling delay between retries.
+const maxRetryBackoff = 5 * time.Minute
+
+// maxConcurrentDeliveries bounds the deliveries in flight across all
+// subscriptions. When it is reached the dispatcher stops reading events until
+// a delivery finishes.
+const maxConcurrentDeliveries = 16
+
+// Dispatcher delivers events from the event bus to webhook subscriptions.
+// Each matching subscription receives the event as JSON, signed with the
+// subscription's secret, and retried according to its retry policy. Every
+// delivery is recorded in the subscription's history. Deliveries run
+// concurrently, so a subscriber may receive events out of order; the event
+// ID increases monotonically and can be used to reorder them.
+type Dispatcher struct {
+	db         storage.WebhookStore
+	bus        *events.Bus
+	httpClient *http.Client
+	logger     zerolog.Logger
+	metrics    *metrics.Metrics
+
+	// Cached subscriptions, refreshed by Reload
+	mu   sync.RWMutex
+	subs []*storage.WebhookSubscription
+
+	slots chan struct{}
+
+	// Background worker
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
+	deliveries sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher for the subscriptions in db.
+func NewDispatcher(db storage.WebhookStore, bus *events.Bus, logger zerolog.Logger) *Dispatcher {
+	return &Dispatcher{
+		db:  db,
+		bus: bus,
+		// Timeouts are per subscription and applied to each request's context
+		httpClient: &http.Client{},
+		logger:     logger.With().Str("component", "webhook_dispatcher").Logger(),
+		slots:      make(chan struct{}, maxConcurrentDeliveries),
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// SetMetrics sets the metrics that deliveries and retries are recorded in.
+func (d *Dispatcher) SetMetrics(m *metrics.Metrics) {
+	d.metrics = m
+}
+
+// Start loads the subscriptions and begins delivering events.
+func (d *Dispatcher) Start(ctx context.Context) error {
+	if err := d.Reload(ctx); err != nil {
+		return err
+	}
+
+	d.mu.RLock()
+	count := len(d.subs)
+	d.mu.RUnlock()
+	d.logger.Info().Int("subscriptions", count).Msg("Starting webhook dispatcher")
+
+	// Subscribe before returning so no event published after Start is missed
+	sub, _, _ := d.bus.Subscribe(events.Filter{}, 0)
+
+	d.wg.Add(1)
+	go d.run(ctx, sub)
+	return nil
+}
+
+// Stop halts event delivery and waits for deliveries in flight to finish or
+// be abandoned.
+func (d *Dispatcher) Stop() {
+	d.logger.Info().Msg("Stopping webhook dispatcher")
+	close(d.stopCh)
+	d.wg.Wait()
+	d.deliveries.Wait()
+}
+
+// Reload refreshes the cached subscriptions from the store. It is called after
+// subscriptions are created, updated or deleted.
+func (d *Dispatcher) Reload(ctx context.Context) error {
+	subs, err := d.db.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load webhook subscriptions: %w", err)
+	}
+
+	d.mu.Lock()
+	d.subs = subs
+	d.mu.Unlock()
+	return nil
+}
+
+// run reads the event bus until stopped. If the dispatcher falls behind and
+// is dropped by the bus, it resubscribes from the last event it handled so
+// buffered events are not lost.
+func (d *Dispatcher) run(ctx context.Context, sub *events.Subscription) {
+	defer d.wg.Done()
+
+	// Deliveries are cancelled when the dispatcher stops
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-d.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	lastID := sub.StartID()
+	var backlog []events.Event
+	for {
+		for _, e := range backlog {
+			if !d.dispatch(ctx, e) {
+				sub.Close()
+				return
+			}
+			lastID = e.ID
+		}
+
+		for dropped := false; !dropped; {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+				return
+			case e, ok := <-sub.Events():
+				if !ok {
+					dropped = true
+					break
+				}
+				if !d.dispatch(ctx, e) {
+					sub.Close()
+					return
+				}
+				lastID = e.ID
+			}
+		}
+
+		var complete bool
+		sub, backlog, complete = d.bus.Subscribe(events.Filter{}, lastID)
+		if !complete {
+			d.logger.Warn().Uint64("last_event_id", lastID).Msg("Webhook dispatcher fell behind; some events were not delivered")
+		}
+	}
+}
+
+// dispatch starts a delivery to every enabled subscription matching e. It
+// returns false if ctx is done before all deliveries could start.
+func (d *Dispatcher) dispatch(ctx context.Context, e events.Event) bool {
+	d.mu.RLock()
+	subs := d.subs
+	d.mu.RUnlock()
+
+	for _, sub := range subs {
+		if !sub.Enabled || !subscriptionFilter(sub).Match(e) {
+			continue
+		}
+
+		select {
+		case d.slots <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+
+		d.deliveries.Add(1)
+		go func(sub *storage.WebhookSubscription) {
+			defer d.deliveries.Done()
+			defer func() { <-d.slots }()
+			d.deliver(ctx, sub, e, false)
+		}(sub)
+	}
+	return true
+}
+
+// Test sends a "webhook.test" event to sub once, without retries, and
+// returns the recorded delivery. It is sent even if sub is disabled.
+func (d *Dispatcher) Test(ctx context.Context, sub *storage.WebhookSubscription) *storage.WebhookDelivery {
+	e := events.Event{
+		Type:       TestEventType,
+		Time:       time.Now().UTC(),
+		LabID:      sub.LabID,
+		ScenarioID: sub.ScenarioID,
+		Data: map[string]interface{}{
+			"subscription_id": sub.ID,
+			"subscription":    sub.Name,
+			"message":         "Test event sent from CymConductor",
+		},
+	}
+
+	test := *sub
+	test.MaxRetries = 0
+	return d.deliver(ctx, &test, e, true)
+}
+
+// deliver sends e to sub, retrying with a doubling backoff, and records the
+// outcome in the subscription's delivery history.
+func (d *Dispatcher) deliver(ctx context.Context, sub *storage.WebhookSubscription, e events.Event, test bool) *storage.WebhookDelivery {
+	// History is recorded even if the delivery is abandoned at shutdown
+	recordCtx := context.WithoutCancel(ctx)
+
+	delivery := &storage.WebhookDelivery{
+		SubscriptionID: sub.ID,
+		EventID:        e.ID,
+		EventType:      e.Type,
+		Test:           test,
+		Status:         storage.WebhookDeliveryPending,
+	}
+	if err := d.db.CreateWebhookDelivery(recordCtx, delivery); err != nil {
+		d.logger.Error().Err(err).Str("subscription_id", sub.ID).Msg("Failed to record webhook delivery")
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		d.finish(recordCtx, sub, delivery, nil, fmt.Errorf("failed to marshal event: %w", err))
+		return delivery
+	}
+
+	retries, backoff, _ := subscriptionPolicy(sub)
+	started := time.Now()
+	var status *int
+	var lastErr error
+attempts:
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				lastErr = ctx.Err()
+				break attempts
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxRetryBackoff)
+			d.metrics.ForwarderRetried(metrics.ForwarderWebhook)
+		}
+
+		delivery.Attempts = attempt + 1
+		code, err := d.post(ctx, sub, delivery.ID, e.Type, body)
+		if code != 0 {
+			status = &code
+		}
+		if err == nil {
+			lastErr = nil
+			break
+		}
+		lastErr = err
+		d.logger.Warn().
+			Err(err).
+			Int("attempt", attempt+1).
+			Str("subscription", sub.Name).
+			Str("event_type", e.Type).
+			Msg("Webhook delivery failed")
+	}
+
+	duration := time.Since(started).Milliseconds()
+	delivery.DurationMs = &duration
+	d.finish(recordCtx, sub, delivery, status, lastErr)
+	return delivery
+}
+
+// post makes a single signed delivery attempt. It returns the response
+// status, or 0 if no response was received.
+func (d *Dispatcher) post(ctx context.Context, sub *storage.WebhookSubscription, deliveryID, eventType string, body []byte) (int, error) {
+	_, _, timeout := subscriptionPolicy(sub)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CymConductor-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, deliveryID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, timestamp, body))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// finish records a delivery's outcome.
+func (d *Dispatcher) finish(ctx context.Context, sub *storage.WebhookSubscription, delivery *storage.WebhookDelivery, status *int, err error) {
+	now := time.Now().UTC()
+	delivery.CompletedAt = &now
+	delivery.ResponseStatus = status
+	if err != nil {
+		msg := err.Error()
+		delivery.Status = storage.WebhookDeliveryFailed
+		delivery.Error = &msg
+		d.logger.Error().
+			Err(err).
+			Str("subscription", sub.Name).
+			Str("delivery_id", delivery.ID).
+			Str("event_type", delivery.EventType).
+			Int("attempts", delivery.Attempts).
+			Msg("Webhook delivery failed after all retries")
+	} else {
+		delivery.Status = storage.WebhookDeliverySucceeded
+		d.logger.Debug().
+			Str("subscription", sub.Name).
+			Str("delivery_id", delivery.ID).
+			Str("event_type", delivery.EventType).
+			Msg("Webhook delivered")
+	}
+	if !delivery.Test {
+		d.metrics.ForwarderDelivered(metrics.ForwarderWebhook, err == nil)
+	}
+
+	if err := d.db.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		d.logger.Error().Err(err).Str("delivery_id", delivery.ID).Msg("Failed to record webhook delivery")
+	}
+}
+
+// subscriptionFilter returns the event filter for a subscription.
+func subscriptionFilter(sub *storage.WebhookSubscription) events.Filter {
+	return events.Filter{
+		Types:      sub.EventTypes,
+		LabID:      sub.LabID,
+		ScenarioID: sub.ScenarioID,
+	}
+}
+
+// subscriptionPolicy returns a subscription's retry policy with defaults
+// applied to unset values.
+func subscriptionPolicy(sub *storage.WebhookSubscription) (retries int, backoff, timeout time.Duration) {
+	retries, backoff, timeout = sub.MaxRetries, sub.RetryBackoff, sub.Timeout
+	if retries < 0 {
+		retries = 0
+	}
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return retries, backoff, timeout
+}
@@ -0,0 +1,98 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"cymbytes.com/cymconductor/internal/orchestrator/events"
+	"cymbytes.com/cymconductor/internal/orchestrator/storage"
+)
+
+func TestWebhookDispatcher_DeliversMatchingSignedEvents(t *testing.T) {
+	db := storage.NewMemory(zerolog.Nop())
+	defer db.Close()
+
+	const secret = "test-secret-0123456789"
+	received := make(chan *http.Request, 10)
+	var failures int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		err := Verify(secret, r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), body, time.Minute)
+		if err != nil {
+			t.Errorf("Invalid signature: %v", err)
+		}
+		// The first attempt fails so the retry policy is exercised
+		if failures == 0 {
+			failures++
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		received <- r
+	}))
+	defer server.Close()
+
+	sub := &storage.WebhookSubscription{
+		Name:         "grading",
+		URL:          server.URL,
+		Secret:       secret,
+		EventTypes:   []string{"job"},
+		ScenarioID:   "scn-1",
+		Enabled:      true,
+		MaxRetries:   2,
+		RetryBackoff: 10 * time.Millisecond,
+		Timeout:      time.Second,
+	}
+	if err := db.CreateWebhookSubscription(context.Background(), sub); err != nil {
+		t.Fatalf("Failed to create subscription: %v", err)
+	}
+
+	bus := events.New(events.DefaultConfig(), zerolog.Nop())
+	dispatcher := NewDispatcher(db, bus, zerolog.Nop())
+	if err := dispatcher.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start dispatcher: %v", err)
+	}
+	defer dispatcher.Stop()
+
+	// Only the job event for the subscribed scenario matches
+	bus.Publish(events.Event{Type: events.AgentOnline, AgentID: "agent-1"})
+	bus.Publish(events.Event{Type: events.JobCompleted, ScenarioID: "scn-2", JobID: "job-other"})
+	bus.Publish(events.Event{Type: events.JobCompleted, ScenarioID: "scn-1", JobID: "job-1"})
+
+	var r *http.Request
+	select {
+	case r = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the webhook delivery")
+	}
+	if r.Header.Get(HeaderEvent) != events.JobCompleted || r.Header.Get(HeaderDelivery) == "" {
+		t.Errorf("Unexpected delivery headers: %v", r.Header)
+	}
+	var e events.Event
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil || e.JobID != "job-1" {
+		t.Errorf("Expected job-1 in the payload, got %+v (err: %v)", e, err)
+	}
+
+	// The outcome is recorded once the delivery finishes
+	var deliveries []*storage.WebhookDelivery
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		deliveries, _ = db.ListWebhookDeliveries(context.Background(), sub.ID, 0)
+		if len(deliveries) == 1 && deliveries[0].Status != storage.WebhookDeliveryPending {
+			break
+		}
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("Expected 1 delivery, got %d", len(deliveries))
+	}
+	if d := deliveries[0]; d.Status != storage.WebhookDeliverySucceeded || d.Attempts != 2 || d.ResponseStatus == nil || *d.ResponseStatus != http.StatusOK {
+		t.Errorf("Expected a delivery that succeeded on the second attempt, got %+v", d)
+	}
+}
@@ -0,0 +1,28 @@
+-- CymConductor - API Keys Schema
+-- Version: 004
+-- Description: Add hashed API keys for operator authentication and RBAC
+
+-- ============================================================
+-- Table: api_keys
+-- Operator credentials for the REST API (only the SHA256 hash is stored)
+-- ============================================================
+CREATE TABLE IF NOT EXISTS api_keys (
+    id TEXT PRIMARY KEY,                              -- UUID v4
+    name TEXT NOT NULL,                               -- Human-readable key name (recorded as audit actor)
+    key_prefix TEXT NOT NULL,                         -- First characters of the key for identification
+    key_hash TEXT NOT NULL,                           -- SHA256 hex digest of the full key
+    role TEXT NOT NULL DEFAULT 'viewer',              -- viewer, operator, admin
+    created_by TEXT,                                  -- Actor that created the key
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at DATETIME,                            -- Last successful authentication
+    expires_at DATETIME,                              -- Optional expiry
+    revoked_at DATETIME,                              -- Set when the key is revoked
+
+    UNIQUE(key_hash)
+);
+
+-- ============================================================
+-- Indexes
+-- ============================================================
+CREATE INDEX IF NOT EXISTS idx_api_keys_name ON api_keys(name);
+CREATE INDEX IF NOT EXISTS idx_audit_actor ON audit_log(actor);
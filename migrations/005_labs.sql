@@ -0,0 +1,84 @@
+-- CymConductor - Multi-Lab Tenancy Schema
+-- Version: 005
+-- Description: Add labs and scope agents, scenarios, jobs and users to a lab
+
+-- ============================================================
+-- Table: labs
+-- Isolated lab namespaces served by one orchestrator
+-- ============================================================
+CREATE TABLE IF NOT EXISTS labs (
+    id TEXT PRIMARY KEY,                              -- Slug (e.g., "default", "class-a")
+    name TEXT NOT NULL,                               -- Human-readable lab name
+    description TEXT,                                 -- Optional description
+    allowed_networks TEXT NOT NULL DEFAULT '[]',      -- JSON array of CIDRs agents and scenarios may use
+    webhook_url TEXT,                                 -- Per-lab webhook target (overrides messenger URL)
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Existing data belongs to the default lab
+INSERT OR IGNORE INTO labs (id, name, description)
+VALUES ('default', 'Default Lab', 'Lab for agents and scenarios registered without a lab ID');
+
+-- ============================================================
+-- Add lab_id to agents, scenarios and jobs
+-- ============================================================
+ALTER TABLE agents ADD COLUMN lab_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE scenarios ADD COLUMN lab_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE jobs ADD COLUMN lab_id TEXT NOT NULL DEFAULT 'default';
+
+-- ============================================================
+-- Rebuild impersonation_users so usernames are unique per lab
+-- (cloned labs commonly share the same domain accounts)
+-- ============================================================
+CREATE TABLE impersonation_users_new (
+    id TEXT PRIMARY KEY,                              -- UUID v4
+    lab_id TEXT NOT NULL DEFAULT 'default',           -- Owning lab
+    username TEXT NOT NULL,                           -- Full username (DOMAIN\user)
+    domain TEXT NOT NULL,                             -- Domain name (e.g., "CYMBYTES")
+    sam_account_name TEXT NOT NULL,                   -- SAM account name (e.g., "jsmith")
+    display_name TEXT,                                -- Display name for logging
+    department TEXT,                                  -- Department for realistic grouping
+    title TEXT,                                       -- Job title
+    allowed_hosts TEXT NOT NULL DEFAULT '[]',         -- JSON array of allowed lab_host_ids
+    persona TEXT,                                     -- JSON: behavior hints for AI planner
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE(lab_id, username)
+);
+
+INSERT INTO impersonation_users_new (id, username, domain, sam_account_name, display_name, department,
+                                     title, allowed_hosts, persona, created_at, updated_at)
+SELECT id, username, domain, sam_account_name, display_name, department,
+       title, allowed_hosts, persona, created_at, updated_at
+FROM impersonation_users;
+
+DROP TABLE impersonation_users;
+ALTER TABLE impersonation_users_new RENAME TO impersonation_users;
+
+-- ============================================================
+-- Indexes
+-- ============================================================
+CREATE INDEX IF NOT EXISTS idx_agents_lab ON agents(lab_id, status);
+CREATE INDEX IF NOT EXISTS idx_scenarios_lab ON scenarios(lab_id, status);
+CREATE INDEX IF NOT EXISTS idx_jobs_lab ON jobs(lab_id, status);
+CREATE INDEX IF NOT EXISTS idx_impersonation_users_domain ON impersonation_users(domain);
+CREATE INDEX IF NOT EXISTS idx_impersonation_users_department ON impersonation_users(department);
+
+-- ============================================================
+-- Triggers
+-- ============================================================
+CREATE TRIGGER IF NOT EXISTS trg_impersonation_users_updated_at
+AFTER UPDATE ON impersonation_users
+FOR EACH ROW
+BEGIN
+    UPDATE impersonation_users SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS trg_labs_updated_at
+AFTER UPDATE ON labs
+FOR EACH ROW
+BEGIN
+    UPDATE labs SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
@@ -0,0 +1,60 @@
+-- CymConductor - Webhook Subscriptions Schema
+-- Version: 006
+-- Description: Add webhook subscriptions and their delivery history
+
+-- ============================================================
+-- Table: webhook_subscriptions
+-- External services that receive orchestrator events
+-- ============================================================
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id TEXT PRIMARY KEY,                              -- UUID v4
+    name TEXT NOT NULL,                               -- Human-readable name (e.g., "tickets")
+    url TEXT NOT NULL,                                -- Endpoint events are POSTed to
+    secret TEXT NOT NULL,                             -- HMAC-SHA256 signing secret
+    event_types TEXT NOT NULL DEFAULT '[]',           -- JSON array of event types or categories; empty = all
+    lab_id TEXT,                                      -- Only events from this lab (NULL = all labs)
+    scenario_id TEXT,                                 -- Only events for this scenario (NULL = all)
+    enabled INTEGER NOT NULL DEFAULT 1,               -- Disabled subscriptions receive nothing
+    max_retries INTEGER NOT NULL DEFAULT 3,           -- Retries after the first attempt
+    retry_backoff_ms INTEGER NOT NULL DEFAULT 1000,   -- First retry delay; doubles per retry
+    timeout_ms INTEGER NOT NULL DEFAULT 10000,        -- Per-attempt request timeout
+    created_by TEXT,                                  -- Actor that created the subscription
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE(name)
+);
+
+-- ============================================================
+-- Table: webhook_deliveries
+-- Recent delivery attempts per subscription
+-- ============================================================
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id TEXT PRIMARY KEY,                              -- UUID v4 (sent as X-CymConductor-Delivery)
+    subscription_id TEXT NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    event_id INTEGER NOT NULL,                        -- Event bus ID (0 for test events)
+    event_type TEXT NOT NULL,
+    test INTEGER NOT NULL DEFAULT 0,                  -- Sent by the test action
+    status TEXT NOT NULL DEFAULT 'pending',           -- pending, succeeded, failed
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER,                          -- HTTP status of the last attempt
+    error TEXT,                                       -- Error of the last failed attempt
+    duration_ms INTEGER,                              -- Time from first attempt to completion
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at DATETIME
+);
+
+-- ============================================================
+-- Indexes
+-- ============================================================
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription ON webhook_deliveries(subscription_id, created_at);
+
+-- ============================================================
+-- Triggers
+-- ============================================================
+CREATE TRIGGER IF NOT EXISTS trg_webhook_subscriptions_updated_at
+AFTER UPDATE ON webhook_subscriptions
+FOR EACH ROW
+BEGIN
+    UPDATE webhook_subscriptions SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
@@ -0,0 +1,41 @@
+-- CymConductor - Outbox Schema
+-- Version: 007
+-- Description: Add a transactional outbox for scoring and messenger events
+
+-- ============================================================
+-- Table: outbox
+-- Events written with the state change that caused them and
+-- delivered to the scoring engine or messenger by a worker
+-- ============================================================
+CREATE TABLE IF NOT EXISTS outbox (
+    id TEXT PRIMARY KEY,                              -- UUID v4
+    event_id TEXT NOT NULL,                           -- Stable event ID sent to the target for deduplication
+    target TEXT NOT NULL,                             -- scoring, messenger
+    destination TEXT NOT NULL DEFAULT '',             -- Scoring run ID or lab ID the event is routed by
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,                            -- JSON request body
+    status TEXT NOT NULL DEFAULT 'pending',           -- pending, delivered, dead
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,                                  -- Error of the last failed attempt
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at DATETIME,
+
+    UNIQUE(target, event_id)
+);
+
+-- ============================================================
+-- Indexes
+-- ============================================================
+CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox(status, next_attempt_at);
+
+-- ============================================================
+-- Triggers
+-- ============================================================
+CREATE TRIGGER IF NOT EXISTS trg_outbox_updated_at
+AFTER UPDATE ON outbox
+FOR EACH ROW
+BEGIN
+    UPDATE outbox SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id;
+END;
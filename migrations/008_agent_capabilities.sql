@@ -0,0 +1,8 @@
+-- CymConductor - Agent Capabilities Schema
+-- Version: 008
+-- Description: Store the actions and backends each agent reports at registration
+
+-- ============================================================
+-- Add capabilities to agents
+-- ============================================================
+ALTER TABLE agents ADD COLUMN capabilities TEXT;                  -- JSON AgentCapabilities; NULL for agents that did not report them
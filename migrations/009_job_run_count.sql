@@ -0,0 +1,8 @@
+-- CymConductor - Job Run Count Schema
+-- Version: 009
+-- Description: Count the runs of each job so every run's result gets its own event ID
+
+-- ============================================================
+-- Add run_count to jobs
+-- ============================================================
+ALTER TABLE jobs ADD COLUMN run_count INTEGER NOT NULL DEFAULT 0;  -- Times the job went back to pending (retry, manual retry, objective re-run)
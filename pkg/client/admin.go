@@ -0,0 +1,224 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// ============================================================
+// Labs
+// ============================================================
+
+// ListLabs lists labs.
+func (c *Client) ListLabs(ctx context.Context) ([]protocol.LabResponse, error) {
+	var resp protocol.ListLabsResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/labs"}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Labs, nil
+}
+
+// GetLab returns a single lab.
+func (c *Client) GetLab(ctx context.Context, labID string) (*protocol.LabResponse, error) {
+	var resp protocol.LabResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: pathf("/api/labs/%s", labID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateLab creates a lab.
+func (c *Client) CreateLab(ctx context.Context, req protocol.CreateLabRequest) (*protocol.LabResponse, error) {
+	var resp protocol.LabResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/labs", body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateLab updates a lab.
+func (c *Client) UpdateLab(ctx context.Context, labID string, req protocol.UpdateLabRequest) (*protocol.LabResponse, error) {
+	var resp protocol.LabResponse
+	if err := c.do(ctx, request{method: http.MethodPut, path: pathf("/api/labs/%s", labID), body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteLab deletes an empty lab.
+func (c *Client) DeleteLab(ctx context.Context, labID string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: pathf("/api/labs/%s", labID)}, nil)
+}
+
+// ============================================================
+// Authentication and API Keys
+// ============================================================
+
+// WhoAmI describes the caller.
+func (c *Client) WhoAmI(ctx context.Context) (*protocol.WhoAmIResponse, error) {
+	var resp protocol.WhoAmIResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/auth/whoami"}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListAPIKeys lists API keys, including revoked ones.
+func (c *Client) ListAPIKeys(ctx context.Context) ([]protocol.APIKeyResponse, error) {
+	var resp protocol.ListAPIKeysResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/auth/keys"}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Keys, nil
+}
+
+// CreateAPIKey creates an API key. The plaintext key is only returned here.
+func (c *Client) CreateAPIKey(ctx context.Context, req protocol.CreateAPIKeyRequest) (*protocol.CreateAPIKeyResponse, error) {
+	var resp protocol.CreateAPIKeyResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/auth/keys", body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RevokeAPIKey revokes an API key.
+func (c *Client) RevokeAPIKey(ctx context.Context, keyID string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: pathf("/api/auth/keys/%s", keyID)}, nil)
+}
+
+// ============================================================
+// Audit Log
+// ============================================================
+
+// AuditQuery filters the audit log. Empty fields are not sent.
+type AuditQuery struct {
+	EntityType string
+	EntityID   string
+	Action     string
+	Actor      string
+
+	Since time.Time
+	Until time.Time
+
+	// Page size and cursor for ListAudit (ignored by ExportAudit)
+	Limit  int
+	Cursor string
+}
+
+func (q AuditQuery) values() url.Values {
+	v := url.Values{}
+	setQuery(v, "entity_type", q.EntityType)
+	setQuery(v, "entity_id", q.EntityID)
+	setQuery(v, "action", q.Action)
+	setQuery(v, "actor", q.Actor)
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", q.Until.Format(time.RFC3339))
+	}
+	return v
+}
+
+// ListAudit returns one page of audit entries, newest first. Pass the
+// response's NextCursor as AuditQuery.Cursor to fetch older entries.
+func (c *Client) ListAudit(ctx context.Context, query AuditQuery) (*protocol.ListAuditResponse, error) {
+	q := query.values()
+	if query.Limit > 0 {
+		q.Set("limit", strconv.Itoa(query.Limit))
+	}
+	setQuery(q, "cursor", query.Cursor)
+
+	var resp protocol.ListAuditResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/audit", query: q}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ExportAudit streams every matching entry, oldest first, as JSON lines
+// (format "jsonl") or CSV (format "csv"). The caller must close the reader.
+func (c *Client) ExportAudit(ctx context.Context, query AuditQuery, format string) (io.ReadCloser, error) {
+	q := query.values()
+	setQuery(q, "format", format)
+	return c.stream(ctx, "/api/audit/export", q)
+}
+
+// ============================================================
+// Backup and State Transfer
+// ============================================================
+
+// Backup streams an online backup of the orchestrator database as a SQLite
+// file. The caller must close the reader.
+func (c *Client) Backup(ctx context.Context) (io.ReadCloser, error) {
+	return c.stream(ctx, "/api/admin/backup", nil)
+}
+
+// ExportState returns a portable copy of the labs, users and validated
+// scenarios.
+func (c *Client) ExportState(ctx context.Context) (*protocol.StateExport, error) {
+	var resp protocol.StateExport
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/admin/export"}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ImportState imports a state export. Existing labs and users are skipped
+// unless overwrite is set.
+func (c *Client) ImportState(ctx context.Context, doc protocol.StateExport, overwrite bool) (*protocol.ImportStateResponse, error) {
+	q := url.Values{}
+	if overwrite {
+		q.Set("overwrite", "true")
+	}
+
+	var resp protocol.ImportStateResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/admin/import", query: q, body: doc}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ============================================================
+// Health
+// ============================================================
+
+// Health returns the orchestrator's health.
+func (c *Client) Health(ctx context.Context) (*protocol.HealthResponse, error) {
+	var resp protocol.HealthResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/health"}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Ready returns nil if the orchestrator is ready to serve requests.
+func (c *Client) Ready(ctx context.Context) error {
+	return c.do(ctx, request{method: http.MethodGet, path: "/ready", noRetry: true}, nil)
+}
+
+// stream sends a GET request and returns the response body unread. Only the
+// context bounds it, since downloads may take longer than the client timeout.
+func (c *Client) stream(ctx context.Context, path string, query url.Values) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, parseError(resp)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// ============================================================
+// Agents
+// ============================================================
+
+// ListAgents lists registered agents.
+func (c *Client) ListAgents(ctx context.Context) ([]protocol.AgentInfo, error) {
+	var resp protocol.ListAgentsResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/agents"}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Agents, nil
+}
+
+// GetAgent returns a single agent.
+func (c *Client) GetAgent(ctx context.Context, agentID string) (*protocol.AgentInfo, error) {
+	var resp protocol.AgentInfo
+	if err := c.do(ctx, request{method: http.MethodGet, path: pathf("/api/agents/%s", agentID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteAgent removes an agent.
+func (c *Client) DeleteAgent(ctx context.Context, agentID string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: pathf("/api/agents/%s", agentID)}, nil)
+}
+
+// ============================================================
+// Agent Protocol
+// ============================================================
+
+// Register registers an agent with the orchestrator. Registration is
+// idempotent (agents re-register with the same ID), so it is retried.
+func (c *Client) Register(ctx context.Context, req protocol.RegisterAgentRequest) (*protocol.RegisterAgentResponse, error) {
+	var resp protocol.RegisterAgentResponse
+	if err := c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       "/api/agents/register",
+		body:       req,
+		idempotent: true,
+	}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Heartbeat reports that an agent is alive.
+func (c *Client) Heartbeat(ctx context.Context, agentID string, req protocol.HeartbeatRequest) (*protocol.HeartbeatResponse, error) {
+	var resp protocol.HeartbeatResponse
+	if err := c.do(ctx, request{
+		method:     http.MethodPost,
+		path:       pathf("/api/agents/%s/heartbeat", agentID),
+		body:       req,
+		idempotent: true,
+	}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetJobs polls for an agent's due jobs.
+func (c *Client) GetJobs(ctx context.Context, agentID string, max int) ([]protocol.JobAssignment, error) {
+	return c.WaitJobs(ctx, agentID, max, 0)
+}
+
+// WaitJobs long-polls for jobs: the orchestrator holds the request until a job
+// is due or the wait expires. A zero wait behaves like GetJobs. No jobs is
+// not an error.
+//
+// Long polls are not retried, since the jobs in a lost response have already
+// been assigned.
+func (c *Client) WaitJobs(ctx context.Context, agentID string, max int, wait time.Duration) ([]protocol.JobAssignment, error) {
+	q := url.Values{}
+	if max > 0 {
+		q.Set("max", strconv.Itoa(max))
+	}
+	if seconds := int(wait / time.Second); seconds > 0 {
+		q.Set("wait", strconv.Itoa(seconds))
+	}
+
+	var resp protocol.GetJobsResponse
+	if err := c.do(ctx, request{
+		method:  http.MethodGet,
+		path:    pathf("/api/agents/%s/jobs/next", agentID),
+		query:   q,
+		timeout: c.timeout + wait,
+		noRetry: true,
+	}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Jobs, nil
+}
+
+// ReportResult reports the outcome of a job. Results are not retried here:
+// the caller decides whether a lost report is worth resending.
+func (c *Client) ReportResult(ctx context.Context, agentID, jobID string, req protocol.JobResultRequest) (*protocol.JobResultResponse, error) {
+	var resp protocol.JobResultResponse
+	if err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   pathf("/api/agents/%s/jobs/%s/result", agentID, jobID),
+		body:   req,
+	}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// Channel is an agent's WebSocket connection to the orchestrator. Messages
+// are protocol.ChannelMessage envelopes (see the protocol.Message* types).
+type Channel struct {
+	conn   *websocket.Conn
+	sendMu sync.Mutex
+}
+
+// OpenChannel opens an agent's WebSocket channel.
+func (c *Client) OpenChannel(ctx context.Context, agentID string) (*Channel, error) {
+	wsURL, err := channelURL(c.baseURL, agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := websocket.NewConfig(wsURL, c.baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create channel config: %w", err)
+	}
+	cfg.Dialer = &net.Dialer{Timeout: c.connectTimeout}
+	if c.apiKey != "" {
+		cfg.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	if c.userAgent != "" {
+		cfg.Header.Set("User-Agent", c.userAgent)
+	}
+
+	conn, err := cfg.DialContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	return &Channel{conn: conn}, nil
+}
+
+// Send writes a message with a JSON-encoded payload. It is safe to call
+// from several goroutines.
+func (ch *Channel) Send(msgType string, payload interface{}) error {
+	msg, err := protocol.NewChannelMessage(msgType, payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	ch.sendMu.Lock()
+	defer ch.sendMu.Unlock()
+
+	if err := websocket.JSON.Send(ch.conn, msg); err != nil {
+		return fmt.Errorf("failed to send %s: %w", msgType, err)
+	}
+	return nil
+}
+
+// Receive blocks until the next message arrives or the timeout passes.
+func (ch *Channel) Receive(timeout time.Duration) (*protocol.ChannelMessage, error) {
+	if err := ch.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	var msg protocol.ChannelMessage
+	if err := websocket.JSON.Receive(ch.conn, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Close closes the channel.
+func (ch *Channel) Close() error {
+	return ch.conn.Close()
+}
+
+// channelURL converts the orchestrator base URL into the channel's ws:// URL.
+func channelURL(baseURL, agentID string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid orchestrator URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + pathf("/api/agents/%s/ws", agentID)
+
+	return u.String(), nil
+}
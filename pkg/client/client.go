@@ -0,0 +1,297 @@
+// Package client is the Go SDK for the orchestrator API.
+//
+// It covers the agent endpoints (registration, heartbeats, job delivery and
+// the WebSocket channel) and the operator endpoints (agents, scenarios, jobs,
+// impersonation users, labs, API keys, audit, administration and the event
+// stream), using the request and response types from pkg/protocol. Error
+// responses are returned as *Error, which matches the Err* sentinels with
+// errors.Is. Idempotent requests are retried on network errors and
+// transient server errors.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for Config fields left zero.
+const (
+	DefaultTimeout        = 30 * time.Second
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultMaxRetries     = 2
+	DefaultRetryWait      = 500 * time.Millisecond
+	DefaultMaxRetryWait   = 5 * time.Second
+)
+
+// Config holds client configuration.
+type Config struct {
+	// Orchestrator base URL, e.g. http://localhost:8081
+	BaseURL string
+
+	// API key sent as a bearer token (optional when auth is disabled)
+	APIKey string
+
+	// Lab to scope requests to (optional, sent as X-Lab-ID)
+	LabID string
+
+	// Timeout for a single request attempt (default DefaultTimeout). The
+	// event stream is not bounded.
+	Timeout time.Duration
+
+	// Timeout for establishing connections (default DefaultConnectTimeout)
+	ConnectTimeout time.Duration
+
+	// Retries after the first attempt of an idempotent request (default
+	// DefaultMaxRetries; negative disables retries)
+	MaxRetries int
+
+	// Backoff before the first retry, doubled for each further retry up to
+	// MaxRetryWait (defaults DefaultRetryWait and DefaultMaxRetryWait)
+	RetryWait    time.Duration
+	MaxRetryWait time.Duration
+
+	// User-Agent header (optional)
+	UserAgent string
+
+	// HTTPClient overrides the transport (optional; ConnectTimeout is then
+	// up to the caller)
+	HTTPClient *http.Client
+}
+
+// Client talks to the orchestrator API. It is safe for concurrent use.
+type Client struct {
+	baseURL        string
+	apiKey         string
+	labID          string
+	userAgent      string
+	timeout        time.Duration
+	connectTimeout time.Duration
+	maxRetries     int
+	retryWait      time.Duration
+	maxRetryWait   time.Duration
+	httpClient     *http.Client
+}
+
+// New creates a client.
+func New(cfg Config) *Client {
+	c := &Client{
+		baseURL:        strings.TrimRight(cfg.BaseURL, "/"),
+		apiKey:         cfg.APIKey,
+		labID:          cfg.LabID,
+		userAgent:      cfg.UserAgent,
+		timeout:        cfg.Timeout,
+		connectTimeout: cfg.ConnectTimeout,
+		maxRetries:     cfg.MaxRetries,
+		retryWait:      cfg.RetryWait,
+		maxRetryWait:   cfg.MaxRetryWait,
+		httpClient:     cfg.HTTPClient,
+	}
+	if c.timeout <= 0 {
+		c.timeout = DefaultTimeout
+	}
+	if c.connectTimeout <= 0 {
+		c.connectTimeout = DefaultConnectTimeout
+	}
+	switch {
+	case c.maxRetries == 0:
+		c.maxRetries = DefaultMaxRetries
+	case c.maxRetries < 0:
+		c.maxRetries = 0
+	}
+	if c.retryWait <= 0 {
+		c.retryWait = DefaultRetryWait
+	}
+	if c.maxRetryWait <= 0 {
+		c.maxRetryWait = DefaultMaxRetryWait
+	}
+	if c.httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = (&net.Dialer{
+			Timeout:   c.connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		c.httpClient = &http.Client{Transport: transport}
+	}
+	return c
+}
+
+// BaseURL returns the orchestrator base URL.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// ============================================================
+// Requests
+// ============================================================
+
+// request describes one API call.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+
+	// Retried on network errors and transient statuses. GET, PUT and DELETE
+	// are always idempotent; POSTs opt in.
+	idempotent bool
+
+	// Never retried, e.g. a GET that hands out work
+	noRetry bool
+
+	// Overrides the client timeout (e.g. for long polls)
+	timeout time.Duration
+}
+
+// do sends a JSON request and decodes a JSON response into out (if
+// non-nil).
+func (c *Client) do(ctx context.Context, req request, out interface{}) error {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+	}
+
+	retries := 0
+	if !req.noRetry && (req.idempotent || req.method == http.MethodGet || req.method == http.MethodPut || req.method == http.MethodDelete) {
+		retries = c.maxRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		status, retryAfter, err := c.attempt(ctx, req, body, out)
+		if err == nil || attempt >= retries || !retryable(status, err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := c.backoff(attempt)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// attempt sends a request once. It returns the status code and any
+// Retry-After delay the server asked for.
+func (c *Client) attempt(ctx context.Context, req request, body []byte, out interface{}) (int, time.Duration, error) {
+	timeout := req.timeout
+	if timeout <= 0 {
+		timeout = c.timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := c.newRequest(ctx, req.method, req.path, req.query, reader)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return 0, 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return resp.StatusCode, retryAfter(resp), parseError(resp)
+	}
+
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, 0, fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return resp.StatusCode, 0, nil
+}
+
+// newRequest builds a request carrying the client's credentials and lab scope.
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	if c.labID != "" {
+		req.Header.Set("X-Lab-ID", c.labID)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	return req, nil
+}
+
+// retryable reports whether a failed attempt may succeed if repeated:
+// network errors (but not decode errors or cancellation) and 429, 502, 503
+// and 504 responses.
+func retryable(status int, err error) bool {
+	if status == 0 {
+		return !errors.Is(err, context.Canceled) && strings.HasPrefix(err.Error(), "request failed")
+	}
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the wait before retry number attempt+1, with jitter.
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.retryWait << uint(attempt)
+	if wait <= 0 || wait > c.maxRetryWait {
+		wait = c.maxRetryWait
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// retryAfter reads a Retry-After header given in seconds.
+func retryAfter(resp *http.Response) time.Duration {
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	return 0
+}
+
+func setQuery(v url.Values, key, value string) {
+	if value != "" {
+		v.Set(key, value)
+	}
+}
+
+// pathf formats a path, escaping every argument as a path segment.
+func pathf(format string, args ...string) string {
+	escaped := make([]interface{}, len(args))
+	for i, a := range args {
+		escaped[i] = url.PathEscape(a)
+	}
+	return fmt.Sprintf(format, escaped...)
+}
@@ -0,0 +1,244 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return New(Config{
+		BaseURL:      server.URL + "/",
+		APIKey:       "cyk_test",
+		LabID:        "lab-a",
+		UserAgent:    "client-test",
+		RetryWait:    time.Millisecond,
+		MaxRetryWait: time.Millisecond,
+	})
+}
+
+func TestClient_SendsCredentialsAndScope(t *testing.T) {
+	var got *http.Request
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		fmt.Fprint(w, `{"jobs":[],"total":0}`)
+	})
+
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if _, err := c.ListJobs(context.Background(), JobQuery{Status: []string{"failed", "cancelled"}, Since: since, Limit: 10}); err != nil {
+		t.Fatalf("ListJobs() error = %v", err)
+	}
+	if got.URL.Path != "/api/jobs" {
+		t.Errorf("Expected /api/jobs, got %s", got.URL.Path)
+	}
+	if q := got.URL.Query(); q.Get("status") != "failed,cancelled" || q.Get("since") != "2026-01-02T03:04:05Z" || q.Get("limit") != "10" || q.Has("cursor") {
+		t.Errorf("Unexpected query: %s", got.URL.RawQuery)
+	}
+	if got.Header.Get("Authorization") != "Bearer cyk_test" || got.Header.Get("X-Lab-ID") != "lab-a" || got.Header.Get("User-Agent") != "client-test" {
+		t.Errorf("Unexpected headers: %v", got.Header)
+	}
+
+	// Path arguments are escaped as single segments
+	if _, err := c.GetJob(context.Background(), "job/../1"); err != nil {
+		t.Fatalf("GetJob() error = %v", err)
+	}
+	if got.URL.EscapedPath() != "/api/jobs/job%2F..%2F1" {
+		t.Errorf("Expected the job ID escaped, got %s", got.URL.EscapedPath())
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		sentinel error
+		want     Error
+	}{
+		{
+			name:     "error response",
+			status:   http.StatusNotFound,
+			body:     `{"error":"job_not_found","message":"Job not found","request_id":"req-1"}`,
+			sentinel: ErrNotFound,
+			want:     Error{StatusCode: http.StatusNotFound, Code: "job_not_found", Message: "Job not found", RequestID: "req-1"},
+		},
+		{
+			name:     "plain text",
+			status:   http.StatusConflict,
+			body:     "already running\n",
+			sentinel: ErrConflict,
+			want:     Error{StatusCode: http.StatusConflict, Message: "already running"},
+		},
+		{
+			name:     "empty body",
+			status:   http.StatusForbidden,
+			sentinel: ErrForbidden,
+			want:     Error{StatusCode: http.StatusForbidden, Message: "Forbidden"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			})
+
+			_, err := c.GetJob(context.Background(), "job-1")
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Expected an *Error, got %v", err)
+			}
+			if apiErr.StatusCode != tt.want.StatusCode || apiErr.Code != tt.want.Code || apiErr.Message != tt.want.Message || apiErr.RequestID != tt.want.RequestID {
+				t.Errorf("Expected %+v, got %+v", tt.want, *apiErr)
+			}
+			if !errors.Is(err, tt.sentinel) || errors.Is(err, ErrBadRequest) {
+				t.Errorf("Expected %v to match only %v", err, tt.sentinel)
+			}
+		})
+	}
+}
+
+func TestClient_Retries(t *testing.T) {
+	tests := []struct {
+		name     string
+		call     func(c *Client) error
+		status   int
+		attempts int32
+	}{
+		{
+			name:     "GET on a transient error",
+			call:     func(c *Client) error { _, err := c.GetJob(context.Background(), "job-1"); return err },
+			status:   http.StatusServiceUnavailable,
+			attempts: 3,
+		},
+		{
+			name:     "GET on a client error",
+			call:     func(c *Client) error { _, err := c.GetJob(context.Background(), "job-1"); return err },
+			status:   http.StatusBadRequest,
+			attempts: 1,
+		},
+		{
+			name: "idempotent POST",
+			call: func(c *Client) error {
+				_, err := c.Heartbeat(context.Background(), "agent-1", protocol.HeartbeatRequest{})
+				return err
+			},
+			status:   http.StatusBadGateway,
+			attempts: 3,
+		},
+		{
+			name: "result report",
+			call: func(c *Client) error {
+				_, err := c.ReportResult(context.Background(), "agent-1", "job-1", protocol.JobResultRequest{})
+				return err
+			},
+			status:   http.StatusServiceUnavailable,
+			attempts: 1,
+		},
+		{
+			name: "long poll",
+			call: func(c *Client) error {
+				_, err := c.WaitJobs(context.Background(), "agent-1", 1, time.Second)
+				return err
+			},
+			status:   http.StatusServiceUnavailable,
+			attempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(tt.status)
+			})
+
+			if err := tt.call(c); err == nil {
+				t.Fatal("Expected an error")
+			}
+			if got := attempts.Load(); got != tt.attempts {
+				t.Errorf("Expected %d attempts, got %d", tt.attempts, got)
+			}
+		})
+	}
+
+	// A retry that succeeds returns the response
+	var attempts atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"job_id":"job-1","status":"completed"}`)
+	})
+	job, err := c.GetJob(context.Background(), "job-1")
+	if err != nil || job.Status != "completed" {
+		t.Errorf("Expected the job after a retry, got %+v (err: %v)", job, err)
+	}
+}
+
+func TestStreamEvents_ResumesAfterDisconnect(t *testing.T) {
+	var connections atomic.Int32
+	var resumedFrom string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("type") != "job,scenario.completed" || r.Header.Get("Accept") != "text/event-stream" {
+			t.Errorf("Unexpected request: %s %v", r.URL.RawQuery, r.Header)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		if connections.Add(1) == 1 {
+			fmt.Fprint(w, "retry: 1\n\n: keep-alive\n\n")
+			fmt.Fprint(w, "id: 7\nevent: job.created\ndata: {\"id\":7,\"type\":\"job.created\",\"job_id\":\"job-1\"}\n\n")
+			return
+		}
+		resumedFrom = r.Header.Get("Last-Event-ID")
+		fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+		fmt.Fprint(w, "id: 9\nevent: job.completed\ndata: {\"id\":9,\n")
+		fmt.Fprint(w, "data: \"type\":\"job.completed\"}\n\n")
+	})
+
+	stop := errors.New("done")
+	var got []string
+	err := c.StreamEvents(context.Background(), EventQuery{Types: []string{"job", "scenario.completed"}}, func(e Event) error {
+		got = append(got, fmt.Sprintf("%d %s", e.ID, e.Type))
+		if e.Type == "job.completed" {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("Expected the callback's error, got %v", err)
+	}
+	if resumedFrom != "7" {
+		t.Errorf("Expected the stream to resume from event 7, got %q", resumedFrom)
+	}
+	if want := "[7 job.created 0 resync 9 job.completed]"; fmt.Sprint(got) != want {
+		t.Errorf("Expected %s, got %v", want, got)
+	}
+}
+
+func TestStreamEvents_FailsBeforeConnecting(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	err := c.StreamEvents(context.Background(), EventQuery{}, func(Event) error { return nil })
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected the status in the error, got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// Sentinel errors matched by *Error through errors.Is, by HTTP status.
+var (
+	ErrBadRequest     = errors.New("bad request")
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrForbidden      = errors.New("forbidden")
+	ErrNotFound       = errors.New("not found")
+	ErrConflict       = errors.New("conflict")
+	ErrNotImplemented = errors.New("not implemented")
+	ErrUnavailable    = errors.New("service unavailable")
+)
+
+// statusErrors maps HTTP statuses to their sentinel errors.
+var statusErrors = map[int]error{
+	http.StatusBadRequest:         ErrBadRequest,
+	http.StatusUnauthorized:       ErrUnauthorized,
+	http.StatusForbidden:          ErrForbidden,
+	http.StatusNotFound:           ErrNotFound,
+	http.StatusConflict:           ErrConflict,
+	http.StatusNotImplemented:     ErrNotImplemented,
+	http.StatusServiceUnavailable: ErrUnavailable,
+}
+
+// Error is an error response from the orchestrator.
+type Error struct {
+	// HTTP status code
+	StatusCode int
+
+	// Error code (e.g. "job_not_found") and message from the response body
+	Code      string
+	Message   string
+	RequestID string
+
+	// Additional details, if the orchestrator sent any
+	Details map[string]interface{}
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("API error (%d): %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("API error (%d): %s - %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is reports whether target is the sentinel error for e's status code, so
+// callers can write errors.Is(err, client.ErrNotFound).
+func (e *Error) Is(target error) bool {
+	sentinel, ok := statusErrors[e.StatusCode]
+	return ok && sentinel == target
+}
+
+// parseError converts an error response into an *Error.
+func parseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var errResp protocol.ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error != "" {
+		return &Error{
+			StatusCode: resp.StatusCode,
+			Code:       errResp.Error,
+			Message:    errResp.Message,
+			RequestID:  errResp.RequestID,
+			Details:    errResp.Details,
+		}
+	}
+
+	msg := strings.TrimSpace(string(body))
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: msg}
+}
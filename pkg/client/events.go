@@ -0,0 +1,181 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventResync is sent when the stream could not resume from the requested
+// event ID; the subscriber should reload its state.
+const EventResync = "resync"
+
+// defaultEventRetry is the reconnect delay used until the server suggests one.
+const defaultEventRetry = 3 * time.Second
+
+// Event is an orchestrator event from the event stream.
+type Event struct {
+	ID         uint64                 `json:"id"`
+	Type       string                 `json:"type"`
+	Time       time.Time              `json:"time"`
+	LabID      string                 `json:"lab_id,omitempty"`
+	AgentID    string                 `json:"agent_id,omitempty"`
+	ScenarioID string                 `json:"scenario_id,omitempty"`
+	JobID      string                 `json:"job_id,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventQuery filters the event stream. Empty fields match everything.
+type EventQuery struct {
+	// Types are exact event types ("job.completed") or categories ("job")
+	Types      []string
+	AgentID    string
+	ScenarioID string
+
+	// Resume after this event ID (0 starts with new events)
+	LastEventID uint64
+}
+
+// StreamEvents follows GET /api/events and calls fn for every event until
+// ctx is cancelled or fn returns an error. Dropped connections are resumed
+// from the last event seen; an error before the first connection succeeds
+// is returned.
+func (c *Client) StreamEvents(ctx context.Context, query EventQuery, fn func(Event) error) error {
+	q := url.Values{}
+	setQuery(q, "type", strings.Join(query.Types, ","))
+	setQuery(q, "agent_id", query.AgentID)
+	setQuery(q, "scenario_id", query.ScenarioID)
+
+	s := &eventStream{lastID: query.LastEventID, retry: defaultEventRetry}
+	connected := false
+	for {
+		err := c.readEvents(ctx, q, s, func() { connected = true }, fn)
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.Is(err, errStopped):
+			return s.stopErr
+		case !connected:
+			return err
+		}
+		var apiErr *Error
+		if errors.As(err, &apiErr) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.retry):
+		}
+	}
+}
+
+// errStopped marks a stream stopped by the callback.
+var errStopped = errors.New("stopped")
+
+// eventStream is the resume state carried across reconnects.
+type eventStream struct {
+	lastID  uint64
+	retry   time.Duration
+	stopErr error
+}
+
+// readEvents reads one connection's worth of events.
+func (c *Client) readEvents(ctx context.Context, q url.Values, s *eventStream, onConnect func(), fn func(Event) error) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/events", q, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if s.lastID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(s.lastID, 10))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return parseError(resp)
+	}
+	onConnect()
+
+	var (
+		id, name string
+		data     strings.Builder
+	)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if data.Len() > 0 {
+				e, err := decodeEvent(id, name, data.String())
+				if err != nil {
+					return err
+				}
+				if e.ID > 0 {
+					s.lastID = e.ID
+				}
+				if err := fn(e); err != nil {
+					s.stopErr = err
+					return errStopped
+				}
+			}
+			id, name = "", ""
+			data.Reset()
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "event":
+			name = value
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+				s.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("event stream closed")
+}
+
+// decodeEvent builds an event from an SSE frame. The frame's id and event
+// fields fill in anything the JSON payload leaves out (resync has none).
+func decodeEvent(id, name, data string) (Event, error) {
+	var e Event
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		return e, fmt.Errorf("failed to decode event: %w", err)
+	}
+	if e.Type == "" {
+		e.Type = name
+	}
+	if e.ID == 0 && id != "" {
+		e.ID, _ = strconv.ParseUint(id, 10, 64)
+	}
+	return e, nil
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// JobQuery filters a job listing. Empty fields are not sent.
+type JobQuery struct {
+	ScenarioID string
+	StepID     string
+	AgentID    string
+	RunAs      string
+
+	// Status and ActionType accept several values
+	Status     []string
+	ActionType []string
+
+	Since time.Time
+	Until time.Time
+
+	// Sort is scheduled_at, created_at or updated_at; Order is asc or desc
+	Sort  string
+	Order string
+
+	Limit  int
+	Cursor string
+}
+
+func (q JobQuery) values() url.Values {
+	v := url.Values{}
+	setQuery(v, "scenario_id", q.ScenarioID)
+	setQuery(v, "step_id", q.StepID)
+	setQuery(v, "agent_id", q.AgentID)
+	setQuery(v, "run_as", q.RunAs)
+	setQuery(v, "status", strings.Join(q.Status, ","))
+	setQuery(v, "action_type", strings.Join(q.ActionType, ","))
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", q.Until.Format(time.RFC3339))
+	}
+	setQuery(v, "sort", q.Sort)
+	setQuery(v, "order", q.Order)
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	setQuery(v, "cursor", q.Cursor)
+	return v
+}
+
+// ListJobs returns one page of jobs. Pass the response's NextCursor as
+// JobQuery.Cursor to fetch the next page.
+func (c *Client) ListJobs(ctx context.Context, query JobQuery) (*protocol.ListJobsResponse, error) {
+	var resp protocol.ListJobsResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/jobs", query: query.values()}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetJob returns a single job including its parameters and result.
+func (c *Client) GetJob(ctx context.Context, jobID string) (*protocol.JobResponse, error) {
+	var resp protocol.JobResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: pathf("/api/jobs/%s", jobID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RetryJob requeues a failed or cancelled job.
+func (c *Client) RetryJob(ctx context.Context, jobID string) (*protocol.JobResponse, error) {
+	var resp protocol.JobResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: pathf("/api/jobs/%s/retry", jobID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CancelJob cancels a pending or assigned job.
+func (c *Client) CancelJob(ctx context.Context, jobID string) (*protocol.JobResponse, error) {
+	var resp protocol.JobResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: pathf("/api/jobs/%s/cancel", jobID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// JobStats returns job counts by status.
+func (c *Client) JobStats(ctx context.Context) (map[string]int, error) {
+	var resp map[string]int
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/jobs/stats"}, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// OutboxQuery filters ListOutbox. Zero values are omitted.
+type OutboxQuery struct {
+	Target string
+	Status string
+	Limit  int
+}
+
+// ListOutbox lists scoring and messenger events in the outbox, newest first,
+// together with the number of entries in each status.
+func (c *Client) ListOutbox(ctx context.Context, query OutboxQuery) (*protocol.ListOutboxResponse, error) {
+	q := url.Values{}
+	if query.Target != "" {
+		q.Set("target", query.Target)
+	}
+	if query.Status != "" {
+		q.Set("status", query.Status)
+	}
+	if query.Limit > 0 {
+		q.Set("limit", strconv.Itoa(query.Limit))
+	}
+
+	var resp protocol.ListOutboxResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/outbox", query: q}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetOutboxEntry returns a single outbox entry with its payload.
+func (c *Client) GetOutboxEntry(ctx context.Context, entryID string) (*protocol.OutboxEntryResponse, error) {
+	var resp protocol.OutboxEntryResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: pathf("/api/outbox/%s", entryID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ReplayOutboxEntry delivers a pending or dead-lettered entry again with a
+// fresh attempt budget.
+func (c *Client) ReplayOutboxEntry(ctx context.Context, entryID string) (*protocol.OutboxEntryResponse, error) {
+	var resp protocol.OutboxEntryResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: pathf("/api/outbox/%s/replay", entryID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ReplayOutbox replays every dead-lettered entry, optionally only those for
+// target, and returns how many were replayed.
+func (c *Client) ReplayOutbox(ctx context.Context, target string) (int, error) {
+	var resp protocol.ReplayOutboxResponse
+	req := protocol.ReplayOutboxRequest{Target: target}
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/outbox/replay", body: req}, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Replayed, nil
+}
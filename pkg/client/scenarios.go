@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// Scenario is a scenario record as returned by GET /api/scenarios.
+type Scenario = protocol.ScenarioRecord
+
+// CreateScenario submits a DSL scenario.
+func (c *Client) CreateScenario(ctx context.Context, req protocol.CreateScenarioRequest) (*protocol.CreateScenarioResponse, error) {
+	var resp protocol.CreateScenarioResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/scenarios", body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListScenarios lists scenarios, optionally filtered by status. A zero limit
+// uses the server default.
+func (c *Client) ListScenarios(ctx context.Context, status string, limit int) ([]Scenario, error) {
+	q := url.Values{}
+	setQuery(q, "status", status)
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+
+	var resp []Scenario
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/scenarios", query: q}, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// GetScenario returns a single scenario.
+func (c *Client) GetScenario(ctx context.Context, scenarioID string) (*Scenario, error) {
+	var resp Scenario
+	if err := c.do(ctx, request{method: http.MethodGet, path: pathf("/api/scenarios/%s", scenarioID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetScenarioStatus returns a scenario's status and job progress.
+func (c *Client) GetScenarioStatus(ctx context.Context, scenarioID string) (*protocol.ScenarioStatusResponse, error) {
+	var resp protocol.ScenarioStatusResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: pathf("/api/scenarios/%s/status", scenarioID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetScenarioScorecard returns the grades of a scenario's objectives.
+func (c *Client) GetScenarioScorecard(ctx context.Context, scenarioID string) (*protocol.ScorecardResponse, error) {
+	var resp protocol.ScorecardResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: pathf("/api/scenarios/%s/scorecard", scenarioID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetScenarioTimeline returns every job a scenario ran, in start order.
+func (c *Client) GetScenarioTimeline(ctx context.Context, scenarioID string) (*protocol.TimelineResponse, error) {
+	var resp protocol.TimelineResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: pathf("/api/scenarios/%s/timeline", scenarioID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ExportScenarioTimeline streams a scenario's timeline as JSON lines (format
+// "jsonl"), CSV ("csv") or Elastic Common Schema documents ("ecs"). With bulk,
+// the ECS export is an Elasticsearch _bulk body. The caller must close the
+// reader.
+func (c *Client) ExportScenarioTimeline(ctx context.Context, scenarioID, format string, bulk bool) (io.ReadCloser, error) {
+	q := url.Values{}
+	setQuery(q, "format", format)
+	if bulk {
+		q.Set("bulk", "true")
+	}
+	return c.stream(ctx, pathf("/api/scenarios/%s/timeline", scenarioID), q)
+}
+
+// GetScenarioReport returns the after-action report of a scenario.
+func (c *Client) GetScenarioReport(ctx context.Context, scenarioID string) (*protocol.ScenarioReport, error) {
+	var resp protocol.ScenarioReport
+	if err := c.do(ctx, request{method: http.MethodGet, path: pathf("/api/scenarios/%s/report", scenarioID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ExportScenarioReport streams the after-action report of a scenario in
+// format "json", "md" or "html". The caller must close the reader.
+func (c *Client) ExportScenarioReport(ctx context.Context, scenarioID, format string) (io.ReadCloser, error) {
+	q := url.Values{}
+	setQuery(q, "format", format)
+	return c.stream(ctx, pathf("/api/scenarios/%s/report", scenarioID), q)
+}
+
+// SetScenarioScoringRun attaches a scoring engine run to a scenario: runID
+// if given, otherwise one the orchestrator creates.
+func (c *Client) SetScenarioScoringRun(ctx context.Context, scenarioID, runID string) (*protocol.ScoringRunResponse, error) {
+	req := protocol.SetScoringRunRequest{RunID: runID, Create: runID == ""}
+
+	var resp protocol.ScoringRunResponse
+	if err := c.do(ctx, request{method: http.MethodPut, path: pathf("/api/scenarios/%s/scoring-run", scenarioID), body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteScenario cancels a scenario's pending jobs and deletes it.
+func (c *Client) DeleteScenario(ctx context.Context, scenarioID string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: pathf("/api/scenarios/%s", scenarioID)}, nil)
+}
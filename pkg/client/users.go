@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// ListUsers lists impersonation users, optionally in one department.
+func (c *Client) ListUsers(ctx context.Context, department string) ([]protocol.ImpersonationUserResponse, error) {
+	q := url.Values{}
+	setQuery(q, "department", department)
+
+	var resp protocol.ListImpersonationUsersResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/users", query: q}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Users, nil
+}
+
+// GetUser returns a single impersonation user.
+func (c *Client) GetUser(ctx context.Context, userID string) (*protocol.ImpersonationUserResponse, error) {
+	var resp protocol.ImpersonationUserResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: pathf("/api/users/%s", userID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateUser creates an impersonation user.
+func (c *Client) CreateUser(ctx context.Context, req protocol.CreateImpersonationUserRequest) (*protocol.ImpersonationUserResponse, error) {
+	var resp protocol.ImpersonationUserResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/users", body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// BulkCreateUsers creates several users. Per-user failures are reported in
+// the response rather than as an error.
+func (c *Client) BulkCreateUsers(ctx context.Context, req protocol.BulkCreateImpersonationUsersRequest) (*protocol.BulkCreateImpersonationUsersResponse, error) {
+	var resp protocol.BulkCreateImpersonationUsersResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/users/bulk", body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateUser updates an impersonation user.
+func (c *Client) UpdateUser(ctx context.Context, userID string, req protocol.UpdateImpersonationUserRequest) (*protocol.ImpersonationUserResponse, error) {
+	var resp protocol.ImpersonationUserResponse
+	if err := c.do(ctx, request{method: http.MethodPut, path: pathf("/api/users/%s", userID), body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteUser deletes an impersonation user.
+func (c *Client) DeleteUser(ctx context.Context, userID string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: pathf("/api/users/%s", userID)}, nil)
+}
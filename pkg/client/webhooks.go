@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"cymbytes.com/cymconductor/pkg/protocol"
+)
+
+// ListWebhooks lists webhook subscriptions. Secrets are not included.
+func (c *Client) ListWebhooks(ctx context.Context) ([]protocol.WebhookResponse, error) {
+	var resp protocol.ListWebhooksResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: "/api/webhooks"}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Webhooks, nil
+}
+
+// GetWebhook returns a single webhook subscription.
+func (c *Client) GetWebhook(ctx context.Context, webhookID string) (*protocol.WebhookResponse, error) {
+	var resp protocol.WebhookResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: pathf("/api/webhooks/%s", webhookID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateWebhook creates a webhook subscription. The secret is only returned
+// here (and when it is rotated).
+func (c *Client) CreateWebhook(ctx context.Context, req protocol.CreateWebhookRequest) (*protocol.WebhookResponse, error) {
+	var resp protocol.WebhookResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: "/api/webhooks", body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpdateWebhook updates a webhook subscription or rotates its secret.
+func (c *Client) UpdateWebhook(ctx context.Context, webhookID string, req protocol.UpdateWebhookRequest) (*protocol.WebhookResponse, error) {
+	var resp protocol.WebhookResponse
+	if err := c.do(ctx, request{method: http.MethodPut, path: pathf("/api/webhooks/%s", webhookID), body: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteWebhook deletes a webhook subscription and its delivery history.
+func (c *Client) DeleteWebhook(ctx context.Context, webhookID string) error {
+	return c.do(ctx, request{method: http.MethodDelete, path: pathf("/api/webhooks/%s", webhookID)}, nil)
+}
+
+// ListWebhookDeliveries lists a subscription's recent deliveries, newest
+// first. A limit of 0 uses the server default.
+func (c *Client) ListWebhookDeliveries(ctx context.Context, webhookID string, limit int) ([]protocol.WebhookDeliveryResponse, error) {
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+
+	var resp protocol.ListWebhookDeliveriesResponse
+	if err := c.do(ctx, request{method: http.MethodGet, path: pathf("/api/webhooks/%s/deliveries", webhookID), query: q}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Deliveries, nil
+}
+
+// TestWebhook sends a test event to a subscription and returns the delivery.
+func (c *Client) TestWebhook(ctx context.Context, webhookID string) (*protocol.WebhookDeliveryResponse, error) {
+	var resp protocol.WebhookDeliveryResponse
+	if err := c.do(ctx, request{method: http.MethodPost, path: pathf("/api/webhooks/%s/test", webhookID)}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -19,6 +19,23 @@ const (
 
 	// ActionSimulateProcessActivity simulates running processes from an approved list
 	ActionSimulateProcessActivity ActionType = "simulate_process_activity"
+
+	// ActionEmailReceive lists, reads or extracts received emails (e.g. to
+	// play a user opening a phishing attachment)
+	ActionEmailReceive ActionType = "email_receive"
+
+	// ActionObserveProcessState checks whether a process is running
+	ActionObserveProcessState ActionType = "observe_process_state"
+
+	// ActionObserveFileState checks whether a file exists, was deleted or changed
+	ActionObserveFileState ActionType = "observe_file_state"
+
+	// ActionObserveUserState checks whether a domain account is enabled
+	ActionObserveUserState ActionType = "observe_user_state"
+
+	// ActionCapturePowerShellHistory collects PowerShell history lines
+	// matching a pattern as evidence of response actions
+	ActionCapturePowerShellHistory ActionType = "capture_powershell_history"
 )
 
 // AllowedActions is the complete list of approved action types.
@@ -28,6 +45,11 @@ var AllowedActions = []ActionType{
 	ActionSimulateFileActivity,
 	ActionSimulateEmailTraffic,
 	ActionSimulateProcessActivity,
+	ActionEmailReceive,
+	ActionObserveProcessState,
+	ActionObserveFileState,
+	ActionObserveUserState,
+	ActionCapturePowerShellHistory,
 }
 
 // IsValidAction checks if an action type is in the approved list.
@@ -141,6 +163,118 @@ type SimulateProcessActivityParams struct {
 	Interact bool `json:"interact,omitempty"`
 }
 
+// EmailReceiveParams defines parameters for receiving email. Connection
+// settings left empty use the agent's configured defaults.
+type EmailReceiveParams struct {
+	// Operation: list, read, extract (save attachments) or execute (save and
+	// open attachments, if the agent allows it); default list
+	Operation string `json:"operation,omitempty" validate:"omitempty,oneof=list read extract execute"`
+
+	// Backend: auto, imap or outlook (Windows only); default auto
+	Backend string `json:"backend,omitempty" validate:"omitempty,oneof=auto imap outlook"`
+
+	// Mail server hostname (IMAP)
+	Server string `json:"server,omitempty" validate:"omitempty,hostname|ip"`
+
+	// Mail server port (IMAP)
+	Port int `json:"port,omitempty" validate:"omitempty,min=1,max=65535"`
+
+	// Username for authentication (IMAP)
+	Username string `json:"username,omitempty"`
+
+	// Password for authentication (IMAP)
+	Password string `json:"password,omitempty"`
+
+	// Use TLS/SSL (IMAP)
+	UseTLS *bool `json:"use_tls,omitempty"`
+
+	// Folder to search (default INBOX)
+	Folder string `json:"folder,omitempty" validate:"omitempty,max=255"`
+
+	// Only emails whose subject contains this text
+	Subject string `json:"subject,omitempty" validate:"omitempty,max=255"`
+
+	// Only emails whose sender contains this text
+	Sender string `json:"sender,omitempty" validate:"omitempty,max=255"`
+
+	// Only emails received after this time (RFC 3339)
+	Since string `json:"since,omitempty" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+
+	// Only emails received before this time (RFC 3339)
+	Before string `json:"before,omitempty" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+
+	// Only unread (true) or read (false) emails
+	Unread *bool `json:"unread,omitempty"`
+
+	// Only emails with (true) or without (false) attachments
+	HasAttachment *bool `json:"has_attachment,omitempty"`
+
+	// Most emails to list (1-100)
+	MaxResults int `json:"max_results,omitempty" validate:"omitempty,min=1,max=100"`
+
+	// Message to read; default the first matching email
+	MessageID string `json:"message_id,omitempty"`
+
+	// Directory attachments are saved to (required for extract and execute;
+	// must also be allowed by the agent's configuration)
+	SaveDirectory string `json:"save_directory,omitempty"`
+}
+
+// ObserveProcessStateParams defines parameters for observing a process.
+type ObserveProcessStateParams struct {
+	// Image name of the process (e.g., "malware.exe")
+	ProcessName string `json:"process_name" validate:"required,max=260"`
+
+	// Expected state: running or not_running (default not_running)
+	ExpectedState string `json:"expected_state,omitempty" validate:"omitempty,oneof=running not_running"`
+
+	// Whether to check processes from all users (default true)
+	CheckAllUsers *bool `json:"check_all_users,omitempty"`
+}
+
+// ObserveFileStateParams defines parameters for observing a file.
+type ObserveFileStateParams struct {
+	// Path of the file or directory to check
+	FilePath string `json:"file_path" validate:"required,max=1024"`
+
+	// Expected state: exists, deleted or modified (default deleted)
+	ExpectedState string `json:"expected_state,omitempty" validate:"omitempty,oneof=exists deleted modified"`
+
+	// Whether to report the file's SHA-256 hash
+	CheckHash bool `json:"check_hash,omitempty"`
+
+	// SHA-256 hash (hex) the file is compared with; a different hash means
+	// the file was modified
+	ExpectedHash string `json:"expected_hash,omitempty" validate:"omitempty,len=64,hexadecimal"`
+}
+
+// ObserveUserStateParams defines parameters for observing a domain account
+// (Windows agents only).
+type ObserveUserStateParams struct {
+	// sAMAccountName or UPN of the account
+	Username string `json:"username" validate:"required,max=104"`
+
+	// Domain controller or domain to query (default the agent's domain)
+	Domain string `json:"domain,omitempty" validate:"omitempty,max=255"`
+
+	// Expected state: enabled or disabled (default disabled)
+	ExpectedState string `json:"expected_state,omitempty" validate:"omitempty,oneof=enabled disabled"`
+}
+
+// CapturePowerShellHistoryParams defines parameters for collecting
+// PowerShell history (Windows agents only).
+type CapturePowerShellHistoryParams struct {
+	// Regular expression selecting commands; default matches common response
+	// actions such as Stop-Process and Disable-ADAccount
+	CommandPattern string `json:"command_pattern,omitempty" validate:"omitempty,max=1000"`
+
+	// Scope: current_user or all_users (default all_users)
+	Scope string `json:"scope,omitempty" validate:"omitempty,oneof=current_user all_users"`
+
+	// Most history lines to read per file (default 1000)
+	MaxLines int `json:"max_lines,omitempty" validate:"omitempty,min=1,max=100000"`
+}
+
 // ApprovedProcessesWindows is the whitelist of processes that can be spawned on Windows.
 var ApprovedProcessesWindows = []string{
 	"notepad.exe",
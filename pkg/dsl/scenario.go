@@ -35,6 +35,9 @@ type Scenario struct {
 
 	// Schedule configuration
 	Schedule Schedule `json:"schedule" validate:"required"`
+
+	// Objectives the scenario is graded on (optional)
+	Objectives []Objective `json:"objectives,omitempty" validate:"omitempty,max=50,dive"`
 }
 
 // Step represents a single action within a scenario.
@@ -122,6 +125,56 @@ type Schedule struct {
 	RepeatIntervalSeconds int `json:"repeat_interval_seconds,omitempty" validate:"omitempty,min=0,max=86400"`
 }
 
+// Objective is a graded assertion over the results of one or more
+// observation steps, such as "the malicious process was stopped".
+type Objective struct {
+	// Unique identifier for this objective (e.g., "contain-malware")
+	ID string `json:"id" validate:"required,min=1,max=64"`
+
+	// Human-readable name
+	Name string `json:"name" validate:"required,min=1,max=255"`
+
+	// Optional description
+	Description string `json:"description,omitempty" validate:"omitempty,max=2000"`
+
+	// IDs of the steps whose results are checked
+	StepIDs []string `json:"step_ids" validate:"required,min=1,max=20"`
+
+	// Predicates that must all hold on a result, such as "state_matches == true"
+	// or "match_count >= 3"; default is DefaultObjectiveAssertion of each step's
+	// action type
+	Assert []string `json:"assert,omitempty" validate:"omitempty,max=10,dive,min=1,max=200"`
+
+	// Which results must pass: all (every job of every step, the default) or
+	// any (at least one)
+	Require string `json:"require,omitempty" validate:"omitempty,oneof=all any"`
+
+	// Weight in the scenario's score (default 1)
+	Weight int `json:"weight,omitempty" validate:"omitempty,min=1,max=100"`
+
+	// Seconds after the scenario starts by which the objective must pass
+	// (0 means it is graded once its steps have finished)
+	DeadlineSeconds int `json:"deadline_seconds,omitempty" validate:"omitempty,min=1,max=86400"`
+
+	// Seconds between re-running a step whose result does not pass yet, until
+	// the deadline (0 disables re-runs; requires deadline_seconds)
+	RetryIntervalSeconds int `json:"retry_interval_seconds,omitempty" validate:"omitempty,min=10,max=3600"`
+}
+
+// DefaultObjectiveAssertion returns the assertion an objective applies to an
+// action's results when it lists none, or "" if the action has no default:
+// state checks must report state_matches and evidence captures has_matches.
+func DefaultObjectiveAssertion(action ActionType) string {
+	switch action {
+	case ActionObserveProcessState, ActionObserveFileState, ActionObserveUserState:
+		return "state_matches == true"
+	case ActionCapturePowerShellHistory:
+		return "has_matches == true"
+	default:
+		return ""
+	}
+}
+
 // Condition for conditional step execution (v2 feature, stubbed for now).
 type Condition struct {
 	// Type of condition: previous_success, time_window, agent_count
@@ -134,6 +187,9 @@ type Condition struct {
 // Intent represents the high-level lab intent submitted by users/API.
 // This is the INPUT to the AI planner.
 type Intent struct {
+	// Lab the scenario is planned for (defaults to "default")
+	LabID string `json:"lab_id,omitempty" validate:"omitempty,max=64"`
+
 	// Lab type identifier
 	LabType string `json:"lab_type" validate:"required"`
 
@@ -203,6 +259,41 @@ func (s *Step) ParseParameters() (interface{}, error) {
 		}
 		return &params, nil
 
+	case ActionEmailReceive:
+		var params EmailReceiveParams
+		if err := json.Unmarshal(s.Parameters, &params); err != nil {
+			return nil, err
+		}
+		return &params, nil
+
+	case ActionObserveProcessState:
+		var params ObserveProcessStateParams
+		if err := json.Unmarshal(s.Parameters, &params); err != nil {
+			return nil, err
+		}
+		return &params, nil
+
+	case ActionObserveFileState:
+		var params ObserveFileStateParams
+		if err := json.Unmarshal(s.Parameters, &params); err != nil {
+			return nil, err
+		}
+		return &params, nil
+
+	case ActionObserveUserState:
+		var params ObserveUserStateParams
+		if err := json.Unmarshal(s.Parameters, &params); err != nil {
+			return nil, err
+		}
+		return &params, nil
+
+	case ActionCapturePowerShellHistory:
+		var params CapturePowerShellHistoryParams
+		if err := json.Unmarshal(s.Parameters, &params); err != nil {
+			return nil, err
+		}
+		return &params, nil
+
 	default:
 		return nil, nil
 	}
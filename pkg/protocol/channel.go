@@ -0,0 +1,126 @@
+// Package protocol defines the HTTP API request and response types.
+package protocol
+
+import "encoding/json"
+
+// ============================================================
+// Job Delivery Transports
+// ============================================================
+
+// Transports an agent can use to receive jobs. The orchestrator advertises the
+// ones it supports at registration; agents fall back in this order.
+const (
+	// TransportWebSocket is a persistent channel carrying heartbeats, job
+	// assignments, results and commands in both directions.
+	TransportWebSocket = "websocket"
+
+	// TransportLongPoll blocks GET jobs/next until a job is due or the wait expires.
+	TransportLongPoll = "long_poll"
+
+	// TransportPoll is the original heartbeat + GET jobs/next polling loop.
+	TransportPoll = "poll"
+)
+
+// ============================================================
+// Agent Channel (WebSocket)
+// ============================================================
+
+// Channel message types.
+const (
+	// MessageHeartbeat is sent by the agent with a HeartbeatRequest payload.
+	MessageHeartbeat = "heartbeat"
+
+	// MessageHeartbeatAck is sent by the orchestrator with a HeartbeatResponse payload.
+	MessageHeartbeatAck = "heartbeat_ack"
+
+	// MessagePoll is sent by the agent with a PollMessage payload when it is
+	// ready for more jobs. The orchestrator answers with MessageJobs as soon
+	// as a job is due.
+	MessagePoll = "poll"
+
+	// MessageJobs is sent by the orchestrator with a GetJobsResponse payload.
+	MessageJobs = "jobs"
+
+	// MessageJobResult is sent by the agent with a JobResultMessage payload.
+	MessageJobResult = "job_result"
+
+	// MessageJobResultAck is sent by the orchestrator with a JobResultAckMessage payload.
+	MessageJobResultAck = "job_result_ack"
+
+	// MessageCommand is sent by the orchestrator with an AgentCommand payload.
+	MessageCommand = "command"
+
+	// MessageCommandAck is sent by the agent with a CommandAckMessage payload
+	// once it has handled a command.
+	MessageCommandAck = "command_ack"
+
+	// MessageError is sent by the orchestrator with an ErrorResponse payload.
+	MessageError = "error"
+)
+
+// Agent command types.
+const (
+	// CommandReregister asks the agent to register again, e.g. after it was
+	// removed from the orchestrator.
+	CommandReregister = "reregister"
+)
+
+// ChannelMessage is the envelope for every message on the agent channel.
+type ChannelMessage struct {
+	// Message type (see Message* constants)
+	Type string `json:"type"`
+
+	// Type-specific payload
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// PollMessage tells the orchestrator the agent is ready for jobs.
+type PollMessage struct {
+	// Maximum number of jobs to assign (default: 5)
+	Max int `json:"max,omitempty"`
+}
+
+// JobResultMessage carries a job result over the agent channel.
+type JobResultMessage struct {
+	// Job the result belongs to
+	JobID string `json:"job_id"`
+
+	// The result, as for POST jobs/{jobID}/result
+	Result JobResultRequest `json:"result"`
+}
+
+// JobResultAckMessage acknowledges a JobResultMessage.
+type JobResultAckMessage struct {
+	// Job the acknowledgment belongs to
+	JobID string `json:"job_id"`
+
+	JobResultResponse
+}
+
+// CommandAckMessage acknowledges an AgentCommand.
+type CommandAckMessage struct {
+	// ID of the acknowledged command
+	CommandID string `json:"command_id"`
+
+	// Command type
+	Type string `json:"type"`
+
+	// Whether the agent carried out the command
+	Success bool `json:"success"`
+
+	// Error message if the command failed
+	Error string `json:"error,omitempty"`
+}
+
+// NewChannelMessage builds a channel message with a JSON-encoded payload.
+func NewChannelMessage(msgType string, payload interface{}) (*ChannelMessage, error) {
+	msg := &ChannelMessage{Type: msgType}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		msg.Payload = data
+	}
+	return msg, nil
+}
@@ -0,0 +1,486 @@
+package protocol
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================
+// API Description
+// ============================================================
+
+// Roles an endpoint can require (see Endpoint.Role). These are the API key
+// roles, from least to most privileged.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// Endpoint describes one API operation. Endpoints is the single list of
+// operations the orchestrator serves; the OpenAPI document is generated from
+// it and the request and response types in this package.
+type Endpoint struct {
+	Method  string
+	Path    string
+	Tag     string
+	Summary string
+
+	// Minimum role, or empty for endpoints that take no API key
+	Role string
+
+	// Query parameters
+	Query []Param
+
+	// Request body and success response, as zero values of their types (nil
+	// for none)
+	Request  interface{}
+	Response interface{}
+
+	// Success status (default 200)
+	Status int
+
+	// Response content type when the response is not JSON
+	ContentType string
+}
+
+// Param is a query parameter.
+type Param struct {
+	Name        string
+	Type        string // string, integer or boolean
+	Description string
+}
+
+// auditParams are the audit log filters shared by list and export.
+var auditParams = []Param{
+	{"entity_type", "string", "Entity type (agent, scenario, job, user, lab, api_key, webhook_subscription, outbox_entry)"},
+	{"entity_id", "string", "Entity ID"},
+	{"action", "string", "Action (create, update, delete, ...)"},
+	{"actor", "string", "Actor, e.g. key:<name>"},
+	{"since", "string", "Only entries at or after this RFC 3339 time"},
+	{"until", "string", "Only entries before this RFC 3339 time"},
+}
+
+// Endpoints lists every API operation.
+var Endpoints = []Endpoint{
+	// Agent protocol
+	{Method: http.MethodPost, Path: "/api/agents/register", Tag: "agent-protocol", Summary: "Register an agent",
+		Request: RegisterAgentRequest{}, Response: RegisterAgentResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/api/agents/{agentID}/heartbeat", Tag: "agent-protocol", Summary: "Send an agent heartbeat",
+		Request: HeartbeatRequest{}, Response: HeartbeatResponse{}},
+	{Method: http.MethodGet, Path: "/api/agents/{agentID}/jobs/next", Tag: "agent-protocol", Summary: "Fetch due jobs (204 when there are none)",
+		Query: []Param{
+			{"max", "integer", "Maximum number of jobs (default 5)"},
+			{"wait", "integer", "Seconds to wait for a job to become due (long poll)"},
+		},
+		Response: GetJobsResponse{}},
+	{Method: http.MethodPost, Path: "/api/agents/{agentID}/jobs/{jobID}/result", Tag: "agent-protocol", Summary: "Report a job result",
+		Request: JobResultRequest{}, Response: JobResultResponse{}},
+	{Method: http.MethodGet, Path: "/api/agents/{agentID}/ws", Tag: "agent-protocol", Summary: "Open the agent WebSocket channel (ChannelMessage envelopes)",
+		Status: http.StatusSwitchingProtocols},
+
+	// Agents
+	{Method: http.MethodGet, Path: "/api/agents", Tag: "agents", Summary: "List agents", Role: RoleViewer,
+		Response: ListAgentsResponse{}},
+	{Method: http.MethodGet, Path: "/api/agents/{agentID}", Tag: "agents", Summary: "Get an agent", Role: RoleViewer,
+		Response: AgentInfo{}},
+	{Method: http.MethodDelete, Path: "/api/agents/{agentID}", Tag: "agents", Summary: "Delete an agent", Role: RoleAdmin,
+		Status: http.StatusNoContent},
+
+	// Scenarios
+	{Method: http.MethodPost, Path: "/api/scenarios", Tag: "scenarios", Summary: "Create a scenario", Role: RoleOperator,
+		Request: CreateScenarioRequest{}, Response: CreateScenarioResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/scenarios", Tag: "scenarios", Summary: "List scenarios", Role: RoleViewer,
+		Query: []Param{
+			{"status", "string", "Only scenarios in this status"},
+			{"limit", "integer", "Maximum number of scenarios (default 50)"},
+		},
+		Response: []ScenarioRecord{}},
+	{Method: http.MethodGet, Path: "/api/scenarios/{scenarioID}", Tag: "scenarios", Summary: "Get a scenario", Role: RoleViewer,
+		Response: ScenarioRecord{}},
+	{Method: http.MethodGet, Path: "/api/scenarios/{scenarioID}/status", Tag: "scenarios", Summary: "Get a scenario's progress", Role: RoleViewer,
+		Response: ScenarioStatusResponse{}},
+	{Method: http.MethodGet, Path: "/api/scenarios/{scenarioID}/scorecard", Tag: "scenarios", Summary: "Grade a scenario's objectives", Role: RoleViewer,
+		Response: ScorecardResponse{}},
+	{Method: http.MethodGet, Path: "/api/scenarios/{scenarioID}/timeline", Tag: "scenarios", Summary: "Get the activity a scenario generated, in time order", Role: RoleViewer,
+		Query: []Param{
+			{"format", "string", "json (default), jsonl, csv or ecs (Elastic Common Schema JSON lines)"},
+			{"bulk", "boolean", "With format=ecs, prefix each document with an Elasticsearch _bulk create action"},
+		},
+		Response: TimelineResponse{}},
+	{Method: http.MethodGet, Path: "/api/scenarios/{scenarioID}/report", Tag: "scenarios", Summary: "Download a scenario's after-action report", Role: RoleViewer,
+		Query:    []Param{{"format", "string", "json (default), md or html (self-contained)"}},
+		Response: ScenarioReport{}},
+	{Method: http.MethodDelete, Path: "/api/scenarios/{scenarioID}", Tag: "scenarios", Summary: "Cancel and delete a scenario", Role: RoleOperator,
+		Status: http.StatusNoContent},
+	{Method: http.MethodPut, Path: "/api/scenarios/{scenarioID}/scoring-run", Tag: "scenarios", Summary: "Attach an existing or newly created scoring engine run", Role: RoleOperator,
+		Request: SetScoringRunRequest{}, Response: ScoringRunResponse{}},
+
+	// Jobs
+	{Method: http.MethodGet, Path: "/api/jobs", Tag: "jobs", Summary: "List jobs", Role: RoleViewer,
+		Query: []Param{
+			{"scenario_id", "string", "Scenario ID"},
+			{"step_id", "string", "Scenario step ID"},
+			{"agent_id", "string", "Agent ID"},
+			{"run_as", "string", "Impersonated user"},
+			{"status", "string", "Comma-separated statuses"},
+			{"action_type", "string", "Comma-separated action types"},
+			{"since", "string", "Only jobs scheduled at or after this RFC 3339 time"},
+			{"until", "string", "Only jobs scheduled before this RFC 3339 time"},
+			{"sort", "string", "scheduled_at, created_at or updated_at"},
+			{"order", "string", "asc or desc"},
+			{"limit", "integer", "Page size"},
+			{"cursor", "string", "next_cursor from the previous page"},
+		},
+		Response: ListJobsResponse{}},
+	{Method: http.MethodGet, Path: "/api/jobs/stats", Tag: "jobs", Summary: "Count jobs by status", Role: RoleViewer,
+		Response: map[string]int{}},
+	{Method: http.MethodGet, Path: "/api/jobs/{jobID}", Tag: "jobs", Summary: "Get a job", Role: RoleViewer,
+		Response: JobResponse{}},
+	{Method: http.MethodPost, Path: "/api/jobs/{jobID}/retry", Tag: "jobs", Summary: "Requeue a failed or cancelled job", Role: RoleOperator,
+		Response: JobResponse{}},
+	{Method: http.MethodPost, Path: "/api/jobs/{jobID}/cancel", Tag: "jobs", Summary: "Cancel a pending or assigned job", Role: RoleOperator,
+		Response: JobResponse{}},
+
+	// Events
+	{Method: http.MethodGet, Path: "/api/events", Tag: "events", Summary: "Stream events (server-sent events; resume with Last-Event-ID)", Role: RoleViewer,
+		Query: []Param{
+			{"type", "string", "Comma-separated event types"},
+			{"agent_id", "string", "Agent ID"},
+			{"scenario_id", "string", "Scenario ID"},
+		},
+		ContentType: "text/event-stream"},
+
+	// Debug
+	{Method: http.MethodPost, Path: "/api/debug/test-job", Tag: "debug", Summary: "Create a file activity test job for the first registered agent", Role: RoleAdmin,
+		Response: CreateTestJobResponse{}, Status: http.StatusCreated},
+
+	// Impersonation users
+	{Method: http.MethodGet, Path: "/api/users", Tag: "users", Summary: "List impersonation users", Role: RoleViewer,
+		Query:    []Param{{"department", "string", "Only users in this department"}},
+		Response: ListImpersonationUsersResponse{}},
+	{Method: http.MethodPost, Path: "/api/users", Tag: "users", Summary: "Create an impersonation user", Role: RoleAdmin,
+		Request: CreateImpersonationUserRequest{}, Response: ImpersonationUserResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/api/users/bulk", Tag: "users", Summary: "Create impersonation users in bulk", Role: RoleAdmin,
+		Request: BulkCreateImpersonationUsersRequest{}, Response: BulkCreateImpersonationUsersResponse{}},
+	{Method: http.MethodGet, Path: "/api/users/{userID}", Tag: "users", Summary: "Get an impersonation user", Role: RoleViewer,
+		Response: ImpersonationUserResponse{}},
+	{Method: http.MethodPut, Path: "/api/users/{userID}", Tag: "users", Summary: "Update an impersonation user", Role: RoleAdmin,
+		Request: UpdateImpersonationUserRequest{}, Response: ImpersonationUserResponse{}},
+	{Method: http.MethodDelete, Path: "/api/users/{userID}", Tag: "users", Summary: "Delete an impersonation user", Role: RoleAdmin,
+		Status: http.StatusNoContent},
+
+	// Labs
+	{Method: http.MethodGet, Path: "/api/labs", Tag: "labs", Summary: "List labs", Role: RoleViewer,
+		Response: ListLabsResponse{}},
+	{Method: http.MethodPost, Path: "/api/labs", Tag: "labs", Summary: "Create a lab", Role: RoleAdmin,
+		Request: CreateLabRequest{}, Response: LabResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/labs/{labID}", Tag: "labs", Summary: "Get a lab", Role: RoleViewer,
+		Response: LabResponse{}},
+	{Method: http.MethodPut, Path: "/api/labs/{labID}", Tag: "labs", Summary: "Update a lab", Role: RoleAdmin,
+		Request: UpdateLabRequest{}, Response: LabResponse{}},
+	{Method: http.MethodDelete, Path: "/api/labs/{labID}", Tag: "labs", Summary: "Delete an empty lab", Role: RoleAdmin,
+		Status: http.StatusNoContent},
+
+	// Webhook subscriptions
+	{Method: http.MethodGet, Path: "/api/webhooks", Tag: "webhooks", Summary: "List webhook subscriptions", Role: RoleAdmin,
+		Response: ListWebhooksResponse{}},
+	{Method: http.MethodPost, Path: "/api/webhooks", Tag: "webhooks", Summary: "Create a webhook subscription (the secret is only returned here)", Role: RoleAdmin,
+		Request: CreateWebhookRequest{}, Response: WebhookResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/webhooks/{webhookID}", Tag: "webhooks", Summary: "Get a webhook subscription", Role: RoleAdmin,
+		Response: WebhookResponse{}},
+	{Method: http.MethodPut, Path: "/api/webhooks/{webhookID}", Tag: "webhooks", Summary: "Update a webhook subscription or rotate its secret", Role: RoleAdmin,
+		Request: UpdateWebhookRequest{}, Response: WebhookResponse{}},
+	{Method: http.MethodDelete, Path: "/api/webhooks/{webhookID}", Tag: "webhooks", Summary: "Delete a webhook subscription and its history", Role: RoleAdmin,
+		Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/webhooks/{webhookID}/deliveries", Tag: "webhooks", Summary: "List recent deliveries, newest first", Role: RoleAdmin,
+		Query:    []Param{{"limit", "integer", "Maximum number of deliveries (default 100)"}},
+		Response: ListWebhookDeliveriesResponse{}},
+	{Method: http.MethodPost, Path: "/api/webhooks/{webhookID}/test", Tag: "webhooks", Summary: "Send a webhook.test event once and return the delivery", Role: RoleAdmin,
+		Response: WebhookDeliveryResponse{}},
+
+	// Outbox
+	{Method: http.MethodGet, Path: "/api/outbox", Tag: "outbox", Summary: "List scoring and messenger events in the outbox, newest first", Role: RoleAdmin,
+		Query: []Param{
+			{"target", "string", "Filter by target (scoring, messenger)"},
+			{"status", "string", "Filter by status (pending, delivered, dead)"},
+			{"limit", "integer", "Maximum number of entries (default 100, max 1000)"},
+		},
+		Response: ListOutboxResponse{}},
+	{Method: http.MethodPost, Path: "/api/outbox/replay", Tag: "outbox", Summary: "Replay every dead-lettered entry", Role: RoleAdmin,
+		Request: ReplayOutboxRequest{}, Response: ReplayOutboxResponse{}},
+	{Method: http.MethodGet, Path: "/api/outbox/{entryID}", Tag: "outbox", Summary: "Get an outbox entry with its payload", Role: RoleAdmin,
+		Response: OutboxEntryResponse{}},
+	{Method: http.MethodPost, Path: "/api/outbox/{entryID}/replay", Tag: "outbox", Summary: "Deliver a pending or dead-lettered entry again with a fresh attempt budget", Role: RoleAdmin,
+		Response: OutboxEntryResponse{}},
+
+	// Audit log
+	{Method: http.MethodGet, Path: "/api/audit", Tag: "audit", Summary: "List audit entries, newest first", Role: RoleAdmin,
+		Query: append(append([]Param{}, auditParams...),
+			Param{"limit", "integer", "Page size (default 100, max 1000)"},
+			Param{"cursor", "string", "next_cursor from the previous page"},
+		),
+		Response: ListAuditResponse{}},
+	{Method: http.MethodGet, Path: "/api/audit/export", Tag: "audit", Summary: "Export audit entries, oldest first", Role: RoleAdmin,
+		Query:       append(append([]Param{}, auditParams...), Param{"format", "string", "jsonl (default) or csv"}),
+		ContentType: "application/x-ndjson"},
+
+	// Administration
+	{Method: http.MethodGet, Path: "/api/admin/backup", Tag: "admin", Summary: "Download an online database backup", Role: RoleAdmin,
+		ContentType: "application/vnd.sqlite3"},
+	{Method: http.MethodGet, Path: "/api/admin/export", Tag: "admin", Summary: "Export labs, users and validated scenarios", Role: RoleAdmin,
+		Response: StateExport{}},
+	{Method: http.MethodPost, Path: "/api/admin/import", Tag: "admin", Summary: "Import a state export", Role: RoleAdmin,
+		Query:   []Param{{"overwrite", "boolean", "Replace existing labs and users"}},
+		Request: StateExport{}, Response: ImportStateResponse{}},
+
+	// Authentication
+	{Method: http.MethodGet, Path: "/api/auth/whoami", Tag: "auth", Summary: "Describe the caller", Role: RoleViewer,
+		Response: WhoAmIResponse{}},
+	{Method: http.MethodGet, Path: "/api/auth/keys", Tag: "auth", Summary: "List API keys", Role: RoleAdmin,
+		Response: ListAPIKeysResponse{}},
+	{Method: http.MethodPost, Path: "/api/auth/keys", Tag: "auth", Summary: "Create an API key", Role: RoleAdmin,
+		Request: CreateAPIKeyRequest{}, Response: CreateAPIKeyResponse{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/api/auth/keys/{keyID}", Tag: "auth", Summary: "Revoke an API key", Role: RoleAdmin,
+		Status: http.StatusNoContent},
+
+	// Health
+	{Method: http.MethodGet, Path: "/health", Tag: "health", Summary: "Health check",
+		Response: HealthResponse{}},
+	{Method: http.MethodGet, Path: "/ready", Tag: "health", Summary: "Readiness check",
+		Response: map[string]bool{}},
+	{Method: http.MethodGet, Path: "/api/openapi.json", Tag: "health", Summary: "This document",
+		ContentType: "application/json"},
+	{Method: http.MethodGet, Path: "/metrics", Tag: "health", Summary: "Prometheus metrics", Role: RoleViewer,
+		ContentType: "text/plain; version=0.0.4"},
+}
+
+// ============================================================
+// OpenAPI Document
+// ============================================================
+
+// OpenAPI returns the OpenAPI 3.0 document for Endpoints, ready to be encoded
+// as JSON.
+func OpenAPI(version string) map[string]interface{} {
+	g := &schemaGen{schemas: map[string]interface{}{}}
+	errorSchema := g.schema(reflect.TypeOf(ErrorResponse{}))
+
+	paths := map[string]map[string]interface{}{}
+	for _, ep := range Endpoints {
+		op := map[string]interface{}{
+			"operationId": operationID(ep),
+			"summary":     ep.Summary,
+			"tags":        []string{ep.Tag},
+		}
+
+		var params []interface{}
+		for _, name := range pathParams(ep.Path) {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, p := range ep.Query {
+			params = append(params, map[string]interface{}{
+				"name": p.Name, "in": "query", "description": p.Description,
+				"schema": map[string]interface{}{"type": p.Type},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+
+		if ep.Request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(ep.Request))},
+				},
+			}
+		}
+
+		status := ep.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		switch {
+		case ep.Response != nil:
+			success["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(ep.Response))},
+			}
+		case ep.ContentType != "":
+			success["content"] = map[string]interface{}{
+				ep.ContentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			}
+		}
+		op["responses"] = map[string]interface{}{
+			strconv.Itoa(status): success,
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": errorSchema},
+				},
+			},
+		}
+
+		if ep.Role != "" {
+			op["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+			op["x-required-role"] = ep.Role
+		}
+
+		if paths[ep.Path] == nil {
+			paths[ep.Path] = map[string]interface{}{}
+		}
+		paths[ep.Path][strings.ToLower(ep.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "CymConductor Orchestrator API",
+			"version":     version,
+			"description": "Agent protocol and operator API of the CymConductor orchestrator. Operator endpoints take an API key as a bearer token; requests can be scoped to a lab with ?lab_id= or the X-Lab-ID header.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// pathParams returns the {name} parameters in a path.
+func pathParams(path string) []string {
+	var names []string
+	for _, m := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// operationID derives an operation ID from the method and path, e.g.
+// "get_api_agents_agentID_jobs_next".
+func operationID(ep Endpoint) string {
+	return strings.ToLower(ep.Method) + strings.NewReplacer("/", "_", "{", "", "}", "", ".", "_").Replace(ep.Path)
+}
+
+// ============================================================
+// JSON Schema Generation
+// ============================================================
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaGen converts Go types to OpenAPI schemas, collecting named structs
+// under components/schemas.
+type schemaGen struct {
+	schemas map[string]interface{}
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s := map[string]interface{}{"type": "integer"}
+		if t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64 {
+			s["format"] = "int64"
+		}
+		return s
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		s := map[string]interface{}{"type": "object"}
+		if t.Elem().Kind() == reflect.Interface {
+			s["additionalProperties"] = true
+		} else {
+			s["additionalProperties"] = g.schema(t.Elem())
+		}
+		return s
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			g.schemas[t.Name()] = nil // placeholder for recursive types
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	// interface{} and anything else: any value
+	return map[string]interface{}{}
+}
+
+// structSchema builds an object schema from a struct's JSON encoding: fields
+// are named by their json tag (or Go name), embedded structs are flattened
+// and fields without omitempty are required.
+func (g *schemaGen) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+
+			properties[name] = g.schema(f.Type)
+			if !strings.Contains(","+opts+",", ",omitempty,") && f.Type.Kind() != reflect.Ptr {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		s["required"] = required
+	}
+	return s
+}
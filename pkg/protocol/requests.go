@@ -2,7 +2,10 @@
 // These types are shared between the orchestrator (server) and agent (client).
 package protocol
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // ============================================================
 // Agent Registration
@@ -27,6 +30,30 @@ type RegisterAgentRequest struct {
 
 	// Agent software version
 	Version string `json:"version" validate:"required"`
+
+	// Lab the agent belongs to (defaults to "default")
+	LabID string `json:"lab_id,omitempty" validate:"omitempty,max=64"`
+
+	// Actions and backends the agent can run (omitted by older agents)
+	Capabilities *AgentCapabilities `json:"capabilities,omitempty"`
+}
+
+// AgentCapabilities describes what an agent can execute. The orchestrator
+// only targets an agent with steps whose action it advertises.
+type AgentCapabilities struct {
+	// Action types the agent can run
+	Actions []string `json:"actions"`
+
+	// Registered action types that cannot run on this host, with the reason
+	// (e.g. {"observe_user_state": "only supported on Windows"})
+	Unavailable map[string]string `json:"unavailable,omitempty"`
+
+	// Backends available per action (e.g. {"email_receive": ["imap"]})
+	Backends map[string][]string `json:"backends,omitempty"`
+
+	// Operating system and architecture (GOOS/GOARCH)
+	OS   string `json:"os,omitempty"`
+	Arch string `json:"arch,omitempty"`
 }
 
 // ============================================================
@@ -133,6 +160,9 @@ type CreateScenarioRequest struct {
 
 	// Or a pre-validated DSL scenario (skips AI planning)
 	Scenario *ScenarioInput `json:"scenario,omitempty"`
+
+	// Optional scoring engine run to forward the scenario's events to
+	ScoringRunID string `json:"scoring_run_id,omitempty" validate:"omitempty,max=255"`
 }
 
 // IntentInput is the user-provided intent for AI planning.
@@ -162,12 +192,25 @@ type ScenarioInput struct {
 	Definition string `json:"definition" validate:"required"`
 }
 
+// SetScoringRunRequest attaches a scoring engine run to a scenario. Give
+// either a run ID or create.
+type SetScoringRunRequest struct {
+	// ID of an existing scoring engine run
+	RunID string `json:"run_id,omitempty" validate:"omitempty,max=255"`
+
+	// Have the orchestrator create the run on the scoring engine
+	Create bool `json:"create,omitempty"`
+}
+
 // ============================================================
 // Impersonation User Management
 // ============================================================
 
 // CreateImpersonationUserRequest is used to create a new impersonation user.
 type CreateImpersonationUserRequest struct {
+	// Lab the user belongs to (optional, defaults to the request's lab scope)
+	LabID string `json:"lab_id,omitempty"`
+
 	// Full username (DOMAIN\user)
 	Username string `json:"username" validate:"required"`
 
@@ -227,3 +270,160 @@ type WorkHoursInput struct {
 type BulkCreateImpersonationUsersRequest struct {
 	Users []CreateImpersonationUserRequest `json:"users" validate:"required,min=1"`
 }
+
+// ============================================================
+// Lab Management
+// ============================================================
+
+// CreateLabRequest is used to create a lab.
+type CreateLabRequest struct {
+	// Lab ID slug (lowercase letters, digits and dashes)
+	ID string `json:"id" validate:"required,max=64"`
+
+	// Human-readable lab name
+	Name string `json:"name" validate:"required"`
+
+	// Optional description
+	Description string `json:"description,omitempty"`
+
+	// CIDRs that agent IPs and scenario targets must fall within (optional)
+	AllowedNetworks []string `json:"allowed_networks,omitempty" validate:"omitempty,dive,cidr"`
+
+	// Webhook target for this lab's events (optional, overrides the messenger URL)
+	WebhookURL string `json:"webhook_url,omitempty" validate:"omitempty,url"`
+}
+
+// UpdateLabRequest is used to update a lab.
+type UpdateLabRequest struct {
+	Name            *string  `json:"name,omitempty"`
+	Description     *string  `json:"description,omitempty"`
+	AllowedNetworks []string `json:"allowed_networks,omitempty"`
+	WebhookURL      *string  `json:"webhook_url,omitempty"`
+}
+
+// ============================================================
+// API Key Management
+// ============================================================
+
+// CreateAPIKeyRequest is used to create an operator API key.
+type CreateAPIKeyRequest struct {
+	// Human-readable key name (recorded as the audit actor)
+	Name string `json:"name" validate:"required"`
+
+	// Role granted to the key: viewer, operator, or admin
+	Role string `json:"role" validate:"required,oneof=viewer operator admin"`
+
+	// Optional expiry time
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ============================================================
+// Webhook Subscriptions
+// ============================================================
+
+// CreateWebhookRequest is used to create a webhook subscription.
+type CreateWebhookRequest struct {
+	// Unique subscription name (e.g., "tickets")
+	Name string `json:"name" validate:"required,max=64"`
+
+	// Endpoint events are POSTed to
+	URL string `json:"url" validate:"required,url"`
+
+	// Signing secret (optional, generated if omitted)
+	Secret string `json:"secret,omitempty" validate:"omitempty,min=16"`
+
+	// Event types or categories to send, e.g. "job.completed" or "scenario" (optional, defaults to all)
+	EventTypes []string `json:"event_types,omitempty"`
+
+	// Only send events from this lab or for this scenario (optional)
+	LabID      string `json:"lab_id,omitempty"`
+	ScenarioID string `json:"scenario_id,omitempty"`
+
+	// Whether events are sent (optional, defaults to true)
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Retry policy: retries after the first attempt, the first retry delay
+	// (doubled for each further retry) and the per-attempt timeout
+	MaxRetries     *int `json:"max_retries,omitempty" validate:"omitempty,min=0,max=10"`
+	RetryBackoffMs *int `json:"retry_backoff_ms,omitempty" validate:"omitempty,min=100,max=300000"`
+	TimeoutMs      *int `json:"timeout_ms,omitempty" validate:"omitempty,min=100,max=60000"`
+}
+
+// UpdateWebhookRequest is used to update a webhook subscription. Omitted
+// fields are left unchanged.
+type UpdateWebhookRequest struct {
+	Name           *string   `json:"name,omitempty" validate:"omitempty,max=64"`
+	URL            *string   `json:"url,omitempty" validate:"omitempty,url"`
+	Secret         *string   `json:"secret,omitempty" validate:"omitempty,min=16"`
+	EventTypes     *[]string `json:"event_types,omitempty"`
+	LabID          *string   `json:"lab_id,omitempty"`
+	ScenarioID     *string   `json:"scenario_id,omitempty"`
+	Enabled        *bool     `json:"enabled,omitempty"`
+	MaxRetries     *int      `json:"max_retries,omitempty" validate:"omitempty,min=0,max=10"`
+	RetryBackoffMs *int      `json:"retry_backoff_ms,omitempty" validate:"omitempty,min=100,max=300000"`
+	TimeoutMs      *int      `json:"timeout_ms,omitempty" validate:"omitempty,min=100,max=60000"`
+
+	// Replace the secret with a newly generated one (returned in the response)
+	RotateSecret bool `json:"rotate_secret,omitempty"`
+}
+
+// ============================================================
+// Outbox
+// ============================================================
+
+// ReplayOutboxRequest is used to replay every dead-lettered outbox entry.
+type ReplayOutboxRequest struct {
+	// Only replay entries for this target: scoring or messenger (optional)
+	Target string `json:"target,omitempty" validate:"omitempty,oneof=scoring messenger"`
+}
+
+// ============================================================
+// State Export/Import
+// ============================================================
+
+// StateExportFormat identifies a state export document.
+const StateExportFormat = "cymconductor-state"
+
+// StateExportVersion is the version of the export document layout. It changes
+// only when the document itself changes, not with the database schema.
+const StateExportVersion = 1
+
+// StateExport is a portable copy of the state worth carrying across range
+// rebuilds. It is returned by GET /api/admin/export and accepted by
+// POST /api/admin/import. Entries use the same shapes as the create
+// requests, so an import goes through the same paths as the API.
+type StateExport struct {
+	// Always StateExportFormat
+	Format string `json:"format"`
+
+	// Document layout version (StateExportVersion)
+	Version int `json:"version"`
+
+	// When and by which orchestrator version the export was taken
+	ExportedAt          time.Time `json:"exported_at"`
+	OrchestratorVersion string    `json:"orchestrator_version,omitempty"`
+
+	Labs      []CreateLabRequest               `json:"labs"`
+	Users     []CreateImpersonationUserRequest `json:"users"`
+	Scenarios []ExportedScenario               `json:"scenarios"`
+}
+
+// ExportedScenario is a validated scenario in a state export. Imported
+// scenarios are recreated in the "validated" status, ready to be compiled.
+type ExportedScenario struct {
+	// Scenario ID (kept so repeated imports do not duplicate scenarios)
+	ID string `json:"id"`
+
+	LabID       string `json:"lab_id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	// Where the scenario came from ("api" or "file")
+	Source string `json:"source,omitempty"`
+
+	// Original intent and the validated DSL, as JSON documents
+	Intent       json.RawMessage `json:"intent"`
+	ValidatedDSL json.RawMessage `json:"validated_dsl"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -1,7 +1,10 @@
 // Package protocol defines the HTTP API request and response types.
 package protocol
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // ============================================================
 // Agent Registration Response
@@ -20,6 +23,12 @@ type RegisterAgentResponse struct {
 
 	// Agent configuration from orchestrator
 	Config *AgentConfig `json:"config,omitempty"`
+
+	// Job delivery transports the orchestrator supports, in order of preference
+	Transports []string `json:"transports,omitempty"`
+
+	// Longest wait the orchestrator honours for long-poll jobs/next requests
+	LongPollMaxMs int `json:"long_poll_max_ms,omitempty"`
 }
 
 // AgentConfig contains configuration sent to agents.
@@ -52,6 +61,9 @@ type HeartbeatResponse struct {
 
 // AgentCommand is a directive from orchestrator to agent.
 type AgentCommand struct {
+	// Command ID, echoed back in the agent's acknowledgment
+	ID string `json:"id,omitempty"`
+
 	// Command type: shutdown, reconfigure, cancel_job
 	Type string `json:"type"`
 
@@ -95,9 +107,22 @@ type JobAssignment struct {
 	// Maximum execution time in seconds (optional)
 	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
 
-	// Scenario context (optional, for logging)
+	// Scenario context (optional, for logging and artifact watermarks)
 	ScenarioID   string `json:"scenario_id,omitempty"`
 	ScenarioName string `json:"scenario_name,omitempty"`
+	StepID       string `json:"step_id,omitempty"`
+
+	// User to run the action as (optional, impersonation)
+	RunAs *RunAsConfig `json:"run_as,omitempty"`
+}
+
+// RunAsConfig specifies user impersonation for a job.
+type RunAsConfig struct {
+	// Full username (DOMAIN\user)
+	User string `json:"user"`
+
+	// Logon type: interactive, network, batch
+	LogonType string `json:"logon_type,omitempty"`
 }
 
 // ============================================================
@@ -144,6 +169,26 @@ type CreateScenarioResponse struct {
 	EstimatedCompletionAt *time.Time `json:"estimated_completion_at,omitempty"`
 }
 
+// ScenarioRecord is a scenario as returned by GET /api/scenarios and
+// GET /api/scenarios/{id}. The orchestrator encodes its stored record
+// directly, so the JSON keys are the Go field names.
+type ScenarioRecord struct {
+	ID           string
+	LabID        string
+	Name         string
+	Description  *string
+	Intent       string // JSON
+	Source       string
+	Status       string  // pending, planning, validated, compiled, active, completed, failed
+	AIOutput     *string // JSON
+	ValidatedDSL *string // JSON
+	ErrorMessage *string
+	ScoringRunID *string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	CompletedAt  *time.Time
+}
+
 // ScenarioStatusResponse provides status information for a scenario.
 type ScenarioStatusResponse struct {
 	// Scenario ID
@@ -178,6 +223,9 @@ type ScenarioProgress struct {
 	// Jobs that failed
 	FailedJobs int `json:"failed_jobs"`
 
+	// Jobs that were cancelled
+	CancelledJobs int `json:"cancelled_jobs"`
+
 	// Jobs currently running
 	RunningJobs int `json:"running_jobs"`
 
@@ -188,6 +236,293 @@ type ScenarioProgress struct {
 	PercentComplete float64 `json:"percent_complete"`
 }
 
+// ScorecardResponse grades a scenario's objectives against the latest
+// results of their observation steps.
+type ScorecardResponse struct {
+	ScenarioID string `json:"scenario_id"`
+	Name       string `json:"name"`
+
+	// Scenario status
+	Status string `json:"status"`
+
+	// Total weight of the passed objectives, out of MaxScore
+	Score    int     `json:"score"`
+	MaxScore int     `json:"max_score"`
+	Percent  float64 `json:"percent"`
+
+	// Objective counts by status
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Pending int `json:"pending"`
+
+	Objectives []ObjectiveScore `json:"objectives"`
+
+	// When the scorecard was computed
+	EvaluatedAt time.Time `json:"evaluated_at"`
+}
+
+// ObjectiveScore is the grade of one scenario objective.
+type ObjectiveScore struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	// Status: pending, passed or failed
+	Status string `json:"status"`
+
+	// Weight, and the score earned (the weight once passed, otherwise 0)
+	Weight int `json:"weight"`
+	Score  int `json:"score"`
+
+	// Which checks must pass: all or any
+	Require string `json:"require"`
+
+	// Assertions applied to results (empty when the step defaults are used)
+	Assert []string `json:"assert,omitempty"`
+
+	// When the objective must pass by, and when it did
+	Deadline *time.Time `json:"deadline,omitempty"`
+	PassedAt *time.Time `json:"passed_at,omitempty"`
+
+	// Latest result of each job the objective depends on
+	Checks []ObjectiveCheck `json:"checks"`
+}
+
+// ObjectiveCheck is the latest result of one job an objective depends on.
+type ObjectiveCheck struct {
+	JobID     string     `json:"job_id"`
+	StepID    string     `json:"step_id"`
+	AgentID   string     `json:"agent_id"`
+	JobStatus string     `json:"job_status"`
+	Passed    bool       `json:"passed"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// TimelineResponse is the ground-truth record of the activity a scenario
+// generated: every job that ran, in the order it started.
+type TimelineResponse struct {
+	ScenarioID string          `json:"scenario_id"`
+	Name       string          `json:"name"`
+	Entries    []TimelineEntry `json:"entries"`
+}
+
+// TimelineEntry describes one executed job.
+type TimelineEntry struct {
+	JobID      string `json:"job_id"`
+	ScenarioID string `json:"scenario_id"`
+	StepID     string `json:"step_id,omitempty"`
+	LabID      string `json:"lab_id"`
+
+	// Host the job ran on
+	AgentID   string `json:"agent_id"`
+	Hostname  string `json:"hostname,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+	OS        string `json:"os,omitempty"`
+
+	// Impersonated user (DOMAIN\user) and logon type, if any
+	RunAs     string `json:"run_as,omitempty"`
+	LogonType string `json:"logon_type,omitempty"`
+
+	ActionType string `json:"action_type"`
+
+	// Key parameters of the action, such as URLs, file paths, process names
+	// and recipients (credentials are never included)
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+
+	// Final status: completed or failed
+	Status      string     `json:"status"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	DurationMs  int64      `json:"duration_ms,omitempty"`
+
+	// Numeric result fields reported by the agent (e.g. pages_loaded,
+	// files_created, emails_sent)
+	Counters map[string]float64 `json:"counters,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// ScenarioReport is the after-action report of a scenario: what was asked
+// for, what was planned and what actually ran. Values of credential
+// parameters in the embedded documents are redacted.
+type ScenarioReport struct {
+	ScenarioID   string     `json:"scenario_id"`
+	LabID        string     `json:"lab_id"`
+	Name         string     `json:"name"`
+	Description  string     `json:"description,omitempty"`
+	Source       string     `json:"source"`
+	Status       string     `json:"status"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	GeneratedAt  time.Time  `json:"generated_at"`
+
+	// Intent submitted, planner output and the DSL that was validated
+	Intent       json.RawMessage `json:"intent,omitempty"`
+	AIOutput     json.RawMessage `json:"ai_output,omitempty"`
+	ValidatedDSL json.RawMessage `json:"validated_dsl,omitempty"`
+
+	// Each planned step with how its jobs went
+	Steps []ReportStep `json:"steps"`
+
+	// Jobs per agent and per impersonated user
+	Agents []ReportAgentSummary `json:"agents"`
+	Users  []ReportUserSummary  `json:"users"`
+
+	// Jobs that failed for good, in the order they started
+	Failures []TimelineEntry `json:"failures"`
+
+	// Objective grades (empty if the scenario has no objectives)
+	Objectives ScorecardResponse `json:"objectives"`
+
+	Timing ReportTiming `json:"timing"`
+}
+
+// ReportStep compares a planned step with its execution. Jobs that belong to
+// no planned step are reported under a step with an empty ID.
+type ReportStep struct {
+	StepID     string                 `json:"step_id"`
+	Order      int                    `json:"order"`
+	ActionType string                 `json:"action_type"`
+	Target     string                 `json:"target,omitempty"`
+	RunAs      string                 `json:"run_as,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+
+	// Planned delays, in milliseconds
+	DelayBeforeMs int `json:"delay_before_ms,omitempty"`
+	DelayAfterMs  int `json:"delay_after_ms,omitempty"`
+
+	// Jobs created for the step and their outcomes
+	Jobs      int `json:"jobs"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	Other     int `json:"other"`
+
+	// Hosts the step ran on
+	Hosts []string `json:"hosts,omitempty"`
+
+	FirstStartedAt  *time.Time `json:"first_started_at,omitempty"`
+	LastCompletedAt *time.Time `json:"last_completed_at,omitempty"`
+	AvgDurationMs   int64      `json:"avg_duration_ms"`
+}
+
+// ReportAgentSummary counts the jobs an agent ran.
+type ReportAgentSummary struct {
+	AgentID         string `json:"agent_id"`
+	Hostname        string `json:"hostname,omitempty"`
+	Jobs            int    `json:"jobs"`
+	Completed       int    `json:"completed"`
+	Failed          int    `json:"failed"`
+	Other           int    `json:"other"`
+	TotalDurationMs int64  `json:"total_duration_ms"`
+}
+
+// ReportUserSummary counts the jobs run as an impersonated user. Jobs run as
+// the agent's own account are reported with an empty user.
+type ReportUserSummary struct {
+	RunAs     string   `json:"run_as"`
+	Jobs      int      `json:"jobs"`
+	Completed int      `json:"completed"`
+	Failed    int      `json:"failed"`
+	Other     int      `json:"other"`
+	Hosts     []string `json:"hosts,omitempty"`
+	Actions   []string `json:"actions,omitempty"`
+}
+
+// ReportTiming summarizes when the scenario's jobs ran. Durations cover the
+// jobs that ran (completed or failed); queue time is from job creation to
+// the agent starting it.
+type ReportTiming struct {
+	FirstStartedAt  *time.Time `json:"first_started_at,omitempty"`
+	LastCompletedAt *time.Time `json:"last_completed_at,omitempty"`
+	WallClockMs     int64      `json:"wall_clock_ms"`
+
+	Jobs      int `json:"jobs"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	Other     int `json:"other"`
+
+	MinDurationMs int64 `json:"min_duration_ms"`
+	AvgDurationMs int64 `json:"avg_duration_ms"`
+	P95DurationMs int64 `json:"p95_duration_ms"`
+	MaxDurationMs int64 `json:"max_duration_ms"`
+	AvgQueueMs    int64 `json:"avg_queue_ms"`
+}
+
+// ScoringRunResponse is returned after attaching a scoring run to a scenario.
+type ScoringRunResponse struct {
+	ScenarioID   string `json:"scenario_id"`
+	ScoringRunID string `json:"scoring_run_id"`
+
+	// Whether the orchestrator created the run on the scoring engine
+	Created bool `json:"created"`
+}
+
+// ============================================================
+// Job Queries
+// ============================================================
+
+// JobResponse describes a job.
+type JobResponse struct {
+	// Job ID
+	ID string `json:"id"`
+
+	// Lab, scenario and step the job belongs to
+	LabID          string `json:"lab_id"`
+	ScenarioID     string `json:"scenario_id,omitempty"`
+	ScenarioStepID string `json:"scenario_step_id,omitempty"`
+
+	// Agent the job is assigned to
+	AgentID string `json:"agent_id"`
+
+	// Action type and parameters (parameters are omitted from listings)
+	ActionType string                 `json:"action_type"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+
+	// User the action runs as, if impersonated
+	RunAsUser      string `json:"run_as_user,omitempty"`
+	RunAsLogonType string `json:"run_as_logon_type,omitempty"`
+
+	// Current status and priority
+	Status   string `json:"status"`
+	Priority int    `json:"priority"`
+
+	// Result reported by the agent (omitted from listings)
+	Result map[string]interface{} `json:"result,omitempty"`
+
+	// Error message if failed
+	ErrorMessage string `json:"error_message,omitempty"`
+
+	// Retry accounting
+	RetryCount int `json:"retry_count"`
+	MaxRetries int `json:"max_retries"`
+
+	// Timestamps
+	ScheduledAt time.Time  `json:"scheduled_at"`
+	AssignedAt  *time.Time `json:"assigned_at,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// ListJobsResponse is one page of a job listing.
+type ListJobsResponse struct {
+	Jobs []JobResponse `json:"jobs"`
+
+	// Pass as ?cursor= to fetch the next page; empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// CreateTestJobResponse is returned after creating a debug test job.
+type CreateTestJobResponse struct {
+	JobID      string `json:"job_id"`
+	AgentID    string `json:"agent_id"`
+	ActionType string `json:"action_type"`
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+}
+
 // ============================================================
 // Error Response
 // ============================================================
@@ -256,6 +591,9 @@ type AgentInfo struct {
 	// Agent ID
 	AgentID string `json:"agent_id"`
 
+	// Lab the agent belongs to
+	LabID string `json:"lab_id"`
+
 	// Lab host ID
 	LabHostID string `json:"lab_host_id"`
 
@@ -280,6 +618,9 @@ type AgentInfo struct {
 	// Registration time
 	RegisteredAt time.Time `json:"registered_at"`
 
+	// Reported capabilities (omitted for agents that did not report them)
+	Capabilities *AgentCapabilities `json:"capabilities,omitempty"`
+
 	// Current job count
 	CurrentJobCount int `json:"current_job_count,omitempty"`
 }
@@ -293,6 +634,9 @@ type ImpersonationUserResponse struct {
 	// User ID
 	ID string `json:"id"`
 
+	// Lab the user belongs to
+	LabID string `json:"lab_id"`
+
 	// Full username (DOMAIN\user)
 	Username string `json:"username"`
 
@@ -357,3 +701,285 @@ type BulkUserError struct {
 	Username string `json:"username"`
 	Error    string `json:"error"`
 }
+
+// ============================================================
+// Lab Management
+// ============================================================
+
+// LabResponse describes a lab.
+type LabResponse struct {
+	// Lab ID
+	ID string `json:"id"`
+
+	// Lab name
+	Name string `json:"name"`
+
+	// Description
+	Description string `json:"description,omitempty"`
+
+	// CIDRs agents and scenario targets must fall within
+	AllowedNetworks []string `json:"allowed_networks"`
+
+	// Webhook target for this lab's events
+	WebhookURL string `json:"webhook_url,omitempty"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ListLabsResponse is returned when listing labs.
+type ListLabsResponse struct {
+	Labs  []LabResponse `json:"labs"`
+	Total int           `json:"total"`
+}
+
+// ============================================================
+// API Key Management
+// ============================================================
+
+// APIKeyResponse describes an API key. The key itself is never returned.
+type APIKeyResponse struct {
+	// Key ID
+	ID string `json:"id"`
+
+	// Key name
+	Name string `json:"name"`
+
+	// Leading characters of the key for identification
+	KeyPrefix string `json:"key_prefix"`
+
+	// Granted role
+	Role string `json:"role"`
+
+	// Actor that created the key
+	CreatedBy string `json:"created_by,omitempty"`
+
+	// Timestamps
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateAPIKeyResponse is returned after creating an API key.
+type CreateAPIKeyResponse struct {
+	APIKeyResponse
+
+	// Plaintext key; only returned once at creation time
+	Key string `json:"key"`
+}
+
+// ListAPIKeysResponse is returned when listing API keys.
+type ListAPIKeysResponse struct {
+	Keys  []APIKeyResponse `json:"keys"`
+	Total int              `json:"total"`
+}
+
+// WhoAmIResponse describes the caller of the request.
+type WhoAmIResponse struct {
+	// Actor recorded in the audit log
+	Actor string `json:"actor"`
+
+	// Caller's role
+	Role string `json:"role"`
+
+	// ID of the API key used, if any
+	KeyID string `json:"key_id,omitempty"`
+}
+
+// ============================================================
+// Webhook Subscriptions
+// ============================================================
+
+// WebhookResponse describes a webhook subscription. The secret is only
+// returned when it is set: at creation and when it is rotated or replaced.
+type WebhookResponse struct {
+	// Subscription ID
+	ID string `json:"id"`
+
+	// Subscription name
+	Name string `json:"name"`
+
+	// Endpoint events are POSTed to
+	URL string `json:"url"`
+
+	// Signing secret; only returned when it is set
+	Secret string `json:"secret,omitempty"`
+
+	// Filters (empty matches everything)
+	EventTypes []string `json:"event_types"`
+	LabID      string   `json:"lab_id,omitempty"`
+	ScenarioID string   `json:"scenario_id,omitempty"`
+
+	// Whether events are sent
+	Enabled bool `json:"enabled"`
+
+	// Retry policy
+	MaxRetries     int   `json:"max_retries"`
+	RetryBackoffMs int64 `json:"retry_backoff_ms"`
+	TimeoutMs      int64 `json:"timeout_ms"`
+
+	// Actor that created the subscription
+	CreatedBy string `json:"created_by,omitempty"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ListWebhooksResponse is returned when listing webhook subscriptions.
+type ListWebhooksResponse struct {
+	Webhooks []WebhookResponse `json:"webhooks"`
+	Total    int               `json:"total"`
+}
+
+// WebhookDeliveryResponse describes one event sent to a subscription.
+type WebhookDeliveryResponse struct {
+	// Delivery ID (sent in the X-CymConductor-Delivery header)
+	ID string `json:"id"`
+
+	// Subscription the event was sent to
+	SubscriptionID string `json:"subscription_id"`
+
+	// Event bus ID and type (the ID is 0 for test events)
+	EventID   uint64 `json:"event_id"`
+	EventType string `json:"event_type"`
+
+	// Sent by the test action
+	Test bool `json:"test,omitempty"`
+
+	// pending, succeeded or failed
+	Status string `json:"status"`
+
+	// Attempts made so far
+	Attempts int `json:"attempts"`
+
+	// HTTP status and error of the last attempt
+	ResponseStatus *int   `json:"response_status,omitempty"`
+	Error          string `json:"error,omitempty"`
+
+	// Time from the first attempt to completion
+	DurationMs *int64 `json:"duration_ms,omitempty"`
+
+	// Timestamps
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// ListWebhookDeliveriesResponse is a subscription's recent deliveries, newest first.
+type ListWebhookDeliveriesResponse struct {
+	Deliveries []WebhookDeliveryResponse `json:"deliveries"`
+	Total      int                       `json:"total"`
+}
+
+// ============================================================
+// Outbox
+// ============================================================
+
+// OutboxEntryResponse describes a scoring or messenger event in the outbox.
+type OutboxEntryResponse struct {
+	// Entry ID
+	ID string `json:"id"`
+
+	// Event ID sent to the target; stable across attempts for deduplication
+	EventID string `json:"event_id"`
+
+	// scoring or messenger
+	Target string `json:"target"`
+
+	// Scoring run ID or lab ID the event is routed by
+	Destination string `json:"destination,omitempty"`
+
+	// Event type
+	EventType string `json:"event_type"`
+
+	// pending, delivered or dead
+	Status string `json:"status"`
+
+	// Attempts made so far
+	Attempts int `json:"attempts"`
+
+	// When a pending entry is next attempted
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+
+	// Error of the last failed attempt
+	LastError string `json:"last_error,omitempty"`
+
+	// Request body sent to the target; only returned for a single entry
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	// Timestamps
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// ListOutboxResponse is returned when listing outbox entries, newest first.
+type ListOutboxResponse struct {
+	Entries []OutboxEntryResponse `json:"entries"`
+	Total   int                   `json:"total"`
+
+	// Entries in the whole outbox by status
+	Counts map[string]int `json:"counts"`
+}
+
+// ReplayOutboxResponse is returned after replaying dead-lettered entries.
+type ReplayOutboxResponse struct {
+	Replayed int `json:"replayed"`
+}
+
+// ============================================================
+// Audit Log
+// ============================================================
+
+// AuditEntryResponse describes an audit log entry.
+type AuditEntryResponse struct {
+	// Entry ID (increasing)
+	ID int64 `json:"id"`
+
+	// Entity changed and how
+	EntityType string `json:"entity_type"`
+	EntityID   string `json:"entity_id"`
+	Action     string `json:"action"`
+
+	// Who made the change ("key:<name>", "agent:<id>", "system" or "anonymous")
+	Actor string `json:"actor"`
+
+	// State before and after the change, and additional context
+	OldValue map[string]interface{} `json:"old_value,omitempty"`
+	NewValue map[string]interface{} `json:"new_value,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+
+	// When the change was recorded
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListAuditResponse is one page of audit entries, newest first.
+type ListAuditResponse struct {
+	Entries []AuditEntryResponse `json:"entries"`
+
+	// Pass as ?cursor= to fetch older entries; empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ============================================================
+// State Export/Import
+// ============================================================
+
+// ImportCounts tallies what an import did with one kind of entry.
+type ImportCounts struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+// ImportStateResponse is returned after importing a state export.
+type ImportStateResponse struct {
+	Labs      ImportCounts `json:"labs"`
+	Users     ImportCounts `json:"users"`
+	Scenarios ImportCounts `json:"scenarios"`
+
+	// Entries that could not be imported, with the reason
+	Errors []string `json:"errors,omitempty"`
+}